@@ -0,0 +1,188 @@
+// Package config loads the configuration of the server from the environment
+// and validates it as a whole at startup.
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db"
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/middleware"
+	"github.com/bft-labs/cometbft-analyzer-backend/retention"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+)
+
+// Defaults of the settings that are not owned by another package
+const (
+	DefaultMongoURI              = "mongodb://localhost:27017"
+	DefaultPort                  = "8080"
+	DefaultUploadDir             = "uploads"
+	DefaultETLBinary             = "cometbft-log-etl"
+	DefaultProcessingConcurrency = 2
+	DefaultShutdownGracePeriod   = 30 * time.Second
+
+	// maxProcessingConcurrency bounds PROCESSING_CONCURRENCY, each worker runs an ETL process
+	maxProcessingConcurrency = 256
+)
+
+// Config is the configuration of the server
+type Config struct {
+	MongoURI string
+	Mongo    db.ConnectOptions
+	Port     string
+	// UploadDir stages uploads and, with the local storage backend, stores the log files
+	UploadDir             string
+	ETLBinary             string // Name on PATH or path of the ETL binary
+	ProcessingConcurrency int
+	ShutdownGracePeriod   time.Duration
+	RetentionInterval     time.Duration // 0 disables the periodic retention runs
+	StorageBackend        string        // storage.BackendLocal or storage.BackendS3
+	S3                    storage.S3Config
+	UploadLimits          logupload.Limits
+	Fetch                 logupload.FetchConfig
+	DefaultUserQuotaBytes int64 // 0 = unlimited
+	CORS                  middleware.CORSConfig
+	RateLimits            map[string]middleware.RateLimitPolicy
+	TrustedProxies        []string                     // IPs or CIDRs whose X-Forwarded-For is trusted
+	APITokens             map[string]middleware.Caller // Bearer tokens of the API callers
+}
+
+// Error lists every invalid setting found by Load
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load reads the configuration from the environment, falling back to the
+// defaults, and validates it. All problems are collected into a single *Error
+// rather than stopping at the first.
+func Load() (Config, error) {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	config := Config{
+		MongoURI:              envOr("MONGODB_URI", DefaultMongoURI),
+		Port:                  envOr("PORT", DefaultPort),
+		UploadDir:             envOr("UPLOAD_DIR", DefaultUploadDir),
+		ETLBinary:             envOr("ETL_BINARY", DefaultETLBinary),
+		ProcessingConcurrency: DefaultProcessingConcurrency,
+		ShutdownGracePeriod:   DefaultShutdownGracePeriod,
+		RetentionInterval:     retention.DefaultInterval,
+		StorageBackend:        envOr("STORAGE_BACKEND", storage.BackendLocal),
+		S3:                    storage.S3ConfigFromEnv(),
+	}
+
+	var err error
+	config.Mongo, err = db.ConnectOptionsFromEnv()
+	check(err)
+	config.UploadLimits, err = logupload.LimitsFromEnv()
+	check(err)
+	config.Fetch, err = logupload.FetchConfigFromEnv()
+	check(err)
+	config.CORS, err = middleware.CORSConfigFromEnv()
+	check(err)
+	config.RateLimits, err = middleware.RateLimitPoliciesFromEnv()
+	check(err)
+	config.APITokens, err = middleware.APITokensFromEnv()
+	check(err)
+
+	if value := os.Getenv("PROCESSING_CONCURRENCY"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxProcessingConcurrency {
+			problems = append(problems, fmt.Sprintf("invalid PROCESSING_CONCURRENCY: %q (1 to %d)", value, maxProcessingConcurrency))
+		}
+		config.ProcessingConcurrency = parsed
+	}
+	for name, target := range map[string]*time.Duration{
+		"SHUTDOWN_GRACE_PERIOD": &config.ShutdownGracePeriod,
+		"RETENTION_INTERVAL":    &config.RetentionInterval,
+	} {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 {
+				problems = append(problems, fmt.Sprintf("invalid %s: %q", name, value))
+			}
+			*target = parsed
+		}
+	}
+	if value := os.Getenv("DEFAULT_USER_QUOTA_BYTES"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			problems = append(problems, fmt.Sprintf("invalid DEFAULT_USER_QUOTA_BYTES: %q", value))
+		}
+		config.DefaultUserQuotaBytes = parsed
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			config.TrustedProxies = append(config.TrustedProxies, proxy)
+		}
+	}
+
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return config, &Error{Problems: problems}
+	}
+	return config, nil
+}
+
+// validate checks the settings that are not checked while parsing
+func (c Config) validate() []string {
+	var problems []string
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("invalid PORT: %q", c.Port))
+	}
+	if c.UploadDir == "" {
+		problems = append(problems, "UPLOAD_DIR must not be empty")
+	} else if err := utils.CheckWritableDir(c.UploadDir); err != nil {
+		problems = append(problems, fmt.Sprintf("UPLOAD_DIR %q is not writable: %v", c.UploadDir, err))
+	}
+	if strings.TrimSpace(c.ETLBinary) == "" {
+		problems = append(problems, "ETL_BINARY must not be empty")
+	}
+
+	switch c.StorageBackend {
+	case storage.BackendLocal:
+	case storage.BackendS3:
+		if err := c.S3.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("STORAGE_BACKEND=s3: %v", err))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown STORAGE_BACKEND %q", c.StorageBackend))
+	}
+
+	if c.UploadLimits.MaxFileBytes > c.UploadLimits.MaxRequestBytes {
+		problems = append(problems, fmt.Sprintf("MAX_UPLOAD_BYTES %d exceeds MAX_UPLOAD_REQUEST_BYTES %d",
+			c.UploadLimits.MaxFileBytes, c.UploadLimits.MaxRequestBytes))
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid TRUSTED_PROXIES entry %q", proxy))
+			}
+		}
+	}
+	return problems
+}
+
+// envOr returns the environment variable name, or fallback when it is unset or empty
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv lists the variables read by Load, cleared so the host environment does not leak in
+var configEnv = []string{
+	"MONGODB_URI", "MONGODB_MAX_POOL_SIZE", "MONGODB_MIN_POOL_SIZE", "MONGODB_CONNECT_TIMEOUT",
+	"MONGODB_SERVER_SELECTION_TIMEOUT", "MONGODB_CONNECT_RETRY_WINDOW", "MONGODB_READ_PREFERENCE",
+	"PORT", "UPLOAD_DIR", "ETL_BINARY", "PROCESSING_CONCURRENCY", "SHUTDOWN_GRACE_PERIOD", "RETENTION_INTERVAL",
+	"STORAGE_BACKEND", "S3_BUCKET", "S3_PREFIX", "S3_REGION", "S3_ENDPOINT", "AWS_REGION",
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	"MAX_UPLOAD_BYTES", "MAX_UPLOAD_REQUEST_BYTES", "MAX_ARCHIVE_ENTRIES", "MAX_ARCHIVE_BYTES",
+	"FETCH_ALLOWED_HOSTS", "FETCH_ALLOWED_SCHEMES", "FETCH_TIMEOUT", "FETCH_CONCURRENCY",
+	"DEFAULT_USER_QUOTA_BYTES", "CORS_ALLOWED_ORIGINS", "ALLOW_ALL_ORIGINS", "RATE_LIMITS", "TRUSTED_PROXIES",
+}
+
+func TestLoad(t *testing.T) {
+	notADir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notADir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		env          map[string]string
+		check        func(t *testing.T, config Config)
+		wantProblems []string // Substrings of the reported problems, in order
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, config Config) {
+				if config.MongoURI != DefaultMongoURI || config.Port != DefaultPort || config.ETLBinary != DefaultETLBinary {
+					t.Errorf("config = %+v, want the defaults", config)
+				}
+				if config.ProcessingConcurrency != DefaultProcessingConcurrency || config.ShutdownGracePeriod != DefaultShutdownGracePeriod {
+					t.Errorf("concurrency %d, grace period %s, want the defaults", config.ProcessingConcurrency, config.ShutdownGracePeriod)
+				}
+				if config.StorageBackend != "local" || config.TrustedProxies != nil {
+					t.Errorf("backend %q, trusted proxies %v, want local and none", config.StorageBackend, config.TrustedProxies)
+				}
+			},
+		},
+		{
+			name: "overrides",
+			env: map[string]string{
+				"PORT":                     "9090",
+				"PROCESSING_CONCURRENCY":   "8",
+				"SHUTDOWN_GRACE_PERIOD":    "1m",
+				"RETENTION_INTERVAL":       "0s",
+				"DEFAULT_USER_QUOTA_BYTES": "1000",
+				"TRUSTED_PROXIES":          " 10.0.0.1, 10.0.0.0/8 ,",
+			},
+			check: func(t *testing.T, config Config) {
+				if config.Port != "9090" || config.ProcessingConcurrency != 8 || config.ShutdownGracePeriod != time.Minute {
+					t.Errorf("port %q, concurrency %d, grace period %s", config.Port, config.ProcessingConcurrency, config.ShutdownGracePeriod)
+				}
+				if config.RetentionInterval != 0 || config.DefaultUserQuotaBytes != 1000 {
+					t.Errorf("retention interval %s, quota %d", config.RetentionInterval, config.DefaultUserQuotaBytes)
+				}
+				if want := []string{"10.0.0.1", "10.0.0.0/8"}; !reflect.DeepEqual(config.TrustedProxies, want) {
+					t.Errorf("trusted proxies = %q, want %q", config.TrustedProxies, want)
+				}
+			},
+		},
+		{
+			name: "every problem is reported",
+			env: map[string]string{
+				"CORS_ALLOWED_ORIGINS":     "https://app.*.com",
+				"RATE_LIMITS":              "bogus=1",
+				"PROCESSING_CONCURRENCY":   "0",
+				"SHUTDOWN_GRACE_PERIOD":    "-1s",
+				"DEFAULT_USER_QUOTA_BYTES": "-5",
+				"PORT":                     "70000",
+				"ETL_BINARY":               "  ",
+				"STORAGE_BACKEND":          "ftp",
+				"TRUSTED_PROXIES":          "10.0.0.1,proxy.local",
+			},
+			wantProblems: []string{
+				"CORS_ALLOWED_ORIGINS",
+				"RATE_LIMITS",
+				"PROCESSING_CONCURRENCY",
+				"SHUTDOWN_GRACE_PERIOD",
+				"DEFAULT_USER_QUOTA_BYTES",
+				"PORT",
+				"ETL_BINARY must not be empty",
+				`unknown STORAGE_BACKEND "ftp"`,
+				`TRUSTED_PROXIES entry "proxy.local"`,
+			},
+		},
+		{
+			name:         "S3 without a bucket",
+			env:          map[string]string{"STORAGE_BACKEND": "s3"},
+			wantProblems: []string{"STORAGE_BACKEND=s3"},
+		},
+		{
+			name:         "file limit above the request limit",
+			env:          map[string]string{"MAX_UPLOAD_BYTES": "2000", "MAX_UPLOAD_REQUEST_BYTES": "1000"},
+			wantProblems: []string{"MAX_UPLOAD_BYTES 2000 exceeds MAX_UPLOAD_REQUEST_BYTES 1000"},
+		},
+		{
+			name:         "unwritable upload directory",
+			env:          map[string]string{"UPLOAD_DIR": notADir},
+			wantProblems: []string{"UPLOAD_DIR"},
+		},
+		{
+			name:         "invalid loader settings are collected too",
+			env:          map[string]string{"MAX_UPLOAD_BYTES": "lots", "FETCH_TIMEOUT": "soon", "ALLOW_ALL_ORIGINS": "maybe", "RETENTION_INTERVAL": "daily"},
+			wantProblems: []string{"MAX_UPLOAD_BYTES", "FETCH_TIMEOUT", "ALLOW_ALL_ORIGINS", "RETENTION_INTERVAL"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range configEnv {
+				t.Setenv(name, "")
+			}
+			t.Setenv("UPLOAD_DIR", t.TempDir())
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			config, err := Load()
+			if tt.wantProblems == nil {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				tt.check(t, config)
+				return
+			}
+
+			var configErr *Error
+			if !errors.As(err, &configErr) {
+				t.Fatalf("error = %v, want a *config.Error", err)
+			}
+			if len(configErr.Problems) != len(tt.wantProblems) {
+				t.Fatalf("%d problems reported, want %d:\n%v", len(configErr.Problems), len(tt.wantProblems), err)
+			}
+			for i, want := range tt.wantProblems {
+				if !strings.Contains(configErr.Problems[i], want) {
+					t.Errorf("problem %d = %q, want it to mention %q", i, configErr.Problems[i], want)
+				}
+			}
+			for _, problem := range configErr.Problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Error() does not list %q", problem)
+				}
+			}
+		})
+	}
+}
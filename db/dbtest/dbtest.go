@@ -0,0 +1,56 @@
+// Package dbtest gives integration tests a database of their own on the
+// MongoDB named by MONGODB_TEST_URI. Without it those tests are skipped.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// URIEnv is the variable naming the MongoDB the integration tests run against
+const URIEnv = "MONGODB_TEST_URI"
+
+// unsafeNameChars are the characters of a test name not allowed in a database name
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// Client connects to the MongoDB of MONGODB_TEST_URI, skipping tb when it is
+// not set. The client is disconnected when tb ends.
+func Client(tb testing.TB) *mongo.Client {
+	tb.Helper()
+	uri := os.Getenv(URIEnv)
+	if uri == "" {
+		tb.Skipf("%s is not set", URIEnv)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetServerSelectionTimeout(5*time.Second))
+	if err != nil {
+		tb.Fatalf("connect to %s: %v", URIEnv, err)
+	}
+	tb.Cleanup(func() { client.Disconnect(context.Background()) })
+	if err := client.Ping(ctx, nil); err != nil {
+		tb.Fatalf("ping %s: %v", URIEnv, err)
+	}
+	return client
+}
+
+// Database returns an empty database for tb, dropped when tb ends
+func Database(tb testing.TB) *mongo.Database {
+	tb.Helper()
+	client := Client(tb)
+	name := unsafeNameChars.ReplaceAllString(tb.Name(), "_")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	db := client.Database(fmt.Sprintf("test_%s_%d", name, time.Now().UnixNano()))
+	tb.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureSimulationIndexes creates the indexes of the collections the ETL
+// writes to a simulation's database. Creating an existing index is a no-op,
+// so it is safe to run after every processing run.
+func EnsureSimulationIndexes(ctx context.Context, database *mongo.Database) error {
+	if err := EnsureEventIndexes(ctx, database.Collection("tracer_events")); err != nil {
+		return fmt.Errorf("tracer_events: %w", err)
+	}
+	if err := EnsureVoteLatencyIndexes(ctx, database.Collection("vote_latencies")); err != nil {
+		return fmt.Errorf("vote_latencies: %w", err)
+	}
+	return nil
+}
+
+// EnsureEventIndexes creates the indexes used by the events API on a simulation's
+// tracer_events collection. Events are paged in (timestamp, _id) order. Height
+// range filters are a $or over height (step events) and vote.height (p2p vote
+// events), so each branch gets its own index to avoid a collection scan. The
+// vote latency join reads each vote event type in vote.height order, and the
+// metrics filter by type within a time window (IXSCAN on type_1_timestamp_1)
+// or group the votes of a height by round and validator.
+func EnsureEventIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "height", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "vote.height", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "vote.height", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "vote.height", Value: 1}, {Key: "vote.round", Value: 1}, {Key: "vote.validatorIndex", Value: 1}}},
+	})
+	return err
+}
+
+// EnsureVoteLatencyIndexes creates the indexes of a simulation's
+// vote_latencies collection. Latency metrics match confirmed votes sent
+// within a time window (IXSCAN on status_1_sentTime_1), and threshold and
+// spike queries select latencies above a bound (IXSCAN on latency_1).
+func EnsureVoteLatencyIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "sentTime", Value: 1}}},
+		{Keys: bson.D{{Key: "latency", Value: 1}}},
+	})
+	return err
+}
+
+// EnsureIndexes creates the indexes of the control plane collections.
+//
+// Usernames and normalized emails are unique, so concurrent registrations
+// cannot create duplicate users. The email index only covers users with a
+// normalized email, which users created before it was introduced lack until
+// they are backfilled; creating it fails while duplicates exist.
+//
+// Project and simulation lists are filtered by owner and then searched by
+// name or description with a case-insensitive substring regex, which no index
+// can serve, so the owner keys narrow the documents the regex is applied to.
+// Their prefixes also serve lookups by owner alone. Simulations are further
+// looked up by status across owners, e.g. to find interrupted processing runs.
+//
+// Each collection's indexes are created independently: a failure, such as the
+// duplicate emails above, is logged and does not keep the other collections
+// from being indexed. The failures are returned joined.
+func EnsureIndexes(ctx context.Context, users, projects, simulations *mongo.Collection) error {
+	collections := []struct {
+		collection *mongo.Collection
+		indexes    []mongo.IndexModel
+	}{
+		{users, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "username", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "emailNormalized", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.D{{Key: "emailNormalized", Value: bson.D{{Key: "$exists", Value: true}}}}),
+			},
+		}},
+		{projects, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "name", Value: 1}}},
+		}},
+		{simulations, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}, {Key: "name", Value: 1}}},
+			{Keys: bson.D{{Key: "projectId", Value: 1}, {Key: "status", Value: 1}, {Key: "name", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}}},
+		}},
+	}
+
+	var errs []error
+	for _, c := range collections {
+		if _, err := c.collection.Indexes().CreateMany(ctx, c.indexes); err != nil {
+			log.Printf("Warning: Failed to create the indexes of %s: %v", c.collection.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", c.collection.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db/dbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// indexNames returns the names of the indexes of coll
+func indexNames(t *testing.T, coll *mongo.Collection) []string {
+	t.Helper()
+	specs, err := coll.Indexes().ListSpecifications(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	return names
+}
+
+func TestEnsureIndexesContinuesAfterAFailure(t *testing.T) {
+	database := dbtest.Database(t)
+	users := database.Collection("users")
+	projects := database.Collection("projects")
+	simulations := database.Collection("simulations")
+
+	// Duplicate normalized emails keep the unique email index from being created
+	if _, err := users.InsertMany(context.Background(), []any{
+		bson.M{"username": "alice", "emailNormalized": "shared@mail.io"},
+		bson.M{"username": "bob", "emailNormalized": "shared@mail.io"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := EnsureIndexes(context.Background(), users, projects, simulations)
+	if err == nil || !strings.HasPrefix(err.Error(), "users: ") {
+		t.Fatalf("EnsureIndexes = %v, want a users error", err)
+	}
+	if strings.Contains(err.Error(), "projects") || strings.Contains(err.Error(), "simulations") {
+		t.Errorf("EnsureIndexes = %v, want only the users error", err)
+	}
+
+	tests := []struct {
+		coll *mongo.Collection
+		want string
+	}{
+		{coll: projects, want: "userId_1_name_1"},
+		{coll: simulations, want: "userId_1_status_1_name_1"},
+		{coll: simulations, want: "projectId_1_status_1_name_1"},
+		{coll: simulations, want: "status_1"},
+	}
+	for _, tt := range tests {
+		names := indexNames(t, tt.coll)
+		found := false
+		for _, name := range names {
+			found = found || name == tt.want
+		}
+		if !found {
+			t.Errorf("indexes of %s = %v, want %s", tt.coll.Name(), names, tt.want)
+		}
+	}
+}
@@ -2,22 +2,189 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"time"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func Connect(uri string) (*mongo.Client, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// Defaults of ConnectOptions
+const (
+	DefaultConnectTimeout         = 10 * time.Second
+	DefaultServerSelectionTimeout = 5 * time.Second
+	DefaultConnectRetryWindow     = 30 * time.Second
+)
+
+// maxConnectBackoff caps the wait between connection attempts
+const maxConnectBackoff = 5 * time.Second
+
+// ConnectOptions configures the MongoDB connection pool and startup.
+// Zero pool sizes keep the driver defaults (or the URI's settings).
+type ConnectOptions struct {
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration // Also bounds each startup ping
+	ReadPreference         string        // primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	RetryWindow            time.Duration // How long Connect retries an unreachable server; 0 tries once
+}
+
+// ConnectOptionsFromEnv reads MONGODB_MAX_POOL_SIZE, MONGODB_MIN_POOL_SIZE,
+// MONGODB_CONNECT_TIMEOUT, MONGODB_SERVER_SELECTION_TIMEOUT,
+// MONGODB_READ_PREFERENCE and MONGODB_CONNECT_RETRY_WINDOW
+func ConnectOptionsFromEnv() (ConnectOptions, error) {
+	opts := ConnectOptions{
+		ConnectTimeout:         DefaultConnectTimeout,
+		ServerSelectionTimeout: DefaultServerSelectionTimeout,
+		ReadPreference:         readpref.PrimaryMode.String(),
+		RetryWindow:            DefaultConnectRetryWindow,
+	}
+
+	for name, target := range map[string]*uint64{
+		"MONGODB_MAX_POOL_SIZE": &opts.MaxPoolSize,
+		"MONGODB_MIN_POOL_SIZE": &opts.MinPoolSize,
+	} {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+	if opts.MaxPoolSize > 0 && opts.MinPoolSize > opts.MaxPoolSize {
+		return opts, fmt.Errorf("MONGODB_MIN_POOL_SIZE %d exceeds MONGODB_MAX_POOL_SIZE %d", opts.MinPoolSize, opts.MaxPoolSize)
+	}
+
+	for name, target := range map[string]*time.Duration{
+		"MONGODB_CONNECT_TIMEOUT":          &opts.ConnectTimeout,
+		"MONGODB_SERVER_SELECTION_TIMEOUT": &opts.ServerSelectionTimeout,
+		"MONGODB_CONNECT_RETRY_WINDOW":     &opts.RetryWindow,
+	} {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 || (parsed == 0 && name != "MONGODB_CONNECT_RETRY_WINDOW") {
+				return opts, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+
+	if value := os.Getenv("MONGODB_READ_PREFERENCE"); value != "" {
+		if _, err := readpref.ModeFromString(value); err != nil {
+			return opts, fmt.Errorf("invalid MONGODB_READ_PREFERENCE: %q", value)
+		}
+		opts.ReadPreference = value
+	}
+
+	return opts, nil
+}
+
+// Client is a MongoDB client whose Ping uses the configured read preference
+type Client struct {
+	*mongo.Client
+	readPref *readpref.ReadPref
+}
+
+// Ping checks that a server matching the read preference answers
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Client.Ping(ctx, c.readPref)
+}
 
-	clientOpts := options.Client().ApplyURI(uri)
-	client, err := mongo.Connect(ctx, clientOpts)
+// Connect connects to uri and pings the server, retrying with exponential
+// backoff for up to opts.RetryWindow so a MongoDB that is still starting does
+// not fail the service. The resolved topology is logged once connected.
+func Connect(uri string, opts ConnectOptions) (*Client, error) {
+	mode, err := readpref.ModeFromString(opts.ReadPreference)
 	if err != nil {
 		return nil, err
 	}
-	if err := client.Ping(ctx, nil); err != nil {
+	readPref, err := readpref.New(mode)
+	if err != nil {
 		return nil, err
 	}
+
+	clientOpts := options.Client().ApplyURI(uri).
+		SetConnectTimeout(opts.ConnectTimeout).
+		SetServerSelectionTimeout(opts.ServerSelectionTimeout).
+		SetReadPreference(readPref)
+	if opts.MaxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(opts.MaxPoolSize)
+	}
+	if opts.MinPoolSize > 0 {
+		clientOpts.SetMinPoolSize(opts.MinPoolSize)
+	}
+
+	// mongo.Connect only validates the options; servers are dialed on demand
+	mongoClient, err := mongo.Connect(context.Background(), clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{Client: mongoClient, readPref: readPref}
+
+	deadline := time.Now().Add(opts.RetryWindow)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ServerSelectionTimeout)
+		err = client.Ping(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			mongoClient.Disconnect(context.Background())
+			return nil, fmt.Errorf("MongoDB unreachable after %d attempts: %w", attempt, err)
+		}
+		log.Printf("MongoDB not reachable (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ServerSelectionTimeout)
+	defer cancel()
+	if topology, err := describeTopology(ctx, mongoClient); err != nil {
+		log.Printf("Warning: Failed to describe the MongoDB topology: %v", err)
+	} else {
+		log.Printf("Connected to MongoDB %s (read preference %s)", topology, mode)
+	}
 	return client, nil
 }
+
+// describeTopology summarizes the hello response of the server, e.g.
+// "replica set rs0 [db1:27017 db2:27017]"
+func describeTopology(ctx context.Context, client *mongo.Client) (string, error) {
+	var hello struct {
+		SetName string   `bson:"setName"`
+		Hosts   []string `bson:"hosts"`
+		Msg     string   `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return "", err
+	}
+	switch {
+	case hello.Msg == "isdbgrid":
+		return "sharded cluster (mongos)", nil
+	case hello.SetName != "":
+		return fmt.Sprintf("replica set %s [%s]", hello.SetName, strings.Join(hello.Hosts, " ")), nil
+	default:
+		return "standalone", nil
+	}
+}
+
+// ServerVersion returns the version of the MongoDB server client is connected to
+func ServerVersion(ctx context.Context, client *mongo.Client) (string, error) {
+	var info struct {
+		Version string `bson:"version"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.Version, nil
+}
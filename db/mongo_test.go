@@ -0,0 +1,116 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectOptionsFromEnv(t *testing.T) {
+	defaults := ConnectOptions{
+		ConnectTimeout:         DefaultConnectTimeout,
+		ServerSelectionTimeout: DefaultServerSelectionTimeout,
+		ReadPreference:         "primary",
+		RetryWindow:            DefaultConnectRetryWindow,
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    func(opts *ConnectOptions) // Applied to the defaults
+		wantErr string
+	}{
+		{name: "defaults", want: func(opts *ConnectOptions) {}},
+		{
+			name: "everything set",
+			env: map[string]string{
+				"MONGODB_MAX_POOL_SIZE":            "50",
+				"MONGODB_MIN_POOL_SIZE":            "5",
+				"MONGODB_CONNECT_TIMEOUT":          "3s",
+				"MONGODB_SERVER_SELECTION_TIMEOUT": "1500ms",
+				"MONGODB_READ_PREFERENCE":          "secondaryPreferred",
+				"MONGODB_CONNECT_RETRY_WINDOW":     "1m",
+			},
+			want: func(opts *ConnectOptions) {
+				*opts = ConnectOptions{
+					MaxPoolSize: 50, MinPoolSize: 5, ConnectTimeout: 3 * time.Second, ServerSelectionTimeout: 1500 * time.Millisecond,
+					ReadPreference: "secondaryPreferred", RetryWindow: time.Minute,
+				}
+			},
+		},
+		{name: "min pool size without a max", env: map[string]string{"MONGODB_MIN_POOL_SIZE": "10"}, want: func(opts *ConnectOptions) { opts.MinPoolSize = 10 }},
+		{name: "no retries", env: map[string]string{"MONGODB_CONNECT_RETRY_WINDOW": "0s"}, want: func(opts *ConnectOptions) { opts.RetryWindow = 0 }},
+		{name: "pool size not a number", env: map[string]string{"MONGODB_MAX_POOL_SIZE": "many"}, wantErr: "MONGODB_MAX_POOL_SIZE"},
+		{name: "negative pool size", env: map[string]string{"MONGODB_MIN_POOL_SIZE": "-1"}, wantErr: "MONGODB_MIN_POOL_SIZE"},
+		{name: "min above max", env: map[string]string{"MONGODB_MAX_POOL_SIZE": "5", "MONGODB_MIN_POOL_SIZE": "10"}, wantErr: "exceeds"},
+		{name: "timeout without a unit", env: map[string]string{"MONGODB_CONNECT_TIMEOUT": "10"}, wantErr: "MONGODB_CONNECT_TIMEOUT"},
+		{name: "zero timeout", env: map[string]string{"MONGODB_SERVER_SELECTION_TIMEOUT": "0s"}, wantErr: "MONGODB_SERVER_SELECTION_TIMEOUT"},
+		{name: "negative retry window", env: map[string]string{"MONGODB_CONNECT_RETRY_WINDOW": "-1s"}, wantErr: "MONGODB_CONNECT_RETRY_WINDOW"},
+		{name: "unknown read preference", env: map[string]string{"MONGODB_READ_PREFERENCE": "fastest"}, wantErr: "MONGODB_READ_PREFERENCE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{
+				"MONGODB_MAX_POOL_SIZE", "MONGODB_MIN_POOL_SIZE", "MONGODB_CONNECT_TIMEOUT",
+				"MONGODB_SERVER_SELECTION_TIMEOUT", "MONGODB_READ_PREFERENCE", "MONGODB_CONNECT_RETRY_WINDOW",
+			} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			opts, err := ConnectOptionsFromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ConnectOptionsFromEnv() error = %v, want one naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConnectOptionsFromEnv(): %v", err)
+			}
+			want := defaults
+			tt.want(&want)
+			if opts != want {
+				t.Errorf("ConnectOptionsFromEnv() = %+v, want %+v", opts, want)
+			}
+		})
+	}
+}
+
+func TestConnectFailsOnAWrongURI(t *testing.T) {
+	// Nothing listens on port 1, so every ping fails once server selection times out
+	unreachable := "mongodb://127.0.0.1:1"
+	opts := ConnectOptions{ConnectTimeout: 100 * time.Millisecond, ServerSelectionTimeout: 100 * time.Millisecond, ReadPreference: "primary"}
+	retrying := opts
+	retrying.RetryWindow = time.Second
+
+	tests := []struct {
+		name        string
+		uri         string
+		opts        ConnectOptions
+		wantErr     string
+		minDuration time.Duration // Time spent retrying
+	}{
+		{name: "malformed URI", uri: "postgres://127.0.0.1:5432", opts: opts, wantErr: "scheme"},
+		{name: "unknown read preference", uri: unreachable, opts: ConnectOptions{ReadPreference: "fastest"}, wantErr: "fastest"},
+		{name: "unreachable without retries", uri: unreachable, opts: opts, wantErr: "unreachable after 1 attempts"},
+		{name: "unreachable within the retry window", uri: unreachable, opts: retrying, wantErr: "unreachable after", minDuration: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			client, err := Connect(tt.uri, tt.opts)
+			if err == nil {
+				client.Disconnect(t.Context())
+				t.Fatal("Connect succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Connect() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDuration || elapsed > tt.opts.RetryWindow+5*time.Second {
+				t.Errorf("Connect() gave up after %s, want between %s and the retry window", elapsed, tt.minDuration)
+			}
+		})
+	}
+}
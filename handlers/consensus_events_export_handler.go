@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportFlushInterval is the number of events written between flushes of the response
+const exportFlushInterval = 1000
+
+// eventCSVColumns are the common event fields flattened into CSV columns, keyed
+// by their JSON field. All other fields go to the trailing "extra" column as JSON.
+var eventCSVColumns = []struct{ header, field string }{
+	{"timestamp", "timestamp"},
+	{"type", "eventType"},
+	{"nodeId", "nodeId"},
+	{"height", "height"},
+	{"round", "round"},
+	{"sourcePeerId", "sourcePeerId"},
+	{"recipientPeerId", "recipientPeerId"},
+}
+
+// ExportConsensusEventsHandler streams the filtered events as an NDJSON or CSV
+// download. Events are written straight from the Mongo cursor, so the result
+// set is never held in memory.
+func ExportConsensusEventsHandler(collection *mongo.Collection, simulationName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "ndjson")
+		if format != "ndjson" && format != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'ndjson' or 'csv'"})
+			return
+		}
+
+		filter, err := buildEventFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The export may take a while for large simulations; stop when the client goes away
+		ctx := c.Request.Context()
+		opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+		cursor, err := collection.Find(ctx, filter.Match(), opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch events"})
+			return
+		}
+		defer cursor.Close(context.Background())
+
+		contentType := "application/x-ndjson"
+		if format == "csv" {
+			contentType = "text/csv; charset=utf-8"
+		}
+		filename := exportFilename(simulationName) + "-events." + format
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		c.Header("Content-Type", contentType)
+		c.Status(http.StatusOK)
+
+		var writeEvent func(event any) error
+		var csvWriter *csv.Writer
+		if format == "csv" {
+			csvWriter = csv.NewWriter(c.Writer)
+			header := make([]string, 0, len(eventCSVColumns)+1)
+			for _, column := range eventCSVColumns {
+				header = append(header, column.header)
+			}
+			if err := csvWriter.Write(append(header, "extra")); err != nil {
+				return
+			}
+			writeEvent = func(event any) error {
+				record, err := eventCSVRecord(event)
+				if err != nil {
+					return err
+				}
+				return csvWriter.Write(record)
+			}
+		} else {
+			encoder := json.NewEncoder(c.Writer)
+			writeEvent = func(event any) error {
+				return encoder.Encode(types.EventResponse{Event: event})
+			}
+		}
+
+		// Headers are already sent, so failures can only truncate the download
+		written := 0
+		for cursor.Next(ctx) {
+			decodedEvent, _, err := decodeEvent(cursor.Current)
+			if err != nil {
+				fmt.Printf("Warning: Failed to decode event during export: %v\n", err)
+				return
+			}
+			if err := writeEvent(decodedEvent); err != nil {
+				fmt.Printf("Warning: Failed to write event export: %v\n", err)
+				return
+			}
+
+			written++
+			if written%exportFlushInterval == 0 {
+				if csvWriter != nil {
+					csvWriter.Flush()
+				}
+				c.Writer.Flush()
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			fmt.Printf("Warning: Event export cursor error: %v\n", err)
+			return
+		}
+
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+		c.Writer.Flush()
+	}
+}
+
+// eventCSVRecord flattens an event into the CSV columns. Vote events carry
+// their height and round in the vote.
+func eventCSVRecord(event any) ([]string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	if vote, ok := fields["vote"].(map[string]any); ok {
+		for _, key := range []string{"height", "round"} {
+			if _, exists := fields[key]; !exists {
+				fields[key] = vote[key]
+			}
+		}
+	}
+
+	record := make([]string, 0, len(eventCSVColumns)+1)
+	for _, column := range eventCSVColumns {
+		value, ok := fields[column.field]
+		delete(fields, column.field)
+		if !ok || value == nil {
+			record = append(record, "")
+			continue
+		}
+		record = append(record, fmt.Sprint(value))
+	}
+
+	extra := ""
+	if len(fields) > 0 {
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		extra = string(data)
+	}
+	return append(record, extra), nil
+}
+
+// exportFilename derives a safe download filename from the simulation name
+func exportFilename(simulationName string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSpace(simulationName))
+	if strings.Trim(name, "_.") == "" {
+		return "simulation"
+	}
+	return name
+}
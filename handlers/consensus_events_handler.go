@@ -2,33 +2,170 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
 	"github.com/bft-labs/cometbft-analyzer-backend/types"
 	"github.com/bft-labs/cometbft-analyzer-backend/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func GetConsensusEventsHandler(collection *mongo.Collection) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Extract time window - only apply if explicitly provided
-		fromStr := c.Query("from")
-		toStr := c.Query("to")
-		hasTimeFilter := fromStr != "" || toStr != ""
+// hiddenEventTypes are p2p gossip events hidden by default. ?includeP2p=true shows
+// all of them, ?includeType= individual ones.
+var hiddenEventTypes = []string{
+	"p2pProposal",
+	"p2pProposalPOL",
+	"p2pNewRoundStep",
+	"p2pHasVote",
+	"p2pVoteSetMaj23",
+	"p2pVoteSetBits",
+	"p2pHasProposalBlockPart",
+}
 
-		var fromTime, toTime time.Time
-		if hasTimeFilter {
-			var err error
-			fromTime, toTime, err = utils.TimeWindowFromContext(c)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
-				return
+// parseEventTypes reads a repeatable event type query parameter, rejecting unknown types
+func parseEventTypes(c *gin.Context, param string) ([]string, error) {
+	eventTypes := c.QueryArray(param)
+	for _, eventType := range eventTypes {
+		if !types.IsConsensusEventType(eventType) {
+			return nil, fmt.Errorf("unknown %s %q", param, eventType)
+		}
+	}
+	return eventTypes, nil
+}
+
+// buildEventFilter builds the event filter shared by the events endpoints from
+// the time window, type, node and height query parameters
+func buildEventFilter(c *gin.Context) (repository.EventFilter, error) {
+	var filter repository.EventFilter
+
+	// Extract time window - only apply if explicitly provided
+	if c.Query("from") != "" || c.Query("to") != "" {
+		var err error
+		filter.From, filter.To, err = utils.TimeWindowFromContext(c)
+		if err != nil {
+			return filter, fmt.Errorf("invalid time range")
+		}
+	}
+
+	// Optional inclusion (?type=) and additional exclusion (?excludeType=) filters
+	includeTypes, err := parseEventTypes(c, "type")
+	if err != nil {
+		return filter, err
+	}
+	excludeTypes, err := parseEventTypes(c, "excludeType")
+	if err != nil {
+		return filter, err
+	}
+
+	unhiddenTypes, err := parseEventTypes(c, "includeType")
+	if err != nil {
+		return filter, err
+	}
+
+	if c.Query("includeP2p") != "true" {
+		for _, hidden := range hiddenEventTypes {
+			if !containsString(unhiddenTypes, hidden) {
+				filter.ExcludeTypes = append(filter.ExcludeTypes, hidden)
 			}
 		}
+	}
+	filter.ExcludeTypes = append(filter.ExcludeTypes, excludeTypes...)
+	filter.Types = includeTypes
+
+	// Node filters: ?nodeId= matches the emitting node, ?peer= matches either side of
+	// p2p events. Both are repeatable and combine with the type filters above.
+	// Pagination cursors only encode the position in the timeline, so they stay valid
+	// as long as the next page is requested with the same filters.
+	filter.NodeIDs = c.QueryArray("nodeId")
+	filter.Peers = c.QueryArray("peer")
+
+	// Height range filter, usable with or instead of the time window. Step events carry
+	// height at the top level, p2p vote events in vote.height (both are indexed, see
+	// db.EnsureEventIndexes).
+	filter.Heights, err = utils.HeightRangeFromContext(c)
+	if err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// eventFieldPattern matches the event field paths accepted by ?fields=
+var eventFieldPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(\.[A-Za-z][A-Za-z0-9]*)*$`)
 
+// parseEventFields builds a projection from the comma separated ?fields= list.
+// Invalid names are ignored, and the fields needed for decoding and cursors are
+// always included. Returns nil when no fields were requested.
+func parseEventFields(c *gin.Context) bson.M {
+	fieldsStr := c.Query("fields")
+	if fieldsStr == "" {
+		return nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(fieldsStr, ",") {
+		if field = strings.TrimSpace(field); eventFieldPattern.MatchString(field) {
+			fields = append(fields, field)
+		}
+	}
+	fields = append(fields, "_id", "timestamp", "type", "eventType")
+
+	// MongoDB rejects projections containing both a field and one of its subfields,
+	// so keep the shortest path
+	sort.Strings(fields)
+	projection := bson.M{}
+	var previous string
+	for _, field := range fields {
+		if previous != "" && (field == previous || strings.HasPrefix(field, previous+".")) {
+			continue
+		}
+		projection[field] = 1
+		previous = field
+	}
+	return projection
+}
+
+// decodeEvent decodes an event document and its position in the timeline. The
+// document is copied first: a cursor's Current is only valid until the next
+// call to Next, and decoded byte slices would otherwise alias the cursor batch.
+func decodeEvent(current bson.Raw) (any, utils.EventCursor, error) {
+	raw := append(bson.Raw(nil), current...)
+
+	event, err := types.DecodeConsensusEvent(raw)
+	if err != nil {
+		return nil, utils.EventCursor{}, err
+	}
+
+	var position utils.EventCursor
+	if id, ok := raw.Lookup("_id").ObjectIDOK(); ok {
+		position.ID = id
+	}
+	if timestamp, ok := raw.Lookup("timestamp").TimeOK(); ok {
+		position.Timestamp = timestamp
+	}
+	return event, position, nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConsensusEventsHandler returns a page of the events matching the query filters
+func GetConsensusEventsHandler(events repository.EventRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		// Parse pagination parameters - support both cursor and segment-based
 		limit := 10000 // Default to 10000
 		if limitStr := c.Query("limit"); limitStr != "" {
@@ -41,173 +178,249 @@ func GetConsensusEventsHandler(collection *mongo.Collection) gin.HandlerFunc {
 		before := c.Query("before")      // For backward pagination
 		segmentStr := c.Query("segment") // For segment-based pagination (1-indexed)
 		includeTotalCount := c.Query("includeTotalCount") == "true"
+		includeIDs := c.Query("includeIds") == "true"   // Expose document _ids for GET /events/:eventId
+		approxCount := c.Query("approxCount") == "true" // Use the collection's estimated count
+
+		order := c.DefaultQuery("order", "asc") // asc, or desc for newest first
+		tailStr := c.Query("tail")              // Last N events in chronological order
 
 		// Convert segment to skip/offset
 		var skip int64 = 0
+		var currentSegment int
 		if segmentStr != "" {
 			if segment, err := strconv.Atoi(segmentStr); err == nil && segment > 0 {
-				skip = int64((segment - 1) * limit) // segment is 1-indexed
+				skip = int64(segment-1) * int64(limit) // segment is 1-indexed
+				currentSegment = segment
 			}
 		}
 
-		// Excluded event types (p2p events we don't want to show)
-		excludedTypes := []string{
-			"p2pProposal",
-			"p2pProposalPOL",
-			"p2pNewRoundStep",
-			"p2pHasVote",
-			"p2pVoteSetMaj23",
-			"p2pVoteSetBits",
-			"p2pHasProposalBlockPart",
+		if order != "asc" && order != "desc" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order must be 'asc' or 'desc'"})
+			return
+		}
+		descending := order == "desc"
+
+		// Backward pagination reads the events immediately preceding the cursor in
+		// reverse order and reverses them below. ?tail=N reads backward from the end.
+		backward := before != ""
+		if tailStr != "" {
+			tail, err := strconv.Atoi(tailStr)
+			if err != nil || tail <= 0 || tail > 50000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tail"})
+				return
+			}
+			if cursor != "" || before != "" || segmentStr != "" || descending {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tail cannot be combined with cursor, before, segment or order"})
+				return
+			}
+			limit = tail
+			backward = true
 		}
 
-		matchConditions := bson.M{
-			"type": bson.M{"$nin": excludedTypes},
+		// following and preceding restrict a filter to the events after and before a
+		// position in the requested order
+		following := func(filter repository.EventFilter, position utils.EventCursor) repository.EventFilter {
+			if descending {
+				filter.Before = &position
+			} else {
+				filter.After = &position
+			}
+			return filter
+		}
+		preceding := func(filter repository.EventFilter, position utils.EventCursor) repository.EventFilter {
+			if descending {
+				filter.After = &position
+			} else {
+				filter.Before = &position
+			}
+			return filter
 		}
 
-		// Add cursor-based pagination conditions
-		timestampFilter := bson.M{}
+		filter, err := buildEventFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		// Add time window filter if provided
-		if hasTimeFilter {
-			timestampFilter["$gte"] = fromTime
-			timestampFilter["$lte"] = toTime
+		// ?resolution= switches from raw events to per-bucket aggregates
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err := time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Millisecond {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration of at least 1ms such as 500ms or 1s"})
+				return
+			}
+			respondEventBuckets(c, events, filter, resolution)
+			return
 		}
 
-		// Parse and add cursor conditions
+		// Parse cursor conditions. Cursors are opaque (timestamp, _id, order) tokens and
+		// are applied on top of the filters, which are also used to peek beyond the page.
+		page := filter
 		if cursor != "" {
-			cursorTime, err := time.Parse(time.RFC3339, cursor)
+			after, err := utils.ParseEventCursor(cursor)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor format, use RFC3339"})
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			if after.Descending != descending {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cursor was generated for a different order"})
 				return
 			}
-			timestampFilter["$gt"] = cursorTime
+			page = following(page, after)
 		}
 
 		if before != "" {
-			beforeTime, err := time.Parse(time.RFC3339, before)
+			beforeCursor, err := utils.ParseEventCursor(before)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before format, use RFC3339"})
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before cursor"})
 				return
 			}
-			timestampFilter["$lt"] = beforeTime
-		}
-
-		if len(timestampFilter) > 0 {
-			matchConditions["timestamp"] = timestampFilter
+			if beforeCursor.Descending != descending {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "before cursor was generated for a different order"})
+				return
+			}
+			page = preceding(page, beforeCursor)
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		// Get total count only if requested (expensive operation)
-		var totalCount *int
-		if includeTotalCount {
-			count, err := collection.CountDocuments(ctx, matchConditions)
+		// Get total count only if requested or needed for segments (expensive operation).
+		// The estimated count ignores filters but avoids scanning the collection.
+		var totalCount, totalSegments, segmentPtr *int
+		if includeTotalCount || currentSegment > 0 {
+			var count int64
+			var err error
+			if approxCount {
+				count, err = events.EstimatedCount(ctx)
+			} else {
+				count, err = events.Count(ctx, page)
+			}
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count events"})
 				return
 			}
 			countInt := int(count)
-			totalCount = &countInt
-		}
-
-		matchStage := bson.D{{Key: "$match", Value: matchConditions}}
-
-		// Fetch limit+1 to determine hasNext
-		fetchLimit := limit + 1
-
-		// Build pipeline based on pagination type
-		pipeline := mongo.Pipeline{
-			matchStage,
-			bson.D{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}}}},
-		}
-
-		// Add skip stage for segment-based pagination
-		if skip > 0 {
-			pipeline = append(pipeline, bson.D{{Key: "$skip", Value: skip}})
+			if includeTotalCount {
+				totalCount = &countInt
+			}
+			// Segments past the end simply return an empty page with this metadata
+			if currentSegment > 0 {
+				segments := (countInt + limit - 1) / limit
+				totalSegments = &segments
+				segmentPtr = &currentSegment
+			}
 		}
 
-		// Add limit stage
-		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: fetchLimit}})
-
-		resultCursor, err := collection.Aggregate(ctx, pipeline)
+		// Fetch limit+1 to determine whether there are more events in the paging direction.
+		// Read newest first for desc pages and for backward reads of asc pages.
+		// Omitted ?fields= decode as zero values.
+		found, err := events.Find(ctx, page, repository.EventPage{
+			Descending: descending != backward,
+			Skip:       skip,
+			Limit:      int64(limit + 1),
+			Fields:     parseEventFields(c),
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch events"})
 			return
 		}
-		defer resultCursor.Close(ctx)
 
 		type eventWithTimestamp struct {
-			event     types.EventResponse
-			timestamp time.Time
+			event    types.EventResponse
+			position utils.EventCursor
 		}
 
 		var allEventsWithTimestamps []eventWithTimestamp
-
-		for resultCursor.Next(ctx) {
-			// Decode each document using type-aware decoder
-			decodedEvent, err := types.DecodeConsensusEvent(resultCursor.Current)
+		for _, raw := range found {
+			// Decode each document using type-aware decoder, along with the timestamp
+			// and _id for cursor generation
+			decodedEvent, position, err := decodeEvent(raw)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode event: " + err.Error()})
 				return
 			}
 
-			// Extract timestamp for cursor generation
-			var doc bson.M
-			var timestamp time.Time
-			if err := bson.Unmarshal(resultCursor.Current, &doc); err == nil {
-				if ts, ok := doc["timestamp"].(time.Time); ok {
-					timestamp = ts
-				}
+			event := types.EventResponse{Event: decodedEvent}
+			if includeIDs && !position.ID.IsZero() {
+				event.ID = &position.ID
 			}
 
 			allEventsWithTimestamps = append(allEventsWithTimestamps, eventWithTimestamp{
-				event:     types.EventResponse{Event: decodedEvent},
-				timestamp: timestamp,
+				event:    event,
+				position: position,
 			})
 		}
 
-		if err := resultCursor.Err(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "cursor error: " + err.Error()})
-			return
-		}
-
-		// Determine hasNext and trim results if needed
-		hasNext := len(allEventsWithTimestamps) > limit
+		// Trim the extra event, which tells whether there are more in the paging direction
+		hasMore := len(allEventsWithTimestamps) > limit
 		eventsToReturn := allEventsWithTimestamps
-		if hasNext && len(allEventsWithTimestamps) > limit {
+		if hasMore {
 			eventsToReturn = allEventsWithTimestamps[:limit]
 		}
 
+		// Restore the requested order for backward pages
+		if backward {
+			for i, j := 0, len(eventsToReturn)-1; i < j; i, j = i+1, j-1 {
+				eventsToReturn[i], eventsToReturn[j] = eventsToReturn[j], eventsToReturn[i]
+			}
+		}
+
 		// Extract events and timestamps for response
-		events := make([]types.EventResponse, len(eventsToReturn))
+		data := make([]types.EventResponse, len(eventsToReturn))
 		for i, ewt := range eventsToReturn {
-			events[i] = ewt.event
+			data[i] = ewt.event
 		}
 
-		// Determine hasPrevious - true if we used cursor (meaning we're not at the beginning)
-		hasPrevious := cursor != ""
+		// The opposite direction is checked by peeking for one event beyond the page
+		hasNext, hasPrevious := hasMore, false
+		if backward {
+			hasNext, hasPrevious = false, hasMore
+		}
+		if len(eventsToReturn) > 0 {
+			var peek *repository.EventFilter
+			if backward {
+				peekFilter := following(filter, eventsToReturn[len(eventsToReturn)-1].position)
+				peek = &peekFilter
+			} else if cursor != "" || skip > 0 {
+				peekFilter := preceding(filter, eventsToReturn[0].position)
+				peek = &peekFilter
+			}
+			if peek != nil {
+				found, err := events.Exists(ctx, *peek)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch events"})
+					return
+				}
+				if backward {
+					hasNext = found
+				} else {
+					hasPrevious = found
+				}
+			}
+		}
 
 		// Generate cursors
 		var nextCursor, previousCursor *string
 		if hasNext && len(eventsToReturn) > 0 {
-			lastTimestamp := eventsToReturn[len(eventsToReturn)-1].timestamp
-			if !lastTimestamp.IsZero() {
-				nextStr := lastTimestamp.Format(time.RFC3339)
+			last := eventsToReturn[len(eventsToReturn)-1].position
+			last.Descending = descending
+			if !last.Timestamp.IsZero() {
+				nextStr := last.Encode()
 				nextCursor = &nextStr
 			}
 		}
 		if hasPrevious && len(eventsToReturn) > 0 {
-			firstTimestamp := eventsToReturn[0].timestamp
-			if !firstTimestamp.IsZero() {
-				prevStr := firstTimestamp.Format(time.RFC3339)
+			first := eventsToReturn[0].position
+			first.Descending = descending
+			if !first.Timestamp.IsZero() {
+				prevStr := first.Encode()
 				previousCursor = &prevStr
 			}
 		}
 
 		response := types.PaginatedEventsResponse{
-			Data: events,
+			Data: data,
 			Pagination: types.CursorPaginationMeta{
 				Limit:          limit,
 				HasNext:        hasNext,
@@ -215,9 +428,132 @@ func GetConsensusEventsHandler(collection *mongo.Collection) gin.HandlerFunc {
 				NextCursor:     nextCursor,
 				PreviousCursor: previousCursor,
 				TotalCount:     totalCount,
+				TotalSegments:  totalSegments,
+				CurrentSegment: segmentPtr,
 			},
 		}
 
 		c.JSON(http.StatusOK, response)
 	}
 }
+
+// eventWindow returns the time window of filter, closing its open sides at the
+// first and last matching events. ok is false when no event matches.
+func eventWindow(ctx context.Context, events repository.EventRepo, filter repository.EventFilter) (from, to time.Time, ok bool, err error) {
+	from, to = filter.From, filter.To
+	for _, descending := range []bool{false, true} {
+		bound := &from
+		if descending {
+			bound = &to
+		}
+		if !bound.IsZero() {
+			continue
+		}
+		edge, err := events.Find(ctx, filter, repository.EventPage{Descending: descending, Limit: 1, Fields: bson.M{"timestamp": 1}})
+		if err != nil {
+			return from, to, false, err
+		}
+		if len(edge) == 0 {
+			return from, to, false, nil
+		}
+		*bound, _ = edge[0].Lookup("timestamp").TimeOK()
+	}
+	return from, to, true, nil
+}
+
+// respondEventBuckets aggregates the matching events into buckets of the given
+// resolution with counts and the first event per type. Windows needing more
+// than maxTimeSeriesBuckets buckets are rejected before aggregating.
+func respondEventBuckets(c *gin.Context, events repository.EventRepo, filter repository.EventFilter, resolution time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	from, to, ok, err := eventWindow(ctx, events, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to aggregate events"})
+		return
+	}
+	if ok {
+		if buckets := to.Sub(from)/resolution + 1; to.Before(from) || buckets > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for resolution %s: at most %d buckets", resolution, maxTimeSeriesBuckets),
+			})
+			return
+		}
+	}
+
+	counts, err := events.Buckets(ctx, filter, resolution)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to aggregate events"})
+		return
+	}
+
+	buckets := []types.EventBucket{}
+	for _, count := range counts {
+		firstEvent, _, err := decodeEvent(count.First)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode event: " + err.Error()})
+			return
+		}
+
+		// Counts are sorted by bucket, so a new bucket starts whenever the start changes
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(count.Start) {
+			buckets = append(buckets, types.EventBucket{
+				Start:       count.Start,
+				Counts:      map[string]int64{},
+				FirstEvents: map[string]types.EventResponse{},
+			})
+		}
+		bucket := &buckets[len(buckets)-1]
+		bucket.Counts[count.Type] = count.Count
+		bucket.FirstEvents[count.Type] = types.EventResponse{Event: firstEvent}
+	}
+
+	c.JSON(http.StatusOK, types.BucketedEventsResponse{
+		Resolution: resolution.String(),
+		Buckets:    buckets,
+	})
+}
+
+// GetConsensusEventHandler returns a single event by its document _id
+func GetConsensusEventHandler(events repository.EventRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := primitive.ObjectIDFromHex(c.Param("eventId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		raw, err := events.Get(ctx, eventID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch event"})
+			return
+		}
+
+		decodedEvent, err := types.DecodeConsensusEvent(raw)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode event: " + err.Error()})
+			return
+		}
+
+		var doc struct {
+			Timestamp time.Time `bson:"timestamp"`
+		}
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode event: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.EventDetailResponse{
+			ID:        eventID.Hex(),
+			Timestamp: doc.Timestamp,
+			Event:     types.EventResponse{Event: decodedEvent},
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/core"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/events"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestGetConsensusEventsHandlerRejectsInvalidCursors(t *testing.T) {
+	position := utils.EventCursor{Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), ID: primitive.NewObjectID()}
+	ascending := position.Encode()
+	position.Descending = true
+	descending := position.Encode()
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantError string
+	}{
+		{name: "malformed cursor", query: url.Values{"cursor": {"garbage!"}}, wantError: "invalid cursor"},
+		{name: "malformed before cursor", query: url.Values{"before": {"garbage!"}}, wantError: "invalid before cursor"},
+		{name: "descending cursor on an ascending page", query: url.Values{"cursor": {descending}}, wantError: "different order"},
+		{name: "ascending cursor on a descending page", query: url.Values{"cursor": {ascending}, "order": {"desc"}}, wantError: "different order"},
+		{name: "before cursor of the other order", query: url.Values{"before": {ascending}, "order": {"desc"}}, wantError: "different order"},
+	}
+
+	// The cursor is validated before the collection is queried
+	handler := GetConsensusEventsHandler(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/events?"+tt.query.Encode(), nil)
+			handler(c)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(recorder.Body.String(), tt.wantError) {
+				t.Errorf("body = %s, want an error containing %q", recorder.Body.String(), tt.wantError)
+			}
+		})
+	}
+}
+
+// marshalRaw marshals a test document
+func marshalRaw(t *testing.T, doc bson.D) bson.Raw {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// The event types only hold values the driver copies while decoding, so this
+// pins the contract rather than the copy itself: a decoded event and its
+// position must survive the cursor buffer being overwritten, also once an
+// event type gains a byte slice or raw BSON field.
+func TestDecodeEventDoesNotAliasTheCursorBuffer(t *testing.T) {
+	id := primitive.NewObjectID()
+	timestamp := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := marshalRaw(t, bson.D{
+		{"_id", id},
+		{"eventType", "p2pBlockPart"},
+		{"timestamp", timestamp},
+		{"nodeId", "node0"},
+		{"height", int64(7)},
+		{"part", bson.D{{"index", int32(1)}, {"bytes", "deadbeef"}}},
+		{"senderPeerId", "peer-a"},
+	})
+	second := marshalRaw(t, bson.D{
+		{"_id", primitive.NewObjectID()},
+		{"eventType", "p2pBlockPart"},
+		{"timestamp", timestamp.Add(time.Hour)},
+		{"nodeId", "node9"},
+		{"height", int64(8)},
+		{"part", bson.D{{"index", int32(2)}, {"bytes", "cafebabe"}}},
+		{"senderPeerId", "peer-z"},
+	})
+	if len(second) != len(first) {
+		t.Fatalf("fixture documents differ in size: %d and %d bytes", len(first), len(second))
+	}
+
+	// A cursor reuses its batch buffer: Current is overwritten by the next document
+	buffer := append([]byte(nil), first...)
+	event, position, err := decodeEvent(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buffer, second)
+	for i := range buffer[len(buffer)/2:] {
+		buffer[len(buffer)/2+i] = 0
+	}
+
+	part, ok := event.(*events.EventP2pBlockPart)
+	if !ok {
+		t.Fatalf("decoded %T, want *events.EventP2pBlockPart", event)
+	}
+	want := events.EventP2pBlockPart{
+		BaseEvent: events.BaseEvent{EventType: "p2pBlockPart", Timestamp: timestamp, NodeId: "node0"},
+		Height:    7,
+		Part:      core.Part{Index: 1, Bytes: "deadbeef"},
+		P2pInfo:   events.P2pInfo{SenderPeerId: "peer-a"},
+	}
+	if !part.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("timestamp = %s, want %s", part.Timestamp, want.Timestamp)
+	}
+	part.Timestamp = want.Timestamp
+	if !reflect.DeepEqual(*part, want) {
+		t.Errorf("event changed with the buffer: %+v, want %+v", *part, want)
+	}
+	if position.ID != id || !position.Timestamp.Equal(timestamp) {
+		t.Errorf("position changed with the buffer: %+v", position)
+	}
+}
+
+func TestDecodeEventProjectedDocuments(t *testing.T) {
+	id := primitive.NewObjectID()
+	timestamp := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		doc          bson.D
+		wantType     any
+		wantPosition utils.EventCursor
+	}{
+		{
+			name:         "projection keeping the required fields",
+			doc:          bson.D{{"_id", id}, {"timestamp", timestamp}, {"eventType", "enteringNewRound"}, {"type", "enteringNewRound"}, {"height", int64(3)}},
+			wantType:     &events.EventEnteringNewRound{},
+			wantPosition: utils.EventCursor{Timestamp: timestamp, ID: id},
+		},
+		{
+			name:         "unknown event type decodes as a base event",
+			doc:          bson.D{{"_id", id}, {"timestamp", timestamp}, {"eventType", "somethingNew"}},
+			wantType:     &events.BaseEvent{},
+			wantPosition: utils.EventCursor{Timestamp: timestamp, ID: id},
+		},
+		{
+			name:         "without _id",
+			doc:          bson.D{{"timestamp", timestamp}, {"eventType", "p2pVote"}},
+			wantType:     &events.EventP2pVote{},
+			wantPosition: utils.EventCursor{Timestamp: timestamp},
+		},
+		{
+			name:         "without timestamp",
+			doc:          bson.D{{"_id", id}, {"eventType", "p2pVote"}},
+			wantType:     &events.EventP2pVote{},
+			wantPosition: utils.EventCursor{ID: id},
+		},
+		{
+			name:         "without eventType",
+			doc:          bson.D{{"_id", id}, {"timestamp", timestamp}},
+			wantType:     &events.BaseEvent{},
+			wantPosition: utils.EventCursor{Timestamp: timestamp, ID: id},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, position, err := decodeEvent(marshalRaw(t, tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(event) != reflect.TypeOf(tt.wantType) {
+				t.Errorf("decoded %T, want %T", event, tt.wantType)
+			}
+			if position.ID != tt.wantPosition.ID || !position.Timestamp.Equal(tt.wantPosition.Timestamp) {
+				t.Errorf("position = %+v, want %+v", position, tt.wantPosition)
+			}
+		})
+	}
+}
+
+func TestDecodeEventRejectsMismatchedFieldTypes(t *testing.T) {
+	raw := marshalRaw(t, bson.D{{"eventType", "enteringNewRound"}, {"height", "not a number"}})
+	if _, _, err := decodeEvent(raw); err == nil {
+		t.Fatal("decodeEvent accepted a string height")
+	}
+}
+
+func TestParseEventFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+		want   bson.M
+	}{
+		{name: "absent", fields: "", want: nil},
+		{
+			name:   "adds the fields needed for decoding and cursors",
+			fields: "nodeId",
+			want:   bson.M{"_id": 1, "timestamp": 1, "type": 1, "eventType": 1, "nodeId": 1},
+		},
+		{
+			name:   "ignores invalid names",
+			fields: "nodeId, $where ,vote..height,1abc,a-b, vote.height",
+			want:   bson.M{"_id": 1, "timestamp": 1, "type": 1, "eventType": 1, "nodeId": 1, "vote.height": 1},
+		},
+		{
+			name:   "keeps a parent over its subfields",
+			fields: "vote.height,vote,vote.round,voter",
+			want:   bson.M{"_id": 1, "timestamp": 1, "type": 1, "eventType": 1, "vote": 1, "voter": 1},
+		},
+		{
+			name:   "deduplicates",
+			fields: "timestamp,nodeId,nodeId",
+			want:   bson.M{"_id": 1, "timestamp": 1, "type": 1, "eventType": 1, "nodeId": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/events?"+url.Values{"fields": {tt.fields}}.Encode(), nil)
+			if got := parseEventFields(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEventFields(%q) = %v, want %v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+// eventsStart is the timestamp of the first event of seedEvents
+var eventsStart = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// seedEvents stores count enteringNewRound events of simulation a second
+// apart, at heights 1 to count, alternately emitted by node0 and node1. Every
+// third event is followed by a p2pHasVote event hidden by default.
+func seedEvents(t *testing.T, simulations *repository.MemorySimulationRepo, simulation types.Simulation, count int) {
+	t.Helper()
+	var events []any
+	for i := range count {
+		timestamp := eventsStart.Add(time.Duration(i) * time.Second)
+		node := fmt.Sprintf("node%d", i%2)
+		events = append(events, bson.D{
+			{"timestamp", timestamp}, {"type", "enteringNewRound"}, {"eventType", "enteringNewRound"},
+			{"nodeId", node}, {"height", int64(i + 1)},
+		})
+		if i%3 == 0 {
+			events = append(events, bson.D{
+				{"timestamp", timestamp.Add(time.Millisecond)}, {"type", "p2pHasVote"}, {"eventType", "p2pHasVote"},
+				{"nodeId", node}, {"sourcePeerId", "peer-a"}, {"recipientPeerId", node},
+			})
+		}
+	}
+	if err := simulations.MemoryEvents(simulation.ID).Insert(events...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventsPage is a page of events as returned by GetConsensusEventsHandler
+type eventsPage struct {
+	Data       []map[string]any           `json:"data"`
+	Pagination types.CursorPaginationMeta `json:"pagination"`
+}
+
+// heights returns the height of each event of the page
+func (page eventsPage) heights() []float64 {
+	heights := []float64{}
+	for _, event := range page.Data {
+		height, _ := event["height"].(float64)
+		heights = append(heights, height)
+	}
+	return heights
+}
+
+func TestGetSimulationConsensusEventsHandlerFilters(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0)
+	seedEvents(t, simulations, simulation, 6)
+	window := func(from, to time.Duration) string {
+		return "from=" + eventsStart.Add(from).Format(time.RFC3339) + "&to=" + eventsStart.Add(to).Format(time.RFC3339)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantHeights []float64
+	}{
+		{name: "hides p2p events", query: "", wantHeights: []float64{1, 2, 3, 4, 5, 6}},
+		{name: "includes p2p events", query: "includeP2p=true", wantHeights: []float64{1, 0, 2, 3, 4, 0, 5, 6}},
+		{name: "includes a hidden type", query: "includeType=p2pHasVote&type=p2pHasVote", wantHeights: []float64{0, 0}},
+		{name: "node", query: "nodeId=node1", wantHeights: []float64{2, 4, 6}},
+		{name: "peer", query: "includeP2p=true&peer=node1", wantHeights: []float64{0}},
+		{name: "time window", query: window(2*time.Second, 4*time.Second), wantHeights: []float64{3, 4, 5}},
+		{name: "heights", query: "heightFrom=2&heightTo=3", wantHeights: []float64{2, 3}},
+		{name: "newest first", query: "order=desc&limit=2", wantHeights: []float64{6, 5}},
+		{name: "tail", query: "tail=2", wantHeights: []float64{5, 6}},
+		{name: "segment", query: "limit=4&segment=2", wantHeights: []float64{5, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var page eventsPage
+			status := getJSON(t, GetSimulationConsensusEventsHandler(simulations), "/simulations/:id/events",
+				"/simulations/"+simulation.ID.Hex()+"/events?"+tt.query, &page)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want %d", status, http.StatusOK)
+			}
+			if got := page.heights(); !reflect.DeepEqual(got, tt.wantHeights) {
+				t.Errorf("heights = %v, want %v", got, tt.wantHeights)
+			}
+		})
+	}
+}
+
+func TestGetSimulationConsensusEventsHandlerBuckets(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0)
+	seedEvents(t, simulations, simulation, 6)
+
+	var got types.BucketedEventsResponse
+	status := getJSON(t, GetSimulationConsensusEventsHandler(simulations), "/simulations/:id/events",
+		"/simulations/"+simulation.ID.Hex()+"/events?includeP2p=true&resolution=4s", &got)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if len(got.Buckets) != 2 {
+		t.Fatalf("%d buckets, want 2: %+v", len(got.Buckets), got.Buckets)
+	}
+
+	want := []map[string]int64{
+		{"enteringNewRound": 4, "p2pHasVote": 2},
+		{"enteringNewRound": 2},
+	}
+	for i, bucket := range got.Buckets {
+		if start := eventsStart.Add(time.Duration(i) * 4 * time.Second); !bucket.Start.Equal(start) {
+			t.Errorf("bucket %d starts at %s, want %s", i, bucket.Start, start)
+		}
+		if !reflect.DeepEqual(bucket.Counts, want[i]) {
+			t.Errorf("bucket %d counts = %v, want %v", i, bucket.Counts, want[i])
+		}
+	}
+}
+
+func TestGetSimulationConsensusEventsHandlerBucketLimit(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0)
+	// Events span 9s, needing 9001 buckets of 1ms
+	seedEvents(t, simulations, simulation, 10)
+	target := "/simulations/" + simulation.ID.Hex() + "/events?resolution="
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "window of the events within the limit", query: "2ms", wantStatus: http.StatusOK},
+		{name: "window of the events beyond the limit", query: "1ms", wantStatus: http.StatusBadRequest},
+		{name: "explicit window beyond the limit", query: "1s&from=2025-03-01T12:00:00Z&to=2025-03-01T14:00:00Z", wantStatus: http.StatusBadRequest},
+		{name: "window of the events of a height range", query: "1ms&heightFrom=6", wantStatus: http.StatusOK},
+		{name: "no matching events", query: "1ms&nodeId=node9", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := getMetric(GetSimulationConsensusEventsHandler(simulations), "/simulations/:id/events", target+tt.query)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestGetSimulationConsensusEventHandler(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0)
+	seedEvents(t, simulations, simulation, 3)
+
+	var page eventsPage
+	getJSON(t, GetSimulationConsensusEventsHandler(simulations), "/simulations/:id/events",
+		"/simulations/"+simulation.ID.Hex()+"/events?includeIds=true&limit=2", &page)
+	if len(page.Data) != 2 {
+		t.Fatalf("%d events, want 2", len(page.Data))
+	}
+	eventID, _ := page.Data[1]["_id"].(string)
+
+	tests := []struct {
+		name       string
+		eventID    string
+		wantStatus int
+	}{
+		{name: "existing", eventID: eventID, wantStatus: http.StatusOK},
+		{name: "invalid ID", eventID: "event", wantStatus: http.StatusBadRequest},
+		{name: "unknown", eventID: primitive.NewObjectID().Hex(), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := serve(t, GetSimulationConsensusEventHandler(simulations), http.MethodGet, "/simulations/:id/events/:eventId",
+				"/simulations/"+simulation.ID.Hex()+"/events/"+tt.eventID, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			event, _ := body["event"].(map[string]any)
+			if body["id"] != eventID || event["height"] != float64(2) {
+				t.Errorf("body = %v, want event %s at height 2", body, eventID)
+			}
+		})
+	}
+}
+
+func TestGetSimulationConsensusEventsHandlerPrunedEvents(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := types.Simulation{ProjectID: project.ID, UserID: project.UserID, DataPruned: true}
+	if err := simulations.Create(context.Background(), &simulation); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := serve(t, GetSimulationConsensusEventsHandler(simulations), http.MethodGet, "/simulations/:id/events",
+		"/simulations/"+simulation.ID.Hex()+"/events", "")
+	if status != http.StatusGone {
+		t.Errorf("status = %d, want %d: %v", status, http.StatusGone, body)
+	}
+}
+
+func TestGetSimulationConsensusEventsHandlerCursorPagination(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0)
+	seedEvents(t, simulations, simulation, 10)
+	// Two more events share the timestamp of height 3, so pages split within a timestamp
+	shared := eventsStart.Add(2 * time.Second)
+	if err := simulations.MemoryEvents(simulation.ID).Insert(
+		bson.D{{"timestamp", shared}, {"type", "enteringNewRound"}, {"eventType", "enteringNewRound"}, {"nodeId", "node1"}, {"height", int64(3)}},
+		bson.D{{"timestamp", shared}, {"type", "enteringNewRound"}, {"eventType", "enteringNewRound"}, {"nodeId", "node2"}, {"height", int64(3)}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetSimulationConsensusEventsHandler(simulations)
+	get := func(t *testing.T, query string) eventsPage {
+		t.Helper()
+		var page eventsPage
+		status := getJSON(t, handler, "/simulations/:id/events", "/simulations/"+simulation.ID.Hex()+"/events?"+query, &page)
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, want %d", status, http.StatusOK)
+		}
+		return page
+	}
+
+	tests := []struct {
+		order       string
+		wantHeights []float64
+	}{
+		{order: "asc", wantHeights: []float64{1, 2, 3, 3, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{order: "desc", wantHeights: []float64{10, 9, 8, 7, 6, 5, 4, 3, 3, 3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			query := "order=" + tt.order + "&limit=3"
+
+			// Walking forward visits every event once, in order
+			var pages []eventsPage
+			var heights []float64
+			for page := get(t, query); ; page = get(t, query+"&cursor="+url.QueryEscape(*page.Pagination.NextCursor)) {
+				pages = append(pages, page)
+				heights = append(heights, page.heights()...)
+				if wantPrevious := len(pages) > 1; page.Pagination.HasPrevious != wantPrevious {
+					t.Errorf("page %d: hasPrevious = %v, want %v", len(pages), page.Pagination.HasPrevious, wantPrevious)
+				}
+				if !page.Pagination.HasNext {
+					break
+				}
+				if len(pages) > len(tt.wantHeights) {
+					t.Fatal("pagination does not end")
+				}
+			}
+			if !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("heights = %v, want %v", heights, tt.wantHeights)
+			}
+
+			// Walking back from the third page returns the second and the first
+			page := pages[2]
+			for want := 1; want >= 0; want-- {
+				if page.Pagination.PreviousCursor == nil {
+					t.Fatalf("page %d has no previous cursor", want+2)
+				}
+				page = get(t, query+"&before="+url.QueryEscape(*page.Pagination.PreviousCursor))
+				if !reflect.DeepEqual(page.Data, pages[want].Data) {
+					t.Errorf("back to page %d = %v, want %v", want+1, page.heights(), pages[want].heights())
+				}
+				if !page.Pagination.HasNext || page.Pagination.HasPrevious != (want > 0) {
+					t.Errorf("back to page %d: hasNext = %v, hasPrevious = %v", want+1, page.Pagination.HasNext, page.Pagination.HasPrevious)
+				}
+			}
+		})
+	}
+}
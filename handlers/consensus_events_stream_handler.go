@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/websocket"
+)
+
+// eventStreamBatchSize is the number of events fetched per cursor batch while streaming
+const eventStreamBatchSize = 500
+
+// StreamConsensusEventsHandler upgrades to a WebSocket and streams events in
+// timestamp order, paced by the time between events divided by the playback
+// speed. Query: from (RFC3339 start position), speed (default 1).
+func StreamConsensusEventsHandler(collection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var from time.Time
+		if fromStr := c.Query("from"); fromStr != "" {
+			parsed, err := time.Parse(time.RFC3339, fromStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, use RFC3339"})
+				return
+			}
+			from = parsed
+		}
+
+		speed := 1.0
+		if speedStr := c.Query("speed"); speedStr != "" {
+			parsed, err := strconv.ParseFloat(speedStr, 64)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid speed"})
+				return
+			}
+			speed = parsed
+		}
+
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			player := &eventPlayer{
+				ws:         ws,
+				collection: collection,
+				speed:      speed,
+				controls:   make(chan types.EventStreamControl),
+			}
+			player.run(from)
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// eventPlayer streams events over a WebSocket connection and applies the
+// client's control messages
+type eventPlayer struct {
+	ws         *websocket.Conn
+	collection *mongo.Collection
+	speed      float64
+	paused     bool
+	controls   chan types.EventStreamControl
+}
+
+// run plays events from the given position until the client disconnects
+func (p *eventPlayer) run(from time.Time) {
+	defer p.ws.Close()
+
+	// A dropped connection cancels the context and with it the Mongo cursor
+	ctx, cancel := context.WithCancel(p.ws.Request().Context())
+	defer cancel()
+
+	go p.readControls(ctx, cancel)
+
+	for {
+		seekTo, err := p.play(ctx, from)
+		if err != nil {
+			if ctx.Err() == nil {
+				p.send(types.EventStreamMessage{Type: "error", Error: "failed to stream events"})
+			}
+			return
+		}
+		from = seekTo
+	}
+}
+
+// readControls forwards control messages to the player until the connection closes
+func (p *eventPlayer) readControls(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		var control types.EventStreamControl
+		if err := websocket.JSON.Receive(p.ws, &control); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				p.send(types.EventStreamMessage{Type: "error", Error: "invalid control message"})
+				continue
+			}
+			return
+		}
+		select {
+		case p.controls <- control:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// play streams events starting at from. It returns the new position when the
+// client seeks, waiting for a seek once all events have been sent.
+func (p *eventPlayer) play(ctx context.Context, from time.Time) (time.Time, error) {
+	filter := bson.M{"type": bson.M{"$nin": hiddenEventTypes}}
+	if !from.IsZero() {
+		filter["timestamp"] = bson.M{"$gte": from}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(eventStreamBatchSize)
+
+	cursor, err := p.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cursor.Close(context.Background())
+
+	var previous time.Time
+	for cursor.Next(ctx) {
+		decodedEvent, position, err := decodeEvent(cursor.Current)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		var delta time.Duration
+		if !previous.IsZero() {
+			delta = position.Timestamp.Sub(previous)
+		}
+		seekTo, seek, err := p.wait(ctx, delta)
+		if err != nil || seek {
+			return seekTo, err
+		}
+		previous = position.Timestamp
+
+		if err := p.send(types.EventStreamMessage{
+			Type:      "event",
+			Timestamp: &position.Timestamp,
+			Event:     &types.EventResponse{Event: decodedEvent},
+		}); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := p.send(types.EventStreamMessage{Type: "end"}); err != nil {
+		return time.Time{}, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case control := <-p.controls:
+			if seekTo, seek := p.apply(control); seek {
+				return seekTo, nil
+			}
+		}
+	}
+}
+
+// wait delays the next event by delta of playback time, applying control
+// messages meanwhile. It reports whether the client asked to seek.
+func (p *eventPlayer) wait(ctx context.Context, delta time.Duration) (time.Time, bool, error) {
+	for {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !p.paused {
+			timer = time.NewTimer(time.Duration(float64(delta) / p.speed))
+			timeout = timer.C
+		}
+		started := time.Now()
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return time.Time{}, false, ctx.Err()
+		case <-timeout:
+			return time.Time{}, false, nil
+		case control := <-p.controls:
+			if timer != nil {
+				timer.Stop()
+				// Keep the playback time already waited across speed changes and pauses
+				delta -= time.Duration(float64(time.Since(started)) * p.speed)
+				if delta < 0 {
+					delta = 0
+				}
+			}
+			if seekTo, seek := p.apply(control); seek {
+				return seekTo, true, nil
+			}
+		}
+	}
+}
+
+// apply handles a control message, returning the position to seek to if any
+func (p *eventPlayer) apply(control types.EventStreamControl) (time.Time, bool) {
+	switch control.Action {
+	case "seek":
+		if control.Timestamp == nil {
+			p.send(types.EventStreamMessage{Type: "error", Error: "seek requires a timestamp"})
+			return time.Time{}, false
+		}
+		return *control.Timestamp, true
+	case "setSpeed":
+		if control.Multiplier <= 0 {
+			p.send(types.EventStreamMessage{Type: "error", Error: "multiplier must be positive"})
+			return time.Time{}, false
+		}
+		p.speed = control.Multiplier
+	case "pause":
+		p.paused = true
+	case "resume":
+		p.paused = false
+	default:
+		p.send(types.EventStreamMessage{Type: "error", Error: "unknown action " + strconv.Quote(control.Action)})
+	}
+	return time.Time{}, false
+}
+
+// send writes a message to the client
+func (p *eventPlayer) send(message types.EventStreamMessage) error {
+	return websocket.JSON.Send(p.ws, message)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the MongoDB ping of a readiness check
+const readinessTimeout = 2 * time.Second
+
+// HealthHandler reports that the process is up
+func HealthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Pinger checks that a database answers, such as a *db.Client
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ReadinessHandler checks that MongoDB answers a ping under the configured
+// read preference, that uploadDir is writable and that the ETL binary is on
+// PATH. Any failure yields 503 with the outcome of every check.
+func ReadinessHandler(client Pinger, uploadDir, etlBinary string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		defer cancel()
+
+		checks := map[string]error{
+			"mongodb": client.Ping(ctx),
+			"uploads": utils.CheckWritableDir(uploadDir),
+		}
+		_, checks["etl"] = exec.LookPath(etlBinary)
+
+		response := types.ReadinessResponse{Status: "ready", Checks: map[string]types.ReadinessCheck{}}
+		status := http.StatusOK
+		for name, err := range checks {
+			if err != nil {
+				response.Checks[name] = types.ReadinessCheck{Status: "failed", Error: err.Error()}
+				response.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			response.Checks[name] = types.ReadinessCheck{Status: "ok"}
+		}
+
+		c.JSON(status, response)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+)
+
+// stubPinger is a Pinger returning err
+type stubPinger struct {
+	err error
+}
+
+func (p stubPinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestReadinessHandler(t *testing.T) {
+	etlBinary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	// A directory below a regular file can neither be created nor written
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	unwritableDir := filepath.Join(blocker, "uploads")
+
+	tests := []struct {
+		name       string
+		ping       error
+		uploadDir  string
+		etlBinary  string
+		wantStatus int
+		wantFailed []string
+	}{
+		{name: "ready", uploadDir: uploadDir, etlBinary: etlBinary, wantStatus: http.StatusOK},
+		{name: "ping fails", ping: errors.New("server selection timeout"), uploadDir: uploadDir, etlBinary: etlBinary,
+			wantStatus: http.StatusServiceUnavailable, wantFailed: []string{"mongodb"}},
+		{name: "upload directory unwritable", uploadDir: unwritableDir, etlBinary: etlBinary,
+			wantStatus: http.StatusServiceUnavailable, wantFailed: []string{"uploads"}},
+		{name: "everything fails", ping: errors.New("connection refused"), uploadDir: unwritableDir,
+			etlBinary: "no-such-etl-binary", wantStatus: http.StatusServiceUnavailable, wantFailed: []string{"etl", "mongodb", "uploads"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response types.ReadinessResponse
+			status := getJSON(t, ReadinessHandler(stubPinger{tt.ping}, tt.uploadDir, tt.etlBinary), "/readyz", "/readyz", &response)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			wantStatus := "ready"
+			if len(tt.wantFailed) > 0 {
+				wantStatus = "unavailable"
+			}
+			if response.Status != wantStatus {
+				t.Errorf("response status = %q, want %q", response.Status, wantStatus)
+			}
+
+			failed := map[string]bool{}
+			for _, name := range tt.wantFailed {
+				failed[name] = true
+			}
+			for _, name := range []string{"etl", "mongodb", "uploads"} {
+				check, ok := response.Checks[name]
+				switch {
+				case !ok:
+					t.Errorf("check %s is missing", name)
+				case failed[name] && (check.Status != "failed" || check.Error == ""):
+					t.Errorf("check %s = %+v, want a failure with its error", name, check)
+				case !failed[name] && check.Status != "ok":
+					t.Errorf("check %s = %+v, want ok", name, check)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	incidentSeverities = []string{types.SeverityWarning, types.SeverityCritical}
+	incidentTypes      = []string{types.IncidentExtraRounds, types.IncidentSlowCommit, types.IncidentMissedCommit, types.IncidentStalled}
+)
+
+// GetSimulationIncidentsHandler returns a page of the incidents of a specific
+// simulation. Incidents detected with the default thresholds are stored after
+// processing; requests with other thresholds detect them from the events.
+func GetSimulationIncidentsHandler(client *mongo.Client, simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		thresholds := metrics.DefaultIncidentThresholds
+		if value := c.Query("maxCommitMs"); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "maxCommitMs must be a positive number"})
+				return
+			}
+			thresholds.MaxCommitMs = parsed
+		}
+		if value := c.Query("minRounds"); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "minRounds must be a positive integer"})
+				return
+			}
+			thresholds.MinRounds = parsed
+		}
+
+		filter := metrics.IncidentFilter{Severities: c.QueryArray("severity"), Types: c.QueryArray("type")}
+		for _, severity := range filter.Severities {
+			if !slices.Contains(incidentSeverities, severity) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown severity %q", severity)})
+				return
+			}
+		}
+		for _, incidentType := range filter.Types {
+			if !slices.Contains(incidentTypes, incidentType) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown incident type %q", incidentType)})
+				return
+			}
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		simulation, ok := loadSimulation(c, simulations)
+		if !ok {
+			return
+		}
+		database := client.Database(simulation.ID.Hex())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		var result *metrics.IncidentsResult
+		if thresholds == metrics.DefaultIncidentThresholds {
+			stored, err := metrics.ListIncidents(ctx, database, filter, page, perPage)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load incidents"})
+				return
+			}
+			result = stored
+		}
+
+		if result == nil {
+			if !checkRawEventsRetained(c, simulation, "tracer_events") {
+				return
+			}
+			incidents, err := metrics.DetectIncidents(ctx, database.Collection("tracer_events"), thresholds)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			// Store incidents of simulations processed before they were stored after processing
+			if thresholds == metrics.DefaultIncidentThresholds && simulation.Status == types.SimulationStatusProcessed {
+				if err := metrics.StoreIncidents(ctx, database, incidents); err != nil {
+					fmt.Printf("Warning: Failed to store incidents of simulation %s: %v\n", simulation.ID.Hex(), err)
+				}
+			}
+			result = metrics.PageIncidents(incidents, filter, page, perPage)
+		}
+
+		c.JSON(http.StatusOK, types.PaginatedIncidentsResponse{
+			Data:       result.Data,
+			Thresholds: thresholds,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
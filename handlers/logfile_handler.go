@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// findLogFile resolves a log file reference, either its index in the simulation's
+// LogFiles array or its original filename, to an index
+func findLogFile(simulation *types.Simulation, ref string) (int, bool) {
+	if index, err := strconv.Atoi(ref); err == nil {
+		return index, index >= 0 && index < len(simulation.LogFiles)
+	}
+
+	for i, logFile := range simulation.LogFiles {
+		if logFile.OriginalFilename == ref {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// isInsidePrefix reports whether a storage key lies below prefix without escaping it
+func isInsidePrefix(prefix, key string) bool {
+	return path.Clean(key) == key && strings.HasPrefix(key, prefix+"/")
+}
+
+// GetLogFilesHandler lists the log files of a simulation with their indices
+func GetLogFilesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulationID := c.Param("id")
+		objectID, err := primitive.ObjectIDFromHex(simulationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation ID"})
+			return
+		}
+
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		entries := make([]types.LogFileListEntry, len(simulation.LogFiles))
+		for i, logFile := range simulation.LogFiles {
+			entries[i] = types.LogFileListEntry{Index: i, LogFileInfo: logFile}
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// DownloadLogFileHandler streams a log file of a simulation. Range requests are
+// supported so interrupted downloads of large files can be resumed.
+func DownloadLogFileHandler(simulations repository.SimulationRepo, store storage.Storage, uploadDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulationID := c.Param("id")
+		objectID, err := primitive.ObjectIDFromHex(simulationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation ID"})
+			return
+		}
+
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		index, ok := findLogFile(&simulation, c.Param("index"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Log file not found"})
+			return
+		}
+		logFile := simulation.LogFiles[index]
+
+		// Never serve anything outside the simulation's storage location
+		key := logFileKey(logFile, uploadDir)
+		if !isInsidePrefix(utils.GetSimulationKey(simulation.UserID, simulation.ProjectID, simulation.ID), key) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Log file path is outside the simulation directory"})
+			return
+		}
+
+		file, err := store.Open(context.Background(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Log file is missing from storage"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open log file"})
+			return
+		}
+		defer file.Close()
+
+		// Files are stored decompressed, so drop any .gz/.zst extension from the download name
+		downloadName := logupload.StoredName(logFile.OriginalFilename)
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": downloadName}))
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+
+		// ServeContent sets Content-Length and handles Range/If-Range requests
+		http.ServeContent(c.Writer, c.Request, downloadName, file.ModTime(), file)
+	}
+}
+
+// DeleteLogFileHandler removes a single log file from a simulation. The file is
+// referenced by its index or original filename. Already processed simulations
+// are flagged for reprocessing since their metrics no longer match the files.
+func DeleteLogFileHandler(simulations repository.SimulationRepo, store storage.Storage, quotas *quota.Quota, uploadDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulationID := c.Param("id")
+		objectID, err := primitive.ObjectIDFromHex(simulationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation ID"})
+			return
+		}
+
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		if simulation.ProcessingStatus == types.ProcessingStatusProcessing {
+			c.JSON(http.StatusConflict, gin.H{"error": "Log files cannot be deleted while the simulation is being processed"})
+			return
+		}
+
+		index, ok := findLogFile(&simulation, c.Param("index"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Log file not found"})
+			return
+		}
+		logFile := simulation.LogFiles[index]
+
+		// Guard against processing having started since the simulation was loaded
+		update := repository.SimulationUpdate{RemoveLogFile: logFile.FilePath, IfNotProcessing: true}
+
+		remainingFiles := len(simulation.LogFiles) - 1
+		status := simulation.Status
+		processingStatus := simulation.ProcessingStatus
+		if remainingFiles == 0 {
+			status = types.SimulationStatusLogFileRequired
+			processingStatus = ""
+			update.Status = &status
+			update.ProcessingStatus = &processingStatus
+		} else if simulation.Status == types.SimulationStatusProcessed || simulation.Status == types.SimulationStatusFailed {
+			// Metrics were computed from the old set of files and need to be regenerated
+			status = types.SimulationStatusProcessing
+			processingStatus = types.ProcessingStatusPending
+			update.Status = &status
+			update.ProcessingStatus = &processingStatus
+		}
+
+		_, err = simulations.Update(context.Background(), objectID, update)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Simulation was modified concurrently, please retry"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		if err := store.Delete(context.Background(), logFileKey(logFile, uploadDir)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			// Log error but don't fail the deletion
+			fmt.Printf("Failed to delete log file %s: %v\n", logFile.FilePath, err)
+		}
+		if err := quotas.Release(context.Background(), simulation.UserID, logFile.FileSize); err != nil {
+			fmt.Printf("Failed to update storage usage of user %s: %v\n", simulation.UserID.Hex(), err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":          "Log file deleted successfully",
+			"simulationId":     simulationID,
+			"deletedFile":      logFile.OriginalFilename,
+			"remainingFiles":   remainingFiles,
+			"status":           status,
+			"processingStatus": processingStatus,
+		})
+	}
+}
+
+// FetchLogFilesHandler downloads log files from allowlisted URLs into a simulation.
+// Each URL is reported separately; failed downloads do not affect the others.
+// Downloads are staged in uploadDir.
+func FetchLogFilesHandler(
+	simulations repository.SimulationRepo, store storage.Storage, quotas *quota.Quota, fetcher *logupload.Fetcher, limits logupload.Limits, uploadDir string,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulationID := c.Param("id")
+		objectID, err := primitive.ObjectIDFromHex(simulationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation ID"})
+			return
+		}
+
+		if !fetcher.Config().Enabled() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Fetching log files from URLs is disabled"})
+			return
+		}
+
+		var req types.FetchLogFilesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		// Ensure temp directory exists
+		if err := os.MkdirAll(uploadDir, 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create uploads directory"})
+			return
+		}
+
+		results := fetcher.FetchAll(context.Background(), req.URLs, uploadDir, limits, func(i int, filename string) string {
+			return fmt.Sprintf("temp_%d_%d_%s", time.Now().UnixNano(), i, filename)
+		})
+
+		response := make([]types.FetchLogFileResult, len(results))
+		resultIndex := make(map[string]int)
+		var fetchedLogFiles []types.LogFileInfo
+		for i, result := range results {
+			response[i] = types.FetchLogFileResult{URL: result.URL, Filename: result.Filename}
+			if result.Err != nil {
+				response[i].Error = result.Err.Error()
+				continue
+			}
+
+			resultIndex[result.Path] = i
+			fetchedLogFiles = append(fetchedLogFiles, types.LogFileInfo{
+				OriginalFilename: result.Filename,
+				FilePath:         result.Path,
+				FileSize:         result.File.Size,
+				Compressed:       result.File.Compression != logupload.CompressionNone,
+				Checksum:         result.File.Checksum,
+				Source:           result.URL,
+				UploadedAt:       time.Now(),
+			})
+		}
+
+		// Files whose content was already uploaded are reported as failures
+		fetchedLogFiles, duplicates := findDuplicateLogFiles(allLogFiles(simulation), fetchedLogFiles)
+		for _, duplicate := range duplicates {
+			response[resultIndex[duplicate.FilePath]].Error = fmt.Sprintf("Log file was already uploaded as %s", duplicate.DuplicateOf)
+		}
+		removeDuplicateLogFiles(duplicates)
+
+		// Remember the staged paths, storing the files changes FilePath to the storage location
+		stagedPaths := make([]string, len(fetchedLogFiles))
+		for i, logFile := range fetchedLogFiles {
+			stagedPaths[i] = logFile.FilePath
+		}
+
+		// Reserve storage quota for the fetched files
+		fetchedBytes := totalFileSize(fetchedLogFiles)
+		if err := quotas.Reserve(context.Background(), simulation.UserID, fetchedBytes); err != nil {
+			removeLogFiles(fetchedLogFiles)
+			respondQuotaError(c, err)
+			return
+		}
+
+		storedLogFiles, err := storeLogFiles(store, &simulation, fetchedLogFiles)
+		if err != nil {
+			quotas.Release(context.Background(), simulation.UserID, fetchedBytes)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store log files"})
+			return
+		}
+
+		if len(storedLogFiles) > 0 {
+			// Downloads can take minutes, so append instead of overwriting the LogFiles array
+			update := firstUploadUpdate(simulation)
+			update.AddLogFiles = storedLogFiles
+
+			if _, err := simulations.Update(context.Background(), objectID, update); err != nil {
+				deleteStoredLogFiles(store, storedLogFiles)
+				quotas.Release(context.Background(), simulation.UserID, fetchedBytes)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+		}
+
+		for i, logFile := range storedLogFiles {
+			index := resultIndex[stagedPaths[i]]
+			response[index].Success = true
+			response[index].FileSize = logFile.FileSize
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"simulationId": simulationID,
+			"fetchedFiles": len(storedLogFiles),
+			"totalFiles":   len(simulation.LogFiles) + len(storedLogFiles),
+			"results":      response,
+		})
+	}
+}
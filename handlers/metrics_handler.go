@@ -2,26 +2,33 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
 	"github.com/bft-labs/cometbft-analyzer-backend/types"
 	"github.com/bft-labs/cometbft-analyzer-backend/utils"
-	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/latency"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// GetVoteLatenciesHandler returns paginated vote latencies for the given time range
-func GetVoteLatenciesHandler(coll *mongo.Collection) gin.HandlerFunc {
+// GetVoteLatenciesHandler returns paginated vote latencies for the given time and height range,
+// filtered by a percentile threshold or absolute latency bounds
+func GetVoteLatenciesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
 			return
 		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// Parse pagination parameters
 		page := 1
@@ -47,10 +54,75 @@ func GetVoteLatenciesHandler(coll *mongo.Collection) gin.HandlerFunc {
 			}
 		}
 
+		query := metrics.VoteLatencyQuery{
+			Page:          page,
+			PerPage:       perPage,
+			Percentile:    threshold,
+			ThresholdMode: metrics.ThresholdModeAbove,
+			Senders:       c.QueryArray("sender"),
+			Receivers:     c.QueryArray("receiver"),
+			VoteTypes:     c.QueryArray("voteType"),
+			SortBy:        c.DefaultQuery("sortBy", "sentTime"),
+		}
+		for _, exact := range []struct {
+			param  string
+			target **uint64
+		}{{"height", &query.Height}, {"round", &query.Round}} {
+			if valueStr := c.Query(exact.param); valueStr != "" {
+				value, err := strconv.ParseUint(valueStr, 10, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + exact.param})
+					return
+				}
+				*exact.target = &value
+			}
+		}
+		if _, ok := metrics.VoteLatencySortFields[query.SortBy]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported sortBy: " + query.SortBy})
+			return
+		}
+		switch c.DefaultQuery("order", "asc") {
+		case "asc":
+		case "desc":
+			query.Descending = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order must be 'asc' or 'desc'"})
+			return
+		}
+		if mode := c.Query("thresholdMode"); mode != "" {
+			switch mode {
+			case metrics.ThresholdModeAbove, metrics.ThresholdModeBelow, metrics.ThresholdModeAll:
+				query.ThresholdMode = mode
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid thresholdMode, use above, below or all"})
+				return
+			}
+		}
+
+		// Absolute bounds replace the percentile threshold
+		for _, bound := range []struct {
+			param  string
+			target **time.Duration
+		}{{"minLatencyMs", &query.MinLatency}, {"maxLatencyMs", &query.MaxLatency}} {
+			if boundStr := c.Query(bound.param); boundStr != "" {
+				ms, err := strconv.ParseFloat(boundStr, 64)
+				if err != nil || ms < 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: must be a non-negative number", bound.param)})
+					return
+				}
+				latency := time.Duration(ms * float64(time.Millisecond))
+				*bound.target = &latency
+			}
+		}
+		if query.MinLatency != nil && query.MaxLatency != nil && *query.MinLatency > *query.MaxLatency {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minLatencyMs must not exceed maxLatencyMs"})
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		result, err := metrics.GetVoteLatencies(ctx, coll, from, to, page, perPage, threshold)
+		result, err := metricsRepo.VoteLatencies(ctx, from, to, heights, query)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -67,7 +139,7 @@ func GetVoteLatenciesHandler(coll *mongo.Collection) gin.HandlerFunc {
 				Receiver:     v.RecipientPeerId,
 				SentTime:     v.SentTime,
 				ReceivedTime: v.ReceivedTime,
-				LatencyMs:    float64(v.Latency) / float64(time.Millisecond),
+				LatencyMs:    types.NanosToMs(float64(v.Latency)),
 			}
 		}
 
@@ -89,19 +161,48 @@ func GetVoteLatenciesHandler(coll *mongo.Collection) gin.HandlerFunc {
 }
 
 // GetPairLatencyHandler returns sender→receiver latency percentiles
-func GetPairLatencyHandler(coll *mongo.Collection) gin.HandlerFunc {
+func GetPairLatencyHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
 			return
 		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Optional peer filters: ?sender=, ?receiver= and ?node= (either side), all repeatable
+		filter := metrics.PairLatencyFilter{
+			Senders:   c.QueryArray("sender"),
+			Receivers: c.QueryArray("receiver"),
+			Nodes:     c.QueryArray("node"),
+		}
+
+		// ?groupBy=voteType splits each pair by vote type
+		groupByVoteType := false
+		switch groupBy := c.Query("groupBy"); groupBy {
+		case "":
+		case "voteType":
+			groupByVoteType = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be 'voteType'"})
+			return
+		}
+
+		percentiles, err := utils.PercentilesFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// TODO: pass window into vizmetrics if supported
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		data, err := metrics.ComputePairwiseLatencyPercentiles(ctx, coll, from, to)
+		data, err := metricsRepo.PairwiseLatencyPercentiles(ctx, from, to, heights, filter, groupByVoteType, percentiles)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -111,7 +212,7 @@ func GetPairLatencyHandler(coll *mongo.Collection) gin.HandlerFunc {
 }
 
 // GetBlockLatencyTimeSeriesHandler returns per-block latency time-series
-func GetBlockLatencyTimeSeriesHandler(coll *mongo.Collection) gin.HandlerFunc {
+func GetBlockLatencyTimeSeriesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
@@ -122,7 +223,7 @@ func GetBlockLatencyTimeSeriesHandler(coll *mongo.Collection) gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		data, err := metrics.ComputeBlockLatencyTimeSeries(ctx, coll, from, to)
+		data, err := metricsRepo.BlockLatencyTimeSeries(ctx, from, to)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -132,7 +233,7 @@ func GetBlockLatencyTimeSeriesHandler(coll *mongo.Collection) gin.HandlerFunc {
 }
 
 // GetLatencyStatsHandler returns histogram and jitter stats
-func GetLatencyStatsHandler(coll *mongo.Collection) gin.HandlerFunc {
+func GetLatencyStatsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
@@ -140,10 +241,39 @@ func GetLatencyStatsHandler(coll *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
+		// Histogram: ?buckets=N automatic buckets, or explicit ?boundaries=1,5,10 (ms)
+		histogram := metrics.HistogramOptions{Buckets: 10}
+		if bucketsStr := c.Query("buckets"); bucketsStr != "" {
+			buckets, err := strconv.Atoi(bucketsStr)
+			if err != nil || buckets < 2 || buckets > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid buckets: must be an integer between 2 and 100"})
+				return
+			}
+			histogram.Buckets = buckets
+		}
+		if boundariesStr := c.Query("boundaries"); boundariesStr != "" {
+			for _, boundaryStr := range strings.Split(boundariesStr, ",") {
+				boundary, err := strconv.ParseFloat(strings.TrimSpace(boundaryStr), 64)
+				if err != nil || math.IsNaN(boundary) || math.IsInf(boundary, 0) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid boundaries: %q is not a number", boundaryStr)})
+					return
+				}
+				if n := len(histogram.Boundaries); n > 0 && boundary <= histogram.Boundaries[n-1] {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid boundaries: values must be strictly ascending"})
+					return
+				}
+				histogram.Boundaries = append(histogram.Boundaries, boundary)
+			}
+			if len(histogram.Boundaries) < 2 || len(histogram.Boundaries) > 101 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid boundaries: between 2 and 101 values are required"})
+				return
+			}
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		stats, err := metrics.ComputeLatencyStats(ctx, coll, from, to)
+		stats, err := metricsRepo.LatencyStats(ctx, from, to, histogram)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -153,18 +283,32 @@ func GetLatencyStatsHandler(coll *mongo.Collection) gin.HandlerFunc {
 }
 
 // GetMessageSuccessRateHandler returns send vs receive counts and delivery ratio
-func GetMessageSuccessRateHandler(coll *mongo.Collection) gin.HandlerFunc {
+func GetMessageSuccessRateHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
 			return
 		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// ?aggregate= collapses the per height and pair rows
+		aggregate := c.Query("aggregate")
+		switch aggregate {
+		case "", "pair", "height", "overall":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "aggregate must be 'pair', 'height' or 'overall'"})
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		rates, err := metrics.ComputeMessageSuccessRate(ctx, coll, from, to)
+		rates, err := metricsRepo.MessageSuccessRate(ctx, from, to, heights, aggregate)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -173,19 +317,81 @@ func GetMessageSuccessRateHandler(coll *mongo.Collection) gin.HandlerFunc {
 	}
 }
 
-// GetBlockEndToEndLatencyHandler returns end-to-end consensus latency per block height
-func GetBlockEndToEndLatencyHandler(coll *mongo.Collection) gin.HandlerFunc {
+// GetMessageSuccessRateTimeSeriesHandler returns per-pair vote delivery per time bucket
+func GetMessageSuccessRateTimeSeriesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
 			return
 		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		resolution := time.Minute
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err = time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Second || resolution > time.Hour || resolution%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration between 1s and 1h such as 1m"})
+				return
+			}
+		}
+		if buckets := to.Sub(from)/resolution + 1; to.Before(from) || buckets > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for resolution %s: at most %d buckets", resolution, maxTimeSeriesBuckets),
+			})
+			return
+		}
+
+		// Only the pairs with the worst delivery when set
+		topN := 0
+		if topNStr := c.Query("topN"); topNStr != "" {
+			parsedTopN, err := strconv.Atoi(topNStr)
+			if err != nil || parsedTopN < 1 || parsedTopN > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "topN must be between 1 and 1000"})
+				return
+			}
+			topN = parsedTopN
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		series, err := metricsRepo.MessageSuccessRateTimeSeries(ctx, from, to, heights, resolution, topN)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.DeliveryTimeSeriesResponse{Resolution: resolution.String(), Pairs: series})
+	}
+}
+
+// GetBlockEndToEndLatencyHandler returns end-to-end consensus latency per block height
+func GetBlockEndToEndLatencyHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Covers the whole simulation unless a window is given
+		var from, to time.Time
+		if c.Query("from") != "" || c.Query("to") != "" {
+			var err error
+			from, to, err = utils.TimeWindowFromContext(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+				return
+			}
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		stats, err := metrics.ComputeBlockEndToEndLatencyByHeight(ctx, coll, from, to)
+		stats, err := metricsRepo.BlockEndToEndLatencyByHeight(ctx, from, to, heights)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -195,7 +401,7 @@ func GetBlockEndToEndLatencyHandler(coll *mongo.Collection) gin.HandlerFunc {
 }
 
 // GetVoteStatisticsHandler returns aggregated vote statistics by sender/receiver/type
-func GetVoteStatisticsHandler(coll *mongo.Collection) gin.HandlerFunc {
+func GetVoteStatisticsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		from, to, err := utils.TimeWindowFromContext(c)
 		if err != nil {
@@ -203,10 +409,16 @@ func GetVoteStatisticsHandler(coll *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
+		percentiles, err := utils.PercentilesFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		stats, err := metrics.ComputeVoteStatistics(ctx, coll, from, to)
+		stats, err := metricsRepo.VoteStatistics(ctx, from, to, percentiles)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -216,12 +428,23 @@ func GetVoteStatisticsHandler(coll *mongo.Collection) gin.HandlerFunc {
 }
 
 // GetNetworkLatencyStatsHandler returns network latency statistics
-func GetNetworkLatencyStatsHandler(coll *mongo.Collection) gin.HandlerFunc {
+func GetNetworkLatencyStatsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Covers the whole run unless a window is given
+		var from, to time.Time
+		if c.Query("from") != "" || c.Query("to") != "" {
+			var err error
+			from, to, err = utils.TimeWindowFromContext(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+				return
+			}
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		stats, err := metrics.GetNetworkLatencyStats(ctx, coll)
+		stats, err := metricsRepo.NetworkLatencyStats(ctx, from, to)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -230,36 +453,310 @@ func GetNetworkLatencyStatsHandler(coll *mongo.Collection) gin.HandlerFunc {
 	}
 }
 
-// GetNetworkLatencyNodeStatsHandler returns network latency node statistics
-func GetNetworkLatencyNodeStatsHandler(coll *mongo.Collection) gin.HandlerFunc {
+// GetNetworkLatencyNodeStatsHandler returns network latency node statistics,
+// sorted and paginated with limit/offset
+func GetNetworkLatencyNodeStatsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Covers the whole run unless a window is given
+		var from, to time.Time
+		if c.Query("from") != "" || c.Query("to") != "" {
+			var err error
+			from, to, err = utils.TimeWindowFromContext(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+				return
+			}
+		}
+
+		query := metrics.NodeStatsQuery{
+			NodeIDs: c.QueryArray("nodeId"),
+			SortBy:  c.DefaultQuery("sortBy", "nodeId"),
+			Limit:   100,
+		}
+		if _, ok := metrics.NodeStatsSortFields[query.SortBy]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported sortBy: " + query.SortBy})
+			return
+		}
+		switch c.DefaultQuery("order", "asc") {
+		case "asc":
+		case "desc":
+			query.Descending = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order must be 'asc' or 'desc'"})
+			return
+		}
+		if limitStr := c.Query("limit"); limitStr != "" {
+			limit, err := strconv.Atoi(limitStr)
+			if err != nil || limit < 1 || limit > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+				return
+			}
+			query.Limit = limit
+		}
+		if offsetStr := c.Query("offset"); offsetStr != "" {
+			offset, err := strconv.Atoi(offsetStr)
+			if err != nil || offset < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+				return
+			}
+			query.Offset = offset
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		cursor, err := coll.Find(ctx, bson.M{})
+		result, err := metricsRepo.NetworkLatencyNodeStats(ctx, from, to, query)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		defer cursor.Close(ctx)
 
-		var nodeStats []latency.NodeNetworkStats
-		if err = cursor.All(ctx, &nodeStats); err != nil {
+		c.JSON(http.StatusOK, types.PaginatedNodeNetworkStatsResponse{
+			Data: result.Data,
+			Pagination: types.OffsetPaginationMeta{
+				Offset: query.Offset,
+				Limit:  query.Limit,
+				Total:  result.Total,
+			},
+		})
+	}
+}
+
+// GetNetworkLatencyOverviewHandler returns comprehensive network latency statistics
+func GetNetworkLatencyOverviewHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Covers the whole run unless a window is given
+		var from, to time.Time
+		if c.Query("from") != "" || c.Query("to") != "" {
+			var err error
+			from, to, err = utils.TimeWindowFromContext(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		stats, err := metricsRepo.NetworkLatencyOverview(ctx, from, to)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// GetEventSummaryHandler returns event counts and time spans per type, optionally per node
+func GetEventSummaryHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Unlike the metrics above, the summary covers all events unless a window is given
+		var from, to time.Time
+		if c.Query("from") != "" || c.Query("to") != "" {
+			var err error
+			from, to, err = utils.TimeWindowFromContext(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+				return
+			}
+		}
+
+		groupBy := c.Query("groupBy")
+		if groupBy != "" && groupBy != "nodeId" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be 'nodeId'"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
 
-		c.JSON(http.StatusOK, nodeStats)
+		summary, err := metricsRepo.EventSummary(ctx, from, to, groupBy == "nodeId")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, summary)
 	}
 }
 
-// GetNetworkLatencyOverviewHandler returns comprehensive network latency statistics
-func GetNetworkLatencyOverviewHandler(coll *mongo.Collection) gin.HandlerFunc {
+// GetHeightsHandler returns the block heights with their time boundaries, paginated
+func GetHeightsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// from/to are a block height range here
+		var fromHeight, toHeight *int64
+		if fromStr := c.Query("from"); fromStr != "" {
+			height, err := strconv.ParseInt(fromStr, 10, 64)
+			if err != nil || height < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from height"})
+				return
+			}
+			fromHeight = &height
+		}
+		if toStr := c.Query("to"); toStr != "" {
+			height, err := strconv.ParseInt(toStr, 10, 64)
+			if err != nil || height < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to height"})
+				return
+			}
+			toHeight = &height
+		}
+		if fromHeight != nil && toHeight != nil && *fromHeight > *toHeight {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be greater than to"})
+			return
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 1000 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 10000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		result, err := metricsRepo.Heights(ctx, fromHeight, toHeight, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := types.PaginatedHeightsResponse{
+			Data: result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		}
+
+		// Heights only change when a simulation is reprocessed
+		c.Header("Cache-Control", "private, max-age=300")
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetHeightTimelineHandler returns the per-round, per-node step timeline of a block height
+func GetHeightTimelineHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		height, err := strconv.ParseInt(c.Param("height"), 10, 64)
+		if err != nil || height < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid height"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		timeline, err := metricsRepo.HeightTimeline(ctx, height)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if timeline == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no events for height"})
+			return
+		}
+		c.JSON(http.StatusOK, timeline)
+	}
+}
+
+// GetRoundDurationsHandler returns per-node consensus round durations, or with
+// ?aggregate=true their p50/p95 per height, paginated
+func GetRoundDurationsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if c.Query("aggregate") == "true" {
+			result, err := metricsRepo.RoundDurationSummary(ctx, from, to, heights, page, perPage)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, types.PaginatedRoundDurationSummaryResponse{
+				Data: result.Data,
+				Pagination: types.PaginationMeta{
+					Page:       page,
+					PerPage:    perPage,
+					Total:      result.Total,
+					TotalPages: (result.Total + perPage - 1) / perPage,
+				},
+			})
+			return
+		}
+
+		result, err := metricsRepo.RoundDurations(ctx, from, to, heights, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.PaginatedRoundDurationsResponse{
+			Data: result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
+
+// GetProposerStatsHandler returns block proposal statistics per proposer node
+func GetProposerStatsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		stats, err := metrics.GetNetworkLatencyOverview(ctx, coll)
+		stats, err := metricsRepo.ProposerStats(ctx, from, to, heights)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -267,3 +764,874 @@ func GetNetworkLatencyOverviewHandler(coll *mongo.Collection) gin.HandlerFunc {
 		c.JSON(http.StatusOK, stats)
 	}
 }
+
+// GetBlockIntervalsHandler returns the commit-to-commit block interval per height
+func GetBlockIntervalsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		marker := c.DefaultQuery("marker", "enteringCommitStep")
+		if _, ok := metrics.CommitMarkerEvents[marker]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported commit marker event: " + marker})
+			return
+		}
+
+		// Moving-average window in heights, 1 disables the moving average
+		window := 1
+		if windowStr := c.Query("window"); windowStr != "" {
+			parsedWindow, err := strconv.Atoi(windowStr)
+			if err != nil || parsedWindow < 1 || parsedWindow > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "window must be between 1 and 1000"})
+				return
+			}
+			window = parsedWindow
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		intervals, err := metricsRepo.BlockIntervals(ctx, from, to, heights, marker, window)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, intervals)
+	}
+}
+
+// GetBlockAssemblyHandler returns the time each node took to assemble the
+// proposal block per height, or its p50/p95 per node with aggregate=node
+func GetBlockAssemblyHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		aggregate := c.Query("aggregate")
+		if aggregate != "" && aggregate != "node" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "aggregate must be node"})
+			return
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if aggregate == "node" {
+			nodes, err := metricsRepo.NodeBlockAssembly(ctx, from, to, heights)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, nodes)
+			return
+		}
+
+		result, err := metricsRepo.BlockAssembly(ctx, from, to, heights, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.PaginatedBlockAssemblyResponse{
+			Data: result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
+
+// GetVoteParticipationHandler returns which validators voted per height, paginated by height
+func GetVoteParticipationHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		byRound := c.Query("byRound") == "true"
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		result, err := metricsRepo.VoteParticipation(ctx, from, to, heights, byRound, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.VoteParticipationResponse{
+			ValidatorCount: result.ValidatorCount,
+			Validators:     result.Validators,
+			Data:           result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
+
+// GetVoteAnomaliesHandler returns votes that were lost or received after the
+// recipient committed the height, paginated
+func GetVoteAnomaliesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only anomalies by default; ?status= (repeatable) also selects delivered votes
+		filter := metrics.VoteAnomalyFilter{
+			Nodes:    c.QueryArray("node"),
+			Statuses: c.QueryArray("status"),
+		}
+		if len(filter.Statuses) == 0 {
+			filter.Statuses = []string{metrics.VoteLost, metrics.VoteLate}
+		}
+		for _, status := range filter.Statuses {
+			switch status {
+			case metrics.VoteDelivered, metrics.VoteLost, metrics.VoteLate:
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be 'delivered', 'lost' or 'late'"})
+				return
+			}
+		}
+
+		// Slack for votes received shortly after the commit, e.g. ?tolerance=50ms
+		if toleranceStr := c.Query("tolerance"); toleranceStr != "" {
+			tolerance, err := time.ParseDuration(toleranceStr)
+			if err != nil || tolerance < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tolerance"})
+				return
+			}
+			filter.Tolerance = tolerance
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		result, err := metricsRepo.VoteAnomalies(ctx, from, to, heights, filter, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.PaginatedVoteAnomaliesResponse{
+			Data: result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
+
+// GetLatencySpikesHandler returns vote deliveries whose latency exceeds k × the
+// p95 latency of their sender/receiver/vote type group, most severe first
+func GetLatencySpikesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Spike threshold as a multiple of the group's p95, 2 by default like spikePerc
+		factor := 2.0
+		if kStr := c.Query("k"); kStr != "" {
+			factor, err = strconv.ParseFloat(kStr, 64)
+			if err != nil || factor <= 0 || factor > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "k must be a number between 0 and 1000"})
+				return
+			}
+		}
+
+		// Optional peer filters: ?sender=, ?receiver= and ?node= (either side), all repeatable
+		filter := metrics.PairLatencyFilter{
+			Senders:   c.QueryArray("sender"),
+			Receivers: c.QueryArray("receiver"),
+			Nodes:     c.QueryArray("node"),
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		result, err := metricsRepo.LatencySpikes(ctx, from, to, heights, filter, factor, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.PaginatedLatencySpikesResponse{
+			Data: result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+			Truncated: result.Truncated,
+		})
+	}
+}
+
+// GetNodeRankingHandler ranks the nodes worst-first by a weighted score of their
+// vote latency, timeouts and vote loss
+func GetNodeRankingHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Component weights, all 1 by default
+		weights := metrics.NodeRankingWeights{Outbound: 1, Inbound: 1, Timeouts: 1, Loss: 1}
+		for param, weight := range map[string]*float64{
+			"outboundWeight": &weights.Outbound,
+			"inboundWeight":  &weights.Inbound,
+			"timeoutWeight":  &weights.Timeouts,
+			"lossWeight":     &weights.Loss,
+		} {
+			if weightStr := c.Query(param); weightStr != "" {
+				parsed, err := strconv.ParseFloat(weightStr, 64)
+				if err != nil || parsed < 0 || math.IsInf(parsed, 0) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a non-negative number"})
+					return
+				}
+				*weight = parsed
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		ranking, err := metricsRepo.NodeRanking(ctx, from, to, heights, weights)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ranking)
+	}
+}
+
+// GetNetworkPartitionsHandler returns the intervals in which the nodes split into
+// groups whose votes did not reach each other
+func GetNetworkPartitionsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := metrics.PartitionOptions{Window: 5 * time.Second, Threshold: 0.5, MinAffectedPairs: 1}
+		if windowStr := c.Query("window"); windowStr != "" {
+			opts.Window, err = time.ParseDuration(windowStr)
+			if err != nil || opts.Window < time.Second || opts.Window > 10*time.Minute || opts.Window%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window, use a duration between 1s and 10m such as 5s"})
+				return
+			}
+		}
+		if windows := to.Sub(from)/opts.Window + 1; to.Before(from) || windows > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for window %s: at most %d windows", opts.Window, maxTimeSeriesBuckets),
+			})
+			return
+		}
+		if thresholdStr := c.Query("threshold"); thresholdStr != "" {
+			opts.Threshold, err = strconv.ParseFloat(thresholdStr, 64)
+			if err != nil || opts.Threshold <= 0 || opts.Threshold > 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be a delivery rate in (0, 1]"})
+				return
+			}
+		}
+		if maxLatencyStr := c.Query("maxLatency"); maxLatencyStr != "" {
+			opts.MaxLatency, err = time.ParseDuration(maxLatencyStr)
+			if err != nil || opts.MaxLatency < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maxLatency"})
+				return
+			}
+		}
+		opts.MinDuration = opts.Window
+		if minDurationStr := c.Query("minDuration"); minDurationStr != "" {
+			opts.MinDuration, err = time.ParseDuration(minDurationStr)
+			if err != nil || opts.MinDuration < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid minDuration"})
+				return
+			}
+		}
+		if minPairsStr := c.Query("minAffectedPairs"); minPairsStr != "" {
+			opts.MinAffectedPairs, err = strconv.Atoi(minPairsStr)
+			if err != nil || opts.MinAffectedPairs < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "minAffectedPairs must be a positive integer"})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		partitions, err := metricsRepo.NetworkPartitions(ctx, from, to, heights, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.NetworkPartitionsResponse{
+			Window:     opts.Window.String(),
+			Threshold:  opts.Threshold,
+			Partitions: partitions,
+		})
+	}
+}
+
+// GetNetworkFanoutHandler returns how many distinct peers each node gossiped
+// with per height, or the per-node fan-out and its low windows with aggregate=node
+func GetNetworkFanoutHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		aggregate := c.Query("aggregate")
+		if aggregate != "" && aggregate != "node" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "aggregate must be node"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if aggregate == "node" {
+			window := 5 * time.Second
+			if windowStr := c.Query("window"); windowStr != "" {
+				window, err = time.ParseDuration(windowStr)
+				if err != nil || window < time.Second || window > 10*time.Minute || window%time.Millisecond != 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window, use a duration between 1s and 10m such as 5s"})
+					return
+				}
+			}
+			if windows := to.Sub(from)/window + 1; to.Before(from) || windows > maxTimeSeriesBuckets {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("time range too large for window %s: at most %d windows", window, maxTimeSeriesBuckets),
+				})
+				return
+			}
+			threshold := 0.5
+			if thresholdStr := c.Query("threshold"); thresholdStr != "" {
+				threshold, err = strconv.ParseFloat(thresholdStr, 64)
+				if err != nil || threshold <= 0 || threshold > 1 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be a fraction of the peak degree in (0, 1]"})
+					return
+				}
+			}
+
+			nodes, err := metricsRepo.NodeFanout(ctx, from, to, heights, window, threshold)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, types.NodeFanoutResponse{
+				Window:    window.String(),
+				Threshold: threshold,
+				Nodes:     nodes,
+			})
+			return
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		result, err := metricsRepo.NetworkFanout(ctx, from, to, heights, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.PaginatedFanoutResponse{
+			Data: result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
+
+// GetCommitLagHandler returns how far behind the first committer each node
+// entered the commit step, per height and per node
+func GetCommitLagHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		result, err := metricsRepo.CommitLag(ctx, from, to, heights, c.QueryArray("node"), page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.CommitLagResponse{
+			Nodes: result.Nodes,
+			Data:  result.Data,
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      result.Total,
+				TotalPages: (result.Total + perPage - 1) / perPage,
+			},
+		})
+	}
+}
+
+// maxTimeSeriesBuckets caps the number of buckets of a bucketed time series
+const maxTimeSeriesBuckets = 5000
+
+// GetThroughputHandler returns event, vote and block throughput per time bucket
+func GetThroughputHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+
+		resolution := 10 * time.Second
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err = time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Second || resolution > 10*time.Minute || resolution%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration between 1s and 10m such as 10s"})
+				return
+			}
+		}
+		if buckets := to.Sub(from)/resolution + 1; to.Before(from) || buckets > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for resolution %s: at most %d buckets", resolution, maxTimeSeriesBuckets),
+			})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		buckets, err := metricsRepo.Throughput(ctx, from, to, resolution)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.ThroughputResponse{Resolution: resolution.String(), Buckets: buckets})
+	}
+}
+
+// GetMessageTimeSeriesHandler returns event counts per event type or node per time bucket
+func GetMessageTimeSeriesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+
+		resolution := 30 * time.Second
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err = time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Second || resolution > time.Hour || resolution%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration between 1s and 1h such as 30s"})
+				return
+			}
+		}
+		if buckets := to.Sub(from)/resolution + 1; to.Before(from) || buckets > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for resolution %s: at most %d buckets", resolution, maxTimeSeriesBuckets),
+			})
+			return
+		}
+
+		eventTypes, err := parseEventTypes(c, "type")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		groupBy := c.DefaultQuery("groupBy", metrics.MessageGroupByType)
+		if groupBy != metrics.MessageGroupByType && groupBy != metrics.MessageGroupByNode {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be type or node"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		series, err := metricsRepo.MessageTimeSeries(ctx, from, to, eventTypes, c.QueryArray("nodeId"), groupBy, resolution)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.MessageTimeSeriesResponse{Resolution: resolution.String(), GroupBy: groupBy, Series: series})
+	}
+}
+
+// GetStepDurationsHandler returns p50/p95 consensus step durations per node
+func GetStepDurationsHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		durations, err := metricsRepo.StepDurations(ctx, from, to, heights, c.QueryArray("node"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, durations)
+	}
+}
+
+// GetStepFunnelHandler returns how many round attempts reached each consensus step
+func GetStepFunnelHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		funnel, err := metricsRepo.StepFunnel(ctx, from, to, heights)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, funnel)
+	}
+}
+
+// GetJitterTimeSeriesHandler returns per-pair latency jitter per time bucket
+func GetJitterTimeSeriesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+
+		resolution := 30 * time.Second
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err = time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Second || resolution > time.Hour || resolution%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration between 1s and 1h such as 30s"})
+				return
+			}
+		}
+		if buckets := to.Sub(from)/resolution + 1; to.Before(from) || buckets > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for resolution %s: at most %d buckets", resolution, maxTimeSeriesBuckets),
+			})
+			return
+		}
+
+		// Optional peer filters: ?sender=, ?receiver= and ?node= (either side), all repeatable
+		filter := metrics.PairLatencyFilter{
+			Senders:   c.QueryArray("sender"),
+			Receivers: c.QueryArray("receiver"),
+			Nodes:     c.QueryArray("node"),
+		}
+
+		// Pairs with the worst jitter first, 20 by default
+		topN := 20
+		if topNStr := c.Query("topN"); topNStr != "" {
+			parsedTopN, err := strconv.Atoi(topNStr)
+			if err != nil || parsedTopN < 1 || parsedTopN > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "topN must be between 1 and 1000"})
+				return
+			}
+			topN = parsedTopN
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		series, err := metricsRepo.JitterTimeSeries(ctx, from, to, filter, resolution, topN)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.JitterTimeSeriesResponse{Resolution: resolution.String(), Pairs: series})
+	}
+}
+
+// GetVoteLatencyTimeSeriesHandler returns vote latency percentiles per time bucket
+func GetVoteLatencyTimeSeriesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil || to.Before(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		resolution := 30 * time.Second
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err = time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Second || resolution > time.Hour || resolution%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration between 1s and 1h such as 30s"})
+				return
+			}
+		}
+		// Coarsen the resolution to whole seconds rather than reject a range needing too many buckets
+		if buckets := to.Sub(from)/resolution + 1; buckets > maxTimeSeriesBuckets {
+			resolution = (to.Sub(from) / (maxTimeSeriesBuckets - 1)).Truncate(time.Second) + time.Second
+		}
+
+		// Optional peer filters: ?sender=, ?receiver= and ?node= (either side), all repeatable
+		filter := metrics.PairLatencyFilter{
+			Senders:   c.QueryArray("sender"),
+			Receivers: c.QueryArray("receiver"),
+			Nodes:     c.QueryArray("node"),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		points, err := metricsRepo.VoteLatencyTimeSeries(ctx, from, to, heights, filter, resolution)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.VoteLatencyTimeSeriesResponse{Resolution: resolution.String(), Points: points})
+	}
+}
+
+// GetTimeoutTimeSeriesHandler returns the scheduled timeouts of each node per time bucket
+func GetTimeoutTimeSeriesHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+
+		resolution := 30 * time.Second
+		if resolutionStr := c.Query("resolution"); resolutionStr != "" {
+			resolution, err = time.ParseDuration(resolutionStr)
+			if err != nil || resolution < time.Second || resolution > time.Hour || resolution%time.Millisecond != 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid resolution, use a duration between 1s and 1h such as 30s"})
+				return
+			}
+		}
+		if buckets := to.Sub(from)/resolution + 1; to.Before(from) || buckets > maxTimeSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("time range too large for resolution %s: at most %d buckets", resolution, maxTimeSeriesBuckets),
+			})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		series, err := metricsRepo.TimeoutTimeSeries(ctx, from, to, c.QueryArray("node"), resolution)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, types.TimeoutTimeSeriesResponse{Resolution: resolution.String(), Nodes: series})
+	}
+}
+
+// GetMetricsSummaryHandler returns the headline metrics of the dashboard in one
+// response. Metrics still running after 5 seconds are reported as errors rather
+// than holding up the others.
+func GetMetricsSummaryHandler(metricsRepo repository.MetricsRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := utils.TimeWindowFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time range"})
+			return
+		}
+		heights, err := utils.HeightRangeFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c.JSON(http.StatusOK, metricsRepo.Summary(ctx, from, to, heights))
+	}
+}
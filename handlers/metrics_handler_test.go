@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db/dbtest"
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/core"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/vote"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// metricsWindowStart is the start of the window the vote latencies of seedPairVotes are sent in
+var metricsWindowStart = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// seedPairVotes stores ten confirmed prevotes of 1 to 10 ms for each
+// sender→receiver pair, given as [sender, receiver]
+func seedPairVotes(t *testing.T, coll *mongo.Collection, pairs ...[2]string) {
+	t.Helper()
+	var docs []any
+	for _, pair := range pairs {
+		for i := 0; i < 10; i++ {
+			sent := metricsWindowStart.Add(time.Duration(i) * time.Second)
+			latency := time.Duration(i+1) * time.Millisecond
+			docs = append(docs, bson.D{
+				{"status", "confirmed"},
+				{"vote", bson.D{{"type", "prevote"}, {"height", int64(i + 1)}, {"round", int32(0)}}},
+				{"senderPeerId", pair[0]},
+				{"recipientPeerId", pair[1]},
+				{"sentTime", sent},
+				{"receivedTime", sent.Add(latency)},
+				{"latency", int64(latency)},
+			})
+		}
+	}
+	if _, err := coll.InsertMany(context.Background(), docs); err != nil {
+		t.Fatalf("seed vote latencies: %v", err)
+	}
+}
+
+// getJSON serves a GET of target by handler registered at pattern and
+// decodes the response into out
+func getJSON(t *testing.T, handler gin.HandlerFunc, pattern, target string, out any) int {
+	t.Helper()
+	router := gin.New()
+	router.GET(pattern, handler)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+		t.Fatalf("decode %s: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code
+}
+
+// metricsWindow is the query of the window of seedPairVotes, followed by extra
+func metricsWindow(extra string) string {
+	query := url.Values{}
+	query.Set("from", metricsWindowStart.Format(time.RFC3339))
+	query.Set("to", metricsWindowStart.Add(time.Hour).Format(time.RFC3339))
+	if extra != "" {
+		return "?" + query.Encode() + "&" + extra
+	}
+	return "?" + query.Encode()
+}
+
+func TestGetPairLatencyHandlerFilters(t *testing.T) {
+	coll := dbtest.Database(t).Collection("vote_latencies")
+	seedPairVotes(t, coll, [2]string{"a", "b"}, [2]string{"b", "a"}, [2]string{"a", "c"}, [2]string{"c", "b"})
+	handler := GetPairLatencyHandler(repository.NewMongoMetricsRepo(coll.Database()))
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string // sender→receiver of the returned pairs, in any order
+	}{
+		{name: "no filters", want: []string{"a→b", "b→a", "a→c", "c→b"}},
+		{name: "sender", query: "sender=a", want: []string{"a→b", "a→c"}},
+		{name: "repeated sender", query: "sender=b&sender=c", want: []string{"b→a", "c→b"}},
+		{name: "receiver", query: "receiver=b", want: []string{"a→b", "c→b"}},
+		{name: "sender and receiver", query: "sender=a&receiver=c", want: []string{"a→c"}},
+		{name: "node on either side", query: "node=c", want: []string{"a→c", "c→b"}},
+		{name: "node and sender", query: "node=b&sender=a", want: []string{"a→b"}},
+		{name: "no match is empty", query: "sender=z", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []types.PairLatency
+			if code := getJSON(t, handler, "/latency/pairs", "/latency/pairs"+metricsWindow(tt.query), &got); code != http.StatusOK {
+				t.Fatalf("status %d", code)
+			}
+			if got == nil {
+				t.Fatal("response is null, want an array")
+			}
+			pairs := map[string]bool{}
+			for _, p := range got {
+				pairs[p.Sender+"→"+p.Receiver] = true
+			}
+			if len(pairs) != len(tt.want) {
+				t.Fatalf("pairs %v, want %v", pairs, tt.want)
+			}
+			for _, want := range tt.want {
+				if !pairs[want] {
+					t.Errorf("pairs %v, want %v", pairs, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetVoteLatenciesHandlerMilliseconds(t *testing.T) {
+	var got metrics.VoteLatencyQuery
+	stub := repository.NewStubMetricsRepo()
+	stub.VoteLatenciesFunc = func(from, to time.Time, heights types.HeightRange, query metrics.VoteLatencyQuery) (*metrics.VoteLatencyResult, error) {
+		got = query
+		return &metrics.VoteLatencyResult{
+			Data: []*vote.VoteLatency{{
+				Vote:            &core.Vote{Type: "prevote", Height: 3},
+				SenderPeerId:    "a",
+				RecipientPeerId: "b",
+				SentTime:        metricsWindowStart,
+				ReceivedTime:    metricsWindowStart.Add(1500 * time.Microsecond),
+				Latency:         1500 * time.Microsecond,
+			}},
+			Total: 1,
+		}, nil
+	}
+
+	var response types.PaginatedVoteLatencyResponse
+	code := getJSON(t, GetVoteLatenciesHandler(stub), "/latency/votes", "/latency/votes"+metricsWindow("minLatencyMs=0.25&maxLatencyMs=10"), &response)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(response.Data) != 1 || response.Data[0].LatencyMs != 1.5 {
+		t.Errorf("data = %+v, want a latency of 1.5 ms", response.Data)
+	}
+	if got.MinLatency == nil || *got.MinLatency != 250*time.Microsecond {
+		t.Errorf("MinLatency = %v, want 250µs", got.MinLatency)
+	}
+	if got.MaxLatency == nil || *got.MaxLatency != 10*time.Millisecond {
+		t.Errorf("MaxLatency = %v, want 10ms", got.MaxLatency)
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIHandler serves the OpenAPI document of the API
+func OpenAPIHandler(spec *openapi.Document) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	}
+}
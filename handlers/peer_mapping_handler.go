@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+)
+
+// minResolveConfidence is the confidence below which resolvePeers leaves a peer ID as is
+const minResolveConfidence = 0.5
+
+// peerIDFields are the response fields whose values resolvePeers translates
+var peerIDFields = map[string]bool{
+	"sender":          true,
+	"receiver":        true,
+	"recipient":       true,
+	"senderPeerId":    true,
+	"recipientPeerId": true,
+	"sourcePeerId":    true,
+}
+
+// GetSimulationPeersHandler returns the peer ID to node ID mapping of a
+// specific simulation. The mapping is stored after processing; for
+// simulations processed before it was, it is derived on the first request.
+func GetSimulationPeersHandler(client *mongo.Client, simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulation, ok := loadSimulation(c, simulations)
+		if !ok {
+			return
+		}
+		database := client.Database(simulation.ID.Hex())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		mappings, err := metrics.LoadPeerMapping(ctx, database)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load peer mapping"})
+			return
+		}
+
+		if len(mappings) == 0 && simulation.Status == types.SimulationStatusProcessed {
+			if !checkRawEventsRetained(c, simulation, "tracer_events") {
+				return
+			}
+			mappings, err = metrics.DerivePeerMapping(ctx, database.Collection("tracer_events"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to derive peer mapping"})
+				return
+			}
+			if err := metrics.StorePeerMapping(ctx, database, mappings); err != nil {
+				fmt.Printf("Warning: Failed to store peer mapping of simulation %s: %v\n", simulation.ID.Hex(), err)
+			}
+		}
+
+		c.JSON(http.StatusOK, mappings)
+	}
+}
+
+// bufferingWriter holds back the response body written by a handler
+type bufferingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferingWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// serveResolvingPeers runs serve and translates the peer IDs in the fields of
+// peerIDFields of its successful JSON response to node IDs, using the stored
+// peer mapping of the simulation. Peer IDs mapped with less than
+// minResolveConfidence, or not mapped at all, are left as they are.
+func serveResolvingPeers(c *gin.Context, metricsRepo repository.MetricsRepo, serve func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mappings, err := metricsRepo.PeerMapping(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load peer mapping"})
+		return
+	}
+	nodeOf := map[string]string{}
+	for _, mapping := range mappings {
+		if mapping.Confidence >= minResolveConfidence {
+			nodeOf[mapping.PeerID] = mapping.NodeID
+		}
+	}
+
+	writer := &bufferingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	serve()
+	c.Writer = writer.ResponseWriter
+
+	body := writer.body.Bytes()
+	if writer.Status() == http.StatusOK && len(nodeOf) > 0 {
+		translated, err := translatePeerIDs(body, nodeOf)
+		if err != nil {
+			fmt.Printf("Warning: Failed to resolve peer IDs of simulation %s: %v\n", c.Param("id"), err)
+		} else {
+			body = translated
+		}
+	}
+	c.Writer.Write(body)
+}
+
+// translatePeerIDs rewrites the peer ID fields of a JSON document
+func translatePeerIDs(body []byte, nodeOf map[string]string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	translatePeerFields(document, nodeOf)
+	return json.Marshal(document)
+}
+
+// translatePeerFields replaces the mapped peer IDs in the peer ID fields of a decoded JSON value, in place
+func translatePeerFields(value any, nodeOf map[string]string) {
+	switch value := value.(type) {
+	case map[string]any:
+		for name, field := range value {
+			if id, ok := field.(string); ok && peerIDFields[name] {
+				if nodeID, ok := nodeOf[id]; ok {
+					value[name] = nodeID
+				}
+				continue
+			}
+			translatePeerFields(field, nodeOf)
+		}
+	case []any:
+		for _, element := range value {
+			translatePeerFields(element, nodeOf)
+		}
+	}
+}
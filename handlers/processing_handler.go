@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetProcessingQueueHandler lists the simulations waiting for processing in dequeue order
+func GetProcessingQueueHandler(queue *processing.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobs := queue.Snapshot()
+
+		entries := make([]types.ProcessingQueueEntry, len(jobs))
+		for i, job := range jobs {
+			entries[i] = types.ProcessingQueueEntry{
+				Position:     i + 1,
+				SimulationID: job.SimulationID,
+				Priority:     job.Priority,
+				EnqueuedAt:   job.EnqueuedAt,
+			}
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// UpdateSimulationPriorityHandler changes the processing priority of a simulation,
+// reordering it in the processing queue if it is already waiting there
+func UpdateSimulationPriorityHandler(simulations repository.SimulationRepo, queue *processing.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulationID := c.Param("id")
+		objectID, err := primitive.ObjectIDFromHex(simulationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation ID"})
+			return
+		}
+
+		var req types.UpdateSimulationPriorityRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, err = simulations.Update(context.Background(), objectID, repository.SimulationUpdate{Priority: req.Priority})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		queued := queue.SetPriority(objectID, *req.Priority)
+
+		c.JSON(http.StatusOK, gin.H{
+			"simulationId": simulationID,
+			"priority":     *req.Priority,
+			"queued":       queued,
+		})
+	}
+}
@@ -2,18 +2,24 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
 	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // CreateProjectHandler creates a new project
-func CreateProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
+func CreateProjectHandler(projects repository.ProjectRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Param("userId")
 		userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -29,26 +35,25 @@ func CreateProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
 		}
 
 		project := types.Project{
-			Name:        req.Name,
-			Description: req.Description,
-			UserID:      userObjectID,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Name:                req.Name,
+			Description:         req.Description,
+			UserID:              userObjectID,
+			RetainRawEventsDays: req.RetainRawEventsDays,
+			CreatedAt:           time.Now(),
+			UpdatedAt:           time.Now(),
 		}
 
-		result, err := collection.InsertOne(context.Background(), project)
-		if err != nil {
+		if err := projects.Create(context.Background(), &project); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project"})
 			return
 		}
 
-		project.ID = result.InsertedID.(primitive.ObjectID)
 		c.JSON(http.StatusCreated, project)
 	}
 }
 
 // GetProjectHandler retrieves a project by ID
-func GetProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
+func GetProjectHandler(projects repository.ProjectRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		projectID := c.Param("projectId")
 		objectID, err := primitive.ObjectIDFromHex(projectID)
@@ -57,9 +62,8 @@ func GetProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		var project types.Project
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&project)
-		if err == mongo.ErrNoDocuments {
+		project, err := projects.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 			return
 		} else if err != nil {
@@ -71,8 +75,9 @@ func GetProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
 	}
 }
 
-// GetProjectsByUserHandler retrieves all projects for a specific user
-func GetProjectsByUserHandler(collection *mongo.Collection) gin.HandlerFunc {
+// GetProjectsByUserHandler retrieves all projects for a specific user. With
+// ?includeStats=true each project carries the stats of its simulations.
+func GetProjectsByUserHandler(projects repository.ProjectRepo, simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Param("userId")
 		userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -81,29 +86,139 @@ func GetProjectsByUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		cursor, err := collection.Find(context.Background(), bson.M{"userId": userObjectID})
+		search, err := utils.SearchFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userProjects, err := projects.ListByUser(context.Background(), userObjectID, search)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		if c.Query("includeStats") != "true" {
+			c.JSON(http.StatusOK, userProjects)
+			return
+		}
+
+		projectIDs := make([]primitive.ObjectID, len(userProjects))
+		for i, project := range userProjects {
+			projectIDs[i] = project.ID
+		}
+		stats, err := simulations.ProjectStats(context.Background(), projectIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		responses := make([]types.ProjectWithStats, len(userProjects))
+		for i, project := range userProjects {
+			responses[i] = types.ProjectWithStats{Project: project, Stats: stats[project.ID]}
+		}
+		c.JSON(http.StatusOK, responses)
+	}
+}
+
+// GetProjectStatsHandler returns the simulation counts by status, the newest
+// simulation and the stored bytes of a project
+func GetProjectStatsHandler(projects repository.ProjectRepo, simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID := c.Param("projectId")
+		objectID, err := primitive.ObjectIDFromHex(projectID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		_, err = projects.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		stats, err := simulations.ProjectStats(context.Background(), []primitive.ObjectID{objectID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
-		defer cursor.Close(context.Background())
+		c.JSON(http.StatusOK, stats[objectID])
+	}
+}
 
-		var projects []types.Project
-		if err := cursor.All(context.Background(), &projects); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode projects"})
+// GetProjectStorageHandler returns the log file bytes of a project's
+// simulations and the size of their processed output on disk
+func GetProjectStorageHandler(projects repository.ProjectRepo, simulations repository.SimulationRepo, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID := c.Param("projectId")
+		objectID, err := primitive.ObjectIDFromHex(projectID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
 			return
 		}
 
-		if projects == nil {
-			projects = []types.Project{}
+		_, err = projects.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
 		}
 
-		c.JSON(http.StatusOK, projects)
+		usage, err := projectStorageUsage(context.Background(), simulations, store, repository.SimulationFilter{ProjectID: objectID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute storage usage"})
+			return
+		}
+		if len(usage) == 0 {
+			c.JSON(http.StatusOK, types.ProjectStorageUsage{ProjectID: objectID})
+			return
+		}
+		c.JSON(http.StatusOK, usage[0])
+	}
+}
+
+// projectStorageUsage sums the log file sizes of the simulations matching
+// filter per project, largest first, and adds the size of their processed
+// directories. Simulations whose directory was removed by hand are counted as
+// missing on disk instead of failing the report.
+func projectStorageUsage(ctx context.Context, simulations repository.SimulationRepo, store storage.Storage, filter repository.SimulationFilter) ([]types.ProjectStorageUsage, error) {
+	logFiles, err := simulations.LogFileUsage(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]types.ProjectStorageUsage, 0, len(logFiles))
+	for _, doc := range logFiles {
+		project := doc.Usage
+		project.Simulations = len(doc.Simulations)
+		for _, simulation := range doc.Simulations {
+			size, err := store.DirSize(ctx, utils.GetProcessedDir(simulation.UserID, project.ProjectID, simulation.ID))
+			if errors.Is(err, storage.ErrNotFound) {
+				// Only a simulation without its own directory is missing; processed/ may not exist yet
+				_, err = store.DirSize(ctx, utils.GetSimulationKey(simulation.UserID, project.ProjectID, simulation.ID))
+				if errors.Is(err, storage.ErrNotFound) {
+					project.MissingOnDisk++
+					continue
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			project.ProcessedBytes += size
+		}
+		usage = append(usage, project)
 	}
+	return usage, nil
 }
 
 // UpdateProjectHandler updates a project by ID
-func UpdateProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
+func UpdateProjectHandler(projects repository.ProjectRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		projectID := c.Param("projectId")
 		objectID, err := primitive.ObjectIDFromHex(projectID)
@@ -118,43 +233,209 @@ func UpdateProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		update := bson.M{
-			"$set": bson.M{
-				"updatedAt": time.Now(),
-			},
+		project, err := projects.Update(context.Background(), objectID, repository.ProjectUpdate{
+			Name:                req.Name,
+			Description:         req.Description,
+			RetainRawEventsDays: req.RetainRawEventsDays,
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
 		}
 
-		if req.Name != nil {
-			update["$set"].(bson.M)["name"] = *req.Name
+		c.JSON(http.StatusOK, project)
+	}
+}
+
+// TransferProjectHandler moves a project with its simulations to another user.
+// The simulation documents and storage keys are rewritten first, then the
+// project's files are moved to the new owner's location; if the move fails
+// the documents are restored. The log file bytes are charged to the new
+// owner's quota. Simulations must not be processing during the transfer.
+func TransferProjectHandler(
+	projects repository.ProjectRepo, simulations repository.SimulationRepo, users repository.UserRepo, store storage.Storage, quotas *quota.Quota, uploadDir string,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID := c.Param("projectId")
+		objectID, err := primitive.ObjectIDFromHex(projectID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		var req types.TransferProjectRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		if req.Description != nil {
-			update["$set"].(bson.M)["description"] = *req.Description
+		targetID, err := primitive.ObjectIDFromHex(req.TargetUserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
+			return
 		}
 
-		result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+		ctx := context.Background()
+		project, err := projects.Get(ctx, objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if project.UserID == targetID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Project already belongs to the target user"})
+			return
+		}
+
+		_, err = users.Get(ctx, targetID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Target user not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		projectSimulations, err := simulations.List(ctx, repository.SimulationFilter{ProjectID: objectID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
 
-		if result.MatchedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		var processing []string
+		for _, simulation := range projectSimulations {
+			if simulation.ProcessingStatus == types.ProcessingStatusProcessing {
+				processing = append(processing, simulation.ID.Hex())
+			}
+		}
+		if len(processing) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":       "Projects cannot be transferred while simulations are being processed",
+				"simulations": processing,
+			})
 			return
 		}
 
-		var project types.Project
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&project)
+		fromKey := utils.GetProjectKey(project.UserID, objectID)
+		toKey := utils.GetProjectKey(targetID, objectID)
+		summary := types.ProjectTransferSummary{FromUserID: project.UserID, Simulations: len(projectSimulations)}
+
+		// Rewrite the keys of every log file and charge their bytes to the new owner
+		var keys []string
+		transferred := make([]types.Simulation, len(projectSimulations))
+		for i, simulation := range projectSimulations {
+			transferred[i] = simulation
+			transferred[i].UserID = targetID
+			transferred[i].LogFiles = movedLogFiles(store, uploadDir, simulation.LogFiles, fromKey, toKey, &keys)
+			transferred[i].PendingLogFiles = movedLogFiles(store, uploadDir, simulation.PendingLogFiles, fromKey, toKey, &keys)
+			summary.LogFiles += len(simulation.LogFiles) + len(simulation.PendingLogFiles)
+			summary.MovedBytes += totalFileSize(simulation.LogFiles) + totalFileSize(simulation.PendingLogFiles)
+		}
+		if err := quotas.Reserve(ctx, targetID, summary.MovedBytes); err != nil {
+			respondQuotaError(c, err)
+			return
+		}
+
+		// restore puts back the documents updated so far and the reservation
+		restore := func(updated []types.Simulation, projectUpdated bool) []string {
+			var failures []string
+			for _, simulation := range updated {
+				if _, err := simulations.Update(ctx, simulation.ID, simulationOwnerUpdate(simulation)); err != nil {
+					failures = append(failures, fmt.Sprintf("restore simulation %s: %v", simulation.ID.Hex(), err))
+				}
+			}
+			if projectUpdated {
+				if _, err := projects.Update(ctx, objectID, repository.ProjectUpdate{UserID: &project.UserID}); err != nil {
+					failures = append(failures, fmt.Sprintf("restore project: %v", err))
+				}
+			}
+			if err := quotas.Release(ctx, targetID, summary.MovedBytes); err != nil {
+				failures = append(failures, fmt.Sprintf("release storage of target user: %v", err))
+			}
+			return failures
+		}
+
+		for i, simulation := range transferred {
+			// Guard against processing having started since the simulations were loaded
+			update := simulationOwnerUpdate(simulation)
+			update.IfNotProcessing = true
+			_, err := simulations.Update(ctx, simulation.ID, update)
+			if errors.Is(err, repository.ErrNotFound) {
+				failures := restore(projectSimulations[:i], false)
+				c.JSON(http.StatusConflict, gin.H{
+					"error":    "Simulation " + simulation.ID.Hex() + " was modified or started processing, please retry",
+					"failures": failures,
+				})
+				return
+			} else if err != nil {
+				failures := append([]string{fmt.Sprintf("simulation %s: %v", simulation.ID.Hex(), err)}, restore(projectSimulations[:i], false)...)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer project", "failures": failures})
+				return
+			}
+		}
+
+		transferredProject, err := projects.Update(ctx, objectID, repository.ProjectUpdate{UserID: &targetID})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve updated project"})
+			failures := append([]string{fmt.Sprintf("project: %v", err)}, restore(projectSimulations, false)...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer project", "failures": failures})
 			return
 		}
 
-		c.JSON(http.StatusOK, project)
+		if err := store.MoveDir(ctx, fromKey, toKey, keys); err != nil {
+			failures := append([]string{fmt.Sprintf("move files: %v", err)}, restore(projectSimulations, true)...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move project files", "failures": failures})
+			return
+		}
+
+		if err := quotas.Release(ctx, project.UserID, summary.MovedBytes); err != nil {
+			fmt.Printf("Failed to update storage usage of user %s: %v\n", project.UserID.Hex(), err)
+		}
+
+		summary.Project = transferredProject
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+// movedLogFiles returns copies of logFiles whose storage keys below from are
+// moved below to, and appends the original keys to keys
+func movedLogFiles(store storage.Storage, uploadDir string, logFiles []types.LogFileInfo, from, to string, keys *[]string) []types.LogFileInfo {
+	if len(logFiles) == 0 {
+		return logFiles
+	}
+	moved := make([]types.LogFileInfo, len(logFiles))
+	for i, logFile := range logFiles {
+		moved[i] = logFile
+		key := logFileKey(logFile, uploadDir)
+		if !strings.HasPrefix(key, from+"/") {
+			continue
+		}
+		*keys = append(*keys, key)
+		moved[i].StorageKey = to + strings.TrimPrefix(key, from)
+		moved[i].FilePath = store.Location(moved[i].StorageKey)
+	}
+	return moved
+}
+
+// simulationOwnerUpdate sets the owner and log file locations of simulation
+func simulationOwnerUpdate(simulation types.Simulation) repository.SimulationUpdate {
+	return repository.SimulationUpdate{
+		UserID:          &simulation.UserID,
+		LogFiles:        simulation.LogFiles,
+		PendingLogFiles: simulation.PendingLogFiles,
 	}
 }
 
-// DeleteProjectHandler deletes a project by ID
-func DeleteProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
+// DeleteProjectHandler deletes a project by ID. Projects with simulations are
+// only deleted with ?cascade=true, which first deletes each simulation (log
+// files, per-simulation database and document). A cascade continues past
+// failures and reports them; the project is kept while any simulation is left.
+func DeleteProjectHandler(
+	projects repository.ProjectRepo, simulations repository.SimulationRepo, store storage.Storage, queue *processing.Queue, quotas *quota.Quota, uploadDir string,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		projectID := c.Param("projectId")
 		objectID, err := primitive.ObjectIDFromHex(projectID)
@@ -163,17 +444,62 @@ func DeleteProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+		ctx := context.Background()
+		_, err = projects.Get(ctx, objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		projectSimulations, err := simulations.List(ctx, repository.SimulationFilter{ProjectID: objectID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
+		if len(projectSimulations) > 0 && c.Query("cascade") != "true" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":       "Project has simulations, delete them first or use ?cascade=true",
+				"simulations": len(projectSimulations),
+			})
+			return
+		}
+
+		summary := types.ProjectDeletionSummary{}
+		remaining := 0
+		for _, simulation := range projectSimulations {
+			deletion, err := deleteSimulation(ctx, simulations, store, queue, quotas, uploadDir, simulation, true)
+			for _, failure := range deletion.Failures {
+				summary.Failures = append(summary.Failures, fmt.Sprintf("simulation %s: %s", simulation.ID.Hex(), failure))
+			}
+			if err != nil {
+				summary.Failures = append(summary.Failures, fmt.Sprintf("simulation %s: %v", simulation.ID.Hex(), err))
+				remaining++
+				continue
+			}
+			if deletion.Deleted {
+				summary.Simulations++
+				summary.FreedBytes += deletion.FreedBytes
+			}
+		}
+		if remaining > 0 {
+			c.JSON(http.StatusInternalServerError, summary)
+			return
+		}
 
-		if result.DeletedCount == 0 {
+		err = projects.Delete(ctx, objectID)
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 			return
+		} else if err != nil {
+			summary.Failures = append(summary.Failures, fmt.Sprintf("project: %v", err))
+			c.JSON(http.StatusInternalServerError, summary)
+			return
 		}
+		summary.ProjectDeleted = true
 
-		c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
+		c.JSON(http.StatusOK, summary)
 	}
 }
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seedProject stores a project of userID named name
+func seedProject(t *testing.T, projects repository.ProjectRepo, userID primitive.ObjectID, name, description string) types.Project {
+	t.Helper()
+	project := types.Project{Name: name, Description: description, UserID: userID, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := projects.Create(context.Background(), &project); err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+// seedSimulation stores a simulation of project in status with a log file of
+// size bytes for each of logFiles
+func seedSimulation(
+	t *testing.T, simulations repository.SimulationRepo, project types.Project, name string, status types.SimulationStatus, size int64, logFiles ...string,
+) types.Simulation {
+	t.Helper()
+	simulation := types.Simulation{
+		Name:      name,
+		ProjectID: project.ID,
+		UserID:    project.UserID,
+		Status:    status,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	for _, logFile := range logFiles {
+		simulation.LogFiles = append(simulation.LogFiles, types.LogFileInfo{
+			OriginalFilename: logFile, FilePath: "/logs/" + logFile, StorageKey: name + "/" + logFile, FileSize: size,
+		})
+	}
+	if err := simulations.Create(context.Background(), &simulation); err != nil {
+		t.Fatal(err)
+	}
+	return simulation
+}
+
+func TestCreateProjectHandler(t *testing.T) {
+	projects := repository.NewMemoryProjectRepo()
+	userID := primitive.NewObjectID()
+
+	status, body := serve(t, CreateProjectHandler(projects), http.MethodPost, "/users/:userId/projects",
+		"/users/"+userID.Hex()+"/projects", `{"name":"testnet","description":"four validators"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %v", status, http.StatusCreated, body)
+	}
+
+	id, err := primitive.ObjectIDFromHex(body["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := projects.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "testnet" || stored.UserID != userID {
+		t.Errorf("stored project = %+v, want testnet of user %s", stored, userID.Hex())
+	}
+}
+
+func TestGetProjectHandler(t *testing.T) {
+	projects := repository.NewMemoryProjectRepo()
+	project := seedProject(t, projects, primitive.NewObjectID(), "testnet", "")
+
+	tests := []struct {
+		name       string
+		projectID  string
+		wantStatus int
+	}{
+		{name: "existing", projectID: project.ID.Hex(), wantStatus: http.StatusOK},
+		{name: "invalid ID", projectID: "testnet", wantStatus: http.StatusBadRequest},
+		{name: "unknown", projectID: primitive.NewObjectID().Hex(), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := serve(t, GetProjectHandler(projects), http.MethodGet, "/projects/:projectId", "/projects/"+tt.projectID, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status == http.StatusOK && body["name"] != "testnet" {
+				t.Errorf("body = %v, want testnet", body)
+			}
+		})
+	}
+}
+
+func TestUpdateProjectHandler(t *testing.T) {
+	projects := repository.NewMemoryProjectRepo()
+	project := seedProject(t, projects, primitive.NewObjectID(), "testnet", "four validators")
+
+	status, body := serve(t, UpdateProjectHandler(projects), http.MethodPut, "/projects/:projectId",
+		"/projects/"+project.ID.Hex(), `{"name":"mainnet"}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if body["name"] != "mainnet" || body["description"] != "four validators" {
+		t.Errorf("body = %v, want the new name and the old description", body)
+	}
+
+	status, body = serve(t, UpdateProjectHandler(projects), http.MethodPut, "/projects/:projectId",
+		"/projects/"+primitive.NewObjectID().Hex(), `{"name":"mainnet"}`)
+	if status != http.StatusNotFound {
+		t.Errorf("unknown project: status = %d, want %d: %v", status, http.StatusNotFound, body)
+	}
+}
+
+func TestGetProjectsByUserHandler(t *testing.T) {
+	projects := repository.NewMemoryProjectRepo()
+	simulations := repository.NewMemorySimulationRepo()
+	userID := primitive.NewObjectID()
+	testnet := seedProject(t, projects, userID, "testnet", "four validators")
+	seedProject(t, projects, userID, "devnet", "single Validator")
+	seedProject(t, projects, primitive.NewObjectID(), "other", "another user's validators")
+	seedSimulation(t, simulations, testnet, "run1", types.SimulationStatusProcessed, 100, "node0.log", "node1.log")
+	seedSimulation(t, simulations, testnet, "run2", types.SimulationStatusFailed, 50, "node0.log")
+
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string
+	}{
+		{name: "all projects of the user", wantNames: []string{"testnet", "devnet"}},
+		{name: "search in the name", query: "?q=TEST", wantNames: []string{"testnet"}},
+		{name: "search in the description", query: "?q=validator", wantNames: []string{"testnet", "devnet"}},
+		{name: "no match", query: "?q=mainnet", wantNames: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []types.Project
+			status := getJSON(t, GetProjectsByUserHandler(projects, simulations), "/users/:userId/projects", "/users/"+userID.Hex()+"/projects"+tt.query, &got)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want %d", status, http.StatusOK)
+			}
+			names := []string{}
+			for _, project := range got {
+				names = append(names, project.Name)
+			}
+			if len(names) != len(tt.wantNames) {
+				t.Fatalf("projects = %v, want %v", names, tt.wantNames)
+			}
+			for i := range names {
+				if names[i] != tt.wantNames[i] {
+					t.Errorf("projects = %v, want %v", names, tt.wantNames)
+				}
+			}
+		})
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		var got []types.ProjectWithStats
+		status := getJSON(t, GetProjectsByUserHandler(projects, simulations), "/users/:userId/projects", "/users/"+userID.Hex()+"/projects?includeStats=true", &got)
+		if status != http.StatusOK || len(got) != 2 {
+			t.Fatalf("status = %d with %d projects, want %d with 2", status, len(got), http.StatusOK)
+		}
+		stats := got[0].Stats
+		if stats.Simulations != 2 || stats.StoredBytes != 250 || stats.ByStatus[types.SimulationStatusProcessed] != 1 || stats.ByStatus[types.SimulationStatusFailed] != 1 {
+			t.Errorf("stats of testnet = %+v, want 2 simulations storing 250 bytes", stats)
+		}
+		if devnet := got[1].Stats; devnet.Simulations != 0 || devnet.LastSimulationAt != nil {
+			t.Errorf("stats of devnet = %+v, want none", devnet)
+		}
+	})
+}
+
+func TestDeleteProjectHandler(t *testing.T) {
+	// Log files are empty, so no storage is released from the unused quota
+	quotas := quota.New(nil, 0)
+
+	t.Run("simulations need a cascade", func(t *testing.T) {
+		projects := repository.NewMemoryProjectRepo()
+		simulations := repository.NewMemorySimulationRepo()
+		project := seedProject(t, projects, primitive.NewObjectID(), "testnet", "")
+		seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0, "node0.log")
+
+		handler := DeleteProjectHandler(projects, simulations, storage.NewLocalStorage(t.TempDir()), processing.NewQueue(), quotas, "")
+		status, body := serve(t, handler, http.MethodDelete, "/projects/:projectId", "/projects/"+project.ID.Hex(), "")
+		if status != http.StatusConflict {
+			t.Fatalf("status = %d, want %d: %v", status, http.StatusConflict, body)
+		}
+		if _, err := projects.Get(context.Background(), project.ID); err != nil {
+			t.Errorf("project was deleted: %v", err)
+		}
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		projects := repository.NewMemoryProjectRepo()
+		simulations := repository.NewMemorySimulationRepo()
+		project := seedProject(t, projects, primitive.NewObjectID(), "testnet", "")
+		run1 := seedSimulation(t, simulations, project, "run1", types.SimulationStatusProcessed, 0, "node0.log")
+		run2 := seedSimulation(t, simulations, project, "run2", types.SimulationStatusLogFileRequired, 0)
+
+		handler := DeleteProjectHandler(projects, simulations, storage.NewLocalStorage(t.TempDir()), processing.NewQueue(), quotas, "")
+		status, body := serve(t, handler, http.MethodDelete, "/projects/:projectId", "/projects/"+project.ID.Hex()+"?cascade=true", "")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %v", status, http.StatusOK, body)
+		}
+		if body["projectDeleted"] != true || body["simulations"] != float64(2) {
+			t.Errorf("summary = %v, want the project and 2 simulations deleted", body)
+		}
+		if _, err := projects.Get(context.Background(), project.ID); err != repository.ErrNotFound {
+			t.Errorf("project lookup = %v, want ErrNotFound", err)
+		}
+		if count, _ := simulations.Count(context.Background(), repository.SimulationFilter{ProjectID: project.ID}); count != 0 {
+			t.Errorf("%d simulations left, want 0", count)
+		}
+		if !simulations.Dropped(run1.ID) || !simulations.Dropped(run2.ID) {
+			t.Error("per-simulation databases were not dropped")
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/retention"
+	"github.com/gin-gonic/gin"
+)
+
+// RunRetentionHandler runs the retention janitor now and reports what it pruned.
+// Answers 409 while a run is in progress.
+func RunRetentionHandler(janitor *retention.Janitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		summary, err := janitor.Run(context.Background())
+		if errors.Is(err, retention.ErrRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Retention run failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db"
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/simarchive"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportSimulationHandler streams a processed simulation as a tar.gz archive
+// (see simarchive) that ImportSimulationHandler restores, e.g. on another
+// deployment. The log files are included with includeLogs=true.
+func ExportSimulationHandler(client *mongo.Client, simulations repository.SimulationRepo, store storage.Storage, uploadDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulation, ok := loadSimulation(c, simulations)
+		if !ok {
+			return
+		}
+		if simulation.Status != types.SimulationStatusProcessed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only processed simulations can be exported", "status": simulation.Status})
+			return
+		}
+
+		var logStore storage.Storage
+		var logKeys []string
+		if c.Query("includeLogs") == "true" {
+			logStore = store
+			for _, logFile := range simulation.LogFiles {
+				key := logFileKey(logFile, uploadDir)
+				if key == "" {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Log file is not in storage", "file": logFile.OriginalFilename})
+					return
+				}
+				logKeys = append(logKeys, key)
+			}
+		}
+
+		filename := exportFilename(simulation.Name) + ".simulation.tar.gz"
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		c.Header("Content-Type", "application/gzip")
+		c.Status(http.StatusOK)
+
+		// Headers are already sent, so failures can only truncate the download,
+		// which import rejects as a corrupt archive
+		database := client.Database(simulation.ID.Hex())
+		if err := simarchive.Export(c.Request.Context(), c.Writer, database, *simulation, logStore, logKeys); err != nil {
+			fmt.Printf("Warning: Failed to export simulation %s: %v\n", simulation.ID.Hex(), err)
+		}
+	}
+}
+
+// ImportSimulationHandler creates a simulation in a project of the user from
+// an archive written by ExportSimulationHandler, sent as the request body or
+// as the multipart field archive. The simulation gets a new ID and its
+// collections are restored into a new per-simulation database. Log files are
+// staged in uploadDir.
+func ImportSimulationHandler(
+	client *mongo.Client, simulations repository.SimulationRepo, projects repository.ProjectRepo, store storage.Storage, quotas *quota.Quota, limits logupload.Limits, uploadDir string,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userObjectID, projectObjectID, ok := loadUserProject(c, projects)
+		if !ok {
+			return
+		}
+
+		logupload.LimitRequestBody(c, limits)
+		archive, err := importArchiveReader(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		simulationID := primitive.NewObjectID()
+		database := client.Database(simulationID.Hex())
+		imported, err := simarchive.Import(c.Request.Context(), archive, database, uploadDir, limits)
+		var uploadErr *logupload.UploadError
+		switch {
+		case err == nil:
+		case logupload.IsRequestTooLarge(err):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request exceeds maximum upload size", "maxBytes": limits.MaxRequestBytes})
+			return
+		case errors.Is(err, simarchive.ErrInvalidArchive), errors.Is(err, simarchive.ErrUnsupportedVersion):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.As(err, &uploadErr):
+			respondUploadError(c, err, limits)
+			return
+		default:
+			fmt.Printf("Warning: Failed to import simulation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import simulation"})
+			return
+		}
+
+		// Reserve storage quota for the included log files
+		importedBytes := totalFileSize(imported.LogFiles)
+		if err := quotas.Reserve(context.Background(), userObjectID, importedBytes); err != nil {
+			removeLogFiles(imported.LogFiles)
+			database.Drop(context.Background())
+			respondQuotaError(c, err)
+			return
+		}
+
+		exported := imported.Simulation
+		simulation := types.Simulation{
+			ID:               simulationID,
+			Name:             exported.Name,
+			Description:      exported.Description,
+			ProjectID:        projectObjectID,
+			UserID:           userObjectID,
+			Status:           exported.Status,
+			ProcessingStatus: exported.ProcessingStatus,
+			ProcessingResult: exported.ProcessingResult,
+			Priority:         exported.Priority,
+			DataPruned:       exported.DataPruned,
+			DataPrunedAt:     exported.DataPrunedAt,
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
+		}
+		if err := createSimulation(context.Background(), simulations, store, &simulation, imported.LogFiles); err != nil {
+			quotas.Release(context.Background(), userObjectID, importedBytes)
+			database.Drop(context.Background())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create simulation"})
+			return
+		}
+
+		if err := db.EnsureSimulationIndexes(context.Background(), database); err != nil {
+			fmt.Printf("Warning: Failed to create indexes of imported simulation %s: %v\n", simulation.ID.Hex(), err)
+		}
+
+		response := simulation.ToResponse()
+		response.ImportedFrom = imported.Manifest.SimulationID
+		response.ImportedDocuments = imported.Documents
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// importArchiveReader returns the archive of an import request: the multipart
+// field archive, streamed without buffering, or else the request body
+func importArchiveReader(c *gin.Context) (io.Reader, error) {
+	if c.ContentType() != "multipart/form-data" {
+		return c.Request.Body, nil
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, errors.New("Failed to parse multipart form")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("archive is required")
+		} else if err != nil {
+			return nil, errors.New("Failed to parse multipart form")
+		}
+		if part.FormName() == "archive" {
+			return part, nil
+		}
+	}
+}
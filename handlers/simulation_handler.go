@@ -2,46 +2,68 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bft-labs/cometbft-analyzer-backend/db"
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
 	"github.com/bft-labs/cometbft-analyzer-backend/types"
 	"github.com/bft-labs/cometbft-analyzer-backend/utils"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// CreateSimulationHandler creates a new simulation
-func CreateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
+// CreateSimulationHandler creates a new simulation in a project of the user.
+// Uploaded log files are staged in uploadDir.
+func CreateSimulationHandler(
+	simulations repository.SimulationRepo, projects repository.ProjectRepo, store storage.Storage, quotas *quota.Quota, queue *processing.Queue, limits logupload.Limits, uploadDir string,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		projectID := c.Param("projectId")
-		projectObjectID, err := primitive.ObjectIDFromHex(projectID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		// The project must exist and belong to the user before any upload is read
+		userObjectID, projectObjectID, ok := loadUserProject(c, projects)
+		if !ok {
 			return
 		}
 
-		userID := c.Param("userId")
-		userObjectID, err := primitive.ObjectIDFromHex(userID)
+		skipDuplicates, err := parseDuplicatesMode(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
 		// Check if this is multipart form data (with potential file upload)
-		contentType := c.GetHeader("Content-Type")
 		var req types.CreateSimulationRequest
 		var logFiles []types.LogFileInfo
 
-		if contentType != "" && contentType[:19] == "multipart/form-data" {
+		if utils.IsMultipartForm(c) {
 			// Handle multipart form data
+			logupload.LimitRequestBody(c, limits)
+			form, err := c.MultipartForm()
+			if err != nil {
+				if logupload.IsRequestTooLarge(err) {
+					c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request exceeds maximum upload size", "maxBytes": limits.MaxRequestBytes})
+					return
+				}
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
+				return
+			}
+
 			req.Name = c.PostForm("name")
 			req.Description = c.PostForm("description")
 
@@ -50,66 +72,20 @@ func CreateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 				return
 			}
 
-			// Handle multiple log file uploads
-			form, err := c.MultipartForm()
-			if err == nil && form.File["logfiles"] != nil {
-				files := form.File["logfiles"]
-				for i, fileHeader := range files {
-					// Open the file
-					file, err := fileHeader.Open()
-					if err != nil {
-						// Clean up previously uploaded files
-						for _, logFile := range logFiles {
-							os.Remove(logFile.FilePath)
-						}
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
-						return
-					}
-					defer file.Close()
-
-					// Generate temporary filename (will be updated after simulation creation)
-					tempFilename := fmt.Sprintf("temp_%d_%d_%s", time.Now().UnixNano(), i, fileHeader.Filename)
-					filePath := filepath.Join("uploads", tempFilename)
-
-					// Ensure temp directory exists
-					if err := os.MkdirAll("uploads", 0755); err != nil {
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create uploads directory"})
-						return
-					}
-
-					// Create destination file
-					dst, err := os.Create(filePath)
-					if err != nil {
-						// Clean up previously uploaded files
-						for _, logFile := range logFiles {
-							os.Remove(logFile.FilePath)
-						}
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
-						return
-					}
-
-					// Copy file content
-					if _, err := io.Copy(dst, file); err != nil {
-						dst.Close()
-						// Clean up all uploaded files including current one
-						os.Remove(filePath)
-						for _, logFile := range logFiles {
-							os.Remove(logFile.FilePath)
-						}
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-						return
-					}
-					dst.Close()
-
-					// Create LogFileInfo with metadata
-					logFileInfo := types.LogFileInfo{
-						OriginalFilename: fileHeader.Filename,
-						FilePath:         filePath,
-						FileSize:         fileHeader.Size,
-						UploadedAt:       time.Now(),
-					}
-					logFiles = append(logFiles, logFileInfo)
+			if priorityStr := c.PostForm("priority"); priorityStr != "" {
+				priority, err := strconv.Atoi(priorityStr)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be an integer"})
+					return
 				}
+				req.Priority = priority
+			}
+
+			// Handle multiple log file uploads and archives of log files
+			logFiles, err = logupload.SaveUploadedFiles(uploadDir, form.File["logfiles"], form.File["archive"], limits)
+			if err != nil {
+				respondUploadError(c, err, limits)
+				return
 			}
 		} else {
 			// Handle JSON request
@@ -119,6 +95,26 @@ func CreateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			}
 		}
 
+		// Drop or reject files uploaded more than once in the same request
+		logFiles, duplicates := findDuplicateLogFiles(nil, logFiles)
+		if len(duplicates) > 0 && !skipDuplicates {
+			removeLogFiles(logFiles)
+			removeDuplicateLogFiles(duplicates)
+			respondDuplicateLogFile(c, duplicates[0])
+			return
+		}
+		removeDuplicateLogFiles(duplicates)
+
+		// Reserve storage quota for the uploaded files
+		uploadedBytes := totalFileSize(logFiles)
+		if len(logFiles) > 0 {
+			if err := quotas.Reserve(context.Background(), userObjectID, uploadedBytes); err != nil {
+				removeLogFiles(logFiles)
+				respondQuotaError(c, err)
+				return
+			}
+		}
+
 		// Determine initial status based on log files
 		var initialStatus types.SimulationStatus
 		var initialProcessingStatus types.ProcessingStatus
@@ -135,78 +131,98 @@ func CreateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			Description:      req.Description,
 			ProjectID:        projectObjectID,
 			UserID:           userObjectID,
-			LogFiles:         logFiles,
 			Status:           initialStatus,
 			ProcessingStatus: initialProcessingStatus,
+			Priority:         req.Priority,
 			CreatedAt:        time.Now(),
 			UpdatedAt:        time.Now(),
 		}
 
-		result, err := collection.InsertOne(context.Background(), simulation)
-		if err != nil {
-			// Clean up uploaded files if database insert fails
-			for _, logFile := range logFiles {
-				os.Remove(logFile.FilePath)
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		if err := createSimulation(context.Background(), simulations, store, &simulation, logFiles); err != nil {
+			quotas.Release(context.Background(), userObjectID, uploadedBytes)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create simulation"})
 			return
 		}
 
-		simulation.ID = result.InsertedID.(primitive.ObjectID)
+		// If files were uploaded during creation, queue processing automatically
+		if len(simulation.LogFiles) > 0 && simulation.Status == types.SimulationStatusProcessing {
+			queue.Enqueue(simulation.ID, simulation.Priority)
+		}
 
-		// Move uploaded files to simulation directory
-		if len(logFiles) > 0 {
-			simulationDir, err := utils.EnsureSimulationDir(userObjectID, projectObjectID, simulation.ID)
-			if err != nil {
-				// Clean up temp files and fail
-				for _, logFile := range logFiles {
-					os.Remove(logFile.FilePath)
-				}
-				collection.DeleteOne(context.Background(), bson.M{"_id": simulation.ID})
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create simulation directory"})
-				return
-			}
+		response := simulation.ToResponse()
+		response.SkippedDuplicates = duplicates
+		c.JSON(http.StatusCreated, response)
+	}
+}
 
-			var updatedLogFiles []types.LogFileInfo
-			for i, logFile := range logFiles {
-				filename := fmt.Sprintf("%d_%s", i, logFile.OriginalFilename)
-				newFilePath := filepath.Join(simulationDir, filename)
-
-				if err := os.Rename(logFile.FilePath, newFilePath); err == nil {
-					// Update LogFileInfo with new path
-					updatedLogFile := logFile
-					updatedLogFile.FilePath = newFilePath
-					updatedLogFiles = append(updatedLogFiles, updatedLogFile)
-				} else {
-					// Clean up on failure
-					for _, updatedFile := range updatedLogFiles {
-						os.Remove(updatedFile.FilePath)
-					}
-					os.Remove(logFile.FilePath)
-					collection.DeleteOne(context.Background(), bson.M{"_id": simulation.ID})
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move files to simulation directory"})
-					return
-				}
-			}
+// loadUserProject checks that the :projectId project exists and belongs to the
+// :userId user, writing an error response if not
+func loadUserProject(c *gin.Context, projects repository.ProjectRepo) (userID, projectID primitive.ObjectID, ok bool) {
+	projectID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return userID, projectID, false
+	}
 
-			// Update simulation with new file info
-			simulation.LogFiles = updatedLogFiles
-			collection.UpdateOne(context.Background(), bson.M{"_id": simulation.ID}, bson.M{
-				"$set": bson.M{"logFiles": updatedLogFiles},
-			})
+	userID, err = primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return userID, projectID, false
+	}
 
-			// If files were uploaded during creation, start processing automatically
-			if len(updatedLogFiles) > 0 && simulation.Status == types.SimulationStatusProcessing {
-				go processSimulationLogs(collection, simulation)
-			}
+	project, err := projects.Get(context.Background(), projectID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return userID, projectID, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return userID, projectID, false
+	}
+	if project.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Project does not belong to the user"})
+		return userID, projectID, false
+	}
+	return userID, projectID, true
+}
+
+// createSimulation stores the staged log files under a simulation ID generated
+// up front, unless the simulation already has one, and only then inserts the
+// simulation document with the stored files.
+// A single insert is atomic on any deployment, so a document never references
+// files that were not stored, without needing a transaction. Each failing step
+// rolls back the previous ones: a failed store removes the staged and already
+// stored files, a failed insert the stored files and the simulation directory.
+// A crash between the steps can only leave files without a document.
+func createSimulation(
+	ctx context.Context, simulations repository.SimulationRepo, store storage.Storage, simulation *types.Simulation, staged []types.LogFileInfo,
+) error {
+	if simulation.ID.IsZero() {
+		simulation.ID = primitive.NewObjectID()
+	}
+	prefix := utils.GetSimulationKey(simulation.UserID, simulation.ProjectID, simulation.ID)
+
+	if len(staged) > 0 {
+		stored, err := storeLogFiles(store, simulation, staged)
+		if err != nil {
+			store.RemoveDir(ctx, prefix)
+			return fmt.Errorf("failed to store log files: %w", err)
 		}
+		simulation.LogFiles = stored
+	}
 
-		c.JSON(http.StatusCreated, simulation.ToResponse())
+	if err := simulations.Create(ctx, simulation); err != nil {
+		deleteStoredLogFiles(store, simulation.LogFiles)
+		if len(staged) > 0 {
+			store.RemoveDir(ctx, prefix)
+		}
+		simulation.LogFiles = nil
+		return fmt.Errorf("failed to insert simulation: %w", err)
 	}
+	return nil
 }
 
 // GetSimulationHandler retrieves a simulation by ID
-func GetSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
+func GetSimulationHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		simulationID := c.Param("id")
 		objectID, err := primitive.ObjectIDFromHex(simulationID)
@@ -215,9 +231,8 @@ func GetSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		var simulation types.Simulation
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&simulation)
-		if err == mongo.ErrNoDocuments {
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 			return
 		} else if err != nil {
@@ -229,8 +244,27 @@ func GetSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 	}
 }
 
+// simulationListFilter adds the optional 'q' search and repeatable 'status' filters of the simulation lists to filter
+func simulationListFilter(c *gin.Context, filter repository.SimulationFilter) (repository.SimulationFilter, error) {
+	for _, status := range c.QueryArray("status") {
+		switch types.SimulationStatus(status) {
+		case types.SimulationStatusLogFileRequired, types.SimulationStatusProcessing,
+			types.SimulationStatusProcessed, types.SimulationStatusFailed:
+			filter.Statuses = append(filter.Statuses, types.SimulationStatus(status))
+		default:
+			return filter, fmt.Errorf("invalid status: %q", status)
+		}
+	}
+	search, err := utils.SearchFromContext(c)
+	if err != nil {
+		return filter, err
+	}
+	filter.Search = search
+	return filter, nil
+}
+
 // GetSimulationsByProjectHandler retrieves all simulations for a specific project
-func GetSimulationsByProjectHandler(collection *mongo.Collection) gin.HandlerFunc {
+func GetSimulationsByProjectHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		projectID := c.Param("projectId")
 		projectObjectID, err := primitive.ObjectIDFromHex(projectID)
@@ -239,22 +273,21 @@ func GetSimulationsByProjectHandler(collection *mongo.Collection) gin.HandlerFun
 			return
 		}
 
-		cursor, err := collection.Find(context.Background(), bson.M{"projectId": projectObjectID})
+		filter, err := simulationListFilter(c, repository.SimulationFilter{ProjectID: projectObjectID})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		defer cursor.Close(context.Background())
 
-		var simulations []types.Simulation
-		if err := cursor.All(context.Background(), &simulations); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode simulations"})
+		matching, err := simulations.List(context.Background(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
 
 		// Convert to response format
-		responses := make([]types.SimulationResponse, len(simulations))
-		for i, sim := range simulations {
+		responses := make([]types.SimulationResponse, len(matching))
+		for i, sim := range matching {
 			responses[i] = sim.ToResponse()
 		}
 
@@ -263,7 +296,7 @@ func GetSimulationsByProjectHandler(collection *mongo.Collection) gin.HandlerFun
 }
 
 // GetSimulationsByUserHandler retrieves all simulations for a specific user
-func GetSimulationsByUserHandler(collection *mongo.Collection) gin.HandlerFunc {
+func GetSimulationsByUserHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Param("userId")
 		userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -272,22 +305,21 @@ func GetSimulationsByUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		cursor, err := collection.Find(context.Background(), bson.M{"userId": userObjectID})
+		filter, err := simulationListFilter(c, repository.SimulationFilter{UserID: userObjectID})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		defer cursor.Close(context.Background())
 
-		var simulations []types.Simulation
-		if err := cursor.All(context.Background(), &simulations); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode simulations"})
+		matching, err := simulations.List(context.Background(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
 
 		// Convert to response format
-		responses := make([]types.SimulationResponse, len(simulations))
-		for i, sim := range simulations {
+		responses := make([]types.SimulationResponse, len(matching))
+		for i, sim := range matching {
 			responses[i] = sim.ToResponse()
 		}
 
@@ -296,7 +328,7 @@ func GetSimulationsByUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 }
 
 // UpdateSimulationHandler updates a simulation by ID
-func UpdateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
+func UpdateSimulationHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		simulationID := c.Param("id")
 		objectID, err := primitive.ObjectIDFromHex(simulationID)
@@ -311,34 +343,15 @@ func UpdateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		update := bson.M{
-			"$set": bson.M{
-				"updatedAt": time.Now(),
-			},
-		}
-
-		if req.Name != nil {
-			update["$set"].(bson.M)["name"] = *req.Name
-		}
-		if req.Description != nil {
-			update["$set"].(bson.M)["description"] = *req.Description
-		}
-
-		result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			return
-		}
-
-		if result.MatchedCount == 0 {
+		simulation, err := simulations.Update(context.Background(), objectID, repository.SimulationUpdate{
+			Name:        req.Name,
+			Description: req.Description,
+		})
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 			return
-		}
-
-		var simulation types.Simulation
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&simulation)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve updated simulation"})
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
 
@@ -347,7 +360,7 @@ func UpdateSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 }
 
 // DeleteSimulationHandler deletes a simulation by ID
-func DeleteSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
+func DeleteSimulationHandler(simulations repository.SimulationRepo, store storage.Storage, queue *processing.Queue, quotas *quota.Quota, uploadDir string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		simulationID := c.Param("id")
 		objectID, err := primitive.ObjectIDFromHex(simulationID)
@@ -357,9 +370,8 @@ func DeleteSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 		}
 
 		// Get simulation to check for log file
-		var simulation types.Simulation
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&simulation)
-		if err == mongo.ErrNoDocuments {
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 			return
 		} else if err != nil {
@@ -367,23 +379,17 @@ func DeleteSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		// Delete log files if they exist
-		for _, logFile := range simulation.LogFiles {
-			if logFile.FilePath != "" {
-				if err := os.Remove(logFile.FilePath); err != nil {
-					// Log error but don't fail the deletion
-					fmt.Printf("Failed to delete log file %s: %v\n", logFile.FilePath, err)
-				}
-			}
+		deletion, err := deleteSimulation(context.Background(), simulations, store, queue, quotas, uploadDir, simulation, false)
+		for _, failure := range deletion.Failures {
+			// Log error but don't fail the deletion
+			fmt.Printf("Failed to delete simulation %s: %s\n", simulation.ID.Hex(), failure)
 		}
-
-		result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
 
-		if result.DeletedCount == 0 {
+		if !deletion.Deleted {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 			return
 		}
@@ -392,8 +398,70 @@ func DeleteSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 	}
 }
 
-// UploadLogFileHandler uploads a log file for a simulation
-func UploadLogFileHandler(collection *mongo.Collection) gin.HandlerFunc {
+// simulationDeletion reports what deleteSimulation removed
+type simulationDeletion struct {
+	Deleted         bool     // The simulation document was deleted
+	LogFiles        int      // Log files removed from storage
+	FreedBytes      int64    // Size of the simulation's log files
+	DatabaseDropped bool     // The per-simulation database was dropped
+	Failures        []string // Cleanup steps that failed without stopping the deletion
+}
+
+// deleteSimulation deletes the document of a simulation, drops it from the
+// processing queue, deletes its log files and releases their storage from the
+// user's quota; quotas may be nil when the user is deleted as well. The document
+// goes first, so uploads and queued processing racing the deletion find the
+// simulation gone instead of adding files nothing refers to. With dropDatabase
+// the per-simulation database is dropped, otherwise only its metrics cache is
+// cleared. Failing cleanup steps are reported in Failures; err is only returned
+// when the document could not be deleted, in which case nothing else is.
+func deleteSimulation(
+	ctx context.Context, simulations repository.SimulationRepo, store storage.Storage, queue *processing.Queue, quotas *quota.Quota, uploadDir string,
+	simulation types.Simulation, dropDatabase bool,
+) (simulationDeletion, error) {
+	var deletion simulationDeletion
+
+	err := simulations.Delete(ctx, simulation.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return deletion, nil
+	} else if err != nil {
+		return deletion, err
+	}
+	deletion.Deleted = true
+	queue.Remove(simulation.ID)
+
+	logFiles := allLogFiles(simulation)
+	for _, logFile := range logFiles {
+		if key := logFileKey(logFile, uploadDir); key != "" {
+			if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				deletion.Failures = append(deletion.Failures, fmt.Sprintf("log file %s: %v", logFile.FilePath, err))
+				continue
+			}
+			deletion.LogFiles++
+		}
+	}
+	deletion.FreedBytes = totalFileSize(logFiles)
+
+	if dropDatabase {
+		if err := simulations.DropDatabase(ctx, simulation.ID); err != nil {
+			deletion.Failures = append(deletion.Failures, fmt.Sprintf("database: %v", err))
+		} else {
+			deletion.DatabaseDropped = true
+		}
+	} else if err := simulations.ClearMetricsCache(ctx, simulation.ID); err != nil {
+		deletion.Failures = append(deletion.Failures, fmt.Sprintf("metrics cache: %v", err))
+	}
+
+	if quotas != nil {
+		if err := quotas.Release(ctx, simulation.UserID, deletion.FreedBytes); err != nil {
+			deletion.Failures = append(deletion.Failures, fmt.Sprintf("storage usage of user %s: %v", simulation.UserID.Hex(), err))
+		}
+	}
+	return deletion, nil
+}
+
+// UploadLogFileHandler uploads a log file for a simulation, staging it in uploadDir
+func UploadLogFileHandler(simulations repository.SimulationRepo, store storage.Storage, quotas *quota.Quota, limits logupload.Limits, uploadDir string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		simulationID := c.Param("id")
 		objectID, err := primitive.ObjectIDFromHex(simulationID)
@@ -402,10 +470,22 @@ func UploadLogFileHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
+		skipDuplicates, err := parseDuplicatesMode(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// ?mode=queue stages uploads arriving during processing instead of rejecting them
+		mode := c.DefaultQuery("mode", "reject")
+		if mode != "reject" && mode != "queue" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be 'reject' or 'queue'"})
+			return
+		}
+
 		// Check if simulation exists
-		var simulation types.Simulation
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&simulation)
-		if err == mongo.ErrNoDocuments {
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 			return
 		} else if err != nil {
@@ -413,129 +493,150 @@ func UploadLogFileHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
+		if simulation.ProcessingStatus == types.ProcessingStatusProcessing && mode != "queue" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Processing in progress, retry later or upload with ?mode=queue"})
+			return
+		}
+
 		// Handle multiple log file uploads
+		logupload.LimitRequestBody(c, limits)
 		form, err := c.MultipartForm()
 		if err != nil {
+			if logupload.IsRequestTooLarge(err) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request exceeds maximum upload size", "maxBytes": limits.MaxRequestBytes})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
 			return
 		}
 
 		files := form.File["logfiles"]
-		if len(files) == 0 {
+		archives := form.File["archive"]
+		if len(files) == 0 && len(archives) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "No log files provided"})
 			return
 		}
 
-		// Get simulation directory
-		simulationDir, err := utils.EnsureSimulationDir(simulation.UserID, simulation.ProjectID, simulation.ID)
+		newLogFiles, err := logupload.SaveUploadedFiles(uploadDir, files, archives, limits)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create simulation directory"})
+			respondUploadError(c, err, limits)
 			return
 		}
 
-		var newLogFiles []types.LogFileInfo
-
-		// Process each uploaded file
-		for i, fileHeader := range files {
-			// Open the file
-			file, err := fileHeader.Open()
-			if err != nil {
-				// Clean up previously uploaded files
-				for _, logFile := range newLogFiles {
-					os.Remove(logFile.FilePath)
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
-				return
+		// Report which files were extracted from archives
+		extractedFileNames := []string{}
+		for _, logFile := range newLogFiles {
+			if logFile.Source != "" {
+				extractedFileNames = append(extractedFileNames, logFile.OriginalFilename)
 			}
-			defer file.Close()
-
-			// Generate unique filename
-			filename := fmt.Sprintf("%d_%s", len(simulation.LogFiles)+i, fileHeader.Filename)
-			filePath := filepath.Join(simulationDir, filename)
+		}
 
-			// Create destination file
-			dst, err := os.Create(filePath)
-			if err != nil {
-				// Clean up previously uploaded files
-				for _, logFile := range newLogFiles {
-					os.Remove(logFile.FilePath)
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
-				return
-			}
+		// Drop or reject files whose content was already uploaded to this simulation
+		newLogFiles, duplicates := findDuplicateLogFiles(allLogFiles(simulation), newLogFiles)
+		if len(duplicates) > 0 && !skipDuplicates {
+			removeLogFiles(newLogFiles)
+			removeDuplicateLogFiles(duplicates)
+			respondDuplicateLogFile(c, duplicates[0])
+			return
+		}
+		removeDuplicateLogFiles(duplicates)
 
-			// Copy file content
-			if _, err := io.Copy(dst, file); err != nil {
-				dst.Close()
-				// Clean up all uploaded files including current one
-				os.Remove(filePath)
-				for _, logFile := range newLogFiles {
-					os.Remove(logFile.FilePath)
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-				return
-			}
-			dst.Close()
-
-			// Create LogFileInfo with metadata
-			logFileInfo := types.LogFileInfo{
-				OriginalFilename: fileHeader.Filename,
-				FilePath:         filePath,
-				FileSize:         fileHeader.Size,
-				UploadedAt:       time.Now(),
-			}
-			newLogFiles = append(newLogFiles, logFileInfo)
+		// Reserve storage quota for the uploaded files
+		uploadedBytes := totalFileSize(newLogFiles)
+		if err := quotas.Reserve(context.Background(), simulation.UserID, uploadedBytes); err != nil {
+			removeLogFiles(newLogFiles)
+			respondQuotaError(c, err)
+			return
 		}
 
-		// Add new files to existing ones
-		allLogFiles := append(simulation.LogFiles, newLogFiles...)
+		// Move uploaded files to the simulation's storage location
+		newLogFiles, err = storeLogFiles(store, &simulation, newLogFiles)
+		if err != nil {
+			quotas.Release(context.Background(), simulation.UserID, uploadedBytes)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store log files"})
+			return
+		}
 
-		// Update status if this is the first upload
-		var newStatus types.SimulationStatus = simulation.Status
-		var newProcessingStatus types.ProcessingStatus = simulation.ProcessingStatus
+		// Create response with original filenames
+		uploadedFileNames := make([]string, len(newLogFiles))
+		for i, logFile := range newLogFiles {
+			uploadedFileNames[i] = logFile.OriginalFilename
+		}
 
-		if simulation.Status == types.SimulationStatusLogFileRequired && len(allLogFiles) > 0 {
-			newStatus = types.SimulationStatusProcessing
-			newProcessingStatus = types.ProcessingStatusPending
+		if duplicates == nil {
+			duplicates = []types.DuplicateLogFile{}
 		}
 
-		// Update simulation with new files and status
-		update := bson.M{
-			"$set": bson.M{
-				"logFiles":         allLogFiles,
-				"status":           newStatus,
-				"processingStatus": newProcessingStatus,
-				"updatedAt":        time.Now(),
-			},
+		// Stage files uploaded during a run; they are merged and reprocessed once it finishes
+		if simulation.ProcessingStatus == types.ProcessingStatusProcessing {
+			processing := types.ProcessingStatusProcessing
+			_, err := simulations.Update(context.Background(), objectID, repository.SimulationUpdate{
+				AddPendingLogFiles: newLogFiles,
+				IfProcessingStatus: &processing,
+			})
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				deleteStoredLogFiles(store, newLogFiles)
+				quotas.Release(context.Background(), simulation.UserID, uploadedBytes)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+
+			if err == nil {
+				c.JSON(http.StatusAccepted, gin.H{
+					"message":            "Processing in progress, log files will be added and processed once the current run finishes",
+					"pendingFiles":       len(newLogFiles),
+					"uploadedFileNames":  uploadedFileNames,
+					"extractedFileNames": extractedFileNames,
+					"skippedDuplicates":  duplicates,
+				})
+				return
+			}
+			// The run finished in the meantime, add the files directly
 		}
 
-		_, err = collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+		// Update status if this is the first upload, and add new files to
+		// existing ones unless processing started since the simulation was loaded
+		update := firstUploadUpdate(simulation)
+		update.AddLogFiles = newLogFiles
+		update.IfNotProcessing = true
+
+		_, err = simulations.Update(context.Background(), objectID, update)
 		if err != nil {
 			// Clean up uploaded files if database update fails
-			for _, logFile := range newLogFiles {
-				os.Remove(logFile.FilePath)
+			deleteStoredLogFiles(store, newLogFiles)
+			quotas.Release(context.Background(), simulation.UserID, uploadedBytes)
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Processing in progress, retry later or upload with ?mode=queue"})
+				return
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 			return
 		}
 
-		// Create response with original filenames
-		uploadedFileNames := make([]string, len(newLogFiles))
-		for i, logFile := range newLogFiles {
-			uploadedFileNames[i] = logFile.OriginalFilename
-		}
-
 		c.JSON(http.StatusOK, gin.H{
-			"message":           "Log files uploaded successfully",
-			"uploadedFiles":     len(newLogFiles),
-			"totalFiles":        len(allLogFiles),
-			"uploadedFileNames": uploadedFileNames,
+			"message":            "Log files uploaded successfully",
+			"uploadedFiles":      len(newLogFiles),
+			"totalFiles":         len(simulation.LogFiles) + len(newLogFiles),
+			"uploadedFileNames":  uploadedFileNames,
+			"extractedFileNames": extractedFileNames,
+			"skippedDuplicates":  duplicates,
 		})
 	}
 }
 
-// ProcessSimulationHandler processes log files for a simulation
-func ProcessSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
+// firstUploadUpdate returns the update of a simulation receiving log files,
+// which waits for processing if these are its first ones
+func firstUploadUpdate(simulation types.Simulation) repository.SimulationUpdate {
+	if simulation.Status != types.SimulationStatusLogFileRequired {
+		return repository.SimulationUpdate{}
+	}
+	status := types.SimulationStatusProcessing
+	processingStatus := types.ProcessingStatusPending
+	return repository.SimulationUpdate{Status: &status, ProcessingStatus: &processingStatus}
+}
+
+// ProcessSimulationHandler queues log files of a simulation for processing
+func ProcessSimulationHandler(simulations repository.SimulationRepo, queue *processing.Queue) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		simulationID := c.Param("id")
 		objectID, err := primitive.ObjectIDFromHex(simulationID)
@@ -544,10 +645,16 @@ func ProcessSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
+		// The request body is optional; an empty body keeps the stored priority
+		var req types.ProcessSimulationRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Check if simulation exists
-		var simulation types.Simulation
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&simulation)
-		if err == mongo.ErrNoDocuments {
+		simulation, err := simulations.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 			return
 		} else if err != nil {
@@ -561,111 +668,145 @@ func ProcessSimulationHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		// Check if already processing
+		// Check if already processing or waiting in the queue
 		if simulation.ProcessingStatus == types.ProcessingStatusProcessing {
 			c.JSON(http.StatusConflict, gin.H{"error": "Simulation is already being processed"})
 			return
 		}
+		if queue.Contains(objectID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Simulation is already queued for processing"})
+			return
+		}
 
-		// Update status to processing
-		update := bson.M{
-			"$set": bson.M{
-				"processingStatus": types.ProcessingStatusProcessing,
-				"updatedAt":        time.Now(),
-			},
+		priority := simulation.Priority
+		if req.Priority != nil {
+			priority = *req.Priority
 		}
-		_, err = collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+
+		// Update status to pending until a worker picks the simulation up
+		pending := types.ProcessingStatusPending
+		_, err = simulations.Update(context.Background(), objectID, repository.SimulationUpdate{ProcessingStatus: &pending, Priority: &priority})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update processing status"})
 			return
 		}
 
-		// Process asynchronously
-		go func() {
-			startTime := time.Now()
-			processSimulationAsync(collection, simulation)
-			processingTime := time.Since(startTime).Milliseconds()
-
-			// Get simulation directory for cometbft-log-etl
-			simulationDir := utils.GetSimulationDir(simulation.UserID, simulation.ProjectID, simulation.ID)
-
-			// Execute cometbft-log-etl with simulation ID
-			cmd := exec.Command("cometbft-log-etl", "-dir", simulationDir, "-simulation", simulation.ID.Hex())
-			err := cmd.Run()
+		if !queue.Enqueue(objectID, priority) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Simulation is already queued for processing"})
+			return
+		}
 
-			var processingResult types.ProcessingResult
-			var status types.ProcessingStatus
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":      "Simulation queued for processing",
+			"simulationId": simulationID,
+			"status":       types.ProcessingStatusPending,
+			"priority":     priority,
+		})
+	}
+}
 
-			var simulationStatus types.SimulationStatus
+// ProcessQueuedSimulation returns the worker function that processes jobs taken
+// from the processing queue by running etlBinary, which writes the per-simulation
+// databases of client; see logFileKey for uploadDir
+func ProcessQueuedSimulation(
+	simulations repository.SimulationRepo, client *mongo.Client, store storage.Storage, queue *processing.Queue, etlBinary, uploadDir string,
+) func(context.Context, processing.Job) {
+	return func(ctx context.Context, job processing.Job) {
+		simulation, err := simulations.Get(context.Background(), job.SimulationID)
+		if err != nil {
+			// The simulation may have been deleted while it was waiting in the queue
+			fmt.Printf("Skipping queued simulation %s: %v\n", job.SimulationID.Hex(), err)
+			return
+		}
 
-			if err != nil {
-				// Processing failed
-				status = types.ProcessingStatusFailed
-				simulationStatus = types.SimulationStatusFailed
-				processingResult = types.ProcessingResult{
-					ProcessedFiles: 0,
-					TotalFiles:     simulation.LogFileCount(),
-					ProcessingTime: processingTime,
-					ErrorMessage:   fmt.Sprintf("Parser execution failed: %v"),
-					ProcessedAt:    time.Now(),
-				}
-			} else {
-				// Processing succeeded
-				status = types.ProcessingStatusCompleted
-				simulationStatus = types.SimulationStatusProcessed
-				processingResult = types.ProcessingResult{
-					ProcessedFiles: simulation.LogFileCount(),
-					TotalFiles:     simulation.LogFileCount(),
-					ProcessingTime: processingTime,
-					ProcessedAt:    time.Now(),
-				}
+		processSimulationLogs(ctx, simulations, client, store, etlBinary, uploadDir, simulation)
+		if ctx.Err() != nil {
+			// Files uploaded during the run stay pending until the simulation is processed again
+			return
+		}
+		mergePendingLogFiles(simulations, queue, simulation)
+	}
+}
 
-				// Create processed directory for future output files
-				_, dirErr := utils.EnsureProcessedDir(simulation.UserID, simulation.ProjectID, simulation.ID)
-				if dirErr != nil {
-					fmt.Printf("Warning: Failed to create processed directory: %v\n", dirErr)
-				}
-			}
+// interruptedMessage is the error reported for simulations whose processing was cut short by a shutdown
+const interruptedMessage = "Processing was interrupted by a server shutdown, process the simulation again."
+
+// InterruptQueuedSimulations marks the simulations of jobs that never ran
+// before a shutdown as failed, so they are not left pending
+func InterruptQueuedSimulations(simulations repository.SimulationRepo, jobs []processing.Job) {
+	status := types.SimulationStatusFailed
+	failed := types.ProcessingStatusFailed
+	pending := types.ProcessingStatusPending
+	for _, job := range jobs {
+		update := repository.SimulationUpdate{
+			Status:             &status,
+			ProcessingStatus:   &failed,
+			ProcessingResult:   &types.ProcessingResult{ErrorMessage: interruptedMessage, ProcessedAt: time.Now()},
+			IfProcessingStatus: &pending,
+		}
+		_, err := simulations.Update(context.Background(), job.SimulationID, update)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			fmt.Printf("Failed to mark simulation %s as interrupted: %v\n", job.SimulationID.Hex(), err)
+		}
+	}
+}
 
-			// Update simulation with final result
-			finalUpdate := bson.M{
-				"$set": bson.M{
-					"status":           simulationStatus,
-					"processingStatus": status,
-					"processingResult": processingResult,
-					"updatedAt":        time.Now(),
-				},
-			}
-			collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, finalUpdate)
-		}()
+// mergePendingLogFiles adds log files uploaded during the finished run to the
+// simulation and queues it for processing again
+func mergePendingLogFiles(simulations repository.SimulationRepo, queue *processing.Queue, simulation types.Simulation) {
+	pending, err := simulations.TakePendingLogFiles(context.Background(), simulation.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return
+	} else if err != nil {
+		fmt.Printf("Failed to merge pending log files of simulation %s: %v\n", simulation.ID.Hex(), err)
+		return
+	}
 
-		c.JSON(http.StatusAccepted, gin.H{
-			"message":      "Simulation processing started",
-			"simulationId": simulationID,
-			"status":       "processing",
-		})
+	status := types.SimulationStatusProcessing
+	processingStatus := types.ProcessingStatusPending
+	_, err = simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{
+		AddLogFiles:      pending.PendingLogFiles,
+		Status:           &status,
+		ProcessingStatus: &processingStatus,
+	})
+	if err != nil {
+		fmt.Printf("Failed to merge pending log files of simulation %s: %v\n", simulation.ID.Hex(), err)
+		return
 	}
+
+	queue.Enqueue(simulation.ID, pending.Priority)
 }
 
-// processSimulationLogs processes log files for a simulation
-func processSimulationLogs(collection *mongo.Collection, simulation types.Simulation) {
+// processSimulationLogs processes log files for a simulation with etlBinary.
+// Canceling ctx kills the ETL and records the run as interrupted.
+func processSimulationLogs(
+	ctx context.Context, simulations repository.SimulationRepo, client *mongo.Client, store storage.Storage, etlBinary, uploadDir string, simulation types.Simulation,
+) {
 	startTime := time.Now()
 
 	// Update status to processing
-	update := bson.M{
-		"$set": bson.M{
-			"processingStatus": types.ProcessingStatusProcessing,
-			"updatedAt":        time.Now(),
-		},
-	}
-	collection.UpdateOne(context.Background(), bson.M{"_id": simulation.ID}, update)
+	processing := types.ProcessingStatusProcessing
+	simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{ProcessingStatus: &processing})
 
-	// Get simulation directory for cometbft-log-etl
-	simulationDir := utils.GetSimulationDir(simulation.UserID, simulation.ProjectID, simulation.ID)
+	// Cached metrics describe the previous processing run
+	simulationDB := client.Database(simulation.ID.Hex())
+	if err := simulations.ClearMetricsCache(context.Background(), simulation.ID); err != nil {
+		fmt.Printf("Warning: Failed to clear metrics cache: %v\n", err)
+	}
 
-	// Execute cometbft-log-etl with simulation ID
-	cmd := exec.Command("cometbft-log-etl", "-dir", simulationDir, "-simulation", simulation.ID.Hex())
-	err := cmd.Run()
+	// Make the log files available in a local directory for cometbft-log-etl
+	keys := make([]string, len(simulation.LogFiles))
+	for i, logFile := range simulation.LogFiles {
+		keys[i] = logFileKey(logFile, uploadDir)
+	}
+	prefix := utils.GetSimulationKey(simulation.UserID, simulation.ProjectID, simulation.ID)
+	simulationDir, cleanup, err := store.Materialize(ctx, prefix, keys)
+	if err == nil {
+		// Execute cometbft-log-etl with simulation ID
+		cmd := exec.CommandContext(ctx, etlBinary, "-dir", simulationDir, "-simulation", simulation.ID.Hex())
+		err = cmd.Run()
+		cleanup()
+	}
 
 	var processingResult types.ProcessingResult
 	var status types.ProcessingStatus
@@ -683,6 +824,9 @@ func processSimulationLogs(collection *mongo.Collection, simulation types.Simula
 			ErrorMessage:   fmt.Sprintf("Parser execution failed: %v.", err),
 			ProcessedAt:    time.Now(),
 		}
+		if ctx.Err() != nil {
+			processingResult.ErrorMessage = interruptedMessage
+		}
 	} else {
 		// Processing succeeded
 		status = types.ProcessingStatusCompleted
@@ -695,26 +839,257 @@ func processSimulationLogs(collection *mongo.Collection, simulation types.Simula
 		}
 
 		// Create processed directory for future output files
-		_, dirErr := utils.EnsureProcessedDir(simulation.UserID, simulation.ProjectID, simulation.ID)
+		_, dirErr := utils.EnsureProcessedDir(store, simulation.UserID, simulation.ProjectID, simulation.ID)
 		if dirErr != nil {
 			fmt.Printf("Warning: Failed to create processed directory: %v\n", dirErr)
 		}
+
+		// Index the collections written by the ETL for the events API and metrics
+		if indexErr := db.EnsureSimulationIndexes(context.Background(), simulationDB); indexErr != nil {
+			fmt.Printf("Warning: Failed to create simulation indexes: %v\n", indexErr)
+		}
+
+		// Derive the peer and validator mappings and detect incidents
+		storeDerivedData(simulationDB)
+	}
+
+	// Update simulation with final result. The ETL rewrote the raw event
+	// collections, so data pruned by the retention janitor is back.
+	simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{
+		Status:           &simulationStatus,
+		ProcessingStatus: &status,
+		ProcessingResult: &processingResult,
+		ClearDataPruned:  true,
+	})
+
+	// Drop metrics cached from partial results while processing
+	if err := simulations.ClearMetricsCache(context.Background(), simulation.ID); err != nil {
+		fmt.Printf("Warning: Failed to clear metrics cache: %v\n", err)
+	}
+}
+
+// totalFileSize sums the sizes of the given log files
+func totalFileSize(logFiles []types.LogFileInfo) int64 {
+	var total int64
+	for _, logFile := range logFiles {
+		total += logFile.FileSize
+	}
+	return total
+}
+
+// respondQuotaError maps errors from reserving storage quota to an HTTP response
+func respondQuotaError(c *gin.Context, err error) {
+	var exceeded *quota.ExceededError
+	switch {
+	case errors.As(err, &exceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":          "Upload exceeds storage quota",
+			"quotaBytes":     exceeded.QuotaBytes,
+			"usedBytes":      exceeded.UsedBytes,
+			"requestedBytes": exceeded.RequestedBytes,
+			"remainingBytes": exceeded.RemainingBytes(),
+		})
+	case errors.Is(err, quota.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 	}
+}
+
+// respondUploadError maps errors from writing an uploaded log file to an HTTP response naming the file
+func respondUploadError(c *gin.Context, err error, limits logupload.Limits) {
+	var filename string
+	var uploadErr *logupload.UploadError
+	if errors.As(err, &uploadErr) {
+		filename = uploadErr.Filename
+	}
+
+	var entryErr *logupload.ArchiveEntryError
+	if errors.As(err, &entryErr) {
+		// Report the offending archive entry, e.g. "logs.tar.gz:node0.log"
+		filename = filename + ":" + entryErr.Entry
+	}
+
+	switch {
+	case errors.Is(err, logupload.ErrArchiveTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "file": filename})
+	case errors.Is(err, logupload.ErrNotArchive), errors.Is(err, logupload.ErrNoLogFiles):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "file": filename})
+	case errors.Is(err, logupload.ErrUnsafeArchiveEntry):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Archive entry is a path traversal or not a regular file", "file": filename})
+	case errors.Is(err, logupload.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds maximum upload size", "file": filename, "maxBytes": limits.MaxFileBytes})
+	case errors.Is(err, logupload.ErrCorruptArchive):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Compressed file is corrupt", "file": filename})
+	case errors.Is(err, logupload.ErrNotLogFile):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "File does not look like a CometBFT log", "file": filename})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file", "file": filename})
+	}
+}
 
-	// Update simulation with final result
-	finalUpdate := bson.M{
-		"$set": bson.M{
-			"status":           simulationStatus,
-			"processingStatus": status,
-			"processingResult": processingResult,
-			"updatedAt":        time.Now(),
-		},
+// parseDuplicatesMode reads the ?duplicates= query parameter. Duplicate uploads are
+// rejected by default; "skip" drops them and reports them in the response instead.
+func parseDuplicatesMode(c *gin.Context) (bool, error) {
+	switch c.Query("duplicates") {
+	case "", "reject":
+		return false, nil
+	case "skip":
+		return true, nil
+	default:
+		return false, errors.New("duplicates must be 'reject' or 'skip'")
 	}
-	collection.UpdateOne(context.Background(), bson.M{"_id": simulation.ID}, finalUpdate)
 }
 
-// processSimulationAsync handles the async processing logic
-func processSimulationAsync(collection *mongo.Collection, simulation types.Simulation) {
-	// This function can be extended with additional processing logic
-	// For now, it's just a placeholder for the async processing workflow
+// allLogFiles returns the log files of simulation followed by those uploaded
+// during processing that are still pending
+func allLogFiles(simulation types.Simulation) []types.LogFileInfo {
+	return slices.Concat(simulation.LogFiles, simulation.PendingLogFiles)
+}
+
+// findDuplicateLogFiles splits uploaded log files into files with new content and
+// files whose checksum matches an existing file or an earlier file of the same
+// upload. existing should include the pending log files, see allLogFiles.
+func findDuplicateLogFiles(existing, uploaded []types.LogFileInfo) ([]types.LogFileInfo, []types.DuplicateLogFile) {
+	seen := make(map[string]string)
+	for _, logFile := range existing {
+		if logFile.Checksum != "" {
+			seen[logFile.Checksum] = logFile.OriginalFilename
+		}
+	}
+
+	var unique []types.LogFileInfo
+	var duplicates []types.DuplicateLogFile
+	for _, logFile := range uploaded {
+		if original, ok := seen[logFile.Checksum]; ok && logFile.Checksum != "" {
+			duplicates = append(duplicates, types.DuplicateLogFile{
+				Filename:    logFile.OriginalFilename,
+				DuplicateOf: original,
+				Checksum:    logFile.Checksum,
+				FilePath:    logFile.FilePath,
+			})
+			continue
+		}
+		if logFile.Checksum != "" {
+			seen[logFile.Checksum] = logFile.OriginalFilename
+		}
+		unique = append(unique, logFile)
+	}
+
+	return unique, duplicates
+}
+
+// storeLogFiles moves staged log files into storage below the simulation's key
+// prefix under unique generated names. On error all staged and already stored
+// files are removed.
+func storeLogFiles(store storage.Storage, simulation *types.Simulation, staged []types.LogFileInfo) ([]types.LogFileInfo, error) {
+	prefix, err := utils.EnsureSimulationDir(store, simulation.UserID, simulation.ProjectID, simulation.ID)
+	if err != nil {
+		removeLogFiles(staged)
+		return nil, err
+	}
+
+	stored := make([]types.LogFileInfo, 0, len(staged))
+	for i, logFile := range staged {
+		// ObjectIDs are unique, so files never overwrite each other even after deletions
+		filename := fmt.Sprintf("%s_%s", primitive.NewObjectID().Hex(), logupload.StoredName(logFile.OriginalFilename))
+		key := path.Join(prefix, filename)
+
+		if err := storage.SaveFile(context.Background(), store, key, logFile.FilePath); err != nil {
+			deleteStoredLogFiles(store, stored)
+			removeLogFiles(staged[i:])
+			return nil, err
+		}
+
+		logFile.StoredFilename = filename
+		logFile.StorageKey = key
+		logFile.FilePath = store.Location(key)
+		stored = append(stored, logFile)
+	}
+
+	return stored, nil
+}
+
+// deleteStoredLogFiles removes log files just stored by storeLogFiles from storage
+func deleteStoredLogFiles(store storage.Storage, logFiles []types.LogFileInfo) {
+	for _, logFile := range logFiles {
+		store.Delete(context.Background(), logFile.StorageKey)
+	}
+}
+
+// legacyUploadDir is the uploads directory of the time before UPLOAD_DIR, which
+// the paths of the log files recorded without a storage key may be relative to
+const legacyUploadDir = "uploads"
+
+// logFileKey returns the storage key of a log file. Files uploaded before storage
+// keys were introduced are addressed by their path below uploadDir, the
+// configured upload directory, or below legacyUploadDir for paths recorded
+// before it was configurable.
+func logFileKey(logFile types.LogFileInfo, uploadDir string) string {
+	if logFile.StorageKey != "" {
+		return logFile.StorageKey
+	}
+
+	for _, dir := range []string{uploadDir, legacyUploadDir} {
+		rel, err := filepath.Rel(dir, logFile.FilePath)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return ""
+}
+
+// removeLogFiles deletes the given staged log files from disk
+func removeLogFiles(logFiles []types.LogFileInfo) {
+	for _, logFile := range logFiles {
+		os.Remove(logFile.FilePath)
+	}
+}
+
+// removeDuplicateLogFiles deletes the on-disk copies of duplicate uploads
+func removeDuplicateLogFiles(duplicates []types.DuplicateLogFile) {
+	for _, duplicate := range duplicates {
+		os.Remove(duplicate.FilePath)
+	}
+}
+
+// respondDuplicateLogFile rejects an upload containing an already uploaded log file
+func respondDuplicateLogFile(c *gin.Context, duplicate types.DuplicateLogFile) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":       "Log file was already uploaded",
+		"file":        duplicate.Filename,
+		"duplicateOf": duplicate.DuplicateOf,
+		"checksum":    duplicate.Checksum,
+	})
+}
+
+// storeDerivedData derives the peer and validator mappings and the incidents of
+// a processed simulation from its events and stores them in its database
+func storeDerivedData(simulationDB *mongo.Database) {
+	ctx := context.Background()
+	eventsColl := simulationDB.Collection("tracer_events")
+
+	peers, err := metrics.DerivePeerMapping(ctx, eventsColl)
+	if err == nil {
+		err = metrics.StorePeerMapping(ctx, simulationDB, peers)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to derive peer mapping: %v\n", err)
+	}
+
+	validators, err := metrics.DeriveValidatorMapping(ctx, eventsColl)
+	if err == nil {
+		err = metrics.StoreValidatorMapping(ctx, simulationDB, validators)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to derive validator mapping: %v\n", err)
+	}
+
+	incidents, err := metrics.DetectIncidents(ctx, eventsColl, metrics.DefaultIncidentThresholds)
+	if err == nil {
+		err = metrics.StoreIncidents(ctx, simulationDB, incidents)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to detect incidents: %v\n", err)
+	}
 }
@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestFindDuplicateLogFiles(t *testing.T) {
+	logFile := func(name, checksum string) types.LogFileInfo {
+		return types.LogFileInfo{OriginalFilename: name, Checksum: checksum, FilePath: "/staging/" + name}
+	}
+	duplicate := func(name, of, checksum string) types.DuplicateLogFile {
+		return types.DuplicateLogFile{Filename: name, DuplicateOf: of, Checksum: checksum, FilePath: "/staging/" + name}
+	}
+
+	tests := []struct {
+		name           string
+		existing       []types.LogFileInfo
+		uploaded       []types.LogFileInfo
+		wantUnique     []types.LogFileInfo
+		wantDuplicates []types.DuplicateLogFile
+	}{
+		{
+			name:       "same name with different content is not a duplicate",
+			existing:   []types.LogFileInfo{logFile("node0.log", "aaa")},
+			uploaded:   []types.LogFileInfo{logFile("node0.log", "bbb")},
+			wantUnique: []types.LogFileInfo{logFile("node0.log", "bbb")},
+		},
+		{
+			name:           "same content with a different name duplicates a stored file",
+			existing:       []types.LogFileInfo{logFile("node0.log", "aaa")},
+			uploaded:       []types.LogFileInfo{logFile("renamed.log", "aaa")},
+			wantDuplicates: []types.DuplicateLogFile{duplicate("renamed.log", "node0.log", "aaa")},
+		},
+		{
+			name:           "duplicates within the pending upload keep the first file",
+			uploaded:       []types.LogFileInfo{logFile("node0.log", "aaa"), logFile("node1.log", "bbb"), logFile("copy.log", "aaa")},
+			wantUnique:     []types.LogFileInfo{logFile("node0.log", "aaa"), logFile("node1.log", "bbb")},
+			wantDuplicates: []types.DuplicateLogFile{duplicate("copy.log", "node0.log", "aaa")},
+		},
+		{
+			name:       "duplicates across the stored and pending sets",
+			existing:   []types.LogFileInfo{logFile("stored.log", "aaa")},
+			uploaded:   []types.LogFileInfo{logFile("new.log", "bbb"), logFile("again.log", "aaa"), logFile("new-copy.log", "bbb")},
+			wantUnique: []types.LogFileInfo{logFile("new.log", "bbb")},
+			wantDuplicates: []types.DuplicateLogFile{
+				duplicate("again.log", "stored.log", "aaa"),
+				duplicate("new-copy.log", "new.log", "bbb"),
+			},
+		},
+		{
+			name:       "files without a checksum are never duplicates",
+			existing:   []types.LogFileInfo{logFile("legacy.log", "")},
+			uploaded:   []types.LogFileInfo{logFile("a.log", ""), logFile("b.log", "")},
+			wantUnique: []types.LogFileInfo{logFile("a.log", ""), logFile("b.log", "")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unique, duplicates := findDuplicateLogFiles(tt.existing, tt.uploaded)
+			if !reflect.DeepEqual(unique, tt.wantUnique) {
+				t.Errorf("unique = %+v, want %+v", unique, tt.wantUnique)
+			}
+			if !reflect.DeepEqual(duplicates, tt.wantDuplicates) {
+				t.Errorf("duplicates = %+v, want %+v", duplicates, tt.wantDuplicates)
+			}
+		})
+	}
+}
+
+func TestLogFileKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		logFile   types.LogFileInfo
+		uploadDir string
+		want      string
+	}{
+		{
+			name:      "storage key wins",
+			logFile:   types.LogFileInfo{StorageKey: "sim/node0.log", FilePath: "/data/logs/other.log"},
+			uploadDir: "/data/logs",
+			want:      "sim/node0.log",
+		},
+		{
+			name:      "path below the configured directory",
+			logFile:   types.LogFileInfo{FilePath: "/data/logs/sim/node0.log"},
+			uploadDir: "/data/logs",
+			want:      "sim/node0.log",
+		},
+		{
+			name:      "path below a relative configured directory",
+			logFile:   types.LogFileInfo{FilePath: "var/logs/sim/node0.log"},
+			uploadDir: "var/logs",
+			want:      "sim/node0.log",
+		},
+		{
+			name:      "path below the legacy directory",
+			logFile:   types.LogFileInfo{FilePath: "uploads/sim/node0.log"},
+			uploadDir: "/data/logs",
+			want:      "sim/node0.log",
+		},
+		{
+			name:      "path outside both directories",
+			logFile:   types.LogFileInfo{FilePath: "/tmp/node0.log"},
+			uploadDir: "/data/logs",
+		},
+		{
+			name:      "path escaping the configured directory",
+			logFile:   types.LogFileInfo{FilePath: "/data/logs/../secrets"},
+			uploadDir: "/data/logs",
+		},
+		{
+			name:      "the directory itself",
+			logFile:   types.LogFileInfo{FilePath: "/data/logs"},
+			uploadDir: "/data/logs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logFileKey(tt.logFile, tt.uploadDir); got != tt.want {
+				t.Errorf("logFileKey(%+v, %q) = %q, want %q", tt.logFile, tt.uploadDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSimulationsByProjectHandlerFilters(t *testing.T) {
+	projects := repository.NewMemoryProjectRepo()
+	simulations := repository.NewMemorySimulationRepo()
+	project := seedProject(t, projects, primitive.NewObjectID(), "testnet", "")
+	other := seedProject(t, projects, project.UserID, "devnet", "")
+	seedSimulation(t, simulations, project, "baseline", types.SimulationStatusProcessed, 0)
+	seedSimulation(t, simulations, project, "partition", types.SimulationStatusFailed, 0)
+	seedSimulation(t, simulations, project, "Partition again", types.SimulationStatusProcessed, 0)
+	seedSimulation(t, simulations, other, "partition", types.SimulationStatusProcessed, 0)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantNames  []string
+	}{
+		{name: "all", wantStatus: http.StatusOK, wantNames: []string{"baseline", "partition", "Partition again"}},
+		{name: "status", query: "?status=processed", wantStatus: http.StatusOK, wantNames: []string{"baseline", "Partition again"}},
+		{name: "repeated status", query: "?status=processed&status=failed", wantStatus: http.StatusOK, wantNames: []string{"baseline", "partition", "Partition again"}},
+		{name: "search", query: "?q=partition", wantStatus: http.StatusOK, wantNames: []string{"partition", "Partition again"}},
+		{name: "search and status", query: "?q=partition&status=failed", wantStatus: http.StatusOK, wantNames: []string{"partition"}},
+		{name: "invalid status", query: "?status=done", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []types.SimulationResponse
+			var rejected map[string]any
+			target := "/projects/" + project.ID.Hex() + "/simulations" + tt.query
+			if tt.wantStatus != http.StatusOK {
+				status := getJSON(t, GetSimulationsByProjectHandler(simulations), "/projects/:projectId/simulations", target, &rejected)
+				if status != tt.wantStatus {
+					t.Errorf("status = %d, want %d: %v", status, tt.wantStatus, rejected)
+				}
+				return
+			}
+
+			status := getJSON(t, GetSimulationsByProjectHandler(simulations), "/projects/:projectId/simulations", target, &got)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			names := []string{}
+			for _, simulation := range got {
+				names = append(names, simulation.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("simulations = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestUpdateSimulationPriorityHandler(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	queued := seedSimulation(t, simulations, project, "queued", types.SimulationStatusProcessing, 0, "node0.log")
+	idle := seedSimulation(t, simulations, project, "idle", types.SimulationStatusProcessed, 0, "node0.log")
+
+	queue := processing.NewQueue()
+	queue.Enqueue(queued.ID, 0)
+
+	tests := []struct {
+		name         string
+		simulationID string
+		wantStatus   int
+		wantQueued   bool
+	}{
+		{name: "waiting in the queue", simulationID: queued.ID.Hex(), wantStatus: http.StatusOK, wantQueued: true},
+		{name: "not queued", simulationID: idle.ID.Hex(), wantStatus: http.StatusOK},
+		{name: "unknown", simulationID: primitive.NewObjectID().Hex(), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := serve(t, UpdateSimulationPriorityHandler(simulations, queue), http.MethodPut,
+				"/simulations/:id/priority", "/simulations/"+tt.simulationID+"/priority", `{"priority":7}`)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if body["queued"] != tt.wantQueued {
+				t.Errorf("queued = %v, want %v", body["queued"], tt.wantQueued)
+			}
+			id, _ := primitive.ObjectIDFromHex(tt.simulationID)
+			if stored, _ := simulations.Get(context.Background(), id); stored.Priority != 7 {
+				t.Errorf("stored priority = %d, want 7", stored.Priority)
+			}
+		})
+	}
+}
+
+func TestDeleteLogFileHandler(t *testing.T) {
+	// Log files are empty, so no storage is released from the unused quota
+	quotas := quota.New(nil, 0)
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+
+	tests := []struct {
+		name                 string
+		status               types.SimulationStatus
+		processingStatus     types.ProcessingStatus
+		logFiles             []string
+		wantStatus           int
+		wantSimulationStatus types.SimulationStatus
+		wantProcessingStatus types.ProcessingStatus
+	}{
+		{
+			name:                 "processed simulation needs reprocessing",
+			status:               types.SimulationStatusProcessed,
+			processingStatus:     types.ProcessingStatusCompleted,
+			logFiles:             []string{"node0.log", "node1.log"},
+			wantStatus:           http.StatusOK,
+			wantSimulationStatus: types.SimulationStatusProcessing,
+			wantProcessingStatus: types.ProcessingStatusPending,
+		},
+		{
+			name:                 "last log file",
+			status:               types.SimulationStatusProcessed,
+			processingStatus:     types.ProcessingStatusCompleted,
+			logFiles:             []string{"node0.log"},
+			wantStatus:           http.StatusOK,
+			wantSimulationStatus: types.SimulationStatusLogFileRequired,
+		},
+		{
+			name:                 "while processing",
+			status:               types.SimulationStatusProcessing,
+			processingStatus:     types.ProcessingStatusProcessing,
+			logFiles:             []string{"node0.log", "node1.log"},
+			wantStatus:           http.StatusConflict,
+			wantSimulationStatus: types.SimulationStatusProcessing,
+			wantProcessingStatus: types.ProcessingStatusProcessing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			simulations := repository.NewMemorySimulationRepo()
+			simulation := seedSimulation(t, simulations, project, "run", tt.status, 0, tt.logFiles...)
+			processingStatus := tt.processingStatus
+			if _, err := simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{ProcessingStatus: &processingStatus}); err != nil {
+				t.Fatal(err)
+			}
+
+			handler := DeleteLogFileHandler(simulations, storage.NewLocalStorage(t.TempDir()), quotas, "")
+			status, body := serve(t, handler, http.MethodDelete, "/simulations/:id/logfiles/:index",
+				"/simulations/"+simulation.ID.Hex()+"/logfiles/node0.log", "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+
+			stored, err := simulations.Get(context.Background(), simulation.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantSimulationStatus || stored.ProcessingStatus != tt.wantProcessingStatus {
+				t.Errorf("status = %s/%q, want %s/%q", stored.Status, stored.ProcessingStatus, tt.wantSimulationStatus, tt.wantProcessingStatus)
+			}
+			wantFiles := len(tt.logFiles)
+			if status == http.StatusOK {
+				wantFiles--
+			}
+			if len(stored.LogFiles) != wantFiles {
+				t.Errorf("%d log files left, want %d", len(stored.LogFiles), wantFiles)
+			}
+		})
+	}
+}
+
+func TestMergePendingLogFiles(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run", types.SimulationStatusProcessed, 0, "node0.log")
+	pending := []types.LogFileInfo{{OriginalFilename: "node1.log", FilePath: "/logs/node1.log"}}
+	if _, err := simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{AddPendingLogFiles: pending}); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := processing.NewQueue()
+	mergePendingLogFiles(simulations, queue, simulation)
+
+	stored, err := simulations.Get(context.Background(), simulation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.LogFiles) != 2 || len(stored.PendingLogFiles) != 0 {
+		t.Errorf("log files = %d, pending = %d, want 2 and 0", len(stored.LogFiles), len(stored.PendingLogFiles))
+	}
+	if stored.ProcessingStatus != types.ProcessingStatusPending || !queue.Contains(simulation.ID) {
+		t.Errorf("processing status = %q, queued = %v, want pending and queued", stored.ProcessingStatus, queue.Contains(simulation.ID))
+	}
+
+	// Without pending log files nothing is queued again
+	queue = processing.NewQueue()
+	mergePendingLogFiles(simulations, queue, simulation)
+	if queue.Contains(simulation.ID) {
+		t.Error("simulation without pending log files was queued")
+	}
+}
+
+func TestInterruptQueuedSimulations(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	seed := func(name string, processingStatus types.ProcessingStatus) types.Simulation {
+		simulation := seedSimulation(t, simulations, project, name, types.SimulationStatusProcessing, 0, "node0.log")
+		if _, err := simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{ProcessingStatus: &processingStatus}); err != nil {
+			t.Fatal(err)
+		}
+		return simulation
+	}
+	queued := seed("queued", types.ProcessingStatusPending)
+	running := seed("running", types.ProcessingStatusProcessing)
+	completed := seed("completed", types.ProcessingStatusCompleted)
+
+	// The job of a deleted simulation is skipped
+	InterruptQueuedSimulations(simulations, []processing.Job{
+		{SimulationID: queued.ID}, {SimulationID: running.ID}, {SimulationID: completed.ID}, {SimulationID: primitive.NewObjectID()},
+	})
+
+	stored, err := simulations.Get(context.Background(), queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != types.SimulationStatusFailed || stored.ProcessingStatus != types.ProcessingStatusFailed {
+		t.Errorf("queued: status = %q, processing status = %q, want failed", stored.Status, stored.ProcessingStatus)
+	}
+	if stored.ProcessingResult == nil || stored.ProcessingResult.ErrorMessage != interruptedMessage {
+		t.Errorf("queued: processing result = %+v, want the interrupted message", stored.ProcessingResult)
+	}
+
+	// Simulations that left the queue are recorded by their own run
+	for _, simulation := range []types.Simulation{running, completed} {
+		stored, err := simulations.Get(context.Background(), simulation.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != types.SimulationStatusProcessing || stored.ProcessingResult != nil {
+			t.Errorf("%s: status = %q, processing result = %+v, want it untouched", simulation.Name, stored.Status, stored.ProcessingResult)
+		}
+	}
+}
+
+func TestProcessSimulationLogsInterrupted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the ETL is stubbed with a shell script")
+	}
+	// The stub ETL reports that it started, then runs until it is killed
+	dir := t.TempDir()
+	started := filepath.Join(dir, "started")
+	etlBinary := filepath.Join(dir, "etl")
+	if err := os.WriteFile(etlBinary, []byte("#!/bin/sh\ntouch "+started+"\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Connecting does not dial, and a failed run touches no database
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run", types.SimulationStatusProcessing, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processSimulationLogs(ctx, simulations, client, storage.NewLocalStorage(dir), etlBinary, dir, simulation)
+	}()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("the ETL did not start")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the ETL was not killed")
+	}
+
+	stored, err := simulations.Get(context.Background(), simulation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != types.SimulationStatusFailed || stored.ProcessingStatus != types.ProcessingStatusFailed {
+		t.Errorf("status = %q, processing status = %q, want failed", stored.Status, stored.ProcessingStatus)
+	}
+	if stored.ProcessingResult == nil || stored.ProcessingResult.ErrorMessage != interruptedMessage {
+		t.Errorf("processing result = %+v, want the interrupted message", stored.ProcessingResult)
+	}
+}
+
+// failingCreateSimulationRepo is a MemorySimulationRepo whose inserts fail
+type failingCreateSimulationRepo struct {
+	*repository.MemorySimulationRepo
+}
+
+// Create fails without storing simulation
+func (r failingCreateSimulationRepo) Create(ctx context.Context, simulation *types.Simulation) error {
+	return errors.New("insert failed")
+}
+
+// filesBelow returns the regular files below dir
+func filesBelow(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCreateSimulationCleansUpOnFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		missing      string // Staged file removed before storing, failing its rename
+		failCreate   bool
+		wantErr      string
+		wantStored   int
+		wantDocument bool
+	}{
+		{name: "stored and inserted", wantStored: 3, wantDocument: true},
+		{name: "rename of a log file fails", missing: "node1.log", wantErr: "failed to store log files"},
+		{name: "insert fails", failCreate: true, wantErr: "failed to insert simulation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := repository.NewMemorySimulationRepo()
+			var simulations repository.SimulationRepo = memory
+			if tt.failCreate {
+				simulations = failingCreateSimulationRepo{memory}
+			}
+			storeDir, stagingDir := t.TempDir(), t.TempDir()
+			store := storage.NewLocalStorage(storeDir)
+
+			var staged []types.LogFileInfo
+			for _, name := range []string{"node0.log", "node1.log", "node2.log"} {
+				filePath := filepath.Join(stagingDir, name)
+				if name != tt.missing {
+					if err := os.WriteFile(filePath, []byte(name), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				staged = append(staged, types.LogFileInfo{OriginalFilename: name, FilePath: filePath})
+			}
+
+			simulation := types.Simulation{UserID: primitive.NewObjectID(), ProjectID: primitive.NewObjectID(), Name: "run"}
+			err := createSimulation(context.Background(), simulations, store, &simulation, staged)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("createSimulation: %v", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("createSimulation = %v, want %q", err, tt.wantErr)
+			}
+
+			if stored := filesBelow(t, storeDir); len(stored) != tt.wantStored {
+				t.Errorf("%d files in storage, want %d: %v", len(stored), tt.wantStored, stored)
+			}
+			if left := filesBelow(t, stagingDir); len(left) != 0 {
+				t.Errorf("staged files left behind: %v", left)
+			}
+			if len(simulation.LogFiles) != tt.wantStored {
+				t.Errorf("%d log files recorded, want %d", len(simulation.LogFiles), tt.wantStored)
+			}
+			_, err = memory.Get(context.Background(), simulation.ID)
+			if tt.wantDocument && err != nil {
+				t.Errorf("simulation not stored: %v", err)
+			} else if !tt.wantDocument && !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("Get = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+// testUploadLimits are upload limits generous enough for the test uploads
+var testUploadLimits = logupload.Limits{MaxFileBytes: 1 << 20, MaxRequestBytes: 1 << 20, MaxArchiveEntries: 10, MaxArchiveBytes: 1 << 20}
+
+// logFileUpload returns a multipart body uploading content as the logfiles
+// field under filename, with its content type
+func logFileUpload(t *testing.T, filename, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("logfiles", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, w.FormDataContentType()
+}
+
+func TestUploadLogFileHandlerRejectsDuplicatesOfPendingFiles(t *testing.T) {
+	const content = `{"level":"info","msg":"entering new round"}` + "\n"
+	checksum := sha256.Sum256([]byte(content))
+
+	simulations := repository.NewMemorySimulationRepo()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+	simulation := seedSimulation(t, simulations, project, "run", types.SimulationStatusProcessing, 0, "node0.log")
+	processingStatus := types.ProcessingStatusProcessing
+	pending := types.LogFileInfo{OriginalFilename: "pending.log", Checksum: hex.EncodeToString(checksum[:])}
+	if _, err := simulations.Update(context.Background(), simulation.ID, repository.SimulationUpdate{
+		ProcessingStatus: &processingStatus, AddPendingLogFiles: []types.LogFileInfo{pending},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The duplicate is rejected before any quota is reserved
+	uploadDir := t.TempDir()
+	router := gin.New()
+	router.POST("/simulations/:id/logfiles", UploadLogFileHandler(simulations, storage.NewLocalStorage(t.TempDir()), quota.New(nil, 0), testUploadLimits, uploadDir))
+	body, contentType := logFileUpload(t, "again.log", content)
+	req := httptest.NewRequest(http.MethodPost, "/simulations/"+simulation.ID.Hex()+"/logfiles?mode=queue", body)
+	req.Header.Set("Content-Type", contentType)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusConflict, recorder.Body)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil || got["duplicateOf"] != "pending.log" {
+		t.Errorf("body = %s, want a duplicate of pending.log", recorder.Body)
+	}
+	if left := filesBelow(t, uploadDir); len(left) != 0 {
+		t.Errorf("staged files left behind: %v", left)
+	}
+}
+
+// stageLogFile writes content to a staged log file named filename in dir
+func stageLogFile(t *testing.T, dir, filename, content string) []types.LogFileInfo {
+	t.Helper()
+	filePath := filepath.Join(dir, filename)
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return []types.LogFileInfo{{OriginalFilename: filename, FilePath: filePath, FileSize: int64(len(content))}}
+}
+
+func TestUploadDeleteUpload(t *testing.T) {
+	const content = `{"level":"info","msg":"entering new round"}` + "\n"
+	ctx := context.Background()
+	simulations := repository.NewMemorySimulationRepo()
+	storeDir, uploadDir := t.TempDir(), t.TempDir()
+	store := storage.NewLocalStorage(storeDir)
+	queue := processing.NewQueue()
+	project := types.Project{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}
+
+	first := types.Simulation{UserID: project.UserID, ProjectID: project.ID, Name: "run"}
+	if err := createSimulation(ctx, simulations, store, &first, stageLogFile(t, uploadDir, "node0.log", content)); err != nil {
+		t.Fatal(err)
+	}
+	queue.Enqueue(first.ID, 0)
+
+	deletion, err := deleteSimulation(ctx, simulations, store, queue, nil, uploadDir, first, false)
+	if err != nil || !deletion.Deleted || deletion.LogFiles != 1 {
+		t.Fatalf("deleteSimulation = %+v, %v, want the document and 1 log file deleted", deletion, err)
+	}
+	if queue.Contains(first.ID) {
+		t.Error("deleted simulation is still queued")
+	}
+	if stored := filesBelow(t, storeDir); len(stored) != 0 {
+		t.Errorf("files left in storage: %v", stored)
+	}
+
+	// Uploads to the deleted simulation find it gone and store nothing
+	router := gin.New()
+	router.POST("/simulations/:id/upload", UploadLogFileHandler(simulations, store, quota.New(nil, 0), testUploadLimits, uploadDir))
+	body, contentType := logFileUpload(t, "node1.log", content)
+	req := httptest.NewRequest(http.MethodPost, "/simulations/"+first.ID.Hex()+"/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("upload to the deleted simulation: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+	if left := append(filesBelow(t, storeDir), filesBelow(t, uploadDir)...); len(left) != 0 {
+		t.Errorf("upload to the deleted simulation left files: %v", left)
+	}
+
+	// The same content can be uploaded again to a new simulation of the same name
+	second := types.Simulation{UserID: project.UserID, ProjectID: project.ID, Name: "run"}
+	if err := createSimulation(ctx, simulations, store, &second, stageLogFile(t, uploadDir, "node0.log", content)); err != nil {
+		t.Fatalf("createSimulation after the deletion: %v", err)
+	}
+	if stored := filesBelow(t, storeDir); len(stored) != 1 || len(second.LogFiles) != 1 {
+		t.Errorf("stored %v for %d log files, want 1 file", stored, len(second.LogFiles))
+	}
+}
+
+// failingDeleteSimulationRepo is a MemorySimulationRepo whose deletions fail
+type failingDeleteSimulationRepo struct {
+	*repository.MemorySimulationRepo
+}
+
+// Delete fails without deleting the simulation
+func (r failingDeleteSimulationRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return errors.New("delete failed")
+}
+
+func TestDeleteSimulationKeepsFilesWhenTheDocumentStays(t *testing.T) {
+	ctx := context.Background()
+	memory := repository.NewMemorySimulationRepo()
+	storeDir, uploadDir := t.TempDir(), t.TempDir()
+	store := storage.NewLocalStorage(storeDir)
+	queue := processing.NewQueue()
+
+	simulation := types.Simulation{UserID: primitive.NewObjectID(), ProjectID: primitive.NewObjectID(), Name: "run"}
+	if err := createSimulation(ctx, memory, store, &simulation, stageLogFile(t, uploadDir, "node0.log", "log")); err != nil {
+		t.Fatal(err)
+	}
+	queue.Enqueue(simulation.ID, 0)
+
+	deletion, err := deleteSimulation(ctx, failingDeleteSimulationRepo{memory}, store, queue, nil, uploadDir, simulation, false)
+	if err == nil || deletion.Deleted || deletion.LogFiles != 0 {
+		t.Fatalf("deleteSimulation = %+v, %v, want the error and nothing deleted", deletion, err)
+	}
+	if !queue.Contains(simulation.ID) {
+		t.Error("simulation was dequeued although it was not deleted")
+	}
+	if stored := filesBelow(t, storeDir); len(stored) != 1 {
+		t.Errorf("files in storage = %v, want the log file kept", stored)
+	}
+}
@@ -1,17 +1,53 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/metricscache"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/retention"
 	"github.com/bft-labs/cometbft-analyzer-backend/types"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
+	"slices"
+	"time"
 )
 
-// Helper function to validate simulation and get database connection
-func validateSimulationAndGetDB(c *gin.Context, client *mongo.Client, simulationsColl *mongo.Collection, collectionName string) (*mongo.Collection, bool) {
+// validateSimulationMetrics loads the simulation named by the id path parameter
+// and returns its metrics, writing an error response if it fails. collectionName
+// is the collection the metric is computed from.
+func validateSimulationMetrics(c *gin.Context, simulations repository.SimulationRepo, collectionName string) (repository.MetricsRepo, bool) {
+	simulation, ok := loadSimulation(c, simulations)
+	if !ok {
+		return nil, false
+	}
+
+	if !checkRawEventsRetained(c, simulation, collectionName) {
+		return nil, false
+	}
+
+	return simulations.Metrics(simulation.ID), true
+}
+
+// checkRawEventsRetained answers 410 if collectionName holds raw events the
+// retention janitor pruned from the simulation
+func checkRawEventsRetained(c *gin.Context, simulation *types.Simulation, collectionName string) bool {
+	if !simulation.DataPruned || !slices.Contains(retention.RawCollections, collectionName) {
+		return true
+	}
+	c.JSON(http.StatusGone, gin.H{
+		"error":        "The raw events of this simulation were pruned by the project's retention policy; reprocess it to restore them",
+		"dataPrunedAt": simulation.DataPrunedAt,
+	})
+	return false
+}
+
+// loadSimulation loads the simulation named by the id path parameter, writing an error response if it fails
+func loadSimulation(c *gin.Context, simulations repository.SimulationRepo) (*types.Simulation, bool) {
 	// Get simulation ID from path parameter
 	simulationID := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(simulationID)
@@ -21,9 +57,8 @@ func validateSimulationAndGetDB(c *gin.Context, client *mongo.Client, simulation
 	}
 
 	// Get simulation to verify it exists
-	var simulation types.Simulation
-	err = simulationsColl.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&simulation)
-	if err == mongo.ErrNoDocuments {
+	simulation, err := simulations.Get(context.Background(), objectID)
+	if errors.Is(err, repository.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Simulation not found"})
 		return nil, false
 	} else if err != nil {
@@ -31,118 +66,423 @@ func validateSimulationAndGetDB(c *gin.Context, client *mongo.Client, simulation
 		return nil, false
 	}
 
-	// Connect to simulation-specific database
-	databaseName := simulationID
-	coll := client.Database(databaseName).Collection(collectionName)
+	return &simulation, true
+}
+
+// cachingWriter keeps a copy of the response body written by a metric handler
+type cachingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cachingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cachingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// serveCachedMetric serves the response of a simulation metric from the
+// simulation's metrics cache, running the handler and caching its successful
+// response on a miss. Requests with noCache=true skip the lookup but refresh the
+// entry. Requests without an explicit 'to' are not cached, as their time window
+// moves with the current time. With resolvePeers=true, peer IDs in the
+// response are translated to node IDs after the cache.
+func serveCachedMetric(c *gin.Context, metricsRepo repository.MetricsRepo, metric string, handler gin.HandlerFunc) {
+	if c.Query(metricscache.ResolvePeersParam) == "true" {
+		serveResolvingPeers(c, metricsRepo, func() { serveMetric(c, metricsRepo, metric, handler) })
+		return
+	}
+	serveMetric(c, metricsRepo, metric, handler)
+}
+
+// serveMetric serves a simulation metric through the metrics cache, as described in serveCachedMetric
+func serveMetric(c *gin.Context, metricsRepo repository.MetricsRepo, metric string, handler gin.HandlerFunc) {
+	if c.Query("to") == "" {
+		handler(c)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	simulationID := c.Param("id")
+	query := c.Request.URL.Query()
+
+	if c.Query(metricscache.NoCacheParam) != "true" {
+		response, err := metricsRepo.CachedResponse(ctx, metric, query)
+		if err != nil {
+			fmt.Printf("Failed to read metrics cache of simulation %s: %v\n", simulationID, err)
+		} else if response != nil {
+			metricscache.RecordHit()
+			hitCount, missCount := metricscache.Stats()
+			fmt.Printf("Metrics cache hit for %s of simulation %s (hits: %d, misses: %d)\n", metric, simulationID, hitCount, missCount)
+			c.Header("X-Metrics-Cache", "hit")
+			c.Data(http.StatusOK, "application/json; charset=utf-8", response)
+			return
+		}
+		metricscache.RecordMiss()
+		hitCount, missCount := metricscache.Stats()
+		fmt.Printf("Metrics cache miss for %s of simulation %s (hits: %d, misses: %d)\n", metric, simulationID, hitCount, missCount)
+	}
 
-	return coll, true
+	c.Header("X-Metrics-Cache", "miss")
+	writer := &cachingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	handler(c)
+	c.Writer = writer.ResponseWriter
+
+	if writer.Status() != http.StatusOK {
+		return
+	}
+	if err := metricsRepo.CacheResponse(ctx, metric, query, writer.body.Bytes()); err != nil {
+		fmt.Printf("Failed to write metrics cache of simulation %s: %v\n", simulationID, err)
+	}
 }
 
 // GetSimulationVoteLatenciesHandler returns paginated vote latencies for a specific simulation
-func GetSimulationVoteLatenciesHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationVoteLatenciesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "vote_latencies"); ok {
-			handler := GetVoteLatenciesHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "voteLatencies", GetVoteLatenciesHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationPairLatencyHandler returns sender→receiver latency percentiles for a specific simulation
-func GetSimulationPairLatencyHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationPairLatencyHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "vote_latencies"); ok {
-			handler := GetPairLatencyHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "pairLatency", GetPairLatencyHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationBlockLatencyTimeSeriesHandler returns per-block latency time-series for a specific simulation
-func GetSimulationBlockLatencyTimeSeriesHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationBlockLatencyTimeSeriesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "tracer_events"); ok {
-			handler := GetBlockLatencyTimeSeriesHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "blockLatencyTimeSeries", GetBlockLatencyTimeSeriesHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationLatencyStatsHandler returns histogram and jitter stats for a specific simulation
-func GetSimulationLatencyStatsHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationLatencyStatsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "tracer_events"); ok {
-			handler := GetLatencyStatsHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "latencyStats", GetLatencyStatsHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationMessageSuccessRateHandler returns send vs receive counts and delivery ratio for a specific simulation
-func GetSimulationMessageSuccessRateHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationMessageSuccessRateHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "tracer_events"); ok {
-			handler := GetMessageSuccessRateHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "messageSuccessRate", GetMessageSuccessRateHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationBlockEndToEndLatencyHandler returns end-to-end consensus latency per block height for a specific simulation
-func GetSimulationBlockEndToEndLatencyHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationBlockEndToEndLatencyHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "tracer_events"); ok {
-			handler := GetBlockEndToEndLatencyHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "blockEndToEndLatency", GetBlockEndToEndLatencyHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationVoteStatisticsHandler returns aggregated vote statistics by sender/receiver/type for a specific simulation
-func GetSimulationVoteStatisticsHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationVoteStatisticsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "vote_latencies"); ok {
-			handler := GetVoteStatisticsHandler(coll)
-			handler(c)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "voteStatistics", GetVoteStatisticsHandler(metricsRepo))
 		}
 	}
 }
 
 // GetSimulationNetworkLatencyStatsHandler returns network latency statistics for a specific simulation
-func GetSimulationNetworkLatencyStatsHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationNetworkLatencyStatsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "network_latency_nodepair_summary"); ok {
-			handler := GetNetworkLatencyStatsHandler(coll)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "network_latency_nodepair_summary"); ok {
+			handler := GetNetworkLatencyStatsHandler(metricsRepo)
 			handler(c)
 		}
 	}
 }
 
 // GetSimulationNetworkLatencyNodeStatsHandler returns network latency node statistics for a specific simulation
-func GetSimulationNetworkLatencyNodeStatsHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationNetworkLatencyNodeStatsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "network_latency_node_stats"); ok {
-			handler := GetNetworkLatencyNodeStatsHandler(coll)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "network_latency_node_stats"); ok {
+			handler := GetNetworkLatencyNodeStatsHandler(metricsRepo)
 			handler(c)
 		}
 	}
 }
 
 // GetSimulationNetworkLatencyOverviewHandler returns comprehensive network latency statistics for a specific simulation
-func GetSimulationNetworkLatencyOverviewHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationNetworkLatencyOverviewHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "network_latency_nodepair_summary"); ok {
-			handler := GetNetworkLatencyOverviewHandler(coll)
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "network_latency_nodepair_summary"); ok {
+			handler := GetNetworkLatencyOverviewHandler(metricsRepo)
 			handler(c)
 		}
 	}
 }
 
 // GetSimulationConsensusEventsHandler returns consensus events for a specific simulation
-func GetSimulationConsensusEventsHandler(client *mongo.Client, simulationsColl *mongo.Collection) gin.HandlerFunc {
+func GetSimulationConsensusEventsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulation, ok := loadSimulation(c, simulations)
+		if ok && checkRawEventsRetained(c, simulation, "tracer_events") {
+			handler := GetConsensusEventsHandler(simulations.Events(simulation.ID))
+			handler(c)
+		}
+	}
+}
+
+// GetSimulationEventSummaryHandler returns event counts per type for a specific simulation
+func GetSimulationEventSummaryHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			handler := GetEventSummaryHandler(metricsRepo)
+			handler(c)
+		}
+	}
+}
+
+// GetSimulationHeightsHandler returns the block heights of a specific simulation
+func GetSimulationHeightsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			handler := GetHeightsHandler(metricsRepo)
+			handler(c)
+		}
+	}
+}
+
+// GetSimulationConsensusEventHandler returns a single consensus event of a specific simulation
+func GetSimulationConsensusEventHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulation, ok := loadSimulation(c, simulations)
+		if ok && checkRawEventsRetained(c, simulation, "tracer_events") {
+			handler := GetConsensusEventHandler(simulations.Events(simulation.ID))
+			handler(c)
+		}
+	}
+}
+
+// GetSimulationHeightTimelineHandler returns the round timeline of a block height for a specific simulation
+func GetSimulationHeightTimelineHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			handler := GetHeightTimelineHandler(metricsRepo)
+			handler(c)
+		}
+	}
+}
+
+// GetSimulationRoundDurationsHandler returns consensus round durations for a specific simulation
+func GetSimulationRoundDurationsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "roundDurations", GetRoundDurationsHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationProposerStatsHandler returns proposer statistics for a specific simulation
+func GetSimulationProposerStatsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "proposerStats", GetProposerStatsHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationBlockIntervalsHandler returns block intervals for a specific simulation
+func GetSimulationBlockIntervalsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "blockIntervals", GetBlockIntervalsHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationBlockAssemblyHandler returns block assembly times for a specific simulation
+func GetSimulationBlockAssemblyHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "blockAssembly", GetBlockAssemblyHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationVoteParticipationHandler returns vote participation for a specific simulation
+func GetSimulationVoteParticipationHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "voteParticipation", GetVoteParticipationHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationVoteAnomaliesHandler returns lost and late votes for a specific simulation
+func GetSimulationVoteAnomaliesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "voteAnomalies", GetVoteAnomaliesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationThroughputHandler returns the throughput time series of a specific simulation
+func GetSimulationThroughputHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "throughput", GetThroughputHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationStepDurationsHandler returns consensus step durations for a specific simulation
+func GetSimulationStepDurationsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "stepDurations", GetStepDurationsHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationStepFunnelHandler returns the consensus step funnel for a specific simulation
+func GetSimulationStepFunnelHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "stepFunnel", GetStepFunnelHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationVoteLatencyTimeSeriesHandler returns vote latency percentile time series for a specific simulation
+func GetSimulationVoteLatencyTimeSeriesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "voteLatencyTimeSeries", GetVoteLatencyTimeSeriesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationMessageTimeSeriesHandler returns event count time series for a specific simulation
+func GetSimulationMessageTimeSeriesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "messageTimeSeries", GetMessageTimeSeriesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationMessageSuccessRateTimeSeriesHandler returns per-pair delivery time series for a specific simulation
+func GetSimulationMessageSuccessRateTimeSeriesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "messageSuccessRateTimeSeries", GetMessageSuccessRateTimeSeriesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationJitterTimeSeriesHandler returns per-pair jitter time series for a specific simulation
+func GetSimulationJitterTimeSeriesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "jitterTimeSeries", GetJitterTimeSeriesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationTimeoutTimeSeriesHandler returns per-node timeout time series for a specific simulation
+func GetSimulationTimeoutTimeSeriesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "timeoutTimeSeries", GetTimeoutTimeSeriesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationLatencySpikesHandler returns vote deliveries with spiking latency for a specific simulation
+func GetSimulationLatencySpikesHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "latencySpikes", GetLatencySpikesHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationNodeRankingHandler ranks the nodes of a specific simulation worst-first
+func GetSimulationNodeRankingHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "nodeRanking", GetNodeRankingHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationNetworkPartitionsHandler returns the network partitions detected in a specific simulation
+func GetSimulationNetworkPartitionsHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "vote_latencies"); ok {
+			serveCachedMetric(c, metricsRepo, "networkPartitions", GetNetworkPartitionsHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationNetworkFanoutHandler returns the gossip fan-out of the nodes of a specific simulation
+func GetSimulationNetworkFanoutHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "networkFanout", GetNetworkFanoutHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationCommitLagHandler returns per-node commit lag behind the first committer for a specific simulation
+func GetSimulationCommitLagHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "commitLag", GetCommitLagHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationMetricsSummaryHandler returns the headline dashboard metrics for a specific simulation
+func GetSimulationMetricsSummaryHandler(simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsRepo, ok := validateSimulationMetrics(c, simulations, "tracer_events"); ok {
+			serveCachedMetric(c, metricsRepo, "summary", GetMetricsSummaryHandler(metricsRepo))
+		}
+	}
+}
+
+// GetSimulationConsensusEventsStreamHandler streams consensus events over a WebSocket for a specific simulation
+func GetSimulationConsensusEventsStreamHandler(client *mongo.Client, simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if simulation, ok := loadSimulation(c, simulations); ok && checkRawEventsRetained(c, simulation, "tracer_events") {
+			handler := StreamConsensusEventsHandler(client.Database(simulation.ID.Hex()).Collection("tracer_events"))
+			handler(c)
+		}
+	}
+}
+
+// GetSimulationConsensusEventsExportHandler exports consensus events of a specific simulation as a file download
+func GetSimulationConsensusEventsExportHandler(client *mongo.Client, simulations repository.SimulationRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if coll, ok := validateSimulationAndGetDB(c, client, simulationsColl, "tracer_events"); ok {
-			handler := GetConsensusEventsHandler(coll)
+		if simulation, ok := loadSimulation(c, simulations); ok && checkRawEventsRetained(c, simulation, "tracer_events") {
+			coll := client.Database(simulation.ID.Hex()).Collection("tracer_events")
+			handler := ExportConsensusEventsHandler(coll, simulation.Name)
 			handler(c)
 		}
 	}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// getMetric serves a GET of target by handler registered at pattern, returning
+// the recorder
+func getMetric(handler gin.HandlerFunc, pattern, target string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.GET(pattern, handler)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+// seedMetricsSimulation stores a processed simulation whose pair latencies are
+// stubbed, returning the number of times they were computed
+func seedMetricsSimulation(t *testing.T, simulations *repository.MemorySimulationRepo, simulation types.Simulation) *int {
+	t.Helper()
+	if err := simulations.Create(context.Background(), &simulation); err != nil {
+		t.Fatal(err)
+	}
+	computed := new(int)
+	simulations.StubMetrics(simulation.ID).PairwiseLatencyPercentilesFunc = func(
+		from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, groupByVoteType bool, percentiles []float64,
+	) ([]types.PairLatency, error) {
+		*computed++
+		return []types.PairLatency{{Sender: "peer-a", Receiver: "peer-b", P50Ms: 1.5}}, nil
+	}
+	return computed
+}
+
+func TestGetSimulationPairLatencyHandlerArguments(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	simulation := types.Simulation{ID: primitive.NewObjectID(), Status: types.SimulationStatusProcessed}
+	if err := simulations.Create(context.Background(), &simulation); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotFilter metrics.PairLatencyFilter
+	var gotHeights types.HeightRange
+	var gotGroupByVoteType bool
+	simulations.StubMetrics(simulation.ID).PairwiseLatencyPercentilesFunc = func(
+		from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, groupByVoteType bool, percentiles []float64,
+	) ([]types.PairLatency, error) {
+		gotFilter, gotHeights, gotGroupByVoteType = filter, heights, groupByVoteType
+		return []types.PairLatency{}, nil
+	}
+
+	recorder := getMetric(GetSimulationPairLatencyHandler(simulations), "/simulations/:id/pair-latency",
+		"/simulations/"+simulation.ID.Hex()+"/pair-latency?sender=a&sender=b&receiver=c&node=d&heightFrom=5&groupBy=voteType")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	wantFilter := metrics.PairLatencyFilter{Senders: []string{"a", "b"}, Receivers: []string{"c"}, Nodes: []string{"d"}}
+	if !reflect.DeepEqual(gotFilter, wantFilter) {
+		t.Errorf("filter = %+v, want %+v", gotFilter, wantFilter)
+	}
+	if gotHeights.From == nil || *gotHeights.From != 5 || gotHeights.To != nil {
+		t.Errorf("heights = %+v, want from 5", gotHeights)
+	}
+	if !gotGroupByVoteType {
+		t.Error("groupBy=voteType was not passed on")
+	}
+}
+
+func TestServeCachedMetric(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	simulation := types.Simulation{ID: primitive.NewObjectID(), Status: types.SimulationStatusProcessed}
+	computed := seedMetricsSimulation(t, simulations, simulation)
+	handler := GetSimulationPairLatencyHandler(simulations)
+	target := "/simulations/" + simulation.ID.Hex() + "/pair-latency?from=2025-03-01T12:00:00Z&to=2025-03-01T13:00:00Z"
+
+	tests := []struct {
+		name         string
+		target       string
+		wantCache    string
+		wantComputed int
+	}{
+		{name: "miss", target: target, wantCache: "miss", wantComputed: 1},
+		{name: "hit", target: target, wantCache: "hit", wantComputed: 1},
+		{name: "noCache refreshes", target: target + "&noCache=true", wantCache: "miss", wantComputed: 2},
+		{name: "open window is not cached", target: "/simulations/" + simulation.ID.Hex() + "/pair-latency", wantCache: "", wantComputed: 3},
+		{name: "open window again", target: "/simulations/" + simulation.ID.Hex() + "/pair-latency", wantCache: "", wantComputed: 4},
+	}
+
+	// Each case continues from the cache state of the previous one
+	for _, tt := range tests {
+		recorder := getMetric(handler, "/simulations/:id/pair-latency", tt.target)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, recorder.Code, http.StatusOK, recorder.Body)
+		}
+		if got := recorder.Header().Get("X-Metrics-Cache"); got != tt.wantCache {
+			t.Errorf("%s: X-Metrics-Cache = %q, want %q", tt.name, got, tt.wantCache)
+		}
+		if *computed != tt.wantComputed {
+			t.Errorf("%s: computed %d times, want %d", tt.name, *computed, tt.wantComputed)
+		}
+		var got []types.PairLatency
+		if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil || len(got) != 1 || got[0].P50Ms != 1.5 {
+			t.Errorf("%s: body = %s, want the stubbed pair", tt.name, recorder.Body)
+		}
+	}
+
+	if err := simulations.ClearMetricsCache(context.Background(), simulation.ID); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := getMetric(handler, "/simulations/:id/pair-latency", target); recorder.Header().Get("X-Metrics-Cache") != "miss" {
+		t.Error("cleared cache still hit")
+	}
+}
+
+func TestServeCachedMetricResolvesPeers(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	simulation := types.Simulation{ID: primitive.NewObjectID(), Status: types.SimulationStatusProcessed}
+	seedMetricsSimulation(t, simulations, simulation)
+	simulations.StubMetrics(simulation.ID).PeerMappings = []types.PeerMapping{
+		{PeerID: "peer-a", NodeID: "node0", Confidence: 0.9},
+		{PeerID: "peer-b", NodeID: "node1", Confidence: 0.2},
+	}
+
+	var got []types.PairLatency
+	status := getJSON(t, GetSimulationPairLatencyHandler(simulations), "/simulations/:id/pair-latency",
+		"/simulations/"+simulation.ID.Hex()+"/pair-latency?resolvePeers=true", &got)
+	if status != http.StatusOK || len(got) != 1 {
+		t.Fatalf("status = %d with %d pairs, want %d with 1", status, len(got), http.StatusOK)
+	}
+	// peer-b is mapped below minResolveConfidence
+	if got[0].Sender != "node0" || got[0].Receiver != "peer-b" {
+		t.Errorf("pair = %s→%s, want node0→peer-b", got[0].Sender, got[0].Receiver)
+	}
+}
+
+func TestValidateSimulationMetricsPrunedEvents(t *testing.T) {
+	simulations := repository.NewMemorySimulationRepo()
+	simulation := types.Simulation{ID: primitive.NewObjectID(), Status: types.SimulationStatusProcessed, DataPruned: true}
+	seedMetricsSimulation(t, simulations, simulation)
+
+	recorder := getMetric(GetSimulationPairLatencyHandler(simulations), "/simulations/:id/pair-latency",
+		"/simulations/"+simulation.ID.Hex()+"/pair-latency")
+	if recorder.Code != http.StatusGone {
+		t.Errorf("raw vote latencies: status = %d, want %d", recorder.Code, http.StatusGone)
+	}
+
+	// The network latency summaries are derived data kept after pruning
+	recorder = getMetric(GetSimulationNetworkLatencyOverviewHandler(simulations), "/simulations/:id/network-latency/overview",
+		"/simulations/"+simulation.ID.Hex()+"/network-latency/overview")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("network latency overview: status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+
+	recorder = getMetric(GetSimulationPairLatencyHandler(simulations), "/simulations/:id/pair-latency",
+		"/simulations/"+primitive.NewObjectID().Hex()+"/pair-latency")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown simulation: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
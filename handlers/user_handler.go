@@ -3,38 +3,53 @@ package handlers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
 	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // validateUserInput performs additional custom validation
 func validateUserInput(req *types.CreateUserRequest) error {
-	// Check for reserved usernames
+	if err := validateUsername(req.Username); err != nil {
+		return err
+	}
+	return validateEmail(req.Email)
+}
+
+// validateUsername rejects reserved usernames
+func validateUsername(username string) error {
 	reservedUsernames := []string{"admin", "root", "system", "api", "www", "mail", "ftp"}
 	for _, reserved := range reservedUsernames {
-		if strings.ToLower(req.Username) == reserved {
+		if strings.ToLower(username) == reserved {
 			return errors.New("username is reserved")
 		}
 	}
+	return nil
+}
 
-	// Additional email validation beyond the built-in validator
+// validateEmail performs email validation beyond the built-in validator
+func validateEmail(email string) error {
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if !emailRegex.MatchString(req.Email) {
+	if !emailRegex.MatchString(email) {
 		return errors.New("invalid email format")
 	}
 
 	// Check email domain is not blacklisted
 	blacklistedDomains := []string{"example.com", "test.com", "invalid.com"}
-	emailParts := strings.Split(req.Email, "@")
+	emailParts := strings.Split(email, "@")
 	if len(emailParts) == 2 {
 		domain := strings.ToLower(emailParts[1])
 		for _, blacklisted := range blacklistedDomains {
@@ -47,34 +62,39 @@ func validateUserInput(req *types.CreateUserRequest) error {
 	return nil
 }
 
+// userValidationErrors describes the binding errors of a user request
+func userValidationErrors(err error) []string {
+	var errorMessages []string
+	if validationErrors, ok := err.(validator.ValidationErrors); ok {
+		for _, e := range validationErrors {
+			switch e.Tag() {
+			case "required":
+				errorMessages = append(errorMessages, e.Field()+" is required")
+			case "email":
+				errorMessages = append(errorMessages, "Invalid email format")
+			case "min":
+				errorMessages = append(errorMessages, e.Field()+" must be at least "+e.Param()+" characters")
+			case "max":
+				errorMessages = append(errorMessages, e.Field()+" must be at most "+e.Param()+" characters")
+			case "alphanum":
+				errorMessages = append(errorMessages, e.Field()+" must contain only alphanumeric characters")
+			default:
+				errorMessages = append(errorMessages, e.Field()+" is invalid")
+			}
+		}
+	} else {
+		errorMessages = append(errorMessages, "Invalid JSON format")
+	}
+	return errorMessages
+}
+
 // CreateUserHandler creates a new user
-func CreateUserHandler(collection *mongo.Collection) gin.HandlerFunc {
+func CreateUserHandler(users repository.UserRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req types.CreateUserRequest
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			var errorMessages []string
-			if validationErrors, ok := err.(validator.ValidationErrors); ok {
-				for _, e := range validationErrors {
-					switch e.Tag() {
-					case "required":
-						errorMessages = append(errorMessages, e.Field()+" is required")
-					case "email":
-						errorMessages = append(errorMessages, "Invalid email format")
-					case "min":
-						errorMessages = append(errorMessages, e.Field()+" must be at least "+e.Param()+" characters")
-					case "max":
-						errorMessages = append(errorMessages, e.Field()+" must be at most "+e.Param()+" characters")
-					case "alphanum":
-						errorMessages = append(errorMessages, e.Field()+" must contain only alphanumeric characters")
-					default:
-						errorMessages = append(errorMessages, e.Field()+" is invalid")
-					}
-				}
-			} else {
-				errorMessages = append(errorMessages, "Invalid JSON format")
-			}
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": errorMessages})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": userValidationErrors(err)})
 			return
 		}
 
@@ -84,45 +104,69 @@ func CreateUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		// Check if user already exists
-		var existingUser types.User
-		err := collection.FindOne(context.Background(), bson.M{
-			"$or": []bson.M{
-				{"username": req.Username},
-				{"email": req.Email},
-			},
-		}).Decode(&existingUser)
+		user := types.User{
+			Username:        req.Username,
+			Email:           req.Email,
+			EmailNormalized: repository.NormalizeEmail(req.Email),
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		}
 
-		if err == nil {
+		err := users.Create(context.Background(), &user)
+		if errors.Is(err, repository.ErrDuplicate) {
 			c.JSON(http.StatusConflict, gin.H{"error": "User with this username or email already exists"})
 			return
-		} else if err != mongo.ErrNoDocuments {
+		} else if err != nil {
 			// Log the actual error but don't expose it to the client
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 			return
 		}
 
-		user := types.User{
-			Username:  req.Username,
-			Email:     req.Email,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
+		c.JSON(http.StatusCreated, user)
+	}
+}
 
-		result, err := collection.InsertOne(context.Background(), user)
+// GetUserHandler retrieves a user by ID
+func GetUserHandler(users repository.UserRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("userId")
+		objectID, err := primitive.ObjectIDFromHex(userID)
 		if err != nil {
-			// Log the actual error but don't expose it to the client
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 			return
 		}
 
-		user.ID = result.InsertedID.(primitive.ObjectID)
-		c.JSON(http.StatusCreated, user)
+		user, err := users.Get(context.Background(), objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
 	}
 }
 
-// GetUserHandler retrieves a user by ID
-func GetUserHandler(collection *mongo.Collection) gin.HandlerFunc {
+// GetUserByUsernameHandler retrieves a user by its exact username
+func GetUserByUsernameHandler(users repository.UserRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := users.GetByUsername(context.Background(), c.Param("username"))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// UpdateUserHandler updates the username and/or email of a user by ID
+func UpdateUserHandler(users repository.UserRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Param("userId")
 		objectID, err := primitive.ObjectIDFromHex(userID)
@@ -131,9 +175,34 @@ func GetUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		var user types.User
-		err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user)
-		if err == mongo.ErrNoDocuments {
+		var req types.UpdateUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": userValidationErrors(err)})
+			return
+		}
+
+		// Same custom validation as on creation
+		update := repository.UserUpdate{Username: req.Username, Email: req.Email}
+		if req.Username != nil {
+			if err := validateUsername(*req.Username); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if req.Email != nil {
+			if err := validateEmail(*req.Email); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			emailNormalized := repository.NormalizeEmail(*req.Email)
+			update.EmailNormalized = &emailNormalized
+		}
+
+		user, err := users.Update(context.Background(), objectID, update)
+		if errors.Is(err, repository.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User with this username or email already exists"})
+			return
+		} else if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		} else if err != nil {
@@ -145,32 +214,94 @@ func GetUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 	}
 }
 
-// GetUsersHandler retrieves all users
-func GetUsersHandler(collection *mongo.Collection) gin.HandlerFunc {
+// GetUsersHandler retrieves a page of users, newest first. ?q= searches the
+// username and email, ?username= and ?email= match them exactly (the email
+// case-insensitively); ?fields=username,email returns only these fields.
+func GetUsersHandler(users repository.UserRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cursor, err := collection.Find(context.Background(), bson.M{})
+		// Parse pagination parameters
+		page := 1
+		if pageStr := c.Query("page"); pageStr != "" {
+			if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
+				page = parsedPage
+			}
+		}
+
+		perPage := 100 // Default per page
+		if perPageStr := c.Query("perPage"); perPageStr != "" {
+			if parsedPerPage, err := strconv.Atoi(perPageStr); err == nil && parsedPerPage > 0 && parsedPerPage <= 1000 {
+				perPage = parsedPerPage
+			}
+		}
+
+		search, err := utils.SearchFromContext(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		defer cursor.Close(context.Background())
+		filter := repository.UserFilter{Search: search, Username: c.Query("username")}
+		if email := c.Query("email"); email != "" {
+			filter.EmailNormalized = repository.NormalizeEmail(email)
+		}
+		if fieldsStr := c.Query("fields"); fieldsStr != "" {
+			for _, field := range strings.Split(fieldsStr, ",") {
+				field = strings.TrimSpace(field)
+				if _, ok := repository.UserFields[field]; !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported field: " + field})
+					return
+				}
+				filter.Fields = append(filter.Fields, field)
+			}
+		}
 
-		var users []types.User
-		if err := cursor.All(context.Background(), &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode users"})
+		list, total, err := users.List(context.Background(), filter, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
 
-		if users == nil {
-			users = []types.User{}
+		response := types.PaginatedUsersResponse{
+			Pagination: types.PaginationMeta{
+				Page:       page,
+				PerPage:    perPage,
+				Total:      int(total),
+				TotalPages: (int(total) + perPage - 1) / perPage,
+			},
+		}
+		if filter.Fields == nil {
+			response.Data = list
+		} else {
+			// Only the selected fields, keyed by their JSON names
+			data := make([]gin.H, len(list))
+			for i, user := range list {
+				all := gin.H{
+					"id":                user.ID,
+					"username":          user.Username,
+					"email":             user.Email,
+					"storageUsedBytes":  user.StorageUsedBytes,
+					"storageQuotaBytes": user.StorageQuotaBytes,
+					"createdAt":         user.CreatedAt,
+					"updatedAt":         user.UpdatedAt,
+				}
+				data[i] = gin.H{}
+				for _, field := range filter.Fields {
+					data[i][field] = all[field]
+				}
+			}
+			response.Data = data
 		}
 
-		c.JSON(http.StatusOK, users)
+		c.JSON(http.StatusOK, response)
 	}
 }
 
-// DeleteUserHandler deletes a user by ID
-func DeleteUserHandler(collection *mongo.Collection) gin.HandlerFunc {
+// DeleteUserHandler deletes a user by ID. Users with projects or simulations
+// are only deleted with ?cascade=true, which first deletes their simulations
+// (log files, per-simulation databases and documents), their upload directory
+// and their projects. A cascade continues past failures and reports them; the
+// user itself is kept while any project or simulation is left, so it can be retried.
+func DeleteUserHandler(
+	users repository.UserRepo, projects repository.ProjectRepo, simulations repository.SimulationRepo, store storage.Storage, queue *processing.Queue, uploadDir string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Param("userId")
 		objectID, err := primitive.ObjectIDFromHex(userID)
@@ -179,17 +310,147 @@ func DeleteUserHandler(collection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+		ctx := context.Background()
+		_, err = users.Get(ctx, objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		projectCount, err := projects.CountByUser(ctx, objectID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
+		simulationCount, err := simulations.Count(ctx, repository.SimulationFilter{UserID: objectID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if (projectCount > 0 || simulationCount > 0) && c.Query("cascade") != "true" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":       "User has projects or simulations, delete them first or use ?cascade=true",
+				"projects":    projectCount,
+				"simulations": simulationCount,
+			})
+			return
+		}
+
+		summary := deleteUserDependents(ctx, objectID, projects, simulations, store, queue, uploadDir)
+		if summary.Projects < projectCount || summary.Simulations < simulationCount {
+			c.JSON(http.StatusInternalServerError, summary)
+			return
+		}
+
+		err = users.Delete(ctx, objectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		} else if err != nil {
+			summary.Failures = append(summary.Failures, fmt.Sprintf("user: %v", err))
+			c.JSON(http.StatusInternalServerError, summary)
+			return
+		}
+		summary.UserDeleted = true
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+// deleteUserDependents deletes the simulations, upload directory and projects of
+// a user, continuing past failures
+func deleteUserDependents(
+	ctx context.Context, userID primitive.ObjectID, projects repository.ProjectRepo, simulations repository.SimulationRepo, store storage.Storage, queue *processing.Queue, uploadDir string,
+) types.UserDeletionSummary {
+	summary := types.UserDeletionSummary{}
+	fail := func(format string, args ...any) {
+		summary.Failures = append(summary.Failures, fmt.Sprintf(format, args...))
+	}
+
+	userSimulations, err := simulations.List(ctx, repository.SimulationFilter{UserID: userID})
+	if err != nil {
+		fail("simulations: %v", err)
+		return summary
+	}
+
+	for _, simulation := range userSimulations {
+		// The user goes away, so there is no storage usage to release
+		deletion, err := deleteSimulation(ctx, simulations, store, queue, nil, uploadDir, simulation, true)
+		for _, failure := range deletion.Failures {
+			fail("simulation %s: %s", simulation.ID.Hex(), failure)
+		}
+		if err != nil {
+			fail("simulation %s: %v", simulation.ID.Hex(), err)
+		}
+		summary.LogFiles += deletion.LogFiles
+		if deletion.DatabaseDropped {
+			summary.Databases++
+		}
+		if deletion.Deleted {
+			summary.Simulations++
+		}
+	}
+
+	if err := store.RemoveDir(ctx, utils.GetUserKey(userID)); err != nil {
+		fail("upload directory: %v", err)
+	}
+
+	deleted, err := projects.DeleteByUser(ctx, userID)
+	if err != nil {
+		fail("projects: %v", err)
+	} else {
+		summary.Projects = deleted
+	}
+	return summary
+}
+
+// GetUserStorageHandler returns a user's storage usage, quota and per-project
+// breakdown, including the processed output on disk
+func GetUserStorageHandler(simulations repository.SimulationRepo, store storage.Storage, quotas *quota.Quota) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("userId")
+		objectID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
 
-		if result.DeletedCount == 0 {
+		used, limit, err := quotas.Usage(context.Background(), objectID)
+		if errors.Is(err, quota.ErrUserNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		projects, err := projectStorageUsage(context.Background(), simulations, store, repository.SimulationFilter{UserID: objectID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute storage usage"})
+			return
+		}
+
+		response := types.UserStorageResponse{
+			UserID:     objectID,
+			UsedBytes:  used,
+			QuotaBytes: limit,
+			Projects:   projects,
+		}
+		for _, project := range projects {
+			response.ProcessedBytes += project.ProcessedBytes
+			response.MissingOnDisk += project.MissingOnDisk
+		}
+		if limit > 0 {
+			remaining := limit - used
+			if remaining < 0 {
+				remaining = 0
+			}
+			response.RemainingBytes = &remaining
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+		c.JSON(http.StatusOK, response)
 	}
 }
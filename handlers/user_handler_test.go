@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// serve routes one request to handler registered at pattern and returns the
+// status and decoded JSON body
+func serve(t *testing.T, handler gin.HandlerFunc, method, pattern, target, body string) (int, map[string]any) {
+	t.Helper()
+	router := gin.New()
+	router.Handle(method, pattern, handler)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	router.ServeHTTP(recorder, req)
+
+	var decoded map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode %s: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, decoded
+}
+
+// seedUsers stores users named by usernames, created a minute apart in order
+func seedUsers(t *testing.T, users repository.UserRepo, usernames ...string) []types.User {
+	t.Helper()
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var seeded []types.User
+	for i, username := range usernames {
+		email := username + "@Mail.io"
+		user := types.User{
+			Username:        username,
+			Email:           email,
+			EmailNormalized: repository.NormalizeEmail(email),
+			CreatedAt:       created.Add(time.Duration(i) * time.Minute),
+		}
+		if err := users.Create(context.Background(), &user); err != nil {
+			t.Fatal(err)
+		}
+		seeded = append(seeded, user)
+	}
+	return seeded
+}
+
+func TestCreateUserHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "valid", body: `{"username":"bob","email":"bob@mail.io"}`, wantStatus: http.StatusCreated},
+		{name: "invalid JSON", body: `{"username":`, wantStatus: http.StatusBadRequest, wantError: "Validation failed"},
+		{name: "missing username", body: `{"email":"bob@mail.io"}`, wantStatus: http.StatusBadRequest, wantError: "Validation failed"},
+		{name: "short username", body: `{"username":"bo","email":"bob@mail.io"}`, wantStatus: http.StatusBadRequest, wantError: "Validation failed"},
+		{name: "non-alphanumeric username", body: `{"username":"bob-1","email":"bob@mail.io"}`, wantStatus: http.StatusBadRequest, wantError: "Validation failed"},
+		{name: "invalid email", body: `{"username":"bob","email":"bob"}`, wantStatus: http.StatusBadRequest, wantError: "Validation failed"},
+		{name: "reserved username", body: `{"username":"Admin","email":"bob@mail.io"}`, wantStatus: http.StatusBadRequest, wantError: "username is reserved"},
+		{name: "blacklisted domain", body: `{"username":"bob","email":"bob@example.com"}`, wantStatus: http.StatusBadRequest, wantError: "email domain is not allowed"},
+		{name: "taken username", body: `{"username":"alice","email":"bob@mail.io"}`, wantStatus: http.StatusConflict, wantError: "already exists"},
+		{name: "taken email in another case", body: `{"username":"bob","email":"ALICE@mail.io"}`, wantStatus: http.StatusConflict, wantError: "already exists"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := repository.NewMemoryUserRepo()
+			seedUsers(t, users, "alice")
+
+			status, body := serve(t, CreateUserHandler(users), http.MethodPost, "/users", "/users", tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if tt.wantError != "" {
+				if message, _ := body["error"].(string); !strings.Contains(message, tt.wantError) {
+					t.Errorf("error = %q, want it to contain %q", message, tt.wantError)
+				}
+				return
+			}
+			stored, err := users.GetByUsername(context.Background(), "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body["id"] != stored.ID.Hex() || stored.EmailNormalized != "bob@mail.io" {
+				t.Errorf("response %v does not match the stored user %+v", body, stored)
+			}
+		})
+	}
+}
+
+func TestCreateUserHandlerConcurrentSameEmail(t *testing.T) {
+	users := repository.NewMemoryUserRepo()
+	router := gin.New()
+	router.POST("/users", CreateUserHandler(users))
+
+	// The same email in different cases, registered at once under different usernames
+	emails := []string{"racer@mail.io", "RACER@mail.io", "Racer@Mail.io", "racer@mail.IO", "racer@MAIL.io", "rAcEr@mail.io"}
+	statuses := make([]int, len(emails))
+	var wg sync.WaitGroup
+	for i, email := range emails {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"username":"racer%d","email":%q}`, i, email)
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			statuses[i] = recorder.Code
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	want := map[int]int{http.StatusCreated: 1, http.StatusConflict: len(emails) - 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("statuses = %v, want one %d and the rest %d", statuses, http.StatusCreated, http.StatusConflict)
+	}
+}
+
+func TestGetUserHandler(t *testing.T) {
+	users := repository.NewMemoryUserRepo()
+	alice := seedUsers(t, users, "alice")[0]
+
+	tests := []struct {
+		name       string
+		userID     string
+		wantStatus int
+	}{
+		{name: "existing", userID: alice.ID.Hex(), wantStatus: http.StatusOK},
+		{name: "invalid ID", userID: "alice", wantStatus: http.StatusBadRequest},
+		{name: "unknown", userID: primitive.NewObjectID().Hex(), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := serve(t, GetUserHandler(users), http.MethodGet, "/users/:userId", "/users/"+tt.userID, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status == http.StatusOK && body["username"] != "alice" {
+				t.Errorf("body = %v, want alice", body)
+			}
+		})
+	}
+}
+
+func TestUpdateUserHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     func(alice types.User) string
+		body       string
+		wantStatus int
+		wantEmail  string
+	}{
+		{name: "email", body: `{"email":"New@Mail.io"}`, wantStatus: http.StatusOK, wantEmail: "New@Mail.io"},
+		{name: "keeping the own username", body: `{"username":"alice"}`, wantStatus: http.StatusOK, wantEmail: "alice@Mail.io"},
+		{name: "invalid ID", userID: func(types.User) string { return "alice" }, body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown", userID: func(types.User) string { return primitive.NewObjectID().Hex() }, body: `{}`, wantStatus: http.StatusNotFound},
+		{name: "invalid username", body: `{"username":"a b"}`, wantStatus: http.StatusBadRequest},
+		{name: "reserved username", body: `{"username":"root"}`, wantStatus: http.StatusBadRequest},
+		{name: "blacklisted domain", body: `{"email":"alice@test.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "taken username", body: `{"username":"carol"}`, wantStatus: http.StatusConflict},
+		{name: "taken email in another case", body: `{"email":"CAROL@mail.io"}`, wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := repository.NewMemoryUserRepo()
+			alice := seedUsers(t, users, "alice", "carol")[0]
+			userID := alice.ID.Hex()
+			if tt.userID != nil {
+				userID = tt.userID(alice)
+			}
+
+			status, body := serve(t, UpdateUserHandler(users), http.MethodPut, "/users/:userId", "/users/"+userID, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status == http.StatusOK && body["email"] != tt.wantEmail {
+				t.Errorf("email = %v, want %s", body["email"], tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestGetUsersHandler(t *testing.T) {
+	users := repository.NewMemoryUserRepo()
+	// Listed newest first: erin, dave, carol, bob, alice
+	seedUsers(t, users, "alice", "bob", "carol", "dave", "erin")
+
+	tests := []struct {
+		name           string
+		query          string
+		wantStatus     int
+		wantUsernames  []string
+		wantPagination types.PaginationMeta
+		wantKeys       []string // Keys of every listed user, when set
+	}{
+		{
+			name:           "defaults",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"erin", "dave", "carol", "bob", "alice"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 100, Total: 5, TotalPages: 1},
+		},
+		{
+			name:           "first page",
+			query:          "page=1&perPage=2",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"erin", "dave"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 2, Total: 5, TotalPages: 3},
+		},
+		{
+			name:           "last partial page",
+			query:          "page=3&perPage=2",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"alice"},
+			wantPagination: types.PaginationMeta{Page: 3, PerPage: 2, Total: 5, TotalPages: 3},
+		},
+		{
+			name:           "page past the end",
+			query:          "page=4&perPage=2",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{},
+			wantPagination: types.PaginationMeta{Page: 4, PerPage: 2, Total: 5, TotalPages: 3},
+		},
+		{
+			name:           "invalid values fall back to the defaults",
+			query:          "page=0&perPage=5000",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"erin", "dave", "carol", "bob", "alice"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 100, Total: 5, TotalPages: 1},
+		},
+		{
+			name:           "search",
+			query:          "q=A",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"erin", "dave", "carol", "bob", "alice"}, // Every email contains "mail"
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 100, Total: 5, TotalPages: 1},
+		},
+		{
+			name:           "search of a username",
+			query:          "q=AR",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"carol"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 100, Total: 1, TotalPages: 1},
+		},
+		{
+			name:           "exact username",
+			query:          "username=bob",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"bob"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 100, Total: 1, TotalPages: 1},
+		},
+		{
+			name:           "email in another case",
+			query:          "email=DAVE@mail.IO",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"dave"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 100, Total: 1, TotalPages: 1},
+		},
+		{
+			name:           "selected fields",
+			query:          "fields=username,%20createdAt&perPage=1",
+			wantStatus:     http.StatusOK,
+			wantUsernames:  []string{"erin"},
+			wantPagination: types.PaginationMeta{Page: 1, PerPage: 1, Total: 5, TotalPages: 5},
+			wantKeys:       []string{"createdAt", "username"},
+		},
+		{name: "unsupported field", query: "fields=username,emailNormalized", wantStatus: http.StatusBadRequest},
+		{name: "search too long", query: "q=" + strings.Repeat("a", 201), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := serve(t, GetUsersHandler(users), http.MethodGet, "/users", "/users?"+tt.query, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			usernames := []string{}
+			for _, value := range body["data"].([]any) {
+				user := value.(map[string]any)
+				usernames = append(usernames, fmt.Sprint(user["username"]))
+				if tt.wantKeys != nil {
+					var keys []string
+					for key := range user {
+						keys = append(keys, key)
+					}
+					if len(keys) != len(tt.wantKeys) || user["createdAt"] == nil {
+						t.Errorf("user = %v, want only %v", user, tt.wantKeys)
+					}
+				}
+			}
+			if !reflect.DeepEqual(usernames, tt.wantUsernames) {
+				t.Errorf("usernames = %v, want %v", usernames, tt.wantUsernames)
+			}
+
+			var pagination types.PaginationMeta
+			encoded, _ := json.Marshal(body["pagination"])
+			if err := json.Unmarshal(encoded, &pagination); err != nil {
+				t.Fatal(err)
+			}
+			if pagination != tt.wantPagination {
+				t.Errorf("pagination = %+v, want %+v", pagination, tt.wantPagination)
+			}
+		})
+	}
+}
+
+func TestDeleteUserHandlerRejectsUnknownUsers(t *testing.T) {
+	users := repository.NewMemoryUserRepo()
+	tests := []struct {
+		name       string
+		userID     string
+		wantStatus int
+	}{
+		{name: "invalid ID", userID: "alice", wantStatus: http.StatusBadRequest},
+		{name: "unknown", userID: primitive.NewObjectID().Hex(), wantStatus: http.StatusNotFound},
+	}
+
+	// Both are rejected before the projects and simulations are counted
+	handler := DeleteUserHandler(users, nil, nil, nil, nil, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := serve(t, handler, http.MethodDelete, "/users/:userId", "/users/"+tt.userID, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+		})
+	}
+}
+
+func TestDeleteUserHandlerCascade(t *testing.T) {
+	users := repository.NewMemoryUserRepo()
+	projects := repository.NewMemoryProjectRepo()
+	simulations := repository.NewMemorySimulationRepo()
+	seeded := seedUsers(t, users, "alice", "bob")
+	alice, bob := seeded[0], seeded[1]
+	project := seedProject(t, projects, alice.ID, "testnet", "")
+	run := seedSimulation(t, simulations, project, "run", types.SimulationStatusProcessed, 10, "node0.log")
+	kept := seedSimulation(t, simulations, seedProject(t, projects, bob.ID, "devnet", ""), "run", types.SimulationStatusProcessed, 10, "node0.log")
+
+	handler := DeleteUserHandler(users, projects, simulations, storage.NewLocalStorage(t.TempDir()), processing.NewQueue(), "")
+	status, body := serve(t, handler, http.MethodDelete, "/users/:userId", "/users/"+alice.ID.Hex(), "")
+	if status != http.StatusConflict {
+		t.Fatalf("without cascade: status = %d, want %d: %v", status, http.StatusConflict, body)
+	}
+
+	status, body = serve(t, handler, http.MethodDelete, "/users/:userId", "/users/"+alice.ID.Hex()+"?cascade=true", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if body["userDeleted"] != true || body["projects"] != float64(1) || body["simulations"] != float64(1) || body["databases"] != float64(1) {
+		t.Errorf("summary = %v, want the user, 1 project, 1 simulation and 1 database deleted", body)
+	}
+	if !simulations.Dropped(run.ID) {
+		t.Error("database of the deleted simulation was not dropped")
+	}
+	if _, err := simulations.Get(context.Background(), kept.ID); err != nil {
+		t.Errorf("simulation of another user: %v", err)
+	}
+	if count, _ := projects.CountByUser(context.Background(), bob.ID); count != 1 {
+		t.Errorf("%d projects of another user left, want 1", count)
+	}
+}
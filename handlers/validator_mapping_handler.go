@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+)
+
+// GetSimulationValidatorsHandler returns the validator index to node ID
+// mapping of a specific simulation. The mapping is stored after processing;
+// for simulations processed before it was, it is derived on the first request.
+func GetSimulationValidatorsHandler(client *mongo.Client, simulations repository.SimulationRepo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		simulation, ok := loadSimulation(c, simulations)
+		if !ok {
+			return
+		}
+		database := client.Database(simulation.ID.Hex())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		mappings, err := metrics.LoadValidatorMapping(ctx, database)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load validator mapping"})
+			return
+		}
+
+		if len(mappings) == 0 && simulation.Status == types.SimulationStatusProcessed {
+			if !checkRawEventsRetained(c, simulation, "tracer_events") {
+				return
+			}
+			mappings, err = metrics.DeriveValidatorMapping(ctx, database.Collection("tracer_events"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to derive validator mapping"})
+				return
+			}
+			if err := metrics.StoreValidatorMapping(ctx, database, mappings); err != nil {
+				fmt.Printf("Warning: Failed to store validator mapping of simulation %s: %v\n", simulation.ID.Hex(), err)
+			}
+		}
+
+		c.JSON(http.StatusOK, mappings)
+	}
+}
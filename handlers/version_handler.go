@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// etlVersionTimeout bounds running the ETL binary with --version
+const etlVersionTimeout = 5 * time.Second
+
+// DetectETLVersion looks up etlBinary on PATH and captures its --version
+// output. It runs once at startup, so version requests do not spawn processes.
+func DetectETLVersion(etlBinary string) types.ETLVersionInfo {
+	path, err := exec.LookPath(etlBinary)
+	if err != nil {
+		return types.ETLVersionInfo{Error: err.Error()}
+	}
+	info := types.ETLVersionInfo{Found: true, Path: path}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etlVersionTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		info.Error = err.Error()
+	}
+	info.Version = strings.TrimSpace(string(output))
+	return info
+}
+
+// VersionHandler reports the build information collected at startup
+func VersionHandler(info types.VersionResponse) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, info)
+	}
+}
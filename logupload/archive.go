@@ -0,0 +1,234 @@
+package logupload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrNotArchive is returned when an archive upload is neither a tar(.gz) nor a zip file
+	ErrNotArchive = errors.New("unsupported archive format")
+	// ErrUnsafeArchiveEntry is returned for path traversal and non-regular archive entries
+	ErrUnsafeArchiveEntry = errors.New("unsafe archive entry")
+	// ErrArchiveTooLarge is returned when an archive exceeds the entry-count or total-size cap
+	ErrArchiveTooLarge = errors.New("archive exceeds extraction limits")
+	// ErrNoLogFiles is returned when an archive does not contain any *.log entries
+	ErrNoLogFiles = errors.New("archive contains no .log files")
+)
+
+// ArchiveEntryError names the archive entry that caused an extraction error
+type ArchiveEntryError struct {
+	Entry string
+	Err   error
+}
+
+func (e *ArchiveEntryError) Error() string {
+	return fmt.Sprintf("archive entry %q: %v", e.Entry, e.Err)
+}
+
+func (e *ArchiveEntryError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractedFile describes a log file extracted from an archive
+type ExtractedFile struct {
+	Name       string      // Entry name inside the archive
+	Path       string      // Path of the extracted file on disk
+	Size       int64       // Extracted (decompressed) size
+	Compressed Compression // Compression of the entry itself, if any
+	Checksum   string      // Hex-encoded SHA-256 of the extracted content
+}
+
+// extractor writes archive entries to disk while enforcing the archive limits
+type extractor struct {
+	dir        string
+	limits     Limits
+	storedName func(entry string) string
+	entries    int
+	totalBytes int64
+	files      []ExtractedFile
+}
+
+// ExtractArchive extracts the *.log entries of a tar, tar.gz or zip archive into dir.
+// storedName maps an entry name to the filename used on disk. Path traversal and
+// non-regular entries are rejected, and all extracted files are removed on error.
+func ExtractArchive(src io.ReaderAt, size int64, dir string, limits Limits, storedName func(entry string) string) ([]ExtractedFile, error) {
+	header := make([]byte, 512)
+	n, err := src.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	ex := &extractor{dir: dir, limits: limits, storedName: storedName}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		err = ex.extractZip(src, size)
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, gzErr := gzip.NewReader(io.NewSectionReader(src, 0, size))
+		if gzErr != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptArchive, gzErr)
+		}
+		err = ex.extractTar(gz)
+		gz.Close()
+	case len(header) > 262 && string(header[257:262]) == "ustar":
+		err = ex.extractTar(io.NewSectionReader(src, 0, size))
+	default:
+		return nil, ErrNotArchive
+	}
+
+	if err == nil && len(ex.files) == 0 {
+		err = ErrNoLogFiles
+	}
+	if err != nil {
+		for _, file := range ex.files {
+			os.Remove(file.Path)
+		}
+		return nil, err
+	}
+
+	return ex.files, nil
+}
+
+func (ex *extractor) extractZip(src io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(src, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+
+	for _, file := range reader.File {
+		if err := ex.countEntry(file.Name); err != nil {
+			return err
+		}
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			return &ArchiveEntryError{Entry: file.Name, Err: ErrUnsafeArchiveEntry}
+		}
+		if !isLogEntry(file.Name) {
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return &ArchiveEntryError{Entry: file.Name, Err: fmt.Errorf("%w: %v", ErrCorruptArchive, err)}
+		}
+		err = ex.writeEntry(file.Name, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ex *extractor) extractTar(src io.Reader) error {
+	reader := tar.NewReader(src)
+
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			if err := ex.countEntry(hdr.Name); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeReg:
+		default:
+			return &ArchiveEntryError{Entry: hdr.Name, Err: ErrUnsafeArchiveEntry}
+		}
+
+		if err := ex.countEntry(hdr.Name); err != nil {
+			return err
+		}
+		if !isLogEntry(hdr.Name) {
+			continue
+		}
+		if err := ex.writeEntry(hdr.Name, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// countEntry validates an entry name and enforces the entry-count cap
+func (ex *extractor) countEntry(name string) error {
+	if !isSafeEntryName(name) {
+		return &ArchiveEntryError{Entry: name, Err: ErrUnsafeArchiveEntry}
+	}
+
+	ex.entries++
+	if ex.entries > ex.limits.MaxArchiveEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, ex.limits.MaxArchiveEntries)
+	}
+	return nil
+}
+
+// writeEntry extracts a single log entry and enforces the total-size cap
+func (ex *extractor) writeEntry(name string, content io.Reader) error {
+	filePath := filepath.Join(ex.dir, ex.storedName(name))
+
+	written, err := WriteLogFile(filePath, name, content, ex.limits)
+	if err != nil {
+		return &ArchiveEntryError{Entry: name, Err: err}
+	}
+
+	ex.files = append(ex.files, ExtractedFile{
+		Name:       name,
+		Path:       filePath,
+		Size:       written.Size,
+		Compressed: written.Compression,
+		Checksum:   written.Checksum,
+	})
+
+	ex.totalBytes += written.Size
+	if ex.totalBytes > ex.limits.MaxArchiveBytes {
+		return fmt.Errorf("%w: more than %d extracted bytes", ErrArchiveTooLarge, ex.limits.MaxArchiveBytes)
+	}
+	return nil
+}
+
+// isSafeEntryName rejects absolute paths and entries escaping the extraction directory
+func isSafeEntryName(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" {
+		return false
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// isLogEntry reports whether an archive entry is a log file worth extracting,
+// ignoring macOS resource fork files
+func isLogEntry(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	base := path.Base(name)
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, "._") {
+		return false
+	}
+	return strings.EqualFold(path.Ext(base), ".log")
+}
@@ -0,0 +1,292 @@
+package logupload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testLogLine = `{"level":"info","msg":"entering new round"}` + "\n"
+
+// archiveEntry is an entry of a fixture archive
+type archiveEntry struct {
+	name    string
+	content string
+	mode    os.FileMode // Zero for a regular file
+	tarType byte        // Zero for tar.TypeReg
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		header.SetMode(0o644)
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, entries []archiveEntry, gzipped bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	w := tar.NewWriter(&buf)
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		w = tar.NewWriter(gz)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		if entry.tarType != 0 {
+			header.Typeflag = entry.tarType
+			header.Size = 0
+			header.Linkname = "target.log"
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := w.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+var testLimits = Limits{
+	MaxFileBytes:      1 << 20,
+	MaxRequestBytes:   1 << 20,
+	MaxArchiveEntries: 10,
+	MaxArchiveBytes:   1 << 20,
+}
+
+// extract runs ExtractArchive into a fresh directory and returns it with the result
+func extract(t *testing.T, archive []byte, limits Limits) (string, []ExtractedFile, error) {
+	t.Helper()
+	dir := t.TempDir()
+	storedName := func(entry string) string { return strings.NewReplacer("/", "_", "\\", "_").Replace(entry) }
+	files, err := ExtractArchive(bytes.NewReader(archive), int64(len(archive)), dir, limits, storedName)
+	return dir, files, err
+}
+
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+	left, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Fatalf("%d files left in the extraction directory after the error", len(left))
+	}
+}
+
+func TestExtractArchiveExtractsLogEntries(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "node0/consensus.log", content: testLogLine},
+		{name: "README.txt", content: "not a log"},
+		{name: "__MACOSX/node0/._consensus.log", content: "resource fork"},
+		{name: "node1/CONSENSUS.LOG", content: testLogLine + testLogLine},
+	}
+	archives := map[string][]byte{
+		"zip":    buildZip(t, entries),
+		"tar":    buildTar(t, entries, false),
+		"tar.gz": buildTar(t, entries, true),
+	}
+
+	for format, archive := range archives {
+		t.Run(format, func(t *testing.T) {
+			_, files, err := extract(t, archive, testLimits)
+			if err != nil {
+				t.Fatalf("ExtractArchive: %v", err)
+			}
+			if len(files) != 2 {
+				t.Fatalf("extracted %d files, want 2", len(files))
+			}
+			for i, want := range []archiveEntry{entries[0], entries[3]} {
+				if files[i].Name != want.name || files[i].Size != int64(len(want.content)) {
+					t.Errorf("file %d = %s (%d bytes), want %s (%d bytes)", i, files[i].Name, files[i].Size, want.name, len(want.content))
+				}
+				content, err := os.ReadFile(files[i].Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != want.content {
+					t.Errorf("file %d content = %q, want %q", i, content, want.content)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry archiveEntry
+		zip   bool
+		tar   bool
+	}{
+		{name: "zip slip", entry: archiveEntry{name: "../evil.log", content: testLogLine}, zip: true, tar: true},
+		{name: "nested zip slip", entry: archiveEntry{name: "logs/../../evil.log", content: testLogLine}, zip: true, tar: true},
+		{name: "backslash zip slip", entry: archiveEntry{name: `logs\..\..\evil.log`, content: testLogLine}, zip: true, tar: true},
+		{name: "absolute path", entry: archiveEntry{name: "/tmp/evil.log", content: testLogLine}, zip: true, tar: true},
+		{name: "symlink", entry: archiveEntry{name: "link.log", content: "target.log", mode: os.ModeSymlink | 0o777, tarType: tar.TypeSymlink}, zip: true, tar: true},
+		{name: "hard link", entry: archiveEntry{name: "link.log", tarType: tar.TypeLink}, tar: true},
+		{name: "device", entry: archiveEntry{name: "dev.log", tarType: tar.TypeChar}, tar: true},
+		{name: "fifo", entry: archiveEntry{name: "fifo.log", content: "", mode: os.ModeNamedPipe | 0o644, tarType: tar.TypeFifo}, zip: true, tar: true},
+	}
+
+	for _, tt := range tests {
+		// A valid entry first, so the test also checks it is cleaned up
+		entries := []archiveEntry{{name: "ok.log", content: testLogLine}, tt.entry}
+		archives := map[string][]byte{}
+		if tt.zip {
+			archives["zip"] = buildZip(t, entries)
+		}
+		if tt.tar {
+			archives["tar"] = buildTar(t, entries, false)
+		}
+
+		for format, archive := range archives {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				dir, files, err := extract(t, archive, testLimits)
+				if !errors.Is(err, ErrUnsafeArchiveEntry) {
+					t.Fatalf("error = %v, want %v", err, ErrUnsafeArchiveEntry)
+				}
+				var entryErr *ArchiveEntryError
+				if !errors.As(err, &entryErr) || entryErr.Entry != tt.entry.name {
+					t.Errorf("error %v does not name entry %q", err, tt.entry.name)
+				}
+				if files != nil {
+					t.Errorf("got %d files with the error", len(files))
+				}
+				assertEmptyDir(t, dir)
+			})
+		}
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	threeLogs := []archiveEntry{
+		{name: "a.log", content: testLogLine},
+		{name: "b.log", content: testLogLine},
+		{name: "c.log", content: testLogLine},
+	}
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		limits  func(*Limits)
+		wantErr error
+	}{
+		{
+			name:    "entry count at the cap",
+			entries: threeLogs,
+			limits:  func(l *Limits) { l.MaxArchiveEntries = 3 },
+		},
+		{
+			name:    "entry count over the cap",
+			entries: threeLogs,
+			limits:  func(l *Limits) { l.MaxArchiveEntries = 2 },
+			wantErr: ErrArchiveTooLarge,
+		},
+		{
+			name:    "non-log entries count towards the cap",
+			entries: append([]archiveEntry{{name: "notes.txt", content: "x"}}, threeLogs...),
+			limits:  func(l *Limits) { l.MaxArchiveEntries = 3 },
+			wantErr: ErrArchiveTooLarge,
+		},
+		{
+			name:    "bytes at the cap",
+			entries: threeLogs,
+			limits:  func(l *Limits) { l.MaxArchiveBytes = int64(3 * len(testLogLine)) },
+		},
+		{
+			name:    "bytes over the cap",
+			entries: threeLogs,
+			limits:  func(l *Limits) { l.MaxArchiveBytes = int64(3*len(testLogLine)) - 1 },
+			wantErr: ErrArchiveTooLarge,
+		},
+		{
+			name:    "single file over the file cap",
+			entries: []archiveEntry{{name: "big.log", content: strings.Repeat(testLogLine, 4)}},
+			limits:  func(l *Limits) { l.MaxFileBytes = int64(len(testLogLine)) },
+			wantErr: ErrFileTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		limits := testLimits
+		tt.limits(&limits)
+		archives := map[string][]byte{
+			"zip": buildZip(t, tt.entries),
+			"tar": buildTar(t, tt.entries, false),
+		}
+		for format, archive := range archives {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				dir, files, err := extract(t, archive, limits)
+				if tt.wantErr == nil {
+					if err != nil {
+						t.Fatalf("ExtractArchive: %v", err)
+					}
+					if len(files) != len(threeLogs) {
+						t.Fatalf("extracted %d files, want %d", len(files), len(threeLogs))
+					}
+					return
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				assertEmptyDir(t, dir)
+			})
+		}
+	}
+}
+
+func TestExtractArchiveRejectsOtherContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr error
+	}{
+		{name: "plain log", archive: []byte(testLogLine), wantErr: ErrNotArchive},
+		{name: "empty", archive: nil, wantErr: ErrNotArchive},
+		{name: "no log entries", archive: buildZip(t, []archiveEntry{{name: "notes.txt", content: "x"}}), wantErr: ErrNoLogFiles},
+		{name: "truncated gzip", archive: buildTar(t, []archiveEntry{{name: "a.log", content: testLogLine}}, true)[:20], wantErr: ErrCorruptArchive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := extract(t, tt.archive, testLimits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
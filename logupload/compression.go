@@ -0,0 +1,113 @@
+package logupload
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies how an uploaded file was compressed
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// ErrCorruptArchive is returned when a compressed upload cannot be decompressed
+var ErrCorruptArchive = errors.New("corrupt compressed file")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionFromExtension returns the compression implied by the filename extension
+func compressionFromExtension(filename string) Compression {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// StoredName returns the name under which an upload is stored on disk, i.e. the
+// base name of the original filename (or archive entry) without a compression extension
+func StoredName(filename string) string {
+	base := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if compressionFromExtension(base) == CompressionNone {
+		return base
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Decompress detects gzip or zstd content by magic bytes (or the filename
+// extension) and returns a reader yielding the decompressed content.
+// Decompression failures surface as errors wrapping ErrCorruptArchive.
+func Decompress(r io.Reader, filename string) (io.ReadCloser, Compression, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, CompressionNone, err
+	}
+
+	compression := CompressionNone
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		compression = CompressionGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		compression = CompressionZstd
+	}
+
+	// A compression extension without matching magic bytes means the archive is broken
+	if ext := compressionFromExtension(filename); ext != CompressionNone && ext != compression {
+		return nil, ext, fmt.Errorf("%w: missing %s header", ErrCorruptArchive, ext)
+	}
+
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, compression, fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+		}
+		return &corruptionReader{r: gz, close: gz.Close}, compression, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, compression, fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+		}
+		return &corruptionReader{r: zr, close: func() error { zr.Close(); return nil }}, compression, nil
+	default:
+		return io.NopCloser(buffered), CompressionNone, nil
+	}
+}
+
+// corruptionReader tags decompression errors with ErrCorruptArchive
+type corruptionReader struct {
+	r     io.Reader
+	close func() error
+}
+
+func (cr *corruptionReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+	return n, err
+}
+
+func (cr *corruptionReader) Close() error {
+	return cr.close()
+}
@@ -0,0 +1,220 @@
+package logupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFetchTimeout is the default time allowed for downloading a single log file
+	DefaultFetchTimeout = 10 * time.Minute
+	// DefaultFetchConcurrency is the default number of log files downloaded in parallel
+	DefaultFetchConcurrency = 4
+)
+
+// ErrURLNotAllowed is returned for URLs whose scheme or host is not allowlisted
+var ErrURLNotAllowed = errors.New("URL is not allowed")
+
+// FetchConfig restricts which URLs log files may be fetched from
+type FetchConfig struct {
+	AllowedSchemes []string      // e.g. "https"
+	AllowedHosts   []string      // Exact hostnames or "*.example.com" wildcards
+	Timeout        time.Duration // Per-download timeout
+	Concurrency    int           // Maximum parallel downloads per request
+}
+
+// FetchConfigFromEnv reads FETCH_ALLOWED_HOSTS, FETCH_ALLOWED_SCHEMES (default
+// "https"), FETCH_TIMEOUT and FETCH_CONCURRENCY. Fetching is disabled while
+// no hosts are allowlisted.
+func FetchConfigFromEnv() (FetchConfig, error) {
+	config := FetchConfig{
+		AllowedSchemes: []string{"https"},
+		AllowedHosts:   splitList(os.Getenv("FETCH_ALLOWED_HOSTS")),
+		Timeout:        DefaultFetchTimeout,
+		Concurrency:    DefaultFetchConcurrency,
+	}
+
+	if value := os.Getenv("FETCH_ALLOWED_SCHEMES"); value != "" {
+		config.AllowedSchemes = splitList(value)
+	}
+
+	if value := os.Getenv("FETCH_TIMEOUT"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return config, fmt.Errorf("invalid FETCH_TIMEOUT: %q", value)
+		}
+		config.Timeout = parsed
+	}
+
+	if value := os.Getenv("FETCH_CONCURRENCY"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return config, fmt.Errorf("invalid FETCH_CONCURRENCY: %q", value)
+		}
+		config.Concurrency = parsed
+	}
+
+	return config, nil
+}
+
+// splitList splits a comma separated list, dropping empty elements
+func splitList(value string) []string {
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.ToLower(strings.TrimSpace(element)); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+// Enabled reports whether any host is allowlisted
+func (c FetchConfig) Enabled() bool {
+	return len(c.AllowedHosts) > 0
+}
+
+// CheckURL parses rawURL and verifies its scheme and host against the allowlists
+func (c FetchConfig) CheckURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", rawURL)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%w: credentials in URLs are not supported", ErrURLNotAllowed)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	schemeAllowed := false
+	for _, allowed := range c.AllowedSchemes {
+		if scheme == allowed {
+			schemeAllowed = true
+			break
+		}
+	}
+	if !schemeAllowed {
+		return nil, fmt.Errorf("%w: scheme %q", ErrURLNotAllowed, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range c.AllowedHosts {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: host %q", ErrURLNotAllowed, u.Hostname())
+}
+
+// FetchResult is the outcome of downloading a single URL
+type FetchResult struct {
+	URL      string
+	Filename string // Filename derived from the URL path
+	Path     string // Local path of the downloaded file
+	File     WrittenFile
+	Err      error
+}
+
+// Fetcher downloads log files from allowlisted URLs
+type Fetcher struct {
+	config FetchConfig
+	client *http.Client
+}
+
+// NewFetcher creates a Fetcher. Redirects are followed only to allowlisted URLs.
+func NewFetcher(config FetchConfig) *Fetcher {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			_, err := config.CheckURL(req.URL.String())
+			return err
+		},
+	}
+	return &Fetcher{config: config, client: client}
+}
+
+// Config returns the fetcher's configuration
+func (f *Fetcher) Config() FetchConfig {
+	return f.config
+}
+
+// FetchAll downloads urls concurrently into dir, naming each file with
+// tempName. Results are returned in the order of urls.
+func (f *Fetcher) FetchAll(ctx context.Context, urls []string, dir string, limits Limits, tempName func(i int, filename string) string) []FetchResult {
+	results := make([]FetchResult, len(urls))
+	sem := make(chan struct{}, f.config.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, rawURL := range urls {
+		wg.Add(1)
+		go func(i int, rawURL string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = f.fetch(ctx, rawURL, dir, limits, func(filename string) string {
+				return tempName(i, filename)
+			})
+		}(i, rawURL)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// fetch downloads a single URL, validating and decompressing it like an upload
+func (f *Fetcher) fetch(ctx context.Context, rawURL, dir string, limits Limits, tempName func(filename string) string) FetchResult {
+	result := FetchResult{URL: rawURL}
+
+	u, err := f.config.CheckURL(rawURL)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Filename = path.Base(u.Path)
+	if result.Filename == "/" || result.Filename == "." {
+		result.Filename = u.Hostname() + ".log"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Err = fmt.Errorf("server returned %s", resp.Status)
+		return result
+	}
+
+	// Compressed responses are checked again after decompression by WriteLogFile
+	if resp.ContentLength > limits.MaxFileBytes {
+		result.Err = ErrFileTooLarge
+		return result
+	}
+
+	result.Path = filepath.Join(dir, tempName(StoredName(result.Filename)))
+	result.File, result.Err = WriteLogFile(result.Path, result.Filename, resp.Body, limits)
+	return result
+}
@@ -0,0 +1,60 @@
+package logupload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrFileTooLarge is returned when a (decompressed) upload exceeds the per-file limit
+var ErrFileTooLarge = errors.New("file exceeds maximum upload size")
+
+// WrittenFile describes a log file written to disk by WriteLogFile
+type WrittenFile struct {
+	Size        int64       // Bytes written to disk (decompressed size)
+	Compression Compression // Compression of the upload, if any
+	Checksum    string      // Hex-encoded SHA-256 of the decompressed content
+}
+
+// WriteLogFile decompresses src if needed, checks that it looks like a CometBFT
+// log and writes it to path while computing its SHA-256 checksum. Partially
+// written files are removed on error.
+func WriteLogFile(path, filename string, src io.Reader, limits Limits) (WrittenFile, error) {
+	content, compression, err := Decompress(src, filename)
+	if err != nil {
+		return WrittenFile{Compression: compression}, err
+	}
+	defer content.Close()
+
+	sniffed, err := SniffLogFile(content)
+	if err != nil {
+		return WrittenFile{Compression: compression}, err
+	}
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return WrittenFile{Compression: compression}, err
+	}
+
+	// Read one byte past the limit so oversized (decompressed) content is detected
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hash), io.LimitReader(sniffed, limits.MaxFileBytes+1))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > limits.MaxFileBytes {
+		err = ErrFileTooLarge
+	}
+	if err != nil {
+		os.Remove(path)
+		return WrittenFile{Compression: compression}, err
+	}
+
+	return WrittenFile{
+		Size:        written,
+		Compression: compression,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
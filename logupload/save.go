@@ -0,0 +1,135 @@
+package logupload
+
+import (
+	"fmt"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+)
+
+// UploadError names the uploaded file that caused an error
+type UploadError struct {
+	Filename string
+	Err      error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Filename, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// SaveUploadedFiles validates and writes uploaded log files and the *.log
+// entries of uploaded archives to temporary files in dir. Extracted entries
+// record the archive as their Source. Each upload is closed as soon as it has
+// been written, and all written files are removed if any upload fails.
+func SaveUploadedFiles(dir string, files, archives []*multipart.FileHeader, limits Limits) ([]types.LogFileInfo, error) {
+	// Reject oversized files before writing anything to disk
+	for _, fileHeader := range files {
+		if fileHeader.Size > limits.MaxFileBytes {
+			return nil, &UploadError{Filename: fileHeader.Filename, Err: ErrFileTooLarge}
+		}
+	}
+
+	if len(files) == 0 && len(archives) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	var logFiles []types.LogFileInfo
+	fail := func(filename string, err error) ([]types.LogFileInfo, error) {
+		for _, logFile := range logFiles {
+			os.Remove(logFile.FilePath)
+		}
+		return nil, &UploadError{Filename: filename, Err: err}
+	}
+
+	for _, fileHeader := range files {
+		logFile, err := saveUploadedFile(dir, len(logFiles), fileHeader, limits)
+		if err != nil {
+			return fail(fileHeader.Filename, err)
+		}
+		logFiles = append(logFiles, logFile)
+	}
+
+	for _, archiveHeader := range archives {
+		extracted, err := saveUploadedArchive(dir, len(logFiles), archiveHeader, limits)
+		if err != nil {
+			return fail(archiveHeader.Filename, err)
+		}
+		logFiles = append(logFiles, extracted...)
+	}
+
+	return logFiles, nil
+}
+
+// tempFilename generates a temporary filename in the upload staging area
+func tempFilename(index int, filename string) string {
+	return fmt.Sprintf("temp_%d_%d_%s", time.Now().UnixNano(), index, StoredName(filename))
+}
+
+// saveUploadedFile writes a single uploaded log file
+func saveUploadedFile(dir string, index int, fileHeader *multipart.FileHeader, limits Limits) (types.LogFileInfo, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return types.LogFileInfo{}, err
+	}
+	defer file.Close()
+
+	filePath := filepath.Join(dir, tempFilename(index, fileHeader.Filename))
+
+	// Decompress, validate and write the file content
+	written, err := WriteLogFile(filePath, fileHeader.Filename, file, limits)
+	if err != nil {
+		return types.LogFileInfo{}, err
+	}
+
+	return types.LogFileInfo{
+		OriginalFilename: fileHeader.Filename,
+		FilePath:         filePath,
+		FileSize:         written.Size,
+		Compressed:       written.Compression != CompressionNone,
+		Checksum:         written.Checksum,
+		UploadedAt:       time.Now(),
+	}, nil
+}
+
+// saveUploadedArchive extracts the *.log entries of an uploaded archive
+func saveUploadedArchive(dir string, firstIndex int, archiveHeader *multipart.FileHeader, limits Limits) ([]types.LogFileInfo, error) {
+	archive, err := archiveHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	nextIndex := firstIndex
+	extracted, err := ExtractArchive(archive, archiveHeader.Size, dir, limits, func(entry string) string {
+		filename := tempFilename(nextIndex, entry)
+		nextIndex++
+		return filename
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logFiles := make([]types.LogFileInfo, len(extracted))
+	for i, file := range extracted {
+		logFiles[i] = types.LogFileInfo{
+			OriginalFilename: file.Name,
+			FilePath:         file.Path,
+			FileSize:         file.Size,
+			Compressed:       file.Compressed != CompressionNone,
+			Checksum:         file.Checksum,
+			Source:           archiveHeader.Filename,
+			UploadedAt:       time.Now(),
+		}
+	}
+	return logFiles, nil
+}
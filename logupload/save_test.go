@@ -0,0 +1,137 @@
+package logupload
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// upload is a file of a fixture multipart upload
+type upload struct {
+	field    string // "files" or "archives"
+	filename string
+	content  []byte
+}
+
+// readUploads encodes uploads as a multipart form and parses it back without
+// keeping any file in memory, so every upload is opened as a temporary file
+// in multipartDir
+func readUploads(t *testing.T, multipartDir string, uploads []upload) *multipart.Form {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, u := range uploads {
+		part, err := w.CreateFormFile(u.field, u.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(u.content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TMPDIR", multipartDir)
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form
+}
+
+// openFilesIn returns the files below dir the process has open
+func openFilesIn(t *testing.T, dir string) []string {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var open []string
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			open = append(open, target)
+		}
+	}
+	return open
+}
+
+func TestSaveUploadedFiles(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open files are listed from /proc")
+	}
+	archive := buildZip(t, []archiveEntry{{name: "node2.log", content: testLogLine}, {name: "node3.log", content: testLogLine}})
+
+	tests := []struct {
+		name      string
+		uploads   []upload
+		wantFiles int
+		wantErr   string // Filename of the failed upload
+	}{
+		{
+			name: "files and an archive",
+			uploads: []upload{
+				{field: "files", filename: "node0.log", content: []byte(testLogLine)},
+				{field: "files", filename: "node1.log", content: []byte(testLogLine)},
+				{field: "archives", filename: "nodes.zip", content: archive},
+			},
+			wantFiles: 4,
+		},
+		{
+			name: "file after a saved one is not a log",
+			uploads: []upload{
+				{field: "files", filename: "node0.log", content: []byte(testLogLine)},
+				{field: "files", filename: "notes.log", content: []byte("not a log")},
+				{field: "files", filename: "node1.log", content: []byte(testLogLine)},
+			},
+			wantErr: "notes.log",
+		},
+		{
+			name: "archive after saved files is corrupt",
+			uploads: []upload{
+				{field: "files", filename: "node0.log", content: []byte(testLogLine)},
+				{field: "archives", filename: "nodes.zip", content: archive},
+				{field: "archives", filename: "broken.zip", content: archive[:len(archive)/2]},
+			},
+			wantErr: "broken.zip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multipartDir, dir := t.TempDir(), t.TempDir()
+			form := readUploads(t, multipartDir, tt.uploads)
+
+			logFiles, err := SaveUploadedFiles(dir, form.File["files"], form.File["archives"], testLimits)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("SaveUploadedFiles: %v", err)
+			}
+			if tt.wantErr != "" {
+				var uploadErr *UploadError
+				if !errors.As(err, &uploadErr) || uploadErr.Filename != tt.wantErr {
+					t.Fatalf("SaveUploadedFiles = %v, want an UploadError of %s", err, tt.wantErr)
+				}
+			}
+
+			if open := openFilesIn(t, multipartDir); len(open) > 0 {
+				t.Errorf("uploads left open: %v", open)
+			}
+			if len(logFiles) != tt.wantFiles {
+				t.Errorf("%d log files, want %d", len(logFiles), tt.wantFiles)
+			}
+			written, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(written) != tt.wantFiles {
+				t.Errorf("%d files written, want %d", len(written), tt.wantFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package logupload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultMaxFileBytes is the default size cap for a single uploaded file (4 GiB)
+	DefaultMaxFileBytes int64 = 4 << 30
+	// DefaultMaxRequestBytes is the default size cap for a whole upload request (16 GiB)
+	DefaultMaxRequestBytes int64 = 16 << 30
+	// DefaultMaxArchiveEntries is the default cap on the number of entries in an uploaded archive
+	DefaultMaxArchiveEntries = 1000
+	// DefaultMaxArchiveBytes is the default cap on the total extracted size of an archive (16 GiB)
+	DefaultMaxArchiveBytes int64 = 16 << 30
+
+	// sniffSize is the number of leading bytes inspected to recognize a log file
+	sniffSize = 8 << 10
+)
+
+// ErrNotLogFile is returned when an uploaded file does not look like a CometBFT log
+var ErrNotLogFile = errors.New("file does not look like a CometBFT log")
+
+// timestampPattern matches the timestamps CometBFT writes in plain and JSON logs,
+// e.g. "I[2024-01-15|10:20:30.123]" or "2024-01-15T10:20:30.123Z"
+var timestampPattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T |]\d{2}:\d{2}:\d{2}`)
+
+// Limits bounds the size of uploaded log files
+type Limits struct {
+	MaxFileBytes      int64 // Maximum size of a single uploaded file
+	MaxRequestBytes   int64 // Maximum size of a whole upload request
+	MaxArchiveEntries int   // Maximum number of entries in an uploaded archive
+	MaxArchiveBytes   int64 // Maximum total extracted size of an uploaded archive
+}
+
+// LimitsFromEnv reads upload limits from MAX_UPLOAD_BYTES (per file),
+// MAX_UPLOAD_REQUEST_BYTES (per request), MAX_ARCHIVE_ENTRIES and
+// MAX_ARCHIVE_BYTES, falling back to the defaults
+func LimitsFromEnv() (Limits, error) {
+	limits := Limits{
+		MaxFileBytes:      DefaultMaxFileBytes,
+		MaxRequestBytes:   DefaultMaxRequestBytes,
+		MaxArchiveEntries: DefaultMaxArchiveEntries,
+		MaxArchiveBytes:   DefaultMaxArchiveBytes,
+	}
+
+	if value := os.Getenv("MAX_UPLOAD_BYTES"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			return limits, fmt.Errorf("invalid MAX_UPLOAD_BYTES: %q", value)
+		}
+		limits.MaxFileBytes = parsed
+	}
+
+	if value := os.Getenv("MAX_UPLOAD_REQUEST_BYTES"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			return limits, fmt.Errorf("invalid MAX_UPLOAD_REQUEST_BYTES: %q", value)
+		}
+		limits.MaxRequestBytes = parsed
+	}
+
+	if value := os.Getenv("MAX_ARCHIVE_ENTRIES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return limits, fmt.Errorf("invalid MAX_ARCHIVE_ENTRIES: %q", value)
+		}
+		limits.MaxArchiveEntries = parsed
+	}
+
+	if value := os.Getenv("MAX_ARCHIVE_BYTES"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			return limits, fmt.Errorf("invalid MAX_ARCHIVE_BYTES: %q", value)
+		}
+		limits.MaxArchiveBytes = parsed
+	}
+
+	return limits, nil
+}
+
+// LimitRequestBody caps the number of bytes read from the request body.
+// It must be called before the multipart form is parsed.
+func LimitRequestBody(c *gin.Context, limits Limits) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxRequestBytes)
+}
+
+// IsRequestTooLarge reports whether err was caused by exceeding the request body limit
+func IsRequestTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// SniffLogFile inspects the first few KB of r and returns ErrNotLogFile unless
+// they look like CometBFT log lines (JSON objects or lines carrying a timestamp).
+// The returned reader yields the full content, including the inspected bytes.
+func SniffLogFile(r io.Reader) (io.Reader, error) {
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	if !looksLikeLog(head, n < sniffSize) {
+		return nil, ErrNotLogFile
+	}
+
+	return io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// looksLikeLog checks whether any complete line in head is a JSON object or carries a timestamp
+func looksLikeLog(head []byte, complete bool) bool {
+	if len(head) == 0 || bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+
+	lines := bytes.Split(head, []byte("\n"))
+	if !complete && len(lines) > 1 {
+		// The last line was cut off by the sniff window
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '{' && json.Valid(line) {
+			return true
+		}
+		if timestampPattern.Match(line) {
+			return true
+		}
+	}
+
+	return false
+}
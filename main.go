@@ -1,92 +1,269 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
-	"os"
+	"net/http"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bft-labs/cometbft-analyzer-backend/config"
 	"github.com/bft-labs/cometbft-analyzer-backend/db"
 	"github.com/bft-labs/cometbft-analyzer-backend/handlers"
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
 	"github.com/bft-labs/cometbft-analyzer-backend/middleware"
+	"github.com/bft-labs/cometbft-analyzer-backend/processing"
+	"github.com/bft-labs/cometbft-analyzer-backend/quota"
+	"github.com/bft-labs/cometbft-analyzer-backend/repository"
+	"github.com/bft-labs/cometbft-analyzer-backend/retention"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+// Build information reported by /v1/version, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = ""
+)
+
 func main() {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found or error loading .env file")
 	}
 
-	// Load MongoDB URI, default to localhost
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017"
+	// Every setting is read and validated up front; all problems are reported at once
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	client, err := db.Connect(mongoURI)
+	mongoClient, err := db.Connect(cfg.MongoURI, cfg.Mongo)
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
+	client := mongoClient.Client
+	versionCtx, cancelVersion := context.WithTimeout(context.Background(), 5*time.Second)
+	mongoVersion, err := db.ServerVersion(versionCtx, client)
+	cancelVersion()
+	if err != nil {
+		log.Printf("Warning: Failed to detect the MongoDB server version: %v", err)
+	}
 
 	// User management collections
 	usersColl := client.Database("consensus_visualizer").Collection("users")
 	projectsColl := client.Database("consensus_visualizer").Collection("projects")
 	simulationsColl := client.Database("consensus_visualizer").Collection("simulations")
+	userRepo := repository.NewMongoUserRepo(usersColl)
+	// Users created before emails were normalized need it before the unique
+	// index on emailNormalized can be built
+	backfill, err := userRepo.BackfillEmailNormalized(context.Background())
+	if err != nil {
+		log.Printf("Warning: Failed to backfill normalized emails: %v", err)
+	} else if backfill.Updated > 0 {
+		log.Printf("Backfilled the normalized email of %d users", backfill.Updated)
+	}
+	for email, usernames := range backfill.Duplicates {
+		log.Printf("Warning: Users %s share the email %s and were left without a normalized one", strings.Join(usernames, ", "), email)
+	}
+	if err := db.EnsureIndexes(context.Background(), usersColl, projectsColl, simulationsColl); err != nil {
+		log.Printf("Warning: Continuing without the indexes that could not be created")
+	}
+	projectRepo := repository.NewMongoProjectRepo(projectsColl)
+	simulationRepo := repository.NewMongoSimulationRepo(simulationsColl)
+
+	// Janitor pruning raw events past the projects' retention window
+	janitor := retention.NewJanitor(client, projectsColl, simulationsColl)
+
+	// Storage backend for uploaded log files
+	store, err := storage.New(cfg.StorageBackend, cfg.UploadDir, cfg.S3)
+	if err != nil {
+		log.Fatalf("Failed to configure storage: %v", err)
+	}
+
+	// Processing queue: simulations are processed by a fixed pool of workers,
+	// highest priority first and FIFO within the same priority
+	processingQueue := processing.NewQueue()
+	processingQueue.Start(cfg.ProcessingConcurrency, handlers.ProcessQueuedSimulation(simulationRepo, client, store, processingQueue, cfg.ETLBinary, cfg.UploadDir))
+
+	logFetcher := logupload.NewFetcher(cfg.Fetch)
+	quotas := quota.New(usersColl, cfg.DefaultUserQuotaBytes)
+	if backfilled, err := quotas.Backfill(context.Background(), simulationsColl); err != nil {
+		log.Printf("Warning: Failed to backfill the storage usage of users: %v", err)
+	} else if backfilled > 0 {
+		log.Printf("Backfilled the storage usage of %d users", backfilled)
+	}
+	rateLimiter := middleware.NewRateLimiter(middleware.DefaultRateLimitMaxClients)
+
+	// Reported by /v1/version; the ETL binary is only run here, not per request
+	versionInfo := types.VersionResponse{
+		Version:        version,
+		Commit:         commit,
+		BuildTime:      buildTime,
+		GoVersion:      runtime.Version(),
+		MongoDBVersion: mongoVersion,
+		ETL:            handlers.DetectETLVersion(cfg.ETLBinary),
+	}
+	log.Printf("Starting %s (commit %s), MongoDB %s, ETL %q", version, commit, mongoVersion, versionInfo.ETL.Version)
 
 	router := gin.Default()
 
+	// Client IPs come from X-Forwarded-For only on requests through the trusted
+	// proxies, e.g. the load balancer subnets; none are trusted by default
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Probes are registered before the middlewares so they bypass validation,
+	// rate limiting and authorization
+	router.GET("/healthz", handlers.HealthHandler())
+	router.GET("/readyz", handlers.ReadinessHandler(mongoClient, cfg.UploadDir, cfg.ETLBinary))
+
 	// Add security middleware
 	router.Use(middleware.SecurityHeadersMiddleware())
-	router.Use(middleware.CORSMiddleware())
+	router.Use(middleware.CORSMiddleware(cfg.CORS))
 	router.Use(middleware.RequestValidationMiddleware())
 
-	// Add rate limiting (60 requests per minute, burst of 10)
-	router.Use(middleware.RateLimitMiddleware(6000, 10))
-
 	v1 := router.Group("/v1")
+	// Every caller authenticates with a token of API_TOKENS and may only
+	// access their own users, projects and simulations
+	v1.Use(middleware.AuthenticationMiddleware(cfg.APITokens))
+	v1.Use(middleware.OwnershipMiddleware(projectsColl, simulationsColl))
+	// Every route is registered in the group of its rate limit policy
+	api := v1.Group("", middleware.RateLimitMiddleware(rateLimiter, cfg.RateLimits["default"]))
+	uploads := v1.Group("", middleware.RateLimitMiddleware(rateLimiter, cfg.RateLimits["uploads"]))
+	events := v1.Group("", middleware.RateLimitMiddleware(rateLimiter, cfg.RateLimits["events"]))
+	metrics := v1.Group("", middleware.RateLimitMiddleware(rateLimiter, cfg.RateLimits["metrics"]))
 	{
+		// Build information
+		api.GET("/version", handlers.VersionHandler(versionInfo))
+
 		// User management endpoints
-		v1.POST("/users", handlers.CreateUserHandler(usersColl))
-		v1.GET("/users", handlers.GetUsersHandler(usersColl))
-		v1.GET("/users/:userId", handlers.GetUserHandler(usersColl))
-		v1.DELETE("/users/:userId", handlers.DeleteUserHandler(usersColl))
+		api.POST("/users", handlers.CreateUserHandler(userRepo))
+		api.GET("/users", middleware.AdminMiddleware(), handlers.GetUsersHandler(userRepo))
+		api.GET("/users/by-username/:username", middleware.AdminMiddleware(), handlers.GetUserByUsernameHandler(userRepo))
+		api.GET("/users/:userId", handlers.GetUserHandler(userRepo))
+		api.PUT("/users/:userId", handlers.UpdateUserHandler(userRepo))
+		api.DELETE("/users/:userId", handlers.DeleteUserHandler(userRepo, projectRepo, simulationRepo, store, processingQueue, cfg.UploadDir))
+		api.GET("/users/:userId/storage", handlers.GetUserStorageHandler(simulationRepo, store, quotas))
 
 		// Project management endpoints
-		v1.POST("/users/:userId/projects", handlers.CreateProjectHandler(projectsColl))
-		v1.GET("/users/:userId/projects", handlers.GetProjectsByUserHandler(projectsColl))
-		v1.GET("/projects/:projectId", handlers.GetProjectHandler(projectsColl))
-		v1.PUT("/projects/:projectId", handlers.UpdateProjectHandler(projectsColl))
-		v1.DELETE("/projects/:projectId", handlers.DeleteProjectHandler(projectsColl))
+		api.POST("/users/:userId/projects", handlers.CreateProjectHandler(projectRepo))
+		api.GET("/users/:userId/projects", handlers.GetProjectsByUserHandler(projectRepo, simulationRepo))
+		api.GET("/projects/:projectId", handlers.GetProjectHandler(projectRepo))
+		api.GET("/projects/:projectId/stats", handlers.GetProjectStatsHandler(projectRepo, simulationRepo))
+		api.GET("/projects/:projectId/storage", handlers.GetProjectStorageHandler(projectRepo, simulationRepo, store))
+		api.PUT("/projects/:projectId", handlers.UpdateProjectHandler(projectRepo))
+		api.POST("/projects/:projectId/transfer", handlers.TransferProjectHandler(projectRepo, simulationRepo, userRepo, store, quotas, cfg.UploadDir))
+		api.DELETE("/projects/:projectId", handlers.DeleteProjectHandler(projectRepo, simulationRepo, store, processingQueue, quotas, cfg.UploadDir))
 
 		// Simulation management endpoints
-		v1.POST("/users/:userId/projects/:projectId/simulations", handlers.CreateSimulationHandler(simulationsColl))
-		v1.GET("/users/:userId/simulations", handlers.GetSimulationsByUserHandler(simulationsColl))
-		v1.GET("/projects/:projectId/simulations", handlers.GetSimulationsByProjectHandler(simulationsColl))
-		v1.GET("/simulations/:id", handlers.GetSimulationHandler(simulationsColl))
-		v1.PUT("/simulations/:id", handlers.UpdateSimulationHandler(simulationsColl))
-		v1.DELETE("/simulations/:id", handlers.DeleteSimulationHandler(simulationsColl))
-		v1.POST("/simulations/:id/upload", handlers.UploadLogFileHandler(simulationsColl))
-		v1.POST("/simulations/:id/process", handlers.ProcessSimulationHandler(simulationsColl))
+		uploads.POST("/users/:userId/projects/:projectId/simulations", handlers.CreateSimulationHandler(simulationRepo, projectRepo, store, quotas, processingQueue, cfg.UploadLimits, cfg.UploadDir))
+		uploads.POST("/users/:userId/projects/:projectId/simulations/import", handlers.ImportSimulationHandler(client, simulationRepo, projectRepo, store, quotas, cfg.UploadLimits, cfg.UploadDir))
+		api.GET("/users/:userId/simulations", handlers.GetSimulationsByUserHandler(simulationRepo))
+		api.GET("/projects/:projectId/simulations", handlers.GetSimulationsByProjectHandler(simulationRepo))
+		api.GET("/simulations/:id", handlers.GetSimulationHandler(simulationRepo))
+		api.PUT("/simulations/:id", handlers.UpdateSimulationHandler(simulationRepo))
+		api.DELETE("/simulations/:id", handlers.DeleteSimulationHandler(simulationRepo, store, processingQueue, quotas, cfg.UploadDir))
+		metrics.GET("/simulations/:id/export", handlers.ExportSimulationHandler(client, simulationRepo, store, cfg.UploadDir))
+		uploads.POST("/simulations/:id/upload", handlers.UploadLogFileHandler(simulationRepo, store, quotas, cfg.UploadLimits, cfg.UploadDir))
+		api.GET("/simulations/:id/logfiles", handlers.GetLogFilesHandler(simulationRepo))
+		uploads.POST("/simulations/:id/logfiles/fetch", handlers.FetchLogFilesHandler(simulationRepo, store, quotas, logFetcher, cfg.UploadLimits, cfg.UploadDir))
+		api.GET("/simulations/:id/logfiles/:index/download", handlers.DownloadLogFileHandler(simulationRepo, store, cfg.UploadDir))
+		api.DELETE("/simulations/:id/logfiles/:index", handlers.DeleteLogFileHandler(simulationRepo, store, quotas, cfg.UploadDir))
+		api.POST("/simulations/:id/process", handlers.ProcessSimulationHandler(simulationRepo, processingQueue))
+		api.PUT("/simulations/:id/priority", handlers.UpdateSimulationPriorityHandler(simulationRepo, processingQueue))
+
+		// Processing queue endpoints
+		api.GET("/processing/queue", middleware.AdminMiddleware(), handlers.GetProcessingQueueHandler(processingQueue))
+
+		// Admin endpoints
+		api.POST("/admin/retention/run", middleware.AdminMiddleware(), handlers.RunRetentionHandler(janitor))
 
 		// Simulation-specific metrics endpoints
-		v1.GET("/simulations/:id/events", handlers.GetSimulationConsensusEventsHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/latency/votes", handlers.GetSimulationVoteLatenciesHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/latency/pairwise", handlers.GetSimulationPairLatencyHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/latency/timeseries", handlers.GetSimulationBlockLatencyTimeSeriesHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/latency/stats", handlers.GetSimulationLatencyStatsHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/messages/success_rate", handlers.GetSimulationMessageSuccessRateHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/latency/end_to_end", handlers.GetSimulationBlockEndToEndLatencyHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/vote/statistics", handlers.GetSimulationVoteStatisticsHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/network/latency/stats", handlers.GetSimulationNetworkLatencyStatsHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/network/latency/node-stats", handlers.GetSimulationNetworkLatencyNodeStatsHandler(client, simulationsColl))
-		v1.GET("/simulations/:id/metrics/network/latency/overview", handlers.GetSimulationNetworkLatencyOverviewHandler(client, simulationsColl))
-	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+		events.GET("/simulations/:id/events", handlers.GetSimulationConsensusEventsHandler(simulationRepo))
+		events.GET("/simulations/:id/events/ws", handlers.GetSimulationConsensusEventsStreamHandler(client, simulationRepo))
+		metrics.GET("/simulations/:id/events/export", handlers.GetSimulationConsensusEventsExportHandler(client, simulationRepo))
+		metrics.GET("/simulations/:id/events/summary", handlers.GetSimulationEventSummaryHandler(simulationRepo))
+		events.GET("/simulations/:id/events/:eventId", handlers.GetSimulationConsensusEventHandler(simulationRepo))
+		events.GET("/simulations/:id/heights", handlers.GetSimulationHeightsHandler(simulationRepo))
+		events.GET("/simulations/:id/heights/:height/timeline", handlers.GetSimulationHeightTimelineHandler(simulationRepo))
+		events.GET("/simulations/:id/peers", handlers.GetSimulationPeersHandler(client, simulationRepo))
+		events.GET("/simulations/:id/validators", handlers.GetSimulationValidatorsHandler(client, simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/votes", handlers.GetSimulationVoteLatenciesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/votes/timeseries", handlers.GetSimulationVoteLatencyTimeSeriesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/pairwise", handlers.GetSimulationPairLatencyHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/timeseries", handlers.GetSimulationBlockLatencyTimeSeriesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/stats", handlers.GetSimulationLatencyStatsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/spikes", handlers.GetSimulationLatencySpikesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/jitter/timeseries", handlers.GetSimulationJitterTimeSeriesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/timeouts/timeseries", handlers.GetSimulationTimeoutTimeSeriesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/messages/timeseries", handlers.GetSimulationMessageTimeSeriesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/messages/success_rate/timeseries", handlers.GetSimulationMessageSuccessRateTimeSeriesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/messages/success_rate", handlers.GetSimulationMessageSuccessRateHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/latency/end_to_end", handlers.GetSimulationBlockEndToEndLatencyHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/rounds/durations", handlers.GetSimulationRoundDurationsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/proposers", handlers.GetSimulationProposerStatsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/blocks/intervals", handlers.GetSimulationBlockIntervalsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/blocks/assembly", handlers.GetSimulationBlockAssemblyHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/votes/participation", handlers.GetSimulationVoteParticipationHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/votes/anomalies", handlers.GetSimulationVoteAnomaliesHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/throughput", handlers.GetSimulationThroughputHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/steps/durations", handlers.GetSimulationStepDurationsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/steps/funnel", handlers.GetSimulationStepFunnelHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/commit/lag", handlers.GetSimulationCommitLagHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/nodes/ranking", handlers.GetSimulationNodeRankingHandler(simulationRepo))
+		metrics.GET("/simulations/:id/incidents", handlers.GetSimulationIncidentsHandler(client, simulationRepo))
+		metrics.GET("/simulations/:id/metrics/summary", handlers.GetSimulationMetricsSummaryHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/vote/statistics", handlers.GetSimulationVoteStatisticsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/network/partitions", handlers.GetSimulationNetworkPartitionsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/network/fanout", handlers.GetSimulationNetworkFanoutHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/network/latency/stats", handlers.GetSimulationNetworkLatencyStatsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/network/latency/node-stats", handlers.GetSimulationNetworkLatencyNodeStatsHandler(simulationRepo))
+		metrics.GET("/simulations/:id/metrics/network/latency/overview", handlers.GetSimulationNetworkLatencyOverviewHandler(simulationRepo))
+	}
+
+	// The OpenAPI document describes the routes registered above
+	router.GET("/openapi.json", handlers.OpenAPIHandler(apiSpec(router.Routes())))
+
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if cfg.RetentionInterval > 0 {
+		janitor.Start(ctx, cfg.RetentionInterval)
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to run server: %v", err)
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// Stop accepting connections, then let in-flight requests and processing
+	// finish within the grace period; processing still running is interrupted
+	log.Printf("Shutting down, waiting up to %s for requests and processing to finish", cfg.ShutdownGracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Not all requests finished: %v", err)
+	}
+	queued, err := processingQueue.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Warning: Interrupted running processing: %v", err)
+	}
+	handlers.InterruptQueuedSimulations(simulationRepo, queued)
+
+	if err := client.Disconnect(context.Background()); err != nil {
+		log.Printf("Warning: Failed to disconnect from MongoDB: %v", err)
 	}
 }
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// BlockAssemblyResult contains a page of block assembly times and the total number of (height, node) rows
+type BlockAssemblyResult struct {
+	Data  []types.BlockAssembly
+	Total int
+}
+
+// blockAssemblyPercentiles are the quantiles of NodeBlockAssembly
+var blockAssemblyPercentiles = []float64{0.50, 0.95}
+
+// blockAssemblyStages correlates, per (height, nodeId), the block parts the
+// node received (receivePacketBlockPart), its receivedProposal and its
+// receivedCompleteProposalBlock. Assembly starts at the first part or
+// proposal received and ends at the completion; assemblyMs is left out when
+// either is missing, and complete is false when the completion is.
+func blockAssemblyStages(from, to time.Time, heights types.HeightRange) mongo.Pipeline {
+	isType := func(eventType string) bson.D { return bson.D{{"$eq", bson.A{"$type", eventType}}} }
+	timeRange := bson.D{{"$gte", from}, {"$lte", to}}
+	partsMatch := withHeightRange(bson.D{
+		{"type", bson.D{{"$in", bson.A{"receivePacketBlockPart", "receivedCompleteProposalBlock"}}}},
+		{"timestamp", timeRange},
+	}, "height", heights)
+	proposalMatch := withHeightRange(bson.D{{"type", "receivedProposal"}, {"timestamp", timeRange}}, "proposal.height", heights)
+
+	return mongo.Pipeline{
+		{{"$match", bson.D{{"$or", bson.A{partsMatch, proposalMatch}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"height", bson.D{{"$ifNull", bson.A{"$height", "$proposal.height"}}}},
+				{"nodeId", "$nodeId"},
+			}},
+			// $min ignores the nulls of the other event types
+			{"firstPart", bson.D{{"$min", bson.D{{"$cond", bson.A{isType("receivePacketBlockPart"), "$timestamp", nil}}}}}},
+			{"proposal", bson.D{{"$min", bson.D{{"$cond", bson.A{isType("receivedProposal"), "$timestamp", nil}}}}}},
+			{"completeTime", bson.D{{"$min", bson.D{{"$cond", bson.A{isType("receivedCompleteProposalBlock"), "$timestamp", nil}}}}}},
+			{"parts", bson.D{{"$addToSet", bson.D{{"$cond", bson.A{isType("receivePacketBlockPart"), "$part.index", nil}}}}}},
+			{"partMessages", bson.D{{"$sum", bson.D{{"$cond", bson.A{isType("receivePacketBlockPart"), 1, 0}}}}}},
+		}}},
+		{{"$set", bson.D{{"startTime", bson.D{{"$min", bson.A{"$firstPart", "$proposal"}}}}}}},
+		{{"$project", bson.D{
+			{"_id", 0},
+			{"height", "$_id.height"},
+			{"nodeId", "$_id.nodeId"},
+			{"startTime", 1},
+			{"completeTime", 1},
+			{"assemblyMs", bson.D{{"$cond", bson.A{
+				bson.D{{"$and", bson.A{
+					bson.D{{"$ne", bson.A{"$startTime", nil}}},
+					bson.D{{"$ne", bson.A{"$completeTime", nil}}},
+				}}},
+				bson.D{{"$toDouble", bson.D{{"$subtract", bson.A{"$completeTime", "$startTime"}}}}},
+				"$$REMOVE",
+			}}}},
+			{"blockParts", bson.D{{"$size", bson.D{{"$setDifference", bson.A{"$parts", bson.A{nil}}}}}}},
+			{"partMessages", 1},
+			{"complete", bson.D{{"$ne", bson.A{"$completeTime", nil}}}},
+		}}},
+	}
+}
+
+// ComputeBlockAssembly returns, per height and node, how long the node took to
+// assemble the proposal block, ordered by height and node. Nodes that started
+// but never completed a block are kept with complete false.
+func ComputeBlockAssembly(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, page, perPage int,
+) (*BlockAssemblyResult, error) {
+	pipeline := append(blockAssemblyStages(from, to, heights),
+		bson.D{{"$sort", bson.D{{"height", 1}, {"nodeId", 1}}}},
+		pageFacet(page, perPage),
+	)
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []types.BlockAssembly `bson:"data"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &BlockAssemblyResult{Data: facet.Data}
+	if result.Data == nil {
+		result.Data = []types.BlockAssembly{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
+
+// ComputeNodeBlockAssembly aggregates the block assembly times of each node
+// over the range into p50/p95/max, ordered by node
+func ComputeNodeBlockAssembly(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) ([]types.NodeBlockAssembly, error) {
+	build := func(native bool) mongo.Pipeline {
+		pipeline := append(blockAssemblyStages(from, to, heights), percentileRanks(native, "$nodeId", "$assemblyMs")...)
+		return append(pipeline,
+			bson.D{{"$group", bson.D{
+				{"_id", "$nodeId"},
+				{"heights", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+					bson.D{{"$eq", bson.A{bson.D{{"$type", "$assemblyMs"}}, "missing"}}}, 0, 1,
+				}}}}}},
+				{"incomplete", bson.D{{"$sum", bson.D{{"$cond", bson.A{"$complete", 0, 1}}}}}},
+				{"maxMs", bson.D{{"$max", "$assemblyMs"}}},
+				{"percentiles", rankedPercentiles(native, "$assemblyMs", blockAssemblyPercentiles)},
+			}}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		)
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []struct {
+		NodeID      string                 `bson:"_id"`
+		Heights     int                    `bson:"heights"`
+		Incomplete  int                    `bson:"incomplete"`
+		MaxMs       *float64               `bson:"maxMs"`
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]types.NodeBlockAssembly, 0, len(docs))
+	for _, doc := range docs {
+		node := types.NodeBlockAssembly{NodeID: doc.NodeID, Heights: doc.Heights, Incomplete: doc.Incomplete}
+		if quantiles := doc.Percentiles.quantiles(blockAssemblyPercentiles); doc.Heights > 0 && doc.MaxMs != nil && quantiles != nil {
+			node.P50Ms, node.P95Ms, node.MaxMs = quantiles[0], quantiles[1], *doc.MaxMs
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
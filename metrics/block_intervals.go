@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// CommitMarkerEvents maps the event types usable as commit markers to their height field
+var CommitMarkerEvents = map[string]string{
+	"enteringCommitStep":            "currentHeight",
+	"committedBlock":                "height",
+	"receivedCompleteProposalBlock": "height",
+	"enteringNewRound":              "height",
+}
+
+// ComputeBlockIntervals returns the commit-to-commit time of every height between
+// the first and last committed height. Each node's interval is the time between
+// its marker events of consecutive heights; the height's interval is the median
+// over nodes, or nil when no node has both. With window > 1 the mean interval of
+// the last window heights is reported as the moving average.
+func ComputeBlockIntervals(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, marker string, window int,
+) ([]types.BlockInterval, error) {
+	heightField := CommitMarkerEvents[marker]
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"type", marker},
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, heightField, heights)}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"nodeId", "$nodeId"},
+				{"height", "$" + heightField},
+			}},
+			{"first", bson.D{{"$min", "$timestamp"}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			NodeID string `bson:"nodeId"`
+			Height int64  `bson:"height"`
+		} `bson:"_id"`
+		First time.Time `bson:"first"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+	if len(rawResults) == 0 {
+		return []types.BlockInterval{}, nil
+	}
+
+	commits := map[string]map[int64]time.Time{}
+	minHeight, maxHeight := rawResults[0].ID.Height, rawResults[0].ID.Height
+	for _, doc := range rawResults {
+		nodeCommits, ok := commits[doc.ID.NodeID]
+		if !ok {
+			nodeCommits = map[int64]time.Time{}
+			commits[doc.ID.NodeID] = nodeCommits
+		}
+		nodeCommits[doc.ID.Height] = doc.First
+		if doc.ID.Height < minHeight {
+			minHeight = doc.ID.Height
+		}
+		if doc.ID.Height > maxHeight {
+			maxHeight = doc.ID.Height
+		}
+	}
+
+	// Heights without any commit event are kept with a nil interval
+	intervals := make([]types.BlockInterval, 0, maxHeight-minHeight+1)
+	for height := minHeight; height <= maxHeight; height++ {
+		interval := types.BlockInterval{Height: height, NodeBreakdown: map[string]float64{}}
+		var nodeIntervals []float64
+		for nodeID, nodeCommits := range commits {
+			current, ok := nodeCommits[height]
+			if !ok {
+				continue
+			}
+			previous, ok := nodeCommits[height-1]
+			if !ok {
+				continue
+			}
+			ms := types.DurationMs(current.Sub(previous))
+			interval.NodeBreakdown[nodeID] = ms
+			nodeIntervals = append(nodeIntervals, ms)
+		}
+		if len(nodeIntervals) > 0 {
+			median := medianOf(nodeIntervals)
+			interval.IntervalMs = &median
+		}
+		intervals = append(intervals, interval)
+	}
+
+	if window > 1 {
+		for i := range intervals {
+			var sum float64
+			var count int
+			for j := max(0, i-window+1); j <= i; j++ {
+				if intervals[j].IntervalMs != nil {
+					sum += *intervals[j].IntervalMs
+					count++
+				}
+			}
+			if count > 0 {
+				average := sum / float64(count)
+				intervals[i].MovingAverageMs = &average
+			}
+		}
+	}
+	return intervals, nil
+}
+
+// medianOf returns the median of values, sorting them in place
+func medianOf(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// CommitLagResult contains a page of per-height commit lags, the per-node lag
+// over all heights and the total number of heights
+type CommitLagResult struct {
+	Nodes []types.NodeCommitLag
+	Data  []types.HeightCommitLag
+	Total int
+}
+
+// ComputeCommitLag reports, per height, how long after the first node each node
+// entered the commit step. The first committer is taken over all nodes; nodes
+// restricts the reported nodes only. A node that committed some height in the
+// range but has no commit event for a height missed it, which is reported
+// separately rather than as a lag.
+func ComputeCommitLag(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, nodes []string, page, perPage int,
+) (*CommitLagResult, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"type", "enteringCommitStep"},
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "currentHeight", heights)}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"height", "$currentHeight"},
+				{"nodeId", "$nodeId"},
+			}},
+			{"first", bson.D{{"$min", "$timestamp"}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			Height int64  `bson:"height"`
+			NodeID string `bson:"nodeId"`
+		} `bson:"_id"`
+		First time.Time `bson:"first"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	commits := map[int64]map[string]time.Time{}
+	allNodes := map[string]bool{}
+	for _, doc := range rawResults {
+		heightCommits, ok := commits[doc.ID.Height]
+		if !ok {
+			heightCommits = map[string]time.Time{}
+			commits[doc.ID.Height] = heightCommits
+		}
+		heightCommits[doc.ID.NodeID] = doc.First
+		allNodes[doc.ID.NodeID] = true
+	}
+
+	reported := make([]string, 0, len(allNodes))
+	for nodeID := range allNodes {
+		if len(nodes) == 0 || slices.Contains(nodes, nodeID) {
+			reported = append(reported, nodeID)
+		}
+	}
+	sort.Strings(reported)
+
+	sortedHeights := make([]int64, 0, len(commits))
+	for height := range commits {
+		sortedHeights = append(sortedHeights, height)
+	}
+	slices.Sort(sortedHeights)
+
+	lagsByNode := map[string][]float64{}
+	missedByNode := map[string]int{}
+	data := make([]types.HeightCommitLag, 0, len(sortedHeights))
+	for _, height := range sortedHeights {
+		heightCommits := commits[height]
+		entry := types.HeightCommitLag{Height: height, LagMs: map[string]float64{}, Missed: []string{}}
+		for nodeID, first := range heightCommits {
+			if entry.FirstCommitter == "" || first.Before(entry.FirstCommitTime) ||
+				(first.Equal(entry.FirstCommitTime) && nodeID < entry.FirstCommitter) {
+				entry.FirstCommitter = nodeID
+				entry.FirstCommitTime = first
+			}
+		}
+		for _, nodeID := range reported {
+			first, ok := heightCommits[nodeID]
+			if !ok {
+				entry.Missed = append(entry.Missed, nodeID)
+				missedByNode[nodeID]++
+				continue
+			}
+			lag := types.DurationMs(first.Sub(entry.FirstCommitTime))
+			entry.LagMs[nodeID] = lag
+			lagsByNode[nodeID] = append(lagsByNode[nodeID], lag)
+		}
+		data = append(data, entry)
+	}
+
+	result := &CommitLagResult{
+		Nodes: make([]types.NodeCommitLag, 0, len(reported)),
+		Total: len(data),
+	}
+	for _, nodeID := range reported {
+		lags := lagsByNode[nodeID]
+		summary := types.NodeCommitLag{NodeID: nodeID, Heights: len(lags), MissedHeights: missedByNode[nodeID]}
+		if len(lags) > 0 {
+			sort.Float64s(lags)
+			summary.P50LagMs = quantileOf(lags, 0.50)
+			summary.P95LagMs = quantileOf(lags, 0.95)
+			summary.MaxLagMs = lags[len(lags)-1]
+		}
+		result.Nodes = append(result.Nodes, summary)
+	}
+
+	start := min((page-1)*perPage, len(data))
+	end := min(start+perPage, len(data))
+	result.Data = data[start:end]
+	return result, nil
+}
+
+// quantileOf returns the q quantile of sorted values, interpolating linearly between the closest ranks
+func quantileOf(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ComputeEventSummary counts events per type, and per node within each type when
+// groupByNode is set. Zero from/to leave the window open on that side.
+func ComputeEventSummary(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, groupByNode bool,
+) (map[string]*types.EventTypeSummary, error) {
+	pipeline := mongo.Pipeline{}
+
+	timeFilter := bson.D{}
+	if !from.IsZero() {
+		timeFilter = append(timeFilter, bson.E{"$gte", from})
+	}
+	if !to.IsZero() {
+		timeFilter = append(timeFilter, bson.E{"$lte", to})
+	}
+	if len(timeFilter) > 0 {
+		pipeline = append(pipeline, bson.D{{"$match", bson.D{{"timestamp", timeFilter}}}})
+	}
+
+	groupID := bson.D{{"type", "$type"}}
+	if groupByNode {
+		groupID = append(groupID, bson.E{"nodeId", "$nodeId"})
+	}
+	pipeline = append(pipeline, bson.D{{"$group", bson.D{
+		{"_id", groupID},
+		{"count", bson.D{{"$sum", 1}}},
+		{"first", bson.D{{"$min", "$timestamp"}}},
+		{"last", bson.D{{"$max", "$timestamp"}}},
+	}}})
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			Type   string `bson:"type"`
+			NodeID string `bson:"nodeId"`
+		} `bson:"_id"`
+		Count int64     `bson:"count"`
+		First time.Time `bson:"first"`
+		Last  time.Time `bson:"last"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	// Simulations that were not processed yet have no events and yield an empty summary
+	out := map[string]*types.EventTypeSummary{}
+	for _, doc := range rawResults {
+		counts := types.EventCountSummary{Count: doc.Count, FirstTimestamp: doc.First, LastTimestamp: doc.Last}
+
+		summary, ok := out[doc.ID.Type]
+		if !ok {
+			summary = &types.EventTypeSummary{EventCountSummary: counts}
+			out[doc.ID.Type] = summary
+		} else {
+			summary.Count += doc.Count
+			if doc.First.Before(summary.FirstTimestamp) {
+				summary.FirstTimestamp = doc.First
+			}
+			if doc.Last.After(summary.LastTimestamp) {
+				summary.LastTimestamp = doc.Last
+			}
+		}
+
+		if groupByNode {
+			if summary.Nodes == nil {
+				summary.Nodes = map[string]types.EventCountSummary{}
+			}
+			summary.Nodes[doc.ID.NodeID] = counts
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HeightsResult contains a page of heights and the total number of heights
+type HeightsResult struct {
+	Data  []types.HeightInfo
+	Total int
+}
+
+// ComputeHeights lists the block heights of a simulation in ascending order,
+// derived from enteringNewRound and enteringCommitStep events. A nil bound
+// leaves the height range open on that side.
+func ComputeHeights(
+	ctx context.Context, coll *mongo.Collection,
+	fromHeight, toHeight *int64, page, perPage int,
+) (*HeightsResult, error) {
+	// enteringNewRound carries height/round, enteringCommitStep currentHeight/currentRound
+	newRoundMatch := bson.D{{"type", "enteringNewRound"}}
+	commitMatch := bson.D{{"type", "enteringCommitStep"}}
+	heightRange := bson.D{}
+	if fromHeight != nil {
+		heightRange = append(heightRange, bson.E{"$gte", *fromHeight})
+	}
+	if toHeight != nil {
+		heightRange = append(heightRange, bson.E{"$lte", *toHeight})
+	}
+	if len(heightRange) > 0 {
+		newRoundMatch = append(newRoundMatch, bson.E{"height", heightRange})
+		commitMatch = append(commitMatch, bson.E{"currentHeight", heightRange})
+	}
+
+	skip := (page - 1) * perPage
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"$or", bson.A{newRoundMatch, commitMatch}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"$ifNull", bson.A{"$height", "$currentHeight"}}}},
+			{"firstTimestamp", bson.D{{"$min", "$timestamp"}}},
+			{"lastTimestamp", bson.D{{"$max", "$timestamp"}}},
+			{"rounds", bson.D{{"$addToSet", bson.D{{"$ifNull", bson.A{"$round", "$currentRound"}}}}}},
+			{"eventCount", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+		{{"$facet", bson.D{
+			{"total", bson.A{bson.D{{"$count", "total"}}}},
+			{"data", bson.A{
+				bson.D{{"$skip", skip}},
+				bson.D{{"$limit", perPage}},
+				bson.D{{"$project", bson.D{
+					{"_id", 0},
+					{"height", "$_id"},
+					{"firstTimestamp", 1},
+					{"lastTimestamp", 1},
+					{"rounds", bson.D{{"$size", "$rounds"}}},
+					{"eventCount", 1},
+				}}},
+			}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []types.HeightInfo `bson:"data"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &HeightsResult{Data: facet.Data}
+	if result.Data == nil {
+		result.Data = []types.HeightInfo{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
@@ -0,0 +1,313 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"slices"
+	"sort"
+	"time"
+)
+
+// IncidentsCollection is the collection of the simulation database holding
+// the incidents detected after processing, with DefaultIncidentThresholds
+const IncidentsCollection = "incidents"
+
+// DefaultIncidentThresholds are the thresholds incidents are stored with
+var DefaultIncidentThresholds = types.IncidentThresholds{MaxCommitMs: 10000, MinRounds: 1}
+
+// IncidentsResult contains a page of incidents and the total number of matching incidents
+type IncidentsResult struct {
+	Data  []types.Incident
+	Total int
+}
+
+// IncidentFilter selects incidents by severity and type; empty lists match everything
+type IncidentFilter struct {
+	Severities []string
+	Types      []string
+}
+
+func (f IncidentFilter) matches(incident types.Incident) bool {
+	return (len(f.Severities) == 0 || slices.Contains(f.Severities, incident.Severity)) &&
+		(len(f.Types) == 0 || slices.Contains(f.Types, incident.Type))
+}
+
+func (f IncidentFilter) query() bson.D {
+	query := bson.D{}
+	if len(f.Severities) > 0 {
+		query = append(query, bson.E{"severity", bson.D{{"$in", f.Severities}}})
+	}
+	if len(f.Types) > 0 {
+		query = append(query, bson.E{"type", bson.D{{"$in", f.Types}}})
+	}
+	return query
+}
+
+// heightNodeSteps is what a node went through at a height
+type heightNodeSteps struct {
+	NodeID     string     `bson:"nodeId"`
+	Start      *time.Time `bson:"start"`      // First enteringNewRound
+	MaxRound   int64      `bson:"maxRound"`   // Highest round entered
+	ExtraStart *time.Time `bson:"extraStart"` // First enteringNewRound of a round at or above MinRounds
+	Commit     *time.Time `bson:"commit"`     // First enteringCommitStep
+	Last       time.Time  `bson:"last"`       // Last step event
+}
+
+// DetectIncidents scans the step events of all heights for:
+//   - extraRounds: a node entered a round at or above MinRounds; critical from
+//     two rounds past it
+//   - slowCommit: the first commit came more than MaxCommitMs after the first
+//     round started; critical past twice that. The affected nodes are those
+//     that took that long themselves.
+//   - stalled: no node committed and the height's events span more than
+//     MaxCommitMs, which leaves out a height still in progress when the logs end
+//   - missedCommit: another node committed, but nodes with step events at
+//     heights below and above never did
+//
+// Incidents are ordered by height and type.
+func DetectIncidents(ctx context.Context, coll *mongo.Collection, thresholds types.IncidentThresholds) ([]types.Incident, error) {
+	isType := func(eventType string) bson.D { return bson.D{{"$eq", bson.A{"$type", eventType}}} }
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"type", bson.D{{"$in", bson.A{"enteringNewRound", "enteringCommitStep"}}}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"height", bson.D{{"$ifNull", bson.A{"$height", "$currentHeight"}}}},
+				{"nodeId", "$nodeId"},
+			}},
+			// $min and $max ignore the nulls of the other event type
+			{"start", bson.D{{"$min", bson.D{{"$cond", bson.A{isType("enteringNewRound"), "$timestamp", nil}}}}}},
+			{"maxRound", bson.D{{"$max", bson.D{{"$cond", bson.A{isType("enteringNewRound"), "$round", nil}}}}}},
+			{"extraStart", bson.D{{"$min", bson.D{{"$cond", bson.A{
+				bson.D{{"$and", bson.A{isType("enteringNewRound"), bson.D{{"$gte", bson.A{"$round", thresholds.MinRounds}}}}}},
+				"$timestamp", nil,
+			}}}}}},
+			{"commit", bson.D{{"$min", bson.D{{"$cond", bson.A{isType("enteringCommitStep"), "$timestamp", nil}}}}}},
+			{"last", bson.D{{"$max", "$timestamp"}}},
+		}}},
+		{{"$sort", bson.D{{"_id.height", 1}, {"_id.nodeId", 1}}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []struct {
+		ID struct {
+			Height int64  `bson:"height"`
+			NodeID string `bson:"nodeId"`
+		} `bson:"_id"`
+		heightNodeSteps `bson:",inline"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	byHeight := map[int64][]heightNodeSteps{}
+	var sortedHeights []int64
+	activeFrom, activeTo := map[string]int64{}, map[string]int64{}
+	for _, doc := range docs {
+		height, steps := doc.ID.Height, doc.heightNodeSteps
+		steps.NodeID = doc.ID.NodeID
+		if _, ok := byHeight[height]; !ok {
+			sortedHeights = append(sortedHeights, height)
+		}
+		byHeight[height] = append(byHeight[height], steps)
+		if from, ok := activeFrom[steps.NodeID]; !ok || height < from {
+			activeFrom[steps.NodeID] = height
+		}
+		activeTo[steps.NodeID] = max(activeTo[steps.NodeID], height)
+	}
+	nodes := make([]string, 0, len(activeFrom))
+	for nodeID := range activeFrom {
+		nodes = append(nodes, nodeID)
+	}
+	sort.Strings(nodes)
+
+	maxCommit := time.Duration(thresholds.MaxCommitMs * float64(time.Millisecond))
+	incidents := []types.Incident{}
+	for _, height := range sortedHeights {
+		steps := byHeight[height]
+		var start, commit *time.Time
+		var extraStart *time.Time
+		var last time.Time
+		maxRound := int64(0)
+		for _, node := range steps {
+			start = earliest(start, node.Start)
+			commit = earliest(commit, node.Commit)
+			extraStart = earliest(extraStart, node.ExtraStart)
+			if node.Last.After(last) {
+				last = node.Last
+			}
+			maxRound = max(maxRound, node.MaxRound)
+		}
+		if start == nil {
+			// Only commit events, the height's first round is outside the logs
+			start = commit
+		}
+		end := last
+		if commit != nil {
+			end = *commit
+		}
+		incident := func(kind, severity string, affected []string, from, to time.Time) types.Incident {
+			return types.Incident{
+				ID:         fmt.Sprintf("%d-%s", height, kind),
+				Height:     height,
+				Type:       kind,
+				Severity:   severity,
+				Nodes:      affected,
+				Round:      maxRound,
+				DurationMs: types.DurationMs(to.Sub(from)),
+				Start:      from,
+				End:        to,
+			}
+		}
+
+		if extraStart != nil {
+			affected := []string{}
+			for _, node := range steps {
+				if node.ExtraStart != nil {
+					affected = append(affected, node.NodeID)
+				}
+			}
+			severity := types.SeverityWarning
+			if maxRound >= thresholds.MinRounds+2 {
+				severity = types.SeverityCritical
+			}
+			incidents = append(incidents, incident(types.IncidentExtraRounds, severity, affected, *extraStart, end))
+		}
+
+		if commit != nil && commit.Sub(*start) > maxCommit {
+			affected := []string{}
+			for _, node := range steps {
+				if node.Commit != nil && node.Start != nil && node.Commit.Sub(*node.Start) > maxCommit {
+					affected = append(affected, node.NodeID)
+				}
+			}
+			severity := types.SeverityWarning
+			if commit.Sub(*start) > 2*maxCommit {
+				severity = types.SeverityCritical
+			}
+			incidents = append(incidents, incident(types.IncidentSlowCommit, severity, affected, *start, *commit))
+		}
+
+		if commit == nil && start != nil && last.Sub(*start) > maxCommit {
+			affected := make([]string, 0, len(steps))
+			for _, node := range steps {
+				affected = append(affected, node.NodeID)
+			}
+			incidents = append(incidents, incident(types.IncidentStalled, types.SeverityCritical, affected, *start, last))
+		}
+
+		if commit != nil {
+			committed := map[string]bool{}
+			for _, node := range steps {
+				if node.Commit != nil {
+					committed[node.NodeID] = true
+				}
+			}
+			affected := []string{}
+			for _, nodeID := range nodes {
+				if !committed[nodeID] && activeFrom[nodeID] < height && activeTo[nodeID] > height {
+					affected = append(affected, nodeID)
+				}
+			}
+			if len(affected) > 0 {
+				incidents = append(incidents, incident(types.IncidentMissedCommit, types.SeverityWarning, affected, *start, last))
+			}
+		}
+	}
+
+	sort.SliceStable(incidents, func(i, j int) bool {
+		if incidents[i].Height != incidents[j].Height {
+			return incidents[i].Height < incidents[j].Height
+		}
+		return incidents[i].Type < incidents[j].Type
+	})
+	return incidents, nil
+}
+
+// earliest returns the earlier of two optional times
+func earliest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.Before(*a)) {
+		return b
+	}
+	return a
+}
+
+// PageIncidents filters incidents and returns the requested page
+func PageIncidents(incidents []types.Incident, filter IncidentFilter, page, perPage int) *IncidentsResult {
+	matching := []types.Incident{}
+	for _, incident := range incidents {
+		if filter.matches(incident) {
+			matching = append(matching, incident)
+		}
+	}
+	start := min((page-1)*perPage, len(matching))
+	end := min(start+perPage, len(matching))
+	return &IncidentsResult{Data: matching[start:end], Total: len(matching)}
+}
+
+// StoreIncidents replaces the stored incidents of a simulation database. The
+// collection is created even without incidents, to tell a simulation without
+// incidents from one whose incidents were never detected.
+func StoreIncidents(ctx context.Context, database *mongo.Database, incidents []types.Incident) error {
+	coll := database.Collection(IncidentsCollection)
+	if err := coll.Drop(ctx); err != nil {
+		return err
+	}
+	if err := database.CreateCollection(ctx, IncidentsCollection); err != nil {
+		return err
+	}
+	if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{"height", 1}, {"type", 1}}}); err != nil {
+		return err
+	}
+	if len(incidents) == 0 {
+		return nil
+	}
+	documents := make([]any, len(incidents))
+	for i, incident := range incidents {
+		documents[i] = incident
+	}
+	_, err := coll.InsertMany(ctx, documents)
+	return err
+}
+
+// ListIncidents returns a page of the stored incidents of a simulation
+// database matching filter, ordered by height and type. It returns nil when
+// the incidents were never stored.
+func ListIncidents(ctx context.Context, database *mongo.Database, filter IncidentFilter, page, perPage int) (*IncidentsResult, error) {
+	names, err := database.ListCollectionNames(ctx, bson.M{"name": IncidentsCollection})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	coll := database.Collection(IncidentsCollection)
+	query := filter.query()
+	total, err := coll.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"height", 1}, {"type", 1}}).
+		SetSkip(int64((page - 1) * perPage)).
+		SetLimit(int64(perPage))
+	cur, err := coll.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &IncidentsResult{Data: []types.Incident{}, Total: int(total)}
+	if err := cur.All(ctx, &result.Data); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
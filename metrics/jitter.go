@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ComputeJitterTimeSeries returns, per sender→receiver pair, the latency mean
+// and standard deviation of the confirmed vote latencies in each time bucket of
+// the given resolution. Pairs are ordered by their worst bucket jitter and
+// limited to topN when it is positive.
+func ComputeJitterTimeSeries(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, filter PairLatencyFilter, resolution time.Duration, topN int,
+) ([]types.JitterSeries, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", pairLatencyMatch(from, to, types.HeightRange{}, filter)}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"sender", "$senderPeerId"},
+				{"receiver", "$recipientPeerId"},
+				{"start", bson.D{{"$dateTrunc", bson.D{
+					{"date", "$sentTime"},
+					{"unit", "millisecond"},
+					{"binSize", resolution.Milliseconds()},
+				}}}},
+			}},
+			{"meanMs", bson.D{{"$avg", nanosToMsExpr("$latency")}}},
+			{"stdDevMs", bson.D{{"$stdDevSamp", nanosToMsExpr("$latency")}}},
+			{"samples", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$sort", bson.D{{"_id.start", 1}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"sender", "$_id.sender"}, {"receiver", "$_id.receiver"}}},
+			{"maxStdDevMs", bson.D{{"$max", "$stdDevMs"}}},
+			{"points", bson.D{{"$push", bson.D{
+				{"start", "$_id.start"},
+				{"meanMs", "$meanMs"},
+				{"stdDevMs", bson.D{{"$ifNull", bson.A{"$stdDevMs", 0}}}},
+				{"samples", "$samples"},
+			}}}},
+		}}},
+		{{"$project", bson.D{
+			{"_id", 0},
+			{"sender", "$_id.sender"},
+			{"receiver", "$_id.receiver"},
+			{"maxStdDevMs", bson.D{{"$ifNull", bson.A{"$maxStdDevMs", 0}}}},
+			{"points", 1},
+		}}},
+		{{"$sort", bson.D{{"maxStdDevMs", -1}, {"sender", 1}, {"receiver", 1}}}},
+	}
+	if topN > 0 {
+		pipeline = append(pipeline, bson.D{{"$limit", topN}})
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	series := []types.JitterSeries{}
+	if err := cur.All(ctx, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
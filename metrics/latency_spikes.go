@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxLatencySpikes bounds the spikes kept for paging; only the most severe ones are returned
+const maxLatencySpikes = 50000
+
+// LatencySpikesResult contains a page of latency spikes and the total number of spikes
+type LatencySpikesResult struct {
+	Data      []types.LatencySpike
+	Total     int
+	Truncated bool // Only the maxLatencySpikes most severe spikes can be paged
+}
+
+// spikeGroupKey identifies the (sender, receiver, vote type) group a vote latency is compared against
+type spikeGroupKey struct {
+	Sender   string `bson:"sender"`
+	Receiver string `bson:"receiver"`
+	VoteType string `bson:"voteType"`
+}
+
+// spikeGroupID is the _id of the groups of spikeGroupKey
+var spikeGroupID = bson.D{
+	{"sender", "$senderPeerId"},
+	{"receiver", "$recipientPeerId"},
+	{"voteType", "$vote.type"},
+}
+
+// spikeGroupStats holds the latency distribution of a group, in nanoseconds
+type spikeGroupStats struct {
+	P95    float64
+	Mean   float64
+	StdDev float64
+}
+
+// ComputeLatencySpikes returns the confirmed vote deliveries whose latency
+// exceeds factor × the p95 latency of their (sender, receiver, vote type)
+// group, most severe first. Severity is the number of standard deviations
+// above the group mean.
+//
+// The group thresholds are computed first; the deliveries above the lowest
+// threshold are then scanned and checked against their group's threshold.
+func ComputeLatencySpikes(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, filter PairLatencyFilter, factor float64, page, perPage int,
+) (*LatencySpikesResult, error) {
+	match := pairLatencyMatch(from, to, heights, filter)
+	groups, err := spikeGroupThresholds(ctx, coll, match)
+	if err != nil {
+		return nil, err
+	}
+	result := &LatencySpikesResult{Data: []types.LatencySpike{}}
+	if len(groups) == 0 {
+		return result, nil
+	}
+
+	minThreshold := math.Inf(1)
+	for _, stats := range groups {
+		minThreshold = math.Min(minThreshold, factor*stats.P95)
+	}
+
+	opts := options.Find().SetProjection(bson.D{
+		{"vote.type", 1}, {"vote.height", 1}, {"vote.round", 1}, {"vote.validatorIndex", 1},
+		{"senderPeerId", 1}, {"recipientPeerId", 1}, {"sentTime", 1}, {"receivedTime", 1}, {"latency", 1},
+	})
+	cur, err := coll.Find(ctx, append(match, bson.E{"latency", bson.D{{"$gt", minThreshold}}}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var spikes []types.LatencySpike
+	for cur.Next(ctx) {
+		var doc struct {
+			Vote struct {
+				Type           string `bson:"type"`
+				Height         uint64 `bson:"height"`
+				Round          uint64 `bson:"round"`
+				ValidatorIndex uint64 `bson:"validatorIndex"`
+			} `bson:"vote"`
+			SenderPeerID    string    `bson:"senderPeerId"`
+			RecipientPeerID string    `bson:"recipientPeerId"`
+			SentTime        time.Time `bson:"sentTime"`
+			ReceivedTime    time.Time `bson:"receivedTime"`
+			Latency         float64   `bson:"latency"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		stats, ok := groups[spikeGroupKey{Sender: doc.SenderPeerID, Receiver: doc.RecipientPeerID, VoteType: doc.Vote.Type}]
+		threshold := factor * stats.P95
+		if !ok || doc.Latency <= threshold {
+			continue
+		}
+		var sigma float64
+		if stats.StdDev > 0 {
+			sigma = (doc.Latency - stats.Mean) / stats.StdDev
+		}
+		spikes = append(spikes, types.LatencySpike{
+			Height:         doc.Vote.Height,
+			Round:          doc.Vote.Round,
+			VoteType:       doc.Vote.Type,
+			ValidatorIndex: doc.Vote.ValidatorIndex,
+			Sender:         doc.SenderPeerID,
+			Receiver:       doc.RecipientPeerID,
+			SentTime:       doc.SentTime,
+			ReceivedTime:   doc.ReceivedTime,
+			LatencyMs:      types.NanosToMs(doc.Latency),
+			ThresholdMs:    types.NanosToMs(threshold),
+			MeanMs:         types.NanosToMs(stats.Mean),
+			Sigma:          sigma,
+		})
+		result.Total++
+		if len(spikes) >= 2*maxLatencySpikes {
+			spikes = mostSevere(spikes)
+			result.Truncated = true
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	spikes = mostSevere(spikes)
+	start := min((page-1)*perPage, len(spikes))
+	end := min(start+perPage, len(spikes))
+	result.Data = append(result.Data, spikes[start:end]...)
+	return result, nil
+}
+
+// mostSevere sorts spikes by severity and keeps the first maxLatencySpikes
+func mostSevere(spikes []types.LatencySpike) []types.LatencySpike {
+	sort.Slice(spikes, func(i, j int) bool {
+		if spikes[i].Sigma != spikes[j].Sigma {
+			return spikes[i].Sigma > spikes[j].Sigma
+		}
+		if spikes[i].LatencyMs != spikes[j].LatencyMs {
+			return spikes[i].LatencyMs > spikes[j].LatencyMs
+		}
+		return spikes[i].SentTime.Before(spikes[j].SentTime)
+	})
+	if len(spikes) > maxLatencySpikes {
+		spikes = spikes[:maxLatencySpikes]
+	}
+	return spikes
+}
+
+// spikeThresholdPercentiles is the quantile spikes are measured against
+var spikeThresholdPercentiles = []float64{0.95}
+
+// spikeGroupThresholds computes the p95, mean and standard deviation of the latency of every group
+func spikeGroupThresholds(ctx context.Context, coll *mongo.Collection, match bson.D) (map[spikeGroupKey]spikeGroupStats, error) {
+	build := func(native bool) mongo.Pipeline {
+		pipeline := append(mongo.Pipeline{{{"$match", match}}}, percentileRanks(native, spikeGroupID, "$latency")...)
+		return append(pipeline, bson.D{{"$group", bson.D{
+			{"_id", spikeGroupID},
+			{"p95", rankedPercentiles(native, "$latency", spikeThresholdPercentiles)},
+			{"mean", bson.D{{"$avg", "$latency"}}},
+			{"stdDev", bson.D{{"$stdDevSamp", "$latency"}}},
+		}}})
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID     spikeGroupKey          `bson:"_id"`
+		P95    accumulatedPercentiles `bson:"p95"`
+		Mean   float64                `bson:"mean"`
+		StdDev *float64               `bson:"stdDev"` // null for groups with a single sample
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	groups := make(map[spikeGroupKey]spikeGroupStats, len(rawResults))
+	for _, doc := range rawResults {
+		p95 := doc.P95.quantiles(spikeThresholdPercentiles)
+		if p95 == nil {
+			continue
+		}
+		stats := spikeGroupStats{P95: p95[0], Mean: doc.Mean}
+		if doc.StdDev != nil {
+			stats.StdDev = *doc.StdDev
+		}
+		groups[doc.ID] = stats
+	}
+	return groups, nil
+}
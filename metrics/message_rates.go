@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Groupings of ComputeMessageTimeSeries
+const (
+	MessageGroupByType = "type"
+	MessageGroupByNode = "node"
+)
+
+// ComputeMessageTimeSeries counts the events in the window per time bucket of
+// the given resolution, with one series per event type or per node depending
+// on groupBy. Empty eventTypes and nodes match every event type and node. The
+// series are ordered by label; buckets without events are left out.
+func ComputeMessageTimeSeries(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, eventTypes, nodes []string, groupBy string, resolution time.Duration,
+) ([]types.MessageSeries, error) {
+	match := bson.D{{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}}}
+	if len(eventTypes) > 0 {
+		match = append(match, bson.E{"type", bson.D{{"$in", eventTypes}}})
+	}
+	if len(nodes) > 0 {
+		match = append(match, bson.E{"nodeId", bson.D{{"$in", nodes}}})
+	}
+	label := "$type"
+	if groupBy == MessageGroupByNode {
+		label = "$nodeId"
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"label", label},
+				{"start", bson.D{{"$dateTrunc", bson.D{
+					{"date", "$timestamp"},
+					{"unit", "millisecond"},
+					{"binSize", resolution.Milliseconds()},
+				}}}},
+			}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$sort", bson.D{{"_id.start", 1}}}},
+		{{"$group", bson.D{
+			{"_id", "$_id.label"},
+			{"total", bson.D{{"$sum", "$count"}}},
+			{"points", bson.D{{"$push", bson.A{bson.D{{"$toLong", "$_id.start"}}, "$count"}}}},
+		}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	series := []types.MessageSeries{}
+	if err := cur.All(ctx, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
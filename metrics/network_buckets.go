@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/latency"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"slices"
+	"time"
+)
+
+// The network latency summary collections hold either whole-run documents or,
+// from ETL versions that bucket the run, one document per time bucket carrying
+// windowStart and windowEnd. Bucket documents of a window are merged on read.
+
+// hasSummaryDocs reports whether coll has a summary document matching filter
+func hasSummaryDocs(ctx context.Context, coll *mongo.Collection, filter bson.D) (bool, error) {
+	err := coll.FindOne(ctx, filter, options.FindOne().SetProjection(bson.D{{"_id", 1}})).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// summaryWindowFilter selects the summary documents for a window; zero from/to
+// leave it open on that side. Windows select the buckets overlapping them, and
+// the whole-run documents when the collection has no buckets (legacy data).
+// Without a window the whole-run documents are used when present, otherwise
+// all buckets.
+func summaryWindowFilter(ctx context.Context, coll *mongo.Collection, from, to time.Time) (bson.D, error) {
+	bucketed, err := hasSummaryDocs(ctx, coll, bson.D{{"windowStart", bson.D{{"$exists", true}}}})
+	if err != nil || !bucketed {
+		return bson.D{}, err
+	}
+
+	if from.IsZero() && to.IsZero() {
+		wholeRun, err := hasSummaryDocs(ctx, coll, bson.D{{"windowStart", bson.D{{"$exists", false}}}})
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{"windowStart", bson.D{{"$exists", !wholeRun}}}}, nil
+	}
+
+	windowStart := bson.D{{"$exists", true}}
+	if !to.IsZero() {
+		windowStart = append(windowStart, bson.E{"$lte", to})
+	}
+	filter := bson.D{{"windowStart", windowStart}}
+	if !from.IsZero() {
+		filter = append(filter, bson.E{"windowEnd", bson.D{{"$gt", from}}})
+	}
+	return filter, nil
+}
+
+// mergeLatencyHistogram merges the histogram of another bucket into merged.
+// Percentiles are recomputed from the merged latencies when all samples are
+// stored, and averaged weighted by count otherwise.
+func mergeLatencyHistogram(merged, other *latency.LatencyHistogram) *latency.LatencyHistogram {
+	if other == nil {
+		return merged
+	}
+	if merged == nil {
+		copied := *other
+		copied.Latencies = slices.Clone(other.Latencies)
+		return &copied
+	}
+
+	weighted := func(a, b int64) int64 {
+		total := merged.Count + other.Count
+		if total == 0 {
+			return max(a, b)
+		}
+		return int64(math.Round((float64(a)*float64(merged.Count) + float64(b)*float64(other.Count)) / float64(total)))
+	}
+	merged.MeanLatency = weighted(merged.MeanLatency, other.MeanLatency)
+	merged.MedianLatency = weighted(merged.MedianLatency, other.MedianLatency)
+	merged.P95Latency = weighted(merged.P95Latency, other.P95Latency)
+	merged.P99Latency = weighted(merged.P99Latency, other.P99Latency)
+
+	if merged.Count == 0 || other.MinLatency < merged.MinLatency {
+		merged.MinLatency = other.MinLatency
+	}
+	merged.MaxLatency = max(merged.MaxLatency, other.MaxLatency)
+	if merged.FirstSeen.IsZero() || (!other.FirstSeen.IsZero() && other.FirstSeen.Before(merged.FirstSeen)) {
+		merged.FirstSeen = other.FirstSeen
+	}
+	if other.LastSeen.After(merged.LastSeen) {
+		merged.LastSeen = other.LastSeen
+	}
+	merged.Count += other.Count
+	merged.Latencies = append(merged.Latencies, other.Latencies...)
+	merged.BelowP50Count += other.BelowP50Count
+	merged.P50ToP95Count += other.P50ToP95Count
+	merged.P95ToP99Count += other.P95ToP99Count
+	merged.AboveP99Count += other.AboveP99Count
+
+	if merged.Count > 0 && len(merged.Latencies) == merged.Count {
+		sorted := make([]float64, len(merged.Latencies))
+		for i, l := range merged.Latencies {
+			sorted[i] = float64(l)
+		}
+		slices.Sort(sorted)
+		merged.MedianLatency = int64(math.Round(quantileOf(sorted, 0.50)))
+		merged.P95Latency = int64(math.Round(quantileOf(sorted, 0.95)))
+		merged.P99Latency = int64(math.Round(quantileOf(sorted, 0.99)))
+	}
+	return merged
+}
+
+// mergeNodePairStats merges the buckets of each node pair into one summary, keeping the order of first appearance
+func mergeNodePairStats(buckets []latency.NodePairLatencyStats) []latency.NodePairLatencyStats {
+	merged := []latency.NodePairLatencyStats{}
+	index := map[string]int{}
+	for _, bucket := range buckets {
+		i, ok := index[bucket.NodePairKey]
+		if !ok {
+			index[bucket.NodePairKey] = len(merged)
+			pair := bucket
+			pair.MessageTypes = map[string]*latency.LatencyHistogram{}
+			pair.OverallStats = nil
+			merged = append(merged, pair)
+			i = len(merged) - 1
+		}
+		pair := &merged[i]
+		for messageType, histogram := range bucket.MessageTypes {
+			pair.MessageTypes[messageType] = mergeLatencyHistogram(pair.MessageTypes[messageType], histogram)
+		}
+		pair.OverallStats = mergeLatencyHistogram(pair.OverallStats, bucket.OverallStats)
+	}
+	return merged
+}
@@ -0,0 +1,290 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// FanoutResult contains a page of per-height fan-out and the total number of (height, node) rows
+type FanoutResult struct {
+	Data  []types.NodeHeightFanout
+	Total int
+}
+
+// fanoutSendTypes are the gossip events a node sends to recipientPeerId; their
+// receive counterparts carry sourcePeerId
+var fanoutSendTypes = bson.A{"sendVote", "sendBlockPart"}
+
+// fanoutEdgeStages turns the vote and block part gossip events into edges
+// { nodeId, height, timestamp, outbound, peer }, peer being the counterpart
+// peer ID
+func fanoutEdgeStages(from, to time.Time, heights types.HeightRange) mongo.Pipeline {
+	timeRange := bson.D{{"$gte", from}, {"$lte", to}}
+	votesMatch := withHeightRange(bson.D{
+		{"type", bson.D{{"$in", bson.A{"sendVote", "receiveVote"}}}},
+		{"timestamp", timeRange},
+	}, "vote.height", heights)
+	partsMatch := withHeightRange(bson.D{
+		{"type", bson.D{{"$in", bson.A{"sendBlockPart", "receivePacketBlockPart"}}}},
+		{"timestamp", timeRange},
+	}, "height", heights)
+
+	return mongo.Pipeline{
+		{{"$match", bson.D{{"$or", bson.A{votesMatch, partsMatch}}}}},
+		{{"$project", bson.D{
+			{"_id", 0},
+			{"nodeId", 1},
+			{"timestamp", 1},
+			{"height", bson.D{{"$ifNull", bson.A{"$vote.height", "$height"}}}},
+			{"outbound", bson.D{{"$in", bson.A{"$type", fanoutSendTypes}}}},
+			{"peer", bson.D{{"$cond", bson.A{
+				bson.D{{"$in", bson.A{"$type", fanoutSendTypes}}}, "$recipientPeerId", "$sourcePeerId",
+			}}}},
+		}}},
+	}
+}
+
+// peerSetAccumulators collect the distinct peers sent to and received from,
+// with nulls standing in for edges of the other direction
+var peerSetAccumulators = bson.D{
+	{"outPeers", bson.D{{"$addToSet", bson.D{{"$cond", bson.A{"$outbound", "$peer", nil}}}}}},
+	{"inPeers", bson.D{{"$addToSet", bson.D{{"$cond", bson.A{"$outbound", nil, "$peer"}}}}}},
+}
+
+// degreeOf is the size of a peer set collected by peerSetAccumulators
+func degreeOf(set string) bson.D {
+	return bson.D{{"$size", bson.D{{"$setDifference", bson.A{set, bson.A{nil}}}}}}
+}
+
+// ComputeNetworkFanout returns, per height and node, how many distinct peers the
+// node sent votes and block parts to and received them from, ordered by height
+// and node
+func ComputeNetworkFanout(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, page, perPage int,
+) (*FanoutResult, error) {
+	pipeline := append(fanoutEdgeStages(from, to, heights),
+		bson.D{{"$group", append(bson.D{
+			{"_id", bson.D{{"height", "$height"}, {"nodeId", "$nodeId"}}},
+			{"sent", bson.D{{"$sum", bson.D{{"$cond", bson.A{"$outbound", 1, 0}}}}}},
+			{"received", bson.D{{"$sum", bson.D{{"$cond", bson.A{"$outbound", 0, 1}}}}}},
+		}, peerSetAccumulators...)}},
+		bson.D{{"$project", bson.D{
+			{"_id", 0},
+			{"height", "$_id.height"},
+			{"nodeId", "$_id.nodeId"},
+			{"outDegree", degreeOf("$outPeers")},
+			{"inDegree", degreeOf("$inPeers")},
+			{"sent", 1},
+			{"received", 1},
+		}}},
+		bson.D{{"$sort", bson.D{{"height", 1}, {"nodeId", 1}}}},
+		pageFacet(page, perPage),
+	)
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []types.NodeHeightFanout `bson:"data"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &FanoutResult{Data: facet.Data}
+	if result.Data == nil {
+		result.Data = []types.NodeHeightFanout{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
+
+// ComputeNodeFanout aggregates the fan-out of each node over the range, ordered
+// by node. The node's degrees are also counted per time window; a window whose
+// out- or in-degree is below threshold times the node's peak window degree is
+// low, and a node with low windows is flagged as possibly partially
+// partitioned. Windows without any gossip event of the node are not evaluated.
+func ComputeNodeFanout(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, window time.Duration, threshold float64,
+) ([]types.NodeFanout, error) {
+	nodes, err := computeNodeHeightDegrees(ctx, coll, from, to, heights)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := computeNodeWindowDegrees(ctx, coll, from, to, heights, window)
+	if err != nil {
+		return nil, err
+	}
+
+	byNode := map[string][]types.FanoutWindow{}
+	for _, doc := range windows {
+		byNode[doc.ID.NodeID] = append(byNode[doc.ID.NodeID], types.FanoutWindow{
+			Start:     doc.ID.Start,
+			OutDegree: doc.OutDegree,
+			InDegree:  doc.InDegree,
+		})
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		nodeWindows := byNode[node.NodeID]
+		for _, w := range nodeWindows {
+			node.PeakOutDegree = max(node.PeakOutDegree, w.OutDegree)
+			node.PeakInDegree = max(node.PeakInDegree, w.InDegree)
+		}
+		node.LowWindows = []types.FanoutWindow{}
+		for _, w := range nodeWindows {
+			if float64(w.OutDegree) < threshold*float64(node.PeakOutDegree) ||
+				float64(w.InDegree) < threshold*float64(node.PeakInDegree) {
+				node.LowWindows = append(node.LowWindows, w)
+			}
+		}
+		sort.Slice(node.LowWindows, func(a, b int) bool { return node.LowWindows[a].Start.Before(node.LowWindows[b].Start) })
+		node.Flagged = len(node.LowWindows) > 0
+	}
+	return nodes, nil
+}
+
+// computeNodeHeightDegrees summarizes the per-height degrees of each node and
+// counts its distinct peers over the range
+func computeNodeHeightDegrees(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) ([]types.NodeFanout, error) {
+	pipeline := append(fanoutEdgeStages(from, to, heights),
+		bson.D{{"$group", append(bson.D{
+			{"_id", bson.D{{"height", "$height"}, {"nodeId", "$nodeId"}}},
+		}, peerSetAccumulators...)}},
+		bson.D{{"$set", bson.D{
+			{"outPeers", bson.D{{"$setDifference", bson.A{"$outPeers", bson.A{nil}}}}},
+			{"inPeers", bson.D{{"$setDifference", bson.A{"$inPeers", bson.A{nil}}}}},
+		}}},
+		bson.D{{"$group", bson.D{
+			{"_id", "$_id.nodeId"},
+			{"heights", bson.D{{"$sum", 1}}},
+			{"avgOutDegree", bson.D{{"$avg", bson.D{{"$size", "$outPeers"}}}}},
+			{"avgInDegree", bson.D{{"$avg", bson.D{{"$size", "$inPeers"}}}}},
+			{"minOutDegree", bson.D{{"$min", bson.D{{"$size", "$outPeers"}}}}},
+			{"minInDegree", bson.D{{"$min", bson.D{{"$size", "$inPeers"}}}}},
+			{"outPeers", bson.D{{"$push", "$outPeers"}}},
+			{"inPeers", bson.D{{"$push", "$inPeers"}}},
+		}}},
+		bson.D{{"$project", bson.D{
+			{"heights", 1},
+			{"avgOutDegree", 1},
+			{"avgInDegree", 1},
+			{"minOutDegree", 1},
+			{"minInDegree", 1},
+			{"outPeers", bson.D{{"$size", bson.D{{"$reduce", bson.D{
+				{"input", "$outPeers"}, {"initialValue", bson.A{}}, {"in", bson.D{{"$setUnion", bson.A{"$$value", "$$this"}}}},
+			}}}}}},
+			{"inPeers", bson.D{{"$size", bson.D{{"$reduce", bson.D{
+				{"input", "$inPeers"}, {"initialValue", bson.A{}}, {"in", bson.D{{"$setUnion", bson.A{"$$value", "$$this"}}}},
+			}}}}}},
+		}}},
+		bson.D{{"$sort", bson.D{{"_id", 1}}}},
+	)
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []struct {
+		NodeID       string  `bson:"_id"`
+		Heights      int     `bson:"heights"`
+		OutPeers     int     `bson:"outPeers"`
+		InPeers      int     `bson:"inPeers"`
+		AvgOutDegree float64 `bson:"avgOutDegree"`
+		AvgInDegree  float64 `bson:"avgInDegree"`
+		MinOutDegree int     `bson:"minOutDegree"`
+		MinInDegree  int     `bson:"minInDegree"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]types.NodeFanout, len(docs))
+	for i, doc := range docs {
+		nodes[i] = types.NodeFanout{
+			NodeID:       doc.NodeID,
+			Heights:      doc.Heights,
+			OutPeers:     doc.OutPeers,
+			InPeers:      doc.InPeers,
+			AvgOutDegree: doc.AvgOutDegree,
+			AvgInDegree:  doc.AvgInDegree,
+			MinOutDegree: doc.MinOutDegree,
+			MinInDegree:  doc.MinInDegree,
+		}
+	}
+	return nodes, nil
+}
+
+// windowNodeDegree is a node's out- and in-degree in a time window
+type windowNodeDegree struct {
+	ID struct {
+		Start  time.Time `bson:"start"`
+		NodeID string    `bson:"nodeId"`
+	} `bson:"_id"`
+	OutDegree int `bson:"outDegree"`
+	InDegree  int `bson:"inDegree"`
+}
+
+// computeNodeWindowDegrees counts the distinct peers of every node per time window
+func computeNodeWindowDegrees(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, window time.Duration,
+) ([]windowNodeDegree, error) {
+	pipeline := append(fanoutEdgeStages(from, to, heights),
+		bson.D{{"$group", append(bson.D{
+			{"_id", bson.D{
+				{"start", bson.D{{"$dateTrunc", bson.D{
+					{"date", "$timestamp"},
+					{"unit", "millisecond"},
+					{"binSize", window.Milliseconds()},
+				}}}},
+				{"nodeId", "$nodeId"},
+			}},
+		}, peerSetAccumulators...)}},
+		bson.D{{"$project", bson.D{
+			{"outDegree", degreeOf("$outPeers")},
+			{"inDegree", degreeOf("$inPeers")},
+		}}},
+	)
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var degrees []windowNodeDegree
+	if err := cur.All(ctx, &degrees); err != nil {
+		return nil, err
+	}
+	return degrees, nil
+}
@@ -8,24 +8,22 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
-// GetNetworkLatencyStats retrieves NodePairLatencyStats directly from MongoDB
-func GetNetworkLatencyStats(ctx context.Context, coll *mongo.Collection) ([]latency.NodePairLatencyStats, error) {
-	// Count documents first
-	count, err := coll.CountDocuments(ctx, bson.M{})
+// GetNetworkLatencyStats retrieves NodePairLatencyStats directly from MongoDB,
+// merging the time buckets of the window per node pair. Zero from/to leave the
+// window open on that side; see summaryWindowFilter.
+func GetNetworkLatencyStats(ctx context.Context, coll *mongo.Collection, from, to time.Time) ([]latency.NodePairLatencyStats, error) {
+	filter, err := summaryWindowFilter(ctx, coll, from, to)
 	if err != nil {
-		return nil, fmt.Errorf("error counting documents: %v", err)
-	}
-
-	if count == 0 {
-		return []latency.NodePairLatencyStats{}, nil
+		return nil, fmt.Errorf("error selecting summary documents: %v", err)
 	}
 
 	// Sort by node pair key for consistent ordering
-	opts := options.Find().SetSort(bson.D{{"nodePairKey", 1}})
+	opts := options.Find().SetSort(bson.D{{"nodePairKey", 1}, {"windowStart", 1}})
 
-	cursor, err := coll.Find(ctx, bson.M{}, opts)
+	cursor, err := coll.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, fmt.Errorf("error finding documents: %v", err)
 	}
@@ -36,144 +34,259 @@ func GetNetworkLatencyStats(ctx context.Context, coll *mongo.Collection) ([]late
 		return nil, fmt.Errorf("error decoding documents: %v", err)
 	}
 
-	return stats, nil
+	return mergeNodePairStats(stats), nil
+}
+
+// NodeStatsSortFields maps the sortBy values of the node stats to their fields
+var NodeStatsSortFields = map[string]string{
+	"nodeId":            "nodeId",
+	"validatorAddress":  "validatorAddress",
+	"totalSends":        "totalSends",
+	"totalReceives":     "totalReceives",
+	"unmatchedSends":    "unmatchedSends",
+	"unmatchedReceives": "unmatchedReceives",
+	"peerCount":         "peerCount",
 }
 
-// GetNetworkLatencyOverview computes comprehensive network latency statistics
-func GetNetworkLatencyOverview(ctx context.Context, coll *mongo.Collection) (*types.NetworkLatencyOverviewResponse, error) {
-	// For now, get all documents to test - we can add time filtering later
-	filter := bson.M{}
+// NodeStatsQuery selects, orders and pages the network latency node stats
+type NodeStatsQuery struct {
+	NodeIDs    []string // Empty means all nodes
+	SortBy     string   // Key of NodeStatsSortFields
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+// NodeStatsResult contains a page of node stats and the total number of matching nodes
+type NodeStatsResult struct {
+	Data  []latency.NodeNetworkStats
+	Total int64
+}
 
-	cursor, err := coll.Find(ctx, filter)
+// GetNetworkLatencyNodeStats retrieves a page of NodeNetworkStats, ordered by
+// the requested field and then by node ID. The time buckets of the window are
+// summed per node; see summaryWindowFilter.
+func GetNetworkLatencyNodeStats(ctx context.Context, coll *mongo.Collection, from, to time.Time, query NodeStatsQuery) (*NodeStatsResult, error) {
+	filter, err := summaryWindowFilter(ctx, coll, from, to)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error selecting summary documents: %v", err)
+	}
+	if len(query.NodeIDs) > 0 {
+		filter = append(filter, bson.E{"nodeId", bson.D{{"$in", query.NodeIDs}}})
+	}
+
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	sort := bson.D{{NodeStatsSortFields[query.SortBy], direction}}
+	if query.SortBy != "nodeId" {
+		sort = append(sort, bson.E{"nodeId", 1})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", filter}},
+		{{"$sort", bson.D{{"windowStart", 1}}}},
+		{{"$group", bson.D{
+			{"_id", "$nodeId"},
+			{"validatorAddress", bson.D{{"$last", "$validatorAddress"}}},
+			{"totalSends", bson.D{{"$sum", "$totalSends"}}},
+			{"totalReceives", bson.D{{"$sum", "$totalReceives"}}},
+			{"unmatchedSends", bson.D{{"$sum", "$unmatchedSends"}}},
+			{"unmatchedReceives", bson.D{{"$sum", "$unmatchedReceives"}}},
+			{"connectedPeers", bson.D{{"$push", bson.D{{"$ifNull", bson.A{"$connectedPeers", bson.A{}}}}}}},
+		}}},
+		{{"$project", bson.D{
+			{"_id", 0},
+			{"nodeId", "$_id"},
+			{"validatorAddress", 1},
+			{"totalSends", 1},
+			{"totalReceives", 1},
+			{"unmatchedSends", 1},
+			{"unmatchedReceives", 1},
+			{"connectedPeers", bson.D{{"$reduce", bson.D{
+				{"input", "$connectedPeers"},
+				{"initialValue", bson.A{}},
+				{"in", bson.D{{"$setUnion", bson.A{"$$value", "$$this"}}}},
+			}}}},
+		}}},
+		{{"$addFields", bson.D{{"peerCount", bson.D{{"$size", "$connectedPeers"}}}}}},
+		{{"$sort", sort}},
+		{{"$facet", bson.D{
+			{"total", bson.A{bson.D{{"$count", "total"}}}},
+			{"data", bson.A{
+				bson.D{{"$skip", query.Offset}},
+				bson.D{{"$limit", query.Limit}},
+			}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error aggregating documents: %v", err)
 	}
 	defer cursor.Close(ctx)
 
-	// Data structures to accumulate statistics
-	messageTypeStats := make(map[string]struct {
-		totalWeightedP95 float64
-		totalCount       int
-	})
-	nodeStats := make(map[string]struct {
-		totalWeightedP95 float64
-		totalCount       int
-	})
-
-	var overallWeightedP95 float64
-	var overallCount int
-
-	docCount := 0
-	// Process each document
-	for cursor.Next(ctx) {
-		var doc bson.M
-		if err := cursor.Decode(&doc); err != nil {
-			continue
+	var facet struct {
+		Total []struct {
+			Total int64 `bson:"total"`
+		} `bson:"total"`
+		Data []latency.NodeNetworkStats `bson:"data"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&facet); err != nil {
+			return nil, fmt.Errorf("error decoding documents: %v", err)
 		}
-		docCount++
-
-		node1Id, _ := doc["node1Id"].(string)
-		node2Id, _ := doc["node2Id"].(string)
-		fmt.Printf("Processing document %d: node1=%s, node2=%s\n", docCount, node1Id, node2Id)
-
-		// Process messageTypes
-		if messageTypes, ok := doc["messageTypes"].(bson.M); ok {
-			for msgType, msgData := range messageTypes {
-				if msgInfo, ok := msgData.(bson.M); ok {
-					if count, ok := msgInfo["count"].(int32); ok {
-						if p95Latency, ok := msgInfo["p95LatencyMs"].(int64); ok {
-							weightedP95 := float64(p95Latency) * float64(count)
-
-							// Accumulate for message type statistics
-							if stat, exists := messageTypeStats[msgType]; exists {
-								stat.totalWeightedP95 += weightedP95
-								stat.totalCount += int(count)
-								messageTypeStats[msgType] = stat
-							} else {
-								messageTypeStats[msgType] = struct {
-									totalWeightedP95 float64
-									totalCount       int
-								}{weightedP95, int(count)}
-							}
-
-							// Accumulate for overall statistics
-							overallWeightedP95 += weightedP95
-							overallCount += int(count)
-
-							// Accumulate for both nodes
-							if stat, exists := nodeStats[node1Id]; exists {
-								stat.totalWeightedP95 += weightedP95 / 2 // Split between sender and receiver
-								stat.totalCount += int(count) / 2
-								nodeStats[node1Id] = stat
-							} else {
-								nodeStats[node1Id] = struct {
-									totalWeightedP95 float64
-									totalCount       int
-								}{weightedP95 / 2, int(count) / 2}
-							}
-
-							if stat, exists := nodeStats[node2Id]; exists {
-								stat.totalWeightedP95 += weightedP95 / 2
-								stat.totalCount += int(count) / 2
-								nodeStats[node2Id] = stat
-							} else {
-								nodeStats[node2Id] = struct {
-									totalWeightedP95 float64
-									totalCount       int
-								}{weightedP95 / 2, int(count) / 2}
-							}
-						}
-					}
-				}
-			}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &NodeStatsResult{Data: facet.Data}
+	if result.Data == nil {
+		result.Data = []latency.NodeNetworkStats{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
+
+// GetNetworkLatencyOverview computes the count-weighted average p95 latency of
+// the node pair summaries overall, per message type and per node. Counts and
+// latencies are converted to doubles, so documents written with any numeric
+// type are included; message types with non-numeric values are skipped.
+//
+// The time buckets of the window are weighted like any other document; see
+// summaryWindowFilter. Zero from/to leave the window open on that side.
+func GetNetworkLatencyOverview(ctx context.Context, coll *mongo.Collection, from, to time.Time) (*types.NetworkLatencyOverviewResponse, error) {
+	filter, err := summaryWindowFilter(ctx, coll, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	toDouble := func(input string) bson.D {
+		return bson.D{{"$convert", bson.D{
+			{"input", input}, {"to", "double"}, {"onError", nil}, {"onNull", nil},
+		}}}
+	}
+	weightedAverage := func(groupID any) bson.D {
+		return bson.D{{"$group", bson.D{
+			{"_id", groupID},
+			{"weightedP95", bson.D{{"$sum", "$weightedP95"}}},
+			{"count", bson.D{{"$sum", "$count"}}},
+		}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", filter}},
+		{{"$project", bson.D{
+			{"node1Id", 1},
+			{"node2Id", 1},
+			{"messageTypes", bson.D{{"$objectToArray", bson.D{{"$ifNull", bson.A{"$messageTypes", bson.D{}}}}}}},
+		}}},
+		{{"$unwind", "$messageTypes"}},
+		{{"$project", bson.D{
+			{"node1Id", 1},
+			{"node2Id", 1},
+			{"messageType", "$messageTypes.k"},
+			{"count", toDouble("$messageTypes.v.count")},
+			{"p95LatencyMs", toDouble("$messageTypes.v.p95LatencyMs")},
+		}}},
+		{{"$match", bson.D{
+			{"count", bson.D{{"$gt", 0}}},
+			{"p95LatencyMs", bson.D{{"$ne", nil}}},
+		}}},
+		{{"$addFields", bson.D{
+			{"weightedP95", bson.D{{"$multiply", bson.A{"$p95LatencyMs", "$count"}}}},
+		}}},
+		{{"$facet", bson.D{
+			{"overall", bson.A{weightedAverage(nil)}},
+			{"messageTypes", bson.A{weightedAverage("$messageType")}},
+			// Each pair counts towards both of its nodes
+			{"nodes", bson.A{
+				bson.D{{"$project", bson.D{
+					{"nodes", bson.A{"$node1Id", "$node2Id"}},
+					{"weightedP95", 1},
+					{"count", 1},
+				}}},
+				bson.D{{"$unwind", "$nodes"}},
+				weightedAverage("$nodes"),
+			}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	type weightedP95 struct {
+		ID          string  `bson:"_id"`
+		WeightedP95 float64 `bson:"weightedP95"`
+		Count       float64 `bson:"count"`
+	}
+	var facet struct {
+		Overall      []weightedP95 `bson:"overall"`
+		MessageTypes []weightedP95 `bson:"messageTypes"`
+		Nodes        []weightedP95 `bson:"nodes"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&facet); err != nil {
+			return nil, err
 		}
 	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
 
-	// Calculate weighted averages and find highest values
 	response := &types.NetworkLatencyOverviewResponse{
 		MessageTypeLatency:      make(map[string]float64),
 		NodeLatencyContribution: make(map[string]float64),
 	}
 
 	// Overall weighted average P95 latency
-	if overallCount > 0 {
-		response.OverallWeightedAvgP95LatencyMs = overallWeightedP95 / float64(overallCount)
+	if len(facet.Overall) > 0 && facet.Overall[0].Count > 0 {
+		response.OverallWeightedAvgP95LatencyMs = facet.Overall[0].WeightedP95 / facet.Overall[0].Count
 	}
 
-	// Message type latency and find highest
-	var highestMsgType string
-	var highestMsgLatency float64
-	for msgType, stat := range messageTypeStats {
-		if stat.totalCount > 0 {
-			avgLatency := stat.totalWeightedP95 / float64(stat.totalCount)
-			response.MessageTypeLatency[msgType] = avgLatency
-			if avgLatency > highestMsgLatency {
-				highestMsgLatency = avgLatency
-				highestMsgType = msgType
+	// highest returns the key with the highest average, preferring the smallest key on ties
+	highest := func(averages map[string]float64) (string, float64) {
+		var highestKey string
+		var highestLatency float64
+		for key, latency := range averages {
+			if latency > highestLatency || (latency == highestLatency && latency > 0 && key < highestKey) {
+				highestKey, highestLatency = key, latency
 			}
 		}
+		return highestKey, highestLatency
+	}
+
+	// Message type latency and find highest
+	for _, stat := range facet.MessageTypes {
+		if stat.Count > 0 {
+			response.MessageTypeLatency[stat.ID] = stat.WeightedP95 / stat.Count
+		}
 	}
+	msgType, msgLatency := highest(response.MessageTypeLatency)
 	response.MessageTypeWithHighestAvgP95 = types.MessageTypeLatencyInfo{
-		MessageType: highestMsgType,
-		LatencyMs:   highestMsgLatency,
+		MessageType: msgType,
+		LatencyMs:   msgLatency,
 	}
 
 	// Node latency contribution and find highest
-	var highestNodeId string
-	var highestNodeLatency float64
-	for nodeId, stat := range nodeStats {
-		if stat.totalCount > 0 {
-			avgLatency := stat.totalWeightedP95 / float64(stat.totalCount)
-			response.NodeLatencyContribution[nodeId] = avgLatency
-			if avgLatency > highestNodeLatency {
-				highestNodeLatency = avgLatency
-				highestNodeId = nodeId
-			}
+	for _, stat := range facet.Nodes {
+		if stat.Count > 0 {
+			response.NodeLatencyContribution[stat.ID] = stat.WeightedP95 / stat.Count
 		}
 	}
+	nodeID, nodeLatency := highest(response.NodeLatencyContribution)
 	response.NodeWithHighestAvgP95 = types.NodeLatencyInfo{
-		NodeId:    highestNodeId,
-		LatencyMs: highestNodeLatency,
+		NodeId:    nodeID,
+		LatencyMs: nodeLatency,
 	}
 
 	return response, nil
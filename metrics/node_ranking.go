@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/vote"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+	"sort"
+	"time"
+)
+
+// NodeRankingWeights weighs the components of a node's ranking score
+type NodeRankingWeights struct {
+	Outbound float64 // Average outbound p95 vote latency
+	Inbound  float64 // Average inbound p95 vote latency
+	Timeouts float64 // Scheduled timeouts
+	Loss     float64 // Loss rate of the votes the node sent
+}
+
+// ComputeNodeRanking ranks the nodes worst-first by a weighted score of their
+// average outbound and inbound p95 vote latency, timeout count and vote loss
+// rate as sender. Each component is divided by its maximum over all nodes
+// before weighing, so the score lies between 0 and the sum of the weights.
+func ComputeNodeRanking(
+	ctx context.Context, eventsColl, voteLatenciesColl *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, weights NodeRankingWeights,
+) ([]types.NodeRanking, error) {
+	var pairs []types.PairLatency
+	var losses []nodeVoteLoss
+	var timeouts map[string]int64
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		pairs, err = ComputePairwiseLatencyPercentiles(ctx, voteLatenciesColl, from, to, heights, PairLatencyFilter{}, false, nil)
+		return err
+	})
+	g.Go(func() (err error) {
+		losses, err = computeNodeVoteLoss(ctx, voteLatenciesColl, from, to, heights)
+		return err
+	})
+	g.Go(func() (err error) {
+		timeouts, err = countNodeTimeouts(ctx, eventsColl, from, to, heights)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	rankings := map[string]*types.NodeRanking{}
+	rankingFor := func(nodeID string) *types.NodeRanking {
+		ranking, ok := rankings[nodeID]
+		if !ok {
+			ranking = &types.NodeRanking{NodeID: nodeID}
+			rankings[nodeID] = ranking
+		}
+		return ranking
+	}
+
+	outboundPairs := map[string]int{}
+	inboundPairs := map[string]int{}
+	for _, pair := range pairs {
+		rankingFor(pair.Sender).OutboundP95Ms += float64(pair.P95Ms)
+		outboundPairs[pair.Sender]++
+		rankingFor(pair.Receiver).InboundP95Ms += float64(pair.P95Ms)
+		inboundPairs[pair.Receiver]++
+	}
+	for _, loss := range losses {
+		ranking := rankingFor(loss.NodeID)
+		ranking.VotesSent = loss.Sent
+		ranking.VotesLost = loss.Lost
+		if loss.Sent > 0 {
+			ranking.LossRate = float64(loss.Lost) / float64(loss.Sent)
+		}
+	}
+	for nodeID, count := range timeouts {
+		rankingFor(nodeID).TimeoutCount = count
+	}
+
+	var maxOutbound, maxInbound, maxTimeouts, maxLoss float64
+	for nodeID, ranking := range rankings {
+		if outboundPairs[nodeID] > 0 {
+			ranking.OutboundP95Ms /= float64(outboundPairs[nodeID])
+		}
+		if inboundPairs[nodeID] > 0 {
+			ranking.InboundP95Ms /= float64(inboundPairs[nodeID])
+		}
+		maxOutbound = max(maxOutbound, ranking.OutboundP95Ms)
+		maxInbound = max(maxInbound, ranking.InboundP95Ms)
+		maxTimeouts = max(maxTimeouts, float64(ranking.TimeoutCount))
+		maxLoss = max(maxLoss, ranking.LossRate)
+	}
+
+	// normalized scales a component to [0, 1] by the maximum over all nodes
+	normalized := func(value, maximum float64) float64 {
+		if maximum == 0 {
+			return 0
+		}
+		return value / maximum
+	}
+
+	result := make([]types.NodeRanking, 0, len(rankings))
+	for _, ranking := range rankings {
+		ranking.Score = weights.Outbound*normalized(ranking.OutboundP95Ms, maxOutbound) +
+			weights.Inbound*normalized(ranking.InboundP95Ms, maxInbound) +
+			weights.Timeouts*normalized(float64(ranking.TimeoutCount), maxTimeouts) +
+			weights.Loss*normalized(ranking.LossRate, maxLoss)
+		result = append(result, *ranking)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].NodeID < result[j].NodeID
+	})
+	for i := range result {
+		result[i].Rank = i + 1
+	}
+	return result, nil
+}
+
+// nodeVoteLoss counts the votes a node sent and how many of them never reached their recipient
+type nodeVoteLoss struct {
+	NodeID string `bson:"_id"`
+	Sent   int64  `bson:"sent"`
+	Lost   int64  `bson:"lost"`
+}
+
+// computeNodeVoteLoss counts the vote latencies sent within the window per
+// sender; those still in the sent status were never received
+func computeNodeVoteLoss(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) ([]nodeVoteLoss, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "vote.height", heights)}},
+		{{"$group", bson.D{
+			{"_id", "$senderPeerId"},
+			{"sent", bson.D{{"$sum", 1}}},
+			{"lost", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$status", string(vote.VoteMsgStatusSent)}}}, 1, 0,
+			}}}}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var losses []nodeVoteLoss
+	if err := cur.All(ctx, &losses); err != nil {
+		return nil, err
+	}
+	return losses, nil
+}
+
+// countNodeTimeouts counts the scheduledTimeout events of each node within the window
+func countNodeTimeouts(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"type", "scheduledTimeout"},
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "height", heights)}},
+		{{"$group", bson.D{
+			{"_id", "$nodeId"},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		NodeID string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rawResults))
+	for _, doc := range rawResults {
+		counts[doc.NodeID] = doc.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/vote"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PartitionOptions tunes network partition detection
+type PartitionOptions struct {
+	Window           time.Duration // Length of the time windows pairs are evaluated in
+	Threshold        float64       // Delivery rate below which a pair is broken
+	MaxLatency       time.Duration // Mean latency above which a pair is broken; 0 disables it
+	MinDuration      time.Duration // Shortest partition reported
+	MinAffectedPairs int           // Fewest broken pairs for a window to count as partitioned
+}
+
+// windowPairDelivery counts the votes sent from a sender to a receiver in a time window
+type windowPairDelivery struct {
+	ID struct {
+		Start    time.Time `bson:"start"`
+		Sender   string    `bson:"sender"`
+		Receiver string    `bson:"receiver"`
+	} `bson:"_id"`
+	Sent          int64    `bson:"sent"`
+	Delivered     int64    `bson:"delivered"`
+	MeanLatencyNs *float64 `bson:"meanLatency"` // Of the delivered votes, null when none were
+	MinHeight     uint64   `bson:"minHeight"`
+	MaxHeight     uint64   `bson:"maxHeight"`
+}
+
+// partitionWindow is a time window whose nodes split into groups that cannot reach each other
+type partitionWindow struct {
+	Start         time.Time
+	Groups        [][]string
+	AffectedPairs int
+	MinHeight     uint64
+	MaxHeight     uint64
+}
+
+// DetectNetworkPartitions infers network partitions from the vote deliveries
+// of each time window. In every window the node pairs whose delivery rate, in
+// either direction, is below the threshold (or whose mean latency exceeds
+// MaxLatency) are broken; the nodes then split into groups connected by healthy
+// pairs. A window is partitioned when there are at least two groups, no broken
+// pair within a group and at least MinAffectedPairs broken pairs. Consecutive
+// windows with the same groups are merged into one partition, and partitions
+// shorter than MinDuration are dropped.
+func DetectNetworkPartitions(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, opts PartitionOptions,
+) ([]types.NetworkPartition, error) {
+	deliveries, err := computeWindowPairDeliveries(ctx, coll, from, to, heights, opts.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	byWindow := map[time.Time][]windowPairDelivery{}
+	var starts []time.Time
+	for _, delivery := range deliveries {
+		start := delivery.ID.Start
+		if _, ok := byWindow[start]; !ok {
+			starts = append(starts, start)
+		}
+		byWindow[start] = append(byWindow[start], delivery)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	partitions := []types.NetworkPartition{}
+	var current *types.NetworkPartition
+	var currentKey string
+	flush := func() {
+		if current != nil && current.End.Sub(current.Start) >= opts.MinDuration {
+			partitions = append(partitions, *current)
+		}
+		current = nil
+	}
+
+	for _, start := range starts {
+		window, ok := partitionOf(start, byWindow[start], opts)
+		if !ok {
+			flush()
+			continue
+		}
+		key := groupsKey(window.Groups)
+		end := start.Add(opts.Window)
+		if current != nil && key == currentKey && !start.After(current.End) {
+			current.End = end
+			current.Windows++
+			current.AffectedPairs = max(current.AffectedPairs, window.AffectedPairs)
+			current.HeightFrom = min(current.HeightFrom, window.MinHeight)
+			current.HeightTo = max(current.HeightTo, window.MaxHeight)
+			continue
+		}
+		flush()
+		current = &types.NetworkPartition{
+			Start:         start,
+			End:           end,
+			Groups:        window.Groups,
+			AffectedPairs: window.AffectedPairs,
+			Windows:       1,
+			HeightFrom:    window.MinHeight,
+			HeightTo:      window.MaxHeight,
+		}
+		currentKey = key
+	}
+	flush()
+	return partitions, nil
+}
+
+// partitionOf splits the nodes of a window into groups connected by healthy
+// pairs and reports whether the window is partitioned
+func partitionOf(start time.Time, deliveries []windowPairDelivery, opts PartitionOptions) (partitionWindow, bool) {
+	type nodePair [2]string
+	pairOf := func(a, b string) nodePair {
+		if a > b {
+			a, b = b, a
+		}
+		return nodePair{a, b}
+	}
+
+	// A pair is broken when either direction is
+	broken := map[nodePair]bool{}
+	heightsOf := map[nodePair][2]uint64{}
+	for _, delivery := range deliveries {
+		if delivery.ID.Sender == delivery.ID.Receiver || delivery.Sent == 0 {
+			continue
+		}
+		pair := pairOf(delivery.ID.Sender, delivery.ID.Receiver)
+		rate := float64(delivery.Delivered) / float64(delivery.Sent)
+		slow := opts.MaxLatency > 0 && delivery.MeanLatencyNs != nil && *delivery.MeanLatencyNs > float64(opts.MaxLatency)
+		broken[pair] = broken[pair] || rate < opts.Threshold || slow
+
+		bounds, ok := heightsOf[pair]
+		if !ok {
+			bounds = [2]uint64{delivery.MinHeight, delivery.MaxHeight}
+		}
+		heightsOf[pair] = [2]uint64{min(bounds[0], delivery.MinHeight), max(bounds[1], delivery.MaxHeight)}
+	}
+
+	// Union-find over the healthy pairs
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(node string) string {
+		if parent[node] != node {
+			parent[node] = find(parent[node])
+		}
+		return parent[node]
+	}
+	for pair, isBroken := range broken {
+		for _, node := range pair {
+			if _, ok := parent[node]; !ok {
+				parent[node] = node
+			}
+		}
+		if !isBroken {
+			parent[find(pair[0])] = find(pair[1])
+		}
+	}
+
+	members := map[string][]string{}
+	for node := range parent {
+		root := find(node)
+		members[root] = append(members[root], node)
+	}
+	if len(members) < 2 {
+		return partitionWindow{}, false
+	}
+
+	window := partitionWindow{Start: start}
+	first := true
+	for pair, isBroken := range broken {
+		if !isBroken {
+			continue
+		}
+		// Groups must stay healthy within themselves
+		if find(pair[0]) == find(pair[1]) {
+			return partitionWindow{}, false
+		}
+		window.AffectedPairs++
+		bounds := heightsOf[pair]
+		if first {
+			window.MinHeight, window.MaxHeight = bounds[0], bounds[1]
+			first = false
+		}
+		window.MinHeight = min(window.MinHeight, bounds[0])
+		window.MaxHeight = max(window.MaxHeight, bounds[1])
+	}
+	if window.AffectedPairs < max(opts.MinAffectedPairs, 1) {
+		return partitionWindow{}, false
+	}
+
+	for _, group := range members {
+		sort.Strings(group)
+		window.Groups = append(window.Groups, group)
+	}
+	sort.Slice(window.Groups, func(i, j int) bool { return window.Groups[i][0] < window.Groups[j][0] })
+	return window, true
+}
+
+// groupsKey identifies a set of sorted groups
+func groupsKey(groups [][]string) string {
+	parts := make([]string, len(groups))
+	for i, group := range groups {
+		parts[i] = strings.Join(group, ",")
+	}
+	return strings.Join(parts, "|")
+}
+
+// computeWindowPairDeliveries counts the sent and delivered votes of every
+// sender→receiver pair per time window, by the time they were sent
+func computeWindowPairDeliveries(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, window time.Duration,
+) ([]windowPairDelivery, error) {
+	delivered := bson.D{{"$ne", bson.A{"$status", string(vote.VoteMsgStatusSent)}}}
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "vote.height", heights)}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"start", bson.D{{"$dateTrunc", bson.D{
+					{"date", "$sentTime"},
+					{"unit", "millisecond"},
+					{"binSize", window.Milliseconds()},
+				}}}},
+				{"sender", "$senderPeerId"},
+				{"receiver", "$recipientPeerId"},
+			}},
+			{"sent", bson.D{{"$sum", 1}}},
+			{"delivered", bson.D{{"$sum", bson.D{{"$cond", bson.A{delivered, 1, 0}}}}}},
+			// $avg ignores the nulls of undelivered votes
+			{"meanLatency", bson.D{{"$avg", bson.D{{"$cond", bson.A{delivered, "$latency", nil}}}}}},
+			{"minHeight", bson.D{{"$min", "$vote.height"}}},
+			{"maxHeight", bson.D{{"$max", "$vote.height"}}},
+		}}},
+	}
+
+	aggOpts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, aggOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var deliveries []windowPairDelivery
+	if err := cur.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
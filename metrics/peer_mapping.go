@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// PeerMappingCollection is the collection of the simulation database holding
+// the peer mapping derived after processing
+const PeerMappingCollection = "peer_mapping"
+
+// peerMatchWindow bounds the clock difference between a sendVote and a
+// receiveVote counted as the same hop of a vote
+const peerMatchWindow = 30 * time.Second
+
+// peerVoteKey identifies a vote within a height
+type peerVoteKey struct {
+	Round          uint64
+	ValidatorIndex uint64
+	Type           string
+}
+
+// sendEdge is a node sending votes to a peer ID
+type sendEdge struct {
+	NodeID string
+	PeerID string
+}
+
+// receiveEdge is a node receiving votes from a peer ID
+type receiveEdge struct {
+	PeerID string
+	NodeID string
+}
+
+// edgeMatch pairs a send edge with a receive edge carrying the same votes
+type edgeMatch struct {
+	Send    sendEdge
+	Receive receiveEdge
+}
+
+// DerivePeerMapping derives which node each peer ID of the vote events
+// belongs to.
+//
+// A sendVote from node A to peer P and a receiveVote on node B from peer Q
+// are the same hop exactly when P is B's peer ID and Q is A's. Over all votes,
+// the receive edge (Q, B) of that hop occurs with nearly every vote sent along
+// (A, P), while unrelated receive edges only occur with some of them. Each
+// send edge is therefore paired with the receive edge that occurred with most
+// of its votes, within peerMatchWindow, and the pair is evidence for P → B and
+// Q → A weighted by how often they occurred together. Ties split the evidence.
+//
+// The confidence of a mapping is the evidence for its node over all evidence
+// for the peer ID. When several peer IDs map to the same node, their
+// confidences are further scaled by their share of that node's evidence.
+// Conflicting candidates are kept as alternatives.
+func DerivePeerMapping(ctx context.Context, coll *mongo.Collection) ([]types.PeerMapping, error) {
+	from, to := time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	sends, err := findVoteEvents(ctx, coll, "sendVote", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer sends.cur.Close(ctx)
+
+	receives, err := findVoteEvents(ctx, coll, "receiveVote", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer receives.cur.Close(ctx)
+
+	sendCount := map[sendEdge]int{}
+	matchCount := map[edgeMatch]int{}
+	sentAt := map[peerVoteKey]map[sendEdge]time.Time{}
+	receivedAt := map[peerVoteKey]map[receiveEdge]time.Time{}
+	for sends.current != nil {
+		height := sends.current.Vote.Height
+
+		clear(sentAt)
+		for sends.current != nil && sends.current.Vote.Height == height {
+			send := sends.current
+			key := peerVoteKey{send.Vote.Round, send.Vote.ValidatorIndex, send.Vote.Type}
+			edge := sendEdge{NodeID: send.NodeID, PeerID: send.RecipientPeerID}
+			if sentAt[key] == nil {
+				sentAt[key] = map[sendEdge]time.Time{}
+			}
+			if first, ok := sentAt[key][edge]; !ok || send.Timestamp.Before(first) {
+				sentAt[key][edge] = send.Timestamp
+			}
+			if err := sends.advance(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		// Skip receives of heights without sends
+		for receives.current != nil && receives.current.Vote.Height < height {
+			if err := receives.advance(ctx); err != nil {
+				return nil, err
+			}
+		}
+		clear(receivedAt)
+		for receives.current != nil && receives.current.Vote.Height == height {
+			receive := receives.current
+			key := peerVoteKey{receive.Vote.Round, receive.Vote.ValidatorIndex, receive.Vote.Type}
+			edge := receiveEdge{PeerID: receive.SourcePeerID, NodeID: receive.NodeID}
+			if receivedAt[key] == nil {
+				receivedAt[key] = map[receiveEdge]time.Time{}
+			}
+			if first, ok := receivedAt[key][edge]; !ok || receive.Timestamp.Before(first) {
+				receivedAt[key][edge] = receive.Timestamp
+			}
+			if err := receives.advance(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		for key, sent := range sentAt {
+			for send, sentTime := range sent {
+				sendCount[send]++
+				for receive, receivedTime := range receivedAt[key] {
+					if receive.NodeID == send.NodeID {
+						continue
+					}
+					if gap := receivedTime.Sub(sentTime); gap < -peerMatchWindow || gap > peerMatchWindow {
+						continue
+					}
+					matchCount[edgeMatch{Send: send, Receive: receive}]++
+				}
+			}
+		}
+	}
+
+	return resolvePeerEvidence(sendCount, matchCount), nil
+}
+
+// resolvePeerEvidence turns the co-occurrence counts of send and receive edges
+// into peer mappings, as described in DerivePeerMapping
+func resolvePeerEvidence(sendCount map[sendEdge]int, matchCount map[edgeMatch]int) []types.PeerMapping {
+	// Receive edges occurring most often with each send edge
+	best := map[sendEdge][]receiveEdge{}
+	bestCount := map[sendEdge]int{}
+	for match, count := range matchCount {
+		switch {
+		case count > bestCount[match.Send]:
+			bestCount[match.Send] = count
+			best[match.Send] = []receiveEdge{match.Receive}
+		case count == bestCount[match.Send]:
+			best[match.Send] = append(best[match.Send], match.Receive)
+		}
+	}
+
+	evidence := map[string]map[string]float64{} // Peer ID → node ID → weight
+	possible := map[string]float64{}            // Peer ID → weight if all evidence agreed fully
+	observations := map[string]int{}
+	add := func(peerID, nodeID string, weight, share float64) {
+		if evidence[peerID] == nil {
+			evidence[peerID] = map[string]float64{}
+		}
+		evidence[peerID][nodeID] += weight
+		possible[peerID] += share
+		observations[peerID]++
+	}
+	for send, receives := range best {
+		share := 1 / float64(len(receives))
+		weight := float64(bestCount[send]) / float64(sendCount[send]) * share
+		for _, receive := range receives {
+			add(send.PeerID, receive.NodeID, weight, share)
+			add(receive.PeerID, send.NodeID, weight, share)
+		}
+	}
+
+	mappings := make([]types.PeerMapping, 0, len(evidence))
+	support := map[string]float64{}
+	nodeSupport := map[string]float64{}
+	for peerID, nodes := range evidence {
+		total := 0.0
+		for _, weight := range nodes {
+			total += weight
+		}
+		candidates := make([]types.PeerNodeCandidate, 0, len(nodes))
+		for nodeID, weight := range nodes {
+			candidates = append(candidates, types.PeerNodeCandidate{NodeID: nodeID, Share: weight / total})
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].Share != candidates[j].Share {
+				return candidates[i].Share > candidates[j].Share
+			}
+			return candidates[i].NodeID < candidates[j].NodeID
+		})
+
+		nodeID := candidates[0].NodeID
+		support[peerID] = nodes[nodeID]
+		nodeSupport[nodeID] += nodes[nodeID]
+		mapping := types.PeerMapping{
+			PeerID:       peerID,
+			NodeID:       nodeID,
+			Confidence:   nodes[nodeID] / possible[peerID],
+			Observations: observations[peerID],
+		}
+		if len(candidates) > 1 {
+			mapping.Alternatives = candidates[1:]
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	for i, mapping := range mappings {
+		mappings[i].Confidence *= support[mapping.PeerID] / nodeSupport[mapping.NodeID]
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].PeerID < mappings[j].PeerID })
+	return mappings
+}
+
+// StorePeerMapping replaces the stored peer mapping of a simulation database
+func StorePeerMapping(ctx context.Context, database *mongo.Database, mappings []types.PeerMapping) error {
+	coll := database.Collection(PeerMappingCollection)
+	if _, err := coll.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
+		return nil
+	}
+	documents := make([]any, len(mappings))
+	for i, mapping := range mappings {
+		documents[i] = mapping
+	}
+	_, err := coll.InsertMany(ctx, documents)
+	return err
+}
+
+// LoadPeerMapping returns the stored peer mapping of a simulation database,
+// ordered by peer ID. It is empty when none was stored.
+func LoadPeerMapping(ctx context.Context, database *mongo.Database) ([]types.PeerMapping, error) {
+	cursor, err := database.Collection(PeerMappingCollection).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	mappings := []types.PeerMapping{}
+	if err := cursor.All(ctx, &mappings); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
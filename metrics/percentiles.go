@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"strings"
+)
+
+// Server error codes of an unknown accumulator or expression operator
+const (
+	errCodeUnknownGroupOperator    = 15952
+	errCodeInvalidPipelineOperator = 168
+)
+
+// aggregatePercentiles runs the pipeline built by build with $percentile
+// accumulators. Servers without $percentile (MongoDB before 7.0) reject it; the
+// pipeline is then rebuilt with native false, where percentileRanks and
+// rankedPercentiles keep only the values around the rank of each quantile.
+func aggregatePercentiles(
+	ctx context.Context, coll *mongo.Collection, build func(native bool) mongo.Pipeline,
+) (*mongo.Cursor, error) {
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, build(true), opts)
+	if err != nil && isPercentileUnsupported(err) {
+		return coll.Aggregate(ctx, build(false), opts)
+	}
+	return cur, err
+}
+
+// overallPercentiles returns the quantiles of input over all documents matching
+// match through aggregatePercentiles, or nil when none has a numeric input
+func overallPercentiles(
+	ctx context.Context, coll *mongo.Collection, match bson.D, input any, percentiles []float64,
+) ([]float64, error) {
+	build := func(native bool) mongo.Pipeline {
+		pipeline := append(mongo.Pipeline{{{"$match", match}}}, percentileRanks(native, nil, input)...)
+		return append(pipeline, bson.D{{"$group", bson.D{
+			{"_id", nil},
+			{"percentiles", rankedPercentiles(native, input, percentiles)},
+		}}})
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result struct {
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	quantiles := result.Percentiles.quantiles(percentiles)
+	if len(quantiles) < len(percentiles) {
+		return nil, nil
+	}
+	return quantiles, nil
+}
+
+// Fields set by percentileRanks
+const (
+	percentileInputField = "_percentileInput"
+	percentileRankField  = "_percentileRank"
+	percentileCountField = "_percentileCount"
+)
+
+// percentileRanks returns the stages preceding the $group of a pipeline of
+// aggregatePercentiles: none when native, otherwise the rank of input within
+// its group, which must be the _id of the $group, and the size of the group.
+func percentileRanks(native bool, group any, input any) mongo.Pipeline {
+	if native {
+		return nil
+	}
+	return mongo.Pipeline{
+		{{"$set", bson.D{{percentileInputField, input}}}},
+		{{"$setWindowFields", bson.D{
+			// Inputs that are not numbers, which $percentile ignores, are ranked apart
+			{"partitionBy", bson.D{
+				{"group", group},
+				{"numeric", bson.D{{"$isNumber", "$" + percentileInputField}}},
+			}},
+			{"sortBy", bson.D{{percentileInputField, 1}}},
+			{"output", bson.D{
+				{percentileRankField, bson.D{{"$documentNumber", bson.D{}}}},
+				{percentileCountField, bson.D{
+					{"$count", bson.D{}},
+					{"window", bson.D{{"documents", bson.A{"unbounded", "unbounded"}}}},
+				}},
+			}},
+		}}},
+	}
+}
+
+// rankedPercentiles is the accumulator of the quantiles of input for
+// aggregatePercentiles, decoded with accumulatedPercentiles: $percentile when
+// native, otherwise the two values ranked around each quantile by
+// percentileRanks, so a group never holds more than two values per quantile.
+func rankedPercentiles(native bool, input any, percentiles []float64) bson.D {
+	if native {
+		return percentileAccumulator(input, percentiles)
+	}
+	value := "$" + percentileInputField
+	rank := bson.D{{"$subtract", bson.A{"$" + percentileRankField, 1}}}
+	near := bson.A{}
+	for _, percentile := range percentiles {
+		lower := bson.D{{"$floor", bson.D{{"$multiply", bson.A{
+			percentile, bson.D{{"$subtract", bson.A{"$" + percentileCountField, 1}}},
+		}}}}}
+		near = append(near, bson.D{{"$in", bson.A{rank, bson.A{lower, bson.D{{"$add", bson.A{lower, 1}}}}}}})
+	}
+	return bson.D{{"$push", bson.D{{"$cond", bson.A{
+		bson.D{{"$and", bson.A{bson.D{{"$isNumber", value}}, bson.D{{"$or", near}}}}},
+		bson.D{{"rank", rank}, {"value", value}, {"count", "$" + percentileCountField}},
+		"$$REMOVE",
+	}}}}}
+}
+
+// percentileSample is a value kept by rankedPercentiles without $percentile
+type percentileSample struct {
+	Rank  int64   `bson:"rank"` // 0-based, in ascending order of the values of the group
+	Value float64 `bson:"value"`
+	Count int64   `bson:"count"` // Number of values in the group
+}
+
+// accumulatedPercentiles decodes a field accumulated by rankedPercentiles
+type accumulatedPercentiles struct {
+	values  []float64          // Computed by $percentile
+	samples []percentileSample // Kept around the quantiles without $percentile
+}
+
+// UnmarshalBSONValue implements bson.ValueUnmarshaler
+func (a *accumulatedPercentiles) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null {
+		return nil
+	}
+	if t != bsontype.Array {
+		return fmt.Errorf("cannot decode %s into percentiles", t)
+	}
+	elements, err := bson.Raw(data).Values()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		if element.Type == bsontype.EmbeddedDocument {
+			var sample percentileSample
+			if err := element.Unmarshal(&sample); err != nil {
+				return err
+			}
+			a.samples = append(a.samples, sample)
+			continue
+		}
+		var value float64
+		if err := element.Unmarshal(&value); err != nil {
+			return err
+		}
+		a.values = append(a.values, value)
+	}
+	return nil
+}
+
+// quantiles returns the accumulated quantiles in the order of percentiles, or
+// nil when the group had no values. Without $percentile they are interpolated
+// exactly between the samples like quantileOf, rather than approximated.
+func (a accumulatedPercentiles) quantiles(percentiles []float64) []float64 {
+	if a.values != nil {
+		return a.values
+	}
+	if len(a.samples) == 0 {
+		return nil
+	}
+	byRank := make(map[int64]float64, len(a.samples))
+	for _, sample := range a.samples {
+		byRank[sample.Rank] = sample.Value
+	}
+	count := a.samples[0].Count
+	quantiles := make([]float64, len(percentiles))
+	for i, percentile := range percentiles {
+		rank := percentile * float64(count-1)
+		lower := math.Floor(rank)
+		lowerValue := byRank[int64(lower)]
+		upperValue, ok := byRank[int64(lower)+1]
+		if !ok {
+			upperValue = lowerValue
+		}
+		quantiles[i] = lowerValue + (upperValue-lowerValue)*(rank-lower)
+	}
+	return quantiles
+}
+
+// isPercentileUnsupported reports whether err is the server rejecting $percentile
+func isPercentileUnsupported(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	return (serverErr.HasErrorCode(errCodeUnknownGroupOperator) || serverErr.HasErrorCode(errCodeInvalidPipelineOperator)) &&
+		strings.Contains(err.Error(), "$percentile")
+}
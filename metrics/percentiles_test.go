@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// decodePercentiles round-trips value through BSON like a field of an aggregation result
+func decodePercentiles(t *testing.T, value any) accumulatedPercentiles {
+	t.Helper()
+	data, err := bson.Marshal(bson.D{{"percentiles", value}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("decode %v: %v", value, err)
+	}
+	return doc.Percentiles
+}
+
+// rankSamples keeps the values of a group rankedPercentiles would push
+func rankSamples(values []float64, percentiles []float64) bson.A {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	count := int64(len(sorted))
+	samples := bson.A{}
+	for rank, value := range sorted {
+		for _, percentile := range percentiles {
+			lower := int64(math.Floor(percentile * float64(count-1)))
+			if int64(rank) == lower || int64(rank) == lower+1 {
+				samples = append(samples, bson.D{{"rank", int64(rank)}, {"value", value}, {"count", count}})
+				break
+			}
+		}
+	}
+	return samples
+}
+
+func TestAccumulatedPercentiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       any
+		percentiles []float64
+		want        []float64
+	}{
+		{name: "$percentile results", value: bson.A{1.5, 2.5}, percentiles: []float64{0.5, 0.95}, want: []float64{1.5, 2.5}},
+		{name: "integer $percentile results", value: bson.A{int64(3), int32(4)}, percentiles: []float64{0.5, 0.95}, want: []float64{3, 4}},
+		{name: "null", value: nil, percentiles: []float64{0.5}},
+		{name: "no samples", value: bson.A{}, percentiles: []float64{0.5}},
+		{
+			name:        "samples are interpolated",
+			value:       rankSamples([]float64{40, 10, 30, 20}, []float64{0.5, 0.9}),
+			percentiles: []float64{0.5, 0.9},
+			want:        []float64{25, 37},
+		},
+		{
+			name:        "the extreme quantiles are the bounds",
+			value:       rankSamples([]float64{3, 1, 2}, []float64{0, 1}),
+			percentiles: []float64{0, 1},
+			want:        []float64{1, 3},
+		},
+		{
+			name:        "a single value",
+			value:       rankSamples([]float64{5}, []float64{0.5, 0.99}),
+			percentiles: []float64{0.5, 0.99},
+			want:        []float64{5, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodePercentiles(t, tt.value).quantiles(tt.percentiles); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("quantiles(%v) = %v, want %v", tt.percentiles, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAccumulatedPercentilesMatchQuantileOf checks that the samples kept per
+// group are enough to interpolate the quantiles of all values exactly
+func TestAccumulatedPercentilesMatchQuantileOf(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	percentiles := []float64{0, 0.01, 0.5, 0.9, 0.95, 0.99, 0.999, 1}
+	for _, count := range []int{1, 2, 3, 10, 101, 1000} {
+		values := make([]float64, count)
+		for i := range values {
+			values[i] = float64(random.Intn(500))
+		}
+		sorted := slices.Clone(values)
+		slices.Sort(sorted)
+
+		samples := rankSamples(values, percentiles)
+		if len(samples) > 2*len(percentiles) {
+			t.Errorf("%d values: %d samples kept, want at most %d", count, len(samples), 2*len(percentiles))
+		}
+		got := decodePercentiles(t, samples).quantiles(percentiles)
+		for i, percentile := range percentiles {
+			if want := quantileOf(sorted, percentile); math.Abs(got[i]-want) > 1e-9 {
+				t.Errorf("%d values: quantile %v = %v, want %v", count, percentile, got[i], want)
+			}
+		}
+	}
+}
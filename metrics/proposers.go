@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// proposalKey identifies the proposal of a round
+type proposalKey struct {
+	Height int64
+	Round  int64
+}
+
+// proposal is a single block proposal, as seen by the proposer and its peers
+type proposal struct {
+	NodeID      string
+	Address     string
+	ProposeTime *time.Time // proposeStep on the proposer, when its logs are available
+}
+
+// ComputeProposerStats reports, per proposer node, the proposals it made, how
+// fast its proposal blocks reached the other nodes and which of its proposals
+// failed because the round advanced.
+//
+// The proposer of a round is the node whose proposeStep has isOurTurn set. When
+// that node's events are missing, the proposer address logged by the other
+// nodes' proposeStep and receivedProposal events is mapped back to a node
+// through the validatorAddress of its events, or reported by address only.
+func ComputeProposerStats(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) ([]types.ProposerStats, error) {
+	timeRange := bson.D{{"$gte", from}, {"$lte", to}}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{
+			{"timestamp", timeRange},
+			{"$or", bson.A{
+				withHeightRange(bson.D{{"type", bson.D{{"$in", bson.A{
+					"enteringNewRound", "proposeStep", "receivedCompleteProposalBlock",
+				}}}}}, "height", heights),
+				withHeightRange(bson.D{{"type", "receivedProposal"}}, "proposal.height", heights),
+			}},
+		}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"type", "$type"},
+				{"nodeId", "$nodeId"},
+				{"validatorAddress", "$validatorAddress"},
+				{"height", bson.D{{"$ifNull", bson.A{"$height", "$proposal.height"}}}},
+				{"round", bson.D{{"$ifNull", bson.A{"$round", "$proposal.round"}}}},
+				{"proposer", "$proposer"},
+				{"isOurTurn", "$isOurTurn"},
+			}},
+			{"first", bson.D{{"$min", "$timestamp"}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			Type             string `bson:"type"`
+			NodeID           string `bson:"nodeId"`
+			ValidatorAddress string `bson:"validatorAddress"`
+			Height           int64  `bson:"height"`
+			Round            int64  `bson:"round"`
+			Proposer         string `bson:"proposer"`
+			IsOurTurn        bool   `bson:"isOurTurn"`
+		} `bson:"_id"`
+		First time.Time `bson:"first"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	// Collect proposals, the highest round of each height and the address of each node
+	proposals := map[proposalKey]*proposal{}
+	maxRound := map[int64]int64{}
+	nodeByAddress := map[string]string{}
+	type reception struct {
+		Height int64
+		NodeID string
+		Time   time.Time
+	}
+	var receptions []reception
+
+	proposalFor := func(height, round int64) *proposal {
+		key := proposalKey{Height: height, Round: round}
+		p, ok := proposals[key]
+		if !ok {
+			p = &proposal{}
+			proposals[key] = p
+		}
+		return p
+	}
+
+	for _, doc := range rawResults {
+		if doc.ID.ValidatorAddress != "" {
+			nodeByAddress[doc.ID.ValidatorAddress] = doc.ID.NodeID
+		}
+
+		switch doc.ID.Type {
+		case "enteringNewRound":
+			if doc.ID.Round > maxRound[doc.ID.Height] {
+				maxRound[doc.ID.Height] = doc.ID.Round
+			}
+		case "proposeStep":
+			if doc.ID.Round > maxRound[doc.ID.Height] {
+				maxRound[doc.ID.Height] = doc.ID.Round
+			}
+			p := proposalFor(doc.ID.Height, doc.ID.Round)
+			if doc.ID.IsOurTurn {
+				first := doc.First
+				p.NodeID = doc.ID.NodeID
+				p.ProposeTime = &first
+				if doc.ID.ValidatorAddress != "" {
+					p.Address = doc.ID.ValidatorAddress
+				}
+			}
+			if p.Address == "" {
+				p.Address = doc.ID.Proposer
+			}
+		case "receivedProposal":
+			if p := proposalFor(doc.ID.Height, doc.ID.Round); p.Address == "" {
+				p.Address = doc.ID.Proposer
+			}
+		case "receivedCompleteProposalBlock":
+			receptions = append(receptions, reception{Height: doc.ID.Height, NodeID: doc.ID.NodeID, Time: doc.First})
+		}
+	}
+
+	// Infer missing proposer nodes from their validator address
+	proposalsByHeight := map[int64][]*proposal{}
+	for key, p := range proposals {
+		if p.NodeID == "" && p.Address != "" {
+			p.NodeID = nodeByAddress[p.Address]
+		}
+		if p.NodeID == "" && p.Address == "" {
+			continue
+		}
+		proposalsByHeight[key.Height] = append(proposalsByHeight[key.Height], p)
+	}
+
+	statsByProposer := map[[2]string]*types.ProposerStats{}
+	statsFor := func(p *proposal) *types.ProposerStats {
+		// Proposers mapped to a node are keyed by node, the others by address
+		key := [2]string{p.NodeID, ""}
+		if p.NodeID == "" {
+			key[1] = p.Address
+		}
+		stats, ok := statsByProposer[key]
+		if !ok {
+			stats = &types.ProposerStats{NodeID: p.NodeID, FailedHeights: []int64{}}
+			statsByProposer[key] = stats
+		}
+		if stats.ValidatorAddress == "" {
+			stats.ValidatorAddress = p.Address
+		}
+		return stats
+	}
+
+	for key, p := range proposals {
+		if p.NodeID == "" && p.Address == "" {
+			continue
+		}
+		stats := statsFor(p)
+		stats.Proposals++
+		if key.Round < maxRound[key.Height] {
+			stats.FailedProposals++
+			stats.FailedHeights = append(stats.FailedHeights, key.Height)
+		}
+	}
+
+	// Attribute each reception to the latest proposal of its height proposed before it
+	propagationTotal := map[*types.ProposerStats]time.Duration{}
+	for _, r := range receptions {
+		var latest *proposal
+		for _, p := range proposalsByHeight[r.Height] {
+			if p.ProposeTime == nil || p.ProposeTime.After(r.Time) {
+				continue
+			}
+			if latest == nil || p.ProposeTime.After(*latest.ProposeTime) {
+				latest = p
+			}
+		}
+		if latest == nil || latest.NodeID == r.NodeID {
+			continue
+		}
+		stats := statsFor(latest)
+		propagationTotal[stats] += r.Time.Sub(*latest.ProposeTime)
+		stats.Samples++
+	}
+
+	result := make([]types.ProposerStats, 0, len(statsByProposer))
+	for _, stats := range statsByProposer {
+		if stats.Samples > 0 {
+			stats.AvgPropagationMs = types.DurationMs(propagationTotal[stats]) / float64(stats.Samples)
+		}
+		sort.Slice(stats.FailedHeights, func(i, j int) bool {
+			return stats.FailedHeights[i] < stats.FailedHeights[j]
+		})
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].NodeID != result[j].NodeID {
+			return result[i].NodeID < result[j].NodeID
+		}
+		return result[i].ValidatorAddress < result[j].ValidatorAddress
+	})
+	return result, nil
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// RoundDurationsResult contains a page of round durations and the total number of rounds
+type RoundDurationsResult struct {
+	Data  []types.RoundDuration
+	Total int
+}
+
+// RoundDurationSummaryResult contains a page of per-height summaries and the total number of heights
+type RoundDurationSummaryResult struct {
+	Data  []types.RoundDurationSummary
+	Total int
+}
+
+// roundDurationStages correlates the step events of each (nodeId, height, round).
+// A round starts at enteringNewRound and ends at enteringCommitStep, or at the
+// node's next enteringNewRound when it ended without commit. Rounds whose end
+// falls outside the time window are left out.
+func roundDurationStages(from, to time.Time, heights types.HeightRange) mongo.Pipeline {
+	// enteringNewRound carries height/round, enteringCommitStep currentHeight/currentRound
+	timeRange := bson.D{{"$gte", from}, {"$lte", to}}
+	newRoundMatch := withHeightRange(bson.D{{"type", "enteringNewRound"}, {"timestamp", timeRange}}, "height", heights)
+	commitMatch := withHeightRange(bson.D{{"type", "enteringCommitStep"}, {"timestamp", timeRange}}, "currentHeight", heights)
+
+	return mongo.Pipeline{
+		{{"$match", bson.D{{"$or", bson.A{newRoundMatch, commitMatch}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"nodeId", "$nodeId"},
+				{"height", bson.D{{"$ifNull", bson.A{"$height", "$currentHeight"}}}},
+				{"round", bson.D{{"$ifNull", bson.A{"$round", "$currentRound"}}}},
+			}},
+			// $min ignores the nulls of the other event type
+			{"startTime", bson.D{{"$min", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$type", "enteringNewRound"}}}, "$timestamp", nil,
+			}}}}}},
+			{"commitTime", bson.D{{"$min", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$type", "enteringCommitStep"}}}, "$timestamp", nil,
+			}}}}}},
+		}}},
+		{{"$match", bson.D{{"startTime", bson.D{{"$ne", nil}}}}}},
+		{{"$setWindowFields", bson.D{
+			{"partitionBy", "$_id.nodeId"},
+			{"sortBy", bson.D{{"_id.height", 1}, {"_id.round", 1}}},
+			{"output", bson.D{
+				{"nextStartTime", bson.D{{"$shift", bson.D{{"output", "$startTime"}, {"by", 1}}}}},
+			}},
+		}}},
+		{{"$project", bson.D{
+			{"_id", 0},
+			{"nodeId", "$_id.nodeId"},
+			{"height", "$_id.height"},
+			{"round", "$_id.round"},
+			{"startTime", 1},
+			{"endTime", bson.D{{"$ifNull", bson.A{"$commitTime", "$nextStartTime"}}}},
+			{"committed", bson.D{{"$ne", bson.A{"$commitTime", nil}}}},
+		}}},
+		{{"$match", bson.D{{"endTime", bson.D{{"$ne", nil}}}}}},
+		{{"$addFields", bson.D{
+			{"durationMs", bson.D{{"$toDouble", bson.D{{"$subtract", bson.A{"$endTime", "$startTime"}}}}}},
+		}}},
+	}
+}
+
+// pageFacet splits the sorted results into their total count and the requested page
+func pageFacet(page, perPage int) bson.D {
+	return bson.D{{"$facet", bson.D{
+		{"total", bson.A{bson.D{{"$count", "total"}}}},
+		{"data", bson.A{
+			bson.D{{"$skip", (page - 1) * perPage}},
+			bson.D{{"$limit", perPage}},
+		}},
+	}}}
+}
+
+// ComputeRoundDurations returns how long each node spent in each consensus round,
+// ordered by height, round and node
+func ComputeRoundDurations(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, page, perPage int,
+) (*RoundDurationsResult, error) {
+	pipeline := append(roundDurationStages(from, to, heights),
+		bson.D{{"$sort", bson.D{{"height", 1}, {"round", 1}, {"nodeId", 1}}}},
+		pageFacet(page, perPage),
+	)
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []types.RoundDuration `bson:"data"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &RoundDurationsResult{Data: facet.Data}
+	if result.Data == nil {
+		result.Data = []types.RoundDuration{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
+
+// roundDurationSummaryPercentiles are the quantiles of each RoundDurationSummary
+var roundDurationSummaryPercentiles = []float64{0.50, 0.95}
+
+// ComputeRoundDurationSummary returns p50/p95 round durations across all nodes per height
+func ComputeRoundDurationSummary(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, page, perPage int,
+) (*RoundDurationSummaryResult, error) {
+	build := func(native bool) mongo.Pipeline {
+		pipeline := append(roundDurationStages(from, to, heights), percentileRanks(native, "$height", "$durationMs")...)
+		return append(pipeline,
+			bson.D{{"$group", bson.D{
+				{"_id", "$height"},
+				{"rounds", bson.D{{"$sum", 1}}},
+				{"failedRounds", bson.D{{"$sum", bson.D{{"$cond", bson.A{"$committed", 0, 1}}}}}},
+				{"percentiles", rankedPercentiles(native, "$durationMs", roundDurationSummaryPercentiles)},
+			}}},
+			bson.D{{"$project", bson.D{
+				{"_id", 0},
+				{"height", "$_id"},
+				{"rounds", 1},
+				{"failedRounds", 1},
+				{"percentiles", 1},
+			}}},
+			bson.D{{"$sort", bson.D{{"height", 1}}}},
+			pageFacet(page, perPage),
+		)
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []struct {
+			types.RoundDurationSummary `bson:",inline"`
+			Percentiles                accumulatedPercentiles `bson:"percentiles"`
+		} `bson:"data"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &RoundDurationSummaryResult{Data: make([]types.RoundDurationSummary, 0, len(facet.Data))}
+	for _, doc := range facet.Data {
+		summary := doc.RoundDurationSummary
+		if quantiles := doc.Percentiles.quantiles(roundDurationSummaryPercentiles); quantiles != nil {
+			summary.P50Ms, summary.P95Ms = quantiles[0], quantiles[1]
+		}
+		result.Data = append(result.Data, summary)
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sort"
+	"time"
+)
+
+// stepOrder is the order of the consensus steps within a round
+var stepOrder = map[string]int{
+	"newRound":      0,
+	"propose":       1,
+	"prevote":       2,
+	"prevoteWait":   3,
+	"precommit":     4,
+	"precommitWait": 5,
+	"commit":        6,
+}
+
+// stepDurationPercentiles are the quantiles of each StepDuration
+var stepDurationPercentiles = []float64{0.50, 0.95}
+
+// ComputeStepDurations returns, per node and consensus step, the p50/p95 time
+// from entering the step to entering the node's next step of the same round.
+// Steps a round skipped are not counted, and the last step of a round has no
+// duration. Nodes limits the result to the given node IDs when not empty.
+func ComputeStepDurations(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, nodes []string,
+) ([]types.StepDuration, error) {
+	// enteringNewRound and proposeStep carry height/round, the other step events currentHeight/currentRound
+	match := bson.D{
+		{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		{"$or", bson.A{
+			withHeightRange(bson.D{{"type", bson.D{{"$in", bson.A{"enteringNewRound", "proposeStep"}}}}}, "height", heights),
+			withHeightRange(bson.D{{"type", bson.D{{"$in", bson.A{
+				"enteringPrevoteStep", "enteringPrevoteWaitStep", "enteringPrecommitStep",
+				"enteringPrecommitWaitStep", "enteringCommitStep",
+			}}}}}, "currentHeight", heights),
+		}},
+	}
+	if len(nodes) > 0 {
+		match = append(match, bson.E{"nodeId", bson.D{{"$in", nodes}}})
+	}
+
+	group := bson.D{{"nodeId", "$_id.nodeId"}, {"type", "$_id.type"}}
+	elapsed := bson.D{{"$subtract", bson.A{"$nextEntered", "$entered"}}}
+	build := func(native bool) mongo.Pipeline {
+		pipeline := mongo.Pipeline{
+			{{"$match", match}},
+			{{"$group", bson.D{
+				{"_id", bson.D{
+					{"nodeId", "$nodeId"},
+					{"height", bson.D{{"$ifNull", bson.A{"$height", "$currentHeight"}}}},
+					{"round", bson.D{{"$ifNull", bson.A{"$round", "$currentRound"}}}},
+					{"type", "$type"},
+				}},
+				{"entered", bson.D{{"$min", "$timestamp"}}},
+			}}},
+			{{"$setWindowFields", bson.D{
+				{"partitionBy", bson.D{{"nodeId", "$_id.nodeId"}, {"height", "$_id.height"}, {"round", "$_id.round"}}},
+				{"sortBy", bson.D{{"entered", 1}}},
+				{"output", bson.D{
+					{"nextEntered", bson.D{{"$shift", bson.D{{"output", "$entered"}, {"by", 1}}}}},
+				}},
+			}}},
+			{{"$match", bson.D{{"nextEntered", bson.D{{"$ne", nil}}}}}},
+		}
+		return append(append(pipeline, percentileRanks(native, group, elapsed)...),
+			bson.D{{"$group", bson.D{
+				{"_id", group},
+				{"samples", bson.D{{"$sum", 1}}},
+				{"percentiles", rankedPercentiles(native, elapsed, stepDurationPercentiles)},
+			}}},
+		)
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			NodeID string `bson:"nodeId"`
+			Type   string `bson:"type"`
+		} `bson:"_id"`
+		Samples     int                    `bson:"samples"`
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	durations := make([]types.StepDuration, 0, len(rawResults))
+	for _, doc := range rawResults {
+		duration := types.StepDuration{
+			NodeID:  doc.ID.NodeID,
+			Step:    timelineSteps[doc.ID.Type],
+			Samples: doc.Samples,
+		}
+		if quantiles := doc.Percentiles.quantiles(stepDurationPercentiles); quantiles != nil {
+			duration.P50Ms, duration.P95Ms = quantiles[0], quantiles[1]
+		}
+		durations = append(durations, duration)
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		if durations[i].NodeID != durations[j].NodeID {
+			return durations[i].NodeID < durations[j].NodeID
+		}
+		return stepOrder[durations[i].Step] < stepOrder[durations[j].Step]
+	})
+	return durations, nil
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// funnelSteps are the stages of the step funnel after the round attempt itself, with their entering events
+var funnelSteps = []struct {
+	Step  string
+	Event string
+}{
+	{"prevote", "enteringPrevoteStep"},
+	{"precommit", "enteringPrecommitStep"},
+	{"commit", "enteringCommitStep"},
+}
+
+// heightRound identifies a round attempt
+type heightRound struct {
+	Height int64
+	Round  int64
+}
+
+// ComputeStepFunnel counts the (height, round) attempts that reached the
+// prevote, precommit and commit steps, cluster-wide and per node. An attempt
+// is any (height, round) a node entered a step of. Cluster-wide, an attempt is
+// counted once and reaches a step when any node entered it. Attempts observed
+// by fewer than half of the nodes are minority attempts; they are counted in
+// both views and reported separately per node.
+func ComputeStepFunnel(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) (*types.StepFunnelResponse, error) {
+	stepEvents := bson.A{}
+	for _, step := range funnelSteps {
+		stepEvents = append(stepEvents, step.Event)
+	}
+	// enteringNewRound carries height/round, the other step events currentHeight/currentRound
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+			{"$or", bson.A{
+				withHeightRange(bson.D{{"type", "enteringNewRound"}}, "height", heights),
+				withHeightRange(bson.D{{"type", bson.D{{"$in", stepEvents}}}}, "currentHeight", heights),
+			}},
+		}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"nodeId", "$nodeId"},
+				{"height", bson.D{{"$ifNull", bson.A{"$height", "$currentHeight"}}}},
+				{"round", bson.D{{"$ifNull", bson.A{"$round", "$currentRound"}}}},
+			}},
+			{"types", bson.D{{"$addToSet", "$type"}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			NodeID string `bson:"nodeId"`
+			Height int64  `bson:"height"`
+			Round  int64  `bson:"round"`
+		} `bson:"_id"`
+		Types []string `bson:"types"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	// Reached[i] tells whether step i of funnelSteps was entered, by any node for attempts and by the node per node
+	type attempt struct {
+		Observers int // Nodes that entered any step of the attempt
+		Reached   [3]bool
+	}
+	attempts := map[heightRound]*attempt{}
+	nodeAttempts := map[string]map[heightRound][3]bool{}
+	for _, doc := range rawResults {
+		key := heightRound{Height: doc.ID.Height, Round: doc.ID.Round}
+		a, ok := attempts[key]
+		if !ok {
+			a = &attempt{}
+			attempts[key] = a
+		}
+		a.Observers++
+
+		var reached [3]bool
+		for i, step := range funnelSteps {
+			for _, eventType := range doc.Types {
+				if eventType == step.Event {
+					reached[i] = true
+					a.Reached[i] = true
+				}
+			}
+		}
+		if _, ok := nodeAttempts[doc.ID.NodeID]; !ok {
+			nodeAttempts[doc.ID.NodeID] = map[heightRound][3]bool{}
+		}
+		nodeAttempts[doc.ID.NodeID][key] = reached
+	}
+
+	// minority tells whether an attempt was observed by fewer than half of the nodes
+	minority := func(key heightRound) bool {
+		return attempts[key].Observers*2 < len(nodeAttempts)
+	}
+
+	var clusterCounts [4]int
+	cluster := types.StepFunnel{}
+	for key, a := range attempts {
+		clusterCounts[0]++
+		for i, reached := range a.Reached {
+			if reached {
+				clusterCounts[i+1]++
+			}
+		}
+		if minority(key) {
+			cluster.MinorityAttempts++
+		}
+	}
+	cluster.Stages = funnelStages(clusterCounts)
+
+	response := &types.StepFunnelResponse{Cluster: cluster, Nodes: make([]types.NodeStepFunnel, 0, len(nodeAttempts))}
+	for nodeID, reachedByAttempt := range nodeAttempts {
+		var counts [4]int
+		node := types.NodeStepFunnel{NodeID: nodeID}
+		for key, reached := range reachedByAttempt {
+			counts[0]++
+			for i, r := range reached {
+				if r {
+					counts[i+1]++
+				}
+			}
+			if minority(key) {
+				node.MinorityAttempts++
+			}
+		}
+		node.Stages = funnelStages(counts)
+		response.Nodes = append(response.Nodes, node)
+	}
+	sort.Slice(response.Nodes, func(i, j int) bool { return response.Nodes[i].NodeID < response.Nodes[j].NodeID })
+	return response, nil
+}
+
+// funnelStages turns the attempt count followed by the count of each of
+// funnelSteps into funnel stages with their drop-off
+func funnelStages(counts [4]int) []types.StepFunnelStage {
+	stages := make([]types.StepFunnelStage, 0, len(counts))
+	stages = append(stages, types.StepFunnelStage{Step: "round", Count: counts[0]})
+	for i, step := range funnelSteps {
+		stages = append(stages, types.StepFunnelStage{Step: step.Step, Count: counts[i+1]})
+	}
+	for i := range stages {
+		if counts[0] > 0 {
+			stages[i].ReachedPct = float64(stages[i].Count) / float64(counts[0]) * 100
+		}
+		if i > 0 && stages[i-1].Count > 0 {
+			stages[i].DropOffPct = float64(stages[i-1].Count-stages[i].Count) / float64(stages[i-1].Count) * 100
+		}
+	}
+	return stages
+}
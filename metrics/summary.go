@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ComputeMetricsSummary computes the headline metrics of a simulation
+// concurrently from its tracer events and vote latencies. A metric that fails
+// is left null and reported in Errors instead of failing the summary.
+func ComputeMetricsSummary(
+	ctx context.Context, eventsColl, voteLatenciesColl *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) *types.MetricsSummary {
+	summary := &types.MetricsSummary{Errors: []types.MetricsSummaryError{}}
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+
+	// Failures are collected rather than returned so the other metrics keep running
+	run := func(field string, compute func() error) {
+		g.Go(func() error {
+			if err := compute(); err != nil {
+				mu.Lock()
+				summary.Errors = append(summary.Errors, types.MetricsSummaryError{Field: field, Error: err.Error()})
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	run("messageSuccessRate", func() error {
+		rates, err := ComputeMessageSuccessRate(ctx, eventsColl, from, to, heights, "overall")
+		if err == nil && len(rates) > 0 {
+			summary.MessageSuccessRate = &rates[0].SuccessRate
+		}
+		return err
+	})
+	run("voteLatency", func() error {
+		p50, p95, err := computeOverallVoteLatency(ctx, voteLatenciesColl, from, to, heights)
+		summary.VoteLatencyP50Ms, summary.VoteLatencyP95Ms = p50, p95
+		return err
+	})
+	run("blocks", func() error {
+		count, avgInterval, err := computeBlockSummary(ctx, eventsColl, from, to, heights)
+		summary.BlockCount, summary.AvgBlockIntervalMs = count, avgInterval
+		return err
+	})
+	run("worstPair", func() error {
+		pairs, err := ComputePairwiseLatencyPercentiles(ctx, voteLatenciesColl, from, to, heights, PairLatencyFilter{}, false, nil)
+		for i := range pairs {
+			if summary.WorstPair == nil || pairs[i].P95Ms > summary.WorstPair.P95Ms {
+				summary.WorstPair = &pairs[i]
+			}
+		}
+		return err
+	})
+	run("timeoutCount", func() error {
+		count, err := eventsColl.CountDocuments(ctx, withHeightRange(bson.D{
+			{"type", "scheduledTimeout"},
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "height", heights))
+		if err == nil {
+			summary.TimeoutCount = &count
+		}
+		return err
+	})
+
+	g.Wait()
+	sort.Slice(summary.Errors, func(i, j int) bool {
+		return summary.Errors[i].Field < summary.Errors[j].Field
+	})
+	return summary
+}
+
+// computeOverallVoteLatency returns the p50/p95 latency of all confirmed votes sent within the window
+func computeOverallVoteLatency(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) (p50, p95 *float64, err error) {
+	match := pairLatencyMatch(from, to, heights, PairLatencyFilter{})
+	quantiles, err := overallPercentiles(ctx, coll, match, "$latency", []float64{0.50, 0.95})
+	if err != nil || quantiles == nil {
+		return nil, nil, err
+	}
+	p50Ms := types.NanosToMs(quantiles[0])
+	p95Ms := types.NanosToMs(quantiles[1])
+	return &p50Ms, &p95Ms, nil
+}
+
+// computeBlockSummary counts the heights committed within the window and the
+// mean interval between the first commits of consecutive heights
+func computeBlockSummary(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) (count *int64, avgIntervalMs *float64, err error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"type", "enteringCommitStep"},
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "currentHeight", heights)}},
+		{{"$group", bson.D{
+			{"_id", "$currentHeight"},
+			{"first", bson.D{{"$min", "$timestamp"}}},
+		}}},
+		{{"$group", bson.D{
+			{"_id", nil},
+			{"count", bson.D{{"$sum", 1}}},
+			{"firstCommit", bson.D{{"$min", "$first"}}},
+			{"lastCommit", bson.D{{"$max", "$first"}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result struct {
+		Count       int64     `bson:"count"`
+		FirstCommit time.Time `bson:"firstCommit"`
+		LastCommit  time.Time `bson:"lastCommit"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&result); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	count = &result.Count
+	if result.Count > 1 {
+		average := types.DurationMs(result.LastCommit.Sub(result.FirstCommit)) / float64(result.Count-1)
+		avgIntervalMs = &average
+	}
+	return count, avgIntervalMs, nil
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ComputeThroughput counts events, sent and received votes and committed blocks
+// per time bucket of the given resolution. Buckets are aligned to multiples of
+// the resolution and empty buckets are included. A block counts in the bucket
+// of the first enteringCommitStep of its height.
+func ComputeThroughput(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, resolution time.Duration,
+) ([]types.ThroughputBucket, error) {
+	resolutionMs := resolution.Milliseconds()
+	bucketOf := func(field string) bson.D {
+		ms := bson.D{{"$toLong", field}}
+		return bson.D{{"$subtract", bson.A{ms, bson.D{{"$mod", bson.A{ms, resolutionMs}}}}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}}}}},
+		{{"$facet", bson.D{
+			{"events", bson.A{
+				bson.D{{"$group", bson.D{
+					{"_id", bucketOf("$timestamp")},
+					{"events", bson.D{{"$sum", 1}}},
+					{"votesSent", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+						bson.D{{"$eq", bson.A{"$type", "sendVote"}}}, 1, 0,
+					}}}}}},
+					{"votesReceived", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+						bson.D{{"$eq", bson.A{"$type", "receiveVote"}}}, 1, 0,
+					}}}}}},
+				}}},
+			}},
+			{"blocks", bson.A{
+				bson.D{{"$match", bson.D{{"type", "enteringCommitStep"}}}},
+				bson.D{{"$group", bson.D{
+					{"_id", "$currentHeight"},
+					{"committed", bson.D{{"$min", "$timestamp"}}},
+				}}},
+				bson.D{{"$group", bson.D{
+					{"_id", bucketOf("$committed")},
+					{"blocks", bson.D{{"$sum", 1}}},
+				}}},
+			}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Events []struct {
+			Bucket        int64 `bson:"_id"`
+			Events        int64 `bson:"events"`
+			VotesSent     int64 `bson:"votesSent"`
+			VotesReceived int64 `bson:"votesReceived"`
+		} `bson:"events"`
+		Blocks []struct {
+			Bucket int64 `bson:"_id"`
+			Blocks int64 `bson:"blocks"`
+		} `bson:"blocks"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	// One bucket per resolution step from the bucket containing from to the one containing to
+	firstBucket := from.UnixMilli() - from.UnixMilli()%resolutionMs
+	lastBucket := to.UnixMilli() - to.UnixMilli()%resolutionMs
+	buckets := make([]types.ThroughputBucket, 0, (lastBucket-firstBucket)/resolutionMs+1)
+	for start := firstBucket; start <= lastBucket; start += resolutionMs {
+		buckets = append(buckets, types.ThroughputBucket{Start: time.UnixMilli(start).UTC()})
+	}
+	bucketAt := func(start int64) *types.ThroughputBucket {
+		index := (start - firstBucket) / resolutionMs
+		if start < firstBucket || index >= int64(len(buckets)) {
+			return nil
+		}
+		return &buckets[index]
+	}
+
+	for _, doc := range facet.Events {
+		if bucket := bucketAt(doc.Bucket); bucket != nil {
+			bucket.Events = doc.Events
+			bucket.VotesSent = doc.VotesSent
+			bucket.VotesReceived = doc.VotesReceived
+		}
+	}
+	for _, doc := range facet.Blocks {
+		if bucket := bucketAt(doc.Bucket); bucket != nil {
+			bucket.BlocksCommitted = doc.Blocks
+		}
+	}
+
+	seconds := resolution.Seconds()
+	for i := range buckets {
+		buckets[i].EventsPerSecond = float64(buckets[i].Events) / seconds
+		buckets[i].VotesPerSecond = float64(buckets[i].VotesSent) / seconds
+		buckets[i].BlocksPerMinute = float64(buckets[i].BlocksCommitted) / resolution.Minutes()
+	}
+	return buckets, nil
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// timelineSteps maps step event types to the step names of the round timeline
+var timelineSteps = map[string]string{
+	"enteringNewRound":          "newRound",
+	"proposeStep":               "propose",
+	"enteringPrevoteStep":       "prevote",
+	"enteringPrevoteWaitStep":   "prevoteWait",
+	"enteringPrecommitStep":     "precommit",
+	"enteringPrecommitWaitStep": "precommitWait",
+	"enteringCommitStep":        "commit",
+}
+
+// ComputeHeightTimeline reconstructs, per round and node, when the node entered
+// each consensus step of a height and how many votes it sent and received.
+// It returns nil when the height has no events.
+func ComputeHeightTimeline(ctx context.Context, coll *mongo.Collection, height int64) (*types.HeightTimeline, error) {
+	// enteringNewRound and proposeStep carry height/round, the other step events
+	// currentHeight/currentRound, and vote events vote.height/vote.round
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"$or", bson.A{
+			bson.D{{"type", bson.D{{"$in", bson.A{"enteringNewRound", "proposeStep"}}}}, {"height", height}},
+			bson.D{{"type", bson.D{{"$in", bson.A{
+				"enteringPrevoteStep", "enteringPrevoteWaitStep", "enteringPrecommitStep",
+				"enteringPrecommitWaitStep", "enteringCommitStep",
+			}}}}, {"currentHeight", height}},
+			bson.D{{"type", bson.D{{"$in", bson.A{"sendVote", "receiveVote"}}}}, {"vote.height", height}},
+		}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"round", bson.D{{"$ifNull", bson.A{"$round", "$currentRound", "$vote.round"}}}},
+				{"nodeId", "$nodeId"},
+				{"type", "$type"},
+			}},
+			{"first", bson.D{{"$min", "$timestamp"}}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			Round  int64  `bson:"round"`
+			NodeID string `bson:"nodeId"`
+			Type   string `bson:"type"`
+		} `bson:"_id"`
+		First time.Time `bson:"first"`
+		Count int       `bson:"count"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+	if len(rawResults) == 0 {
+		return nil, nil
+	}
+
+	// Assemble rounds → nodes → steps
+	nodesByRound := map[int64]map[string]*types.NodeRoundTimeline{}
+	for _, doc := range rawResults {
+		nodes, ok := nodesByRound[doc.ID.Round]
+		if !ok {
+			nodes = map[string]*types.NodeRoundTimeline{}
+			nodesByRound[doc.ID.Round] = nodes
+		}
+		node, ok := nodes[doc.ID.NodeID]
+		if !ok {
+			node = &types.NodeRoundTimeline{NodeID: doc.ID.NodeID, Steps: map[string]time.Time{}}
+			nodes[doc.ID.NodeID] = node
+		}
+
+		switch doc.ID.Type {
+		case "sendVote":
+			node.VotesSent += doc.Count
+		case "receiveVote":
+			node.VotesReceived += doc.Count
+		default:
+			node.Steps[timelineSteps[doc.ID.Type]] = doc.First
+		}
+	}
+
+	timeline := &types.HeightTimeline{Height: height, Rounds: []types.RoundTimeline{}}
+	for round, nodes := range nodesByRound {
+		roundTimeline := types.RoundTimeline{Round: round, Nodes: make([]types.NodeRoundTimeline, 0, len(nodes))}
+		for _, node := range nodes {
+			roundTimeline.Nodes = append(roundTimeline.Nodes, *node)
+		}
+		sort.Slice(roundTimeline.Nodes, func(i, j int) bool {
+			return roundTimeline.Nodes[i].NodeID < roundTimeline.Nodes[j].NodeID
+		})
+		timeline.Rounds = append(timeline.Rounds, roundTimeline)
+	}
+	sort.Slice(timeline.Rounds, func(i, j int) bool {
+		return timeline.Rounds[i].Round < timeline.Rounds[j].Round
+	})
+	return timeline, nil
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ComputeTimeoutTimeSeries counts the scheduledTimeout events of each node per
+// time bucket of the given resolution, split by the step and duration of the
+// timeout. Buckets without timeouts are omitted. nodes restricts the nodes
+// reported; empty means all.
+func ComputeTimeoutTimeSeries(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, nodes []string, resolution time.Duration,
+) ([]types.TimeoutSeries, error) {
+	match := bson.D{
+		{"type", "scheduledTimeout"},
+		{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+	}
+	if len(nodes) > 0 {
+		match = append(match, bson.E{"nodeId", bson.D{{"$in", nodes}}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"nodeId", "$nodeId"},
+				{"start", bson.D{{"$dateTrunc", bson.D{
+					{"date", "$timestamp"},
+					{"unit", "millisecond"},
+					{"binSize", resolution.Milliseconds()},
+				}}}},
+				{"step", "$step"},
+				{"duration", "$duration"},
+			}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$sort", bson.D{{"_id.nodeId", 1}, {"_id.start", 1}, {"_id.step", 1}, {"_id.duration", 1}}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			NodeID   string    `bson:"nodeId"`
+			Start    time.Time `bson:"start"`
+			Step     string    `bson:"step"`
+			Duration string    `bson:"duration"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cur.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	// Results are sorted by node and bucket, so each series and point is built in order
+	series := []types.TimeoutSeries{}
+	for _, doc := range rawResults {
+		if len(series) == 0 || series[len(series)-1].NodeID != doc.ID.NodeID {
+			series = append(series, types.TimeoutSeries{NodeID: doc.ID.NodeID})
+		}
+		node := &series[len(series)-1]
+		if len(node.Points) == 0 || !node.Points[len(node.Points)-1].Start.Equal(doc.ID.Start) {
+			node.Points = append(node.Points, types.TimeoutPoint{Start: doc.ID.Start})
+		}
+		point := &node.Points[len(node.Points)-1]
+
+		timeout := types.TimeoutCount{Step: doc.ID.Step, Duration: doc.ID.Duration, Count: doc.Count}
+		// The duration is logged as a Go duration string, e.g. "3s"
+		if duration, err := time.ParseDuration(doc.ID.Duration); err == nil {
+			ms := types.DurationMs(duration)
+			timeout.DurationMs = &ms
+		}
+		point.Count += doc.Count
+		point.Timeouts = append(point.Timeouts, timeout)
+		node.Total += doc.Count
+	}
+	return series, nil
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+)
+
+// ValidatorMappingCollection is the collection of the simulation database
+// holding the validator mapping derived after processing
+const ValidatorMappingCollection = "validator_mapping"
+
+// DeriveValidatorMapping derives which node each validator index of the vote
+// events belongs to.
+//
+// Every node relays the votes of the others, so a vote is attributed to the
+// node it originated at: the nodes whose sendVote carries their own validator
+// address as the vote's, or, when the events carry no validator addresses, the
+// node that sent the vote first. An index is mapped to the node originating
+// most of its votes and flagged ambiguous when its votes originated at several
+// nodes, which points at a shared validator key or misparsed events.
+func DeriveValidatorMapping(ctx context.Context, coll *mongo.Collection) ([]types.ValidatorMapping, error) {
+	ownAddress := bson.D{{"$and", bson.A{
+		bson.D{{"$gt", bson.A{"$validatorAddress", ""}}},
+		bson.D{{"$eq", bson.A{"$validatorAddress", "$vote.validatorAddress"}}},
+	}}}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"type", "sendVote"}}}},
+		{{"$sort", bson.D{{"timestamp", 1}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"height", "$vote.height"},
+				{"round", "$vote.round"},
+				{"voteType", "$vote.type"},
+				{"validatorIndex", "$vote.validatorIndex"},
+			}},
+			{"firstSender", bson.D{{"$first", "$nodeId"}}},
+			{"signers", bson.D{{"$addToSet", bson.D{{"$cond", bson.A{ownAddress, "$nodeId", nil}}}}}},
+			{"address", bson.D{{"$first", "$vote.validatorAddress"}}},
+		}}},
+		{{"$set", bson.D{{"signers", bson.D{{"$setDifference", bson.A{"$signers", bson.A{nil}}}}}}}},
+		{{"$project", bson.D{
+			{"address", 1},
+			{"originators", bson.D{{"$cond", bson.A{
+				bson.D{{"$gt", bson.A{bson.D{{"$size", "$signers"}}, 0}}},
+				"$signers",
+				bson.A{"$firstSender"},
+			}}}},
+		}}},
+		{{"$unwind", "$originators"}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"validatorIndex", "$_id.validatorIndex"}, {"nodeId", "$originators"}}},
+			{"votes", bson.D{{"$sum", 1}}},
+			{"heightFrom", bson.D{{"$min", "$_id.height"}}},
+			{"heightTo", bson.D{{"$max", "$_id.height"}}},
+			{"address", bson.D{{"$max", "$address"}}},
+		}}},
+	}
+
+	cur, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID struct {
+			ValidatorIndex uint64 `bson:"validatorIndex"`
+			NodeID         string `bson:"nodeId"`
+		} `bson:"_id"`
+		Votes      int64  `bson:"votes"`
+		HeightFrom uint64 `bson:"heightFrom"`
+		HeightTo   uint64 `bson:"heightTo"`
+		Address    string `bson:"address"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	byIndex := map[uint64]*types.ValidatorMapping{}
+	for _, doc := range docs {
+		mapping := byIndex[doc.ID.ValidatorIndex]
+		if mapping == nil {
+			mapping = &types.ValidatorMapping{ValidatorIndex: doc.ID.ValidatorIndex}
+			byIndex[doc.ID.ValidatorIndex] = mapping
+		}
+		if doc.Address != "" {
+			mapping.ValidatorAddress = doc.Address
+		}
+		mapping.TotalVotes += doc.Votes
+		mapping.Nodes = append(mapping.Nodes, types.ValidatorNodeVotes{
+			NodeID:     doc.ID.NodeID,
+			Votes:      doc.Votes,
+			HeightFrom: doc.HeightFrom,
+			HeightTo:   doc.HeightTo,
+		})
+	}
+
+	mappings := make([]types.ValidatorMapping, 0, len(byIndex))
+	for _, mapping := range byIndex {
+		nodes := mapping.Nodes
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].Votes != nodes[j].Votes {
+				return nodes[i].Votes > nodes[j].Votes
+			}
+			return nodes[i].NodeID < nodes[j].NodeID
+		})
+		mapping.NodeID = nodes[0].NodeID
+		mapping.Votes = nodes[0].Votes
+		mapping.HeightFrom = nodes[0].HeightFrom
+		mapping.HeightTo = nodes[0].HeightTo
+		mapping.Ambiguous = len(nodes) > 1
+		if !mapping.Ambiguous {
+			mapping.Nodes = nil
+		}
+		mappings = append(mappings, *mapping)
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].ValidatorIndex < mappings[j].ValidatorIndex })
+	return mappings, nil
+}
+
+// StoreValidatorMapping replaces the stored validator mapping of a simulation database
+func StoreValidatorMapping(ctx context.Context, database *mongo.Database, mappings []types.ValidatorMapping) error {
+	coll := database.Collection(ValidatorMappingCollection)
+	if _, err := coll.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
+		return nil
+	}
+	documents := make([]any, len(mappings))
+	for i, mapping := range mappings {
+		documents[i] = mapping
+	}
+	_, err := coll.InsertMany(ctx, documents)
+	return err
+}
+
+// LoadValidatorMapping returns the stored validator mapping of a simulation
+// database, ordered by validator index. It is empty when none was stored.
+func LoadValidatorMapping(ctx context.Context, database *mongo.Database) ([]types.ValidatorMapping, error) {
+	cursor, err := database.Collection(ValidatorMappingCollection).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	mappings := []types.ValidatorMapping{}
+	if err := cursor.All(ctx, &mappings); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Vote delivery statuses
+const (
+	VoteDelivered = "delivered"
+	VoteLost      = "lost"
+	VoteLate      = "late"
+)
+
+// VoteAnomalyFilter restricts the classified votes
+type VoteAnomalyFilter struct {
+	Nodes     []string      // Sender or recipient peer IDs; empty means all
+	Statuses  []string      // Delivery statuses to return
+	Tolerance time.Duration // Slack before a vote received after the commit counts as late
+}
+
+// VoteAnomaliesResult contains a page of classified votes and the total number of matches
+type VoteAnomaliesResult struct {
+	Data  []types.VoteAnomaly
+	Total int
+}
+
+// ComputeVoteAnomalies pairs every sendVote with the matching receiveVote of its
+// recipient, per (height, round, vote type, validator index, sender, recipient),
+// and classifies the vote as lost when it was never received, late when it was
+// received after the recipient's enteringCommitStep for the height plus the
+// tolerance, and delivered otherwise. Late detection compares timestamps of the
+// recipient's own clock, so it is not affected by clock skew between nodes; the
+// reported delay is.
+func ComputeVoteAnomalies(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, filter VoteAnomalyFilter, page, perPage int,
+) (*VoteAnomaliesResult, error) {
+	timeRange := bson.D{{"$gte", from}, {"$lte", to}}
+	isType := func(eventType string) bson.D {
+		return bson.D{{"$eq", bson.A{"$type", eventType}}}
+	}
+	whenType := func(eventType string, value any) bson.D {
+		return bson.D{{"$cond", bson.A{isType(eventType), value, nil}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{
+			{"timestamp", timeRange},
+			{"$or", bson.A{
+				withHeightRange(bson.D{{"type", bson.D{{"$in", bson.A{"sendVote", "receiveVote"}}}}}, "vote.height", heights),
+				withHeightRange(bson.D{{"type", "enteringCommitStep"}}, "currentHeight", heights),
+			}},
+		}}},
+		// sendVote is logged by the sender, receiveVote and enteringCommitStep by the recipient
+		{{"$project", bson.D{
+			{"height", bson.D{{"$ifNull", bson.A{"$vote.height", "$currentHeight"}}}},
+			{"round", "$vote.round"},
+			{"voteType", "$vote.type"},
+			{"validatorIndex", "$vote.validatorIndex"},
+			{"sender", bson.D{{"$switch", bson.D{
+				{"branches", bson.A{
+					bson.D{{"case", isType("sendVote")}, {"then", "$nodeId"}},
+					bson.D{{"case", isType("receiveVote")}, {"then", "$sourcePeerId"}},
+				}},
+				{"default", nil},
+			}}}},
+			{"recipient", bson.D{{"$cond", bson.A{isType("sendVote"), "$recipientPeerId", "$nodeId"}}}},
+			{"sentTime", whenType("sendVote", "$timestamp")},
+			{"receivedTime", whenType("receiveVote", "$timestamp")},
+			{"commitTime", whenType("enteringCommitStep", "$timestamp")},
+		}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"height", "$height"},
+				{"round", "$round"},
+				{"voteType", "$voteType"},
+				{"validatorIndex", "$validatorIndex"},
+				{"sender", "$sender"},
+				{"recipient", "$recipient"},
+			}},
+			{"sentTime", bson.D{{"$min", "$sentTime"}}},
+			{"receivedTime", bson.D{{"$min", "$receivedTime"}}},
+			{"commitTime", bson.D{{"$min", "$commitTime"}}},
+		}}},
+		// Spread the recipient's commit time over its votes of the height
+		{{"$setWindowFields", bson.D{
+			{"partitionBy", bson.D{{"recipient", "$_id.recipient"}, {"height", "$_id.height"}}},
+			{"output", bson.D{
+				{"commitTime", bson.D{{"$min", "$commitTime"}}},
+			}},
+		}}},
+		{{"$match", bson.D{{"sentTime", bson.D{{"$ne", nil}}}}}},
+	}
+
+	if len(filter.Nodes) > 0 {
+		pipeline = append(pipeline, bson.D{{"$match", bson.D{{"$or", bson.A{
+			bson.D{{"_id.sender", bson.D{{"$in", filter.Nodes}}}},
+			bson.D{{"_id.recipient", bson.D{{"$in", filter.Nodes}}}},
+		}}}}})
+	}
+
+	receivedLate := bson.D{{"$and", bson.A{
+		bson.D{{"$ne", bson.A{"$commitTime", nil}}},
+		bson.D{{"$gt", bson.A{
+			"$receivedTime",
+			bson.D{{"$add", bson.A{"$commitTime", filter.Tolerance.Milliseconds()}}},
+		}}},
+	}}}
+
+	pipeline = append(pipeline,
+		bson.D{{"$project", bson.D{
+			{"_id", 0},
+			{"height", "$_id.height"},
+			{"round", "$_id.round"},
+			{"voteType", "$_id.voteType"},
+			{"validatorIndex", "$_id.validatorIndex"},
+			{"sender", "$_id.sender"},
+			{"recipient", "$_id.recipient"},
+			{"sentTime", 1},
+			{"receivedTime", 1},
+			{"commitTime", 1},
+			{"status", bson.D{{"$switch", bson.D{
+				{"branches", bson.A{
+					bson.D{{"case", bson.D{{"$eq", bson.A{"$receivedTime", nil}}}}, {"then", VoteLost}},
+					bson.D{{"case", receivedLate}, {"then", VoteLate}},
+				}},
+				{"default", VoteDelivered},
+			}}}},
+			{"delayMs", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$receivedTime", nil}}}, nil,
+				bson.D{{"$toDouble", bson.D{{"$subtract", bson.A{"$receivedTime", "$sentTime"}}}}},
+			}}}},
+			{"lateByMs", bson.D{{"$cond", bson.A{
+				receivedLate,
+				bson.D{{"$toDouble", bson.D{{"$subtract", bson.A{"$receivedTime", "$commitTime"}}}}},
+				nil,
+			}}}},
+		}}},
+		bson.D{{"$match", bson.D{{"status", bson.D{{"$in", filter.Statuses}}}}}},
+		bson.D{{"$sort", bson.D{
+			{"height", 1}, {"round", 1}, {"voteType", 1}, {"validatorIndex", 1}, {"sender", 1}, {"recipient", 1},
+		}}},
+		pageFacet(page, perPage),
+	)
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []types.VoteAnomaly `bson:"data"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &VoteAnomaliesResult{Data: facet.Data}
+	if result.Data == nil {
+		result.Data = []types.VoteAnomaly{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+	return result, nil
+}
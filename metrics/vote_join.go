@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// voteReceiveSlack is how long after the window a receiveVote is still matched
+// to a sendVote from within the window
+const voteReceiveSlack = time.Minute
+
+// voteLatencySample is the latency of a vote from its sendVote on the sender to
+// the matching receiveVote on the receiver
+type voteLatencySample struct {
+	Height    uint64
+	Sender    string
+	Receiver  string
+	LatencyMs float64
+}
+
+// voteJoinKey identifies a vote sent from a sender to a receiver; the height is
+// implied by the join, which proceeds one height at a time. The type keeps a
+// prevote and a precommit of the same validator and round apart.
+type voteJoinKey struct {
+	Round          uint64
+	ValidatorIndex uint64
+	Type           string
+	Sender         string
+	Receiver       string
+}
+
+// voteEvent holds the fields of sendVote and receiveVote events needed for the join
+type voteEvent struct {
+	Timestamp       time.Time `bson:"timestamp"`
+	NodeID          string    `bson:"nodeId"`
+	RecipientPeerID string    `bson:"recipientPeerId"` // sendVote
+	SourcePeerID    string    `bson:"sourcePeerId"`    // receiveVote
+	Vote            struct {
+		Height         uint64 `bson:"height"`
+		Round          uint64 `bson:"round"`
+		ValidatorIndex uint64 `bson:"validatorIndex"`
+		Type           string `bson:"type"`
+	} `bson:"vote"`
+}
+
+// voteEventSource iterates vote events of one type in vote.height order
+type voteEventSource interface {
+	// peek returns the current event, or nil at the end
+	peek() *voteEvent
+	// advance moves to the next event
+	advance(ctx context.Context) error
+}
+
+// voteEventCursor is a voteEventSource reading from a MongoDB cursor
+type voteEventCursor struct {
+	cur     *mongo.Cursor
+	current *voteEvent
+}
+
+// findVoteEvents opens a cursor over the events of eventType in the time range, ordered by vote.height
+func findVoteEvents(ctx context.Context, coll *mongo.Collection, eventType string, from, to time.Time) (*voteEventCursor, error) {
+	opts := options.Find().
+		SetSort(bson.D{{"vote.height", 1}}).
+		SetProjection(bson.D{
+			{"timestamp", 1}, {"nodeId", 1}, {"recipientPeerId", 1}, {"sourcePeerId", 1},
+			{"vote.height", 1}, {"vote.round", 1}, {"vote.validatorIndex", 1}, {"vote.type", 1},
+		}).
+		SetAllowDiskUse(true)
+	cur, err := coll.Find(ctx, bson.D{
+		{"type", eventType},
+		{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &voteEventCursor{cur: cur}
+	return c, c.advance(ctx)
+}
+
+func (c *voteEventCursor) peek() *voteEvent {
+	return c.current
+}
+
+// advance moves to the next event, leaving current nil at the end
+func (c *voteEventCursor) advance(ctx context.Context) error {
+	c.current = nil
+	if !c.cur.Next(ctx) {
+		return c.cur.Err()
+	}
+	var event voteEvent
+	if err := c.cur.Decode(&event); err != nil {
+		return err
+	}
+	c.current = &event
+	return nil
+}
+
+// forEachVoteLatency joins the sendVote events within the window with their
+// receiveVote events on (height, round, validator index, vote type, sender,
+// receiver) and calls fn for every match, in height order.
+func forEachVoteLatency(ctx context.Context, coll *mongo.Collection, from, to time.Time, fn func(voteLatencySample)) error {
+	sends, err := findVoteEvents(ctx, coll, "sendVote", from, to)
+	if err != nil {
+		return err
+	}
+	defer sends.cur.Close(ctx)
+
+	receives, err := findVoteEvents(ctx, coll, "receiveVote", from, to.Add(voteReceiveSlack))
+	if err != nil {
+		return err
+	}
+	defer receives.cur.Close(ctx)
+
+	return mergeVoteLatencies(ctx, sends, receives, fn)
+}
+
+// mergeVoteLatencies merges sends and receives one height at a time, so only
+// the sends of a single height are held in memory. Repeated sends of a vote
+// are matched from the first one.
+func mergeVoteLatencies(ctx context.Context, sends, receives voteEventSource, fn func(voteLatencySample)) error {
+	sentAt := map[voteJoinKey]time.Time{}
+	for sends.peek() != nil {
+		height := sends.peek().Vote.Height
+
+		clear(sentAt)
+		for sends.peek() != nil && sends.peek().Vote.Height == height {
+			send := sends.peek()
+			key := voteJoinKey{
+				Round:          send.Vote.Round,
+				ValidatorIndex: send.Vote.ValidatorIndex,
+				Type:           send.Vote.Type,
+				Sender:         send.NodeID,
+				Receiver:       send.RecipientPeerID,
+			}
+			if first, ok := sentAt[key]; !ok || send.Timestamp.Before(first) {
+				sentAt[key] = send.Timestamp
+			}
+			if err := sends.advance(ctx); err != nil {
+				return err
+			}
+		}
+
+		// Skip receives of heights without sends
+		for receives.peek() != nil && receives.peek().Vote.Height < height {
+			if err := receives.advance(ctx); err != nil {
+				return err
+			}
+		}
+		for receives.peek() != nil && receives.peek().Vote.Height == height {
+			receive := receives.peek()
+			key := voteJoinKey{
+				Round:          receive.Vote.Round,
+				ValidatorIndex: receive.Vote.ValidatorIndex,
+				Type:           receive.Vote.Type,
+				Sender:         receive.SourcePeerID,
+				Receiver:       receive.NodeID,
+			}
+			if sent, ok := sentAt[key]; ok {
+				fn(voteLatencySample{
+					Height:    height,
+					Sender:    key.Sender,
+					Receiver:  key.Receiver,
+					LatencyMs: types.DurationMs(receive.Timestamp.Sub(sent)),
+				})
+			}
+			if err := receives.advance(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sliceVoteEvents is a voteEventSource over events already in vote.height order
+type sliceVoteEvents struct {
+	events []voteEvent
+}
+
+func (s *sliceVoteEvents) peek() *voteEvent {
+	if len(s.events) == 0 {
+		return nil
+	}
+	return &s.events[0]
+}
+
+func (s *sliceVoteEvents) advance(context.Context) error {
+	s.events = s.events[1:]
+	return nil
+}
+
+var joinStart = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// sendVote returns a sendVote event from sender to receiver at offset ms after joinStart
+func sendVote(height, round, validator uint64, voteType, sender, receiver string, ms int) voteEvent {
+	event := voteEvent{Timestamp: joinStart.Add(time.Duration(ms) * time.Millisecond), NodeID: sender, RecipientPeerID: receiver}
+	event.Vote.Height, event.Vote.Round, event.Vote.ValidatorIndex, event.Vote.Type = height, round, validator, voteType
+	return event
+}
+
+// receiveVote returns a receiveVote event of a vote from sender on receiver at offset ms after joinStart
+func receiveVote(height, round, validator uint64, voteType, sender, receiver string, ms int) voteEvent {
+	event := voteEvent{Timestamp: joinStart.Add(time.Duration(ms) * time.Millisecond), NodeID: receiver, SourcePeerID: sender}
+	event.Vote.Height, event.Vote.Round, event.Vote.ValidatorIndex, event.Vote.Type = height, round, validator, voteType
+	return event
+}
+
+func TestMergeVoteLatencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		sends    []voteEvent
+		receives []voteEvent
+		want     []voteLatencySample
+	}{
+		{
+			name: "prevote and precommit of the same validator and round",
+			sends: []voteEvent{
+				sendVote(1, 0, 3, "prevote", "a", "b", 0),
+				sendVote(1, 0, 3, "precommit", "a", "b", 100),
+			},
+			receives: []voteEvent{
+				receiveVote(1, 0, 3, "prevote", "a", "b", 20),
+				receiveVote(1, 0, 3, "precommit", "a", "b", 150),
+			},
+			want: []voteLatencySample{
+				{Height: 1, Sender: "a", Receiver: "b", LatencyMs: 20},
+				{Height: 1, Sender: "a", Receiver: "b", LatencyMs: 50},
+			},
+		},
+		{
+			name:     "receive of another vote type is not matched",
+			sends:    []voteEvent{sendVote(1, 0, 3, "prevote", "a", "b", 0)},
+			receives: []voteEvent{receiveVote(1, 0, 3, "precommit", "a", "b", 20)},
+		},
+		{
+			name: "repeated sends match from the first",
+			sends: []voteEvent{
+				sendVote(1, 0, 0, "prevote", "a", "b", 30),
+				sendVote(1, 0, 0, "prevote", "a", "b", 10),
+			},
+			receives: []voteEvent{receiveVote(1, 0, 0, "prevote", "a", "b", 40)},
+			want:     []voteLatencySample{{Height: 1, Sender: "a", Receiver: "b", LatencyMs: 30}},
+		},
+		{
+			name:  "receives of heights without sends are skipped",
+			sends: []voteEvent{sendVote(2, 0, 0, "prevote", "a", "b", 0), sendVote(4, 0, 0, "prevote", "a", "b", 0)},
+			receives: []voteEvent{
+				receiveVote(1, 0, 0, "prevote", "a", "b", 5),
+				receiveVote(2, 0, 0, "prevote", "a", "b", 7),
+				receiveVote(3, 0, 0, "prevote", "a", "b", 9),
+				receiveVote(4, 0, 0, "prevote", "a", "b", 11),
+			},
+			want: []voteLatencySample{
+				{Height: 2, Sender: "a", Receiver: "b", LatencyMs: 7},
+				{Height: 4, Sender: "a", Receiver: "b", LatencyMs: 11},
+			},
+		},
+		{
+			name:     "direction matters",
+			sends:    []voteEvent{sendVote(1, 0, 0, "prevote", "a", "b", 0)},
+			receives: []voteEvent{receiveVote(1, 0, 0, "prevote", "b", "a", 5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []voteLatencySample
+			err := mergeVoteLatencies(context.Background(), &sliceVoteEvents{tt.sends}, &sliceVoteEvents{tt.receives}, func(sample voteLatencySample) {
+				got = append(got, sample)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("samples = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// syntheticVoteEvents returns about n events of a network of nodes where every
+// node relays both vote types of every validator to every other node, sorted
+// by vote.height like the cursors of forEachVoteLatency
+func syntheticVoteEvents(n, nodes int) (sends, receives []voteEvent) {
+	names := make([]string, nodes)
+	for i := range names {
+		names[i] = fmt.Sprintf("node%d", i)
+	}
+	for height := uint64(1); len(sends)+len(receives) < n; height++ {
+		for validator := uint64(0); validator < uint64(nodes); validator++ {
+			for _, voteType := range []string{"prevote", "precommit"} {
+				for s, sender := range names {
+					for r, receiver := range names {
+						if s == r {
+							continue
+						}
+						ms := int(height)*1000 + s*10 + r
+						sends = append(sends, sendVote(height, 0, validator, voteType, sender, receiver, ms))
+						receives = append(receives, receiveVote(height, 0, validator, voteType, sender, receiver, ms+5))
+					}
+				}
+			}
+		}
+	}
+	return sends, receives
+}
+
+// BenchmarkMergeVoteLatencies runs the per-height merge over 1M synthetic
+// events. The $lookup self-join it replaced ran inside MongoDB and cannot be
+// run without a server, so there is no in-process baseline.
+func BenchmarkMergeVoteLatencies(b *testing.B) {
+	sends, receives := syntheticVoteEvents(1_000_000, 10)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matched := 0
+		err := mergeVoteLatencies(ctx, &sliceVoteEvents{sends}, &sliceVoteEvents{receives}, func(voteLatencySample) {
+			matched++
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if matched != len(sends) {
+			b.Fatalf("matched %d of %d sends", matched, len(sends))
+		}
+	}
+	b.ReportMetric(float64(len(sends)+len(receives)), "events/op")
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/vote"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"slices"
 	"time"
 )
 
@@ -15,253 +18,356 @@ type VoteLatencyResult struct {
 	Total int
 }
 
+// Threshold modes of VoteLatencyQuery
+const (
+	ThresholdModeAbove = "above" // At or above the percentile
+	ThresholdModeBelow = "below" // Below the percentile
+	ThresholdModeAll   = "all"   // No percentile filter
+)
+
+// VoteLatencySortFields maps the sortBy values of the vote latencies to their fields
+var VoteLatencySortFields = map[string]string{
+	"sentTime": "sentTime",
+	"latency":  "latency",
+}
+
+// VoteLatencyQuery selects, orders and pages the confirmed vote latencies returned by GetVoteLatencies
+type VoteLatencyQuery struct {
+	Page          int
+	PerPage       int
+	Percentile    string // p50, p95 or p99; defaults to p95
+	ThresholdMode string // ThresholdModeAbove (default), ThresholdModeBelow or ThresholdModeAll
+
+	// Column filters, applied before the percentile threshold is computed.
+	// Empty lists and nil values match everything.
+	Senders   []string
+	Receivers []string
+	VoteTypes []string
+	Height    *uint64
+	Round     *uint64
+
+	SortBy     string // Key of VoteLatencySortFields; defaults to sentTime
+	Descending bool
+
+	// Absolute latency bounds. When either is set they replace the percentile
+	// filter, and Percentile and ThresholdMode are ignored.
+	MinLatency *time.Duration
+	MaxLatency *time.Duration
+}
+
+// voteLatencyMatch selects the confirmed vote latencies sent within the window
+// that pass the column filters of query. A height filter is folded into the
+// height range, so vote.height appears once in the match.
+func voteLatencyMatch(from, to time.Time, heights types.HeightRange, query VoteLatencyQuery) bson.D {
+	if query.Height != nil {
+		height := int64(*query.Height)
+		if heights.From == nil || *heights.From < height {
+			heights.From = &height
+		}
+		if heights.To == nil || *heights.To > height {
+			heights.To = &height
+		}
+	}
+	match := withHeightRange(bson.D{
+		{"status", string(vote.VoteMsgStatusConfirmed)},
+		{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
+	}, "vote.height", heights)
+	if len(query.Senders) > 0 {
+		match = append(match, bson.E{"senderPeerId", bson.D{{"$in", query.Senders}}})
+	}
+	if len(query.Receivers) > 0 {
+		match = append(match, bson.E{"recipientPeerId", bson.D{{"$in", query.Receivers}}})
+	}
+	if len(query.VoteTypes) > 0 {
+		match = append(match, bson.E{"vote.type", bson.D{{"$in", query.VoteTypes}}})
+	}
+	if query.Round != nil {
+		match = append(match, bson.E{"vote.round", *query.Round})
+	}
+	return match
+}
+
+// GetVoteLatencies returns a page of the confirmed vote latencies within the
+// window, filtered and ordered by query
 func GetVoteLatencies(
 	ctx context.Context, coll *mongo.Collection,
-	from, to time.Time, page, perPage int, percentile string,
+	from, to time.Time, heights types.HeightRange, query VoteLatencyQuery,
 ) (*VoteLatencyResult, error) {
-	// Convert percentile string to value
-	var percentileValue float64
-	var percentileKey string
-	switch percentile {
-	case "p50":
-		percentileValue = 0.50
-		percentileKey = "p50"
-	case "p95":
-		percentileValue = 0.95
-		percentileKey = "p95"
-	case "p99":
-		percentileValue = 0.99
-		percentileKey = "p99"
-	default:
-		percentileValue = 0.95 // Default to p95
-		percentileKey = "p95"
-	}
-
-	// First get percentile threshold
-	percentilePipeline := mongo.Pipeline{
-		{{"$match", bson.D{
-			{"status", string(vote.VoteMsgStatusConfirmed)},
-			{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
-		}}},
-		{{"$group", bson.D{
-			{"_id", nil},
-			{percentileKey, bson.D{{"$percentile", bson.D{
-				{"input", "$latency"},
-				{"p", bson.A{percentileValue}},
-				{"method", "approximate"},
-			}}}},
-		}}},
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := coll.Aggregate(ctx, voteLatencyPipeline(from, to, heights, query, nil), opts)
+	if err != nil && isPercentileUnsupported(err) {
+		// Without $percentile the threshold is computed first, over the same votes
+		percentile, _, _ := voteLatencyThreshold(query)
+		match := voteLatencyMatch(from, to, heights, query)
+		var quantiles []float64
+		quantiles, err = overallPercentiles(ctx, coll, match, "$latency", []float64{percentile})
+		if err != nil {
+			return nil, err
+		}
+		var threshold float64 // Any threshold selects nothing without votes
+		if quantiles != nil {
+			threshold = quantiles[0]
+		}
+		cursor, err = coll.Aggregate(ctx, voteLatencyPipeline(from, to, heights, query, &threshold), opts)
 	}
-
-	cursor, err := coll.Aggregate(ctx, percentilePipeline)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var percentileResult map[string][]float64
+	var result struct {
+		Total []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Data []*vote.VoteLatency `bson:"data"`
+	}
 	if cursor.Next(ctx) {
-		if err := cursor.Decode(&percentileResult); err != nil {
+		if err := cursor.Decode(&result); err != nil {
 			return nil, err
 		}
 	}
-	cursor.Close(ctx)
-
-	// If no percentile result, return empty
-	thresholdValues, exists := percentileResult[percentileKey]
-	if !exists || len(thresholdValues) == 0 {
-		return &VoteLatencyResult{Data: []*vote.VoteLatency{}, Total: 0}, nil
+	if err := cursor.Err(); err != nil {
+		return nil, err
 	}
 
-	threshold := thresholdValues[0]
-
-	// Create match stage for filtered data
-	matchStage := bson.D{{"$match", bson.D{
-		{"status", string(vote.VoteMsgStatusConfirmed)},
-		{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
-		{"latency", bson.D{{"$gte", threshold}}},
-	}}}
+	latencies := VoteLatencyResult{Data: result.Data}
+	if latencies.Data == nil {
+		latencies.Data = []*vote.VoteLatency{}
+	}
+	if len(result.Total) > 0 {
+		latencies.Total = result.Total[0].Total
+	}
+	return &latencies, nil
+}
 
-	// Get total count
-	countPipeline := mongo.Pipeline{
-		matchStage,
-		{{"$count", "total"}},
+// voteLatencyThreshold returns the quantile of the percentile threshold of
+// query and how latencies are compared with it; ok is false without one
+func voteLatencyThreshold(query VoteLatencyQuery) (percentile float64, comparison string, ok bool) {
+	if query.MinLatency != nil || query.MaxLatency != nil || query.ThresholdMode == ThresholdModeAll {
+		return 0, "", false
+	}
+	// Convert percentile string to value
+	switch query.Percentile {
+	case "p50":
+		percentile = 0.50
+	case "p99":
+		percentile = 0.99
+	default:
+		percentile = 0.95 // Default to p95
+	}
+	comparison = "$gte"
+	if query.ThresholdMode == ThresholdModeBelow {
+		comparison = "$lt"
 	}
+	return percentile, comparison, true
+}
 
-	countCursor, err := coll.Aggregate(ctx, countPipeline)
-	if err != nil {
-		return nil, err
+// voteLatencyPipeline returns the pipeline of GetVoteLatencies. A threshold
+// computed beforehand replaces the $percentile window of the percentile threshold.
+func voteLatencyPipeline(
+	from, to time.Time, heights types.HeightRange, query VoteLatencyQuery, threshold *float64,
+) mongo.Pipeline {
+	match := voteLatencyMatch(from, to, heights, query)
+	pipeline := mongo.Pipeline{}
+
+	percentile, comparison, hasThreshold := voteLatencyThreshold(query)
+	switch {
+	case query.MinLatency != nil || query.MaxLatency != nil:
+		bounds := bson.D{}
+		if query.MinLatency != nil {
+			bounds = append(bounds, bson.E{"$gte", int64(*query.MinLatency)})
+		}
+		if query.MaxLatency != nil {
+			bounds = append(bounds, bson.E{"$lte", int64(*query.MaxLatency)})
+		}
+		pipeline = append(pipeline, bson.D{{"$match", append(match, bson.E{"latency", bounds})}})
+	case hasThreshold && threshold != nil:
+		pipeline = append(pipeline, bson.D{{"$match", append(match, bson.E{"latency", bson.D{{comparison, *threshold}}})}})
+	case hasThreshold:
+		// Every matching vote is annotated with the percentile threshold
+		// over all of them, so filtering, counting and paging stay a
+		// single round-trip
+		pipeline = append(pipeline,
+			bson.D{{"$match", match}},
+			bson.D{{"$setWindowFields", bson.D{
+				{"output", bson.D{
+					{"threshold", bson.D{
+						{"$percentile", bson.D{
+							{"input", "$latency"},
+							{"p", bson.A{percentile}},
+							{"method", "approximate"},
+						}},
+						{"window", bson.D{{"documents", bson.A{"unbounded", "unbounded"}}}},
+					}},
+				}},
+			}}},
+			bson.D{{"$match", bson.D{{"$expr", bson.D{{comparison, bson.A{
+				"$latency", bson.D{{"$arrayElemAt", bson.A{"$threshold", 0}}},
+			}}}}}}},
+			bson.D{{"$unset", "threshold"}},
+		)
+	default:
+		pipeline = append(pipeline, bson.D{{"$match", match}})
 	}
-	defer countCursor.Close(ctx)
 
-	var countResult struct {
-		Total int `bson:"total"`
+	sortField, ok := VoteLatencySortFields[query.SortBy]
+	if !ok {
+		sortField = "sentTime"
 	}
-	if countCursor.Next(ctx) {
-		if err := countCursor.Decode(&countResult); err != nil {
-			return nil, err
-		}
+	direction := 1
+	if query.Descending {
+		direction = -1
 	}
-	countCursor.Close(ctx)
+	// _id keeps the order stable between pages
+	sort := bson.D{{sortField, direction}, {"_id", direction}}
 
-	// Calculate skip value
-	skip := (page - 1) * perPage
+	return append(pipeline, bson.D{{"$facet", bson.D{
+		{"total", bson.A{bson.D{{"$count", "total"}}}},
+		{"data", bson.A{
+			bson.D{{"$sort", sort}},
+			bson.D{{"$skip", (query.Page - 1) * query.PerPage}},
+			bson.D{{"$limit", query.PerPage}},
+		}},
+	}}})
+}
 
-	// Get paginated data
-	dataPipeline := mongo.Pipeline{
-		matchStage,
-		{{"$sort", bson.D{{"sentTime", 1}}}}, // Sort by sentTime ascending
-		{{"$skip", skip}},
-		{{"$limit", perPage}},
-	}
+// voteStatisticsSpikeFactor is the multiple of a group's p95 latency from which a vote counts as a spike
+const voteStatisticsSpikeFactor = 2
 
-	dataCursor, err := coll.Aggregate(ctx, dataPipeline)
+// voteStatisticsPercentiles are the p50, p90, p95 and p99 of each VoteStatisticsResponse
+var voteStatisticsPercentiles = []float64{0.50, 0.90, 0.95, 0.99}
+
+// ComputeVoteStatistics returns aggregated statistics grouped by sender, receiver, and vote type.
+// The requested percentiles are returned in addition, keyed by quantile.
+//
+// The percentiles are accumulated directly on the grouped latencies, so no
+// group ever holds its latencies in a single document; without $percentile
+// only the latencies ranked around each quantile are kept. Spikes are counted
+// in a second pass over the votes above the lowest spike threshold.
+func ComputeVoteStatistics(ctx context.Context, coll *mongo.Collection, from, to time.Time, percentiles []float64) ([]types.VoteStatisticsResponse, error) {
+	match := bson.D{
+		{"status", string(vote.VoteMsgStatusConfirmed)},
+		{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
+	}
+	// The standard quantiles come first, then the requested ones
+	quantileList := append(slices.Clone(voteStatisticsPercentiles), percentiles...)
+	cursor, err := aggregatePercentiles(ctx, coll, func(native bool) mongo.Pipeline {
+		return voteStatisticsPipeline(match, quantileList, native)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer dataCursor.Close(ctx)
+	defer cursor.Close(ctx)
 
-	var latencies []*vote.VoteLatency
-	for dataCursor.Next(ctx) {
-		var latency vote.VoteLatency
-		if err := dataCursor.Decode(&latency); err != nil {
-			return nil, err
+	var rawResults []voteStatisticsGroup
+	if err := cursor.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	quantiles := make(map[spikeGroupKey][]float64, len(rawResults))
+	thresholds := make(map[spikeGroupKey]float64, len(rawResults))
+	for _, result := range rawResults {
+		if values := result.Percentiles.quantiles(quantileList); len(values) == len(quantileList) {
+			quantiles[result.ID] = values
+			thresholds[result.ID] = voteStatisticsSpikeFactor * values[2]
 		}
-		latencies = append(latencies, &latency)
 	}
-	if err := dataCursor.Err(); err != nil {
+	spikes, err := countVoteSpikes(ctx, coll, match, thresholds)
+	if err != nil {
 		return nil, err
 	}
 
-	return &VoteLatencyResult{
-		Data:  latencies,
-		Total: countResult.Total,
-	}, nil
+	results := make([]types.VoteStatisticsResponse, 0, len(rawResults))
+	for _, result := range rawResults {
+		stats := types.VoteStatisticsResponse{
+			Sender:   result.ID.Sender,
+			Receiver: result.ID.Receiver,
+			VoteType: result.ID.VoteType,
+			Count:    result.Count,
+			Max:      types.NanosToMs(result.Max),
+		}
+		values := quantiles[result.ID]
+		if values != nil {
+			stats.P50 = types.NanosToMs(values[0])
+			stats.P90 = types.NanosToMs(values[1])
+			stats.P95 = types.NanosToMs(values[2])
+			stats.P99 = types.NanosToMs(values[3])
+		}
+		if result.Count > 0 {
+			stats.SpikePerc = float64(spikes[result.ID]) / float64(result.Count) * 100
+		}
+		if len(percentiles) > 0 && values != nil {
+			stats.Percentiles = percentileMap(percentiles, values[len(voteStatisticsPercentiles):], types.NanosToMs(1))
+		}
+		results = append(results, stats)
+	}
+	return results, nil
 }
 
-// ComputeVoteStatistics returns aggregated statistics grouped by sender, receiver, and vote type
-func ComputeVoteStatistics(ctx context.Context, coll *mongo.Collection, from, to time.Time) ([]types.VoteStatisticsResponse, error) {
-	pipeline := mongo.Pipeline{
-		{{"$match", bson.D{
-			{"status", string(vote.VoteMsgStatusConfirmed)},
-			{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}},
-		}}},
-		{{"$group", bson.D{
-			{"_id", bson.D{
-				{"sender", "$senderPeerId"},
-				{"receiver", "$recipientPeerId"},
-				{"voteType", "$vote.type"},
-			}},
+// voteStatisticsGroup is a group of the voteStatisticsPipeline
+type voteStatisticsGroup struct {
+	ID          spikeGroupKey          `bson:"_id"`
+	Count       int64                  `bson:"count"`
+	Max         float64                `bson:"max"`
+	Percentiles accumulatedPercentiles `bson:"percentiles"`
+}
+
+// voteStatisticsPipeline groups the votes matching match by spikeGroupKey and
+// accumulates the quantiles of their latency for aggregatePercentiles
+func voteStatisticsPipeline(match bson.D, percentiles []float64, native bool) mongo.Pipeline {
+	pipeline := append(mongo.Pipeline{{{"$match", match}}}, percentileRanks(native, spikeGroupID, "$latency")...)
+	return append(pipeline,
+		bson.D{{"$group", bson.D{
+			{"_id", spikeGroupID},
 			{"count", bson.D{{"$sum", 1}}},
-			{"latencies", bson.D{{"$push", "$latency"}}},
-		}}},
-		{{"$addFields", bson.D{
-			{"p50", bson.D{{"$percentile", bson.D{
-				{"input", "$latencies"},
-				{"p", bson.A{0.5}},
-				{"method", "approximate"},
-			}}}},
-			{"p90", bson.D{{"$percentile", bson.D{
-				{"input", "$latencies"},
-				{"p", bson.A{0.9}},
-				{"method", "approximate"},
-			}}}},
-			{"p95", bson.D{{"$percentile", bson.D{
-				{"input", "$latencies"},
-				{"p", bson.A{0.95}},
-				{"method", "approximate"},
-			}}}},
-			{"p99", bson.D{{"$percentile", bson.D{
-				{"input", "$latencies"},
-				{"p", bson.A{0.99}},
-				{"method", "approximate"},
-			}}}},
-			{"max", bson.D{{"$max", "$latencies"}}},
-		}}},
-		{{"$addFields", bson.D{
-			{"p95Value", bson.D{{"$arrayElemAt", bson.A{"$p95", 0}}}},
-		}}},
-		{{"$addFields", bson.D{
-			{"spikeThreshold", bson.D{{"$multiply", bson.A{"$p95Value", 2}}}},
+			{"percentiles", rankedPercentiles(native, "$latency", percentiles)},
+			{"max", bson.D{{"$max", "$latency"}}},
 		}}},
-		{{"$addFields", bson.D{
-			{"spikes", bson.D{{"$size", bson.D{{"$filter", bson.D{
-				{"input", "$latencies"},
-				{"cond", bson.D{{"$gte", bson.A{"$$this", "$spikeThreshold"}}}},
-			}}}}}},
-		}}},
-		{{"$addFields", bson.D{
-			{"spikePerc", bson.D{{"$multiply", bson.A{
-				bson.D{{"$divide", bson.A{"$spikes", "$count"}}},
-				100,
-			}}}},
-		}}},
-		{{"$sort", bson.D{{"_id.sender", 1}, {"_id.receiver", 1}, {"_id.voteType", 1}}}},
+		bson.D{{"$sort", bson.D{{"_id.sender", 1}, {"_id.receiver", 1}, {"_id.voteType", 1}}}},
+	)
+}
+
+// countVoteSpikes counts, per group, the votes matching match whose latency is
+// at least the group's threshold. Only the votes above the lowest threshold are scanned.
+func countVoteSpikes(
+	ctx context.Context, coll *mongo.Collection, match bson.D, thresholds map[spikeGroupKey]float64,
+) (map[spikeGroupKey]int64, error) {
+	counts := map[spikeGroupKey]int64{}
+	if len(thresholds) == 0 {
+		return counts, nil
+	}
+	minThreshold := math.Inf(1)
+	for _, threshold := range thresholds {
+		minThreshold = math.Min(minThreshold, threshold)
 	}
 
-	cursor, err := coll.Aggregate(ctx, pipeline)
+	filter := append(bson.D{}, match...)
+	filter = append(filter, bson.E{"latency", bson.D{{"$gte", minThreshold}}})
+	opts := options.Find().SetProjection(bson.D{
+		{"senderPeerId", 1}, {"recipientPeerId", 1}, {"vote.type", 1}, {"latency", 1},
+	})
+	cur, err := coll.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	defer cur.Close(ctx)
 
-	var results []types.VoteStatisticsResponse
-	for cursor.Next(ctx) {
-		var result struct {
-			ID struct {
-				Sender   string `bson:"sender"`
-				Receiver string `bson:"receiver"`
-				VoteType string `bson:"voteType"`
-			} `bson:"_id"`
-			Count     int64     `bson:"count"`
-			P50       []float64 `bson:"p50"`
-			P90       []float64 `bson:"p90"`
-			P95       []float64 `bson:"p95"`
-			P99       []float64 `bson:"p99"`
-			Max       int64     `bson:"max"`
-			SpikePerc float64   `bson:"spikePerc"`
+	for cur.Next(ctx) {
+		var doc struct {
+			Vote struct {
+				Type string `bson:"type"`
+			} `bson:"vote"`
+			SenderPeerID    string  `bson:"senderPeerId"`
+			RecipientPeerID string  `bson:"recipientPeerId"`
+			Latency         float64 `bson:"latency"`
 		}
-
-		if err := cursor.Decode(&result); err != nil {
+		if err := cur.Decode(&doc); err != nil {
 			return nil, err
 		}
-
-		// Convert nanoseconds to milliseconds and extract percentile values
-		p50Ms := 0.0
-		if len(result.P50) > 0 {
-			p50Ms = float64(result.P50[0]) / 1e6
-		}
-
-		p90Ms := 0.0
-		if len(result.P90) > 0 {
-			p90Ms = float64(result.P90[0]) / 1e6
-		}
-
-		p95Ms := 0.0
-		if len(result.P95) > 0 {
-			p95Ms = float64(result.P95[0]) / 1e6
-		}
-
-		p99Ms := 0.0
-		if len(result.P99) > 0 {
-			p99Ms = float64(result.P99[0]) / 1e6
+		key := spikeGroupKey{Sender: doc.SenderPeerID, Receiver: doc.RecipientPeerID, VoteType: doc.Vote.Type}
+		if threshold, ok := thresholds[key]; ok && doc.Latency >= threshold {
+			counts[key]++
 		}
-
-		maxMs := float64(result.Max) / 1e6
-
-		results = append(results, types.VoteStatisticsResponse{
-			Sender:    result.ID.Sender,
-			Receiver:  result.ID.Receiver,
-			VoteType:  result.ID.VoteType,
-			Count:     result.Count,
-			P50:       p50Ms,
-			P90:       p90Ms,
-			P95:       p95Ms,
-			P99:       p99Ms,
-			Max:       maxMs,
-			SpikePerc: result.SpikePerc,
-		})
 	}
-
-	return results, cursor.Err()
+	return counts, cur.Err()
 }
@@ -0,0 +1,469 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db/dbtest"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/vote"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestVoteLatencyMatchHeightConditions(t *testing.T) {
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	int64p := func(v int64) *int64 { return &v }
+	uint64p := func(v uint64) *uint64 { return &v }
+
+	tests := []struct {
+		name    string
+		heights types.HeightRange
+		height  *uint64
+		want    bson.D // The vote.height condition, nil when absent
+	}{
+		{name: "no height filter"},
+		{name: "range only", heights: types.HeightRange{From: int64p(10), To: int64p(20)}, want: bson.D{{"$gte", int64(10)}, {"$lte", int64(20)}}},
+		{name: "height only", height: uint64p(15), want: bson.D{{"$gte", int64(15)}, {"$lte", int64(15)}}},
+		{name: "height within the range", heights: types.HeightRange{From: int64p(10), To: int64p(20)}, height: uint64p(15), want: bson.D{{"$gte", int64(15)}, {"$lte", int64(15)}}},
+		{name: "height with an open range", heights: types.HeightRange{From: int64p(10)}, height: uint64p(15), want: bson.D{{"$gte", int64(15)}, {"$lte", int64(15)}}},
+		{name: "height below the range", heights: types.HeightRange{From: int64p(10), To: int64p(20)}, height: uint64p(5), want: bson.D{{"$gte", int64(10)}, {"$lte", int64(5)}}},
+		{name: "height above the range", heights: types.HeightRange{From: int64p(10), To: int64p(20)}, height: uint64p(25), want: bson.D{{"$gte", int64(25)}, {"$lte", int64(20)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := voteLatencyMatch(from, to, tt.heights, VoteLatencyQuery{Height: tt.height})
+			var conditions []any
+			for _, e := range match {
+				if e.Key == "vote.height" {
+					conditions = append(conditions, e.Value)
+				}
+			}
+			if tt.want == nil {
+				if len(conditions) != 0 {
+					t.Fatalf("vote.height conditions = %v, want none", conditions)
+				}
+				return
+			}
+			if len(conditions) != 1 {
+				t.Fatalf("vote.height appears %d times in %v, want once", len(conditions), match)
+			}
+			if !reflect.DeepEqual(conditions[0], tt.want) {
+				t.Errorf("vote.height condition = %v, want %v", conditions[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestVoteLatencyMatchDoesNotModifyTheRange(t *testing.T) {
+	from, to := int64(10), int64(20)
+	heights := types.HeightRange{From: &from, To: &to}
+	height := uint64(15)
+	voteLatencyMatch(time.Time{}, time.Time{}, heights, VoteLatencyQuery{Height: &height})
+	if *heights.From != 10 || *heights.To != 20 {
+		t.Fatalf("range changed to %d-%d", *heights.From, *heights.To)
+	}
+}
+
+// seedVote is a vote latency document of seedVoteLatencies
+type seedVote struct {
+	Sender, Receiver, VoteType string
+	Height                     int64
+	Round                      int32
+	SentTime                   time.Time
+	Latency                    time.Duration
+}
+
+// seedVoteLatencies inserts confirmed vote latencies into coll in batches
+func seedVoteLatencies(tb testing.TB, coll *mongo.Collection, votes []seedVote) {
+	tb.Helper()
+	const batchSize = 10000
+	for start := 0; start < len(votes); start += batchSize {
+		docs := make([]any, 0, batchSize)
+		for _, v := range votes[start:min(start+batchSize, len(votes))] {
+			docs = append(docs, bson.D{
+				{"status", string(vote.VoteMsgStatusConfirmed)},
+				{"vote", bson.D{{"type", v.VoteType}, {"height", v.Height}, {"round", v.Round}}},
+				{"senderPeerId", v.Sender},
+				{"recipientPeerId", v.Receiver},
+				{"sentTime", v.SentTime},
+				{"receivedTime", v.SentTime.Add(v.Latency)},
+				{"latency", int64(v.Latency)},
+			})
+		}
+		if _, err := coll.InsertMany(context.Background(), docs); err != nil {
+			tb.Fatalf("seed vote latencies: %v", err)
+		}
+	}
+}
+
+// TestComputeVoteStatisticsLargeGroup seeds more votes for one pair than the
+// old $push of every latency could take and checks the statistics, both with
+// the server's $percentile and with the ranked fallback of MongoDB before 7.0
+func TestComputeVoteStatisticsLargeGroup(t *testing.T) {
+	coll := dbtest.Database(t).Collection("vote_latencies")
+	ctx := context.Background()
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	const n = 150000
+
+	// Latencies of 1 to n ms, sent one per millisecond
+	votes := make([]seedVote, n)
+	for i := range votes {
+		votes[i] = seedVote{
+			Sender: "node0", Receiver: "node1", VoteType: "prevote",
+			Height: int64(i / 100), SentTime: from.Add(time.Duration(i) * time.Millisecond),
+			Latency: time.Duration(i+1) * time.Millisecond,
+		}
+	}
+	seedVoteLatencies(t, coll, votes)
+	to := from.Add(n * time.Millisecond)
+
+	stats, err := ComputeVoteStatistics(ctx, coll, from, to, []float64{0.999})
+	if err != nil {
+		t.Fatalf("ComputeVoteStatistics: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("%d groups, want 1: %+v", len(stats), stats)
+	}
+	got := stats[0]
+	if got.Count != n || got.Max != n || got.SpikePerc != 0 {
+		t.Errorf("count %d, max %v ms, spikes %v%%, want %d, %d ms and none", got.Count, got.Max, got.SpikePerc, n, n)
+	}
+	// $percentile approximates, so allow 1%
+	for name, pair := range map[string][2]float64{
+		"p50": {got.P50, 0.50 * n}, "p90": {got.P90, 0.90 * n}, "p95": {got.P95, 0.95 * n},
+		"p99": {got.P99, 0.99 * n}, "0.999": {got.Percentiles["0.999"], 0.999 * n},
+	} {
+		if math.Abs(pair[0]-pair[1]) > 0.01*n {
+			t.Errorf("%s = %v ms, want about %v ms", name, pair[0], pair[1])
+		}
+	}
+
+	// The fallback keeps two samples per quantile and interpolates exactly
+	match := bson.D{{"sentTime", bson.D{{"$gte", from}, {"$lte", to}}}}
+	quantiles := append(slices.Clone(voteStatisticsPercentiles), 0.999)
+	cur, err := coll.Aggregate(ctx, voteStatisticsPipeline(match, quantiles, false))
+	if err != nil {
+		t.Fatalf("fallback pipeline: %v", err)
+	}
+	var groups []voteStatisticsGroup
+	if err := cur.All(ctx, &groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("fallback returned %d groups, want 1", len(groups))
+	}
+	if kept := len(groups[0].Percentiles.samples); kept > 2*len(quantiles) {
+		t.Errorf("fallback kept %d latencies, want at most %d", kept, 2*len(quantiles))
+	}
+	for i, value := range groups[0].Percentiles.quantiles(quantiles) {
+		want := float64(time.Millisecond) * (1 + quantiles[i]*(n-1))
+		if math.Abs(value-want) > 1 {
+			t.Errorf("fallback quantile %v = %v ns, want %v ns", quantiles[i], value, want)
+		}
+	}
+}
+
+// BenchmarkGetVoteLatencies compares the single $facet pipeline of
+// GetVoteLatencies with computing the threshold in a round-trip of its own
+// first, as the fallback without $percentile does
+func BenchmarkGetVoteLatencies(b *testing.B) {
+	coll := dbtest.Database(b).Collection("vote_latencies")
+	ctx := context.Background()
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	const n = 50000
+
+	votes := make([]seedVote, n)
+	for i := range votes {
+		votes[i] = seedVote{
+			Sender: "node" + strconv.Itoa(i%4), Receiver: "node" + strconv.Itoa((i+1)%4), VoteType: "prevote",
+			Height: int64(i / 100), SentTime: from.Add(time.Duration(i) * time.Millisecond),
+			Latency: time.Duration(i%1000+1) * time.Millisecond,
+		}
+	}
+	seedVoteLatencies(b, coll, votes)
+	to := from.Add(n * time.Millisecond)
+	query := VoteLatencyQuery{Page: 3, PerPage: 100, Percentile: "p95", ThresholdMode: ThresholdModeAbove}
+
+	b.Run("single pipeline", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := GetVoteLatencies(ctx, coll, from, to, types.HeightRange{}, query); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("threshold first", func(b *testing.B) {
+		match := voteLatencyMatch(from, to, types.HeightRange{}, query)
+		for i := 0; i < b.N; i++ {
+			quantiles, err := overallPercentiles(ctx, coll, match, "$latency", []float64{0.95})
+			if err != nil {
+				b.Fatal(err)
+			}
+			cur, err := coll.Aggregate(ctx, voteLatencyPipeline(from, to, types.HeightRange{}, query, &quantiles[0]))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := cur.All(ctx, &[]bson.M{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// latencyCondition returns the stage names of pipeline and the latency
+// condition of its first $match, nil when there is none
+func latencyCondition(pipeline mongo.Pipeline) (stages []string, condition any) {
+	for i, stage := range pipeline {
+		stages = append(stages, stage[0].Key)
+		if i > 0 || stage[0].Key != "$match" {
+			continue
+		}
+		for _, e := range stage[0].Value.(bson.D) {
+			if e.Key == "latency" {
+				condition = e.Value
+			}
+		}
+	}
+	return stages, condition
+}
+
+func TestVoteLatencyPipelineThresholds(t *testing.T) {
+	ms := func(v int) *time.Duration { d := time.Duration(v) * time.Millisecond; return &d }
+	threshold := 42.0
+	window := []string{"$match", "$setWindowFields", "$match", "$unset", "$facet"}
+
+	tests := []struct {
+		name       string
+		query      VoteLatencyQuery
+		threshold  *float64
+		stages     []string
+		comparison string // Of the percentile window, empty without one
+		condition  any
+	}{
+		{name: "p95 above by default", query: VoteLatencyQuery{}, stages: window, comparison: "$gte"},
+		{name: "below", query: VoteLatencyQuery{Percentile: "p50", ThresholdMode: ThresholdModeBelow}, stages: window, comparison: "$lt"},
+		{name: "all", query: VoteLatencyQuery{Percentile: "p99", ThresholdMode: ThresholdModeAll}, stages: []string{"$match", "$facet"}},
+		{
+			name:      "minimum replaces the percentile",
+			query:     VoteLatencyQuery{Percentile: "p99", ThresholdMode: ThresholdModeAbove, MinLatency: ms(5)},
+			stages:    []string{"$match", "$facet"},
+			condition: bson.D{{"$gte", int64(5 * time.Millisecond)}},
+		},
+		{
+			name:      "maximum replaces the percentile",
+			query:     VoteLatencyQuery{Percentile: "p50", ThresholdMode: ThresholdModeBelow, MaxLatency: ms(7)},
+			stages:    []string{"$match", "$facet"},
+			condition: bson.D{{"$lte", int64(7 * time.Millisecond)}},
+		},
+		{
+			name:      "bounds win over a computed threshold",
+			query:     VoteLatencyQuery{MinLatency: ms(5), MaxLatency: ms(7)},
+			threshold: &threshold,
+			stages:    []string{"$match", "$facet"},
+			condition: bson.D{{"$gte", int64(5 * time.Millisecond)}, {"$lte", int64(7 * time.Millisecond)}},
+		},
+		{
+			name:      "computed threshold replaces the window",
+			query:     VoteLatencyQuery{ThresholdMode: ThresholdModeBelow},
+			threshold: &threshold,
+			stages:    []string{"$match", "$facet"},
+			condition: bson.D{{"$lt", threshold}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Page, tt.query.PerPage = 1, 10
+			pipeline := voteLatencyPipeline(time.Time{}, time.Time{}, types.HeightRange{}, tt.query, tt.threshold)
+			stages, condition := latencyCondition(pipeline)
+			if !slices.Equal(stages, tt.stages) {
+				t.Fatalf("stages = %v, want %v", stages, tt.stages)
+			}
+			if !reflect.DeepEqual(condition, tt.condition) {
+				t.Errorf("latency condition = %v, want %v", condition, tt.condition)
+			}
+			if tt.comparison != "" {
+				expr := pipeline[2][0].Value.(bson.D)[0].Value.(bson.D)
+				if expr[0].Key != tt.comparison {
+					t.Errorf("threshold comparison = %s, want %s", expr[0].Key, tt.comparison)
+				}
+			}
+		})
+	}
+}
+
+// TestGetVoteLatenciesThresholds checks on the server that absolute bounds
+// win over the percentile threshold
+func TestGetVoteLatenciesThresholds(t *testing.T) {
+	coll := dbtest.Database(t).Collection("vote_latencies")
+	ctx := context.Background()
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Latencies of 1 to 100 ms
+	votes := make([]seedVote, 100)
+	for i := range votes {
+		votes[i] = seedVote{
+			Sender: "node0", Receiver: "node1", VoteType: "prevote",
+			Height: 1, SentTime: from.Add(time.Duration(i) * time.Second),
+			Latency: time.Duration(i+1) * time.Millisecond,
+		}
+	}
+	seedVoteLatencies(t, coll, votes)
+	to := from.Add(time.Hour)
+	ms := func(v int) *time.Duration { d := time.Duration(v) * time.Millisecond; return &d }
+
+	tests := []struct {
+		name     string
+		query    VoteLatencyQuery
+		min, max time.Duration // Of the returned latencies
+		total    int
+	}{
+		{name: "above p95", query: VoteLatencyQuery{Percentile: "p95", ThresholdMode: ThresholdModeAbove}, min: 95 * time.Millisecond, max: 100 * time.Millisecond, total: 6},
+		{name: "below p50", query: VoteLatencyQuery{Percentile: "p50", ThresholdMode: ThresholdModeBelow}, min: time.Millisecond, max: 49 * time.Millisecond, total: 49},
+		{name: "all", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll}, min: time.Millisecond, max: 100 * time.Millisecond, total: 100},
+		{
+			name:  "bounds win over above p99",
+			query: VoteLatencyQuery{Percentile: "p99", ThresholdMode: ThresholdModeAbove, MinLatency: ms(10), MaxLatency: ms(20)},
+			min:   10 * time.Millisecond, max: 20 * time.Millisecond, total: 11,
+		},
+		{
+			name:  "minimum wins over below p50",
+			query: VoteLatencyQuery{Percentile: "p50", ThresholdMode: ThresholdModeBelow, MinLatency: ms(90)},
+			min:   90 * time.Millisecond, max: 100 * time.Millisecond, total: 11,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Page, tt.query.PerPage = 1, 100
+			tt.query.SortBy = "latency"
+			result, err := GetVoteLatencies(ctx, coll, from, to, types.HeightRange{}, tt.query)
+			if err != nil {
+				t.Fatalf("GetVoteLatencies: %v", err)
+			}
+			// $percentile approximates, so allow a vote either side
+			if result.Total < tt.total-1 || result.Total > tt.total+1 || len(result.Data) != result.Total {
+				t.Fatalf("total %d with %d rows, want about %d", result.Total, len(result.Data), tt.total)
+			}
+			lowest, highest := time.Duration(result.Data[0].Latency), time.Duration(result.Data[len(result.Data)-1].Latency)
+			if (lowest-tt.min).Abs() > time.Millisecond || (highest-tt.max).Abs() > time.Millisecond {
+				t.Errorf("latencies %v to %v, want %v to %v", lowest, highest, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestVoteLatencyMatchFilters(t *testing.T) {
+	round := uint64(2)
+	tests := []struct {
+		name  string
+		query VoteLatencyQuery
+		want  bson.D // Conditions after status and sentTime
+	}{
+		{name: "no filters", want: bson.D{}},
+		{name: "senders", query: VoteLatencyQuery{Senders: []string{"a", "b"}}, want: bson.D{{"senderPeerId", bson.D{{"$in", []string{"a", "b"}}}}}},
+		{name: "receivers", query: VoteLatencyQuery{Receivers: []string{"c"}}, want: bson.D{{"recipientPeerId", bson.D{{"$in", []string{"c"}}}}}},
+		{name: "vote types", query: VoteLatencyQuery{VoteTypes: []string{"precommit"}}, want: bson.D{{"vote.type", bson.D{{"$in", []string{"precommit"}}}}}},
+		{name: "round", query: VoteLatencyQuery{Round: &round}, want: bson.D{{"vote.round", uint64(2)}}},
+		{
+			name:  "all combined",
+			query: VoteLatencyQuery{Senders: []string{"a"}, Receivers: []string{"c"}, VoteTypes: []string{"prevote"}, Round: &round},
+			want: bson.D{
+				{"senderPeerId", bson.D{{"$in", []string{"a"}}}},
+				{"recipientPeerId", bson.D{{"$in", []string{"c"}}}},
+				{"vote.type", bson.D{{"$in", []string{"prevote"}}}},
+				{"vote.round", uint64(2)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := voteLatencyMatch(time.Time{}, time.Time{}, types.HeightRange{}, tt.query)
+			if got := match[2:]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filters = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetVoteLatenciesFilters checks on the server that the column filters
+// apply before the percentile threshold and that the sort order holds
+func TestGetVoteLatenciesFilters(t *testing.T) {
+	coll := dbtest.Database(t).Collection("vote_latencies")
+	ctx := context.Background()
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// node0 sends prevotes of 1 to 100 ms at round 0, node1 precommits of
+	// 1001 to 1100 ms at round 1, both to node2 and over heights 1 to 10
+	var votes []seedVote
+	for i := 0; i < 100; i++ {
+		sent := from.Add(time.Duration(i) * time.Second)
+		votes = append(votes,
+			seedVote{Sender: "node0", Receiver: "node2", VoteType: "prevote", Height: int64(i/10 + 1), Round: 0, SentTime: sent, Latency: time.Duration(i+1) * time.Millisecond},
+			seedVote{Sender: "node1", Receiver: "node2", VoteType: "precommit", Height: int64(i/10 + 1), Round: 1, SentTime: sent, Latency: time.Duration(i+1001) * time.Millisecond},
+		)
+	}
+	seedVoteLatencies(t, coll, votes)
+	to := from.Add(time.Hour)
+	height, round := uint64(3), uint64(1)
+
+	tests := []struct {
+		name   string
+		query  VoteLatencyQuery
+		total  int
+		sender string        // Of every returned vote, empty to skip
+		first  time.Duration // Latency of the first returned vote
+	}{
+		{name: "all votes", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll}, total: 200, first: time.Millisecond},
+		{name: "sender", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll, Senders: []string{"node1"}}, total: 100, sender: "node1", first: 1001 * time.Millisecond},
+		{name: "receiver", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll, Receivers: []string{"node0"}}, total: 0},
+		{name: "vote type", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll, VoteTypes: []string{"prevote"}}, total: 100, sender: "node0", first: time.Millisecond},
+		{name: "height", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll, Height: &height}, total: 20, first: 21 * time.Millisecond},
+		{name: "round", query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll, Round: &round}, total: 100, sender: "node1", first: 1001 * time.Millisecond},
+		{
+			name:  "descending",
+			query: VoteLatencyQuery{ThresholdMode: ThresholdModeAll, VoteTypes: []string{"prevote"}, Descending: true},
+			total: 100, sender: "node0", first: 100 * time.Millisecond,
+		},
+		{
+			// Over all votes p95 falls among the precommits
+			name:  "threshold over the filtered set",
+			query: VoteLatencyQuery{Percentile: "p95", ThresholdMode: ThresholdModeAbove, Senders: []string{"node0"}},
+			total: 6, sender: "node0", first: 95 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Page, tt.query.PerPage = 1, 200
+			tt.query.SortBy = "latency"
+			result, err := GetVoteLatencies(ctx, coll, from, to, types.HeightRange{}, tt.query)
+			if err != nil {
+				t.Fatalf("GetVoteLatencies: %v", err)
+			}
+			// $percentile approximates, so allow a vote either side
+			if result.Total < tt.total-1 || result.Total > tt.total+1 || len(result.Data) != result.Total {
+				t.Fatalf("total %d with %d rows, want about %d", result.Total, len(result.Data), tt.total)
+			}
+			if len(result.Data) == 0 {
+				return
+			}
+			if first := time.Duration(result.Data[0].Latency); (first - tt.first).Abs() > time.Millisecond {
+				t.Errorf("first latency %v, want %v", first, tt.first)
+			}
+			for _, v := range result.Data {
+				if tt.sender != "" && v.SenderPeerId != tt.sender {
+					t.Fatalf("vote from %s, want only %s", v.SenderPeerId, tt.sender)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// voteLatencyTimeSeriesPercentiles are the quantiles of each VoteLatencyPoint
+var voteLatencyTimeSeriesPercentiles = []float64{0.50, 0.95, 0.99}
+
+// ComputeVoteLatencyTimeSeries returns the p50, p95 and p99 of the confirmed
+// vote latencies per time bucket of the given resolution, bucketed by sentTime.
+// Only buckets with votes are returned, in time order.
+func ComputeVoteLatencyTimeSeries(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, filter PairLatencyFilter, resolution time.Duration,
+) ([]types.VoteLatencyPoint, error) {
+	bucket := bson.D{{"$dateTrunc", bson.D{
+		{"date", "$sentTime"},
+		{"unit", "millisecond"},
+		{"binSize", resolution.Milliseconds()},
+	}}}
+	latencyMs := nanosToMsExpr("$latency")
+	build := func(native bool) mongo.Pipeline {
+		pipeline := mongo.Pipeline{{{"$match", pairLatencyMatch(from, to, heights, filter)}}}
+		return append(append(pipeline, percentileRanks(native, bucket, latencyMs)...),
+			bson.D{{"$group", bson.D{
+				{"_id", bucket},
+				{"samples", bson.D{{"$sum", 1}}},
+				{"percentiles", rankedPercentiles(native, latencyMs, voteLatencyTimeSeriesPercentiles)},
+			}}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		)
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []struct {
+		Start       time.Time              `bson:"_id"`
+		Samples     int                    `bson:"samples"`
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	points := make([]types.VoteLatencyPoint, 0, len(docs))
+	for _, doc := range docs {
+		point := types.VoteLatencyPoint{Start: doc.Start, Samples: doc.Samples}
+		if quantiles := doc.Percentiles.quantiles(voteLatencyTimeSeriesPercentiles); quantiles != nil {
+			point.P50Ms, point.P95Ms, point.P99Ms = quantiles[0], quantiles[1], quantiles[2]
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"context"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// VoteParticipationResult contains a page of heights with their vote participation,
+// the total number of heights and the participation of each validator
+type VoteParticipationResult struct {
+	Data           []types.HeightVoteParticipation
+	Total          int
+	ValidatorCount int
+	Validators     []types.ValidatorParticipation
+}
+
+// ComputeVoteParticipation reports which validator indices had their votes
+// observed anywhere in the network, per height and vote type (and round when
+// byRound is set), from p2pVote and receiveVote events. Each such combination
+// is a vote slot; a validator's participation is the share of slots in the
+// range it voted in.
+func ComputeVoteParticipation(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, byRound bool, page, perPage int,
+) (*VoteParticipationResult, error) {
+	slotKey := bson.D{{"height", "$vote.height"}}
+	if byRound {
+		slotKey = append(slotKey, bson.E{"round", "$vote.round"})
+	}
+	slotKey = append(slotKey, bson.E{"voteType", "$vote.type"})
+
+	pipeline := mongo.Pipeline{
+		{{"$match", withHeightRange(bson.D{
+			{"type", bson.D{{"$in", bson.A{"p2pVote", "receiveVote"}}}},
+			{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		}, "vote.height", heights)}},
+		{{"$group", bson.D{
+			{"_id", slotKey},
+			{"validators", bson.D{{"$addToSet", "$vote.validatorIndex"}}},
+		}}},
+		{{"$facet", bson.D{
+			{"heights", bson.A{
+				bson.D{{"$sort", bson.D{{"_id.round", 1}, {"_id.voteType", 1}}}},
+				bson.D{{"$group", bson.D{
+					{"_id", "$_id.height"},
+					{"votes", bson.D{{"$push", bson.D{
+						{"round", "$_id.round"},
+						{"voteType", "$_id.voteType"},
+						{"validators", "$validators"},
+					}}}},
+				}}},
+				bson.D{{"$sort", bson.D{{"_id", 1}}}},
+				bson.D{{"$skip", (page - 1) * perPage}},
+				bson.D{{"$limit", perPage}},
+			}},
+			{"total", bson.A{
+				bson.D{{"$group", bson.D{{"_id", "$_id.height"}}}},
+				bson.D{{"$count", "total"}},
+			}},
+			{"slots", bson.A{bson.D{{"$count", "slots"}}}},
+			{"validators", bson.A{
+				bson.D{{"$unwind", "$validators"}},
+				bson.D{{"$group", bson.D{
+					{"_id", "$validators"},
+					{"participated", bson.D{{"$sum", 1}}},
+				}}},
+			}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facet struct {
+		Heights []types.HeightVoteParticipation `bson:"heights"`
+		Total   []struct {
+			Total int `bson:"total"`
+		} `bson:"total"`
+		Slots []struct {
+			Slots int `bson:"slots"`
+		} `bson:"slots"`
+		Validators []struct {
+			Index        int `bson:"_id"`
+			Participated int `bson:"participated"`
+		} `bson:"validators"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&facet); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &VoteParticipationResult{Data: facet.Heights, Validators: []types.ValidatorParticipation{}}
+	if result.Data == nil {
+		result.Data = []types.HeightVoteParticipation{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Total
+	}
+
+	// Validators that never voted in range still count up to the highest observed index
+	participated := map[int]int{}
+	for _, validator := range facet.Validators {
+		participated[validator.Index] = validator.Participated
+		if validator.Index+1 > result.ValidatorCount {
+			result.ValidatorCount = validator.Index + 1
+		}
+	}
+	slots := 0
+	if len(facet.Slots) > 0 {
+		slots = facet.Slots[0].Slots
+	}
+	for index := 0; index < result.ValidatorCount; index++ {
+		validator := types.ValidatorParticipation{ValidatorIndex: index, Participated: participated[index]}
+		if slots > 0 {
+			validator.ParticipationPct = float64(validator.Participated) / float64(slots) * 100
+		}
+		result.Validators = append(result.Validators, validator)
+	}
+
+	for i := range result.Data {
+		for j := range result.Data[i].Votes {
+			votes := &result.Data[i].Votes[j]
+			bitmap := []byte(strings.Repeat("_", result.ValidatorCount))
+			for _, index := range votes.Validators {
+				bitmap[index] = 'x'
+			}
+			votes.Bitmap = string(bitmap)
+			votes.Participating = len(votes.Validators)
+		}
+	}
+	return result, nil
+}
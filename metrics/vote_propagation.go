@@ -6,77 +6,154 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
 	"time"
 )
 
-// 1. Pair-wise latency percentiles (p50, p95, p99) per sender→receiver
+// PairLatencyFilter restricts pairwise latencies to the given peer IDs. Empty
+// lists match everything; Nodes matches either the sender or the receiver.
+type PairLatencyFilter struct {
+	Senders   []string
+	Receivers []string
+	Nodes     []string
+}
+
+// withHeightRange adds a block height range on field to a match, ANDed with its other conditions
+func withHeightRange(match bson.D, field string, heights types.HeightRange) bson.D {
+	condition := bson.D{}
+	if heights.From != nil {
+		condition = append(condition, bson.E{"$gte", *heights.From})
+	}
+	if heights.To != nil {
+		condition = append(condition, bson.E{"$lte", *heights.To})
+	}
+	if len(condition) == 0 {
+		return match
+	}
+	return append(match, bson.E{field, condition})
+}
+
+// pairLatencyMatch selects the confirmed vote latencies sent within the window that pass the filter
+func pairLatencyMatch(from, to time.Time, heights types.HeightRange, filter PairLatencyFilter) bson.D {
+	match := withHeightRange(bson.D{
+		{"sentTime", bson.D{
+			{"$gte", from},
+			{"$lte", to},
+		}},
+		{"status", "confirmed"},
+	}, "vote.height", heights)
+	if len(filter.Senders) > 0 {
+		match = append(match, bson.E{"senderPeerId", bson.D{{"$in", filter.Senders}}})
+	}
+	if len(filter.Receivers) > 0 {
+		match = append(match, bson.E{"recipientPeerId", bson.D{{"$in", filter.Receivers}}})
+	}
+	if len(filter.Nodes) > 0 {
+		match = append(match, bson.E{"$or", bson.A{
+			bson.D{{"senderPeerId", bson.D{{"$in", filter.Nodes}}}},
+			bson.D{{"recipientPeerId", bson.D{{"$in", filter.Nodes}}}},
+		}})
+	}
+	return match
+}
+
+// nanosToMsExpr converts a nanosecond expression, such as $latency, to milliseconds within a pipeline
+func nanosToMsExpr(expr any) bson.D {
+	return bson.D{{"$divide", bson.A{expr, float64(time.Millisecond)}}}
+}
+
+// percentileAccumulator computes the given quantiles of input as an array
+func percentileAccumulator(input any, percentiles []float64) bson.D {
+	p := bson.A{}
+	for _, percentile := range percentiles {
+		p = append(p, percentile)
+	}
+	return bson.D{{"$percentile", bson.D{
+		{"input", input},
+		{"p", p},
+		{"method", "approximate"},
+	}}}
+}
+
+// percentileMap keys the values computed by percentileAccumulator by their
+// quantile, scaling each by scale
+func percentileMap(percentiles []float64, values []float64, scale float64) map[string]float64 {
+	result := make(map[string]float64, len(percentiles))
+	for i, percentile := range percentiles {
+		if i < len(values) {
+			result[strconv.FormatFloat(percentile, 'f', -1, 64)] = values[i] * scale
+		}
+	}
+	return result
+}
+
+// pairLatencyPercentiles are the p50, p95 and p99 of each PairLatency
+var pairLatencyPercentiles = []float64{0.50, 0.95, 0.99}
+
+// 1. Pair-wise latency percentiles (p50, p95, p99) per sender→receiver, and
+// per vote type when groupByVoteType is set. The requested percentiles are
+// returned in addition, keyed by quantile.
 func ComputePairwiseLatencyPercentiles(
 	ctx context.Context, coll *mongo.Collection,
-	from, to time.Time,
+	from, to time.Time, heights types.HeightRange, filter PairLatencyFilter, groupByVoteType bool,
+	percentiles []float64,
 ) ([]types.PairLatency, error) {
-	pipeline := mongo.Pipeline{
-		{{"$match", bson.D{
-			{"sentTime", bson.D{
-				{"$gte", from},
-				{"$lte", to},
-			}},
-			{"status", "confirmed"},
-		}}},
-		{{"$addFields", bson.D{
-			{"latencyMs", bson.D{{"$divide", bson.A{"$latency", 1000000}}}}, // convert nanoseconds to milliseconds
-		}}},
-		{{"$group", bson.D{
-			{"_id", bson.D{
-				{"sender", "$senderPeerId"},
-				{"receiver", "$recipientPeerId"},
-			}},
-			{"p50", bson.D{{"$percentile", bson.D{
-				{"input", "$latencyMs"},
-				{"p", bson.A{0.50}},
-				{"method", "approximate"},
-			}}}},
-			{"p95", bson.D{{"$percentile", bson.D{
-				{"input", "$latencyMs"},
-				{"p", bson.A{0.95}},
-				{"method", "approximate"},
-			}}}},
-			{"p99", bson.D{{"$percentile", bson.D{
-				{"input", "$latencyMs"},
-				{"p", bson.A{0.99}},
-				{"method", "approximate"},
-			}}}},
-		}}},
-		{{"$project", bson.D{
-			{"_id", 0},
-			{"sender", "$_id.sender"},
-			{"receiver", "$_id.receiver"},
-			{"p50Ms", bson.D{{"$arrayElemAt", bson.A{"$p50", 0}}}},
-			{"p95Ms", bson.D{{"$arrayElemAt", bson.A{"$p95", 0}}}},
-			{"p99Ms", bson.D{{"$arrayElemAt", bson.A{"$p99", 0}}}},
-		}}},
+	match := pairLatencyMatch(from, to, heights, filter)
+
+	groupKey := bson.D{
+		{"sender", "$senderPeerId"},
+		{"receiver", "$recipientPeerId"},
+	}
+	if groupByVoteType {
+		groupKey = append(groupKey, bson.E{"voteType", "$vote.type"})
 	}
 
-	opts := options.Aggregate().SetAllowDiskUse(true)
-	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	// The standard quantiles come first, then the requested ones
+	quantileList := append(slices.Clone(pairLatencyPercentiles), percentiles...)
+	latencyMs := nanosToMsExpr("$latency")
+	build := func(native bool) mongo.Pipeline {
+		pipeline := append(mongo.Pipeline{{{"$match", match}}}, percentileRanks(native, groupKey, latencyMs)...)
+		return append(pipeline, bson.D{{"$group", bson.D{
+			{"_id", groupKey},
+			{"percentiles", rankedPercentiles(native, latencyMs, quantileList)},
+		}}})
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
-	var rawResults []bson.M
+	var rawResults []struct {
+		ID struct {
+			Sender   string `bson:"sender"`
+			Receiver string `bson:"receiver"`
+			VoteType string `bson:"voteType"`
+		} `bson:"_id"`
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
 	if err := cur.All(ctx, &rawResults); err != nil {
 		return nil, err
 	}
 
-	var out []types.PairLatency
+	out := []types.PairLatency{}
 	for _, doc := range rawResults {
-		out = append(out, types.PairLatency{
-			Sender:   doc["sender"].(string),
-			Receiver: doc["receiver"].(string),
-			P50Ms:    float32(doc["p50Ms"].(float64)),
-			P95Ms:    float32(doc["p95Ms"].(float64)),
-			P99Ms:    float32(doc["p99Ms"].(float64)),
-		})
+		latency := types.PairLatency{
+			Sender:   doc.ID.Sender,
+			Receiver: doc.ID.Receiver,
+			VoteType: doc.ID.VoteType,
+		}
+		if quantiles := doc.Percentiles.quantiles(quantileList); len(quantiles) == len(quantileList) {
+			latency.P50Ms, latency.P95Ms, latency.P99Ms = float32(quantiles[0]), float32(quantiles[1]), float32(quantiles[2])
+			if len(percentiles) > 0 {
+				latency.Percentiles = percentileMap(percentiles, quantiles[len(pairLatencyPercentiles):], 1)
+			}
+		}
+		out = append(out, latency)
 	}
 	return out, nil
 }
@@ -86,183 +163,206 @@ func ComputeBlockLatencyTimeSeries(
 	ctx context.Context, coll *mongo.Collection,
 	from, to time.Time,
 ) ([]types.BlockLatencyPoint, error) {
-	matchTime := bson.D{{"$match", bson.D{
-		{"timestamp", bson.D{
-			{"$gte", from},
-			{"$lte", to},
-		}},
-		{"type", "sendVote"},
-	}}}
-	pipeline := mongo.Pipeline{
-		matchTime,
-		{{"$match", bson.D{{"type", "sendVote"}}}},
-		{{"$lookup", bson.D{
-			{"from", "events"},
-			{"let", bson.D{
-				{"h", "$vote.height"},
-				{"r", "$vote.round"},
-				{"vIdx", "$vote.validatorIndex"},
-				{"sendTs", "$timestamp"},
-				{"snd", "$nodeId"},
-				{"recPe", "$recipientPeerId"},
-			}},
-			{"pipeline", mongo.Pipeline{
-				{{"$match", bson.D{{"$expr", bson.D{{"$and", bson.A{
-					bson.D{{"$eq", bson.A{"$type", "receiveVote"}}},
-					bson.D{{"$eq", bson.A{"$vote.height", "$$h"}}},
-					bson.D{{"$eq", bson.A{"$vote.round", "$$r"}}},
-					bson.D{{"$eq", bson.A{"$vote.validatorIndex", "$$vIdx"}}},
-					bson.D{{"$eq", bson.A{"$sourcePeerId", "$$snd"}}},
-					bson.D{{"$eq", bson.A{"$nodeId", "$$recPe"}}},
-				}}}}}}},
-				{{"$project", bson.D{
-					{"height", "$$h"},
-					{"sender", "$$snd"},
-					{"receiver", "$$recPe"},
-					{"latencyMs", bson.D{{"$subtract", bson.A{"$timestamp", "$$sendTs"}}}},
-				}}},
-			}},
-			{"as", "recvDocs"},
-		}}},
-		{{"$unwind", "$recvDocs"}},
-		{{"$replaceRoot", bson.D{{"newRoot", "$recvDocs"}}}},
-		{{"$sort", bson.D{{"height", 1}}}},
-	}
-
-	opts := options.Aggregate().SetAllowDiskUse(true)
-	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	series := []types.BlockLatencyPoint{}
+	err := forEachVoteLatency(ctx, coll, from, to, func(sample voteLatencySample) {
+		series = append(series, types.BlockLatencyPoint{
+			Height:    sample.Height,
+			Sender:    sample.Sender,
+			Receiver:  sample.Receiver,
+			LatencyMs: float32(sample.LatencyMs),
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer cur.Close(ctx)
+	return series, nil
+}
 
-	var series []types.BlockLatencyPoint
-	if err := cur.All(ctx, &series); err != nil {
-		return nil, err
+// HistogramOptions configures the latency histogram. Explicit Boundaries (ms,
+// ascending) take precedence over Buckets, the number of automatic buckets.
+type HistogramOptions struct {
+	Buckets    int
+	Boundaries []float64
+}
+
+// buildHistogram distributes the latencies into buckets, sorting them in place.
+// With explicit boundaries the samples below the first one go to an underflow
+// bucket from 0, or from the smallest sample when it is negative, and those
+// on or above the last one to an overflow bucket whose upper bound is the
+// largest sample. Otherwise, like $bucketAuto, the
+// samples are split into buckets of about equal count where equal values never
+// span two buckets, and each bucket ends where the next one starts.
+func buildHistogram(latencies []float64, histogram HistogramOptions) []types.LatencyHistogramBucket {
+	buckets := []types.LatencyHistogramBucket{}
+	if len(latencies) == 0 {
+		return buckets
 	}
-	return series, nil
+	sort.Float64s(latencies)
+
+	if boundaries := histogram.Boundaries; len(boundaries) > 0 {
+		counts := make([]int64, len(boundaries)-1)
+		underflow := types.LatencyHistogramBucket{
+			Lower:     float32(min(latencies[0], 0)),
+			Upper:     float32(boundaries[0]),
+			Underflow: true,
+		}
+		overflow := types.LatencyHistogramBucket{Lower: float32(boundaries[len(boundaries)-1]), Overflow: true}
+		for _, latency := range latencies {
+			// Index of the first boundary above the latency
+			i := sort.SearchFloat64s(boundaries, latency)
+			if i < len(boundaries) && boundaries[i] == latency {
+				i++
+			}
+			switch i {
+			case 0:
+				underflow.Count++
+			case len(boundaries):
+				overflow.Count++
+				overflow.Upper = float32(latency)
+			default:
+				counts[i-1]++
+			}
+		}
+		if underflow.Count > 0 {
+			buckets = append(buckets, underflow)
+		}
+		for i, count := range counts {
+			if count > 0 {
+				buckets = append(buckets, types.LatencyHistogramBucket{
+					Lower: float32(boundaries[i]),
+					Upper: float32(boundaries[i+1]),
+					Count: count,
+				})
+			}
+		}
+		if overflow.Count > 0 {
+			buckets = append(buckets, overflow)
+		}
+		return buckets
+	}
+
+	perBucket := (len(latencies) + histogram.Buckets - 1) / histogram.Buckets
+	for start := 0; start < len(latencies); {
+		end := min(start+perBucket, len(latencies))
+		for end < len(latencies) && latencies[end] == latencies[end-1] {
+			end++
+		}
+		bucket := types.LatencyHistogramBucket{Lower: float32(latencies[start]), Count: int64(end - start)}
+		if end < len(latencies) {
+			bucket.Upper = float32(latencies[end])
+		} else {
+			bucket.Upper = float32(latencies[end-1])
+		}
+		buckets = append(buckets, bucket)
+		start = end
+	}
+	return buckets
 }
 
-// 3. Latency distribution (histogram via bucketAuto) & jitter (stdDev) per pair
+// 3. Latency distribution (histogram) & jitter (stdDev) per pair
+//
+// Latencies come from forEachVoteLatency, which matches the sendVote events of
+// the window with their receiveVote events (received up to voteReceiveSlack
+// after the window) and measures them in milliseconds from the event timestamps.
 func ComputeLatencyStats(
 	ctx context.Context, coll *mongo.Collection,
-	from, to time.Time,
+	from, to time.Time, histogram HistogramOptions,
 ) (*types.LatencyStats, error) {
-	matchTime := bson.D{{"$match", bson.D{
-		{"timestamp", bson.D{
-			{"$gte", from},
-			{"$lte", to},
-		}},
-		{"type", "sendVote"},
-	}}}
-	// facet into histogram and stddev facets
-	pipeline := mongo.Pipeline{
-		matchTime,
-		{{"$match", bson.D{{"type", "sendVote"}}}},
-		{{"$lookup", bson.D{
-			{"from", "events"},
-			{"let", bson.D{
-				{"h", "$vote.height"},
-				{"r", "$vote.round"},
-				{"vIdx", "$vote.validatorIndex"},
-				{"sendTs", "$timestamp"},
-				{"snd", "$nodeId"},
-				{"recPe", "$recipientPeerId"},
-			}},
-			{"pipeline", mongo.Pipeline{
-				{{"$match", bson.D{{"$expr", bson.D{{"$and", bson.A{
-					bson.D{{"$eq", bson.A{"$type", "receiveVote"}}},
-					bson.D{{"$eq", bson.A{"$vote.height", "$$h"}}},
-					bson.D{{"$eq", bson.A{"$vote.round", "$$r"}}},
-					bson.D{{"$eq", bson.A{"$vote.validatorIndex", "$$vIdx"}}},
-					bson.D{{"$eq", bson.A{"$sourcePeerId", "$$snd"}}},
-					bson.D{{"$eq", bson.A{"$nodeId", "$$recPe"}}},
-				}}}}}}},
-				{{"$project", bson.D{
-					{"sender", "$$snd"},
-					{"receiver", "$$recPe"},
-					{"latencyMs", bson.D{{"$subtract", bson.A{"$timestamp", "$$sendTs"}}}},
-				}}},
-			}},
-			{"as", "r"},
-		}}},
-		{{"$unwind", "$r"}},
-		{{"$replaceRoot", bson.D{{"newRoot", "$r"}}}},
-
-		// now facet
-		{{"$facet", bson.D{
-			{"jitter", bson.A{
-				bson.D{{"$group", bson.D{
-					{"_id", bson.D{{"sender", "$sender"}, {"receiver", "$receiver"}}},
-					{"stdDevMs", bson.D{{"$stdDevSamp", "$latencyMs"}}},
-				}}},
-				bson.D{{"$project", bson.D{
-					{"_id", 0},
-					{"sender", "$_id.sender"},
-					{"receiver", "$_id.receiver"},
-					{"stdDevMs", 1},
-				}}},
-			}},
-			{"histogram", bson.A{
-				bson.D{{"$bucketAuto", bson.D{
-					{"groupBy", "$latencyMs"},
-					{"buckets", 10},
-				}}},
-			}},
-		}}},
+	type pairKey struct{ Sender, Receiver string }
+	type pairMoments struct {
+		Count      int
+		Sum, SumSq float64
 	}
 
-	opts := options.Aggregate().SetAllowDiskUse(true)
-	cur, err := coll.Aggregate(ctx, pipeline, opts)
+	var latencies []float64
+	moments := map[pairKey]*pairMoments{}
+	var pairs []pairKey
+	err := forEachVoteLatency(ctx, coll, from, to, func(sample voteLatencySample) {
+		latencies = append(latencies, sample.LatencyMs)
+
+		key := pairKey{Sender: sample.Sender, Receiver: sample.Receiver}
+		m, ok := moments[key]
+		if !ok {
+			m = &pairMoments{}
+			moments[key] = m
+			pairs = append(pairs, key)
+		}
+		m.Count++
+		m.Sum += sample.LatencyMs
+		m.SumSq += sample.LatencyMs * sample.LatencyMs
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer cur.Close(ctx)
 
-	var faceted []struct {
-		Jitter    []types.LatencyJitter          `bson:"jitter"`
-		Histogram []types.LatencyHistogramBucket `bson:"histogram"`
-	}
-	if err = cur.All(ctx, &faceted); err != nil {
-		return nil, err
-	}
-	if len(faceted) == 0 {
-		return nil, nil // no data
+	// Sample standard deviation, 0 for pairs with a single sample
+	jitter := make([]types.LatencyJitter, 0, len(pairs))
+	for _, key := range pairs {
+		m := moments[key]
+		var stdDev float64
+		if m.Count > 1 {
+			mean := m.Sum / float64(m.Count)
+			variance := (m.SumSq - float64(m.Count)*mean*mean) / float64(m.Count-1)
+			stdDev = math.Sqrt(math.Max(variance, 0))
+		}
+		jitter = append(jitter, types.LatencyJitter{Sender: key.Sender, Receiver: key.Receiver, StdDevMs: float32(stdDev)})
 	}
+
 	return &types.LatencyStats{
-		Histogram: faceted[0].Histogram,
-		Jitter:    faceted[0].Jitter,
+		Histogram: buildHistogram(latencies, histogram),
+		Jitter:    jitter,
 	}, nil
 }
 
-// 4. Message success & loss rate per block, per pair
-func ComputeMessageSuccessRate(
-	ctx context.Context, coll *mongo.Collection,
-	from, to time.Time,
-) ([]types.MessageSuccessRate, error) {
-	matchTime := bson.D{{"$match", bson.D{
+// messageEventsMatch matches the sendVote and receiveVote events in the window
+// by their own timestamps
+func messageEventsMatch(from, to time.Time, heights types.HeightRange) bson.D {
+	return bson.D{{"$match", withHeightRange(bson.D{
 		{"timestamp", bson.D{
 			{"$gte", from},
 			{"$lte", to},
 		}},
-		{"type", "sendVote"},
-	}}}
+		{"type", bson.D{{"$in", bson.A{"sendVote", "receiveVote"}}}},
+	}, "vote.height", heights)}}
+}
+
+// messagePairProjection derives the sender→receiver pair of sendVote and
+// receiveVote events, as [sender, receiver] in pair, and flags them as sent or
+// received. extra fields are kept for grouping.
+func messagePairProjection(extra ...bson.E) bson.D {
+	fields := append(bson.D{}, extra...)
+	fields = append(fields,
+		bson.E{"pair", bson.D{{"$cond", bson.A{
+			bson.D{{"$eq", bson.A{"$type", "sendVote"}}}, bson.A{"$nodeId", "$recipientPeerId"},
+			bson.A{"$sourcePeerId", "$nodeId"},
+		}}}},
+		bson.E{"sent", bson.D{{"$cond", bson.A{
+			bson.D{{"$eq", bson.A{"$type", "sendVote"}}}, 1, 0,
+		}}}},
+		bson.E{"recv", bson.D{{"$cond", bson.A{
+			bson.D{{"$eq", bson.A{"$type", "receiveVote"}}}, 1, 0,
+		}}}},
+	)
+	return bson.D{{"$project", fields}}
+}
+
+// successRateExpr is recvCnt / sentCnt, 0 without sends
+var successRateExpr = bson.D{{"$cond", bson.A{
+	bson.D{{"$eq", bson.A{"$sentCnt", 0}}}, 0,
+	bson.D{{"$divide", bson.A{"$recvCnt", "$sentCnt"}}},
+}}}
+
+// 4. Message success & loss rate per block, per pair
+//
+// aggregate collapses the per (height, pair) counts: "pair" over heights,
+// "height" over pairs and "overall" into a single row; empty keeps them.
+func ComputeMessageSuccessRate(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange, aggregate string,
+) ([]types.MessageSuccessRate, error) {
 	pipeline := mongo.Pipeline{
-		matchTime,
-		{{"$match", bson.D{{"type", bson.D{{"$in", bson.A{"sendVote", "receiveVote"}}}}}}},
-		{{"$project", bson.D{
-			{"height", "$vote.height"},
-			{"pair", bson.D{{"$cond", bson.A{
-				bson.D{{"$eq", bson.A{"$type", "sendVote"}}}, bson.A{"$nodeId", "$recipientPeerId"},
-				bson.A{"$sourcePeerId", "$nodeId"},
-			}}}},
-			{"sent", bson.D{{"$cond", bson.A{
-				bson.D{{"$eq", bson.A{"$type", "sendVote"}}}, 1, 0,
-			}}}},
-			{"recv", bson.D{{"$cond", bson.A{
-				bson.D{{"$eq", bson.A{"$type", "receiveVote"}}}, 1, 0,
-			}}}},
-		}}},
+		messageEventsMatch(from, to, heights),
+		messagePairProjection(bson.E{"height", "$vote.height"}),
 		{{"$group", bson.D{
 			{"_id", bson.D{
 				{"height", "$height"},
@@ -272,20 +372,37 @@ func ComputeMessageSuccessRate(
 			{"sentCnt", bson.D{{"$sum", "$sent"}}},
 			{"recvCnt", bson.D{{"$sum", "$recv"}}},
 		}}},
-		{{"$project", bson.D{
+	}
+
+	var regroupKey any
+	switch aggregate {
+	case "pair":
+		regroupKey = bson.D{{"sender", "$_id.sender"}, {"receiver", "$_id.receiver"}}
+	case "height":
+		regroupKey = bson.D{{"height", "$_id.height"}}
+	case "overall":
+		regroupKey = nil
+	}
+	if aggregate != "" {
+		pipeline = append(pipeline, bson.D{{"$group", bson.D{
+			{"_id", regroupKey},
+			{"sentCnt", bson.D{{"$sum", "$sentCnt"}}},
+			{"recvCnt", bson.D{{"$sum", "$recvCnt"}}},
+		}}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{"$project", bson.D{
 			{"_id", 0},
 			{"height", "$_id.height"},
 			{"sender", "$_id.sender"},
 			{"receiver", "$_id.receiver"},
 			{"sentCnt", 1},
 			{"recvCnt", 1},
-			{"successRate", bson.D{{"$cond", bson.A{
-				bson.D{{"$eq", bson.A{"$sentCnt", 0}}}, 0,
-				bson.D{{"$divide", bson.A{"$recvCnt", "$sentCnt"}}},
-			}}}},
+			{"successRate", successRateExpr},
 		}}},
-		{{"$sort", bson.D{{"height", 1}}}},
-	}
+		bson.D{{"$sort", bson.D{{"height", 1}, {"sender", 1}, {"receiver", 1}}}},
+	)
 
 	opts := options.Aggregate().SetAllowDiskUse(true)
 	cur, err := coll.Aggregate(ctx, pipeline, opts)
@@ -301,58 +418,55 @@ func ComputeMessageSuccessRate(
 	return rates, nil
 }
 
-// 5. Block end-to-end consensus latency per height (EnteringNewRound → ReceivedCompleteProposalBlock)
-func ComputeBlockEndToEndLatencyByHeight(
+// ComputeMessageSuccessRateTimeSeries returns, per sender→receiver pair, the
+// sends, receives and success rate of votes per time bucket of the given
+// resolution. Sends and receives are counted in the buckets of their own
+// timestamps, so a bucket's rate can exceed 1 when receives trail their sends
+// across a bucket boundary. Pairs are ordered by their mean bucket success
+// rate, worst first, and limited to topN when it is positive.
+func ComputeMessageSuccessRateTimeSeries(
 	ctx context.Context, coll *mongo.Collection,
-	from, to time.Time,
-) ([]types.BlockConsensusLatency, error) {
-	matchTime := bson.D{{"$match", bson.D{
-		{"timestamp", bson.D{
-			{"$gte", from},
-			{"$lte", to},
-		}},
-		{"type", "sendVote"},
-	}}}
+	from, to time.Time, heights types.HeightRange, resolution time.Duration, topN int,
+) ([]types.DeliverySeries, error) {
 	pipeline := mongo.Pipeline{
-		matchTime,
-		{{"$match", bson.D{{"type", "enteringNewRound"}}}},
-		{{"$lookup", bson.D{
-			{"from", "events"},
-			{"let", bson.D{
-				{"h", "$height"},
-				{"startTs", "$timestamp"},
-			}},
-			{"pipeline", mongo.Pipeline{
-				{{"$match", bson.D{{"$expr", bson.D{{"$and", bson.A{
-					bson.D{{"$eq", bson.A{"$type", "receivedCompleteProposalBlock"}}},
-					bson.D{{"$eq", bson.A{"$height", "$$h"}}},
-				}}}}}}},
-				{{"$project", bson.D{
-					{"height", "$$h"},
-					{"latencyMs", bson.D{{"$subtract", bson.A{"$timestamp", "$$startTs"}}}},
-				}}},
+		messageEventsMatch(from, to, heights),
+		messagePairProjection(bson.E{"timestamp", "$timestamp"}),
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"sender", bson.D{{"$arrayElemAt", bson.A{"$pair", 0}}}},
+				{"receiver", bson.D{{"$arrayElemAt", bson.A{"$pair", 1}}}},
+				{"start", bson.D{{"$dateTrunc", bson.D{
+					{"date", "$timestamp"},
+					{"unit", "millisecond"},
+					{"binSize", resolution.Milliseconds()},
+				}}}},
 			}},
-			{"as", "latencies"},
+			{"sentCnt", bson.D{{"$sum", "$sent"}}},
+			{"recvCnt", bson.D{{"$sum", "$recv"}}},
 		}}},
-		{{"$unwind", "$latencies"}},
-
-		// group by block height
+		{{"$set", bson.D{{"successRate", successRateExpr}}}},
+		{{"$sort", bson.D{{"_id.start", 1}}}},
 		{{"$group", bson.D{
-			{"_id", "$latencies.height"},
-			{"p50Ms", bson.D{{"$percentile", bson.D{
-				{"input", "$latencies.latencyMs"}, {"p", bson.A{0.50}}, {"method", "approximate"},
-			}}}},
-			{"p95Ms", bson.D{{"$percentile", bson.D{
-				{"input", "$latencies.latencyMs"}, {"p", bson.A{0.95}}, {"method", "approximate"},
+			{"_id", bson.D{{"sender", "$_id.sender"}, {"receiver", "$_id.receiver"}}},
+			{"avgSuccessRate", bson.D{{"$avg", "$successRate"}}},
+			{"points", bson.D{{"$push", bson.D{
+				{"start", "$_id.start"},
+				{"sentCnt", "$sentCnt"},
+				{"recvCnt", "$recvCnt"},
+				{"successRate", "$successRate"},
 			}}}},
 		}}},
 		{{"$project", bson.D{
 			{"_id", 0},
-			{"height", "$_id"},
-			{"p50Ms", 1},
-			{"p95Ms", 1},
+			{"sender", "$_id.sender"},
+			{"receiver", "$_id.receiver"},
+			{"avgSuccessRate", 1},
+			{"points", 1},
 		}}},
-		{{"$sort", bson.D{{"height", 1}}}},
+		{{"$sort", bson.D{{"avgSuccessRate", 1}, {"sender", 1}, {"receiver", 1}}}},
+	}
+	if topN > 0 {
+		pipeline = append(pipeline, bson.D{{"$limit", topN}})
 	}
 
 	opts := options.Aggregate().SetAllowDiskUse(true)
@@ -362,9 +476,91 @@ func ComputeBlockEndToEndLatencyByHeight(
 	}
 	defer cur.Close(ctx)
 
-	var latencies []types.BlockConsensusLatency
-	if err := cur.All(ctx, &latencies); err != nil {
+	series := []types.DeliverySeries{}
+	if err := cur.All(ctx, &series); err != nil {
 		return nil, err
 	}
+	return series, nil
+}
+
+// blockConsensusLatencyPercentiles are the p50 and p95 of each BlockConsensusLatency
+var blockConsensusLatencyPercentiles = []float64{0.50, 0.95}
+
+// 5. Block end-to-end consensus latency per height (EnteringNewRound → ReceivedCompleteProposalBlock)
+//
+// Each node's latency runs from its first enteringNewRound of the height to its
+// first receivedCompleteProposalBlock of the height; the percentiles are taken
+// over nodes. Zero from/to leave the window open on that side.
+func ComputeBlockEndToEndLatencyByHeight(
+	ctx context.Context, coll *mongo.Collection,
+	from, to time.Time, heights types.HeightRange,
+) ([]types.BlockConsensusLatency, error) {
+	match := withHeightRange(bson.D{
+		{"type", bson.D{{"$in", bson.A{"enteringNewRound", "receivedCompleteProposalBlock"}}}},
+	}, "height", heights)
+	timeFilter := bson.D{}
+	if !from.IsZero() {
+		timeFilter = append(timeFilter, bson.E{"$gte", from})
+	}
+	if !to.IsZero() {
+		timeFilter = append(timeFilter, bson.E{"$lte", to})
+	}
+	if len(timeFilter) > 0 {
+		match = append(match, bson.E{"timestamp", timeFilter})
+	}
+
+	latencyMs := bson.D{{"$toDouble", bson.D{{"$subtract", bson.A{"$blockTime", "$startTime"}}}}}
+	build := func(native bool) mongo.Pipeline {
+		pipeline := mongo.Pipeline{
+			{{"$match", match}},
+			{{"$group", bson.D{
+				{"_id", bson.D{{"nodeId", "$nodeId"}, {"height", "$height"}}},
+				// $min ignores the nulls of the other event type
+				{"startTime", bson.D{{"$min", bson.D{{"$cond", bson.A{
+					bson.D{{"$eq", bson.A{"$type", "enteringNewRound"}}}, "$timestamp", nil,
+				}}}}}},
+				{"blockTime", bson.D{{"$min", bson.D{{"$cond", bson.A{
+					bson.D{{"$eq", bson.A{"$type", "receivedCompleteProposalBlock"}}}, "$timestamp", nil,
+				}}}}}},
+			}}},
+			{{"$match", bson.D{
+				{"startTime", bson.D{{"$ne", nil}}},
+				{"blockTime", bson.D{{"$ne", nil}}},
+				{"$expr", bson.D{{"$gte", bson.A{"$blockTime", "$startTime"}}}},
+			}}},
+		}
+
+		// group by block height
+		return append(append(pipeline, percentileRanks(native, "$_id.height", latencyMs)...),
+			bson.D{{"$group", bson.D{
+				{"_id", "$_id.height"},
+				{"percentiles", rankedPercentiles(native, latencyMs, blockConsensusLatencyPercentiles)},
+			}}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		)
+	}
+
+	cur, err := aggregatePercentiles(ctx, coll, build)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []struct {
+		Height      uint64                 `bson:"_id"`
+		Percentiles accumulatedPercentiles `bson:"percentiles"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	latencies := make([]types.BlockConsensusLatency, 0, len(docs))
+	for _, doc := range docs {
+		latency := types.BlockConsensusLatency{Height: doc.Height}
+		if quantiles := doc.Percentiles.quantiles(blockConsensusLatencyPercentiles); quantiles != nil {
+			latency.P50Ms, latency.P95Ms = float32(quantiles[0]), float32(quantiles[1])
+		}
+		latencies = append(latencies, latency)
+	}
 	return latencies, nil
 }
@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db/dbtest"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestBuildHistogram(t *testing.T) {
+	type bucket = types.LatencyHistogramBucket
+	tests := []struct {
+		name      string
+		latencies []float64
+		histogram HistogramOptions
+		want      []bucket
+	}{
+		{name: "no samples with boundaries", histogram: HistogramOptions{Boundaries: []float64{0, 10}}, want: []bucket{}},
+		{name: "no samples with automatic buckets", histogram: HistogramOptions{Buckets: 4}, want: []bucket{}},
+		{
+			name:      "samples on a boundary go to the bucket starting there",
+			latencies: []float64{19.9, 10, 0, 5},
+			histogram: HistogramOptions{Boundaries: []float64{0, 10, 20}},
+			want:      []bucket{{Lower: 0, Upper: 10, Count: 2}, {Lower: 10, Upper: 20, Count: 2}},
+		},
+		{
+			name:      "samples below the first boundary underflow, on and above the last overflow",
+			latencies: []float64{25, -1, 5, 20},
+			histogram: HistogramOptions{Boundaries: []float64{0, 10, 20}},
+			want: []bucket{
+				{Lower: -1, Upper: 0, Count: 1, Underflow: true},
+				{Lower: 0, Upper: 10, Count: 1},
+				{Lower: 20, Upper: 25, Count: 2, Overflow: true},
+			},
+		},
+		{
+			name:      "underflow of positive samples starts at 0",
+			latencies: []float64{2, 3, 7},
+			histogram: HistogramOptions{Boundaries: []float64{5, 10}},
+			want:      []bucket{{Lower: 0, Upper: 5, Count: 2, Underflow: true}, {Lower: 5, Upper: 10, Count: 1}},
+		},
+		{
+			name:      "empty buckets are omitted",
+			latencies: []float64{1, 25},
+			histogram: HistogramOptions{Boundaries: []float64{0, 10, 20, 30}},
+			want:      []bucket{{Lower: 0, Upper: 10, Count: 1}, {Lower: 20, Upper: 30, Count: 1}},
+		},
+		{
+			name:      "automatic buckets of equal count end where the next starts",
+			latencies: []float64{4, 2, 3, 1},
+			histogram: HistogramOptions{Buckets: 2},
+			want:      []bucket{{Lower: 1, Upper: 3, Count: 2}, {Lower: 3, Upper: 4, Count: 2}},
+		},
+		{
+			name:      "equal values never span automatic buckets",
+			latencies: []float64{1, 2, 1, 1},
+			histogram: HistogramOptions{Buckets: 2},
+			want:      []bucket{{Lower: 1, Upper: 2, Count: 3}, {Lower: 2, Upper: 2, Count: 1}},
+		},
+		{
+			name:      "more automatic buckets than distinct values",
+			latencies: []float64{5, 5, 5},
+			histogram: HistogramOptions{Buckets: 3},
+			want:      []bucket{{Lower: 5, Upper: 5, Count: 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildHistogram(tt.latencies, tt.histogram); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildHistogram(%v) = %+v, want %+v", tt.latencies, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPairLatencyMatchFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter PairLatencyFilter
+		want   bson.D // Conditions after sentTime and status
+	}{
+		{name: "no filters", want: bson.D{}},
+		{name: "senders", filter: PairLatencyFilter{Senders: []string{"a", "b"}}, want: bson.D{{"senderPeerId", bson.D{{"$in", []string{"a", "b"}}}}}},
+		{name: "receivers", filter: PairLatencyFilter{Receivers: []string{"c"}}, want: bson.D{{"recipientPeerId", bson.D{{"$in", []string{"c"}}}}}},
+		{
+			name:   "node matches either side",
+			filter: PairLatencyFilter{Nodes: []string{"a"}},
+			want: bson.D{{"$or", bson.A{
+				bson.D{{"senderPeerId", bson.D{{"$in", []string{"a"}}}}},
+				bson.D{{"recipientPeerId", bson.D{{"$in", []string{"a"}}}}},
+			}}},
+		},
+		{
+			name:   "filters are ANDed",
+			filter: PairLatencyFilter{Senders: []string{"a"}, Receivers: []string{"c"}, Nodes: []string{"b"}},
+			want: bson.D{
+				{"senderPeerId", bson.D{{"$in", []string{"a"}}}},
+				{"recipientPeerId", bson.D{{"$in", []string{"c"}}}},
+				{"$or", bson.A{
+					bson.D{{"senderPeerId", bson.D{{"$in", []string{"b"}}}}},
+					bson.D{{"recipientPeerId", bson.D{{"$in", []string{"b"}}}}},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := pairLatencyMatch(time.Time{}, time.Time{}, types.HeightRange{}, tt.filter)
+			if got := match[2:]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filters = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageEventsMatch(t *testing.T) {
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Minute)
+	height := int64(7)
+
+	got := messageEventsMatch(from, to, types.HeightRange{From: &height})
+	want := bson.D{{"$match", bson.D{
+		{"timestamp", bson.D{{"$gte", from}, {"$lte", to}}},
+		{"type", bson.D{{"$in", bson.A{"sendVote", "receiveVote"}}}},
+		{"vote.height", bson.D{{"$gte", height}}},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messageEventsMatch = %v, want %v", got, want)
+	}
+}
+
+func TestNanosToMsExpr(t *testing.T) {
+	want := bson.D{{"$divide", bson.A{"$latency", 1e6}}}
+	if got := nanosToMsExpr("$latency"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nanosToMsExpr = %v, want %v", got, want)
+	}
+
+	coll := dbtest.Database(t).Collection("vote_latencies")
+	ctx := context.Background()
+	if _, err := coll.InsertOne(ctx, bson.D{{"latency", int64(1500 * time.Microsecond)}}); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{{{"$project", bson.D{{"ms", nanosToMsExpr("$latency")}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Ms float64 `bson:"ms"`
+	}
+	if err := cursor.All(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Ms != 1.5 {
+		t.Errorf("projected %+v, want 1.5 ms", got)
+	}
+}
+
+// messageStart is the start of the window of the message success rate tests
+var messageStart = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// seedMessage inserts the sendVote of sender to receiver at height, sent ms
+// after messageStart, and its receiveVote latencyMs later unless it is negative
+func seedMessage(t *testing.T, coll *mongo.Collection, height int64, sender, receiver string, ms, latencyMs int) {
+	t.Helper()
+	sent := messageStart.Add(time.Duration(ms) * time.Millisecond)
+	events := []any{bson.D{
+		{"type", "sendVote"}, {"nodeId", sender}, {"recipientPeerId", receiver},
+		{"timestamp", sent}, {"vote", bson.D{{"height", height}}},
+	}}
+	if latencyMs >= 0 {
+		events = append(events, bson.D{
+			{"type", "receiveVote"}, {"nodeId", receiver}, {"sourcePeerId", sender},
+			{"timestamp", sent.Add(time.Duration(latencyMs) * time.Millisecond)}, {"vote", bson.D{{"height", height}}},
+		})
+	}
+	if _, err := coll.InsertMany(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestComputeMessageSuccessRate(t *testing.T) {
+	coll := dbtest.Database(t).Collection("tracer_events")
+	to := messageStart.Add(time.Second)
+
+	// Every vote of height 1 arrives, within the window
+	seedMessage(t, coll, 1, "node0", "node1", 0, 10)
+	seedMessage(t, coll, 1, "node1", "node0", 5, 10)
+	seedMessage(t, coll, 1, "node0", "node1", 100, 20)
+	// At height 2 node0's second vote is received after the window ends, and
+	// node1's vote is lost
+	seedMessage(t, coll, 2, "node0", "node1", 500, 10)
+	seedMessage(t, coll, 2, "node0", "node1", 995, 10)
+	seedMessage(t, coll, 2, "node1", "node0", 600, -1)
+	// Events of other types carrying a vote are not messages
+	if _, err := coll.InsertOne(context.Background(), bson.D{
+		{"type", "addVote"}, {"nodeId", "node1"}, {"timestamp", messageStart}, {"vote", bson.D{{"height", 1}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	one, two := int64(1), int64(2)
+	tests := []struct {
+		name      string
+		heights   types.HeightRange
+		aggregate string
+		want      []types.MessageSuccessRate
+	}{
+		{
+			name:    "balanced sends and receives are delivered in full",
+			heights: types.HeightRange{From: &one, To: &one},
+			want: []types.MessageSuccessRate{
+				{Height: 1, Sender: "node0", Receiver: "node1", SentCount: 2, RecvCount: 2, SuccessRate: 1},
+				{Height: 1, Sender: "node1", Receiver: "node0", SentCount: 1, RecvCount: 1, SuccessRate: 1},
+			},
+		},
+		{
+			name:    "receives after the window are not counted",
+			heights: types.HeightRange{From: &two},
+			want: []types.MessageSuccessRate{
+				{Height: 2, Sender: "node0", Receiver: "node1", SentCount: 2, RecvCount: 1, SuccessRate: 0.5},
+				{Height: 2, Sender: "node1", Receiver: "node0", SentCount: 1, RecvCount: 0, SuccessRate: 0},
+			},
+		},
+		{
+			name:      "per pair",
+			aggregate: "pair",
+			want: []types.MessageSuccessRate{
+				{Sender: "node0", Receiver: "node1", SentCount: 4, RecvCount: 3, SuccessRate: 0.75},
+				{Sender: "node1", Receiver: "node0", SentCount: 2, RecvCount: 1, SuccessRate: 0.5},
+			},
+		},
+		{
+			name:      "overall",
+			aggregate: "overall",
+			want:      []types.MessageSuccessRate{{SentCount: 6, RecvCount: 4, SuccessRate: 4.0 / 6}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ComputeMessageSuccessRate(context.Background(), coll, messageStart, to, tt.heights, tt.aggregate)
+			if err != nil {
+				t.Fatalf("ComputeMessageSuccessRate: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rates = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
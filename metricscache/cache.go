@@ -0,0 +1,111 @@
+package metricscache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName is the collection of a simulation's database holding its cached metric responses
+const CollectionName = "metrics_cache"
+
+// NoCacheParam is the query parameter that bypasses the cache; it is not part of the key
+const NoCacheParam = "noCache"
+
+// ResolvePeersParam is the query parameter translating peer IDs of a response
+// to node IDs. The translation applies to the cached response, so it is not
+// part of the key either.
+const ResolvePeersParam = "resolvePeers"
+
+// Entry is a cached metric response
+type Entry struct {
+	ID         string    `bson:"_id"`
+	Metric     string    `bson:"metric"`
+	ParamsHash string    `bson:"paramsHash"`
+	Params     string    `bson:"params"`
+	Response   []byte    `bson:"response"`
+	ComputedAt time.Time `bson:"computedAt"`
+}
+
+var hits, misses atomic.Int64
+
+// Stats returns the number of cache hits and misses since startup
+func Stats() (hitCount, missCount int64) {
+	return hits.Load(), misses.Load()
+}
+
+// RecordHit counts a cache hit
+func RecordHit() { hits.Add(1) }
+
+// RecordMiss counts a cache miss
+func RecordMiss() { misses.Add(1) }
+
+// CanonicalParams encodes the query parameters sorted by name and value,
+// leaving out NoCacheParam and ResolvePeersParam, so equivalent requests share a cache entry
+func CanonicalParams(query url.Values) string {
+	canonical := url.Values{}
+	for name, values := range query {
+		if name == NoCacheParam || name == ResolvePeersParam {
+			continue
+		}
+		sorted := append([]string(nil), values...)
+		sort.Strings(sorted)
+		canonical[name] = sorted
+	}
+	// Encode sorts by name
+	return canonical.Encode()
+}
+
+// Key returns the cache entry ID and the params hash of a metric with the given query parameters
+func Key(metric string, query url.Values) (id, paramsHash, params string) {
+	params = CanonicalParams(query)
+	sum := sha256.Sum256([]byte(params))
+	paramsHash = hex.EncodeToString(sum[:])
+	return metric + ":" + paramsHash, paramsHash, params
+}
+
+// Get looks up the cached response of a metric, returning nil when there is none
+func Get(ctx context.Context, db *mongo.Database, metric string, query url.Values) (*Entry, error) {
+	id, _, _ := Key(metric, query)
+	var entry Entry
+	err := db.Collection(CollectionName).FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Put stores the response of a metric, replacing any previous entry
+func Put(ctx context.Context, db *mongo.Database, metric string, query url.Values, response []byte) error {
+	id, paramsHash, params := Key(metric, query)
+	entry := Entry{
+		ID:         id,
+		Metric:     metric,
+		ParamsHash: paramsHash,
+		Params:     params,
+		Response:   response,
+		ComputedAt: time.Now(),
+	}
+	_, err := db.Collection(CollectionName).ReplaceOne(ctx, bson.M{"_id": id}, entry, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Drop removes all cached responses of a simulation's database
+func Drop(ctx context.Context, db *mongo.Database) error {
+	if err := db.Collection(CollectionName).Drop(ctx); err != nil {
+		return fmt.Errorf("drop metrics cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APITokensFromEnv reads the bearer tokens of API_TOKENS, comma separated
+// token:userId entries or token:admin for an admin caller not bound to a user
+func APITokensFromEnv() (map[string]Caller, error) {
+	tokens := map[string]Caller{}
+	for _, entry := range strings.Split(os.Getenv("API_TOKENS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		token, subject, ok := strings.Cut(entry, ":")
+		token, subject = strings.TrimSpace(token), strings.TrimSpace(subject)
+		if !ok || token == "" {
+			return nil, fmt.Errorf("invalid API_TOKENS entry: expected token:userId or token:admin")
+		}
+		if _, duplicate := tokens[token]; duplicate {
+			return nil, fmt.Errorf("invalid API_TOKENS: a token is listed twice")
+		}
+		if subject == RoleAdmin {
+			tokens[token] = Caller{Role: RoleAdmin}
+			continue
+		}
+		userID, err := primitive.ObjectIDFromHex(subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_TOKENS user ID %q", subject)
+		}
+		tokens[token] = Caller{UserID: userID}
+	}
+	return tokens, nil
+}
+
+// AuthenticationMiddleware sets the Caller of the bearer token in the
+// Authorization header under CallerKey, and rejects requests without a known
+// token with 401
+func AuthenticationMiddleware(tokens map[string]Caller) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok {
+			// Every token is compared in constant time, so the time taken does
+			// not tell how close a guess came
+			var caller Caller
+			found := false
+			for token, tokenCaller := range tokens {
+				if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+					caller, found = tokenCaller, true
+				}
+			}
+			if found {
+				c.Set(CallerKey, caller)
+				c.Next()
+				return
+			}
+		}
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		c.Abort()
+	})
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CallerKey is the context key under which AuthenticationMiddleware stores the Caller of a request
+const CallerKey = "caller"
+
+// RoleAdmin may access the resources of every user
+const RoleAdmin = "admin"
+
+// Caller identifies the authenticated user of a request
+type Caller struct {
+	UserID primitive.ObjectID
+	Role   string
+}
+
+// CallerFromContext returns the authenticated caller, or false for unauthenticated requests
+func CallerFromContext(c *gin.Context) (Caller, bool) {
+	value, ok := c.Get(CallerKey)
+	if !ok {
+		return Caller{}, false
+	}
+	caller, ok := value.(Caller)
+	return caller, ok
+}
+
+// CanAccess reports whether caller may access a resource owned by ownerID
+func CanAccess(caller Caller, ownerID primitive.ObjectID) bool {
+	return caller.Role == RoleAdmin || caller.UserID == ownerID
+}
+
+// OwnershipMiddleware rejects requests for users, projects and simulations
+// the caller does not own with 403, and requests without a caller with 401.
+// The owner is resolved from the :userId, :projectId and, below /simulations,
+// :id route params. Unknown resources pass and are reported by the handlers.
+func OwnershipMiddleware(projects, simulations *mongo.Collection) gin.HandlerFunc {
+	return ownershipMiddleware(collectionOwner(projects), collectionOwner(simulations))
+}
+
+// ownerLookup returns the userId of the project or simulation with id, or none if it does not exist
+type ownerLookup func(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error)
+
+// ownershipMiddleware is OwnershipMiddleware with the owners of projects and
+// simulations resolved by the given lookups
+func ownershipMiddleware(projectOwner, simulationOwner ownerLookup) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		caller, ok := requireCaller(c)
+		if !ok {
+			return
+		}
+
+		var owners []primitive.ObjectID
+		if userID, err := primitive.ObjectIDFromHex(c.Param("userId")); err == nil {
+			owners = append(owners, userID)
+		}
+		if projectID, err := primitive.ObjectIDFromHex(c.Param("projectId")); err == nil {
+			owner, err := projectOwner(c.Request.Context(), projectID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				c.Abort()
+				return
+			}
+			owners = append(owners, owner...)
+		}
+		if strings.Contains(c.FullPath(), "/simulations/:id") {
+			if simulationID, err := primitive.ObjectIDFromHex(c.Param("id")); err == nil {
+				owner, err := simulationOwner(c.Request.Context(), simulationID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+					c.Abort()
+					return
+				}
+				owners = append(owners, owner...)
+			}
+		}
+
+		for _, owner := range owners {
+			if !CanAccess(caller, owner) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	})
+}
+
+// requireCaller returns the caller of the request, or rejects it with 401 and
+// returns false when there is none
+func requireCaller(c *gin.Context) (Caller, bool) {
+	caller, ok := CallerFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		c.Abort()
+	}
+	return caller, ok
+}
+
+// collectionOwner looks up owners in the userId of the documents of collection
+func collectionOwner(collection *mongo.Collection) ownerLookup {
+	return func(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+		return ownerOf(ctx, collection, id)
+	}
+}
+
+// ownerOf returns the userId of the document with id, or none if it does not exist
+func ownerOf(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	var doc struct {
+		UserID primitive.ObjectID `bson:"userId"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"userId": 1})
+	err := collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []primitive.ObjectID{doc.UserID}, nil
+}
+
+// AdminMiddleware rejects requests of a caller that is not an admin with 403,
+// and requests without a caller with 401
+func AdminMiddleware() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		caller, ok := requireCaller(c)
+		if !ok {
+			return
+		}
+		if caller.Role != RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ownerMap is an ownerLookup of the owners of known IDs
+func ownerMap(owners map[primitive.ObjectID]primitive.ObjectID) ownerLookup {
+	return func(_ context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+		if owner, ok := owners[id]; ok {
+			return []primitive.ObjectID{owner}, nil
+		}
+		return nil, nil
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	project, simulation, unknown := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	tokens := map[string]Caller{
+		"owner-token": {UserID: owner},
+		"other-token": {UserID: other},
+		"admin-token": {Role: RoleAdmin},
+	}
+
+	// The routes of each group of main.go, with the middlewares they use
+	router := gin.New()
+	v1 := router.Group("/v1", AuthenticationMiddleware(tokens), ownershipMiddleware(
+		ownerMap(map[primitive.ObjectID]primitive.ObjectID{project: owner}),
+		ownerMap(map[primitive.ObjectID]primitive.ObjectID{simulation: owner}),
+	))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	v1.GET("/users", AdminMiddleware(), ok)
+	v1.GET("/users/by-username/:username", AdminMiddleware(), ok)
+	v1.GET("/users/:userId", ok)
+	v1.GET("/users/:userId/projects", ok)
+	v1.GET("/projects/:projectId", ok)
+	v1.POST("/users/:userId/projects/:projectId/simulations", ok)
+	v1.GET("/simulations/:id", ok)
+	v1.POST("/simulations/:id/upload", ok)
+	v1.GET("/simulations/:id/metrics/latency/votes", ok)
+	v1.GET("/processing/queue", AdminMiddleware(), ok)
+
+	users := "/v1/users/" + owner.Hex()
+	projects := "/v1/projects/" + project.Hex()
+	simulations := "/v1/simulations/" + simulation.Hex()
+	tests := []struct {
+		group, method, path string
+		owner               int // Status for the owner
+		nonOwner            int
+	}{
+		{"user listing", http.MethodGet, "/v1/users", http.StatusForbidden, http.StatusForbidden},
+		{"user listing", http.MethodGet, "/v1/users/by-username/alice", http.StatusForbidden, http.StatusForbidden},
+		{"users", http.MethodGet, users, http.StatusOK, http.StatusForbidden},
+		{"users", http.MethodGet, users + "/projects", http.StatusOK, http.StatusForbidden},
+		{"projects", http.MethodGet, projects, http.StatusOK, http.StatusForbidden},
+		{"uploads", http.MethodPost, users + "/projects/" + project.Hex() + "/simulations", http.StatusOK, http.StatusForbidden},
+		{"simulations", http.MethodGet, simulations, http.StatusOK, http.StatusForbidden},
+		{"uploads", http.MethodPost, simulations + "/upload", http.StatusOK, http.StatusForbidden},
+		{"metrics", http.MethodGet, simulations + "/metrics/latency/votes", http.StatusOK, http.StatusForbidden},
+		{"unknown resources pass", http.MethodGet, "/v1/simulations/" + unknown.Hex(), http.StatusOK, http.StatusOK},
+		{"admin", http.MethodGet, "/v1/processing/queue", http.StatusForbidden, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		for _, caller := range []struct {
+			name, authorization string
+			want                int
+		}{
+			{"owner", "Bearer owner-token", tt.owner},
+			{"non-owner", "Bearer other-token", tt.nonOwner},
+			{"admin", "Bearer admin-token", http.StatusOK},
+			{"no token", "", http.StatusUnauthorized},
+			{"unknown token", "Bearer guess", http.StatusUnauthorized},
+			{"other scheme", "Basic owner-token", http.StatusUnauthorized},
+		} {
+			t.Run(tt.group+" "+tt.path+" "+caller.name, func(t *testing.T) {
+				recorder := httptest.NewRecorder()
+				req := httptest.NewRequest(tt.method, tt.path, nil)
+				if caller.authorization != "" {
+					req.Header.Set("Authorization", caller.authorization)
+				}
+				router.ServeHTTP(recorder, req)
+				if recorder.Code != caller.want {
+					t.Errorf("status %d, want %d: %s", recorder.Code, caller.want, recorder.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestAuthorizationWithoutAuthentication(t *testing.T) {
+	// Routes that skipped the authentication middleware still reject requests
+	router := gin.New()
+	none := ownerMap(nil)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/v1/simulations/:id", ownershipMiddleware(none, none), ok)
+	router.GET("/v1/processing/queue", AdminMiddleware(), ok)
+
+	for _, path := range []string{"/v1/simulations/" + primitive.NewObjectID().Hex(), "/v1/processing/queue"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want %d", path, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestOwnershipLookupError(t *testing.T) {
+	failing := func(context.Context, primitive.ObjectID) ([]primitive.ObjectID, error) {
+		return nil, errors.New("connection reset")
+	}
+	router := gin.New()
+	router.GET("/v1/projects/:projectId", func(c *gin.Context) {
+		c.Set(CallerKey, Caller{UserID: primitive.NewObjectID()})
+	}, ownershipMiddleware(failing, ownerMap(nil)), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/projects/"+primitive.NewObjectID().Hex(), nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestAPITokensFromEnv(t *testing.T) {
+	user := primitive.NewObjectID()
+	tests := []struct {
+		name    string
+		env     string
+		want    map[string]Caller
+		wantErr bool
+	}{
+		{name: "unset", want: map[string]Caller{}},
+		{name: "user and admin", env: " a1:" + user.Hex() + " , b2:admin,", want: map[string]Caller{"a1": {UserID: user}, "b2": {Role: RoleAdmin}}},
+		{name: "missing subject", env: "a1", wantErr: true},
+		{name: "empty token", env: ":admin", wantErr: true},
+		{name: "invalid user ID", env: "a1:alice", wantErr: true},
+		{name: "duplicate token", env: "a1:admin,a1:" + user.Hex(), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_TOKENS", tt.env)
+			got, err := APITokensFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("APITokensFromEnv() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("APITokensFromEnv() = %v, want %v", got, tt.want)
+			}
+			for token, caller := range tt.want {
+				if got[token] != caller {
+					t.Errorf("token %s = %+v, want %+v", token, got[token], caller)
+				}
+			}
+		})
+	}
+}
@@ -1,37 +1,131 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CORSMiddleware adds CORS headers
-func CORSMiddleware() gin.HandlerFunc {
-	return gin.HandlerFunc(func(c *gin.Context) {
-		origin := c.Request.Header.Get("Origin")
+// DefaultCORSOrigins are allowed when CORS_ALLOWED_ORIGINS is unset
+var DefaultCORSOrigins = []string{"http://localhost:3000", "http://localhost:3001"}
 
-		// Allow specific origins (configure as needed)
-		allowedOrigins := []string{
-			"http://localhost:3000",
-			"http://localhost:3001",
-			"https://yourdomain.com",
-		}
+// CORSConfig configures which browser origins may call the API
+type CORSConfig struct {
+	AllowedOrigins []string // Exact origins or wildcards such as "https://*.example.com"
+	AllowAll       bool     // Development only: allow any origin, without credentials
+}
 
-		// Check if origin is allowed
-		isAllowed := false
-		for _, allowed := range allowedOrigins {
-			if origin == allowed {
-				isAllowed = true
-				break
+// CORSConfigFromEnv reads CORS_ALLOWED_ORIGINS (comma separated, default
+// DefaultCORSOrigins) and ALLOW_ALL_ORIGINS
+func CORSConfigFromEnv() (CORSConfig, error) {
+	config := CORSConfig{AllowedOrigins: DefaultCORSOrigins}
+
+	if value := os.Getenv("CORS_ALLOWED_ORIGINS"); value != "" {
+		config.AllowedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.ToLower(strings.TrimSpace(origin)); origin != "" {
+				config.AllowedOrigins = append(config.AllowedOrigins, origin)
 			}
 		}
+	}
+	for _, origin := range config.AllowedOrigins {
+		if _, _, _, err := parseOrigin(origin); err != nil {
+			return config, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q: %w", origin, err)
+		}
+	}
+
+	if value := os.Getenv("ALLOW_ALL_ORIGINS"); value != "" {
+		allowAll, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid ALLOW_ALL_ORIGINS: %q", value)
+		}
+		config.AllowAll = allowAll
+	}
+
+	return config, nil
+}
+
+// Allows reports whether origin matches an allowed origin. A wildcard entry
+// matches any subdomain, but not the domain itself, with the same scheme and port.
+func (c CORSConfig) Allows(origin string) bool {
+	scheme, host, port, err := parseOrigin(strings.ToLower(origin))
+	if err != nil || strings.Contains(host, "*") {
+		return false
+	}
+
+	for _, allowed := range c.AllowedOrigins {
+		allowedScheme, allowedHost, allowedPort, err := parseOrigin(allowed)
+		if err != nil || scheme != allowedScheme || port != allowedPort {
+			continue
+		}
+		if host == allowedHost {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(allowedHost, "*"); ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
 
-		if isAllowed {
+// parseOrigin splits a scheme://host[:port] origin. Wildcards are only allowed
+// as the leading label of the host.
+func parseOrigin(origin string) (scheme, host, port string, err error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", "", "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", "", fmt.Errorf("scheme must be http or https")
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", "", fmt.Errorf("origin must be scheme://host[:port]")
+	}
+	host = u.Hostname()
+	if host == "" {
+		return "", "", "", fmt.Errorf("missing host")
+	}
+	if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+		return "", "", "", fmt.Errorf("wildcards are only allowed as *.domain")
+	}
+	return u.Scheme, host, u.Port(), nil
+}
+
+// CORSMiddleware adds CORS headers for allowed origins. Preflight requests
+// from other origins are rejected with 403; other requests from them proceed
+// without CORS headers, so browsers block reading the response.
+func CORSMiddleware(config CORSConfig) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+		// Responses differ by origin and must not be cached across origins
+		c.Writer.Header().Add("Vary", "Origin")
+
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		switch {
+		case config.AllowAll:
+			// Credentials are never allowed with the * origin
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		case config.Allows(origin):
 			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		default:
+			if c.Request.Method == "OPTIONS" {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+				return
+			}
+			c.Next()
+			return
 		}
 
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestCORSConfigAllows(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{
+		"https://*.domain.com",
+		"https://app.example.com",
+		"http://localhost:3000",
+		"https://*.ports.dev:8443",
+	}}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://app.domain.com", want: true},
+		{origin: "https://a.b.domain.com", want: true},
+		{origin: "https://APP.Domain.com", want: true},
+		{origin: "https://domain.com", want: false},      // The apex does not match *.domain.com
+		{origin: "https://evil-domain.com", want: false}, // Suffix without the dot
+		{origin: "https://app.domain.com.evil.io", want: false},
+		{origin: "https://.domain.com", want: false},
+		{origin: "https://*.domain.com", want: false},  // A wildcard origin is never sent by browsers
+		{origin: "http://app.domain.com", want: false}, // Scheme mismatch
+		{origin: "https://app.example.com", want: true},
+		{origin: "https://sub.app.example.com", want: false}, // Exact entries do not match subdomains
+		{origin: "http://localhost:3000", want: true},
+		{origin: "http://localhost:3001", want: false}, // Port mismatch
+		{origin: "http://localhost", want: false},
+		{origin: "https://app.domain.com:443", want: false}, // An explicit port differs from none
+		{origin: "https://api.ports.dev:8443", want: true},
+		{origin: "https://api.ports.dev", want: false},
+		{origin: "https://api.ports.dev:9443", want: false},
+		{origin: "null", want: false},
+		{origin: "https://app.example.com/path", want: false},
+		{origin: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := config.Allows(tt.origin); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://*.domain.com"}}
+
+	tests := []struct {
+		name            string
+		config          CORSConfig
+		method          string
+		origin          string
+		wantStatus      int
+		wantAllowOrigin string
+		wantCredentials string
+	}{
+		{name: "allowed preflight", config: config, method: http.MethodOptions, origin: "https://app.domain.com", wantStatus: http.StatusNoContent, wantAllowOrigin: "https://app.domain.com", wantCredentials: "true"},
+		{name: "disallowed preflight", config: config, method: http.MethodOptions, origin: "https://evil-domain.com", wantStatus: http.StatusForbidden},
+		{name: "apex preflight", config: config, method: http.MethodOptions, origin: "https://domain.com", wantStatus: http.StatusForbidden},
+		{name: "allowed request", config: config, method: http.MethodGet, origin: "https://app.domain.com", wantStatus: http.StatusOK, wantAllowOrigin: "https://app.domain.com", wantCredentials: "true"},
+		{name: "disallowed request proceeds without CORS headers", config: config, method: http.MethodGet, origin: "https://evil-domain.com", wantStatus: http.StatusOK},
+		{name: "request without origin", config: config, method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "allow all without credentials", config: CORSConfig{AllowAll: true}, method: http.MethodOptions, origin: "https://anything.io", wantStatus: http.StatusNoContent, wantAllowOrigin: "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(CORSMiddleware(tt.config))
+			router.Handle(tt.method, "/resource", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/resource", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			header := recorder.Header()
+			if got := header.Values("Vary"); len(got) != 1 || got[0] != "Origin" {
+				t.Errorf("Vary = %q, want [Origin]", got)
+			}
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllowOrigin)
+			}
+			if got := header.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if tt.wantAllowOrigin == "" && header.Get("Access-Control-Allow-Methods") != "" {
+				t.Error("CORS headers set for a disallowed origin")
+			}
+		})
+	}
+}
+
+func TestCORSConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		origins  string
+		allowAll string
+		want     []string
+		wantAll  bool
+		wantErr  bool
+	}{
+		{name: "defaults", want: DefaultCORSOrigins},
+		{name: "list is trimmed and lowercased", origins: " https://App.example.com ,, https://*.domain.com", want: []string{"https://app.example.com", "https://*.domain.com"}},
+		{name: "allow all", allowAll: "true", want: DefaultCORSOrigins, wantAll: true},
+		{name: "wildcard in the middle", origins: "https://app.*.com", wantErr: true},
+		{name: "bare wildcard", origins: "*", wantErr: true},
+		{name: "path", origins: "https://app.example.com/api", wantErr: true},
+		{name: "other scheme", origins: "ftp://example.com", wantErr: true},
+		{name: "invalid allow all", allowAll: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ALLOWED_ORIGINS", tt.origins)
+			t.Setenv("ALLOW_ALL_ORIGINS", tt.allowAll)
+			config, err := CORSConfigFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("CORSConfigFromEnv() = %+v, want an error", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.AllowedOrigins) != len(tt.want) {
+				t.Fatalf("AllowedOrigins = %q, want %q", config.AllowedOrigins, tt.want)
+			}
+			for i := range tt.want {
+				if config.AllowedOrigins[i] != tt.want[i] {
+					t.Errorf("AllowedOrigins = %q, want %q", config.AllowedOrigins, tt.want)
+				}
+			}
+			if config.AllowAll != tt.wantAll {
+				t.Errorf("AllowAll = %v, want %v", config.AllowAll, tt.wantAll)
+			}
+		})
+	}
+}
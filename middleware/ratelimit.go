@@ -1,35 +1,115 @@
 package middleware
 
 import (
+	"container/list"
+	"fmt"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// RateLimitPolicy limits the requests per minute of each client on the routes it is applied to
+type RateLimitPolicy struct {
+	Name  string
+	Rate  int // requests per minute
+	Burst int // maximum burst
+}
+
+// DefaultRateLimitPolicies are the policies routes are registered with, unless overridden by RATE_LIMITS
+var DefaultRateLimitPolicies = map[string]RateLimitPolicy{
+	"default": {Name: "default", Rate: 600, Burst: 20},
+	"metrics": {Name: "metrics", Rate: 120, Burst: 10},  // Expensive aggregations
+	"events":  {Name: "events", Rate: 3000, Burst: 100}, // Event playback pages through timelines quickly
+	"uploads": {Name: "uploads", Rate: 10, Burst: 3},
+}
+
+// RateLimitPoliciesFromEnv returns DefaultRateLimitPolicies with the overrides
+// of RATE_LIMITS, a comma separated list of name=rate[:burst] such as
+// "metrics=60:5,uploads=20". The burst is kept when omitted.
+func RateLimitPoliciesFromEnv() (map[string]RateLimitPolicy, error) {
+	policies := make(map[string]RateLimitPolicy, len(DefaultRateLimitPolicies))
+	for name, policy := range DefaultRateLimitPolicies {
+		policies[name] = policy
+	}
+
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMITS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, limit, ok := strings.Cut(entry, "=")
+		policy, known := policies[strings.TrimSpace(name)]
+		if !ok || !known {
+			return nil, fmt.Errorf("invalid RATE_LIMITS entry %q: expected name=rate[:burst] with name one of default, metrics, events, uploads", entry)
+		}
+
+		rateStr, burstStr, hasBurst := strings.Cut(limit, ":")
+		rate, err := strconv.Atoi(strings.TrimSpace(rateStr))
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMITS rate in %q", entry)
+		}
+		policy.Rate = rate
+		if hasBurst {
+			burst, err := strconv.Atoi(strings.TrimSpace(burstStr))
+			if err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid RATE_LIMITS burst in %q", entry)
+			}
+			policy.Burst = burst
+		}
+		policies[policy.Name] = policy
+	}
+	return policies, nil
+}
+
+// DefaultRateLimitMaxClients bounds the token buckets kept by a RateLimiter
+const DefaultRateLimitMaxClients = 100000
+
 // Client represents a rate-limited client
 type Client struct {
-	tokens   int
+	key      bucketKey
+	tokens   float64
 	lastSeen time.Time
 }
 
-// RateLimiter manages rate limiting
+// bucketKey identifies the token bucket of a client under a policy
+type bucketKey struct {
+	clientID string
+	policy   string
+}
+
+// RateLimiter manages rate limiting with a token bucket per client and policy.
+// At most maxClients buckets are kept; the least recently used one is evicted
+// for a new client, so a flood of (spoofed) addresses cannot exhaust memory.
 type RateLimiter struct {
-	clients map[string]*Client
-	mutex   sync.RWMutex
-	rate    int           // requests per minute
-	burst   int           // maximum burst
-	window  time.Duration // time window
+	clients    map[bucketKey]*list.Element
+	recent     *list.List // *Client, most recently used first
+	maxClients int
+	mutex      sync.RWMutex
+	window     time.Duration // time window the policy rates are given in
+	now        func() time.Time
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(rate, burst int) *RateLimiter {
+// RateLimitResult reports the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int           // Requests the client may still make right away
+	Reset     time.Duration // Until the next request is allowed when denied, else until the burst is full again
+}
+
+// NewRateLimiter creates a new rate limiter keeping at most maxClients token buckets
+func NewRateLimiter(maxClients int) *RateLimiter {
 	rl := &RateLimiter{
-		clients: make(map[string]*Client),
-		rate:    rate,
-		burst:   burst,
-		window:  time.Minute,
+		clients:    make(map[bucketKey]*list.Element),
+		recent:     list.New(),
+		maxClients: max(maxClients, 1),
+		window:     time.Minute,
+		now:        time.Now,
 	}
 
 	// Cleanup routine
@@ -38,36 +118,53 @@ func NewRateLimiter(rate, burst int) *RateLimiter {
 	return rl
 }
 
-// Allow checks if the client is allowed to make a request
-func (rl *RateLimiter) Allow(clientID string) bool {
+// Allow checks if the client is allowed to make a request under policy and takes a token if so
+func (rl *RateLimiter) Allow(clientID string, policy RateLimitPolicy) RateLimitResult {
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 
-	now := time.Now()
-	client, exists := rl.clients[clientID]
-
-	if !exists {
+	now := rl.now()
+	key := bucketKey{clientID: clientID, policy: policy.Name}
+	var client *Client
+	if element, exists := rl.clients[key]; exists {
+		client = element.Value.(*Client)
+		rl.recent.MoveToFront(element)
+	} else {
+		if len(rl.clients) >= rl.maxClients {
+			// An evicted client starts over with a full bucket
+			oldest := rl.recent.Back()
+			rl.recent.Remove(oldest)
+			delete(rl.clients, oldest.Value.(*Client).key)
+		}
 		client = &Client{
-			tokens:   rl.burst - 1,
+			key:      key,
+			tokens:   float64(policy.Burst),
 			lastSeen: now,
 		}
-		rl.clients[clientID] = client
-		return true
+		rl.clients[key] = rl.recent.PushFront(client)
 	}
 
-	// Calculate tokens to add based on time elapsed
+	// Refill continuously; whole tokens are only spent below
+	perSecond := float64(policy.Rate) / rl.window.Seconds()
 	elapsed := now.Sub(client.lastSeen)
-	tokensToAdd := int(elapsed.Seconds() * float64(rl.rate) / 60.0)
-
-	client.tokens = min(client.tokens+tokensToAdd, rl.burst)
+	client.tokens = math.Min(client.tokens+elapsed.Seconds()*perSecond, float64(policy.Burst))
 	client.lastSeen = now
 
-	if client.tokens > 0 {
+	result := RateLimitResult{}
+	if client.tokens >= 1 {
 		client.tokens--
-		return true
+		result.Allowed = true
+		result.Reset = secondsToDuration((float64(policy.Burst) - client.tokens) / perSecond)
+	} else {
+		result.Reset = secondsToDuration((1 - client.tokens) / perSecond)
 	}
+	result.Remaining = int(client.tokens)
+	return result
+}
 
-	return false
+// secondsToDuration converts fractional seconds to a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
 }
 
 // cleanup removes old clients
@@ -77,35 +174,41 @@ func (rl *RateLimiter) cleanup() {
 
 	for range ticker.C {
 		rl.mutex.Lock()
-		now := time.Now()
-		for clientID, client := range rl.clients {
-			if now.Sub(client.lastSeen) > time.Hour {
-				delete(rl.clients, clientID)
+		now := rl.now()
+		// The least recently used clients are at the back
+		for element := rl.recent.Back(); element != nil; element = rl.recent.Back() {
+			client := element.Value.(*Client)
+			if now.Sub(client.lastSeen) <= time.Hour {
+				break
 			}
+			rl.recent.Remove(element)
+			delete(rl.clients, client.key)
 		}
 		rl.mutex.Unlock()
 	}
 }
 
-// min returns the minimum of two integers
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-// RateLimitMiddleware creates a rate limiting middleware
-func RateLimitMiddleware(rate, burst int) gin.HandlerFunc {
-	limiter := NewRateLimiter(rate, burst)
-
+// RateLimitMiddleware limits the routes it is applied to by policy, keyed by
+// client IP. Every response carries X-RateLimit-Policy, X-RateLimit-Limit (the
+// burst), X-RateLimit-Remaining and X-RateLimit-Reset (seconds until the burst
+// is full again); rejected requests get a 429 with Retry-After.
+func RateLimitMiddleware(limiter *RateLimiter, policy RateLimitPolicy) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		clientID := c.ClientIP()
 
-		if !limiter.Allow(clientID) {
+		result := limiter.Allow(clientID, policy)
+		resetSeconds := int(math.Ceil(result.Reset.Seconds()))
+		c.Header("X-RateLimit-Policy", policy.Name)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(policy.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(resetSeconds))
 			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "Rate limit exceeded",
-				"retry_after": "60s",
+				"error":       fmt.Sprintf("Rate limit exceeded for %s requests (%d per minute)", policy.Name, policy.Rate),
+				"policy":      policy.Name,
+				"retry_after": fmt.Sprintf("%ds", resetSeconds),
 			})
 			c.Abort()
 			return
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeClock is a clock advanced by the test
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// rateLimitStep advances the clock and makes one request
+type rateLimitStep struct {
+	advance       time.Duration
+	client        string
+	policy        RateLimitPolicy
+	wantAllowed   bool
+	wantRemaining int
+	wantReset     time.Duration // Checked when non-zero
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	perSecond := RateLimitPolicy{Name: "metrics", Rate: 60, Burst: 3}
+	otherPolicy := RateLimitPolicy{Name: "uploads", Rate: 60, Burst: 1}
+	single := RateLimitPolicy{Name: "default", Rate: 60, Burst: 1}
+
+	tests := []struct {
+		name       string
+		maxClients int
+		steps      []rateLimitStep
+		wantLRU    []bucketKey // Buckets after the last step, most recently used first
+	}{
+		{
+			name:       "burst then deny",
+			maxClients: 10,
+			steps: []rateLimitStep{
+				{client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 2, wantReset: time.Second},
+				{client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 1, wantReset: 2 * time.Second},
+				{client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 0, wantReset: 3 * time.Second},
+				{client: "a", policy: perSecond, wantAllowed: false, wantRemaining: 0, wantReset: time.Second},
+			},
+			wantLRU: []bucketKey{{"a", "metrics"}},
+		},
+		{
+			name:       "refill is continuous and capped at the burst",
+			maxClients: 10,
+			steps: []rateLimitStep{
+				{client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 2},
+				{client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 1},
+				{client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 0},
+				{advance: 500 * time.Millisecond, client: "a", policy: perSecond, wantAllowed: false, wantRemaining: 0, wantReset: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 0, wantReset: 3 * time.Second},
+				{advance: 2 * time.Second, client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 1, wantReset: 2 * time.Second},
+				{advance: time.Hour, client: "a", policy: perSecond, wantAllowed: true, wantRemaining: 2, wantReset: time.Second},
+			},
+		},
+		{
+			name:       "buckets are per client and policy",
+			maxClients: 10,
+			steps: []rateLimitStep{
+				{client: "a", policy: single, wantAllowed: true},
+				{client: "a", policy: single, wantAllowed: false},
+				{client: "a", policy: otherPolicy, wantAllowed: true},
+				{client: "b", policy: single, wantAllowed: true},
+				{client: "a", policy: otherPolicy, wantAllowed: false},
+			},
+			wantLRU: []bucketKey{{"a", "uploads"}, {"b", "default"}, {"a", "default"}},
+		},
+		{
+			name:       "the least recently used bucket is evicted",
+			maxClients: 2,
+			steps: []rateLimitStep{
+				{client: "a", policy: single, wantAllowed: true},
+				{client: "b", policy: single, wantAllowed: true},
+				{client: "a", policy: single, wantAllowed: false}, // a is now the most recently used
+				{client: "c", policy: single, wantAllowed: true},  // Evicts b
+				{client: "a", policy: single, wantAllowed: false}, // a kept its empty bucket
+			},
+			wantLRU: []bucketKey{{"a", "default"}, {"c", "default"}},
+		},
+		{
+			name:       "an evicted client starts over with a full bucket",
+			maxClients: 1,
+			steps: []rateLimitStep{
+				{client: "a", policy: single, wantAllowed: true},
+				{client: "a", policy: single, wantAllowed: false},
+				{client: "b", policy: single, wantAllowed: true}, // Evicts a
+				{client: "a", policy: single, wantAllowed: true}, // Evicts b
+			},
+			wantLRU: []bucketKey{{"a", "default"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+			rl := NewRateLimiter(tt.maxClients)
+			rl.now = clock.Now
+
+			for i, step := range tt.steps {
+				clock.now = clock.now.Add(step.advance)
+				result := rl.Allow(step.client, step.policy)
+				if result.Allowed != step.wantAllowed || result.Remaining != step.wantRemaining {
+					t.Fatalf("step %d (%s/%s): allowed=%v remaining=%d, want allowed=%v remaining=%d",
+						i, step.client, step.policy.Name, result.Allowed, result.Remaining, step.wantAllowed, step.wantRemaining)
+				}
+				if step.wantReset != 0 && result.Reset != step.wantReset {
+					t.Errorf("step %d (%s/%s): reset = %s, want %s", i, step.client, step.policy.Name, result.Reset, step.wantReset)
+				}
+			}
+
+			if tt.wantLRU != nil {
+				var lru []bucketKey
+				for element := rl.recent.Front(); element != nil; element = element.Next() {
+					lru = append(lru, element.Value.(*Client).key)
+				}
+				if !reflect.DeepEqual(lru, tt.wantLRU) {
+					t.Errorf("buckets = %v, want %v", lru, tt.wantLRU)
+				}
+				if len(rl.clients) != len(tt.wantLRU) {
+					t.Errorf("%d buckets indexed, want %d", len(rl.clients), len(tt.wantLRU))
+				}
+			}
+		})
+	}
+}
+
+// forwardedRequest is a request from remoteAddr carrying forwardedFor, if any,
+// in X-Forwarded-For
+type forwardedRequest struct {
+	remoteAddr   string
+	forwardedFor string
+	wantStatus   int
+}
+
+func TestRateLimitMiddlewareForwardedFor(t *testing.T) {
+	const proxy = "10.0.0.1:4000"
+	tests := []struct {
+		name     string
+		requests []forwardedRequest
+	}{
+		{
+			name: "clients behind a trusted proxy are limited by their forwarded IP",
+			requests: []forwardedRequest{
+				{remoteAddr: proxy, forwardedFor: "203.0.113.7", wantStatus: http.StatusOK},
+				{remoteAddr: proxy, forwardedFor: "203.0.113.8", wantStatus: http.StatusOK},
+				{remoteAddr: proxy, forwardedFor: "203.0.113.7", wantStatus: http.StatusTooManyRequests},
+			},
+		},
+		{
+			name: "clients spoofing X-Forwarded-For are limited by their own IP",
+			requests: []forwardedRequest{
+				{remoteAddr: "198.51.100.9:5000", forwardedFor: "203.0.113.7", wantStatus: http.StatusOK},
+				{remoteAddr: "198.51.100.9:5000", forwardedFor: "203.0.113.8", wantStatus: http.StatusTooManyRequests},
+				{remoteAddr: "198.51.100.9:5000", wantStatus: http.StatusTooManyRequests},
+			},
+		},
+		{
+			name: "addresses a client prepends before the trusted proxy's are ignored",
+			requests: []forwardedRequest{
+				{remoteAddr: proxy, forwardedFor: "192.0.2.1, 203.0.113.7", wantStatus: http.StatusOK},
+				{remoteAddr: proxy, forwardedFor: "192.0.2.2, 203.0.113.7", wantStatus: http.StatusTooManyRequests},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			if err := router.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+				t.Fatal(err)
+			}
+			policy := RateLimitPolicy{Name: "default", Rate: 1, Burst: 1}
+			router.GET("/", RateLimitMiddleware(NewRateLimiter(10), policy), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, request := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = request.remoteAddr
+				if request.forwardedFor != "" {
+					req.Header.Set("X-Forwarded-For", request.forwardedFor)
+				}
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, req)
+				if recorder.Code != request.wantStatus {
+					t.Errorf("request %d from %s for %q: status = %d, want %d",
+						i, request.remoteAddr, request.forwardedFor, recorder.Code, request.wantStatus)
+				}
+			}
+		})
+	}
+}
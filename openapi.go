@@ -0,0 +1,361 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/openapi"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/latency"
+	"github.com/gin-gonic/gin"
+)
+
+// Query parameters shared by many routes
+var (
+	timeWindowParams = []openapi.Parameter{
+		openapi.Query("from", "date-time", "Only events at or after this time (RFC3339)"),
+		openapi.Query("to", "date-time", "Only events before this time (RFC3339)"),
+	}
+	heightRangeParams = []openapi.Parameter{
+		openapi.Query("heightFrom", "integer", "Only heights at or above this height"),
+		openapi.Query("heightTo", "integer", "Only heights at or below this height"),
+	}
+	pageParams = []openapi.Parameter{
+		openapi.Query("page", "integer", "Page number, starting at 1"),
+		openapi.Query("perPage", "integer", "Items per page (default 100, max 1000)"),
+	}
+	searchParam  = openapi.Query("q", "string", "Case-insensitive search on name and description")
+	cascadeParam = openapi.Query("cascade", "boolean", "Also delete everything the resource owns")
+	nodeParam    = openapi.Query("node", "string", "Only this node")
+	pairParams   = []openapi.Parameter{
+		openapi.Query("sender", "string", "Only messages sent by this node"),
+		openapi.Query("receiver", "string", "Only messages received by this node"),
+	}
+	resolutionParam  = openapi.Query("resolution", "string", "Bucket size as a duration, e.g. 1s or 500ms")
+	percentilesParam = openapi.Query("percentiles", "string", "Comma separated percentiles between 0 and 1, e.g. 0.5,0.9,0.99")
+)
+
+// params concatenates parameter lists
+func params(lists ...[]openapi.Parameter) []openapi.Parameter {
+	var all []openapi.Parameter
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// metricParams are the query parameters of a metric over a time window and height range
+func metricParams(extra ...openapi.Parameter) []openapi.Parameter {
+	return params(timeWindowParams, heightRangeParams, extra)
+}
+
+var logFileFormFields = []openapi.FormField{
+	{Name: "logfiles", Description: "Log files, optionally .gz or .zst compressed", File: true, Multiple: true},
+	{Name: "archive", Description: "A .zip or .tar.gz archive of log files", File: true},
+}
+
+// routeDocs describes the routes registered in main, keyed by method and path
+var routeDocs = map[string]openapi.Operation{
+	// Probes
+	"GET /healthz": {Summary: "Liveness probe", Tags: []string{"health"}, Response: map[string]string{}},
+	"GET /readyz": {Summary: "Readiness probe checking MongoDB, storage and the ETL binary", Tags: []string{"health"},
+		Response: types.ReadinessResponse{}},
+
+	"GET /v1/version": {Summary: "Get the build and dependency versions", Tags: []string{"health"}, Response: types.VersionResponse{}},
+
+	// Users
+	"POST /v1/users": {Summary: "Create a user", Tags: []string{"users"}, Body: types.CreateUserRequest{},
+		Status: http.StatusCreated, Response: types.User{}},
+	"GET /v1/users": {Summary: "List users", Tags: []string{"users"}, Response: types.PaginatedUsersResponse{},
+		Query: params(pageParams, []openapi.Parameter{
+			openapi.Query("q", "string", "Case-insensitive search on username and email"),
+			openapi.Query("username", "string", "Exact username"),
+			openapi.Query("email", "string", "Email, matched case-insensitively"),
+			openapi.Query("fields", "string", "Comma separated fields to return"),
+		})},
+	"GET /v1/users/by-username/:username": {Summary: "Get a user by username", Tags: []string{"users"}, Response: types.User{}},
+	"GET /v1/users/:userId":               {Summary: "Get a user", Tags: []string{"users"}, Response: types.User{}},
+	"PUT /v1/users/:userId": {Summary: "Update a user", Tags: []string{"users"}, Body: types.UpdateUserRequest{},
+		Response: types.User{}},
+	"DELETE /v1/users/:userId": {Summary: "Delete a user", Tags: []string{"users"}, Query: []openapi.Parameter{cascadeParam},
+		Response: types.UserDeletionSummary{}},
+	"GET /v1/users/:userId/storage": {Summary: "Get the storage usage and quota of a user", Tags: []string{"users"},
+		Response: types.UserStorageResponse{}},
+
+	// Projects
+	"POST /v1/users/:userId/projects": {Summary: "Create a project", Tags: []string{"projects"}, Body: types.CreateProjectRequest{},
+		Status: http.StatusCreated, Response: types.Project{}},
+	"GET /v1/users/:userId/projects": {Summary: "List the projects of a user", Tags: []string{"projects"},
+		Description: "Projects carry a stats object when includeStats is true.",
+		Query:       []openapi.Parameter{searchParam, openapi.Query("includeStats", "boolean", "Include simulation stats")},
+		Response:    []types.ProjectWithStats{}},
+	"GET /v1/projects/:projectId":       {Summary: "Get a project", Tags: []string{"projects"}, Response: types.Project{}},
+	"GET /v1/projects/:projectId/stats": {Summary: "Get the simulation stats of a project", Tags: []string{"projects"}, Response: types.ProjectStats{}},
+	"GET /v1/projects/:projectId/storage": {Summary: "Get the storage usage of a project", Tags: []string{"projects"},
+		Response: types.ProjectStorageUsage{}},
+	"PUT /v1/projects/:projectId": {Summary: "Update a project", Tags: []string{"projects"}, Body: types.UpdateProjectRequest{},
+		Response: types.Project{}},
+	"POST /v1/projects/:projectId/transfer": {Summary: "Transfer a project to another user", Tags: []string{"projects"},
+		Body: types.TransferProjectRequest{}, Response: types.ProjectTransferSummary{}},
+	"DELETE /v1/projects/:projectId": {Summary: "Delete a project", Tags: []string{"projects"}, Query: []openapi.Parameter{cascadeParam},
+		Response: types.ProjectDeletionSummary{}},
+
+	// Simulations
+	"POST /v1/users/:userId/projects/:projectId/simulations": {Summary: "Create a simulation", Tags: []string{"simulations"},
+		Description: "Log files may be uploaded with the simulation as multipart/form-data.",
+		Body:        types.CreateSimulationRequest{},
+		Form: append([]openapi.FormField{
+			{Name: "name", Required: true},
+			{Name: "description"},
+			{Name: "priority", Description: "Processing priority, higher first"},
+		}, logFileFormFields...),
+		Status: http.StatusCreated, Response: types.SimulationResponse{}},
+	"GET /v1/users/:userId/simulations": {Summary: "List the simulations of a user", Tags: []string{"simulations"},
+		Query: []openapi.Parameter{searchParam, openapi.QueryList("status", "Only simulations with these statuses")}, Response: []types.SimulationResponse{}},
+	"GET /v1/projects/:projectId/simulations": {Summary: "List the simulations of a project", Tags: []string{"simulations"},
+		Query: []openapi.Parameter{searchParam, openapi.QueryList("status", "Only simulations with these statuses")}, Response: []types.SimulationResponse{}},
+	"GET /v1/simulations/:id": {Summary: "Get a simulation", Tags: []string{"simulations"}, Response: types.SimulationResponse{}},
+	"PUT /v1/simulations/:id": {Summary: "Update a simulation", Tags: []string{"simulations"}, Body: types.UpdateSimulationRequest{},
+		Response: types.SimulationResponse{}},
+	"DELETE /v1/simulations/:id": {Summary: "Delete a simulation and its data", Tags: []string{"simulations"}, Response: map[string]string{}},
+	"GET /v1/simulations/:id/export": {Summary: "Export a processed simulation as a portable archive", Tags: []string{"simulations"},
+		Query:       []openapi.Parameter{openapi.Query("includeLogs", "boolean", "Include the log files")},
+		ContentType: "application/gzip"},
+	"POST /v1/users/:userId/projects/:projectId/simulations/import": {Summary: "Import a simulation archive", Tags: []string{"simulations"},
+		Description: "The archive written by the export may also be sent as the request body (application/gzip).",
+		Form:        []openapi.FormField{{Name: "archive", Description: "Simulation archive (.tar.gz)", File: true, Required: true}},
+		Status:      http.StatusCreated, Response: types.SimulationResponse{}},
+	"POST /v1/simulations/:id/upload": {Summary: "Upload log files", Tags: []string{"log files"},
+		Query: []openapi.Parameter{
+			openapi.QueryEnum("mode", "Reject uploads while processing runs, or queue them for the next run", "reject", "queue"),
+			openapi.QueryEnum("duplicates", "Reject files whose content was already uploaded, or skip them", "reject", "skip"),
+		},
+		Form: logFileFormFields, Response: map[string]any{}},
+	"GET /v1/simulations/:id/logfiles": {Summary: "List the log files of a simulation", Tags: []string{"log files"},
+		Response: []types.LogFileListEntry{}},
+	"POST /v1/simulations/:id/logfiles/fetch": {Summary: "Fetch log files from URLs", Tags: []string{"log files"},
+		Body: types.FetchLogFilesRequest{}, Response: map[string]any{}},
+	"GET /v1/simulations/:id/logfiles/:index/download": {Summary: "Download a log file", Tags: []string{"log files"},
+		ContentType: "application/octet-stream"},
+	"DELETE /v1/simulations/:id/logfiles/:index": {Summary: "Delete a log file", Tags: []string{"log files"}, Response: map[string]any{}},
+	"POST /v1/simulations/:id/process": {Summary: "Queue a simulation for processing", Tags: []string{"processing"},
+		Body: types.ProcessSimulationRequest{}, Status: http.StatusAccepted, Response: map[string]any{}},
+	"PUT /v1/simulations/:id/priority": {Summary: "Change the processing priority of a simulation", Tags: []string{"processing"},
+		Body: types.UpdateSimulationPriorityRequest{}, Response: map[string]any{}},
+	"GET /v1/processing/queue": {Summary: "List the queued simulations", Tags: []string{"processing"},
+		Description: "Admin only.", Response: []types.ProcessingQueueEntry{}},
+
+	// Admin
+	"POST /v1/admin/retention/run": {Summary: "Prune raw events past the projects' retention windows now", Tags: []string{"admin"},
+		Description: "Answers 409 while a run is in progress.", Response: types.RetentionRunSummary{}},
+
+	// Events
+	"GET /v1/simulations/:id/events": {Summary: "List consensus events", Tags: []string{"events"},
+		Description: "Returns a BucketedEventsResponse instead when resolution is set.",
+		Query: params(timeWindowParams, heightRangeParams, []openapi.Parameter{
+			openapi.Query("limit", "integer", "Events per page"),
+			openapi.Query("cursor", "string", "Cursor of the next page, from the previous response"),
+			openapi.Query("before", "string", "Cursor to page backwards from"),
+			openapi.Query("segment", "integer", "Segment to page to (1-indexed)"),
+			openapi.QueryEnum("order", "Sort order by time", "asc", "desc"),
+			openapi.Query("tail", "integer", "Only the last N events, in chronological order"),
+			openapi.Query("includeTotalCount", "boolean", "Count the matching events"),
+			openapi.Query("approxCount", "boolean", "Use the estimated collection count"),
+			openapi.Query("includeIds", "boolean", "Include event ids"),
+			openapi.Query("fields", "string", "Comma separated event fields to return"),
+			openapi.Query("type", "string", "Only events of this type"),
+			openapi.Query("includeType", "string", "Comma separated event types to include"),
+			openapi.Query("excludeType", "string", "Comma separated event types to exclude"),
+			openapi.Query("includeP2p", "boolean", "Include p2p message events"),
+			openapi.QueryList("nodeId", "Only events of these nodes"),
+			openapi.QueryList("peer", "Only messages exchanged with these peers"),
+			resolutionParam,
+		}),
+		Response: types.PaginatedEventsResponse{}},
+	"GET /v1/simulations/:id/events/ws": {Summary: "Stream consensus events over a WebSocket", Tags: []string{"events"},
+		Description: "Upgrades to a WebSocket sending EventStreamMessage frames and accepting EventStreamControl messages.",
+		Query: []openapi.Parameter{
+			openapi.Query("from", "date-time", "Start of the playback"),
+			openapi.Query("speed", "number", "Playback speed multiplier"),
+		},
+		Status: http.StatusSwitchingProtocols},
+	"GET /v1/simulations/:id/events/export": {Summary: "Export consensus events", Tags: []string{"events"},
+		Query:       params(timeWindowParams, heightRangeParams, []openapi.Parameter{openapi.QueryEnum("format", "Export format", "ndjson", "csv")}),
+		ContentType: "application/x-ndjson"},
+	"GET /v1/simulations/:id/events/summary": {Summary: "Count events per type", Tags: []string{"events"},
+		Query:    metricParams(openapi.QueryEnum("groupBy", "Also count per node", "nodeId")),
+		Response: map[string]*types.EventTypeSummary{}},
+	"GET /v1/simulations/:id/events/:eventId": {Summary: "Get a consensus event", Tags: []string{"events"}, Response: types.EventDetailResponse{}},
+	"GET /v1/simulations/:id/heights": {Summary: "List block heights", Tags: []string{"events"},
+		Query: params(timeWindowParams, pageParams), Response: types.PaginatedHeightsResponse{}},
+	"GET /v1/simulations/:id/heights/:height/timeline": {Summary: "Get the consensus timeline of a height", Tags: []string{"events"},
+		Response: types.HeightTimeline{}},
+	"GET /v1/simulations/:id/peers": {Summary: "Map peer IDs to node IDs", Tags: []string{"events"},
+		Description: "Derived from the vote events; conflicting evidence lowers the confidence and is listed as alternatives.",
+		Response:    []types.PeerMapping{}},
+	"GET /v1/simulations/:id/validators": {Summary: "Map validator indices to node IDs", Tags: []string{"events"},
+		Description: "Derived from the nodes the votes of each index originated at; indices originated at several nodes are flagged ambiguous.",
+		Response:    []types.ValidatorMapping{}},
+
+	// Metrics
+	"GET /v1/simulations/:id/incidents": {Summary: "List incidents", Tags: []string{"metrics"},
+		Description: "Heights that needed extra rounds, committed slowly, stalled or were not committed by some nodes.",
+		Query: params([]openapi.Parameter{
+			openapi.Query("maxCommitMs", "number", "Longest acceptable time to commit a height in ms (default 10000)"),
+			openapi.Query("minRounds", "integer", "Lowest round reported as extra rounds (default 1)"),
+			openapi.QueryEnum("severity", "Only incidents of this severity; repeatable", types.SeverityWarning, types.SeverityCritical),
+			openapi.QueryEnum("type", "Only incidents of this type; repeatable",
+				types.IncidentExtraRounds, types.IncidentSlowCommit, types.IncidentMissedCommit, types.IncidentStalled),
+		}, pageParams),
+		Response: types.PaginatedIncidentsResponse{}},
+	"GET /v1/simulations/:id/metrics/latency/votes": {Summary: "List vote latencies", Tags: []string{"metrics"},
+		Query: metricParams(append(append([]openapi.Parameter{
+			openapi.Query("threshold", "number", "Latency threshold in ms"),
+			openapi.QueryEnum("thresholdMode", "Keep latencies above or below the threshold, or all", "above", "below", "all"),
+			openapi.Query("minLatencyMs", "number", "Minimum latency in ms"),
+			openapi.Query("maxLatencyMs", "number", "Maximum latency in ms"),
+			openapi.Query("voteType", "string", "Only this vote type"),
+			openapi.Query("height", "integer", "Only this height"),
+			openapi.Query("round", "integer", "Only this round"),
+			openapi.QueryEnum("sortBy", "Sort field", "sentTime", "latency"),
+			openapi.QueryEnum("order", "Sort order", "asc", "desc"),
+		}, pairParams...), pageParams...)...),
+		Response: types.PaginatedVoteLatencyResponse{}},
+	"GET /v1/simulations/:id/metrics/latency/pairwise": {Summary: "Get latency percentiles per node pair", Tags: []string{"metrics"},
+		Query: metricParams(append([]openapi.Parameter{
+			openapi.QueryEnum("groupBy", "Split each pair by vote type", "voteType"),
+			nodeParam, percentilesParam,
+		}, pairParams...)...),
+		Response: []types.PairLatency{}},
+	"GET /v1/simulations/:id/metrics/latency/timeseries": {Summary: "Get the block latency time series", Tags: []string{"metrics"},
+		Query: metricParams(), Response: []types.BlockLatencyPoint{}},
+	"GET /v1/simulations/:id/metrics/latency/stats": {Summary: "Get latency statistics and histogram", Tags: []string{"metrics"},
+		Query: metricParams(
+			openapi.Query("buckets", "integer", "Number of histogram buckets"),
+			openapi.Query("boundaries", "string", "Comma separated histogram boundaries in ms"),
+		),
+		Response: types.LatencyStats{}},
+	"GET /v1/simulations/:id/metrics/latency/spikes": {Summary: "List latency spikes", Tags: []string{"metrics"},
+		Query: metricParams(append(append([]openapi.Parameter{
+			openapi.Query("k", "number", "Standard deviations above the mean counted as a spike"),
+			nodeParam,
+		}, pairParams...), pageParams...)...),
+		Response: types.PaginatedLatencySpikesResponse{}},
+	"GET /v1/simulations/:id/metrics/latency/votes/timeseries": {Summary: "Get the vote latency percentile time series", Tags: []string{"metrics"},
+		Description: "p50/p95/p99 of the confirmed vote latencies per sentTime bucket. The resolution is coarsened when the range needs more than 5000 buckets.",
+		Query:       metricParams(append([]openapi.Parameter{resolutionParam, nodeParam}, pairParams...)...),
+		Response:    types.VoteLatencyTimeSeriesResponse{}},
+	"GET /v1/simulations/:id/metrics/latency/jitter/timeseries": {Summary: "Get the latency jitter time series", Tags: []string{"metrics"},
+		Query: metricParams(append([]openapi.Parameter{
+			resolutionParam, nodeParam, openapi.Query("topN", "integer", "Only the pairs with the most jitter"),
+		}, pairParams...)...),
+		Response: types.JitterTimeSeriesResponse{}},
+	"GET /v1/simulations/:id/metrics/timeouts/timeseries": {Summary: "Get the timeout time series", Tags: []string{"metrics"},
+		Query: metricParams(nodeParam, resolutionParam), Response: types.TimeoutTimeSeriesResponse{}},
+	"GET /v1/simulations/:id/metrics/messages/timeseries": {Summary: "Get the event rate time series", Tags: []string{"metrics"},
+		Description: "Events per time bucket, one series per event type or node with [bucket start (Unix ms), events] points.",
+		Query: params(timeWindowParams, []openapi.Parameter{
+			resolutionParam,
+			openapi.Query("type", "string", "Only this event type; repeatable"),
+			openapi.Query("nodeId", "string", "Only events of this node; repeatable"),
+			openapi.QueryEnum("groupBy", "One series per event type (default) or per node", "type", "node"),
+		}),
+		Response: types.MessageTimeSeriesResponse{}},
+	"GET /v1/simulations/:id/metrics/messages/success_rate/timeseries": {Summary: "Get the per-pair delivery time series", Tags: []string{"metrics"},
+		Description: "Vote sends, receives and success rate per sender→receiver pair and time bucket, worst pairs first.",
+		Query: metricParams(resolutionParam,
+			openapi.Query("topN", "integer", "Only the pairs with the lowest mean success rate")),
+		Response: types.DeliveryTimeSeriesResponse{}},
+	"GET /v1/simulations/:id/metrics/messages/success_rate": {Summary: "Get message delivery success rates", Tags: []string{"metrics"},
+		Query:    metricParams(openapi.QueryEnum("aggregate", "Collapse the rows per height and pair", "pair", "height", "overall")),
+		Response: []types.MessageSuccessRate{}},
+	"GET /v1/simulations/:id/metrics/latency/end_to_end": {Summary: "Get end-to-end block latency per height", Tags: []string{"metrics"},
+		Query: metricParams(), Response: []types.BlockConsensusLatency{}},
+	"GET /v1/simulations/:id/metrics/rounds/durations": {Summary: "List round durations", Tags: []string{"metrics"},
+		Description: "Returns a PaginatedRoundDurationSummaryResponse instead when aggregate is true.",
+		Query:       metricParams(append([]openapi.Parameter{openapi.Query("aggregate", "boolean", "Summarize per round")}, pageParams...)...),
+		Response:    types.PaginatedRoundDurationsResponse{}},
+	"GET /v1/simulations/:id/metrics/proposers": {Summary: "Get proposer statistics", Tags: []string{"metrics"},
+		Query: metricParams(), Response: []types.ProposerStats{}},
+	"GET /v1/simulations/:id/metrics/blocks/intervals": {Summary: "Get block intervals", Tags: []string{"metrics"},
+		Query: metricParams(
+			openapi.Query("marker", "string", "Event type marking a block (default enteringCommitStep)"),
+			openapi.Query("window", "integer", "Moving average window in heights (1 to 1000)"),
+		),
+		Response: []types.BlockInterval{}},
+	"GET /v1/simulations/:id/metrics/blocks/assembly": {Summary: "Get block assembly times per height and node", Tags: []string{"metrics"},
+		Description: "Returns a list of NodeBlockAssembly instead when aggregate is node.",
+		Query:       metricParams(append([]openapi.Parameter{openapi.QueryEnum("aggregate", "Summarize per node over the range", "node")}, pageParams...)...),
+		Response:    types.PaginatedBlockAssemblyResponse{}},
+	"GET /v1/simulations/:id/metrics/votes/participation": {Summary: "Get vote participation", Tags: []string{"metrics"},
+		Query:    metricParams(append([]openapi.Parameter{openapi.Query("byRound", "boolean", "Break down per round")}, pageParams...)...),
+		Response: types.VoteParticipationResponse{}},
+	"GET /v1/simulations/:id/metrics/votes/anomalies": {Summary: "List vote anomalies", Tags: []string{"metrics"},
+		Query: metricParams(append([]openapi.Parameter{
+			nodeParam,
+			openapi.QueryList("status", "Only anomalies with these statuses"),
+			openapi.Query("tolerance", "string", "Slack for votes received shortly after the commit, e.g. 50ms"),
+		}, pageParams...)...),
+		Response: types.PaginatedVoteAnomaliesResponse{}},
+	"GET /v1/simulations/:id/metrics/throughput": {Summary: "Get block and transaction throughput", Tags: []string{"metrics"},
+		Query: metricParams(resolutionParam), Response: types.ThroughputResponse{}},
+	"GET /v1/simulations/:id/metrics/steps/durations": {Summary: "Get consensus step durations", Tags: []string{"metrics"},
+		Query: metricParams(nodeParam), Response: []types.StepDuration{}},
+	"GET /v1/simulations/:id/metrics/steps/funnel": {Summary: "Get the consensus step funnel", Tags: []string{"metrics"},
+		Query: metricParams(), Response: types.StepFunnelResponse{}},
+	"GET /v1/simulations/:id/metrics/commit/lag": {Summary: "Get the commit lag of the nodes", Tags: []string{"metrics"},
+		Query: metricParams(append([]openapi.Parameter{nodeParam}, pageParams...)...), Response: types.CommitLagResponse{}},
+	"GET /v1/simulations/:id/metrics/nodes/ranking": {Summary: "Rank the nodes", Tags: []string{"metrics"},
+		Query: metricParams(), Response: []types.NodeRanking{}},
+	"GET /v1/simulations/:id/metrics/summary": {Summary: "Get a summary of the simulation metrics", Tags: []string{"metrics"},
+		Query: metricParams(), Response: types.MetricsSummary{}},
+	"GET /v1/simulations/:id/metrics/vote/statistics": {Summary: "Get vote statistics", Tags: []string{"metrics"},
+		Query: metricParams(), Response: []types.VoteStatisticsResponse{}},
+	"GET /v1/simulations/:id/metrics/network/partitions": {Summary: "Detect network partitions", Tags: []string{"metrics"},
+		Query: metricParams(
+			openapi.Query("window", "string", "Detection window between 1s and 10m (default 5s)"),
+			openapi.Query("threshold", "number", "Delivery rate in (0, 1] below which a pair counts as partitioned (default 0.5)"),
+			openapi.Query("maxLatency", "string", "Latency above which a message counts as undelivered, e.g. 2s"),
+			openapi.Query("minDuration", "string", "Minimum partition duration (default the window)"),
+			openapi.Query("minAffectedPairs", "integer", "Minimum partitioned pairs"),
+		),
+		Response: types.NetworkPartitionsResponse{}},
+	"GET /v1/simulations/:id/metrics/network/fanout": {Summary: "Get the gossip fan-out per height and node", Tags: []string{"metrics"},
+		Description: "Returns a NodeFanoutResponse instead when aggregate is node.",
+		Query: metricParams(append([]openapi.Parameter{
+			openapi.QueryEnum("aggregate", "Summarize per node over the range", "node"),
+			openapi.Query("window", "string", "Window low degrees are detected in with aggregate=node, between 1s and 10m (default 5s)"),
+			openapi.Query("threshold", "number", "Fraction in (0, 1] of the node's peak window degree below which a window is low (default 0.5)"),
+		}, pageParams...)...),
+		Response: types.PaginatedFanoutResponse{}},
+	"GET /v1/simulations/:id/metrics/network/latency/stats": {Summary: "Get network latency statistics per node pair", Tags: []string{"metrics"},
+		Query: timeWindowParams, Response: []latency.NodePairLatencyStats{}},
+	"GET /v1/simulations/:id/metrics/network/latency/node-stats": {Summary: "List network latency statistics per node", Tags: []string{"metrics"},
+		Query: params(timeWindowParams, []openapi.Parameter{
+			openapi.QueryList("nodeId", "Only these nodes"),
+			openapi.Query("sortBy", "string", "Sort field (default nodeId)"),
+			openapi.QueryEnum("order", "Sort order", "asc", "desc"),
+			openapi.Query("limit", "integer", "Nodes per page (default 100, max 1000)"),
+			openapi.Query("offset", "integer", "Nodes to skip"),
+		}),
+		Response: types.PaginatedNodeNetworkStatsResponse{}},
+	"GET /v1/simulations/:id/metrics/network/latency/overview": {Summary: "Get an overview of the network latency", Tags: []string{"metrics"},
+		Query: timeWindowParams, Response: types.NetworkLatencyOverviewResponse{}},
+}
+
+// apiSpec builds the OpenAPI document of routes. Every route is included;
+// routes missing from routeDocs are logged and get a bare operation.
+func apiSpec(routes gin.RoutesInfo) *openapi.Document {
+	spec := openapi.New(openapi.Info{
+		Title:   "CometBFT Analyzer API",
+		Version: "1.0.0",
+	})
+	for _, route := range routes {
+		op, ok := routeDocs[route.Method+" "+route.Path]
+		if !ok {
+			log.Printf("Warning: Route %s %s is not described in the OpenAPI document", route.Method, route.Path)
+		}
+		spec.Add(route.Method, route.Path, op)
+	}
+	return spec
+}
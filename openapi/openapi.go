@@ -0,0 +1,359 @@
+// Package openapi builds an OpenAPI 3 document from route descriptions,
+// deriving the request and response schemas from the Go types by reflection.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Version is the OpenAPI version of the generated documents
+const Version = "3.0.3"
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components Components                       `json:"components"`
+
+	names map[string]reflect.Type // Go types of the component schemas
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Components holds the schemas of the named Go types referenced by operations
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is the subset of the OpenAPI schema object the builder emits
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Explode     *bool   `json:"explode,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Query returns an optional query parameter of a primitive type: string,
+// integer, number, boolean or date-time (an RFC3339 string)
+func Query(name, typ, description string) Parameter {
+	schema := &Schema{Type: typ}
+	if typ == "date-time" {
+		schema = &Schema{Type: "string", Format: "date-time"}
+	}
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// QueryEnum returns an optional string query parameter limited to values
+func QueryEnum(name, description string, values ...string) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: "string", Enum: values}}
+}
+
+// QueryList returns an optional query parameter that may be repeated
+func QueryList(name, description string) Parameter {
+	explode := true
+	return Parameter{Name: name, In: "query", Description: description, Explode: &explode,
+		Schema: &Schema{Type: "array", Items: &Schema{Type: "string"}}}
+}
+
+// FormField is a field of a multipart/form-data request body
+type FormField struct {
+	Name        string
+	Description string
+	File        bool // An uploaded file rather than a text value
+	Multiple    bool // May be given more than once
+	Required    bool
+}
+
+// Operation describes a route. Body and Response are values of the Go types
+// sent and returned as JSON; their schemas are derived by reflection.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Query       []Parameter
+	Body        any         // JSON request body
+	Form        []FormField // multipart/form-data request body, accepted besides Body when both are set
+	Status      int         // Success status, 200 when zero
+	Response    any         // JSON response body, nil for a response without a documented body
+	ContentType string      // Response content type when it is not JSON, e.g. for downloads
+}
+
+type operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	OperationID string               `json:"operationId"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *requestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type requestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type response struct {
+	Description string                `json:"description"`
+	Content     map[string]*mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New returns an empty document
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      map[string]map[string]*operation{},
+		Components: Components{Schemas: map[string]*Schema{}},
+		names:      map[string]reflect.Type{},
+	}
+}
+
+// pathParam matches the :name and *name segments of a gin route
+var pathParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// Add describes the route method path, given in gin syntax such as
+// /v1/simulations/:id. Path parameters are added from the path.
+func (d *Document) Add(method, path string, op Operation) {
+	spec := &operation{
+		Summary:     op.Summary,
+		Description: op.Description,
+		Tags:        op.Tags,
+		OperationID: operationID(method, path),
+		Responses:   map[string]*response{},
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		spec.Parameters = append(spec.Parameters, Parameter{
+			Name: match[1], In: "path", Required: true, Schema: &Schema{Type: "string"},
+		})
+	}
+	spec.Parameters = append(spec.Parameters, op.Query...)
+
+	if op.Body != nil || len(op.Form) > 0 {
+		spec.RequestBody = &requestBody{Required: true, Content: map[string]*mediaType{}}
+		if op.Body != nil {
+			spec.RequestBody.Content["application/json"] = &mediaType{Schema: d.schemaOf(reflect.TypeOf(op.Body))}
+		}
+		if len(op.Form) > 0 {
+			spec.RequestBody.Content["multipart/form-data"] = &mediaType{Schema: formSchema(op.Form)}
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &response{Description: http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		success.Content = map[string]*mediaType{op.ContentType: {Schema: &Schema{Type: "string", Format: "binary"}}}
+	case op.Response != nil:
+		success.Content = map[string]*mediaType{"application/json": {Schema: d.schemaOf(reflect.TypeOf(op.Response))}}
+	}
+	spec.Responses[fmt.Sprint(status)] = success
+	spec.Responses["default"] = &response{
+		Description: "Error",
+		Content:     map[string]*mediaType{"application/json": {Schema: d.schemaOf(reflect.TypeOf(Error{}))}},
+	}
+
+	openAPIPath := pathParam.ReplaceAllString(path, "{$1}")
+	if d.Paths[openAPIPath] == nil {
+		d.Paths[openAPIPath] = map[string]*operation{}
+	}
+	d.Paths[openAPIPath][strings.ToLower(method)] = spec
+}
+
+// Error is the body of error responses
+type Error struct {
+	Error string `json:"error"`
+}
+
+// operationID derives a unique operation id from the method and path
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// formSchema describes a multipart/form-data body
+func formSchema(fields []FormField) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for _, field := range fields {
+		property := &Schema{Type: "string", Description: field.Description}
+		if field.File {
+			property.Format = "binary"
+		}
+		if field.Multiple {
+			property = &Schema{Type: "array", Description: field.Description, Items: &Schema{Type: property.Type, Format: property.Format}}
+		}
+		schema.Properties[field.Name] = property
+		if field.Required {
+			schema.Required = append(schema.Required, field.Name)
+		}
+	}
+	return schema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	objectIDType   = reflect.TypeOf(primitive.ObjectID{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	invalidNameRun = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// schemaOf returns the schema of t, adding named struct types to the
+// components and referencing them
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == objectIDType:
+		return &Schema{Type: "string", Description: "ObjectID (24 hex characters)"}
+	case t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		// Custom JSON encodings are not described further
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := d.schemaOf(t.Elem())
+		if schema.Ref != "" {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: integerFormat(t)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name := d.componentName(t)
+		if _, exists := d.Components.Schemas[name]; !exists {
+			// Registered before the fields so recursive types terminate
+			d.Components.Schemas[name] = &Schema{}
+			*d.Components.Schemas[name] = *d.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		// Interfaces hold any JSON value
+		return &Schema{}
+	}
+}
+
+// integerFormat returns int64 for 64 bit integers
+func integerFormat(t reflect.Type) string {
+	if t.Bits() == 64 {
+		return "int64"
+	}
+	return "int32"
+}
+
+// componentName names the component of a struct type, qualified by its
+// package when another package has a type of the same name
+func (d *Document) componentName(t reflect.Type) string {
+	name := invalidNameRun.ReplaceAllString(t.Name(), "_")
+	if other, exists := d.names[name]; exists && other != t {
+		pkg := t.PkgPath()
+		name = invalidNameRun.ReplaceAllString(pkg[strings.LastIndex(pkg, "/")+1:], "_") + "." + name
+	}
+	d.names[name] = t
+	return name
+}
+
+// structSchema describes the JSON encoding of a struct type: exported fields
+// under their json names, promoted fields of embedded structs and, unless
+// omitempty, required
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				embedded := d.structSchema(fieldType)
+				for property, propertySchema := range embedded.Properties {
+					schema.Properties[property] = propertySchema
+				}
+				schema.Required = append(schema.Required, embedded.Required...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = d.schemaOf(fieldType)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestAPISpecIsValidOpenAPI builds the document of every route in routeDocs
+// and checks the rules of the OpenAPI 3.0 specification the builder can break:
+// structure, path templating, unique operation ids and parameters, response
+// codes and resolvable $refs.
+func TestAPISpecIsValidOpenAPI(t *testing.T) {
+	routes := gin.RoutesInfo{}
+	for route := range routeDocs {
+		method, path, _ := strings.Cut(route, " ")
+		routes = append(routes, gin.RouteInfo{Method: method, Path: path})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path+routes[i].Method < routes[j].Path+routes[j].Method })
+
+	encoded, err := json.Marshal(apiSpec(routes))
+	if err != nil {
+		t.Fatalf("marshal the document: %v", err)
+	}
+	// Validate the serialized document, as clients see it
+	var doc map[string]any
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := validateOpenAPI(doc)
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+
+	operations := 0
+	for _, item := range doc["paths"].(map[string]any) {
+		operations += len(item.(map[string]any))
+	}
+	if operations != len(routeDocs) {
+		t.Errorf("document has %d operations, want %d", operations, len(routeDocs))
+	}
+}
+
+func TestValidateOpenAPIReportsViolations(t *testing.T) {
+	var doc map[string]any
+	err := json.Unmarshal([]byte(`{
+		"openapi": "3.1.0",
+		"info": {"title": "t", "version": "1"},
+		"paths": {
+			"/v1/things/{id}": {
+				"get": {"operationId": "getThing", "parameters": [{"name": "other", "in": "path", "required": true, "schema": {"type": "string"}}],
+					"responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Missing"}}}}}},
+				"put": {"operationId": "getThing", "responses": {"20": {"description": ""}}}
+			}
+		},
+		"components": {"schemas": {"Thing": {"type": "object", "required": ["name"], "properties": {"tags": {"type": "array"}}}}}
+	}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := strings.Join(validateOpenAPI(doc), "\n")
+	for _, want := range []string{
+		`openapi = "3.1.0"`,
+		`path parameter "id" is not declared`,
+		`path parameter "other" is not in the path`,
+		`$ref "#/components/schemas/Missing" does not resolve`,
+		`operationId "getThing" is also used`,
+		`response code "20" is invalid`,
+		`response 20 has no description`,
+		`required property "name" is not defined`,
+		`items must be given exactly for arrays`,
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("violation %q not reported in:\n%s", want, problems)
+		}
+	}
+}
+
+var (
+	openAPIVersion   = regexp.MustCompile(`^3\.0\.\d+$`)
+	responseCode     = regexp.MustCompile(`^([1-5]\d\d|[1-5]XX|default)$`)
+	componentKey     = regexp.MustCompile(`^[a-zA-Z0-9.\-_]+$`)
+	pathTemplate     = regexp.MustCompile(`\{([^{}/]+)\}`)
+	operationMethods = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	parameterIn      = map[string]bool{"query": true, "header": true, "path": true, "cookie": true}
+	schemaTypes      = map[string]bool{"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true}
+)
+
+// validateOpenAPI returns the violations of the OpenAPI 3.0 rules in doc
+func validateOpenAPI(doc map[string]any) []string {
+	var problems []string
+	report := func(format string, args ...any) { problems = append(problems, fmt.Sprintf(format, args...)) }
+
+	if version, _ := doc["openapi"].(string); !openAPIVersion.MatchString(version) {
+		report("openapi = %q, want 3.0.x", version)
+	}
+	info, _ := doc["info"].(map[string]any)
+	if title, _ := info["title"].(string); title == "" {
+		report("info.title is missing")
+	}
+	if version, _ := info["version"].(string); version == "" {
+		report("info.version is missing")
+	}
+
+	components, _ := doc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	for name, schema := range schemas {
+		if !componentKey.MatchString(name) {
+			report("component name %q is not allowed", name)
+		}
+		validateSchema(schema, "#/components/schemas/"+name, schemas, report)
+	}
+
+	paths, ok := doc["paths"].(map[string]any)
+	if !ok {
+		report("paths is missing")
+	}
+	operationIDs := map[string]string{}
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, ":*") {
+			report("path %q is not an OpenAPI path template", path)
+		}
+		templated := map[string]bool{}
+		for _, match := range pathTemplate.FindAllStringSubmatch(path, -1) {
+			templated[match[1]] = true
+		}
+
+		for method, value := range item.(map[string]any) {
+			where := strings.ToUpper(method) + " " + path
+			if !operationMethods[method] {
+				report("%s: %q is not an HTTP method", where, method)
+				continue
+			}
+			operation := value.(map[string]any)
+
+			id, _ := operation["operationId"].(string)
+			if id == "" {
+				report("%s: operationId is missing", where)
+			} else if other, exists := operationIDs[id]; exists {
+				report("%s: operationId %q is also used by %s", where, id, other)
+			}
+			operationIDs[id] = where
+
+			pathParams := map[string]bool{}
+			seen := map[string]bool{}
+			parameters, _ := operation["parameters"].([]any)
+			for _, value := range parameters {
+				parameter := value.(map[string]any)
+				name, _ := parameter["name"].(string)
+				in, _ := parameter["in"].(string)
+				if name == "" || !parameterIn[in] {
+					report("%s: parameter %q has invalid location %q", where, name, in)
+				}
+				if seen[in+" "+name] {
+					report("%s: duplicate %s parameter %q", where, in, name)
+				}
+				seen[in+" "+name] = true
+				if in == "path" {
+					pathParams[name] = true
+					if required, _ := parameter["required"].(bool); !required {
+						report("%s: path parameter %q must be required", where, name)
+					}
+				}
+				if _, ok := parameter["schema"]; !ok {
+					report("%s: parameter %q has no schema", where, name)
+				}
+				validateSchema(parameter["schema"], where+" parameter "+name, schemas, report)
+			}
+			for name := range templated {
+				if !pathParams[name] {
+					report("%s: path parameter %q is not declared", where, name)
+				}
+			}
+			for name := range pathParams {
+				if !templated[name] {
+					report("%s: path parameter %q is not in the path", where, name)
+				}
+			}
+
+			if body, ok := operation["requestBody"].(map[string]any); ok {
+				content, _ := body["content"].(map[string]any)
+				if len(content) == 0 {
+					report("%s: requestBody has no content", where)
+				}
+				for mediaType, media := range content {
+					validateSchema(media.(map[string]any)["schema"], where+" request "+mediaType, schemas, report)
+				}
+			}
+
+			responses, _ := operation["responses"].(map[string]any)
+			if len(responses) == 0 {
+				report("%s: responses are missing", where)
+			}
+			for code, value := range responses {
+				if !responseCode.MatchString(code) {
+					report("%s: response code %q is invalid", where, code)
+				}
+				response := value.(map[string]any)
+				if description, _ := response["description"].(string); description == "" {
+					report("%s: response %s has no description", where, code)
+				}
+				content, _ := response["content"].(map[string]any)
+				for mediaType, media := range content {
+					validateSchema(media.(map[string]any)["schema"], where+" response "+code+" "+mediaType, schemas, report)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// validateSchema checks a schema object and its subschemas
+func validateSchema(value any, where string, schemas map[string]any, report func(string, ...any)) {
+	schema, ok := value.(map[string]any)
+	if !ok {
+		report("%s: schema is not an object", where)
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if len(schema) > 1 {
+			report("%s: $ref has siblings, which OpenAPI 3.0 ignores", where)
+		}
+		name, found := strings.CutPrefix(ref, "#/components/schemas/")
+		if _, exists := schemas[name]; !found || !exists {
+			report("%s: $ref %q does not resolve", where, ref)
+		}
+		return
+	}
+
+	typ, hasType := schema["type"].(string)
+	if hasType && !schemaTypes[typ] {
+		report("%s: type %q is invalid", where, typ)
+	}
+	if _, hasItems := schema["items"]; hasItems != (typ == "array") {
+		report("%s: items must be given exactly for arrays", where)
+	}
+	if items, ok := schema["items"]; ok {
+		validateSchema(items, where+"[]", schemas, report)
+	}
+	if additional, ok := schema["additionalProperties"]; ok {
+		validateSchema(additional, where+"{}", schemas, report)
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	for name, property := range properties {
+		validateSchema(property, where+"."+name, schemas, report)
+	}
+	required, _ := schema["required"].([]any)
+	seen := map[string]bool{}
+	for _, value := range required {
+		name, _ := value.(string)
+		if _, ok := properties[name]; !ok {
+			report("%s: required property %q is not defined", where, name)
+		}
+		if seen[name] {
+			report("%s: property %q is required twice", where, name)
+		}
+		seen[name] = true
+	}
+	if enum, ok := schema["enum"].([]any); ok && len(enum) == 0 {
+		report("%s: enum is empty", where)
+	}
+}
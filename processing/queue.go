@@ -0,0 +1,249 @@
+package processing
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job represents a simulation waiting in the processing queue
+type Job struct {
+	SimulationID primitive.ObjectID
+	Priority     int
+	EnqueuedAt   time.Time
+
+	seq   uint64 // insertion order, used as FIFO tie-breaker
+	index int    // position in the heap
+}
+
+// jobHeap orders jobs by priority (highest first), then by insertion order
+type jobHeap []*Job
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x any) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*h = old[:n-1]
+	return job
+}
+
+// Queue is a priority queue of simulations awaiting processing.
+// Jobs with a higher priority are dequeued first; jobs with equal
+// priority are dequeued in the order they were enqueued.
+type Queue struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	jobs   jobHeap
+	byID   map[primitive.ObjectID]*Job
+	seq    uint64
+	closed bool
+
+	workers   sync.WaitGroup
+	ctx       context.Context // Passed to running jobs, canceled to interrupt them
+	interrupt context.CancelFunc
+}
+
+// NewQueue creates an empty processing queue
+func NewQueue() *Queue {
+	q := &Queue{
+		byID: make(map[primitive.ObjectID]*Job),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	q.ctx, q.interrupt = context.WithCancel(context.Background())
+	return q
+}
+
+// Enqueue adds a simulation to the queue. It returns false if the
+// simulation is already queued or the queue has been closed.
+func (q *Queue) Enqueue(simulationID primitive.ObjectID, priority int) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return false
+	}
+	if _, exists := q.byID[simulationID]; exists {
+		return false
+	}
+
+	q.seq++
+	job := &Job{
+		SimulationID: simulationID,
+		Priority:     priority,
+		EnqueuedAt:   time.Now(),
+		seq:          q.seq,
+	}
+	heap.Push(&q.jobs, job)
+	q.byID[simulationID] = job
+	q.cond.Signal()
+	return true
+}
+
+// SetPriority changes the priority of a queued simulation and reorders
+// the queue. It returns false if the simulation is not queued.
+func (q *Queue) SetPriority(simulationID primitive.ObjectID, priority int) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, exists := q.byID[simulationID]
+	if !exists {
+		return false
+	}
+	job.Priority = priority
+	heap.Fix(&q.jobs, job.index)
+	return true
+}
+
+// Contains reports whether a simulation is waiting in the queue
+func (q *Queue) Contains(simulationID primitive.ObjectID) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	_, exists := q.byID[simulationID]
+	return exists
+}
+
+// Remove drops a simulation from the queue. It returns false if the
+// simulation was not queued.
+func (q *Queue) Remove(simulationID primitive.ObjectID) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, exists := q.byID[simulationID]
+	if !exists {
+		return false
+	}
+	heap.Remove(&q.jobs, job.index)
+	delete(q.byID, simulationID)
+	return true
+}
+
+// Snapshot returns the queued jobs in the order they will be dequeued
+func (q *Queue) Snapshot() []Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	ordered := make(jobHeap, len(q.jobs))
+	for i, job := range q.jobs {
+		copied := *job
+		ordered[i] = &copied
+	}
+
+	jobs := make([]Job, 0, len(ordered))
+	for ordered.Len() > 0 {
+		jobs = append(jobs, *heap.Pop(&ordered).(*Job))
+	}
+	return jobs
+}
+
+// Len returns the number of queued jobs
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.jobs)
+}
+
+// Next blocks until a job is available and removes it from the queue.
+// It returns false once the queue has been closed.
+func (q *Queue) Next() (Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return Job{}, false
+	}
+
+	job := heap.Pop(&q.jobs).(*Job)
+	delete(q.byID, job.SimulationID)
+	return *job, true
+}
+
+// Close wakes up all waiting workers and stops the queue from accepting jobs
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Shutdown closes the queue and waits for the running jobs to finish. Once
+// ctx is done, the context of the running jobs is canceled and Shutdown
+// waits for them to return before reporting ctx's error. It returns the jobs
+// that were still queued and will not run.
+func (q *Queue) Shutdown(ctx context.Context) ([]Job, error) {
+	q.mutex.Lock()
+	q.closed = true
+	remaining := make([]Job, 0, len(q.jobs))
+	for len(q.jobs) > 0 {
+		job := heap.Pop(&q.jobs).(*Job)
+		delete(q.byID, job.SimulationID)
+		remaining = append(remaining, *job)
+	}
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return remaining, nil
+	case <-ctx.Done():
+		q.interrupt()
+		<-done
+		return remaining, ctx.Err()
+	}
+}
+
+// Start launches the given number of workers, each of which pulls jobs
+// from the queue and hands them to process until the queue is closed. The
+// context passed to process is canceled when Shutdown runs out of time.
+func (q *Queue) Start(workers int, process func(context.Context, Job)) {
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			for {
+				job, ok := q.Next()
+				if !ok {
+					return
+				}
+				process(q.ctx, job)
+			}
+		}()
+	}
+}
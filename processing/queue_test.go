@@ -0,0 +1,227 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newIDs returns n distinct simulation IDs
+func newIDs(n int) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, n)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	return ids
+}
+
+// drain dequeues every job of a queue that is not being worked on
+func drain(t *testing.T, q *Queue) []primitive.ObjectID {
+	t.Helper()
+	var order []primitive.ObjectID
+	for q.Len() > 0 {
+		job, ok := q.Next()
+		if !ok {
+			t.Fatal("Next reported a closed queue while jobs were queued")
+		}
+		order = append(order, job.SimulationID)
+	}
+	return order
+}
+
+func assertOrder(t *testing.T, got, want []primitive.ObjectID) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("job %d: got %s, want %s", i, got[i].Hex(), want[i].Hex())
+		}
+	}
+}
+
+func TestQueueOrdersByPriorityThenFIFO(t *testing.T) {
+	ids := newIDs(5)
+	q := NewQueue()
+	q.Enqueue(ids[0], 0)
+	q.Enqueue(ids[1], 5)
+	q.Enqueue(ids[2], 0)
+	q.Enqueue(ids[3], 5)
+	q.Enqueue(ids[4], -1)
+
+	want := []primitive.ObjectID{ids[1], ids[3], ids[0], ids[2], ids[4]}
+	snapshot := q.Snapshot()
+	snapshotIDs := make([]primitive.ObjectID, len(snapshot))
+	for i, job := range snapshot {
+		snapshotIDs[i] = job.SimulationID
+	}
+	assertOrder(t, snapshotIDs, want)
+	if q.Len() != len(ids) {
+		t.Fatalf("Snapshot changed the queue: %d jobs left", q.Len())
+	}
+	assertOrder(t, drain(t, q), want)
+}
+
+func TestQueueRejectsDuplicates(t *testing.T) {
+	id := primitive.NewObjectID()
+	q := NewQueue()
+	if !q.Enqueue(id, 0) {
+		t.Fatal("first Enqueue rejected")
+	}
+	if q.Enqueue(id, 10) {
+		t.Fatal("duplicate Enqueue accepted")
+	}
+	if q.Len() != 1 {
+		t.Fatalf("got %d jobs, want 1", q.Len())
+	}
+}
+
+func TestQueueSetPriorityReorders(t *testing.T) {
+	ids := newIDs(3)
+	q := NewQueue()
+	for _, id := range ids {
+		q.Enqueue(id, 0)
+	}
+
+	if !q.SetPriority(ids[2], 1) {
+		t.Fatal("SetPriority of a queued job failed")
+	}
+	if !q.SetPriority(ids[0], -1) {
+		t.Fatal("SetPriority of a queued job failed")
+	}
+	if q.SetPriority(primitive.NewObjectID(), 1) {
+		t.Fatal("SetPriority of an unknown job succeeded")
+	}
+	assertOrder(t, drain(t, q), []primitive.ObjectID{ids[2], ids[1], ids[0]})
+}
+
+func TestQueueSetPriorityKeepsFIFOAmongEquals(t *testing.T) {
+	ids := newIDs(3)
+	q := NewQueue()
+	q.Enqueue(ids[0], 1)
+	q.Enqueue(ids[1], 0)
+	q.Enqueue(ids[2], 1)
+
+	// Raising ids[1] to the others' priority keeps it behind them by enqueue order
+	q.SetPriority(ids[1], 1)
+	assertOrder(t, drain(t, q), ids)
+}
+
+func TestQueueRemove(t *testing.T) {
+	ids := newIDs(3)
+	q := NewQueue()
+	for _, id := range ids {
+		q.Enqueue(id, 0)
+	}
+
+	if !q.Remove(ids[1]) {
+		t.Fatal("Remove of a queued job failed")
+	}
+	if q.Remove(ids[1]) {
+		t.Fatal("second Remove succeeded")
+	}
+	if q.Contains(ids[1]) {
+		t.Fatal("removed job still queued")
+	}
+	assertOrder(t, drain(t, q), []primitive.ObjectID{ids[0], ids[2]})
+
+	// A removed simulation can be queued again
+	if !q.Enqueue(ids[1], 0) {
+		t.Fatal("Enqueue after Remove rejected")
+	}
+}
+
+func TestQueueCloseStopsNext(t *testing.T) {
+	q := NewQueue()
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Next()
+		done <- ok
+	}()
+
+	q.Close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("Next returned a job from a closed queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not wake up the waiting Next")
+	}
+	if q.Enqueue(primitive.NewObjectID(), 0) {
+		t.Fatal("closed queue accepted a job")
+	}
+}
+
+func TestQueueShutdownDrains(t *testing.T) {
+	ids := newIDs(3)
+	q := NewQueue()
+	started := make(chan primitive.ObjectID)
+	release := make(chan struct{})
+	q.Start(1, func(ctx context.Context, job Job) {
+		started <- job.SimulationID
+		<-release
+	})
+
+	q.Enqueue(ids[0], 0)
+	if running := <-started; running != ids[0] {
+		t.Fatalf("worker started %s, want %s", running.Hex(), ids[0].Hex())
+	}
+	q.Enqueue(ids[1], 0)
+	q.Enqueue(ids[2], 1)
+
+	result := make(chan []Job)
+	go func() {
+		remaining, err := q.Shutdown(context.Background())
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		result <- remaining
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("Shutdown returned while a job was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	remaining := <-result
+	remainingIDs := make([]primitive.ObjectID, len(remaining))
+	for i, job := range remaining {
+		remainingIDs[i] = job.SimulationID
+	}
+	assertOrder(t, remainingIDs, []primitive.ObjectID{ids[2], ids[1]})
+	if q.Len() != 0 {
+		t.Fatalf("%d jobs left after Shutdown", q.Len())
+	}
+}
+
+func TestQueueShutdownInterruptsOnDeadline(t *testing.T) {
+	q := NewQueue()
+	started := make(chan struct{})
+	interrupted := make(chan struct{})
+	q.Start(1, func(ctx context.Context, job Job) {
+		close(started)
+		<-ctx.Done()
+		close(interrupted)
+	})
+	q.Enqueue(primitive.NewObjectID(), 0)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := q.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-interrupted:
+	default:
+		t.Fatal("Shutdown returned before the interrupted job did")
+	}
+}
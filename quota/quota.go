@@ -0,0 +1,159 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrUserNotFound is returned when storage is reserved for a user that does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ExceededError is returned when an upload would exceed a user's storage quota
+type ExceededError struct {
+	QuotaBytes     int64
+	UsedBytes      int64
+	RequestedBytes int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d bytes used, %d bytes requested", e.UsedBytes, e.QuotaBytes, e.RequestedBytes)
+}
+
+// RemainingBytes returns how many bytes the user may still upload
+func (e *ExceededError) RemainingBytes() int64 {
+	if remaining := e.QuotaBytes - e.UsedBytes; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Quota tracks the bytes stored per user in the users collection and enforces
+// per-user storage quotas. A quota of 0 means unlimited.
+type Quota struct {
+	users        *mongo.Collection
+	defaultBytes int64
+}
+
+// New creates a Quota with the given default quota for users without an override
+func New(users *mongo.Collection, defaultBytes int64) *Quota {
+	return &Quota{users: users, defaultBytes: defaultBytes}
+}
+
+// Limit returns the quota of a user, honoring the per-user override
+func (q *Quota) Limit(user *types.User) int64 {
+	if user.StorageQuotaBytes != nil {
+		return *user.StorageQuotaBytes
+	}
+	return q.defaultBytes
+}
+
+// Usage loads a user and returns the bytes used and the user's quota
+func (q *Quota) Usage(ctx context.Context, userID primitive.ObjectID) (used, limit int64, err error) {
+	var user types.User
+	err = q.users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return 0, 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, 0, err
+	}
+	return user.StorageUsedBytes, q.Limit(&user), nil
+}
+
+// Reserve atomically adds bytes to the user's usage unless that would exceed
+// the quota, in which case an *ExceededError is returned
+func (q *Quota) Reserve(ctx context.Context, userID primitive.ObjectID, bytes int64) error {
+	used, limit, err := q.Usage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": userID}
+	if limit > 0 {
+		if used+bytes > limit {
+			return &ExceededError{QuotaBytes: limit, UsedBytes: used, RequestedBytes: bytes}
+		}
+		// Guard against concurrent uploads reserving the same allowance
+		filter["$or"] = bson.A{
+			bson.M{"storageUsedBytes": bson.M{"$lte": limit - bytes}},
+			bson.M{"storageUsedBytes": bson.M{"$exists": false}},
+		}
+	}
+
+	result, err := q.users.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"storageUsedBytes": bytes}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		used, limit, err := q.Usage(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return &ExceededError{QuotaBytes: limit, UsedBytes: used, RequestedBytes: bytes}
+	}
+	return nil
+}
+
+// Release subtracts bytes from the user's usage after files were deleted
+func (q *Quota) Release(ctx context.Context, userID primitive.ObjectID, bytes int64) error {
+	if bytes == 0 {
+		return nil
+	}
+	_, err := q.users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$inc": bson.M{"storageUsedBytes": -bytes}})
+	return err
+}
+
+// Backfill sets the storage usage of the users that have none recorded, such
+// as users created before usage was tracked, to the size of the log files of
+// their simulations, pending ones included. Users whose usage is already
+// recorded are left alone. It returns the number of users updated.
+func (q *Quota) Backfill(ctx context.Context, simulations *mongo.Collection) (int, error) {
+	cursor, err := simulations.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{
+			"userId": 1,
+			"bytes":  bson.M{"$add": bson.A{bson.M{"$sum": "$logFiles.fileSize"}, bson.M{"$sum": "$pendingLogFiles.fileSize"}}},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$userId", "bytes": bson.M{"$sum": "$bytes"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var usage []struct {
+		UserID primitive.ObjectID `bson:"_id"`
+		Bytes  int64              `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &usage); err != nil {
+		return 0, err
+	}
+	usedBytes := make(map[primitive.ObjectID]int64, len(usage))
+	for _, user := range usage {
+		usedBytes[user.UserID] = user.Bytes
+	}
+
+	missing := bson.M{"storageUsedBytes": bson.M{"$exists": false}}
+	cursor, err = q.users.Find(ctx, missing)
+	if err != nil {
+		return 0, err
+	}
+	var users []types.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, user := range users {
+		// An upload reserving storage meanwhile has set the usage, which then stays
+		filter := bson.M{"_id": user.ID, "storageUsedBytes": bson.M{"$exists": false}}
+		result, err := q.users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"storageUsedBytes": usedBytes[user.ID]}})
+		if err != nil {
+			return updated, err
+		}
+		updated += int(result.ModifiedCount)
+	}
+	return updated, nil
+}
@@ -0,0 +1,65 @@
+package quota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db/dbtest"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBackfill(t *testing.T) {
+	database := dbtest.Database(t)
+	users, simulations := database.Collection("users"), database.Collection("simulations")
+	ctx := context.Background()
+
+	legacy, idle, tracked := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := users.InsertMany(ctx, []any{
+		bson.M{"_id": legacy, "username": "legacy"},
+		bson.M{"_id": idle, "username": "idle"},
+		bson.M{"_id": tracked, "username": "tracked", "storageUsedBytes": int64(7)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	logFiles := func(sizes ...int64) bson.A {
+		files := bson.A{}
+		for _, size := range sizes {
+			files = append(files, bson.M{"fileSize": size})
+		}
+		return files
+	}
+	if _, err := simulations.InsertMany(ctx, []any{
+		bson.M{"userId": legacy, "logFiles": logFiles(100, 20), "pendingLogFiles": logFiles(3)},
+		bson.M{"userId": legacy},
+		bson.M{"userId": legacy, "logFiles": logFiles(1000)},
+		bson.M{"userId": tracked, "logFiles": logFiles(50)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	quotas := New(users, 0)
+	updated, err := quotas.Backfill(ctx, simulations)
+	if err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+	if updated != 2 {
+		t.Errorf("updated %d users, want 2", updated)
+	}
+
+	// Recorded usage is kept even when it disagrees with the log files
+	for id, want := range map[primitive.ObjectID]int64{legacy: 1123, idle: 0, tracked: 7} {
+		var user types.User
+		if err := users.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+			t.Fatal(err)
+		}
+		if user.StorageUsedBytes != want {
+			t.Errorf("%s: storageUsedBytes = %d, want %d", user.Username, user.StorageUsedBytes, want)
+		}
+	}
+
+	if updated, err := quotas.Backfill(ctx, simulations); err != nil || updated != 0 {
+		t.Errorf("second Backfill = %d, %v, want nothing left to update", updated, err)
+	}
+}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventFilter selects the consensus events of a simulation. Zero fields do not filter.
+type EventFilter struct {
+	From         time.Time // Inclusive start of the time window
+	To           time.Time // Inclusive end of the time window
+	Types        []string  // Only these event types
+	ExcludeTypes []string  // None of these event types
+	NodeIDs      []string  // Emitted by one of these nodes
+	Peers        []string  // Sent or received by one of these peers
+	Heights      types.HeightRange
+
+	After  *utils.EventCursor // Only events after this position in the timeline
+	Before *utils.EventCursor // Only events before this position in the timeline
+}
+
+// Match converts filter into a query. Step events carry the height at the top
+// level and p2p vote events in vote.height, so both are matched.
+func (filter EventFilter) Match() bson.M {
+	match := bson.M{}
+
+	typeFilter := bson.M{}
+	if len(filter.ExcludeTypes) > 0 {
+		typeFilter["$nin"] = filter.ExcludeTypes
+	}
+	if len(filter.Types) > 0 {
+		typeFilter["$in"] = filter.Types
+	}
+	if len(typeFilter) > 0 {
+		match["type"] = typeFilter
+	}
+
+	if len(filter.NodeIDs) > 0 {
+		match["nodeId"] = bson.M{"$in": filter.NodeIDs}
+	}
+
+	window := bson.M{}
+	if !filter.From.IsZero() {
+		window["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		window["$lte"] = filter.To
+	}
+	if len(window) > 0 {
+		match["timestamp"] = window
+	}
+
+	var and []bson.M
+	if len(filter.Peers) > 0 {
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"sourcePeerId": bson.M{"$in": filter.Peers}},
+			bson.M{"recipientPeerId": bson.M{"$in": filter.Peers}},
+		}})
+	}
+	heights := bson.M{}
+	if filter.Heights.From != nil {
+		heights["$gte"] = *filter.Heights.From
+	}
+	if filter.Heights.To != nil {
+		heights["$lte"] = *filter.Heights.To
+	}
+	if len(heights) > 0 {
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"height": heights},
+			bson.M{"vote.height": heights},
+		}})
+	}
+	if filter.After != nil {
+		and = append(and, filter.After.After())
+	}
+	if filter.Before != nil {
+		and = append(and, filter.Before.Before())
+	}
+	if len(and) > 0 {
+		match["$and"] = and
+	}
+	return match
+}
+
+// EventPage selects a page of the matching events
+type EventPage struct {
+	Descending bool   // Newest first
+	Skip       int64  // Events skipped before the page
+	Limit      int64  // Maximum number of events, all when zero
+	Fields     bson.M // Projection of the returned documents, whole documents when nil
+}
+
+// EventBucketCount is the number of events of a type in a time bucket
+type EventBucketCount struct {
+	Start time.Time
+	Type  string
+	Count int64
+	First bson.Raw // The earliest event of the type in the bucket
+}
+
+// EventRepo reads the consensus events of a simulation
+type EventRepo interface {
+	// Find returns the matching events of page in timeline order
+	Find(ctx context.Context, filter EventFilter, page EventPage) ([]bson.Raw, error)
+	// Count returns the number of matching events
+	Count(ctx context.Context, filter EventFilter) (int64, error)
+	// EstimatedCount returns the approximate number of events, ignoring filters
+	EstimatedCount(ctx context.Context) (int64, error)
+	// Exists reports whether at least one event matches filter
+	Exists(ctx context.Context, filter EventFilter) (bool, error)
+	// Get returns the event with id, or ErrNotFound
+	Get(ctx context.Context, id primitive.ObjectID) (bson.Raw, error)
+	// Buckets counts the matching events per type in buckets of resolution,
+	// sorted by bucket start and type
+	Buckets(ctx context.Context, filter EventFilter, resolution time.Duration) ([]EventBucketCount, error)
+}
+
+// MongoEventRepo reads events from the tracer_events collection of a simulation database
+type MongoEventRepo struct {
+	collection *mongo.Collection
+}
+
+// NewMongoEventRepo returns an EventRepo backed by collection
+func NewMongoEventRepo(collection *mongo.Collection) *MongoEventRepo {
+	return &MongoEventRepo{collection: collection}
+}
+
+// Find returns the matching events of page in timeline order
+func (r *MongoEventRepo) Find(ctx context.Context, filter EventFilter, page EventPage) ([]bson.Raw, error) {
+	sortOrder := 1
+	if page.Descending {
+		sortOrder = -1
+	}
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter.Match()}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: sortOrder}, {Key: "_id", Value: sortOrder}}}},
+	}
+	if page.Skip > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: page.Skip}})
+	}
+	if page.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: page.Limit}})
+	}
+	if len(page.Fields) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: page.Fields}})
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []bson.Raw
+	for cursor.Next(ctx) {
+		// Current is only valid until the next call to Next
+		events = append(events, append(bson.Raw(nil), cursor.Current...))
+	}
+	return events, cursor.Err()
+}
+
+// Count returns the number of matching events
+func (r *MongoEventRepo) Count(ctx context.Context, filter EventFilter) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter.Match())
+}
+
+// EstimatedCount returns the collection's estimated document count
+func (r *MongoEventRepo) EstimatedCount(ctx context.Context) (int64, error) {
+	return r.collection.EstimatedDocumentCount(ctx)
+}
+
+// Exists reports whether at least one event matches filter
+func (r *MongoEventRepo) Exists(ctx context.Context, filter EventFilter) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, filter.Match(), options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Get returns the event with id
+func (r *MongoEventRepo) Get(ctx context.Context, id primitive.ObjectID) (bson.Raw, error) {
+	raw, err := r.collection.FindOne(ctx, bson.M{"_id": id}).Raw()
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	return raw, err
+}
+
+// Buckets counts the matching events per type in buckets of resolution
+func (r *MongoEventRepo) Buckets(ctx context.Context, filter EventFilter, resolution time.Duration) ([]EventBucketCount, error) {
+	resolutionMs := resolution.Milliseconds()
+	timestampMs := bson.M{"$toLong": "$timestamp"}
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter.Match()}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"bucket": bson.M{"$subtract": bson.A{timestampMs, bson.M{"$mod": bson.A{timestampMs, resolutionMs}}}},
+				"type":   "$type",
+			},
+			"count": bson.M{"$sum": 1},
+			"first": bson.M{"$first": "$$ROOT"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id.bucket", Value: 1}, {Key: "_id.type", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var buckets []EventBucketCount
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID struct {
+				Bucket int64  `bson:"bucket"`
+				Type   string `bson:"type"`
+			} `bson:"_id"`
+			Count int64    `bson:"count"`
+			First bson.Raw `bson:"first"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, EventBucketCount{
+			Start: time.UnixMilli(doc.ID.Bucket).UTC(),
+			Type:  doc.ID.Type,
+			Count: doc.Count,
+			First: append(bson.Raw(nil), doc.First...),
+		})
+	}
+	return buckets, cursor.Err()
+}
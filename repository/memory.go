@@ -0,0 +1,627 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserRepo is a UserRepo kept in memory, for tests. It enforces the same
+// unique usernames and normalized emails as the indexes of MongoUserRepo.
+type MemoryUserRepo struct {
+	mutex sync.Mutex
+	users map[primitive.ObjectID]types.User
+}
+
+// NewMemoryUserRepo returns an empty MemoryUserRepo
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{users: map[primitive.ObjectID]types.User{}}
+}
+
+// Create inserts user and sets its ID
+func (r *MemoryUserRepo) Create(ctx context.Context, user *types.User) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.taken(primitive.NilObjectID, user.Username, user.EmailNormalized) {
+		return ErrDuplicate
+	}
+	user.ID = primitive.NewObjectID()
+	r.users[user.ID] = *user
+	return nil
+}
+
+// taken reports whether a user other than id has username or emailNormalized
+func (r *MemoryUserRepo) taken(id primitive.ObjectID, username, emailNormalized string) bool {
+	for _, other := range r.users {
+		if other.ID == id {
+			continue
+		}
+		if other.Username == username || (emailNormalized != "" && other.EmailNormalized == emailNormalized) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the user with id
+func (r *MemoryUserRepo) Get(ctx context.Context, id primitive.ObjectID) (types.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return types.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// GetByUsername returns the user with the exact username
+func (r *MemoryUserRepo) GetByUsername(ctx context.Context, username string) (types.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return types.User{}, ErrNotFound
+}
+
+// Update applies update, bumping updatedAt, and returns the updated user
+func (r *MemoryUserRepo) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (types.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return types.User{}, ErrNotFound
+	}
+	if update.Username != nil {
+		user.Username = *update.Username
+	}
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+	if update.EmailNormalized != nil {
+		user.EmailNormalized = *update.EmailNormalized
+	}
+	if r.taken(id, user.Username, user.EmailNormalized) {
+		return types.User{}, ErrDuplicate
+	}
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+	return user, nil
+}
+
+// List returns a page of the matching users, newest first
+func (r *MemoryUserRepo) List(ctx context.Context, filter UserFilter, page, perPage int) ([]types.User, int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	search := strings.ToLower(filter.Search)
+	matching := []types.User{}
+	for _, user := range r.users {
+		if search != "" && !strings.Contains(strings.ToLower(user.Username), search) && !strings.Contains(strings.ToLower(user.Email), search) {
+			continue
+		}
+		if filter.Username != "" && user.Username != filter.Username {
+			continue
+		}
+		if filter.EmailNormalized != "" && user.EmailNormalized != filter.EmailNormalized {
+			continue
+		}
+		matching = append(matching, user)
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if !matching[i].CreatedAt.Equal(matching[j].CreatedAt) {
+			return matching[i].CreatedAt.After(matching[j].CreatedAt)
+		}
+		return matching[i].ID.Hex() > matching[j].ID.Hex()
+	})
+
+	total := int64(len(matching))
+	start := min((page-1)*perPage, len(matching))
+	users := matching[start:min(start+perPage, len(matching))]
+	if len(filter.Fields) > 0 {
+		for i, user := range users {
+			users[i] = projectUser(user, filter.Fields)
+		}
+	}
+	return users, total, nil
+}
+
+// projectUser keeps only the fields of user, like the projection of MongoUserRepo.List
+func projectUser(user types.User, fields []string) types.User {
+	projected := types.User{}
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projected.ID = user.ID
+		case "username":
+			projected.Username = user.Username
+		case "email":
+			projected.Email = user.Email
+		case "storageUsedBytes":
+			projected.StorageUsedBytes = user.StorageUsedBytes
+		case "storageQuotaBytes":
+			projected.StorageQuotaBytes = user.StorageQuotaBytes
+		case "createdAt":
+			projected.CreatedAt = user.CreatedAt
+		case "updatedAt":
+			projected.UpdatedAt = user.UpdatedAt
+		}
+	}
+	return projected
+}
+
+// Delete deletes the user with id
+func (r *MemoryUserRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+// BackfillEmailNormalized sets the normalized email of the users lacking one
+func (r *MemoryUserRepo) BackfillEmailNormalized(ctx context.Context) (EmailBackfill, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	users := make([]types.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	updates, duplicates := planEmailBackfill(users)
+	for id, email := range updates {
+		user := r.users[id]
+		user.EmailNormalized = email
+		r.users[id] = user
+	}
+	return EmailBackfill{Updated: len(updates), Duplicates: duplicates}, nil
+}
+
+// MemoryProjectRepo is a ProjectRepo kept in memory, for tests. Projects are
+// listed in insertion order like an unsorted MongoDB query.
+type MemoryProjectRepo struct {
+	mutex    sync.Mutex
+	projects []types.Project
+}
+
+// NewMemoryProjectRepo returns an empty MemoryProjectRepo
+func NewMemoryProjectRepo() *MemoryProjectRepo {
+	return &MemoryProjectRepo{}
+}
+
+// Create inserts project and sets its ID
+func (r *MemoryProjectRepo) Create(ctx context.Context, project *types.Project) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	project.ID = primitive.NewObjectID()
+	r.projects = append(r.projects, *project)
+	return nil
+}
+
+// index returns the position of the project with id, or -1
+func (r *MemoryProjectRepo) index(id primitive.ObjectID) int {
+	return slices.IndexFunc(r.projects, func(project types.Project) bool { return project.ID == id })
+}
+
+// Get returns the project with id
+func (r *MemoryProjectRepo) Get(ctx context.Context, id primitive.ObjectID) (types.Project, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 {
+		return types.Project{}, ErrNotFound
+	}
+	return r.projects[i], nil
+}
+
+// ListByUser returns the matching projects of a user
+func (r *MemoryProjectRepo) ListByUser(ctx context.Context, userID primitive.ObjectID, search string) ([]types.Project, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	projects := []types.Project{}
+	for _, project := range r.projects {
+		if project.UserID == userID && containsFold(search, project.Name, project.Description) {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
+
+// CountByUser returns the number of projects of a user
+func (r *MemoryProjectRepo) CountByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	projects, err := r.ListByUser(ctx, userID, "")
+	return int64(len(projects)), err
+}
+
+// Update applies update, bumping updatedAt, and returns the updated project
+func (r *MemoryProjectRepo) Update(ctx context.Context, id primitive.ObjectID, update ProjectUpdate) (types.Project, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 {
+		return types.Project{}, ErrNotFound
+	}
+	project := &r.projects[i]
+	if update.Name != nil {
+		project.Name = *update.Name
+	}
+	if update.Description != nil {
+		project.Description = *update.Description
+	}
+	if update.RetainRawEventsDays != nil {
+		project.RetainRawEventsDays = *update.RetainRawEventsDays
+	}
+	if update.UserID != nil {
+		project.UserID = *update.UserID
+	}
+	project.UpdatedAt = time.Now()
+	return *project, nil
+}
+
+// Delete deletes the project with id
+func (r *MemoryProjectRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.projects = slices.Delete(r.projects, i, i+1)
+	return nil
+}
+
+// DeleteByUser deletes the projects of a user
+func (r *MemoryProjectRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := len(r.projects)
+	r.projects = slices.DeleteFunc(r.projects, func(project types.Project) bool { return project.UserID == userID })
+	return int64(count - len(r.projects)), nil
+}
+
+// containsFold reports whether any of values contains search case-insensitively,
+// like utils.SearchFilter; an empty search matches all
+func containsFold(search string, values ...string) bool {
+	if search == "" {
+		return true
+	}
+	search = strings.ToLower(search)
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemorySimulationRepo is a SimulationRepo kept in memory, for tests.
+// Simulations are listed in insertion order like an unsorted MongoDB query.
+// Of the per-simulation databases only the events and stubbed metrics are
+// kept, and dropping a database records its ID.
+type MemorySimulationRepo struct {
+	mutex       sync.Mutex
+	simulations []types.Simulation
+	events      map[primitive.ObjectID]*MemoryEventRepo
+	metrics     map[primitive.ObjectID]*StubMetricsRepo
+	dropped     map[primitive.ObjectID]bool
+}
+
+// NewMemorySimulationRepo returns an empty MemorySimulationRepo
+func NewMemorySimulationRepo() *MemorySimulationRepo {
+	return &MemorySimulationRepo{
+		events:  map[primitive.ObjectID]*MemoryEventRepo{},
+		metrics: map[primitive.ObjectID]*StubMetricsRepo{},
+		dropped: map[primitive.ObjectID]bool{},
+	}
+}
+
+// Create inserts simulation, keeping an ID that is already set
+func (r *MemorySimulationRepo) Create(ctx context.Context, simulation *types.Simulation) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if simulation.ID.IsZero() {
+		simulation.ID = primitive.NewObjectID()
+	} else if r.index(simulation.ID) >= 0 {
+		return ErrDuplicate
+	}
+	r.simulations = append(r.simulations, cloneSimulation(*simulation))
+	return nil
+}
+
+// cloneSimulation copies the log file slices of simulation, so stored
+// simulations do not share them with the caller
+func cloneSimulation(simulation types.Simulation) types.Simulation {
+	simulation.LogFiles = slices.Clone(simulation.LogFiles)
+	simulation.PendingLogFiles = slices.Clone(simulation.PendingLogFiles)
+	return simulation
+}
+
+// index returns the position of the simulation with id, or -1
+func (r *MemorySimulationRepo) index(id primitive.ObjectID) int {
+	return slices.IndexFunc(r.simulations, func(simulation types.Simulation) bool { return simulation.ID == id })
+}
+
+// Get returns the simulation with id
+func (r *MemorySimulationRepo) Get(ctx context.Context, id primitive.ObjectID) (types.Simulation, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 {
+		return types.Simulation{}, ErrNotFound
+	}
+	return cloneSimulation(r.simulations[i]), nil
+}
+
+// matches reports whether simulation is selected by filter
+func (filter SimulationFilter) matches(simulation types.Simulation) bool {
+	if !filter.UserID.IsZero() && simulation.UserID != filter.UserID {
+		return false
+	}
+	if !filter.ProjectID.IsZero() && simulation.ProjectID != filter.ProjectID {
+		return false
+	}
+	if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, simulation.Status) {
+		return false
+	}
+	return containsFold(filter.Search, simulation.Name, simulation.Description)
+}
+
+// List returns the matching simulations
+func (r *MemorySimulationRepo) List(ctx context.Context, filter SimulationFilter) ([]types.Simulation, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	simulations := []types.Simulation{}
+	for _, simulation := range r.simulations {
+		if filter.matches(simulation) {
+			simulations = append(simulations, cloneSimulation(simulation))
+		}
+	}
+	return simulations, nil
+}
+
+// Count returns the number of matching simulations
+func (r *MemorySimulationRepo) Count(ctx context.Context, filter SimulationFilter) (int64, error) {
+	simulations, err := r.List(ctx, filter)
+	return int64(len(simulations)), err
+}
+
+// Update applies update, bumping updatedAt, and returns the updated simulation
+func (r *MemorySimulationRepo) Update(ctx context.Context, id primitive.ObjectID, update SimulationUpdate) (types.Simulation, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 {
+		return types.Simulation{}, ErrNotFound
+	}
+	simulation := cloneSimulation(r.simulations[i])
+	if update.IfProcessingStatus != nil && simulation.ProcessingStatus != *update.IfProcessingStatus {
+		return types.Simulation{}, ErrNotFound
+	}
+	if update.IfNotProcessing && simulation.ProcessingStatus == types.ProcessingStatusProcessing {
+		return types.Simulation{}, ErrNotFound
+	}
+	if update.RemoveLogFile != "" {
+		removed := slices.DeleteFunc(simulation.LogFiles, func(logFile types.LogFileInfo) bool {
+			return logFile.FilePath == update.RemoveLogFile
+		})
+		if len(removed) == len(r.simulations[i].LogFiles) {
+			return types.Simulation{}, ErrNotFound
+		}
+		simulation.LogFiles = removed
+	}
+
+	if update.Name != nil {
+		simulation.Name = *update.Name
+	}
+	if update.Description != nil {
+		simulation.Description = *update.Description
+	}
+	if update.Priority != nil {
+		simulation.Priority = *update.Priority
+	}
+	if update.UserID != nil {
+		simulation.UserID = *update.UserID
+	}
+	if update.Status != nil {
+		simulation.Status = *update.Status
+	}
+	if update.ProcessingStatus != nil {
+		simulation.ProcessingStatus = *update.ProcessingStatus
+	}
+	if update.ProcessingResult != nil {
+		result := *update.ProcessingResult
+		simulation.ProcessingResult = &result
+	}
+	if len(update.LogFiles) > 0 {
+		simulation.LogFiles = slices.Clone(update.LogFiles)
+	}
+	if len(update.PendingLogFiles) > 0 {
+		simulation.PendingLogFiles = slices.Clone(update.PendingLogFiles)
+	}
+	simulation.LogFiles = append(simulation.LogFiles, update.AddLogFiles...)
+	simulation.PendingLogFiles = append(simulation.PendingLogFiles, update.AddPendingLogFiles...)
+	if update.ClearDataPruned {
+		simulation.DataPruned = false
+		simulation.DataPrunedAt = nil
+	}
+	simulation.UpdatedAt = time.Now()
+
+	r.simulations[i] = simulation
+	return cloneSimulation(simulation), nil
+}
+
+// TakePendingLogFiles removes the pending log files of a simulation and returns it as it was before
+func (r *MemorySimulationRepo) TakePendingLogFiles(ctx context.Context, id primitive.ObjectID) (types.Simulation, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 || len(r.simulations[i].PendingLogFiles) == 0 {
+		return types.Simulation{}, ErrNotFound
+	}
+	simulation := r.simulations[i]
+	r.simulations[i].PendingLogFiles = nil
+	return simulation, nil
+}
+
+// Delete deletes the simulation with id
+func (r *MemorySimulationRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.simulations = slices.Delete(r.simulations, i, i+1)
+	return nil
+}
+
+// ProjectStats returns the stats of the simulations of each project
+func (r *MemorySimulationRepo) ProjectStats(ctx context.Context, projectIDs []primitive.ObjectID) (map[primitive.ObjectID]*types.ProjectStats, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := make(map[primitive.ObjectID]*types.ProjectStats, len(projectIDs))
+	for _, projectID := range projectIDs {
+		stats[projectID] = types.NewProjectStats()
+	}
+	for _, simulation := range r.simulations {
+		if projectStats, ok := stats[simulation.ProjectID]; ok {
+			addProjectStats(projectStats, simulation.Status, 1, simulation.CreatedAt, totalFileSize(simulation.LogFiles))
+		}
+	}
+	return stats, nil
+}
+
+// totalFileSize sums the sizes of logFiles
+func totalFileSize(logFiles []types.LogFileInfo) int64 {
+	var total int64
+	for _, logFile := range logFiles {
+		total += logFile.FileSize
+	}
+	return total
+}
+
+// LogFileUsage sums the log files of the matching simulations per project
+func (r *MemorySimulationRepo) LogFileUsage(ctx context.Context, filter SimulationFilter) ([]ProjectLogFiles, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var usage []ProjectLogFiles
+	for _, simulation := range r.simulations {
+		if !filter.matches(simulation) {
+			continue
+		}
+		i := slices.IndexFunc(usage, func(project ProjectLogFiles) bool { return project.Usage.ProjectID == simulation.ProjectID })
+		if i < 0 {
+			usage = append(usage, ProjectLogFiles{Usage: types.ProjectStorageUsage{ProjectID: simulation.ProjectID}})
+			i = len(usage) - 1
+		}
+		usage[i].Usage.UsedBytes += totalFileSize(simulation.LogFiles)
+		usage[i].Usage.LogFiles += len(simulation.LogFiles)
+		usage[i].Simulations = append(usage[i].Simulations, types.Simulation{
+			ID: simulation.ID, ProjectID: simulation.ProjectID, UserID: simulation.UserID,
+		})
+	}
+	sort.SliceStable(usage, func(i, j int) bool {
+		if usage[i].Usage.UsedBytes != usage[j].Usage.UsedBytes {
+			return usage[i].Usage.UsedBytes > usage[j].Usage.UsedBytes
+		}
+		return usage[i].Usage.ProjectID.Hex() < usage[j].Usage.ProjectID.Hex()
+	})
+	return usage, nil
+}
+
+// DropDatabase records that the database of the simulation with id was dropped
+func (r *MemorySimulationRepo) DropDatabase(ctx context.Context, id primitive.ObjectID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.events, id)
+	delete(r.metrics, id)
+	r.dropped[id] = true
+	return nil
+}
+
+// Dropped reports whether the database of the simulation with id was dropped
+func (r *MemorySimulationRepo) Dropped(id primitive.ObjectID) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.dropped[id]
+}
+
+// ClearMetricsCache drops the cached responses of the stubbed metrics of a simulation
+func (r *MemorySimulationRepo) ClearMetricsCache(ctx context.Context, id primitive.ObjectID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if stub, ok := r.metrics[id]; ok {
+		stub.clearCache()
+	}
+	return nil
+}
+
+// Events returns the events of the simulation with id, creating an empty
+// MemoryEventRepo on first use
+func (r *MemorySimulationRepo) Events(id primitive.ObjectID) EventRepo {
+	return r.MemoryEvents(id)
+}
+
+// MemoryEvents is Events returning the MemoryEventRepo, to insert events in tests
+func (r *MemorySimulationRepo) MemoryEvents(id primitive.ObjectID) *MemoryEventRepo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	events, ok := r.events[id]
+	if !ok {
+		events = NewMemoryEventRepo()
+		r.events[id] = events
+	}
+	return events
+}
+
+// Metrics returns the stubbed metrics of the simulation with id
+func (r *MemorySimulationRepo) Metrics(id primitive.ObjectID) MetricsRepo {
+	return r.StubMetrics(id)
+}
+
+// StubMetrics is Metrics returning the StubMetricsRepo, to set results in
+// tests. An empty one is created on first use.
+func (r *MemorySimulationRepo) StubMetrics(id primitive.ObjectID) *StubMetricsRepo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stub, ok := r.metrics[id]
+	if !ok {
+		stub = NewStubMetricsRepo()
+		r.metrics[id] = stub
+	}
+	return stub
+}
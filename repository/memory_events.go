@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryEventRepo is an EventRepo kept in memory, for tests. Projections keep
+// whole top-level fields, so a projected subfield returns its parent.
+type MemoryEventRepo struct {
+	mutex  sync.Mutex
+	events []bson.Raw // Sorted in timeline order
+}
+
+// NewMemoryEventRepo returns an empty MemoryEventRepo
+func NewMemoryEventRepo() *MemoryEventRepo {
+	return &MemoryEventRepo{}
+}
+
+// Insert stores events, given as documents with a timestamp. Events without
+// an _id get a new one.
+func (r *MemoryEventRepo) Insert(events ...any) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, event := range events {
+		raw, err := bson.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, ok := bson.Raw(raw).Lookup("_id").ObjectIDOK(); !ok {
+			var doc bson.D
+			if err := bson.Unmarshal(raw, &doc); err != nil {
+				return err
+			}
+			if raw, err = bson.Marshal(append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)); err != nil {
+				return err
+			}
+		}
+		r.events = append(r.events, raw)
+	}
+	slices.SortStableFunc(r.events, compareEvents)
+	return nil
+}
+
+// eventPosition returns the position of an event in the timeline
+func eventPosition(event bson.Raw) utils.EventCursor {
+	var position utils.EventCursor
+	position.ID, _ = event.Lookup("_id").ObjectIDOK()
+	position.Timestamp, _ = event.Lookup("timestamp").TimeOK()
+	return position
+}
+
+// comparePositions orders positions by timestamp, then by _id unless one of
+// them is a legacy timestamp-only cursor
+func comparePositions(a, b utils.EventCursor) int {
+	if c := a.Timestamp.Compare(b.Timestamp); c != 0 || a.ID.IsZero() || b.ID.IsZero() {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+// compareEvents orders events in the timeline
+func compareEvents(a, b bson.Raw) int {
+	return comparePositions(eventPosition(a), eventPosition(b))
+}
+
+// matches reports whether event is selected by filter
+func (filter EventFilter) matches(event bson.Raw) bool {
+	eventType, _ := event.Lookup("type").StringValueOK()
+	if len(filter.Types) > 0 && !slices.Contains(filter.Types, eventType) {
+		return false
+	}
+	if slices.Contains(filter.ExcludeTypes, eventType) {
+		return false
+	}
+	if len(filter.NodeIDs) > 0 {
+		nodeID, _ := event.Lookup("nodeId").StringValueOK()
+		if !slices.Contains(filter.NodeIDs, nodeID) {
+			return false
+		}
+	}
+	if len(filter.Peers) > 0 {
+		source, _ := event.Lookup("sourcePeerId").StringValueOK()
+		recipient, _ := event.Lookup("recipientPeerId").StringValueOK()
+		if !slices.Contains(filter.Peers, source) && !slices.Contains(filter.Peers, recipient) {
+			return false
+		}
+	}
+
+	position := eventPosition(event)
+	if !filter.From.IsZero() && position.Timestamp.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && position.Timestamp.After(filter.To) {
+		return false
+	}
+	if filter.Heights.From != nil || filter.Heights.To != nil {
+		if !filter.inHeights(event.Lookup("height")) && !filter.inHeights(event.Lookup("vote", "height")) {
+			return false
+		}
+	}
+	if filter.After != nil && comparePositions(position, *filter.After) <= 0 {
+		return false
+	}
+	if filter.Before != nil && comparePositions(position, *filter.Before) >= 0 {
+		return false
+	}
+	return true
+}
+
+// inHeights reports whether value is a number within the height range of filter
+func (filter EventFilter) inHeights(value bson.RawValue) bool {
+	height, ok := value.AsInt64OK()
+	if !ok {
+		return false
+	}
+	return (filter.Heights.From == nil || height >= *filter.Heights.From) &&
+		(filter.Heights.To == nil || height <= *filter.Heights.To)
+}
+
+// matching returns the events matching filter in timeline order
+func (r *MemoryEventRepo) matching(filter EventFilter) []bson.Raw {
+	var events []bson.Raw
+	for _, event := range r.events {
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Find returns the matching events of page in timeline order
+func (r *MemoryEventRepo) Find(ctx context.Context, filter EventFilter, page EventPage) ([]bson.Raw, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	events := r.matching(filter)
+	if page.Descending {
+		slices.Reverse(events)
+	}
+	events = events[min(page.Skip, int64(len(events))):]
+	if page.Limit > 0 {
+		events = events[:min(page.Limit, int64(len(events)))]
+	}
+
+	found := make([]bson.Raw, len(events))
+	for i, event := range events {
+		projected, err := projectEvent(event, page.Fields)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = projected
+	}
+	return found, nil
+}
+
+// projectEvent copies the top-level fields of event that are in fields, or
+// have a subfield there. All fields are copied when fields is empty.
+func projectEvent(event bson.Raw, fields bson.M) (bson.Raw, error) {
+	if len(fields) == 0 {
+		return append(bson.Raw(nil), event...), nil
+	}
+
+	elements, err := event.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var projected bson.D
+	for _, element := range elements {
+		for field := range fields {
+			if field == element.Key() || strings.HasPrefix(field, element.Key()+".") {
+				projected = append(projected, bson.E{Key: element.Key(), Value: element.Value()})
+				break
+			}
+		}
+	}
+	return bson.Marshal(projected)
+}
+
+// Count returns the number of matching events
+func (r *MemoryEventRepo) Count(ctx context.Context, filter EventFilter) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return int64(len(r.matching(filter))), nil
+}
+
+// EstimatedCount returns the exact number of events
+func (r *MemoryEventRepo) EstimatedCount(ctx context.Context) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return int64(len(r.events)), nil
+}
+
+// Exists reports whether at least one event matches filter
+func (r *MemoryEventRepo) Exists(ctx context.Context, filter EventFilter) (bool, error) {
+	count, err := r.Count(ctx, filter)
+	return count > 0, err
+}
+
+// Get returns the event with id
+func (r *MemoryEventRepo) Get(ctx context.Context, id primitive.ObjectID) (bson.Raw, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, event := range r.events {
+		if eventPosition(event).ID == id {
+			return append(bson.Raw(nil), event...), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Buckets counts the matching events per type in buckets of resolution
+func (r *MemoryEventRepo) Buckets(ctx context.Context, filter EventFilter, resolution time.Duration) ([]EventBucketCount, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	resolutionMs := resolution.Milliseconds()
+	var buckets []EventBucketCount
+	for _, event := range r.matching(filter) {
+		timestampMs := eventPosition(event).Timestamp.UnixMilli()
+		start := time.UnixMilli(timestampMs - timestampMs%resolutionMs).UTC()
+		eventType, _ := event.Lookup("type").StringValueOK()
+		i := slices.IndexFunc(buckets, func(bucket EventBucketCount) bool {
+			return bucket.Start.Equal(start) && bucket.Type == eventType
+		})
+		if i < 0 {
+			// Events are in timeline order, so the first one seen is the earliest
+			buckets = append(buckets, EventBucketCount{Start: start, Type: eventType, First: append(bson.Raw(nil), event...)})
+			i = len(buckets) - 1
+		}
+		buckets[i].Count++
+	}
+	slices.SortFunc(buckets, func(a, b EventBucketCount) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Type, b.Type)
+	})
+	return buckets, nil
+}
@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/metricscache"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/latency"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MetricsRepo computes the metrics of a simulation and caches their responses.
+// Each metric is computed like the metrics function of the same name, such as
+// metrics.ComputeThroughput for Throughput.
+type MetricsRepo interface {
+	VoteLatencies(ctx context.Context, from, to time.Time, heights types.HeightRange, query metrics.VoteLatencyQuery) (*metrics.VoteLatencyResult, error)
+	PairwiseLatencyPercentiles(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, groupByVoteType bool, percentiles []float64) ([]types.PairLatency, error)
+	BlockLatencyTimeSeries(ctx context.Context, from, to time.Time) ([]types.BlockLatencyPoint, error)
+	LatencyStats(ctx context.Context, from, to time.Time, histogram metrics.HistogramOptions) (*types.LatencyStats, error)
+	MessageSuccessRate(ctx context.Context, from, to time.Time, heights types.HeightRange, aggregate string) ([]types.MessageSuccessRate, error)
+	MessageSuccessRateTimeSeries(ctx context.Context, from, to time.Time, heights types.HeightRange, resolution time.Duration, topN int) ([]types.DeliverySeries, error)
+	BlockEndToEndLatencyByHeight(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.BlockConsensusLatency, error)
+	VoteStatistics(ctx context.Context, from, to time.Time, percentiles []float64) ([]types.VoteStatisticsResponse, error)
+	NetworkLatencyStats(ctx context.Context, from, to time.Time) ([]latency.NodePairLatencyStats, error)
+	NetworkLatencyNodeStats(ctx context.Context, from, to time.Time, query metrics.NodeStatsQuery) (*metrics.NodeStatsResult, error)
+	NetworkLatencyOverview(ctx context.Context, from, to time.Time) (*types.NetworkLatencyOverviewResponse, error)
+	EventSummary(ctx context.Context, from, to time.Time, groupByNode bool) (map[string]*types.EventTypeSummary, error)
+	Heights(ctx context.Context, fromHeight, toHeight *int64, page, perPage int) (*metrics.HeightsResult, error)
+	HeightTimeline(ctx context.Context, height int64) (*types.HeightTimeline, error)
+	RoundDurations(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationsResult, error)
+	RoundDurationSummary(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationSummaryResult, error)
+	ProposerStats(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.ProposerStats, error)
+	BlockIntervals(ctx context.Context, from, to time.Time, heights types.HeightRange, marker string, window int) ([]types.BlockInterval, error)
+	BlockAssembly(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.BlockAssemblyResult, error)
+	NodeBlockAssembly(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.NodeBlockAssembly, error)
+	VoteParticipation(ctx context.Context, from, to time.Time, heights types.HeightRange, byRound bool, page, perPage int) (*metrics.VoteParticipationResult, error)
+	VoteAnomalies(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.VoteAnomalyFilter, page, perPage int) (*metrics.VoteAnomaliesResult, error)
+	LatencySpikes(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, factor float64, page, perPage int) (*metrics.LatencySpikesResult, error)
+	NodeRanking(ctx context.Context, from, to time.Time, heights types.HeightRange, weights metrics.NodeRankingWeights) ([]types.NodeRanking, error)
+	NetworkPartitions(ctx context.Context, from, to time.Time, heights types.HeightRange, opts metrics.PartitionOptions) ([]types.NetworkPartition, error)
+	NetworkFanout(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.FanoutResult, error)
+	NodeFanout(ctx context.Context, from, to time.Time, heights types.HeightRange, window time.Duration, threshold float64) ([]types.NodeFanout, error)
+	CommitLag(ctx context.Context, from, to time.Time, heights types.HeightRange, nodes []string, page, perPage int) (*metrics.CommitLagResult, error)
+	Throughput(ctx context.Context, from, to time.Time, resolution time.Duration) ([]types.ThroughputBucket, error)
+	MessageTimeSeries(ctx context.Context, from, to time.Time, eventTypes, nodes []string, groupBy string, resolution time.Duration) ([]types.MessageSeries, error)
+	StepDurations(ctx context.Context, from, to time.Time, heights types.HeightRange, nodes []string) ([]types.StepDuration, error)
+	StepFunnel(ctx context.Context, from, to time.Time, heights types.HeightRange) (*types.StepFunnelResponse, error)
+	JitterTimeSeries(ctx context.Context, from, to time.Time, filter metrics.PairLatencyFilter, resolution time.Duration, topN int) ([]types.JitterSeries, error)
+	VoteLatencyTimeSeries(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, resolution time.Duration) ([]types.VoteLatencyPoint, error)
+	TimeoutTimeSeries(ctx context.Context, from, to time.Time, nodes []string, resolution time.Duration) ([]types.TimeoutSeries, error)
+	Summary(ctx context.Context, from, to time.Time, heights types.HeightRange) *types.MetricsSummary
+
+	// PeerMapping returns the stored peer mapping of the simulation
+	PeerMapping(ctx context.Context) ([]types.PeerMapping, error)
+	// CachedResponse returns the cached response of metric for query, or nil
+	CachedResponse(ctx context.Context, metric string, query url.Values) ([]byte, error)
+	// CacheResponse caches the response of metric for query
+	CacheResponse(ctx context.Context, metric string, query url.Values, response []byte) error
+}
+
+// MongoMetricsRepo computes metrics from the collections of a simulation database
+type MongoMetricsRepo struct {
+	database *mongo.Database
+}
+
+// NewMongoMetricsRepo returns a MetricsRepo backed by the simulation database database
+func NewMongoMetricsRepo(database *mongo.Database) *MongoMetricsRepo {
+	return &MongoMetricsRepo{database: database}
+}
+
+// events returns the tracer events collection
+func (r *MongoMetricsRepo) events() *mongo.Collection {
+	return r.database.Collection("tracer_events")
+}
+
+// voteLatencies returns the vote latencies collection
+func (r *MongoMetricsRepo) voteLatencies() *mongo.Collection {
+	return r.database.Collection("vote_latencies")
+}
+
+// VoteLatencies computes metrics.GetVoteLatencies from vote_latencies
+func (r *MongoMetricsRepo) VoteLatencies(ctx context.Context, from, to time.Time, heights types.HeightRange, query metrics.VoteLatencyQuery) (*metrics.VoteLatencyResult, error) {
+	return metrics.GetVoteLatencies(ctx, r.voteLatencies(), from, to, heights, query)
+}
+
+// PairwiseLatencyPercentiles computes metrics.ComputePairwiseLatencyPercentiles from vote_latencies
+func (r *MongoMetricsRepo) PairwiseLatencyPercentiles(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, groupByVoteType bool, percentiles []float64) ([]types.PairLatency, error) {
+	return metrics.ComputePairwiseLatencyPercentiles(ctx, r.voteLatencies(), from, to, heights, filter, groupByVoteType, percentiles)
+}
+
+// BlockLatencyTimeSeries computes metrics.ComputeBlockLatencyTimeSeries from tracer_events
+func (r *MongoMetricsRepo) BlockLatencyTimeSeries(ctx context.Context, from, to time.Time) ([]types.BlockLatencyPoint, error) {
+	return metrics.ComputeBlockLatencyTimeSeries(ctx, r.events(), from, to)
+}
+
+// LatencyStats computes metrics.ComputeLatencyStats from tracer_events
+func (r *MongoMetricsRepo) LatencyStats(ctx context.Context, from, to time.Time, histogram metrics.HistogramOptions) (*types.LatencyStats, error) {
+	return metrics.ComputeLatencyStats(ctx, r.events(), from, to, histogram)
+}
+
+// MessageSuccessRate computes metrics.ComputeMessageSuccessRate from tracer_events
+func (r *MongoMetricsRepo) MessageSuccessRate(ctx context.Context, from, to time.Time, heights types.HeightRange, aggregate string) ([]types.MessageSuccessRate, error) {
+	return metrics.ComputeMessageSuccessRate(ctx, r.events(), from, to, heights, aggregate)
+}
+
+// MessageSuccessRateTimeSeries computes metrics.ComputeMessageSuccessRateTimeSeries from tracer_events
+func (r *MongoMetricsRepo) MessageSuccessRateTimeSeries(ctx context.Context, from, to time.Time, heights types.HeightRange, resolution time.Duration, topN int) ([]types.DeliverySeries, error) {
+	return metrics.ComputeMessageSuccessRateTimeSeries(ctx, r.events(), from, to, heights, resolution, topN)
+}
+
+// BlockEndToEndLatencyByHeight computes metrics.ComputeBlockEndToEndLatencyByHeight from tracer_events
+func (r *MongoMetricsRepo) BlockEndToEndLatencyByHeight(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.BlockConsensusLatency, error) {
+	return metrics.ComputeBlockEndToEndLatencyByHeight(ctx, r.events(), from, to, heights)
+}
+
+// VoteStatistics computes metrics.ComputeVoteStatistics from vote_latencies
+func (r *MongoMetricsRepo) VoteStatistics(ctx context.Context, from, to time.Time, percentiles []float64) ([]types.VoteStatisticsResponse, error) {
+	return metrics.ComputeVoteStatistics(ctx, r.voteLatencies(), from, to, percentiles)
+}
+
+// NetworkLatencyStats computes metrics.GetNetworkLatencyStats from network_latency_nodepair_summary
+func (r *MongoMetricsRepo) NetworkLatencyStats(ctx context.Context, from, to time.Time) ([]latency.NodePairLatencyStats, error) {
+	return metrics.GetNetworkLatencyStats(ctx, r.database.Collection("network_latency_nodepair_summary"), from, to)
+}
+
+// NetworkLatencyNodeStats computes metrics.GetNetworkLatencyNodeStats from network_latency_node_stats
+func (r *MongoMetricsRepo) NetworkLatencyNodeStats(ctx context.Context, from, to time.Time, query metrics.NodeStatsQuery) (*metrics.NodeStatsResult, error) {
+	return metrics.GetNetworkLatencyNodeStats(ctx, r.database.Collection("network_latency_node_stats"), from, to, query)
+}
+
+// NetworkLatencyOverview computes metrics.GetNetworkLatencyOverview from network_latency_nodepair_summary
+func (r *MongoMetricsRepo) NetworkLatencyOverview(ctx context.Context, from, to time.Time) (*types.NetworkLatencyOverviewResponse, error) {
+	return metrics.GetNetworkLatencyOverview(ctx, r.database.Collection("network_latency_nodepair_summary"), from, to)
+}
+
+// EventSummary computes metrics.ComputeEventSummary from tracer_events
+func (r *MongoMetricsRepo) EventSummary(ctx context.Context, from, to time.Time, groupByNode bool) (map[string]*types.EventTypeSummary, error) {
+	return metrics.ComputeEventSummary(ctx, r.events(), from, to, groupByNode)
+}
+
+// Heights computes metrics.ComputeHeights from tracer_events
+func (r *MongoMetricsRepo) Heights(ctx context.Context, fromHeight, toHeight *int64, page, perPage int) (*metrics.HeightsResult, error) {
+	return metrics.ComputeHeights(ctx, r.events(), fromHeight, toHeight, page, perPage)
+}
+
+// HeightTimeline computes metrics.ComputeHeightTimeline from tracer_events
+func (r *MongoMetricsRepo) HeightTimeline(ctx context.Context, height int64) (*types.HeightTimeline, error) {
+	return metrics.ComputeHeightTimeline(ctx, r.events(), height)
+}
+
+// RoundDurations computes metrics.ComputeRoundDurations from tracer_events
+func (r *MongoMetricsRepo) RoundDurations(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationsResult, error) {
+	return metrics.ComputeRoundDurations(ctx, r.events(), from, to, heights, page, perPage)
+}
+
+// RoundDurationSummary computes metrics.ComputeRoundDurationSummary from tracer_events
+func (r *MongoMetricsRepo) RoundDurationSummary(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationSummaryResult, error) {
+	return metrics.ComputeRoundDurationSummary(ctx, r.events(), from, to, heights, page, perPage)
+}
+
+// ProposerStats computes metrics.ComputeProposerStats from tracer_events
+func (r *MongoMetricsRepo) ProposerStats(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.ProposerStats, error) {
+	return metrics.ComputeProposerStats(ctx, r.events(), from, to, heights)
+}
+
+// BlockIntervals computes metrics.ComputeBlockIntervals from tracer_events
+func (r *MongoMetricsRepo) BlockIntervals(ctx context.Context, from, to time.Time, heights types.HeightRange, marker string, window int) ([]types.BlockInterval, error) {
+	return metrics.ComputeBlockIntervals(ctx, r.events(), from, to, heights, marker, window)
+}
+
+// BlockAssembly computes metrics.ComputeBlockAssembly from tracer_events
+func (r *MongoMetricsRepo) BlockAssembly(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.BlockAssemblyResult, error) {
+	return metrics.ComputeBlockAssembly(ctx, r.events(), from, to, heights, page, perPage)
+}
+
+// NodeBlockAssembly computes metrics.ComputeNodeBlockAssembly from tracer_events
+func (r *MongoMetricsRepo) NodeBlockAssembly(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.NodeBlockAssembly, error) {
+	return metrics.ComputeNodeBlockAssembly(ctx, r.events(), from, to, heights)
+}
+
+// VoteParticipation computes metrics.ComputeVoteParticipation from tracer_events
+func (r *MongoMetricsRepo) VoteParticipation(ctx context.Context, from, to time.Time, heights types.HeightRange, byRound bool, page, perPage int) (*metrics.VoteParticipationResult, error) {
+	return metrics.ComputeVoteParticipation(ctx, r.events(), from, to, heights, byRound, page, perPage)
+}
+
+// VoteAnomalies computes metrics.ComputeVoteAnomalies from tracer_events
+func (r *MongoMetricsRepo) VoteAnomalies(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.VoteAnomalyFilter, page, perPage int) (*metrics.VoteAnomaliesResult, error) {
+	return metrics.ComputeVoteAnomalies(ctx, r.events(), from, to, heights, filter, page, perPage)
+}
+
+// LatencySpikes computes metrics.ComputeLatencySpikes from vote_latencies
+func (r *MongoMetricsRepo) LatencySpikes(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, factor float64, page, perPage int) (*metrics.LatencySpikesResult, error) {
+	return metrics.ComputeLatencySpikes(ctx, r.voteLatencies(), from, to, heights, filter, factor, page, perPage)
+}
+
+// NodeRanking computes metrics.ComputeNodeRanking from tracer_events and vote_latencies
+func (r *MongoMetricsRepo) NodeRanking(ctx context.Context, from, to time.Time, heights types.HeightRange, weights metrics.NodeRankingWeights) ([]types.NodeRanking, error) {
+	return metrics.ComputeNodeRanking(ctx, r.events(), r.voteLatencies(), from, to, heights, weights)
+}
+
+// NetworkPartitions computes metrics.DetectNetworkPartitions from vote_latencies
+func (r *MongoMetricsRepo) NetworkPartitions(ctx context.Context, from, to time.Time, heights types.HeightRange, opts metrics.PartitionOptions) ([]types.NetworkPartition, error) {
+	return metrics.DetectNetworkPartitions(ctx, r.voteLatencies(), from, to, heights, opts)
+}
+
+// NetworkFanout computes metrics.ComputeNetworkFanout from tracer_events
+func (r *MongoMetricsRepo) NetworkFanout(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.FanoutResult, error) {
+	return metrics.ComputeNetworkFanout(ctx, r.events(), from, to, heights, page, perPage)
+}
+
+// NodeFanout computes metrics.ComputeNodeFanout from tracer_events
+func (r *MongoMetricsRepo) NodeFanout(ctx context.Context, from, to time.Time, heights types.HeightRange, window time.Duration, threshold float64) ([]types.NodeFanout, error) {
+	return metrics.ComputeNodeFanout(ctx, r.events(), from, to, heights, window, threshold)
+}
+
+// CommitLag computes metrics.ComputeCommitLag from tracer_events
+func (r *MongoMetricsRepo) CommitLag(ctx context.Context, from, to time.Time, heights types.HeightRange, nodes []string, page, perPage int) (*metrics.CommitLagResult, error) {
+	return metrics.ComputeCommitLag(ctx, r.events(), from, to, heights, nodes, page, perPage)
+}
+
+// Throughput computes metrics.ComputeThroughput from tracer_events
+func (r *MongoMetricsRepo) Throughput(ctx context.Context, from, to time.Time, resolution time.Duration) ([]types.ThroughputBucket, error) {
+	return metrics.ComputeThroughput(ctx, r.events(), from, to, resolution)
+}
+
+// MessageTimeSeries computes metrics.ComputeMessageTimeSeries from tracer_events
+func (r *MongoMetricsRepo) MessageTimeSeries(ctx context.Context, from, to time.Time, eventTypes, nodes []string, groupBy string, resolution time.Duration) ([]types.MessageSeries, error) {
+	return metrics.ComputeMessageTimeSeries(ctx, r.events(), from, to, eventTypes, nodes, groupBy, resolution)
+}
+
+// StepDurations computes metrics.ComputeStepDurations from tracer_events
+func (r *MongoMetricsRepo) StepDurations(ctx context.Context, from, to time.Time, heights types.HeightRange, nodes []string) ([]types.StepDuration, error) {
+	return metrics.ComputeStepDurations(ctx, r.events(), from, to, heights, nodes)
+}
+
+// StepFunnel computes metrics.ComputeStepFunnel from tracer_events
+func (r *MongoMetricsRepo) StepFunnel(ctx context.Context, from, to time.Time, heights types.HeightRange) (*types.StepFunnelResponse, error) {
+	return metrics.ComputeStepFunnel(ctx, r.events(), from, to, heights)
+}
+
+// JitterTimeSeries computes metrics.ComputeJitterTimeSeries from vote_latencies
+func (r *MongoMetricsRepo) JitterTimeSeries(ctx context.Context, from, to time.Time, filter metrics.PairLatencyFilter, resolution time.Duration, topN int) ([]types.JitterSeries, error) {
+	return metrics.ComputeJitterTimeSeries(ctx, r.voteLatencies(), from, to, filter, resolution, topN)
+}
+
+// VoteLatencyTimeSeries computes metrics.ComputeVoteLatencyTimeSeries from vote_latencies
+func (r *MongoMetricsRepo) VoteLatencyTimeSeries(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, resolution time.Duration) ([]types.VoteLatencyPoint, error) {
+	return metrics.ComputeVoteLatencyTimeSeries(ctx, r.voteLatencies(), from, to, heights, filter, resolution)
+}
+
+// TimeoutTimeSeries computes metrics.ComputeTimeoutTimeSeries from tracer_events
+func (r *MongoMetricsRepo) TimeoutTimeSeries(ctx context.Context, from, to time.Time, nodes []string, resolution time.Duration) ([]types.TimeoutSeries, error) {
+	return metrics.ComputeTimeoutTimeSeries(ctx, r.events(), from, to, nodes, resolution)
+}
+
+// Summary computes metrics.ComputeMetricsSummary from tracer_events and vote_latencies
+func (r *MongoMetricsRepo) Summary(ctx context.Context, from, to time.Time, heights types.HeightRange) *types.MetricsSummary {
+	return metrics.ComputeMetricsSummary(ctx, r.events(), r.voteLatencies(), from, to, heights)
+}
+
+// PeerMapping returns the stored peer mapping
+func (r *MongoMetricsRepo) PeerMapping(ctx context.Context) ([]types.PeerMapping, error) {
+	return metrics.LoadPeerMapping(ctx, r.database)
+}
+
+// CachedResponse returns the response of the metrics cache entry of metric for query, or nil
+func (r *MongoMetricsRepo) CachedResponse(ctx context.Context, metric string, query url.Values) ([]byte, error) {
+	entry, err := metricscache.Get(ctx, r.database, metric, query)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return entry.Response, nil
+}
+
+// CacheResponse stores response in the metrics cache
+func (r *MongoMetricsRepo) CacheResponse(ctx context.Context, metric string, query url.Values, response []byte) error {
+	return metricscache.Put(ctx, r.database, metric, query, response)
+}
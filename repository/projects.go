@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProjectUpdate changes the set fields of a project
+type ProjectUpdate struct {
+	Name                *string
+	Description         *string
+	RetainRawEventsDays *int
+	UserID              *primitive.ObjectID
+}
+
+// ProjectRepo stores projects
+type ProjectRepo interface {
+	// Create inserts project and sets its ID
+	Create(ctx context.Context, project *types.Project) error
+	// Get returns the project with id, or ErrNotFound
+	Get(ctx context.Context, id primitive.ObjectID) (types.Project, error)
+	// ListByUser returns the projects of a user whose name or description
+	// contains search case-insensitively; an empty search matches all
+	ListByUser(ctx context.Context, userID primitive.ObjectID, search string) ([]types.Project, error)
+	// CountByUser returns the number of projects of a user
+	CountByUser(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	// Update applies update and returns the updated project, or ErrNotFound
+	Update(ctx context.Context, id primitive.ObjectID, update ProjectUpdate) (types.Project, error)
+	// Delete deletes the project with id, or returns ErrNotFound
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// DeleteByUser deletes the projects of a user and returns how many there were
+	DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error)
+}
+
+// MongoProjectRepo stores projects in a MongoDB collection
+type MongoProjectRepo struct {
+	collection *mongo.Collection
+}
+
+// NewMongoProjectRepo returns a ProjectRepo backed by collection
+func NewMongoProjectRepo(collection *mongo.Collection) *MongoProjectRepo {
+	return &MongoProjectRepo{collection: collection}
+}
+
+// Create inserts project and sets its ID
+func (r *MongoProjectRepo) Create(ctx context.Context, project *types.Project) error {
+	result, err := r.collection.InsertOne(ctx, project)
+	if err != nil {
+		return err
+	}
+	project.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Get returns the project with id
+func (r *MongoProjectRepo) Get(ctx context.Context, id primitive.ObjectID) (types.Project, error) {
+	var project types.Project
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		return project, ErrNotFound
+	}
+	return project, err
+}
+
+// ListByUser returns the matching projects of a user
+func (r *MongoProjectRepo) ListByUser(ctx context.Context, userID primitive.ObjectID, search string) ([]types.Project, error) {
+	filter := bson.M{"userId": userID}
+	if search != "" {
+		filter = bson.M{"$and": bson.A{filter, utils.SearchFilter(search, "name", "description")}}
+	}
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	projects := []types.Project{}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// CountByUser returns the number of projects of a user
+func (r *MongoProjectRepo) CountByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"userId": userID})
+}
+
+// Update applies update, bumping updatedAt, and returns the updated project
+func (r *MongoProjectRepo) Update(ctx context.Context, id primitive.ObjectID, update ProjectUpdate) (types.Project, error) {
+	set := bson.M{"updatedAt": time.Now()}
+	if update.Name != nil {
+		set["name"] = *update.Name
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.RetainRawEventsDays != nil {
+		set["retainRawEventsDays"] = *update.RetainRawEventsDays
+	}
+	if update.UserID != nil {
+		set["userId"] = *update.UserID
+	}
+
+	var project types.Project
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		return project, ErrNotFound
+	}
+	return project, err
+}
+
+// Delete deletes the project with id
+func (r *MongoProjectRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteByUser deletes the projects of a user
+func (r *MongoProjectRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := r.collection.DeleteMany(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/metricscache"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SimulationFilter selects simulations. Zero fields do not filter.
+type SimulationFilter struct {
+	UserID    primitive.ObjectID
+	ProjectID primitive.ObjectID
+	Statuses  []types.SimulationStatus
+	Search    string // Case-insensitive substring of the name or description
+}
+
+// SimulationUpdate changes the set fields of a simulation. The If fields make
+// it conditional: a simulation that does not meet them is left unchanged and
+// reported as ErrNotFound.
+type SimulationUpdate struct {
+	Name               *string
+	Description        *string
+	Priority           *int
+	UserID             *primitive.ObjectID
+	Status             *types.SimulationStatus
+	ProcessingStatus   *types.ProcessingStatus // The empty status removes it
+	ProcessingResult   *types.ProcessingResult
+	LogFiles           []types.LogFileInfo // Replaces the log files when not empty
+	PendingLogFiles    []types.LogFileInfo // Replaces the pending log files when not empty
+	AddLogFiles        []types.LogFileInfo // Appended to the log files
+	AddPendingLogFiles []types.LogFileInfo // Appended to the pending log files
+	RemoveLogFile      string              // FilePath of a log file to remove, which must exist
+	ClearDataPruned    bool                // Raw events are available again
+
+	IfProcessingStatus *types.ProcessingStatus // Only in this processing status
+	IfNotProcessing    bool                    // Only while not being processed
+}
+
+// ProjectLogFiles is the log file usage of the simulations of a project
+type ProjectLogFiles struct {
+	Usage       types.ProjectStorageUsage // Without the processed output
+	Simulations []types.Simulation        // Only ID, ProjectID and UserID are set
+}
+
+// SimulationRepo stores simulations and manages their per-simulation databases
+type SimulationRepo interface {
+	// Create inserts simulation, keeping an ID that is already set
+	Create(ctx context.Context, simulation *types.Simulation) error
+	// Get returns the simulation with id, or ErrNotFound
+	Get(ctx context.Context, id primitive.ObjectID) (types.Simulation, error)
+	// List returns the matching simulations
+	List(ctx context.Context, filter SimulationFilter) ([]types.Simulation, error)
+	// Count returns the number of matching simulations
+	Count(ctx context.Context, filter SimulationFilter) (int64, error)
+	// Update applies update, bumping updatedAt, and returns the updated
+	// simulation, or ErrNotFound
+	Update(ctx context.Context, id primitive.ObjectID, update SimulationUpdate) (types.Simulation, error)
+	// TakePendingLogFiles removes the pending log files of a simulation and
+	// returns it as it was before, or ErrNotFound if it has none
+	TakePendingLogFiles(ctx context.Context, id primitive.ObjectID) (types.Simulation, error)
+	// Delete deletes the simulation with id, or returns ErrNotFound
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// ProjectStats returns the stats of the simulations of each project,
+	// also of projects without simulations
+	ProjectStats(ctx context.Context, projectIDs []primitive.ObjectID) (map[primitive.ObjectID]*types.ProjectStats, error)
+	// LogFileUsage sums the log files of the matching simulations per
+	// project, most used first
+	LogFileUsage(ctx context.Context, filter SimulationFilter) ([]ProjectLogFiles, error)
+	// DropDatabase drops the per-simulation database of a simulation
+	DropDatabase(ctx context.Context, id primitive.ObjectID) error
+	// ClearMetricsCache drops the cached metrics of a simulation
+	ClearMetricsCache(ctx context.Context, id primitive.ObjectID) error
+	// Events returns the consensus events of the simulation with id
+	Events(id primitive.ObjectID) EventRepo
+	// Metrics returns the metrics of the simulation with id
+	Metrics(id primitive.ObjectID) MetricsRepo
+}
+
+// MongoSimulationRepo stores simulations in a MongoDB collection, and their
+// processed data in a database per simulation named by its ID
+type MongoSimulationRepo struct {
+	collection *mongo.Collection
+}
+
+// NewMongoSimulationRepo returns a SimulationRepo backed by collection
+func NewMongoSimulationRepo(collection *mongo.Collection) *MongoSimulationRepo {
+	return &MongoSimulationRepo{collection: collection}
+}
+
+// Database returns the per-simulation database of the simulation with id
+func (r *MongoSimulationRepo) Database(id primitive.ObjectID) *mongo.Database {
+	return r.collection.Database().Client().Database(id.Hex())
+}
+
+// Create inserts simulation
+func (r *MongoSimulationRepo) Create(ctx context.Context, simulation *types.Simulation) error {
+	result, err := r.collection.InsertOne(ctx, simulation)
+	if err != nil {
+		return err
+	}
+	simulation.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Get returns the simulation with id
+func (r *MongoSimulationRepo) Get(ctx context.Context, id primitive.ObjectID) (types.Simulation, error) {
+	var simulation types.Simulation
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&simulation)
+	if err == mongo.ErrNoDocuments {
+		return simulation, ErrNotFound
+	}
+	return simulation, err
+}
+
+// simulationQuery converts filter into a query
+func simulationQuery(filter SimulationFilter) bson.M {
+	query := bson.M{}
+	if !filter.UserID.IsZero() {
+		query["userId"] = filter.UserID
+	}
+	if !filter.ProjectID.IsZero() {
+		query["projectId"] = filter.ProjectID
+	}
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+	if filter.Search != "" {
+		return bson.M{"$and": bson.A{query, utils.SearchFilter(filter.Search, "name", "description")}}
+	}
+	return query
+}
+
+// List returns the matching simulations
+func (r *MongoSimulationRepo) List(ctx context.Context, filter SimulationFilter) ([]types.Simulation, error) {
+	cursor, err := r.collection.Find(ctx, simulationQuery(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	simulations := []types.Simulation{}
+	if err := cursor.All(ctx, &simulations); err != nil {
+		return nil, err
+	}
+	return simulations, nil
+}
+
+// Count returns the number of matching simulations
+func (r *MongoSimulationRepo) Count(ctx context.Context, filter SimulationFilter) (int64, error) {
+	return r.collection.CountDocuments(ctx, simulationQuery(filter))
+}
+
+// Update applies update, bumping updatedAt, and returns the updated simulation
+func (r *MongoSimulationRepo) Update(ctx context.Context, id primitive.ObjectID, update SimulationUpdate) (types.Simulation, error) {
+	filter := bson.M{"_id": id}
+	set := bson.M{"updatedAt": time.Now()}
+	unset := bson.M{}
+	push := bson.M{}
+
+	if update.Name != nil {
+		set["name"] = *update.Name
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.Priority != nil {
+		set["priority"] = *update.Priority
+	}
+	if update.UserID != nil {
+		set["userId"] = *update.UserID
+	}
+	if update.Status != nil {
+		set["status"] = *update.Status
+	}
+	if update.ProcessingStatus != nil {
+		if *update.ProcessingStatus == "" {
+			unset["processingStatus"] = ""
+		} else {
+			set["processingStatus"] = *update.ProcessingStatus
+		}
+	}
+	if update.ProcessingResult != nil {
+		set["processingResult"] = *update.ProcessingResult
+	}
+	if len(update.LogFiles) > 0 {
+		set["logFiles"] = update.LogFiles
+	}
+	if len(update.PendingLogFiles) > 0 {
+		set["pendingLogFiles"] = update.PendingLogFiles
+	}
+	if len(update.AddLogFiles) > 0 {
+		push["logFiles"] = bson.M{"$each": update.AddLogFiles}
+	}
+	if len(update.AddPendingLogFiles) > 0 {
+		push["pendingLogFiles"] = bson.M{"$each": update.AddPendingLogFiles}
+	}
+	if update.ClearDataPruned {
+		unset["dataPruned"] = ""
+		unset["dataPrunedAt"] = ""
+	}
+
+	if update.IfProcessingStatus != nil {
+		filter["processingStatus"] = *update.IfProcessingStatus
+	} else if update.IfNotProcessing {
+		filter["processingStatus"] = bson.M{"$ne": types.ProcessingStatusProcessing}
+	}
+
+	document := bson.M{"$set": set}
+	if len(unset) > 0 {
+		document["$unset"] = unset
+	}
+	if len(push) > 0 {
+		document["$push"] = push
+	}
+	if update.RemoveLogFile != "" {
+		filter["logFiles.filePath"] = update.RemoveLogFile
+		document["$pull"] = bson.M{"logFiles": bson.M{"filePath": update.RemoveLogFile}}
+	}
+
+	var simulation types.Simulation
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, filter, document, opts).Decode(&simulation)
+	if err == mongo.ErrNoDocuments {
+		return simulation, ErrNotFound
+	}
+	return simulation, err
+}
+
+// TakePendingLogFiles removes the pending log files of a simulation and returns it as it was before
+func (r *MongoSimulationRepo) TakePendingLogFiles(ctx context.Context, id primitive.ObjectID) (types.Simulation, error) {
+	var simulation types.Simulation
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "pendingLogFiles.0": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"pendingLogFiles": ""}},
+	).Decode(&simulation)
+	if err == mongo.ErrNoDocuments {
+		return simulation, ErrNotFound
+	}
+	return simulation, err
+}
+
+// Delete deletes the simulation with id
+func (r *MongoSimulationRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ProjectStats returns the stats of the simulations of each project
+func (r *MongoSimulationRepo) ProjectStats(ctx context.Context, projectIDs []primitive.ObjectID) (map[primitive.ObjectID]*types.ProjectStats, error) {
+	stats := make(map[primitive.ObjectID]*types.ProjectStats, len(projectIDs))
+	for _, projectID := range projectIDs {
+		stats[projectID] = types.NewProjectStats()
+	}
+	if len(projectIDs) == 0 {
+		return stats, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"projectId", bson.D{{"$in", projectIDs}}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"projectId", "$projectId"}, {"status", "$status"}}},
+			{"count", bson.D{{"$sum", 1}}},
+			{"lastCreatedAt", bson.D{{"$max", "$createdAt"}}},
+			// $sum of a missing array is 0
+			{"storedBytes", bson.D{{"$sum", bson.D{{"$sum", "$logFiles.fileSize"}}}}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rawResults []struct {
+		ID struct {
+			ProjectID primitive.ObjectID     `bson:"projectId"`
+			Status    types.SimulationStatus `bson:"status"`
+		} `bson:"_id"`
+		Count         int64     `bson:"count"`
+		LastCreatedAt time.Time `bson:"lastCreatedAt"`
+		StoredBytes   int64     `bson:"storedBytes"`
+	}
+	if err := cursor.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	for _, doc := range rawResults {
+		addProjectStats(stats[doc.ID.ProjectID], doc.ID.Status, doc.Count, doc.LastCreatedAt, doc.StoredBytes)
+	}
+	return stats, nil
+}
+
+// addProjectStats adds count simulations in status, the newest created at
+// lastCreatedAt and storing storedBytes, to stats
+func addProjectStats(stats *types.ProjectStats, status types.SimulationStatus, count int64, lastCreatedAt time.Time, storedBytes int64) {
+	stats.Simulations += count
+	stats.ByStatus[status] += count
+	stats.StoredBytes += storedBytes
+	if stats.LastSimulationAt == nil || lastCreatedAt.After(*stats.LastSimulationAt) {
+		stats.LastSimulationAt = &lastCreatedAt
+	}
+}
+
+// LogFileUsage sums the log files of the matching simulations per project
+func (r *MongoSimulationRepo) LogFileUsage(ctx context.Context, filter SimulationFilter) ([]ProjectLogFiles, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", simulationQuery(filter)}},
+		{{"$unwind", bson.D{{"path", "$logFiles"}, {"preserveNullAndEmptyArrays", true}}}},
+		{{"$group", bson.D{
+			{"_id", "$projectId"},
+			{"usedBytes", bson.D{{"$sum", "$logFiles.fileSize"}}},
+			{"logFiles", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+				bson.D{{"$ifNull", bson.A{"$logFiles", false}}}, 1, 0,
+			}}}}}},
+			{"simulations", bson.D{{"$addToSet", bson.D{{"_id", "$_id"}, {"projectId", "$projectId"}, {"userId", "$userId"}}}}},
+		}}},
+		{{"$sort", bson.D{{"usedBytes", -1}, {"_id", 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rawResults []struct {
+		types.ProjectStorageUsage `bson:",inline"`
+		Simulations               []types.Simulation `bson:"simulations"`
+	}
+	if err := cursor.All(ctx, &rawResults); err != nil {
+		return nil, err
+	}
+
+	usage := make([]ProjectLogFiles, len(rawResults))
+	for i, doc := range rawResults {
+		usage[i] = ProjectLogFiles{Usage: doc.ProjectStorageUsage, Simulations: doc.Simulations}
+	}
+	return usage, nil
+}
+
+// DropDatabase drops the per-simulation database of a simulation
+func (r *MongoSimulationRepo) DropDatabase(ctx context.Context, id primitive.ObjectID) error {
+	return r.Database(id).Drop(ctx)
+}
+
+// ClearMetricsCache drops the cached metrics of a simulation
+func (r *MongoSimulationRepo) ClearMetricsCache(ctx context.Context, id primitive.ObjectID) error {
+	return metricscache.Drop(ctx, r.Database(id))
+}
+
+// Events returns the tracer events of the simulation with id
+func (r *MongoSimulationRepo) Events(id primitive.ObjectID) EventRepo {
+	return NewMongoEventRepo(r.Database(id).Collection("tracer_events"))
+}
+
+// Metrics returns the metrics computed from the database of the simulation with id
+func (r *MongoSimulationRepo) Metrics(id primitive.ObjectID) MetricsRepo {
+	return NewMongoMetricsRepo(r.Database(id))
+}
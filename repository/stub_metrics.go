@@ -0,0 +1,385 @@
+package repository
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/metrics"
+	"github.com/bft-labs/cometbft-analyzer-backend/metricscache"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/latency"
+)
+
+// StubMetricsRepo is a MetricsRepo for tests. Each metric returns the result
+// of its Func field, which also sees the parsed arguments, or an empty result
+// when it is nil. Cached responses are kept in memory.
+type StubMetricsRepo struct {
+	VoteLatenciesFunc                func(from, to time.Time, heights types.HeightRange, query metrics.VoteLatencyQuery) (*metrics.VoteLatencyResult, error)
+	PairwiseLatencyPercentilesFunc   func(from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, groupByVoteType bool, percentiles []float64) ([]types.PairLatency, error)
+	BlockLatencyTimeSeriesFunc       func(from, to time.Time) ([]types.BlockLatencyPoint, error)
+	LatencyStatsFunc                 func(from, to time.Time, histogram metrics.HistogramOptions) (*types.LatencyStats, error)
+	MessageSuccessRateFunc           func(from, to time.Time, heights types.HeightRange, aggregate string) ([]types.MessageSuccessRate, error)
+	MessageSuccessRateTimeSeriesFunc func(from, to time.Time, heights types.HeightRange, resolution time.Duration, topN int) ([]types.DeliverySeries, error)
+	BlockEndToEndLatencyByHeightFunc func(from, to time.Time, heights types.HeightRange) ([]types.BlockConsensusLatency, error)
+	VoteStatisticsFunc               func(from, to time.Time, percentiles []float64) ([]types.VoteStatisticsResponse, error)
+	NetworkLatencyStatsFunc          func(from, to time.Time) ([]latency.NodePairLatencyStats, error)
+	NetworkLatencyNodeStatsFunc      func(from, to time.Time, query metrics.NodeStatsQuery) (*metrics.NodeStatsResult, error)
+	NetworkLatencyOverviewFunc       func(from, to time.Time) (*types.NetworkLatencyOverviewResponse, error)
+	EventSummaryFunc                 func(from, to time.Time, groupByNode bool) (map[string]*types.EventTypeSummary, error)
+	HeightsFunc                      func(fromHeight, toHeight *int64, page, perPage int) (*metrics.HeightsResult, error)
+	HeightTimelineFunc               func(height int64) (*types.HeightTimeline, error)
+	RoundDurationsFunc               func(from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationsResult, error)
+	RoundDurationSummaryFunc         func(from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationSummaryResult, error)
+	ProposerStatsFunc                func(from, to time.Time, heights types.HeightRange) ([]types.ProposerStats, error)
+	BlockIntervalsFunc               func(from, to time.Time, heights types.HeightRange, marker string, window int) ([]types.BlockInterval, error)
+	BlockAssemblyFunc                func(from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.BlockAssemblyResult, error)
+	NodeBlockAssemblyFunc            func(from, to time.Time, heights types.HeightRange) ([]types.NodeBlockAssembly, error)
+	VoteParticipationFunc            func(from, to time.Time, heights types.HeightRange, byRound bool, page, perPage int) (*metrics.VoteParticipationResult, error)
+	VoteAnomaliesFunc                func(from, to time.Time, heights types.HeightRange, filter metrics.VoteAnomalyFilter, page, perPage int) (*metrics.VoteAnomaliesResult, error)
+	LatencySpikesFunc                func(from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, factor float64, page, perPage int) (*metrics.LatencySpikesResult, error)
+	NodeRankingFunc                  func(from, to time.Time, heights types.HeightRange, weights metrics.NodeRankingWeights) ([]types.NodeRanking, error)
+	NetworkPartitionsFunc            func(from, to time.Time, heights types.HeightRange, opts metrics.PartitionOptions) ([]types.NetworkPartition, error)
+	NetworkFanoutFunc                func(from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.FanoutResult, error)
+	NodeFanoutFunc                   func(from, to time.Time, heights types.HeightRange, window time.Duration, threshold float64) ([]types.NodeFanout, error)
+	CommitLagFunc                    func(from, to time.Time, heights types.HeightRange, nodes []string, page, perPage int) (*metrics.CommitLagResult, error)
+	ThroughputFunc                   func(from, to time.Time, resolution time.Duration) ([]types.ThroughputBucket, error)
+	MessageTimeSeriesFunc            func(from, to time.Time, eventTypes, nodes []string, groupBy string, resolution time.Duration) ([]types.MessageSeries, error)
+	StepDurationsFunc                func(from, to time.Time, heights types.HeightRange, nodes []string) ([]types.StepDuration, error)
+	StepFunnelFunc                   func(from, to time.Time, heights types.HeightRange) (*types.StepFunnelResponse, error)
+	JitterTimeSeriesFunc             func(from, to time.Time, filter metrics.PairLatencyFilter, resolution time.Duration, topN int) ([]types.JitterSeries, error)
+	VoteLatencyTimeSeriesFunc        func(from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, resolution time.Duration) ([]types.VoteLatencyPoint, error)
+	TimeoutTimeSeriesFunc            func(from, to time.Time, nodes []string, resolution time.Duration) ([]types.TimeoutSeries, error)
+	SummaryFunc                      func(from, to time.Time, heights types.HeightRange) *types.MetricsSummary
+
+	PeerMappings []types.PeerMapping
+
+	mutex sync.Mutex
+	cache map[string][]byte
+}
+
+// NewStubMetricsRepo returns a StubMetricsRepo without results
+func NewStubMetricsRepo() *StubMetricsRepo {
+	return &StubMetricsRepo{cache: map[string][]byte{}}
+}
+
+// VoteLatencies returns the result of VoteLatenciesFunc, or an empty result
+func (r *StubMetricsRepo) VoteLatencies(ctx context.Context, from, to time.Time, heights types.HeightRange, query metrics.VoteLatencyQuery) (*metrics.VoteLatencyResult, error) {
+	if r.VoteLatenciesFunc != nil {
+		return r.VoteLatenciesFunc(from, to, heights, query)
+	}
+	return &metrics.VoteLatencyResult{}, nil
+}
+
+// PairwiseLatencyPercentiles returns the result of PairwiseLatencyPercentilesFunc, or an empty result
+func (r *StubMetricsRepo) PairwiseLatencyPercentiles(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, groupByVoteType bool, percentiles []float64) ([]types.PairLatency, error) {
+	if r.PairwiseLatencyPercentilesFunc != nil {
+		return r.PairwiseLatencyPercentilesFunc(from, to, heights, filter, groupByVoteType, percentiles)
+	}
+	return nil, nil
+}
+
+// BlockLatencyTimeSeries returns the result of BlockLatencyTimeSeriesFunc, or an empty result
+func (r *StubMetricsRepo) BlockLatencyTimeSeries(ctx context.Context, from, to time.Time) ([]types.BlockLatencyPoint, error) {
+	if r.BlockLatencyTimeSeriesFunc != nil {
+		return r.BlockLatencyTimeSeriesFunc(from, to)
+	}
+	return nil, nil
+}
+
+// LatencyStats returns the result of LatencyStatsFunc, or an empty result
+func (r *StubMetricsRepo) LatencyStats(ctx context.Context, from, to time.Time, histogram metrics.HistogramOptions) (*types.LatencyStats, error) {
+	if r.LatencyStatsFunc != nil {
+		return r.LatencyStatsFunc(from, to, histogram)
+	}
+	return &types.LatencyStats{}, nil
+}
+
+// MessageSuccessRate returns the result of MessageSuccessRateFunc, or an empty result
+func (r *StubMetricsRepo) MessageSuccessRate(ctx context.Context, from, to time.Time, heights types.HeightRange, aggregate string) ([]types.MessageSuccessRate, error) {
+	if r.MessageSuccessRateFunc != nil {
+		return r.MessageSuccessRateFunc(from, to, heights, aggregate)
+	}
+	return nil, nil
+}
+
+// MessageSuccessRateTimeSeries returns the result of MessageSuccessRateTimeSeriesFunc, or an empty result
+func (r *StubMetricsRepo) MessageSuccessRateTimeSeries(ctx context.Context, from, to time.Time, heights types.HeightRange, resolution time.Duration, topN int) ([]types.DeliverySeries, error) {
+	if r.MessageSuccessRateTimeSeriesFunc != nil {
+		return r.MessageSuccessRateTimeSeriesFunc(from, to, heights, resolution, topN)
+	}
+	return nil, nil
+}
+
+// BlockEndToEndLatencyByHeight returns the result of BlockEndToEndLatencyByHeightFunc, or an empty result
+func (r *StubMetricsRepo) BlockEndToEndLatencyByHeight(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.BlockConsensusLatency, error) {
+	if r.BlockEndToEndLatencyByHeightFunc != nil {
+		return r.BlockEndToEndLatencyByHeightFunc(from, to, heights)
+	}
+	return nil, nil
+}
+
+// VoteStatistics returns the result of VoteStatisticsFunc, or an empty result
+func (r *StubMetricsRepo) VoteStatistics(ctx context.Context, from, to time.Time, percentiles []float64) ([]types.VoteStatisticsResponse, error) {
+	if r.VoteStatisticsFunc != nil {
+		return r.VoteStatisticsFunc(from, to, percentiles)
+	}
+	return nil, nil
+}
+
+// NetworkLatencyStats returns the result of NetworkLatencyStatsFunc, or an empty result
+func (r *StubMetricsRepo) NetworkLatencyStats(ctx context.Context, from, to time.Time) ([]latency.NodePairLatencyStats, error) {
+	if r.NetworkLatencyStatsFunc != nil {
+		return r.NetworkLatencyStatsFunc(from, to)
+	}
+	return nil, nil
+}
+
+// NetworkLatencyNodeStats returns the result of NetworkLatencyNodeStatsFunc, or an empty result
+func (r *StubMetricsRepo) NetworkLatencyNodeStats(ctx context.Context, from, to time.Time, query metrics.NodeStatsQuery) (*metrics.NodeStatsResult, error) {
+	if r.NetworkLatencyNodeStatsFunc != nil {
+		return r.NetworkLatencyNodeStatsFunc(from, to, query)
+	}
+	return &metrics.NodeStatsResult{}, nil
+}
+
+// NetworkLatencyOverview returns the result of NetworkLatencyOverviewFunc, or an empty result
+func (r *StubMetricsRepo) NetworkLatencyOverview(ctx context.Context, from, to time.Time) (*types.NetworkLatencyOverviewResponse, error) {
+	if r.NetworkLatencyOverviewFunc != nil {
+		return r.NetworkLatencyOverviewFunc(from, to)
+	}
+	return &types.NetworkLatencyOverviewResponse{}, nil
+}
+
+// EventSummary returns the result of EventSummaryFunc, or an empty result
+func (r *StubMetricsRepo) EventSummary(ctx context.Context, from, to time.Time, groupByNode bool) (map[string]*types.EventTypeSummary, error) {
+	if r.EventSummaryFunc != nil {
+		return r.EventSummaryFunc(from, to, groupByNode)
+	}
+	return nil, nil
+}
+
+// Heights returns the result of HeightsFunc, or an empty result
+func (r *StubMetricsRepo) Heights(ctx context.Context, fromHeight, toHeight *int64, page, perPage int) (*metrics.HeightsResult, error) {
+	if r.HeightsFunc != nil {
+		return r.HeightsFunc(fromHeight, toHeight, page, perPage)
+	}
+	return &metrics.HeightsResult{}, nil
+}
+
+// HeightTimeline returns the result of HeightTimelineFunc, or an empty result
+func (r *StubMetricsRepo) HeightTimeline(ctx context.Context, height int64) (*types.HeightTimeline, error) {
+	if r.HeightTimelineFunc != nil {
+		return r.HeightTimelineFunc(height)
+	}
+	return &types.HeightTimeline{}, nil
+}
+
+// RoundDurations returns the result of RoundDurationsFunc, or an empty result
+func (r *StubMetricsRepo) RoundDurations(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationsResult, error) {
+	if r.RoundDurationsFunc != nil {
+		return r.RoundDurationsFunc(from, to, heights, page, perPage)
+	}
+	return &metrics.RoundDurationsResult{}, nil
+}
+
+// RoundDurationSummary returns the result of RoundDurationSummaryFunc, or an empty result
+func (r *StubMetricsRepo) RoundDurationSummary(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.RoundDurationSummaryResult, error) {
+	if r.RoundDurationSummaryFunc != nil {
+		return r.RoundDurationSummaryFunc(from, to, heights, page, perPage)
+	}
+	return &metrics.RoundDurationSummaryResult{}, nil
+}
+
+// ProposerStats returns the result of ProposerStatsFunc, or an empty result
+func (r *StubMetricsRepo) ProposerStats(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.ProposerStats, error) {
+	if r.ProposerStatsFunc != nil {
+		return r.ProposerStatsFunc(from, to, heights)
+	}
+	return nil, nil
+}
+
+// BlockIntervals returns the result of BlockIntervalsFunc, or an empty result
+func (r *StubMetricsRepo) BlockIntervals(ctx context.Context, from, to time.Time, heights types.HeightRange, marker string, window int) ([]types.BlockInterval, error) {
+	if r.BlockIntervalsFunc != nil {
+		return r.BlockIntervalsFunc(from, to, heights, marker, window)
+	}
+	return nil, nil
+}
+
+// BlockAssembly returns the result of BlockAssemblyFunc, or an empty result
+func (r *StubMetricsRepo) BlockAssembly(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.BlockAssemblyResult, error) {
+	if r.BlockAssemblyFunc != nil {
+		return r.BlockAssemblyFunc(from, to, heights, page, perPage)
+	}
+	return &metrics.BlockAssemblyResult{}, nil
+}
+
+// NodeBlockAssembly returns the result of NodeBlockAssemblyFunc, or an empty result
+func (r *StubMetricsRepo) NodeBlockAssembly(ctx context.Context, from, to time.Time, heights types.HeightRange) ([]types.NodeBlockAssembly, error) {
+	if r.NodeBlockAssemblyFunc != nil {
+		return r.NodeBlockAssemblyFunc(from, to, heights)
+	}
+	return nil, nil
+}
+
+// VoteParticipation returns the result of VoteParticipationFunc, or an empty result
+func (r *StubMetricsRepo) VoteParticipation(ctx context.Context, from, to time.Time, heights types.HeightRange, byRound bool, page, perPage int) (*metrics.VoteParticipationResult, error) {
+	if r.VoteParticipationFunc != nil {
+		return r.VoteParticipationFunc(from, to, heights, byRound, page, perPage)
+	}
+	return &metrics.VoteParticipationResult{}, nil
+}
+
+// VoteAnomalies returns the result of VoteAnomaliesFunc, or an empty result
+func (r *StubMetricsRepo) VoteAnomalies(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.VoteAnomalyFilter, page, perPage int) (*metrics.VoteAnomaliesResult, error) {
+	if r.VoteAnomaliesFunc != nil {
+		return r.VoteAnomaliesFunc(from, to, heights, filter, page, perPage)
+	}
+	return &metrics.VoteAnomaliesResult{}, nil
+}
+
+// LatencySpikes returns the result of LatencySpikesFunc, or an empty result
+func (r *StubMetricsRepo) LatencySpikes(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, factor float64, page, perPage int) (*metrics.LatencySpikesResult, error) {
+	if r.LatencySpikesFunc != nil {
+		return r.LatencySpikesFunc(from, to, heights, filter, factor, page, perPage)
+	}
+	return &metrics.LatencySpikesResult{}, nil
+}
+
+// NodeRanking returns the result of NodeRankingFunc, or an empty result
+func (r *StubMetricsRepo) NodeRanking(ctx context.Context, from, to time.Time, heights types.HeightRange, weights metrics.NodeRankingWeights) ([]types.NodeRanking, error) {
+	if r.NodeRankingFunc != nil {
+		return r.NodeRankingFunc(from, to, heights, weights)
+	}
+	return nil, nil
+}
+
+// NetworkPartitions returns the result of NetworkPartitionsFunc, or an empty result
+func (r *StubMetricsRepo) NetworkPartitions(ctx context.Context, from, to time.Time, heights types.HeightRange, opts metrics.PartitionOptions) ([]types.NetworkPartition, error) {
+	if r.NetworkPartitionsFunc != nil {
+		return r.NetworkPartitionsFunc(from, to, heights, opts)
+	}
+	return nil, nil
+}
+
+// NetworkFanout returns the result of NetworkFanoutFunc, or an empty result
+func (r *StubMetricsRepo) NetworkFanout(ctx context.Context, from, to time.Time, heights types.HeightRange, page, perPage int) (*metrics.FanoutResult, error) {
+	if r.NetworkFanoutFunc != nil {
+		return r.NetworkFanoutFunc(from, to, heights, page, perPage)
+	}
+	return &metrics.FanoutResult{}, nil
+}
+
+// NodeFanout returns the result of NodeFanoutFunc, or an empty result
+func (r *StubMetricsRepo) NodeFanout(ctx context.Context, from, to time.Time, heights types.HeightRange, window time.Duration, threshold float64) ([]types.NodeFanout, error) {
+	if r.NodeFanoutFunc != nil {
+		return r.NodeFanoutFunc(from, to, heights, window, threshold)
+	}
+	return nil, nil
+}
+
+// CommitLag returns the result of CommitLagFunc, or an empty result
+func (r *StubMetricsRepo) CommitLag(ctx context.Context, from, to time.Time, heights types.HeightRange, nodes []string, page, perPage int) (*metrics.CommitLagResult, error) {
+	if r.CommitLagFunc != nil {
+		return r.CommitLagFunc(from, to, heights, nodes, page, perPage)
+	}
+	return &metrics.CommitLagResult{}, nil
+}
+
+// Throughput returns the result of ThroughputFunc, or an empty result
+func (r *StubMetricsRepo) Throughput(ctx context.Context, from, to time.Time, resolution time.Duration) ([]types.ThroughputBucket, error) {
+	if r.ThroughputFunc != nil {
+		return r.ThroughputFunc(from, to, resolution)
+	}
+	return nil, nil
+}
+
+// MessageTimeSeries returns the result of MessageTimeSeriesFunc, or an empty result
+func (r *StubMetricsRepo) MessageTimeSeries(ctx context.Context, from, to time.Time, eventTypes, nodes []string, groupBy string, resolution time.Duration) ([]types.MessageSeries, error) {
+	if r.MessageTimeSeriesFunc != nil {
+		return r.MessageTimeSeriesFunc(from, to, eventTypes, nodes, groupBy, resolution)
+	}
+	return nil, nil
+}
+
+// StepDurations returns the result of StepDurationsFunc, or an empty result
+func (r *StubMetricsRepo) StepDurations(ctx context.Context, from, to time.Time, heights types.HeightRange, nodes []string) ([]types.StepDuration, error) {
+	if r.StepDurationsFunc != nil {
+		return r.StepDurationsFunc(from, to, heights, nodes)
+	}
+	return nil, nil
+}
+
+// StepFunnel returns the result of StepFunnelFunc, or an empty result
+func (r *StubMetricsRepo) StepFunnel(ctx context.Context, from, to time.Time, heights types.HeightRange) (*types.StepFunnelResponse, error) {
+	if r.StepFunnelFunc != nil {
+		return r.StepFunnelFunc(from, to, heights)
+	}
+	return &types.StepFunnelResponse{}, nil
+}
+
+// JitterTimeSeries returns the result of JitterTimeSeriesFunc, or an empty result
+func (r *StubMetricsRepo) JitterTimeSeries(ctx context.Context, from, to time.Time, filter metrics.PairLatencyFilter, resolution time.Duration, topN int) ([]types.JitterSeries, error) {
+	if r.JitterTimeSeriesFunc != nil {
+		return r.JitterTimeSeriesFunc(from, to, filter, resolution, topN)
+	}
+	return nil, nil
+}
+
+// VoteLatencyTimeSeries returns the result of VoteLatencyTimeSeriesFunc, or an empty result
+func (r *StubMetricsRepo) VoteLatencyTimeSeries(ctx context.Context, from, to time.Time, heights types.HeightRange, filter metrics.PairLatencyFilter, resolution time.Duration) ([]types.VoteLatencyPoint, error) {
+	if r.VoteLatencyTimeSeriesFunc != nil {
+		return r.VoteLatencyTimeSeriesFunc(from, to, heights, filter, resolution)
+	}
+	return nil, nil
+}
+
+// TimeoutTimeSeries returns the result of TimeoutTimeSeriesFunc, or an empty result
+func (r *StubMetricsRepo) TimeoutTimeSeries(ctx context.Context, from, to time.Time, nodes []string, resolution time.Duration) ([]types.TimeoutSeries, error) {
+	if r.TimeoutTimeSeriesFunc != nil {
+		return r.TimeoutTimeSeriesFunc(from, to, nodes, resolution)
+	}
+	return nil, nil
+}
+
+// Summary returns the result of SummaryFunc, or an empty result
+func (r *StubMetricsRepo) Summary(ctx context.Context, from, to time.Time, heights types.HeightRange) *types.MetricsSummary {
+	if r.SummaryFunc != nil {
+		return r.SummaryFunc(from, to, heights)
+	}
+	return &types.MetricsSummary{}
+}
+
+// PeerMapping returns PeerMappings
+func (r *StubMetricsRepo) PeerMapping(ctx context.Context) ([]types.PeerMapping, error) {
+	return r.PeerMappings, nil
+}
+
+// CachedResponse returns the cached response of metric for query, or nil
+func (r *StubMetricsRepo) CachedResponse(ctx context.Context, metric string, query url.Values) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id, _, _ := metricscache.Key(metric, query)
+	return r.cache[id], nil
+}
+
+// CacheResponse caches the response of metric for query
+func (r *StubMetricsRepo) CacheResponse(ctx context.Context, metric string, query url.Values, response []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id, _, _ := metricscache.Key(metric, query)
+	r.cache[id] = append([]byte(nil), response...)
+	return nil
+}
+
+// clearCache drops the cached responses
+func (r *StubMetricsRepo) clearCache() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	clear(r.cache)
+}
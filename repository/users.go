@@ -0,0 +1,269 @@
+// Package repository holds the queries of the control plane collections and of
+// the per-simulation data behind interfaces, so handlers do not build bson
+// themselves and can be given other implementations.
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"github.com/bft-labs/cometbft-analyzer-backend/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotFound is returned when the requested document does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned when a write would violate a unique index
+var ErrDuplicate = errors.New("duplicate")
+
+// UserFields maps the user fields selectable in a UserFilter, by JSON name, to their document fields
+var UserFields = map[string]string{
+	"id":                "_id",
+	"username":          "username",
+	"email":             "email",
+	"storageUsedBytes":  "storageUsedBytes",
+	"storageQuotaBytes": "storageQuotaBytes",
+	"createdAt":         "createdAt",
+	"updatedAt":         "updatedAt",
+}
+
+// UserFilter selects the users of a list. Zero fields do not filter.
+type UserFilter struct {
+	Search          string   // Case-insensitive substring of the username or email
+	Username        string   // Exact username
+	EmailNormalized string   // Exact normalized email
+	Fields          []string // Keys of UserFields to load, all when empty
+}
+
+// UserUpdate changes the set fields of a user
+type UserUpdate struct {
+	Username        *string
+	Email           *string
+	EmailNormalized *string
+}
+
+// NormalizeEmail returns the form of an email that is unique among users
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailBackfill reports a backfill of normalized emails
+type EmailBackfill struct {
+	Updated    int                 // Users given their normalized email
+	Duplicates map[string][]string // Normalized emails of several users, to their usernames
+}
+
+// UserRepo stores users
+type UserRepo interface {
+	// Create inserts user and sets its ID, or returns ErrDuplicate for a taken username or email
+	Create(ctx context.Context, user *types.User) error
+	// Get returns the user with id, or ErrNotFound
+	Get(ctx context.Context, id primitive.ObjectID) (types.User, error)
+	// GetByUsername returns the user with the exact username, or ErrNotFound
+	GetByUsername(ctx context.Context, username string) (types.User, error)
+	// Update applies update and returns the updated user, or ErrNotFound or ErrDuplicate
+	Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (types.User, error)
+	// List returns a page of the matching users, newest first, and their total count
+	List(ctx context.Context, filter UserFilter, page, perPage int) ([]types.User, int64, error)
+	// Delete deletes the user with id, or returns ErrNotFound
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// BackfillEmailNormalized sets the normalized email of the users created
+	// before it was stored. Users whose normalized email another user already
+	// has are left without one and reported as duplicates.
+	BackfillEmailNormalized(ctx context.Context) (EmailBackfill, error)
+}
+
+// planEmailBackfill returns the normalized email to set for each user lacking
+// one, skipping those of several users, and the usernames per such email
+func planEmailBackfill(users []types.User) (map[primitive.ObjectID]string, map[string][]string) {
+	byEmail := map[string][]types.User{}
+	for _, user := range users {
+		email := user.EmailNormalized
+		if email == "" {
+			email = NormalizeEmail(user.Email)
+		}
+		if email != "" {
+			byEmail[email] = append(byEmail[email], user)
+		}
+	}
+
+	updates := map[primitive.ObjectID]string{}
+	duplicates := map[string][]string{}
+	for email, owners := range byEmail {
+		if len(owners) > 1 {
+			for _, owner := range owners {
+				duplicates[email] = append(duplicates[email], owner.Username)
+			}
+			sort.Strings(duplicates[email])
+			continue
+		}
+		if owners[0].EmailNormalized == "" {
+			updates[owners[0].ID] = email
+		}
+	}
+	return updates, duplicates
+}
+
+// MongoUserRepo stores users in a MongoDB collection whose unique indexes
+// (see db.EnsureIndexes) enforce unique usernames and emails
+type MongoUserRepo struct {
+	collection *mongo.Collection
+}
+
+// NewMongoUserRepo returns a UserRepo backed by collection
+func NewMongoUserRepo(collection *mongo.Collection) *MongoUserRepo {
+	return &MongoUserRepo{collection: collection}
+}
+
+// Create inserts user and sets its ID
+func (r *MongoUserRepo) Create(ctx context.Context, user *types.User) error {
+	result, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	} else if err != nil {
+		return err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Get returns the user with id
+func (r *MongoUserRepo) Get(ctx context.Context, id primitive.ObjectID) (types.User, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+// GetByUsername returns the user with the exact username
+func (r *MongoUserRepo) GetByUsername(ctx context.Context, username string) (types.User, error) {
+	return r.findOne(ctx, bson.M{"username": username})
+}
+
+func (r *MongoUserRepo) findOne(ctx context.Context, filter bson.M) (types.User, error) {
+	var user types.User
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+// Update applies update, bumping updatedAt, and returns the updated user
+func (r *MongoUserRepo) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (types.User, error) {
+	set := bson.M{"updatedAt": time.Now()}
+	if update.Username != nil {
+		set["username"] = *update.Username
+	}
+	if update.Email != nil {
+		set["email"] = *update.Email
+	}
+	if update.EmailNormalized != nil {
+		set["emailNormalized"] = *update.EmailNormalized
+	}
+
+	var user types.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&user)
+	switch {
+	case mongo.IsDuplicateKeyError(err):
+		return user, ErrDuplicate
+	case err == mongo.ErrNoDocuments:
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+// List returns a page of the matching users, newest first. Exact matches are
+// served by the unique indexes.
+func (r *MongoUserRepo) List(ctx context.Context, filter UserFilter, page, perPage int) ([]types.User, int64, error) {
+	query := bson.M{}
+	if filter.Search != "" {
+		query = utils.SearchFilter(filter.Search, "username", "email")
+	}
+	if filter.Username != "" {
+		query["username"] = filter.Username
+	}
+	if filter.EmailNormalized != "" {
+		query["emailNormalized"] = filter.EmailNormalized
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{"createdAt", -1}, {"_id", -1}}).
+		SetSkip(int64((page - 1) * perPage)).
+		SetLimit(int64(perPage))
+	if len(filter.Fields) > 0 {
+		projection := bson.M{"_id": 0}
+		for _, field := range filter.Fields {
+			projection[UserFields[field]] = 1
+		}
+		opts.SetProjection(projection)
+	}
+
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []types.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Delete deletes the user with id
+func (r *MongoUserRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// BackfillEmailNormalized sets the normalized email of the users lacking one.
+// A user registered meanwhile with the same email is reported as a duplicate.
+func (r *MongoUserRepo) BackfillEmailNormalized(ctx context.Context) (EmailBackfill, error) {
+	opts := options.Find().SetProjection(bson.M{"username": 1, "email": 1, "emailNormalized": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return EmailBackfill{}, err
+	}
+	var users []types.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return EmailBackfill{}, err
+	}
+
+	updates, duplicates := planEmailBackfill(users)
+	backfill := EmailBackfill{Duplicates: duplicates}
+	for _, user := range users {
+		email, ok := updates[user.ID]
+		if !ok {
+			continue
+		}
+		filter := bson.M{"_id": user.ID, "emailNormalized": bson.M{"$exists": false}}
+		result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"emailNormalized": email}})
+		if mongo.IsDuplicateKeyError(err) {
+			backfill.Duplicates[email] = append(backfill.Duplicates[email], user.Username)
+			continue
+		} else if err != nil {
+			return backfill, err
+		}
+		backfill.Updated += int(result.ModifiedCount)
+	}
+	return backfill, nil
+}
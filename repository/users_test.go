@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/db"
+	"github.com/bft-labs/cometbft-analyzer-backend/db/dbtest"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// legacyUsers are users stored before emails were normalized, two of them
+// sharing an email in different cases
+var legacyUsers = []types.User{
+	{Username: "alice", Email: "Alice@Mail.io"},
+	{Username: "bob", Email: "bob@mail.io"},
+	{Username: "carol", Email: " BOB@mail.io "},
+	{Username: "dave", Email: "dave@mail.io", EmailNormalized: "dave@mail.io"},
+}
+
+// wantBackfill is the backfill of legacyUsers
+var wantBackfill = EmailBackfill{
+	Updated:    1,
+	Duplicates: map[string][]string{"bob@mail.io": {"bob", "carol"}},
+}
+
+// checkBackfilledEmails checks the normalized emails of legacyUsers after the backfill
+func checkBackfilledEmails(t *testing.T, users UserRepo) {
+	t.Helper()
+	want := map[string]string{"alice": "alice@mail.io", "bob": "", "carol": "", "dave": "dave@mail.io"}
+	for username, email := range want {
+		user, err := users.GetByUsername(context.Background(), username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.EmailNormalized != email {
+			t.Errorf("%s: emailNormalized = %q, want %q", username, user.EmailNormalized, email)
+		}
+	}
+}
+
+func TestMemoryUserRepoBackfillEmailNormalized(t *testing.T) {
+	users := NewMemoryUserRepo()
+	for _, user := range legacyUsers {
+		if err := users.Create(context.Background(), &user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backfill, err := users.BackfillEmailNormalized(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(backfill, wantBackfill) {
+		t.Errorf("backfill = %+v, want %+v", backfill, wantBackfill)
+	}
+	checkBackfilledEmails(t, users)
+
+	// Running it again finds nothing left to set
+	backfill, err = users.BackfillEmailNormalized(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backfill.Updated != 0 {
+		t.Errorf("second backfill updated %d users, want 0", backfill.Updated)
+	}
+}
+
+func TestMongoUserRepoBackfillEmailNormalized(t *testing.T) {
+	coll := dbtest.Database(t).Collection("users")
+	for _, user := range legacyUsers {
+		doc := bson.M{"username": user.Username, "email": user.Email}
+		if user.EmailNormalized != "" {
+			doc["emailNormalized"] = user.EmailNormalized
+		}
+		if _, err := coll.InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users := NewMongoUserRepo(coll)
+
+	backfill, err := users.BackfillEmailNormalized(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(backfill, wantBackfill) {
+		t.Errorf("backfill = %+v, want %+v", backfill, wantBackfill)
+	}
+	checkBackfilledEmails(t, users)
+
+	// The unique email index can be built once the duplicates are left out
+	if err := db.EnsureIndexes(context.Background(), coll, coll.Database().Collection("projects"), coll.Database().Collection("simulations")); err != nil {
+		t.Fatalf("EnsureIndexes after the backfill: %v", err)
+	}
+}
+
+func TestMongoUserRepoConcurrentCreate(t *testing.T) {
+	database := dbtest.Database(t)
+	coll := database.Collection("users")
+	if err := db.EnsureIndexes(context.Background(), coll, database.Collection("projects"), database.Collection("simulations")); err != nil {
+		t.Fatal(err)
+	}
+	users := NewMongoUserRepo(coll)
+
+	const attempts = 8
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := types.User{Username: "racer" + string(rune('a'+i)), Email: "racer@mail.io", EmailNormalized: "racer@mail.io"}
+			errs[i] = users.Create(context.Background(), &user)
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		} else if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Create: %v, want nil or ErrDuplicate", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("created %d users with the same email, want 1", created)
+	}
+}
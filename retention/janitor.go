@@ -0,0 +1,169 @@
+// Package retention prunes the raw event data of old simulations according to
+// the retention window of their project.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/metricscache"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultInterval is the time between janitor runs unless RETENTION_INTERVAL is set
+const DefaultInterval = time.Hour
+
+// RawCollections are the per-simulation collections of raw events that are
+// pruned. Summary collections such as network_latency_* are kept.
+var RawCollections = []string{"tracer_events", "vote_latencies"}
+
+// ErrRunning is returned when a run is requested while another is in progress
+var ErrRunning = errors.New("retention janitor is already running")
+
+// Janitor drops the raw event collections of processed simulations older than
+// the retainRawEventsDays of their project and marks them dataPruned
+type Janitor struct {
+	client      *mongo.Client
+	projects    *mongo.Collection
+	simulations *mongo.Collection
+	running     sync.Mutex
+}
+
+// NewJanitor creates a janitor for the simulations of projects
+func NewJanitor(client *mongo.Client, projects, simulations *mongo.Collection) *Janitor {
+	return &Janitor{client: client, projects: projects, simulations: simulations}
+}
+
+// Start runs the janitor every interval until ctx is done
+func (j *Janitor) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				summary, err := j.Run(ctx)
+				if errors.Is(err, ErrRunning) {
+					continue
+				} else if err != nil {
+					log.Printf("Warning: Retention janitor failed: %v", err)
+					continue
+				}
+				if summary.Simulations > 0 || len(summary.Failures) > 0 {
+					log.Printf("Retention janitor pruned %d simulations, %d failures", summary.Simulations, len(summary.Failures))
+				}
+			}
+		}
+	}()
+}
+
+// Run prunes every simulation past its project's retention window once. It
+// continues past failures of single simulations and reports them.
+func (j *Janitor) Run(ctx context.Context) (types.RetentionRunSummary, error) {
+	if !j.running.TryLock() {
+		return types.RetentionRunSummary{}, ErrRunning
+	}
+	defer j.running.Unlock()
+
+	summary := types.RetentionRunSummary{StartedAt: time.Now()}
+	cursor, err := j.projects.Find(ctx, bson.M{"retainRawEventsDays": bson.M{"$gt": 0}})
+	if err != nil {
+		return summary, err
+	}
+	var projects []types.Project
+	if err := cursor.All(ctx, &projects); err != nil {
+		return summary, err
+	}
+	summary.Projects = len(projects)
+
+	for _, project := range projects {
+		if ctx.Err() != nil {
+			break
+		}
+		cutoff := time.Now().AddDate(0, 0, -project.RetainRawEventsDays)
+		pruned, failures := j.pruneProject(ctx, project, cutoff)
+		summary.Simulations += pruned
+		summary.Failures = append(summary.Failures, failures...)
+	}
+
+	summary.FinishedAt = time.Now()
+	return summary, ctx.Err()
+}
+
+// pruneProject prunes the simulations of project processed before cutoff.
+// Simulations processed before processing results were recorded are aged by
+// their creation time.
+func (j *Janitor) pruneProject(ctx context.Context, project types.Project, cutoff time.Time) (int, []string) {
+	var failures []string
+	cursor, err := j.simulations.Find(ctx, bson.M{
+		"projectId":  project.ID,
+		"status":     types.SimulationStatusProcessed,
+		"dataPruned": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"processingResult.processedAt": bson.M{"$lt": cutoff}},
+			bson.M{"processingResult": bson.M{"$exists": false}, "createdAt": bson.M{"$lt": cutoff}},
+		},
+	})
+	if err != nil {
+		return 0, []string{fmt.Sprintf("project %s: %v", project.ID.Hex(), err)}
+	}
+	var simulations []types.Simulation
+	if err := cursor.All(ctx, &simulations); err != nil {
+		return 0, []string{fmt.Sprintf("project %s: %v", project.ID.Hex(), err)}
+	}
+
+	pruned := 0
+	for _, simulation := range simulations {
+		ok, err := j.pruneSimulation(ctx, simulation)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("simulation %s: %v", simulation.ID.Hex(), err))
+			continue
+		}
+		if ok {
+			pruned++
+		}
+	}
+	return pruned, failures
+}
+
+// pruneSimulation marks a simulation dataPruned while it is still processed,
+// so a simulation queued for reprocessing in the meantime is left alone, and
+// then drops its raw collections and cached metrics. The mark is reverted if
+// dropping fails. Reports false if the simulation no longer qualified.
+func (j *Janitor) pruneSimulation(ctx context.Context, simulation types.Simulation) (bool, error) {
+	now := time.Now()
+	result, err := j.simulations.UpdateOne(ctx,
+		bson.M{"_id": simulation.ID, "status": types.SimulationStatusProcessed, "dataPruned": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"dataPruned": true, "dataPrunedAt": now, "updatedAt": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		// Reprocessed or pruned concurrently
+		return false, nil
+	}
+
+	database := j.client.Database(simulation.ID.Hex())
+	for _, name := range RawCollections {
+		if err := database.Collection(name).Drop(ctx); err != nil {
+			j.simulations.UpdateOne(context.Background(), bson.M{"_id": simulation.ID},
+				bson.M{"$unset": bson.M{"dataPruned": "", "dataPrunedAt": ""}})
+			return false, fmt.Errorf("failed to drop %s: %w", name, err)
+		}
+	}
+	// Cached metrics were computed from the raw events
+	if err := metricscache.Drop(ctx, database); err != nil {
+		log.Printf("Warning: Failed to clear metrics cache of pruned simulation %s: %v", simulation.ID.Hex(), err)
+	}
+	return true, nil
+}
@@ -0,0 +1,68 @@
+// Package simarchive exports a processed simulation as a portable tar.gz
+// archive and imports such archives into a new simulation.
+//
+// An archive holds, in this order:
+//
+//	manifest.json                  format name and version, collections, log file count
+//	simulation.json                the simulation document
+//	collections/<name>/<n>.bson    chunks of concatenated BSON documents per collection
+//	logs/<index>_<name>            log files, when exported with them; index into the simulation's logFiles
+package simarchive
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/metricscache"
+)
+
+// Format identifies simulation archives in their manifest
+const Format = "cometbft-analyzer-simulation"
+
+// FormatVersion is the version of the archive layout written by Export. Import
+// only accepts archives of this version.
+const FormatVersion = 1
+
+const (
+	manifestEntry   = "manifest.json"
+	simulationEntry = "simulation.json"
+	collectionDir   = "collections/"
+	logDir          = "logs/"
+
+	// chunkBytes caps the documents buffered per collection entry, as tar
+	// entries need their size up front
+	chunkBytes = 8 << 20
+	// maxDocumentBytes is MongoDB's document size limit plus headroom
+	maxDocumentBytes = 16<<20 + 16<<10
+)
+
+var (
+	// ErrInvalidArchive is returned for archives that are corrupt or do not follow the format
+	ErrInvalidArchive = errors.New("invalid simulation archive")
+	// ErrUnsupportedVersion is returned for archives of another format version
+	ErrUnsupportedVersion = errors.New("unsupported simulation archive version")
+)
+
+// Manifest describes the content of an archive
+type Manifest struct {
+	Format       string    `json:"format"`
+	Version      int       `json:"version"`
+	ExportedAt   time.Time `json:"exportedAt"`
+	SimulationID string    `json:"simulationId"` // ID of the exported simulation
+	Collections  []string  `json:"collections"`  // Collections of the simulation database
+	LogFiles     int       `json:"logFiles"`     // Log files included, 0 when exported without them
+}
+
+// invalid wraps ErrInvalidArchive with details
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidArchive, fmt.Sprintf(format, args...))
+}
+
+// isArchivedCollection reports whether a collection of the simulation database
+// belongs in an archive. The metrics cache is derived and rebuilt on demand.
+func isArchivedCollection(name string) bool {
+	return name != "" && name != metricscache.CollectionName &&
+		!strings.HasPrefix(name, "system.") && !strings.ContainsAny(name, "/$\x00")
+}
@@ -0,0 +1,143 @@
+package simarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Export writes simulation and the collections of its database as a tar.gz
+// archive to w. Documents are copied from the cursors in chunks of at most
+// chunkBytes, so memory use does not grow with the simulation. With a store,
+// the log files are included, logKeys[i] being the storage key of
+// simulation.LogFiles[i]. Output written before an error is a truncated archive.
+func Export(ctx context.Context, w io.Writer, database *mongo.Database, simulation types.Simulation, store storage.Storage, logKeys []string) error {
+	names, err := database.ListCollectionNames(ctx, bson.M{"type": "collection"})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	collections := []string{}
+	for _, name := range names {
+		if isArchivedCollection(name) {
+			collections = append(collections, name)
+		}
+	}
+	sort.Strings(collections)
+
+	manifest := Manifest{
+		Format:       Format,
+		Version:      FormatVersion,
+		ExportedAt:   time.Now(),
+		SimulationID: simulation.ID.Hex(),
+		Collections:  collections,
+	}
+	if store != nil {
+		manifest.LogFiles = len(simulation.LogFiles)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeJSON(tw, manifestEntry, manifest); err != nil {
+		return err
+	}
+	if err := writeJSON(tw, simulationEntry, simulation); err != nil {
+		return err
+	}
+	for _, name := range collections {
+		if err := writeCollection(ctx, tw, database.Collection(name)); err != nil {
+			return fmt.Errorf("failed to export %s: %w", name, err)
+		}
+	}
+	if store != nil {
+		for i, logFile := range simulation.LogFiles {
+			if err := writeLogFile(ctx, tw, store, i, logFile, logKeys[i]); err != nil {
+				return fmt.Errorf("failed to export log file %s: %w", logFile.OriginalFilename, err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeEntry writes a regular file entry
+func writeEntry(tw *tar.Writer, name string, size int64, content io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, content)
+	return err
+}
+
+// writeJSON writes value as a JSON entry
+func writeJSON(tw *tar.Writer, name string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, int64(len(data)), bytes.NewReader(data))
+}
+
+// writeCollection writes the documents of collection as numbered chunk entries
+func writeCollection(ctx context.Context, tw *tar.Writer, collection *mongo.Collection) error {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	var chunk []byte
+	chunks := 0
+	flush := func() error {
+		name := fmt.Sprintf("%s%s/%06d.bson", collectionDir, collection.Name(), chunks)
+		if err := writeEntry(tw, name, int64(len(chunk)), bytes.NewReader(chunk)); err != nil {
+			return err
+		}
+		chunks++
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		if len(chunk) > 0 && len(chunk)+len(cursor.Current) > chunkBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		chunk = append(chunk, cursor.Current...)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		return flush()
+	}
+	return nil
+}
+
+// writeLogFile copies a stored log file into the archive
+func writeLogFile(ctx context.Context, tw *tar.Writer, store storage.Storage, index int, logFile types.LogFileInfo, key string) error {
+	object, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	name := fmt.Sprintf("%s%d_%s", logDir, index, logupload.StoredName(logFile.OriginalFilename))
+	return writeEntry(tw, name, object.Size(), object)
+}
@@ -0,0 +1,241 @@
+package simarchive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/cometbft-analyzer-backend/logupload"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// insertBatchBytes caps the size of the document batches inserted on import
+const insertBatchBytes = 8 << 20
+
+// Imported is the content of an imported archive
+type Imported struct {
+	Manifest   Manifest
+	Simulation types.Simulation    // The document as exported
+	Documents  map[string]int64    // Restored documents per collection
+	LogFiles   []types.LogFileInfo // Staged log files carrying their exported metadata
+}
+
+// Import reads an archive written by Export from r, restores its collections
+// into database, which should be new, and writes its log files to stageDir.
+// The archive is validated while it is read: it must be of FormatVersion and
+// hold a processed simulation. Log files are checked like uploads and are
+// subject to limits. On error the database is dropped and the staged files are
+// removed; errors about the archive itself wrap ErrInvalidArchive or
+// ErrUnsupportedVersion.
+func Import(ctx context.Context, r io.Reader, database *mongo.Database, stageDir string, limits logupload.Limits) (Imported, error) {
+	imported, err := importArchive(ctx, r, database, stageDir, limits)
+	if err != nil {
+		database.Drop(context.Background())
+		for _, logFile := range imported.LogFiles {
+			if logFile.FilePath != "" {
+				os.Remove(logFile.FilePath)
+			}
+		}
+		return Imported{}, err
+	}
+	return imported, nil
+}
+
+func importArchive(ctx context.Context, r io.Reader, database *mongo.Database, stageDir string, limits logupload.Limits) (Imported, error) {
+	imported := Imported{Documents: map[string]int64{}}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return imported, fmt.Errorf("%w: not a gzip stream: %w", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	next := func() (*tar.Header, error) {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, invalid("entry %q is not a regular file", header.Name)
+		}
+		return header, nil
+	}
+
+	// The manifest and the simulation come first so everything else can be checked against them
+	header, err := next()
+	if err != nil || header.Name != manifestEntry {
+		return imported, invalid("archive does not start with %s", manifestEntry)
+	}
+	if err := decodeJSON(tr, &imported.Manifest); err != nil {
+		return imported, invalid("%s: %v", manifestEntry, err)
+	}
+	manifest := imported.Manifest
+	if manifest.Format != Format {
+		return imported, invalid("unknown format %q", manifest.Format)
+	}
+	if manifest.Version != FormatVersion {
+		return imported, fmt.Errorf("%w: %d, expected %d", ErrUnsupportedVersion, manifest.Version, FormatVersion)
+	}
+	for _, name := range manifest.Collections {
+		if !isArchivedCollection(name) {
+			return imported, invalid("invalid collection name %q", name)
+		}
+		imported.Documents[name] = 0
+	}
+
+	header, err = next()
+	if err != nil || header.Name != simulationEntry {
+		return imported, invalid("%s does not follow the manifest", simulationEntry)
+	}
+	if err := decodeJSON(tr, &imported.Simulation); err != nil {
+		return imported, invalid("%s: %v", simulationEntry, err)
+	}
+	simulation := imported.Simulation
+	if simulation.Status != types.SimulationStatusProcessed {
+		return imported, invalid("simulation is %s, not processed", simulation.Status)
+	}
+	if manifest.LogFiles != 0 && manifest.LogFiles != len(simulation.LogFiles) {
+		return imported, invalid("manifest lists %d log files, the simulation %d", manifest.LogFiles, len(simulation.LogFiles))
+	}
+	if manifest.LogFiles > 0 {
+		imported.LogFiles = make([]types.LogFileInfo, manifest.LogFiles)
+	}
+
+	for {
+		header, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, err
+		}
+
+		switch {
+		case strings.HasPrefix(header.Name, collectionDir):
+			name, _, _ := strings.Cut(strings.TrimPrefix(header.Name, collectionDir), "/")
+			if !slices.Contains(manifest.Collections, name) {
+				return imported, invalid("entry %q is not of a listed collection", header.Name)
+			}
+			restored, err := restoreChunk(ctx, tr, database.Collection(name))
+			imported.Documents[name] += restored
+			if err != nil {
+				return imported, fmt.Errorf("%s: %w", header.Name, err)
+			}
+		case strings.HasPrefix(header.Name, logDir):
+			prefix, _, _ := strings.Cut(strings.TrimPrefix(header.Name, logDir), "_")
+			index, err := strconv.Atoi(prefix)
+			if err != nil || index < 0 || index >= len(imported.LogFiles) || imported.LogFiles[index].FilePath != "" {
+				return imported, invalid("unexpected log file entry %q", header.Name)
+			}
+			logFile, err := stageLogFile(tr, simulation.LogFiles[index], stageDir, index, limits)
+			if err != nil {
+				return imported, err
+			}
+			imported.LogFiles[index] = logFile
+		default:
+			return imported, invalid("unexpected entry %q", header.Name)
+		}
+	}
+
+	for _, logFile := range imported.LogFiles {
+		if logFile.FilePath == "" {
+			return imported, invalid("log file %s is missing", logFile.OriginalFilename)
+		}
+	}
+	return imported, nil
+}
+
+// decodeJSON decodes a single JSON value from an entry
+func decodeJSON(r io.Reader, value any) error {
+	return json.NewDecoder(io.LimitReader(r, maxDocumentBytes)).Decode(value)
+}
+
+// restoreChunk inserts the concatenated BSON documents of a chunk in batches
+// and returns the number of documents inserted
+func restoreChunk(ctx context.Context, r io.Reader, collection *mongo.Collection) (int64, error) {
+	var restored int64
+	var batch []any
+	batchBytes := 0
+	insert := func() error {
+		if _, err := collection.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		restored += int64(len(batch))
+		batch, batchBytes = nil, 0
+		return nil
+	}
+
+	for {
+		var length [4]byte
+		if _, err := io.ReadFull(r, length[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return restored, fmt.Errorf("%w: truncated document: %w", ErrInvalidArchive, err)
+		}
+		size := binary.LittleEndian.Uint32(length[:])
+		if size < 5 || size > maxDocumentBytes {
+			return restored, invalid("document of %d bytes", size)
+		}
+		document := make(bson.Raw, size)
+		copy(document, length[:])
+		if _, err := io.ReadFull(r, document[4:]); err != nil {
+			return restored, fmt.Errorf("%w: truncated document: %w", ErrInvalidArchive, err)
+		}
+		if err := document.Validate(); err != nil {
+			return restored, invalid("malformed document: %v", err)
+		}
+
+		batch = append(batch, document)
+		batchBytes += len(document)
+		if batchBytes >= insertBatchBytes {
+			if err := insert(); err != nil {
+				return restored, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		return restored, insert()
+	}
+	return restored, nil
+}
+
+// stageLogFile writes a log file entry to stageDir, checking it like an upload,
+// and returns its exported metadata pointing at the staged copy
+func stageLogFile(r io.Reader, exported types.LogFileInfo, stageDir string, index int, limits logupload.Limits) (types.LogFileInfo, error) {
+	if err := os.MkdirAll(stageDir, 0755); err != nil {
+		return types.LogFileInfo{}, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	filename := logupload.StoredName(exported.OriginalFilename)
+	filePath := filepath.Join(stageDir, fmt.Sprintf("temp_%d_%d_%s", time.Now().UnixNano(), index, filename))
+	written, err := logupload.WriteLogFile(filePath, filename, r, limits)
+	if err != nil {
+		return types.LogFileInfo{}, &logupload.UploadError{Filename: exported.OriginalFilename, Err: err}
+	}
+	if exported.Checksum != "" && written.Checksum != exported.Checksum {
+		os.Remove(filePath)
+		return types.LogFileInfo{}, invalid("checksum of log file %s does not match", exported.OriginalFilename)
+	}
+
+	logFile := exported
+	logFile.FilePath = filePath
+	logFile.StoredFilename = ""
+	logFile.StorageKey = ""
+	logFile.FileSize = written.Size
+	logFile.Checksum = written.Checksum
+	return logFile, nil
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage stores objects as files below a root directory on local disk
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage creates a LocalStorage rooted at root
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+// path maps a key to a file below the root, rejecting keys that escape it
+func (s *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+// EnsureDir creates the directory for keys below prefix
+func (s *LocalStorage) EnsureDir(ctx context.Context, prefix string) error {
+	dir, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+// Save writes r to the file for key
+func (s *LocalStorage) Save(ctx context.Context, key string, r io.Reader, size int64) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	return nil
+}
+
+// importFile renames a local file into place, falling back to copying when
+// the file lives on another filesystem
+func (s *LocalStorage) importFile(key, localPath string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(localPath, filePath); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := s.Save(context.Background(), key, src, -1); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(localPath)
+}
+
+// Open opens the file for key
+func (s *LocalStorage) Open(ctx context.Context, key string) (Object, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &localObject{File: file, info: info}, nil
+}
+
+// Delete removes the file for key
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RemoveDir removes the directory for prefix with all files below it
+func (s *LocalStorage) RemoveDir(ctx context.Context, prefix string) error {
+	dirPath, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dirPath)
+}
+
+// DirSize sums the sizes of the regular files in the directory for prefix and its subdirectories
+func (s *LocalStorage) DirSize(ctx context.Context, prefix string) (int64, error) {
+	dirPath, err := s.path(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err = filepath.WalkDir(dirPath, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	return size, err
+}
+
+// MoveDir renames the directory for from, with everything below it, to the directory for to
+func (s *LocalStorage) MoveDir(ctx context.Context, from, to string, keys []string) error {
+	for _, key := range keys {
+		if !strings.HasPrefix(key, from+"/") {
+			return fmt.Errorf("storage key %q is outside %q", key, from)
+		}
+	}
+	fromPath, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := s.path(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fromPath); os.IsNotExist(err) {
+		// Nothing was ever stored below from
+		return nil
+	}
+	if _, err := os.Stat(toPath); err == nil {
+		return fmt.Errorf("%s already exists", toPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(fromPath, toPath)
+}
+
+// Location returns the path of the file for key
+func (s *LocalStorage) Location(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// Materialize returns the directory below prefix, since the files already live on local disk
+func (s *LocalStorage) Materialize(ctx context.Context, prefix string, keys []string) (string, func(), error) {
+	dir, err := s.path(prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix+"/") {
+			return "", nil, fmt.Errorf("storage key %q is outside %q", key, prefix)
+		}
+	}
+	return dir, func() {}, nil
+}
+
+// localObject adapts an *os.File to Object
+type localObject struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (o *localObject) Size() int64 {
+	return o.info.Size()
+}
+
+func (o *localObject) ModTime() time.Time {
+	return o.info.ModTime()
+}
@@ -0,0 +1,545 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsignedPayload skips payload hashing so large uploads can be streamed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config configures an S3Storage
+type S3Config struct {
+	Bucket          string
+	Prefix          string // Optional key prefix inside the bucket
+	Region          string
+	Endpoint        string // Optional custom endpoint (e.g. MinIO); uses path-style URLs
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3Storage stores objects in an S3 (compatible) bucket using plain HTTP
+// requests signed with AWS Signature Version 4
+type S3Storage struct {
+	config S3Config
+	client *http.Client
+}
+
+// Validate checks that the bucket and the credentials are set
+func (config S3Config) Validate() error {
+	if config.Bucket == "" {
+		return errors.New("S3 bucket is required")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return errors.New("S3 credentials are required")
+	}
+	return nil
+}
+
+// NewS3Storage creates an S3Storage
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return &S3Storage{config: config, client: &http.Client{}}, nil
+}
+
+// S3ConfigFromEnv reads S3_BUCKET, S3_PREFIX, S3_REGION (or AWS_REGION),
+// S3_ENDPOINT and the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN credentials
+func S3ConfigFromEnv() S3Config {
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	return S3Config{
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Prefix:          os.Getenv("S3_PREFIX"),
+		Region:          region,
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// objectKey prepends the configured prefix to key
+func (s *S3Storage) objectKey(key string) string {
+	if s.config.Prefix == "" {
+		return key
+	}
+	return s.config.Prefix + "/" + key
+}
+
+// objectURL returns the URL of key
+func (s *S3Storage) objectURL(key string) *url.URL {
+	return s.bucketURL("/" + s.objectKey(key))
+}
+
+// bucketURL returns the URL of objectPath in the bucket, virtual-hosted style
+// on AWS and path style on custom endpoints
+func (s *S3Storage) bucketURL(objectPath string) *url.URL {
+	if s.config.Endpoint == "" {
+		return &url.URL{
+			Scheme:  "https",
+			Host:    fmt.Sprintf("%s.s3.%s.amazonaws.com", s.config.Bucket, s.config.Region),
+			Path:    objectPath,
+			RawPath: uriEncode(objectPath, false),
+		}
+	}
+
+	u, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: s.config.Endpoint}
+	}
+	u.Path = "/" + s.config.Bucket + objectPath
+	u.RawPath = uriEncode(u.Path, false)
+	return u
+}
+
+// do sends a signed request for key
+func (s *S3Storage) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	return s.send(ctx, method, s.objectURL(key), body, size, header)
+}
+
+// send sends a signed request to u
+func (s *S3Storage) send(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// EnsureDir is a no-op since S3 has no directories
+func (s *S3Storage) EnsureDir(ctx context.Context, prefix string) error {
+	return nil
+}
+
+// Save uploads size bytes read from r under key
+func (s *S3Storage) Save(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size < 0 {
+		return errors.New("S3 uploads require a known size")
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError("upload", key, resp)
+	}
+	return nil
+}
+
+// Open looks up the size of key; the content is fetched lazily with ranged GET requests
+func (s *S3Storage) Open(ctx context.Context, key string) (Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.responseError("open", key, resp)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &s3Object{ctx: ctx, storage: s, key: key, size: resp.ContentLength, modTime: modTime}, nil
+}
+
+// Delete removes key from the bucket
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s.responseError("delete", key, resp)
+	}
+	return nil
+}
+
+// RemoveDir is a no-op since S3 has no directories; objects are removed with Delete
+func (s *S3Storage) RemoveDir(ctx context.Context, prefix string) error {
+	return nil
+}
+
+// listObjectsResult is the part of a ListObjectsV2 response DirSize reads
+type listObjectsResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// DirSize sums the sizes of the objects below prefix, listing them with
+// ListObjectsV2. Since S3 has no directories, a prefix without objects is
+// reported as ErrNotFound.
+func (s *S3Storage) DirSize(ctx context.Context, prefix string) (int64, error) {
+	var size int64
+	var objects int
+	query := url.Values{"list-type": {"2"}, "prefix": {s.objectKey(prefix) + "/"}}
+	for {
+		u := s.bucketURL("/")
+		u.RawQuery = canonicalQuery(query)
+		resp, err := s.send(ctx, http.MethodGet, u, nil, 0, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s.responseError("list", prefix, resp)
+			resp.Body.Close()
+			return 0, err
+		}
+
+		var result listObjectsResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, object := range result.Contents {
+			size += object.Size
+		}
+		objects += len(result.Contents)
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	if objects == 0 {
+		return 0, ErrNotFound
+	}
+	return size, nil
+}
+
+// MoveDir copies every key to its place below to and deletes the originals
+// once all copies exist. If a copy fails, the copies made so far are removed.
+func (s *S3Storage) MoveDir(ctx context.Context, from, to string, keys []string) error {
+	for _, key := range keys {
+		if !strings.HasPrefix(key, from+"/") {
+			return fmt.Errorf("storage key %q is outside %q", key, from)
+		}
+	}
+
+	copied := make([]string, 0, len(keys))
+	for _, key := range keys {
+		dst := to + strings.TrimPrefix(key, from)
+		if err := s.copyObject(ctx, key, dst); err != nil {
+			s.deleteAll(ctx, copied)
+			return err
+		}
+		copied = append(copied, dst)
+	}
+
+	// The copies are in place; an original that cannot be deleted only wastes space
+	s.deleteAll(ctx, keys)
+	return nil
+}
+
+// copyObject copies src to dst within the bucket
+func (s *S3Storage) copyObject(ctx context.Context, src, dst string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", uriEncode("/"+s.config.Bucket+"/"+s.objectKey(src), false))
+	resp, err := s.do(ctx, http.MethodPut, dst, nil, 0, header)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError("copy", src, resp)
+	}
+	return nil
+}
+
+// deleteAll deletes keys, logging the ones that cannot be deleted
+func (s *S3Storage) deleteAll(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
+
+// Location returns the s3:// URL of key
+func (s *S3Storage) Location(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.config.Bucket, s.objectKey(key))
+}
+
+// Materialize downloads keys into a temporary directory
+func (s *S3Storage) Materialize(ctx context.Context, prefix string, keys []string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "simulation-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	for _, key := range keys {
+		if err := s.download(ctx, key, filepath.Join(dir, path.Base(key))); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	return dir, cleanup, nil
+}
+
+// download writes the object stored under key to the local file dst
+func (s *S3Storage) download(ctx context.Context, key, dst string) error {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError("download", key, resp)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// responseError converts a non-success S3 response to an error
+func (s *S3Storage) responseError(op, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("failed to %s %s: S3 returned %s: %s", op, key, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sign adds AWS Signature Version 4 headers to req. The payload is left
+// unsigned unless req already carries its hash in X-Amz-Content-Sha256.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	canonicalRequest, signedHeaders := canonicalRequest(req)
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalRequest builds the SigV4 canonical request of req, signing the
+// host, range and all x-amz-* headers, and returns it with the signed header names
+func canonicalRequest(req *http.Request) (canonical, signedHeaders string) {
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	canonical = strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(), // already encoded by bucketURL
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	return canonical, signedHeaders
+}
+
+// canonicalQuery builds the sorted, encoded query string of a SigV4 canonical request
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, val := range vals {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(val, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters (and '/' unless encodeSlash)
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Object reads an S3 object with ranged GET requests, reopening the
+// response body whenever the read position is moved by Seek
+type s3Object struct {
+	ctx     context.Context
+	storage *S3Storage
+	key     string
+	size    int64
+	modTime time.Time
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.offset, 10) + "-"}}
+		resp, err := o.storage.do(o.ctx, http.MethodGet, o.key, nil, 0, header)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return 0, o.storage.responseError("read", o.key, resp)
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = o.offset + offset
+	case io.SeekEnd:
+		target = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if target < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if target != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = target
+	return target, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+func (o *s3Object) Size() int64 {
+	return o.size
+}
+
+func (o *s3Object) ModTime() time.Time {
+	return o.modTime
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist in the storage backend
+var ErrNotFound = errors.New("object not found")
+
+// Object is an open stored file. It supports seeking so it can be served with
+// HTTP Range requests.
+type Object interface {
+	io.ReadSeekCloser
+	Size() int64
+	ModTime() time.Time
+}
+
+// Storage stores uploaded log files under slash-separated keys such as
+// "user_<id>/project_<id>/simulation_<id>/0_node.log"
+type Storage interface {
+	// EnsureDir prepares the location for keys below prefix
+	EnsureDir(ctx context.Context, prefix string) error
+	// Save stores size bytes read from r under key
+	Save(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open opens the object stored under key
+	Open(ctx context.Context, key string) (Object, error)
+	// Delete removes the object stored under key
+	Delete(ctx context.Context, key string) error
+	// RemoveDir removes the location prepared by EnsureDir and anything left below prefix
+	RemoveDir(ctx context.Context, prefix string) error
+	// DirSize returns the total size of the files below prefix, or ErrNotFound
+	// if the location prepared by EnsureDir does not exist
+	DirSize(ctx context.Context, prefix string) (int64, error)
+	// MoveDir moves the objects under keys, which all lie below from, and the
+	// location prepared by EnsureDir to the same keys below to. On error nothing
+	// is left moved.
+	MoveDir(ctx context.Context, from, to string, keys []string) error
+	// Location returns a human readable location of key, stored as LogFileInfo.FilePath
+	Location(key string) string
+	// Materialize makes the objects under keys available as files in a local
+	// directory, e.g. for running the ETL. cleanup removes any temporary copies.
+	Materialize(ctx context.Context, prefix string, keys []string) (dir string, cleanup func(), err error)
+}
+
+// fileImporter is implemented by backends that can take over a local file
+// without copying it
+type fileImporter interface {
+	importFile(key, path string) error
+}
+
+// SaveFile moves the local file at path into the storage under key. The local
+// file is removed once it has been stored.
+func SaveFile(ctx context.Context, s Storage, key, path string) error {
+	if importer, ok := s.(fileImporter); ok {
+		return importer.importFile(key, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := s.Save(ctx, key, file, info.Size()); err != nil {
+		return err
+	}
+
+	file.Close()
+	return os.Remove(path)
+}
+
+// Storage backends selectable with New
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// New creates the storage backend named backend: BackendLocal stores below
+// localRoot, BackendS3 in the bucket of s3Config
+func New(backend, localRoot string, s3Config S3Config) (Storage, error) {
+	switch backend {
+	case BackendLocal:
+		return NewLocalStorage(localRoot), nil
+	case BackendS3:
+		return NewS3Storage(s3Config)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
@@ -7,11 +7,14 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Username  string             `json:"username" bson:"username"`
-	Email     string             `json:"email" bson:"email"`
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Username          string             `json:"username" bson:"username"`
+	Email             string             `json:"email" bson:"email"`
+	EmailNormalized   string             `json:"-" bson:"emailNormalized,omitempty"`                             // Lowercased email, unique
+	StorageUsedBytes  int64              `json:"storageUsedBytes" bson:"storageUsedBytes"`                       // Sum of stored log file sizes
+	StorageQuotaBytes *int64             `json:"storageQuotaBytes,omitempty" bson:"storageQuotaBytes,omitempty"` // Overrides DEFAULT_USER_QUOTA_BYTES, 0 = unlimited
+	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt         time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
 // Project represents a project owned by a user
@@ -20,8 +23,10 @@ type Project struct {
 	Name        string             `json:"name" bson:"name"`
 	Description string             `json:"description" bson:"description"`
 	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
-	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+	// Raw events of simulations processed longer ago are pruned; 0 keeps them forever
+	RetainRawEventsDays int       `json:"retainRawEventsDays,omitempty" bson:"retainRawEventsDays,omitempty"`
+	CreatedAt           time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt           time.Time `json:"updatedAt" bson:"updatedAt"`
 }
 
 // SimulationStatus represents the overall status of a simulation
@@ -47,11 +52,116 @@ const (
 // LogFileInfo represents metadata for an uploaded log file
 type LogFileInfo struct {
 	OriginalFilename string    `json:"originalFilename" bson:"originalFilename"`
-	FilePath         string    `json:"filePath" bson:"filePath"`
-	FileSize         int64     `json:"fileSize" bson:"fileSize"`
+	FilePath         string    `json:"filePath" bson:"filePath"`                                 // Local path or s3:// URL, informational
+	StoredFilename   string    `json:"storedFilename,omitempty" bson:"storedFilename,omitempty"` // Generated unique filename
+	StorageKey       string    `json:"storageKey,omitempty" bson:"storageKey,omitempty"`         // Key in the storage backend
+	FileSize         int64     `json:"fileSize" bson:"fileSize"`                                 // Stored size (decompressed)
+	Compressed       bool      `json:"compressed,omitempty" bson:"compressed,omitempty"`         // Uploaded as .gz/.zst
+	Checksum         string    `json:"checksum,omitempty" bson:"checksum,omitempty"`             // Hex SHA-256 of the decompressed content
+	Source           string    `json:"source,omitempty" bson:"source,omitempty"`                 // URL or archive the file came from
 	UploadedAt       time.Time `json:"uploadedAt" bson:"uploadedAt"`
 }
 
+// LogFileListEntry represents a log file together with its index in the simulation's LogFiles array
+type LogFileListEntry struct {
+	Index int `json:"index"`
+	LogFileInfo
+}
+
+// FetchLogFilesRequest represents the request body for fetching log files from URLs
+type FetchLogFilesRequest struct {
+	URLs []string `json:"urls" binding:"required,min=1,dive,required"`
+}
+
+// FetchLogFileResult reports the outcome of fetching a single URL
+type FetchLogFileResult struct {
+	URL      string `json:"url"`
+	Success  bool   `json:"success"`
+	Filename string `json:"filename,omitempty"`
+	FileSize int64  `json:"fileSize,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ProjectStorageUsage represents the storage used by the simulations of a project
+type ProjectStorageUsage struct {
+	ProjectID      primitive.ObjectID `json:"projectId" bson:"_id"`
+	UsedBytes      int64              `json:"usedBytes" bson:"usedBytes"` // Sum of the log file sizes
+	LogFiles       int                `json:"logFiles" bson:"logFiles"`
+	Simulations    int                `json:"simulations" bson:"-"`
+	ProcessedBytes int64              `json:"processedBytes" bson:"-"` // Size of the processed/ directories on disk
+	MissingOnDisk  int                `json:"missingOnDisk" bson:"-"`  // Simulations whose directory no longer exists
+}
+
+// ProjectStats summarizes the simulations of a project
+type ProjectStats struct {
+	Simulations      int64                      `json:"simulations"`
+	ByStatus         map[SimulationStatus]int64 `json:"byStatus"`                   // Every status, 0 when unused
+	LastSimulationAt *time.Time                 `json:"lastSimulationAt,omitempty"` // createdAt of the newest simulation
+	StoredBytes      int64                      `json:"storedBytes"`                // Size of the uploaded log files
+}
+
+// NewProjectStats returns the stats of a project without simulations
+func NewProjectStats() *ProjectStats {
+	return &ProjectStats{ByStatus: map[SimulationStatus]int64{
+		SimulationStatusLogFileRequired: 0,
+		SimulationStatusProcessing:      0,
+		SimulationStatusProcessed:       0,
+		SimulationStatusFailed:          0,
+	}}
+}
+
+// ProjectWithStats is a project together with the stats of its simulations
+type ProjectWithStats struct {
+	Project
+	Stats *ProjectStats `json:"stats"`
+}
+
+// UserDeletionSummary reports what a cascading user deletion removed and what failed
+type UserDeletionSummary struct {
+	UserDeleted bool     `json:"userDeleted"` // Only once no project or simulation is left
+	Projects    int64    `json:"projects"`
+	Simulations int64    `json:"simulations"`
+	LogFiles    int      `json:"logFiles"`
+	Databases   int      `json:"databases"` // Per-simulation databases dropped
+	Failures    []string `json:"failures,omitempty"`
+}
+
+// ProjectDeletionSummary reports what a project deletion removed and what failed
+type ProjectDeletionSummary struct {
+	ProjectDeleted bool     `json:"projectDeleted"` // Only once no simulation is left
+	Simulations    int64    `json:"simulations"`
+	FreedBytes     int64    `json:"freedBytes"` // Size of the deleted log files
+	Failures       []string `json:"failures,omitempty"`
+}
+
+// ProjectTransferSummary reports a project moved to another user
+type ProjectTransferSummary struct {
+	Project     Project            `json:"project"`
+	FromUserID  primitive.ObjectID `json:"fromUserId"`
+	Simulations int                `json:"simulations"`
+	LogFiles    int                `json:"logFiles"`
+	MovedBytes  int64              `json:"movedBytes"` // Size of the moved log files, charged to the new owner
+}
+
+// UserStorageResponse represents a user's storage usage and quota
+type UserStorageResponse struct {
+	UserID         primitive.ObjectID    `json:"userId"`
+	UsedBytes      int64                 `json:"usedBytes"`
+	QuotaBytes     int64                 `json:"quotaBytes"`               // 0 = unlimited
+	RemainingBytes *int64                `json:"remainingBytes,omitempty"` // Omitted when unlimited
+	ProcessedBytes int64                 `json:"processedBytes"`
+	MissingOnDisk  int                   `json:"missingOnDisk"`
+	Projects       []ProjectStorageUsage `json:"projects"`
+}
+
+// DuplicateLogFile describes an uploaded log file skipped because its content was already uploaded
+type DuplicateLogFile struct {
+	Filename    string `json:"filename"`
+	DuplicateOf string `json:"duplicateOf"` // Original filename of the already stored copy
+	Checksum    string `json:"checksum"`
+	FilePath    string `json:"-"`
+}
+
 // ProcessingResult represents the result of processing log files
 type ProcessingResult struct {
 	ProcessedFiles int       `json:"processedFiles" bson:"processedFiles"`
@@ -69,9 +179,13 @@ type Simulation struct {
 	ProjectID        primitive.ObjectID `json:"projectId" bson:"projectId"`
 	UserID           primitive.ObjectID `json:"userId" bson:"userId"`
 	LogFiles         []LogFileInfo      `json:"logFiles,omitempty" bson:"logFiles,omitempty"`
+	PendingLogFiles  []LogFileInfo      `json:"pendingLogFiles,omitempty" bson:"pendingLogFiles,omitempty"` // Uploaded during processing, merged afterwards
 	Status           SimulationStatus   `json:"status" bson:"status"`
 	ProcessingStatus ProcessingStatus   `json:"processingStatus,omitempty" bson:"processingStatus,omitempty"`
 	ProcessingResult *ProcessingResult  `json:"processingResult,omitempty" bson:"processingResult,omitempty"`
+	Priority         int                `json:"priority" bson:"priority"`
+	DataPruned       bool               `json:"dataPruned,omitempty" bson:"dataPruned,omitempty"` // Raw events dropped by the retention janitor
+	DataPrunedAt     *time.Time         `json:"dataPrunedAt,omitempty" bson:"dataPrunedAt,omitempty"`
 	CreatedAt        time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt        time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
@@ -82,22 +196,36 @@ type CreateUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 }
 
+// UpdateUserRequest represents the request body for updating a user
+type UpdateUserRequest struct {
+	Username *string `json:"username,omitempty" binding:"omitempty,min=3,max=30,alphanum"`
+	Email    *string `json:"email,omitempty" binding:"omitempty,email"`
+}
+
 // CreateProjectRequest represents the request body for creating a project
 type CreateProjectRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
+	Name                string `json:"name" binding:"required"`
+	Description         string `json:"description"`
+	RetainRawEventsDays int    `json:"retainRawEventsDays" binding:"min=0"`
 }
 
 // UpdateProjectRequest represents the request body for updating a project
 type UpdateProjectRequest struct {
-	Name        *string `json:"name,omitempty"`
-	Description *string `json:"description,omitempty"`
+	Name                *string `json:"name,omitempty"`
+	Description         *string `json:"description,omitempty"`
+	RetainRawEventsDays *int    `json:"retainRawEventsDays,omitempty" binding:"omitempty,min=0"` // 0 keeps raw events forever
+}
+
+// TransferProjectRequest represents the request body for transferring a project to another user
+type TransferProjectRequest struct {
+	TargetUserID string `json:"targetUserId" binding:"required"`
 }
 
 // CreateSimulationRequest represents the request body for creating a simulation
 type CreateSimulationRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
+	Priority    int    `json:"priority"`
 }
 
 // UpdateSimulationRequest represents the request body for updating a simulation
@@ -106,6 +234,24 @@ type UpdateSimulationRequest struct {
 	Description *string `json:"description,omitempty"`
 }
 
+// ProcessSimulationRequest represents the optional request body for processing a simulation
+type ProcessSimulationRequest struct {
+	Priority *int `json:"priority,omitempty"`
+}
+
+// UpdateSimulationPriorityRequest represents the request body for changing a simulation's processing priority
+type UpdateSimulationPriorityRequest struct {
+	Priority *int `json:"priority" binding:"required"`
+}
+
+// ProcessingQueueEntry represents a simulation waiting in the processing queue
+type ProcessingQueueEntry struct {
+	Position     int                `json:"position"` // 1-based dequeue order
+	SimulationID primitive.ObjectID `json:"simulationId"`
+	Priority     int                `json:"priority"`
+	EnqueuedAt   time.Time          `json:"enqueuedAt"`
+}
+
 // SimulationResponse represents the response structure for simulation endpoints
 type SimulationResponse struct {
 	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
@@ -114,11 +260,21 @@ type SimulationResponse struct {
 	ProjectID        primitive.ObjectID `json:"projectId" bson:"projectId"`
 	UserID           primitive.ObjectID `json:"userId" bson:"userId"`
 	LogFiles         []LogFileInfo      `json:"logFiles,omitempty" bson:"logFiles,omitempty"`
+	PendingLogFiles  []LogFileInfo      `json:"pendingLogFiles,omitempty" bson:"pendingLogFiles,omitempty"` // Uploaded during processing, merged afterwards
 	Status           SimulationStatus   `json:"status" bson:"status"`
 	ProcessingStatus ProcessingStatus   `json:"processingStatus,omitempty" bson:"processingStatus,omitempty"`
 	ProcessingResult *ProcessingResult  `json:"processingResult,omitempty" bson:"processingResult,omitempty"`
+	Priority         int                `json:"priority" bson:"priority"`
+	DataPruned       bool               `json:"dataPruned,omitempty" bson:"dataPruned,omitempty"`
+	DataPrunedAt     *time.Time         `json:"dataPrunedAt,omitempty" bson:"dataPrunedAt,omitempty"`
 	CreatedAt        time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt        time.Time          `json:"updatedAt" bson:"updatedAt"`
+	// SkippedDuplicates lists uploaded files dropped as duplicates (?duplicates=skip)
+	SkippedDuplicates []DuplicateLogFile `json:"skippedDuplicates,omitempty" bson:"-"`
+	// ImportedFrom is the ID of the exported simulation an import was created from
+	ImportedFrom string `json:"importedFrom,omitempty" bson:"-"`
+	// ImportedDocuments counts the documents an import restored per collection
+	ImportedDocuments map[string]int64 `json:"importedDocuments,omitempty" bson:"-"`
 }
 
 // GetLogFilePaths returns just the file paths for backward compatibility
@@ -149,10 +305,23 @@ func (s *Simulation) ToResponse() SimulationResponse {
 		ProjectID:        s.ProjectID,
 		UserID:           s.UserID,
 		LogFiles:         s.LogFiles,
+		PendingLogFiles:  s.PendingLogFiles,
 		Status:           s.Status,
 		ProcessingStatus: s.ProcessingStatus,
 		ProcessingResult: s.ProcessingResult,
+		Priority:         s.Priority,
+		DataPruned:       s.DataPruned,
+		DataPrunedAt:     s.DataPrunedAt,
 		CreatedAt:        s.CreatedAt,
 		UpdatedAt:        s.UpdatedAt,
 	}
 }
+
+// RetentionRunSummary reports a run of the retention janitor
+type RetentionRunSummary struct {
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Projects    int       `json:"projects"`    // Projects with a retention window
+	Simulations int       `json:"simulations"` // Simulations whose raw events were pruned
+	Failures    []string  `json:"failures,omitempty"`
+}
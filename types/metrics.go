@@ -1,12 +1,23 @@
 package types
 
+import "time"
+
+// HeightRange is an optional block height range; nil bounds are open.
+type HeightRange struct {
+	From *int64
+	To   *int64
+}
+
 // PairLatency represents latency percentiles for a given sender→receiver pair.
 type PairLatency struct {
-	Sender   string  `json:"sender"`   // Node ID of the sender
-	Receiver string  `json:"receiver"` // Node ID of the receiver
-	P50Ms    float32 `json:"p50Ms"`    // 50th percentile latency in milliseconds
-	P95Ms    float32 `json:"p95Ms"`    // 95th percentile latency in milliseconds
-	P99Ms    float32 `json:"p99Ms"`    // 99th percentile latency in milliseconds
+	Sender   string  `json:"sender"`             // Node ID of the sender
+	Receiver string  `json:"receiver"`           // Node ID of the receiver
+	VoteType string  `json:"voteType,omitempty"` // Only set when grouping by vote type
+	P50Ms    float32 `json:"p50Ms"`              // 50th percentile latency in milliseconds
+	P95Ms    float32 `json:"p95Ms"`              // 95th percentile latency in milliseconds
+	P99Ms    float32 `json:"p99Ms"`              // 99th percentile latency in milliseconds
+
+	Percentiles map[string]float64 `json:"percentiles,omitempty"` // Requested quantile → latency (ms)
 }
 
 // BlockLatencyPoint is a single latency measurement record tied to a block height.
@@ -19,9 +30,11 @@ type BlockLatencyPoint struct {
 
 // LatencyHistogramBucket represents a bucket in the latency distribution.
 type LatencyHistogramBucket struct {
-	Lower float32 `json:"lower"` // Lower bound of the bucket (ms)
-	Upper float32 `json:"upper"` // Upper bound of the bucket (ms)
-	Count int64   `json:"count"` // Number of samples in this bucket
+	Lower     float32 `json:"lower" bson:"lower,truncate"`          // Lower bound of the bucket (ms); 0 or the smallest negative sample for the underflow bucket
+	Upper     float32 `json:"upper" bson:"upper,truncate"`          // Upper bound of the bucket (ms); the largest sample for the overflow bucket
+	Count     int64   `json:"count" bson:"count"`                   // Number of samples in this bucket
+	Underflow bool    `json:"underflow,omitempty" bson:"underflow"` // Samples below the first explicit boundary
+	Overflow  bool    `json:"overflow,omitempty" bson:"overflow"`   // Samples on or above the last explicit boundary
 }
 
 // LatencyJitter holds standard deviation (jitter) info for a sender→receiver pair.
@@ -39,17 +52,416 @@ type LatencyStats struct {
 
 // MessageSuccessRate measures send vs receive counts and delivery ratio.
 type MessageSuccessRate struct {
-	Height      uint64  `json:"height"`      // Block height
-	Sender      string  `json:"sender"`      // Node ID of the sender
-	Receiver    string  `json:"receiver"`    // Node ID of the receiver
-	SentCount   int64   `json:"sentCount"`   // Total send events
-	RecvCount   int64   `json:"recvCount"`   // Total receive events
-	SuccessRate float32 `json:"successRate"` // recvCount / sentCount
+	Height      uint64  `json:"height,omitempty" bson:"height"`     // Block height, omitted when aggregated over heights
+	Sender      string  `json:"sender,omitempty" bson:"sender"`     // Node ID of the sender, omitted when aggregated over pairs
+	Receiver    string  `json:"receiver,omitempty" bson:"receiver"` // Node ID of the receiver, omitted when aggregated over pairs
+	SentCount   int64   `json:"sentCount" bson:"sentCnt"`           // Total send events
+	RecvCount   int64   `json:"recvCount" bson:"recvCnt"`           // Total receive events
+	SuccessRate float32 `json:"successRate" bson:"successRate"`     // recvCount / sentCount
+}
+
+// DeliveryPoint is the vote delivery of a sender→receiver pair within a time bucket.
+type DeliveryPoint struct {
+	Start       time.Time `json:"start" bson:"start"`
+	SentCount   int64     `json:"sentCount" bson:"sentCnt"`
+	RecvCount   int64     `json:"recvCount" bson:"recvCnt"`
+	SuccessRate float64   `json:"successRate" bson:"successRate"` // recvCount / sentCount, 0 without sends
+}
+
+// DeliverySeries is the vote delivery time series of a sender→receiver pair.
+type DeliverySeries struct {
+	Sender         string          `json:"sender" bson:"sender"`
+	Receiver       string          `json:"receiver" bson:"receiver"`
+	AvgSuccessRate float64         `json:"avgSuccessRate" bson:"avgSuccessRate"` // Mean of the bucket success rates, used for topN
+	Points         []DeliveryPoint `json:"points" bson:"points"`
 }
 
 // BlockConsensusLatency captures consensus end-to-end latency per block.
 type BlockConsensusLatency struct {
-	Height uint64  `json:"height"` // Block height
-	P50Ms  float32 `json:"p50Ms"`  // 50th percentile end-to-end latency (ms)
-	P95Ms  float32 `json:"p95Ms"`  // 95th percentile end-to-end latency (ms)
+	Height uint64  `json:"height" bson:"height"`        // Block height
+	P50Ms  float32 `json:"p50Ms" bson:"p50Ms,truncate"` // 50th percentile end-to-end latency (ms)
+	P95Ms  float32 `json:"p95Ms" bson:"p95Ms,truncate"` // 95th percentile end-to-end latency (ms)
+}
+
+// RoundDuration is the time a node spent in a single consensus round.
+type RoundDuration struct {
+	NodeID     string    `json:"nodeId" bson:"nodeId"`
+	Height     int64     `json:"height" bson:"height"`
+	Round      int64     `json:"round" bson:"round"`
+	StartTime  time.Time `json:"startTime" bson:"startTime"`   // enteringNewRound
+	EndTime    time.Time `json:"endTime" bson:"endTime"`       // enteringCommitStep, or the next enteringNewRound
+	DurationMs float64   `json:"durationMs" bson:"durationMs"` // EndTime - StartTime (ms)
+	Committed  bool      `json:"committed" bson:"committed"`   // False for rounds that ended without commit
+}
+
+// RoundDurationSummary aggregates the round durations of all nodes at a block height.
+type RoundDurationSummary struct {
+	Height       int64   `json:"height" bson:"height"`
+	Rounds       int     `json:"rounds" bson:"rounds"`             // Node rounds measured
+	FailedRounds int     `json:"failedRounds" bson:"failedRounds"` // Node rounds that ended without commit
+	P50Ms        float64 `json:"p50Ms" bson:"p50Ms"`               // 50th percentile round duration (ms)
+	P95Ms        float64 `json:"p95Ms" bson:"p95Ms"`               // 95th percentile round duration (ms)
+}
+
+// ProposerStats summarizes the block proposals of a single proposer node.
+type ProposerStats struct {
+	NodeID           string  `json:"nodeId"`           // Empty when the proposer could not be mapped to a node
+	ValidatorAddress string  `json:"validatorAddress"` // Proposer address, when logged
+	Proposals        int     `json:"proposals"`        // (height, round) proposals made
+	FailedProposals  int     `json:"failedProposals"`  // Proposals whose round advanced without commit
+	FailedHeights    []int64 `json:"failedHeights"`    // Heights of the failed proposals
+	AvgPropagationMs float64 `json:"avgPropagationMs"` // Mean proposeStep → receivedCompleteProposalBlock on other nodes (ms)
+	Samples          int     `json:"samples"`          // Receptions averaged into AvgPropagationMs
+}
+
+// BlockInterval is the commit-to-commit time of a block height.
+type BlockInterval struct {
+	Height          int64              `json:"height"`
+	IntervalMs      *float64           `json:"intervalMs"`                // Median over nodes, null when no node committed both heights
+	MovingAverageMs *float64           `json:"movingAverageMs,omitempty"` // Mean interval over the moving-average window
+	NodeBreakdown   map[string]float64 `json:"nodeBreakdown"`             // Interval per node (ms)
+}
+
+// VoteParticipation lists the validators whose votes of one type were observed at a height (or round).
+type VoteParticipation struct {
+	Round         *int64 `json:"round,omitempty" bson:"round"` // Only set when grouping by round
+	VoteType      string `json:"voteType" bson:"voteType"`
+	Bitmap        string `json:"bitmap"`              // "x" for each validator index that voted, "_" otherwise
+	Participating int    `json:"participating"`       // Number of validators that voted
+	Validators    []int  `json:"-" bson:"validators"` // Validator indices that voted
+}
+
+// HeightVoteParticipation holds the vote participation of a block height.
+type HeightVoteParticipation struct {
+	Height int64               `json:"height" bson:"_id"`
+	Votes  []VoteParticipation `json:"votes" bson:"votes"`
+}
+
+// ValidatorParticipation is the share of vote slots a validator participated in.
+type ValidatorParticipation struct {
+	ValidatorIndex   int     `json:"validatorIndex"`
+	Participated     int     `json:"participated"`     // Vote slots with a vote from the validator
+	ParticipationPct float64 `json:"participationPct"` // Participated / all vote slots in range (%)
+}
+
+// VoteAnomaly classifies the delivery of a vote from a sender to a recipient.
+type VoteAnomaly struct {
+	Height         int64      `json:"height" bson:"height"`
+	Round          int64      `json:"round" bson:"round"`
+	VoteType       string     `json:"voteType" bson:"voteType"`
+	ValidatorIndex int64      `json:"validatorIndex" bson:"validatorIndex"`
+	Sender         string     `json:"sender" bson:"sender"`
+	Recipient      string     `json:"recipient" bson:"recipient"`
+	Status         string     `json:"status" bson:"status"` // delivered, lost or late
+	SentTime       time.Time  `json:"sentTime" bson:"sentTime"`
+	ReceivedTime   *time.Time `json:"receivedTime" bson:"receivedTime"`
+	CommitTime     *time.Time `json:"commitTime" bson:"commitTime"` // Recipient's enteringCommitStep for the height
+	DelayMs        *float64   `json:"delayMs" bson:"delayMs"`       // ReceivedTime - SentTime, across node clocks
+	LateByMs       *float64   `json:"lateByMs" bson:"lateByMs"`     // ReceivedTime - CommitTime, for late votes
+}
+
+// ThroughputBucket holds the event, vote and block counts of a time bucket.
+type ThroughputBucket struct {
+	Start           time.Time `json:"start"`
+	Events          int64     `json:"events"`
+	VotesSent       int64     `json:"votesSent"`
+	VotesReceived   int64     `json:"votesReceived"`
+	BlocksCommitted int64     `json:"blocksCommitted"` // Heights first committed (by any node) in the bucket
+	EventsPerSecond float64   `json:"eventsPerSecond"`
+	VotesPerSecond  float64   `json:"votesPerSecond"` // Votes sent per second
+	BlocksPerMinute float64   `json:"blocksPerMinute"`
+}
+
+// StepDuration aggregates how long a node stayed in a consensus step.
+type StepDuration struct {
+	NodeID  string  `json:"nodeId"`
+	Step    string  `json:"step"`    // newRound, propose, prevote, prevoteWait, precommit, precommitWait
+	Samples int     `json:"samples"` // Rounds in which the step was followed by another step
+	P50Ms   float64 `json:"p50Ms"`   // 50th percentile time until the next step (ms)
+	P95Ms   float64 `json:"p95Ms"`   // 95th percentile time until the next step (ms)
+}
+
+// JitterPoint is the latency mean and jitter of a pair within a time bucket.
+type JitterPoint struct {
+	Start    time.Time `json:"start" bson:"start"`
+	MeanMs   float64   `json:"meanMs" bson:"meanMs"`
+	StdDevMs float64   `json:"stdDevMs" bson:"stdDevMs"` // 0 for buckets with a single sample
+	Samples  int       `json:"samples" bson:"samples"`
+}
+
+// JitterSeries is the jitter time series of a sender→receiver pair.
+type JitterSeries struct {
+	Sender      string        `json:"sender" bson:"sender"`
+	Receiver    string        `json:"receiver" bson:"receiver"`
+	MaxStdDevMs float64       `json:"maxStdDevMs" bson:"maxStdDevMs"` // Worst bucket jitter, used for topN
+	Points      []JitterPoint `json:"points" bson:"points"`
+}
+
+// VoteLatencyPoint holds the percentiles of the confirmed vote latencies sent within a time bucket.
+type VoteLatencyPoint struct {
+	Start   time.Time `json:"start"`
+	Samples int       `json:"samples"`
+	P50Ms   float64   `json:"p50Ms"`
+	P95Ms   float64   `json:"p95Ms"`
+	P99Ms   float64   `json:"p99Ms"`
+}
+
+// MessageSeries counts the events of one event type or node per time bucket.
+type MessageSeries struct {
+	Label  string     `json:"label" bson:"_id"`     // Event type or node ID, per groupBy
+	Total  int64      `json:"total" bson:"total"`   // Events over all buckets
+	Points [][2]int64 `json:"points" bson:"points"` // [bucket start (Unix ms), events] of non-empty buckets, in time order
+}
+
+// TimeoutCount counts the timeouts of one step and duration within a time bucket.
+type TimeoutCount struct {
+	Step       string   `json:"step"`                 // Step the timeout was scheduled for
+	Duration   string   `json:"duration,omitempty"`   // Timeout duration as logged, empty when absent
+	DurationMs *float64 `json:"durationMs,omitempty"` // Parsed duration, omitted when absent or unparsable
+	Count      int      `json:"count"`
+}
+
+// TimeoutPoint is the number of timeouts a node scheduled within a time bucket.
+type TimeoutPoint struct {
+	Start    time.Time      `json:"start"`
+	Count    int            `json:"count"`
+	Timeouts []TimeoutCount `json:"timeouts"` // By step and duration
+}
+
+// TimeoutSeries is the timeout time series of a node; buckets without timeouts are omitted.
+type TimeoutSeries struct {
+	NodeID string         `json:"nodeId"`
+	Total  int            `json:"total"`
+	Points []TimeoutPoint `json:"points"`
+}
+
+// StepFunnelStage counts the round attempts that reached a consensus step.
+type StepFunnelStage struct {
+	Step       string  `json:"step"`       // round (all attempts), prevote, precommit or commit
+	Count      int     `json:"count"`      // Attempts that reached the step
+	ReachedPct float64 `json:"reachedPct"` // Share of all attempts, in percent
+	DropOffPct float64 `json:"dropOffPct"` // Share of the previous stage that did not reach the step, in percent
+}
+
+// StepFunnel is the step funnel of the (height, round) attempts.
+type StepFunnel struct {
+	Stages           []StepFunnelStage `json:"stages"`
+	MinorityAttempts int               `json:"minorityAttempts"` // Attempts observed by fewer than half of the nodes
+}
+
+// NodeStepFunnel is the step funnel of the attempts a node observed.
+type NodeStepFunnel struct {
+	NodeID           string            `json:"nodeId"`
+	Stages           []StepFunnelStage `json:"stages"`
+	MinorityAttempts int               `json:"minorityAttempts"` // Of its attempts, those observed by fewer than half of the nodes
+}
+
+// MetricsSummaryError names a summary field that could not be computed.
+type MetricsSummaryError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// MetricsSummary holds the headline metrics of a simulation's dashboard. Fields
+// that failed to compute are null and listed in Errors.
+type MetricsSummary struct {
+	MessageSuccessRate *float32              `json:"messageSuccessRate"`
+	VoteLatencyP50Ms   *float64              `json:"voteLatencyP50Ms"`
+	VoteLatencyP95Ms   *float64              `json:"voteLatencyP95Ms"`
+	BlockCount         *int64                `json:"blockCount"`         // Heights committed by any node
+	AvgBlockIntervalMs *float64              `json:"avgBlockIntervalMs"` // Null with fewer than two blocks
+	WorstPair          *PairLatency          `json:"worstPair"`          // Pair with the highest p95 latency
+	TimeoutCount       *int64                `json:"timeoutCount"`       // scheduledTimeout events
+	Errors             []MetricsSummaryError `json:"errors"`
+}
+
+// LatencySpike is a vote delivery whose latency exceeds the spike threshold of
+// its (sender, receiver, vote type) group.
+type LatencySpike struct {
+	Height         uint64    `json:"height"`
+	Round          uint64    `json:"round"`
+	VoteType       string    `json:"voteType"`
+	ValidatorIndex uint64    `json:"validatorIndex"`
+	Sender         string    `json:"sender"`
+	Receiver       string    `json:"receiver"`
+	SentTime       time.Time `json:"sentTime"`
+	ReceivedTime   time.Time `json:"receivedTime"`
+	LatencyMs      float64   `json:"latencyMs"`
+	ThresholdMs    float64   `json:"thresholdMs"` // k × the group's p95 latency
+	MeanMs         float64   `json:"meanMs"`      // Mean latency of the group
+	Sigma          float64   `json:"sigma"`       // Standard deviations above the group mean
+}
+
+// NodeRanking holds the components and composite score of a node's ranking.
+type NodeRanking struct {
+	Rank          int     `json:"rank"` // 1 for the node hurting consensus most
+	NodeID        string  `json:"nodeId"`
+	Score         float64 `json:"score"`         // Weighted sum of the components, each scaled by its maximum over nodes
+	OutboundP95Ms float64 `json:"outboundP95Ms"` // Mean p95 latency of the votes sent to each peer
+	InboundP95Ms  float64 `json:"inboundP95Ms"`  // Mean p95 latency of the votes received from each peer
+	TimeoutCount  int64   `json:"timeoutCount"`
+	VotesSent     int64   `json:"votesSent"`
+	VotesLost     int64   `json:"votesLost"` // Sent votes never received
+	LossRate      float64 `json:"lossRate"`  // votesLost / votesSent
+}
+
+// NetworkPartition is an interval in which the nodes split into groups whose
+// votes did not reach each other.
+type NetworkPartition struct {
+	Start         time.Time  `json:"start"`
+	End           time.Time  `json:"end"`
+	Groups        [][]string `json:"groups"`        // Node IDs of each group
+	AffectedPairs int        `json:"affectedPairs"` // Most broken node pairs in a window
+	Windows       int        `json:"windows"`       // Partitioned windows merged into the interval
+	HeightFrom    uint64     `json:"heightFrom"`    // Lowest height of the votes between groups
+	HeightTo      uint64     `json:"heightTo"`      // Highest height of the votes between groups
+}
+
+// HeightCommitLag is how long after the first node each node entered the commit step of a height.
+type HeightCommitLag struct {
+	Height          int64              `json:"height"`
+	FirstCommitter  string             `json:"firstCommitter"`
+	FirstCommitTime time.Time          `json:"firstCommitTime"`
+	LagMs           map[string]float64 `json:"lagMs"`  // Node ID → lag behind the first committer (ms)
+	Missed          []string           `json:"missed"` // Nodes without a commit event for the height
+}
+
+// NodeCommitLag aggregates a node's commit lag over a height range.
+type NodeCommitLag struct {
+	NodeID        string  `json:"nodeId"`
+	Heights       int     `json:"heights"`       // Heights the node committed
+	MissedHeights int     `json:"missedHeights"` // Heights committed by other nodes only
+	P50LagMs      float64 `json:"p50LagMs"`
+	P95LagMs      float64 `json:"p95LagMs"`
+	MaxLagMs      float64 `json:"maxLagMs"`
+}
+
+// PeerMapping is the node a p2p peer ID was derived to belong to, from the
+// vote events of a simulation.
+type PeerMapping struct {
+	PeerID       string              `json:"peerId" bson:"_id"`
+	NodeID       string              `json:"nodeId" bson:"nodeId"`
+	Confidence   float64             `json:"confidence" bson:"confidence"`     // 0–1, lowered by conflicting evidence
+	Observations int                 `json:"observations" bson:"observations"` // Matched send/receive edges the mapping rests on
+	Alternatives []PeerNodeCandidate `json:"alternatives,omitempty" bson:"alternatives,omitempty"`
+}
+
+// PeerNodeCandidate is a node that part of the evidence mapped a peer ID to
+type PeerNodeCandidate struct {
+	NodeID string  `json:"nodeId" bson:"nodeId"`
+	Share  float64 `json:"share" bson:"share"` // Share of the peer's evidence pointing at the node
+}
+
+// ValidatorMapping is the node a validator index belongs to, derived from the
+// nodes its votes originated at.
+type ValidatorMapping struct {
+	ValidatorIndex   uint64               `json:"validatorIndex" bson:"_id"`
+	NodeID           string               `json:"nodeId" bson:"nodeId"` // Node originating most of the index's votes
+	ValidatorAddress string               `json:"validatorAddress,omitempty" bson:"validatorAddress,omitempty"`
+	Votes            int64                `json:"votes" bson:"votes"`           // Votes of the index originated at NodeID
+	TotalVotes       int64                `json:"totalVotes" bson:"totalVotes"` // Votes of the index originated at any node
+	HeightFrom       uint64               `json:"heightFrom" bson:"heightFrom"` // Heights over which NodeID originated them
+	HeightTo         uint64               `json:"heightTo" bson:"heightTo"`
+	Ambiguous        bool                 `json:"ambiguous" bson:"ambiguous"`             // Originated at more than one node
+	Nodes            []ValidatorNodeVotes `json:"nodes,omitempty" bson:"nodes,omitempty"` // Every originating node, when ambiguous
+}
+
+// ValidatorNodeVotes counts the votes of a validator index originated at a node
+type ValidatorNodeVotes struct {
+	NodeID     string `json:"nodeId" bson:"nodeId"`
+	Votes      int64  `json:"votes" bson:"votes"`
+	HeightFrom uint64 `json:"heightFrom" bson:"heightFrom"`
+	HeightTo   uint64 `json:"heightTo" bson:"heightTo"`
+}
+
+// Incident kinds reported by the incident detection
+const (
+	IncidentExtraRounds  = "extraRounds"  // The height needed rounds past the threshold
+	IncidentSlowCommit   = "slowCommit"   // The height took longer than the threshold to commit
+	IncidentMissedCommit = "missedCommit" // Nodes active around the height never committed it
+	IncidentStalled      = "stalled"      // No node committed the height
+)
+
+// Incident severities
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// IncidentThresholds configure what counts as an incident
+type IncidentThresholds struct {
+	MaxCommitMs float64 `json:"maxCommitMs" bson:"maxCommitMs"` // Longest acceptable time from a height's first round to its commit
+	MinRounds   int64   `json:"minRounds" bson:"minRounds"`     // Lowest round reported as extra rounds
+}
+
+// Incident is something that went wrong at a height. Start and End delimit it
+// in time so a UI can jump to it.
+type Incident struct {
+	ID         string    `json:"id" bson:"_id"` // <height>-<type>
+	Height     int64     `json:"height" bson:"height"`
+	Type       string    `json:"type" bson:"type"`
+	Severity   string    `json:"severity" bson:"severity"`
+	Nodes      []string  `json:"nodes" bson:"nodes"` // Affected nodes
+	Round      int64     `json:"round" bson:"round"` // Highest round entered at the height
+	DurationMs float64   `json:"durationMs" bson:"durationMs"`
+	Start      time.Time `json:"start" bson:"start"`
+	End        time.Time `json:"end" bson:"end"`
+}
+
+// BlockAssembly is how long a node took to assemble the proposal block of a
+// height, from the first block part or proposal it received to its
+// receivedCompleteProposalBlock.
+type BlockAssembly struct {
+	Height       int64      `json:"height" bson:"height"`
+	NodeID       string     `json:"nodeId" bson:"nodeId"`
+	StartTime    *time.Time `json:"startTime" bson:"startTime"`       // Nil when the node received no part or proposal, e.g. as proposer
+	CompleteTime *time.Time `json:"completeTime" bson:"completeTime"` // Nil when the node never completed the block
+	AssemblyMs   *float64   `json:"assemblyMs" bson:"assemblyMs"`     // Nil unless both times are known
+	BlockParts   int        `json:"blockParts" bson:"blockParts"`     // Distinct block parts received
+	PartMessages int        `json:"partMessages" bson:"partMessages"` // Block part messages received, duplicates included
+	Complete     bool       `json:"complete" bson:"complete"`
+}
+
+// NodeBlockAssembly aggregates a node's block assembly times over a height range.
+type NodeBlockAssembly struct {
+	NodeID     string  `json:"nodeId"`
+	Heights    int     `json:"heights"`    // Heights with an assembly time
+	Incomplete int     `json:"incomplete"` // Heights the node started but never completed
+	P50Ms      float64 `json:"p50Ms"`
+	P95Ms      float64 `json:"p95Ms"`
+	MaxMs      float64 `json:"maxMs"`
+}
+
+// NodeHeightFanout is how many distinct peers a node gossiped votes and block
+// parts with at a height.
+type NodeHeightFanout struct {
+	Height    int64  `json:"height" bson:"height"`
+	NodeID    string `json:"nodeId" bson:"nodeId"`
+	OutDegree int    `json:"outDegree" bson:"outDegree"` // Distinct peers sent to
+	InDegree  int    `json:"inDegree" bson:"inDegree"`   // Distinct peers received from
+	Sent      int64  `json:"sent" bson:"sent"`
+	Received  int64  `json:"received" bson:"received"`
+}
+
+// FanoutWindow is a time window in which a node's out- or in-degree dropped below the threshold
+type FanoutWindow struct {
+	Start     time.Time `json:"start"`
+	OutDegree int       `json:"outDegree"`
+	InDegree  int       `json:"inDegree"`
+}
+
+// NodeFanout aggregates a node's gossip fan-out over a range.
+type NodeFanout struct {
+	NodeID        string         `json:"nodeId"`
+	Heights       int            `json:"heights"`
+	OutPeers      int            `json:"outPeers"` // Distinct peers sent to over the range
+	InPeers       int            `json:"inPeers"`  // Distinct peers received from over the range
+	AvgOutDegree  float64        `json:"avgOutDegree"`
+	AvgInDegree   float64        `json:"avgInDegree"`
+	MinOutDegree  int            `json:"minOutDegree"`
+	MinInDegree   int            `json:"minInDegree"`
+	PeakOutDegree int            `json:"peakOutDegree"` // Highest out-degree in a time window
+	PeakInDegree  int            `json:"peakInDegree"`  // Highest in-degree in a time window
+	LowWindows    []FanoutWindow `json:"lowWindows"`
+	Flagged       bool           `json:"flagged"` // Possible partial partition: at least one low window
 }
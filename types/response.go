@@ -4,17 +4,72 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/bft-labs/cometbft-analyzer-types/pkg/events"
+	"github.com/bft-labs/cometbft-analyzer-types/pkg/statistics/latency"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 // EventResponse wraps any consensus event for API responses
 type EventResponse struct {
-	Event any `json:"event"`
+	Event any                 `json:"event"`
+	ID    *primitive.ObjectID `json:"-"` // Document _id, included in the flattened JSON when set
 }
 
 // MarshalJSON implements custom JSON marshaling to flatten the event structure
 func (er EventResponse) MarshalJSON() ([]byte, error) {
-	return json.Marshal(er.Event)
+	data, err := json.Marshal(er.Event)
+	if err != nil || er.ID == nil || len(data) < 2 || data[0] != '{' {
+		return data, err
+	}
+
+	// Prepend the _id field to the event object
+	prefix := `{"_id":"` + er.ID.Hex() + `"`
+	if len(data) > 2 {
+		prefix += ","
+	}
+	return append([]byte(prefix), data[1:]...), nil
+}
+
+// EventDetailResponse wraps a single event with its document _id and timestamp
+type EventDetailResponse struct {
+	ID        string        `json:"id"`
+	Timestamp time.Time     `json:"timestamp"`
+	Event     EventResponse `json:"event"`
+}
+
+// ConsensusEventTypes lists the event types DecodeConsensusEvent decodes into a specific struct
+var ConsensusEventTypes = []string{
+	"p2pVote",
+	"p2pBlockPart",
+	"p2pProposal",
+	"p2pProposalPOL",
+	"p2pNewRoundStep",
+	"p2pHasVote",
+	"p2pVoteSetMaj23",
+	"p2pVoteSetBits",
+	"p2pHasProposalBlockPart",
+	"enteringNewRound",
+	"proposeStep",
+	"enteringPrevoteStep",
+	"enteringPrecommitStep",
+	"enteringPrevoteWaitStep",
+	"enteringPrecommitWaitStep",
+	"enteringCommitStep",
+	"enteringWaitStep",
+	"receivedProposal",
+	"receivedCompleteProposalBlock",
+	"scheduledTimeout",
+}
+
+// IsConsensusEventType reports whether eventType is one of ConsensusEventTypes
+func IsConsensusEventType(eventType string) bool {
+	for _, known := range ConsensusEventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
 }
 
 // DecodeConsensusEvent decodes a MongoDB document into the appropriate event type
@@ -52,48 +107,48 @@ func DecodeConsensusEvent(raw bson.Raw) (any, error) {
 		}
 		return &event, nil
 
-	// TODO: Uncomment and use these when needed from frontend
-	//case "p2pProposalPOL":
-	//	var event events.EventP2pProposalPOL
-	//	if err := bson.Unmarshal(raw, &event); err != nil {
-	//		return nil, fmt.Errorf("failed to decode EventP2pProposalPOL: %w", err)
-	//	}
-	//	return &event, nil
-	//
-	//case "p2pNewRoundStep":
-	//	var event events.EventP2pNewRoundStep
-	//	if err := bson.Unmarshal(raw, &event); err != nil {
-	//		return nil, fmt.Errorf("failed to decode EventP2pNewRoundStep: %w", err)
-	//	}
-	//	return &event, nil
-	//
-	//case "p2pHasVote":
-	//	var event events.EventP2pHasVote
-	//	if err := bson.Unmarshal(raw, &event); err != nil {
-	//		return nil, fmt.Errorf("failed to decode EventP2pHasVote: %w", err)
-	//	}
-	//	return &event, nil
-	//
-	//case "p2pVoteSetMaj23":
-	//	var event events.EventP2pVoteSetMaj23
-	//	if err := bson.Unmarshal(raw, &event); err != nil {
-	//		return nil, fmt.Errorf("failed to decode EventP2pVoteSetMaj23: %w", err)
-	//	}
-	//	return &event, nil
-	//
-	//case "p2pVoteSetBits":
-	//	var event events.EventP2pVoteSetBits
-	//	if err := bson.Unmarshal(raw, &event); err != nil {
-	//		return nil, fmt.Errorf("failed to decode EventP2pVoteSetBits: %w", err)
-	//	}
-	//	return &event, nil
-	//
-	//case "p2pHasProposalBlockPart":
-	//	var event events.EventP2pBlockPart // Reuse EventP2pBlockPart for this
-	//	if err := bson.Unmarshal(raw, &event); err != nil {
-	//		return nil, fmt.Errorf("failed to decode p2pHasProposalBlockPart: %w", err)
-	//	}
-	//	return &event, nil
+	// P2P gossip events, hidden from the events API unless requested
+	case "p2pProposalPOL":
+		var event events.EventP2pProposalPOL
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode EventP2pProposalPOL: %w", err)
+		}
+		return &event, nil
+
+	case "p2pNewRoundStep":
+		var event events.EventP2pNewRoundStep
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode EventP2pNewRoundStep: %w", err)
+		}
+		return &event, nil
+
+	case "p2pHasVote":
+		var event events.EventP2pHasVote
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode EventP2pHasVote: %w", err)
+		}
+		return &event, nil
+
+	case "p2pVoteSetMaj23":
+		var event events.EventP2pVoteSetMaj23
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode EventP2pVoteSetMaj23: %w", err)
+		}
+		return &event, nil
+
+	case "p2pVoteSetBits":
+		var event events.EventP2pVoteSetBits
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode EventP2pVoteSetBits: %w", err)
+		}
+		return &event, nil
+
+	case "p2pHasProposalBlockPart":
+		var event events.EventP2pBlockPart // Reuse EventP2pBlockPart for this
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode p2pHasProposalBlockPart: %w", err)
+		}
+		return &event, nil
 
 	// Consensus step events
 	case "enteringNewRound":
@@ -223,5 +278,251 @@ type CursorPaginationMeta struct {
 	HasPrevious    bool    `json:"hasPrevious"`
 	NextCursor     *string `json:"nextCursor"`
 	PreviousCursor *string `json:"previousCursor"`
-	TotalCount     *int    `json:"totalCount"` // Optional, expensive to calculate
+	TotalCount     *int    `json:"totalCount"`               // Optional, expensive to calculate
+	TotalSegments  *int    `json:"totalSegments,omitempty"`  // Only for segment-based pagination
+	CurrentSegment *int    `json:"currentSegment,omitempty"` // Only for segment-based pagination
+}
+
+// OffsetPaginationMeta contains offset-based pagination metadata
+type OffsetPaginationMeta struct {
+	Offset int   `json:"offset"` // Items skipped
+	Limit  int   `json:"limit"`  // Maximum items returned
+	Total  int64 `json:"total"`  // Total number of items
+}
+
+// PaginatedNodeNetworkStatsResponse wraps network latency node stats with pagination metadata
+type PaginatedNodeNetworkStatsResponse struct {
+	Data       []latency.NodeNetworkStats `json:"data"`
+	Pagination OffsetPaginationMeta       `json:"pagination"`
+}
+
+// EventStreamControl is a control message sent by clients of the event stream
+type EventStreamControl struct {
+	Action     string     `json:"action"`               // "seek", "setSpeed", "pause" or "resume"
+	Timestamp  *time.Time `json:"timestamp,omitempty"`  // Position to seek to
+	Multiplier float64    `json:"multiplier,omitempty"` // Playback speed for setSpeed, 2 plays twice as fast
+}
+
+// EventStreamMessage is a message sent to clients of the event stream
+type EventStreamMessage struct {
+	Type      string         `json:"type"` // "event", "end" or "error"
+	Timestamp *time.Time     `json:"timestamp,omitempty"`
+	Event     *EventResponse `json:"event,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// EventCountSummary counts events and records the time span they cover
+type EventCountSummary struct {
+	Count          int64     `json:"count"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+}
+
+// EventTypeSummary summarizes the events of one type, optionally broken down by node
+type EventTypeSummary struct {
+	EventCountSummary
+	Nodes map[string]EventCountSummary `json:"nodes,omitempty"`
+}
+
+// HeightInfo describes a block height observed in a simulation
+type HeightInfo struct {
+	Height         int64     `json:"height" bson:"height"`
+	FirstTimestamp time.Time `json:"firstTimestamp" bson:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp" bson:"lastTimestamp"`
+	Rounds         int       `json:"rounds" bson:"rounds"`         // Number of distinct rounds observed
+	EventCount     int       `json:"eventCount" bson:"eventCount"` // enteringNewRound and enteringCommitStep events
+}
+
+// PaginatedUsersResponse wraps users with pagination metadata. Data holds
+// []User, or objects with only the selected fields when fields are selected.
+type PaginatedUsersResponse struct {
+	Data       any            `json:"data"`
+	Pagination PaginationMeta `json:"pagination"`
+}
+
+// PaginatedHeightsResponse wraps heights with pagination metadata
+type PaginatedHeightsResponse struct {
+	Data       []HeightInfo   `json:"data"`
+	Pagination PaginationMeta `json:"pagination"`
+}
+
+// PaginatedIncidentsResponse wraps incidents with the thresholds they were detected with and pagination metadata
+type PaginatedIncidentsResponse struct {
+	Data       []Incident         `json:"data"`
+	Thresholds IncidentThresholds `json:"thresholds"`
+	Pagination PaginationMeta     `json:"pagination"`
+}
+
+// PaginatedBlockAssemblyResponse wraps block assembly times with pagination metadata
+type PaginatedBlockAssemblyResponse struct {
+	Data       []BlockAssembly `json:"data"`
+	Pagination PaginationMeta  `json:"pagination"`
+}
+
+// PaginatedRoundDurationsResponse wraps round durations with pagination metadata
+type PaginatedRoundDurationsResponse struct {
+	Data       []RoundDuration `json:"data"`
+	Pagination PaginationMeta  `json:"pagination"`
+}
+
+// PaginatedRoundDurationSummaryResponse wraps per-height round duration percentiles with pagination metadata
+type PaginatedRoundDurationSummaryResponse struct {
+	Data       []RoundDurationSummary `json:"data"`
+	Pagination PaginationMeta         `json:"pagination"`
+}
+
+// VoteParticipationResponse holds a page of per-height vote participation and
+// the per-validator participation over the whole requested range
+type VoteParticipationResponse struct {
+	ValidatorCount int                       `json:"validatorCount"` // Highest observed validator index + 1
+	Validators     []ValidatorParticipation  `json:"validators"`
+	Data           []HeightVoteParticipation `json:"data"`
+	Pagination     PaginationMeta            `json:"pagination"`
+}
+
+// PaginatedVoteAnomaliesResponse wraps vote anomalies with pagination metadata
+type PaginatedVoteAnomaliesResponse struct {
+	Data       []VoteAnomaly  `json:"data"`
+	Pagination PaginationMeta `json:"pagination"`
+}
+
+// PaginatedLatencySpikesResponse wraps latency spikes with pagination metadata
+type PaginatedLatencySpikesResponse struct {
+	Data       []LatencySpike `json:"data"`
+	Pagination PaginationMeta `json:"pagination"`
+	Truncated  bool           `json:"truncated,omitempty"` // Only the most severe spikes can be paged
+}
+
+// NetworkPartitionsResponse lists the network partitions detected with the given window and threshold
+type NetworkPartitionsResponse struct {
+	Window     string             `json:"window"`
+	Threshold  float64            `json:"threshold"`
+	Partitions []NetworkPartition `json:"partitions"`
+}
+
+// PaginatedFanoutResponse wraps per-height gossip fan-out with pagination metadata
+type PaginatedFanoutResponse struct {
+	Data       []NodeHeightFanout `json:"data"`
+	Pagination PaginationMeta     `json:"pagination"`
+}
+
+// NodeFanoutResponse lists the per-node gossip fan-out with the window and threshold low windows were detected with
+type NodeFanoutResponse struct {
+	Window    string       `json:"window"`
+	Threshold float64      `json:"threshold"`
+	Nodes     []NodeFanout `json:"nodes"`
+}
+
+// CommitLagResponse holds a page of per-height commit lags and the per-node lag
+// over the whole requested range
+type CommitLagResponse struct {
+	Nodes      []NodeCommitLag   `json:"nodes"`
+	Data       []HeightCommitLag `json:"data"`
+	Pagination PaginationMeta    `json:"pagination"`
+}
+
+// ThroughputResponse is a throughput time series at the given resolution
+type ThroughputResponse struct {
+	Resolution string             `json:"resolution"`
+	Buckets    []ThroughputBucket `json:"buckets"`
+}
+
+// JitterTimeSeriesResponse holds per-pair jitter series at the given resolution
+type JitterTimeSeriesResponse struct {
+	Resolution string         `json:"resolution"`
+	Pairs      []JitterSeries `json:"pairs"`
+}
+
+// VoteLatencyTimeSeriesResponse holds the vote latency percentiles per time bucket
+type VoteLatencyTimeSeriesResponse struct {
+	Resolution string             `json:"resolution"` // Resolution used, coarser than requested when the range needs too many buckets
+	Points     []VoteLatencyPoint `json:"points"`
+}
+
+// MessageTimeSeriesResponse holds event count series at the given resolution
+type MessageTimeSeriesResponse struct {
+	Resolution string          `json:"resolution"`
+	GroupBy    string          `json:"groupBy"` // type or node
+	Series     []MessageSeries `json:"series"`
+}
+
+// DeliveryTimeSeriesResponse holds per-pair vote delivery series at the given resolution
+type DeliveryTimeSeriesResponse struct {
+	Resolution string           `json:"resolution"`
+	Pairs      []DeliverySeries `json:"pairs"`
+}
+
+// TimeoutTimeSeriesResponse holds per-node timeout series at the given resolution
+type TimeoutTimeSeriesResponse struct {
+	Resolution string          `json:"resolution"`
+	Nodes      []TimeoutSeries `json:"nodes"`
+}
+
+// StepFunnelResponse holds the cluster-wide and per-node step funnels
+type StepFunnelResponse struct {
+	Cluster StepFunnel       `json:"cluster"`
+	Nodes   []NodeStepFunnel `json:"nodes"`
+}
+
+// HeightTimeline describes the rounds of a block height as seen by each node
+type HeightTimeline struct {
+	Height int64           `json:"height"`
+	Rounds []RoundTimeline `json:"rounds"`
+}
+
+// RoundTimeline holds the per-node timelines of a round
+type RoundTimeline struct {
+	Round int64               `json:"round"`
+	Nodes []NodeRoundTimeline `json:"nodes"`
+}
+
+// NodeRoundTimeline holds when a node entered each step of a round and its vote traffic
+type NodeRoundTimeline struct {
+	NodeID        string               `json:"nodeId"`
+	Steps         map[string]time.Time `json:"steps"` // newRound, propose, prevote, prevoteWait, precommit, precommitWait, commit
+	VotesSent     int                  `json:"votesSent"`
+	VotesReceived int                  `json:"votesReceived"`
+}
+
+// BucketedEventsResponse is returned instead of raw events when a resolution is requested
+type BucketedEventsResponse struct {
+	Resolution string        `json:"resolution"`
+	Buckets    []EventBucket `json:"buckets"`
+}
+
+// EventBucket aggregates the events of one time bucket by type
+type EventBucket struct {
+	Start       time.Time                `json:"start"`
+	Counts      map[string]int64         `json:"counts"`
+	FirstEvents map[string]EventResponse `json:"firstEvents"` // Earliest event of each type in the bucket
+}
+
+// ReadinessCheck reports the outcome of a single readiness check
+type ReadinessCheck struct {
+	Status string `json:"status"` // "ok" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessResponse reports whether the service can serve requests, with every check
+type ReadinessResponse struct {
+	Status string                    `json:"status"` // "ready" or "unavailable"
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// VersionResponse identifies the running build and the versions of its dependencies
+type VersionResponse struct {
+	Version        string         `json:"version"`
+	Commit         string         `json:"commit"`
+	BuildTime      string         `json:"buildTime,omitempty"`
+	GoVersion      string         `json:"goVersion"`
+	MongoDBVersion string         `json:"mongodbVersion,omitempty"` // Omitted when it could not be detected
+	ETL            ETLVersionInfo `json:"etl"`
+}
+
+// ETLVersionInfo describes the cometbft-log-etl binary found at startup
+type ETLVersionInfo struct {
+	Found   bool   `json:"found"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"` // Output of --version
+	Error   string `json:"error,omitempty"`
 }
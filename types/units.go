@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// Latencies are stored in nanoseconds (the latency field of vote_latencies)
+// and reported in milliseconds; differences of BSON dates are already in
+// milliseconds. Conversions happen when the response is built.
+
+// NanosToMs converts a value in nanoseconds to milliseconds
+func NanosToMs(ns float64) float64 {
+	return ns / float64(time.Millisecond)
+}
+
+// DurationMs returns d in milliseconds
+func DurationMs(d time.Duration) float64 {
+	return NanosToMs(float64(d))
+}
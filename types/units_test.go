@@ -0,0 +1,34 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMillisecondConversions(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		want     float64
+	}{
+		{name: "zero", duration: 0, want: 0},
+		{name: "one nanosecond", duration: time.Nanosecond, want: 0.000001},
+		{name: "sub-millisecond", duration: 250 * time.Microsecond, want: 0.25},
+		{name: "one millisecond", duration: time.Millisecond, want: 1},
+		{name: "fractional milliseconds", duration: 1500 * time.Microsecond, want: 1.5},
+		{name: "seconds", duration: 2*time.Second + 3*time.Millisecond, want: 2003},
+		{name: "negative sub-millisecond", duration: -500 * time.Microsecond, want: -0.5},
+		{name: "negative", duration: -3 * time.Second, want: -3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DurationMs(tt.duration); got != tt.want {
+				t.Errorf("DurationMs(%s) = %v, want %v", tt.duration, got, tt.want)
+			}
+			if got := NanosToMs(float64(tt.duration.Nanoseconds())); got != tt.want {
+				t.Errorf("NanosToMs(%d) = %v, want %v", tt.duration.Nanoseconds(), got, tt.want)
+			}
+		})
+	}
+}
@@ -41,4 +41,6 @@ type VoteStatisticsResponse struct {
 	P99       float64 `json:"p99"`
 	Max       float64 `json:"max"`
 	SpikePerc float64 `json:"spikePerc"`
+
+	Percentiles map[string]float64 `json:"percentiles,omitempty"` // Requested quantile → latency (ms)
 }
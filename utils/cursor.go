@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned for pagination cursors that cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EventCursor is a position in the event timeline. Events are ordered by
+// (timestamp, _id) so that events sharing a timestamp are paged exactly once.
+type EventCursor struct {
+	Timestamp  time.Time
+	ID         primitive.ObjectID // Zero for legacy timestamp-only cursors
+	Descending bool               // Whether the cursor was generated for newest-first pages
+}
+
+type eventCursorToken struct {
+	Timestamp  time.Time `json:"t"`
+	ID         string    `json:"id"`
+	Descending bool      `json:"d,omitempty"`
+}
+
+// Encode returns the cursor as an opaque URL-safe token
+func (c EventCursor) Encode() string {
+	data, _ := json.Marshal(eventCursorToken{Timestamp: c.Timestamp, ID: c.ID.Hex(), Descending: c.Descending})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseEventCursor decodes a cursor token. Plain RFC3339 timestamps, the
+// cursor format of earlier releases, are still accepted as ascending cursors.
+// TODO: drop legacy timestamp cursors in the next release.
+func ParseEventCursor(value string) (EventCursor, error) {
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return EventCursor{Timestamp: ts}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return EventCursor{}, ErrInvalidCursor
+	}
+	var token eventCursorToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return EventCursor{}, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(token.ID)
+	if err != nil {
+		return EventCursor{}, ErrInvalidCursor
+	}
+	return EventCursor{Timestamp: token.Timestamp, ID: id, Descending: token.Descending}, nil
+}
+
+// After returns a filter matching events after the cursor, comparing the
+// (timestamp, _id) tuple. Legacy cursors compare the timestamp only.
+func (c EventCursor) After() bson.M {
+	if c.ID.IsZero() {
+		return bson.M{"timestamp": bson.M{"$gt": c.Timestamp}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"timestamp": bson.M{"$gt": c.Timestamp}},
+		bson.M{"timestamp": c.Timestamp, "_id": bson.M{"$gt": c.ID}},
+	}}
+}
+
+// Before returns a filter matching events before the cursor
+func (c EventCursor) Before() bson.M {
+	if c.ID.IsZero() {
+		return bson.M{"timestamp": bson.M{"$lt": c.Timestamp}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"timestamp": bson.M{"$lt": c.Timestamp}},
+		bson.M{"timestamp": c.Timestamp, "_id": bson.M{"$lt": c.ID}},
+	}}
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEventCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []EventCursor{
+		{Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), ID: id},
+		{Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 123456789, time.UTC), ID: id, Descending: true},
+		{Timestamp: time.Date(2025, 3, 1, 14, 0, 0, 1, time.FixedZone("CEST", 2*60*60)), ID: id},
+	}
+
+	for _, cursor := range tests {
+		token := cursor.Encode()
+		if strings.ContainsAny(token, "+/=") {
+			t.Errorf("token %q is not URL safe", token)
+		}
+		parsed, err := ParseEventCursor(token)
+		if err != nil {
+			t.Fatalf("ParseEventCursor(%q): %v", token, err)
+		}
+		if !parsed.Timestamp.Equal(cursor.Timestamp) || parsed.ID != cursor.ID || parsed.Descending != cursor.Descending {
+			t.Errorf("round trip of %+v = %+v", cursor, parsed)
+		}
+	}
+}
+
+func TestEventCursorKeepsOrder(t *testing.T) {
+	cursor := EventCursor{Timestamp: time.Now().UTC(), ID: primitive.NewObjectID()}
+
+	ascending, err := ParseEventCursor(cursor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor.Descending = true
+	descending, err := ParseEventCursor(cursor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers reject cursors whose order differs from the requested one
+	if ascending.Descending || !descending.Descending {
+		t.Fatalf("order not preserved: ascending=%v descending=%v", ascending.Descending, descending.Descending)
+	}
+}
+
+func TestParseLegacyTimestampCursor(t *testing.T) {
+	parsed, err := ParseEventCursor("2025-03-01T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if !parsed.Timestamp.Equal(want) || !parsed.ID.IsZero() || parsed.Descending {
+		t.Fatalf("legacy cursor = %+v, want an ascending timestamp-only cursor at %s", parsed, want)
+	}
+}
+
+func TestParseEventCursorRejectsMalformedTokens(t *testing.T) {
+	encode := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+	valid := EventCursor{Timestamp: time.Now().UTC(), ID: primitive.NewObjectID()}.Encode()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "not base64", token: "not a cursor!"},
+		{name: "padding", token: valid + "="},
+		{name: "standard base64 alphabet", token: strings.NewReplacer("-", "+", "_", "/").Replace(valid) + "+/"},
+		{name: "truncated", token: valid[:len(valid)/2]},
+		{name: "not json", token: encode("timestamp=2025")},
+		{name: "json array", token: encode(`["2025-03-01T12:00:00Z"]`)},
+		{name: "wrong timestamp type", token: encode(`{"t":1740830400,"id":"0123456789abcdef01234567"}`)},
+		{name: "invalid timestamp", token: encode(`{"t":"yesterday","id":"0123456789abcdef01234567"}`)},
+		{name: "missing id", token: encode(`{"t":"2025-03-01T12:00:00Z"}`)},
+		{name: "short id", token: encode(`{"t":"2025-03-01T12:00:00Z","id":"0123"}`)},
+		{name: "non-hex id", token: encode(`{"t":"2025-03-01T12:00:00Z","id":"zzzzzzzzzzzzzzzzzzzzzzzz"}`)},
+		{name: "wrong order type", token: encode(`{"t":"2025-03-01T12:00:00Z","id":"0123456789abcdef01234567","d":"desc"}`)},
+		{name: "non-RFC3339 timestamp", token: "2025-03-01 12:00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEventCursor(tt.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("ParseEventCursor(%q) error = %v, want %v", tt.token, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestParseEventCursorDetectsTampering(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("65e1c2a0f1e2d3c4b5a69788")
+	for _, nanos := range []int{0, 1, 12, 123, 1234, 12345, 123456, 1234567} {
+		// The timestamp precision varies the token length, and with it the unused trailing bits
+		token := EventCursor{Timestamp: time.Date(2025, 3, 1, 12, 0, 0, nanos, time.UTC), ID: id}.Encode()
+		assertTamperDetected(t, token)
+	}
+}
+
+// assertTamperDetected checks that changing any single character of token
+// either fails to parse or yields a different cursor
+func assertTamperDetected(t *testing.T, token string) {
+	t.Helper()
+	original, err := ParseEventCursor(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range token {
+		replacement := byte('A')
+		if token[i] == 'A' {
+			replacement = 'B'
+		}
+		tampered := token[:i] + string(replacement) + token[i+1:]
+		parsed, err := ParseEventCursor(tampered)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("tampered token %q: error = %v, want %v", tampered, err, ErrInvalidCursor)
+			}
+			continue
+		}
+		if parsed == original {
+			t.Fatalf("tampered token %q parsed to the original cursor", tampered)
+		}
+	}
+}
+
+func TestEventCursorFilters(t *testing.T) {
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	id := primitive.NewObjectID()
+
+	tuple := EventCursor{Timestamp: ts, ID: id}
+	wantAfter := bson.M{"$or": bson.A{
+		bson.M{"timestamp": bson.M{"$gt": ts}},
+		bson.M{"timestamp": ts, "_id": bson.M{"$gt": id}},
+	}}
+	if got := tuple.After(); !reflect.DeepEqual(got, wantAfter) {
+		t.Errorf("After() = %v, want %v", got, wantAfter)
+	}
+	wantBefore := bson.M{"$or": bson.A{
+		bson.M{"timestamp": bson.M{"$lt": ts}},
+		bson.M{"timestamp": ts, "_id": bson.M{"$lt": id}},
+	}}
+	if got := tuple.Before(); !reflect.DeepEqual(got, wantBefore) {
+		t.Errorf("Before() = %v, want %v", got, wantBefore)
+	}
+
+	legacy := EventCursor{Timestamp: ts}
+	if got, want := legacy.After(), (bson.M{"timestamp": bson.M{"$gt": ts}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("legacy After() = %v, want %v", got, want)
+	}
+	if got, want := legacy.Before(), (bson.M{"timestamp": bson.M{"$lt": ts}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("legacy Before() = %v, want %v", got, want)
+	}
+}
@@ -1,40 +1,62 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
+	"path"
 
+	"github.com/bft-labs/cometbft-analyzer-backend/storage"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// GetSimulationDir returns the directory path for a specific simulation
-func GetSimulationDir(userID, projectID, simulationID primitive.ObjectID) string {
-	return filepath.Join("uploads",
-		fmt.Sprintf("user_%s", userID.Hex()),
-		fmt.Sprintf("project_%s", projectID.Hex()),
-		fmt.Sprintf("simulation_%s", simulationID.Hex()))
+// GetUserKey returns the storage key prefix of all files of a user
+func GetUserKey(userID primitive.ObjectID) string {
+	return fmt.Sprintf("user_%s", userID.Hex())
 }
 
-// EnsureSimulationDir creates the simulation directory if it doesn't exist
-func EnsureSimulationDir(userID, projectID, simulationID primitive.ObjectID) (string, error) {
-	dir := GetSimulationDir(userID, projectID, simulationID)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// GetProjectKey returns the storage key prefix of all files of a project
+func GetProjectKey(userID, projectID primitive.ObjectID) string {
+	return path.Join(GetUserKey(userID), fmt.Sprintf("project_%s", projectID.Hex()))
+}
+
+// GetSimulationKey returns the storage key prefix for a specific simulation
+func GetSimulationKey(userID, projectID, simulationID primitive.ObjectID) string {
+	return path.Join(GetProjectKey(userID, projectID), fmt.Sprintf("simulation_%s", simulationID.Hex()))
+}
+
+// EnsureSimulationDir prepares the storage location of a simulation and returns its key prefix
+func EnsureSimulationDir(store storage.Storage, userID, projectID, simulationID primitive.ObjectID) (string, error) {
+	prefix := GetSimulationKey(userID, projectID, simulationID)
+	if err := store.EnsureDir(context.Background(), prefix); err != nil {
 		return "", fmt.Errorf("failed to create simulation directory: %w", err)
 	}
-	return dir, nil
+	return prefix, nil
 }
 
-// GetProcessedDir returns the processed files directory for a simulation
+// GetProcessedDir returns the processed files key prefix for a simulation
 func GetProcessedDir(userID, projectID, simulationID primitive.ObjectID) string {
-	return filepath.Join(GetSimulationDir(userID, projectID, simulationID), "processed")
+	return path.Join(GetSimulationKey(userID, projectID, simulationID), "processed")
 }
 
-// EnsureProcessedDir creates the processed directory if it doesn't exist
-func EnsureProcessedDir(userID, projectID, simulationID primitive.ObjectID) (string, error) {
-	dir := GetProcessedDir(userID, projectID, simulationID)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// EnsureProcessedDir prepares the processed files location of a simulation
+func EnsureProcessedDir(store storage.Storage, userID, projectID, simulationID primitive.ObjectID) (string, error) {
+	prefix := GetProcessedDir(userID, projectID, simulationID)
+	if err := store.EnsureDir(context.Background(), prefix); err != nil {
 		return "", fmt.Errorf("failed to create processed directory: %w", err)
 	}
-	return dir, nil
+	return prefix, nil
+}
+
+// CheckWritableDir creates dir if needed and checks that files can be created in it
+func CheckWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".writable-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
 }
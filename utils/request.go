@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxPercentiles limits the number of quantiles accepted by PercentilesFromContext
+const maxPercentiles = 20
+
+// maxSearchLength limits the length of the 'q' search param
+const maxSearchLength = 200
+
+// IsMultipartForm reports whether the request body is multipart/form-data.
+// Missing, short or malformed Content-Type headers are treated as non-multipart.
+func IsMultipartForm(c *gin.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// PercentilesFromContext parses the optional comma separated 'percentiles' query
+// param, e.g. 0.5,0.9,0.999. Every value must lie strictly between 0 and 1.
+// Returns nil when the param is absent.
+func PercentilesFromContext(c *gin.Context) ([]float64, error) {
+	percentilesStr := c.Query("percentiles")
+	if percentilesStr == "" {
+		return nil, nil
+	}
+
+	var percentiles []float64
+	for _, valueStr := range strings.Split(percentilesStr, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(valueStr), 64)
+		if err != nil || !(value > 0 && value < 1) {
+			return nil, fmt.Errorf("invalid percentiles: %q must be a number between 0 and 1 (exclusive)", valueStr)
+		}
+		percentiles = append(percentiles, value)
+	}
+	if len(percentiles) > maxPercentiles {
+		return nil, fmt.Errorf("invalid percentiles: at most %d values are allowed", maxPercentiles)
+	}
+	return percentiles, nil
+}
+
+// SearchFromContext returns the trimmed 'q' query param, empty when absent
+func SearchFromContext(c *gin.Context) (string, error) {
+	q := strings.TrimSpace(c.Query("q"))
+	if len(q) > maxSearchLength {
+		return "", fmt.Errorf("invalid q: at most %d characters are allowed", maxSearchLength)
+	}
+	return q, nil
+}
+
+// SearchFilter matches documents where any of fields contains q, case-insensitively
+func SearchFilter(q string, fields ...string) bson.M {
+	pattern := regexp.QuoteMeta(q)
+	matches := make(bson.A, len(fields))
+	for i, field := range fields {
+		matches[i] = bson.M{field: bson.M{"$regex": pattern, "$options": "i"}}
+	}
+	return bson.M{"$or": matches}
+}
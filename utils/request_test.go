@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newContext returns a gin context for a GET of target with the given headers
+func newContext(target string, headers map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for name, value := range headers {
+		c.Request.Header.Set(name, value)
+	}
+	return c
+}
+
+func TestIsMultipartForm(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        bool
+	}{
+		{name: "bare", contentType: "multipart/form-data", want: true},
+		{name: "boundary parameter", contentType: "multipart/form-data; boundary=----WebKitFormBoundary7MA4YWxk", want: true},
+		{name: "quoted boundary", contentType: `multipart/form-data; boundary="a b"`, want: true},
+		{name: "mixed case", contentType: "Multipart/Form-Data; Boundary=xyz", want: true},
+		{name: "surrounding whitespace", contentType: "  multipart/form-data ; boundary=xyz", want: true},
+		{name: "multipart/mixed", contentType: "multipart/mixed; boundary=xyz", want: false},
+		{name: "json", contentType: "application/json", want: false},
+		{name: "prefix only", contentType: "multipart/form-data-extended", want: false},
+		{name: "missing", contentType: "", want: false},
+		{name: "malformed parameter", contentType: "multipart/form-data; boundary", want: false},
+		{name: "malformed media type", contentType: "multipart/", want: false},
+		{name: "garbage", contentType: ";;;", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newContext("/", map[string]string{"Content-Type": tt.contentType})
+			if got := IsMultipartForm(c); got != tt.want {
+				t.Errorf("IsMultipartForm(%q) = %v, want %v", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"fmt"
+	"github.com/bft-labs/cometbft-analyzer-backend/types"
 	"github.com/gin-gonic/gin"
+	"strconv"
 	"time"
 )
 
@@ -35,3 +38,25 @@ func TimeWindowFromContext(c *gin.Context) (from time.Time, to time.Time, err er
 	}
 	return
 }
+
+// HeightRangeFromContext extracts the optional 'heightFrom' and 'heightTo' block height query params.
+func HeightRangeFromContext(c *gin.Context) (heights types.HeightRange, err error) {
+	if fromStr := c.Query("heightFrom"); fromStr != "" {
+		from, parseErr := strconv.ParseInt(fromStr, 10, 64)
+		if parseErr != nil || from < 0 {
+			return heights, fmt.Errorf("invalid heightFrom")
+		}
+		heights.From = &from
+	}
+	if toStr := c.Query("heightTo"); toStr != "" {
+		to, parseErr := strconv.ParseInt(toStr, 10, 64)
+		if parseErr != nil || to < 0 {
+			return heights, fmt.Errorf("invalid heightTo")
+		}
+		heights.To = &to
+	}
+	if heights.From != nil && heights.To != nil && *heights.From > *heights.To {
+		return heights, fmt.Errorf("heightFrom must not be greater than heightTo")
+	}
+	return heights, nil
+}